@@ -2,24 +2,104 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/nickalie/nship/internal/config"
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/infrastructure/ssh"
 	"github.com/nickalie/nship/internal/platform/cli"
+	"github.com/nickalie/nship/internal/util"
 )
 
 var revision = "latest"
 
+const (
+	// commandValidate is the subcommand that only loads and validates the configuration
+	commandValidate = "validate"
+	// commandDump is the subcommand that prints the fully resolved configuration
+	commandDump = "dump"
+	// commandClearCache is the subcommand that removes the stored hashes of executed steps
+	commandClearCache = "clear-cache"
+	// commandInit is the subcommand that writes a starter configuration
+	commandInit = "init"
+)
+
+const (
+	// hashStorageLocal keeps step hashes in the local hash directory
+	hashStorageLocal = "local"
+	// hashStorageRemote keeps step hashes in a state file on each target
+	hashStorageRemote = "remote"
+)
+
+const (
+	// exitFailure is the exit code of a run that failed, including a deployment in which every job run failed
+	exitFailure = 1
+	// exitPartialFailure is the exit code of a deployment with --keep-going in which only some job runs failed
+	exitPartialFailure = 2
+	// exitInterrupted is the exit code of a deployment cancelled with Ctrl-C or SIGTERM
+	exitInterrupted = 130
+)
+
+// cleanupTimeout is how long an interrupted deployment may take to run its cleanup hooks before nship exits anyway
+const cleanupTimeout = 30 * time.Second
+
+// subcommands lists the commands that can be given as the first argument
+var subcommands = map[string]bool{
+	commandValidate:   true,
+	commandDump:       true,
+	commandClearCache: true,
+	commandInit:       true,
+}
+
 // Application encapsulates the nship CLI application
 type Application struct {
+	command       string
 	configPath    string
-	jobName       string
+	profile       string
+	template      bool
+	jobNames      []string
+	targetName    string
 	envPaths      []string
+	envVars       map[string]string
+	envOverride   bool
 	vaultPassword string
+	vaultPassFile string
 	noSkip        bool
+	forceJobs     []string
+	noPreflight   bool
+	preflight     bool
+	keepGoing     bool
+	targetOrder   string
+	logDir        string
+	quiet         bool
+	forceUnlock   bool
+	timeout       time.Duration
+	maxUploadRate int64
+	mergeOutput   bool
+	timestamps    bool
+	debugSSH      bool
+	maxReconnects int
+	defaultPort   int
+	hashStorage   string
+	stateDir      string
+	stateFile     string
+	interactive   bool
+	assumeYes     bool
+	format        string
+	redact        bool
+	force         bool
+	noColor       bool
+	printEnv      bool
 	version       bool
 	versionString string
 	// Internal field to store default config paths
@@ -30,17 +110,33 @@ type Application struct {
 func NewApplication() *Application {
 	return &Application{
 		configPath:         "nship.yaml",
+		maxReconnects:      job.DefaultMaxReconnects,
+		hashStorage:        hashStorageLocal,
+		stateDir:           os.Getenv("NSHIP_STATE_DIR"),
+		format:             "yaml",
 		versionString:      revision,
 		defaultConfigPaths: []string{"nship.yaml", "nship.yml"},
 	}
 }
 
 // ParseFlags parses the command-line flags and updates the Application fields accordingly.
-// It sets the configuration file path, job name, environment file paths, vault password,
-// verbosity, and version flag based on the provided command-line arguments.
+// It sets the subcommand, configuration file path, job name, environment file paths,
+// vault password, verbosity, and version flag based on the provided command-line arguments.
 func (app *Application) ParseFlags() {
+	args := os.Args[1:]
+	if len(args) > 0 && subcommands[args[0]] {
+		app.command = args[0]
+		args = args[1:]
+	}
+
 	flag.StringVar(&app.configPath, "config", app.configPath, "Path to configuration file")
-	flag.StringVar(&app.jobName, "job", app.jobName, "Name of specific job to run")
+	flag.StringVar(&app.profile, "profile", app.profile, "Name of the config profile to apply")
+	flag.BoolVar(&app.template, "template", app.template, "Render config files as Go templates before parsing them")
+	flag.Func("job", "Name of a job to run, or a glob pattern such as deploy-* (can be specified multiple times)", func(value string) error {
+		app.jobNames = append(app.jobNames, value)
+		return nil
+	})
+	flag.StringVar(&app.targetName, "target", app.targetName, "Name of the target whose cache the clear-cache command removes")
 
 	// Use only a callback function to process each env-file flag
 	flag.Func("env-file", "Path to environment file (can be specified multiple times)", func(value string) error {
@@ -51,64 +147,454 @@ func (app *Application) ParseFlags() {
 		return nil
 	})
 
+	flag.Func("env", "Environment variable as KEY=VALUE, overriding env files (can be specified multiple times)", app.addEnvVar)
+	flag.BoolVar(&app.envOverride, "env-override", app.envOverride, "Let env files override the variables nship was started with")
 	flag.StringVar(&app.vaultPassword, "vault-password", app.vaultPassword, "Password for Ansible Vault file")
+	flag.StringVar(&app.vaultPassFile, "vault-password-file", app.vaultPassFile, "Path to a file containing the Ansible Vault password")
 	flag.BoolVar(&app.noSkip, "no-skip", app.noSkip, "Disable skipping unchanged steps")
+	flag.Func("force-job", "Run all steps of a job even when unchanged, or of the jobs matching a glob (can be specified multiple times)",
+		func(value string) error {
+			app.forceJobs = append(app.forceJobs, value)
+			return nil
+		})
+	flag.BoolVar(&app.preflight, "preflight", app.preflight, "Also check that every target can be reached before any job starts")
+	flag.BoolVar(&app.noPreflight, "no-preflight", app.noPreflight, "Skip the checks run before any job starts")
+	flag.BoolVar(&app.keepGoing, "keep-going", app.keepGoing, "Run the remaining jobs and targets after a job failed")
+	flag.StringVar(&app.targetOrder, "target-order", app.targetOrder,
+		"Order of the targets: file (default), reverse, sorted or a comma-separated list of all target names")
+	flag.DurationVar(&app.timeout, "timeout", app.timeout, "Stop the deployment when it takes longer than this, e.g. 15m (default no limit)")
+	flag.BoolVar(&app.interactive, "interactive", app.interactive, "Prompt for confirmation before running jobs on targets that require it")
+	flag.BoolVar(&app.assumeYes, "yes", app.assumeYes || envAssumeYes(), "Answer all prompts with yes (also NSHIP_ASSUME_YES)")
+	flag.Func("max-upload-rate", "Maximum upload rate in bytes per second, e.g. 512K or 10M", func(value string) error {
+		rate, err := util.ParseSize(value)
+		if err != nil {
+			return err
+		}
+		app.maxUploadRate = rate
+		return nil
+	})
+	flag.BoolVar(&app.forceUnlock, "force-unlock", app.forceUnlock, "Remove the deploy locks of targets held by other deployments")
+	flag.StringVar(&app.logDir, "log-dir", app.logDir, "Also write the output of each target to <log-dir>/<target>.log")
+	flag.BoolVar(&app.quiet, "quiet", app.quiet, "Don't print the output of the targets, only write it to the log files of -log-dir")
+	flag.BoolVar(&app.mergeOutput, "merge-output", app.mergeOutput, "Write remote stderr to stdout, tagging each line with its stream")
+	flag.BoolVar(&app.timestamps, "timestamps", app.timestamps, "Prefix each line of remote command output with the time it was received")
+	flag.BoolVar(&app.debugSSH, "debug-ssh", app.debugSSH, "Log SSH connections, handshakes and authentication attempts to stderr")
+	flag.IntVar(&app.maxReconnects, "max-reconnects", app.maxReconnects, "Maximum reconnects when the connection to a target is lost during a job")
+	flag.Func("default-port", "SSH port of targets that set none, unless the config sets default_port", app.setDefaultPort)
+	flag.Func("hash-storage", "Where step hashes are stored: local or remote (default local)", app.setHashStorage)
+	flag.StringVar(&app.stateDir, "state-dir", app.stateDir, "Directory for local step hashes (also NSHIP_STATE_DIR)")
+	flag.StringVar(&app.stateFile, "state-file", app.stateFile, "File for local step hashes, overrides -state-dir")
+	flag.StringVar(&app.format, "format", app.format, "Output format for the dump and init commands (yaml, json, toml; init also ts)")
+	flag.BoolVar(&app.redact, "redact", app.redact, "Redact secrets in the output of the dump command")
+	flag.BoolVar(&app.force, "force", app.force, "Overwrite an existing config file with the init command")
+	flag.BoolVar(&app.printEnv, "print-env", app.printEnv, "Print the environment variables available to the config and exit")
+	flag.BoolVar(&app.noColor, "no-color", app.noColor, "Disable colored output (also NO_COLOR)")
 	flag.BoolVar(&app.version, "version", app.version, "Show version information")
 
-	flag.Parse()
+	_ = flag.CommandLine.Parse(args)
+}
+
+// addEnvVar adds an environment variable given as KEY=VALUE
+func (app *Application) addEnvVar(value string) error {
+	key, val, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("must have the form KEY=VALUE")
+	}
+	if app.envVars == nil {
+		app.envVars = make(map[string]string)
+	}
+	app.envVars[key] = val
+	return nil
+}
+
+// setHashStorage sets where step hashes are stored after checking that the value is supported
+func (app *Application) setHashStorage(value string) error {
+	if value != hashStorageLocal && value != hashStorageRemote {
+		return fmt.Errorf("must be %s or %s", hashStorageLocal, hashStorageRemote)
+	}
+	app.hashStorage = value
+	return nil
+}
+
+// setDefaultPort sets the default SSH port after checking that it is a valid port
+func (app *Application) setDefaultPort(value string) error {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("must be a port between 1 and 65535")
+	}
+	app.defaultPort = port
+	return nil
 }
 
 // Run executes the application
-func (app *Application) Run() error {
+func (app *Application) Run(ctx context.Context) error {
+	util.SetColor(util.ColorSupported(app.noColor, os.Stdout), util.ColorSupported(app.noColor, os.Stderr))
+
 	// Show version and exit if requested
 	if app.version {
 		fmt.Printf("nship version %s\n", app.versionString)
 		return nil
 	}
 
-	// Find the appropriate config path
-	configPath := app.findConfigPath()
+	if app.printEnv {
+		return app.printEnvironment()
+	}
+
+	return app.runCommand(ctx)
+}
+
+// runCommand runs the subcommand, or the deployment when there is none. Commands that don't
+// read the config don't need one to exist.
+func (app *Application) runCommand(ctx context.Context) error {
+	switch app.command {
+	case commandInit:
+		return app.initConfig()
+	case commandClearCache:
+		return app.clearCache()
+	}
+
+	configPath, err := app.findConfigPath()
+	if err != nil {
+		return err
+	}
+
+	switch app.command {
+	case commandValidate:
+		return app.validateConfig(configPath)
+	case commandDump:
+		return app.dumpConfig(configPath)
+	}
 
 	// Execute the application with the determined config path
-	return app.executeWithConfig(configPath)
+	return app.executeWithConfig(ctx, configPath)
+}
+
+// validateConfig loads and validates the configuration without connecting to any target
+func (app *Application) validateConfig(configPath string) error {
+	cfg, err := cli.Validate(configPath, app.envPaths, app.vaultPassword, app.loadOptions()...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(util.Success(fmt.Sprintf("config OK: %d targets, %d jobs", len(cfg.Targets), len(cfg.Jobs))))
+	return nil
+}
+
+// printEnvironment prints the environment variables available to the config after loading the
+// environment files, those set by nship first
+func (app *Application) printEnvironment() error {
+	vars, err := cli.Env(app.envPaths, app.vaultPassword, app.loadOptions()...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("# Set by env files and --env")
+	printEnvVariables(vars, true)
+	fmt.Println("\n# Inherited")
+	printEnvVariables(vars, false)
+	return nil
+}
+
+// printEnvVariables prints the variables that were set by nship, or those that were inherited
+func printEnvVariables(vars []cli.EnvVariable, set bool) {
+	for _, v := range vars {
+		if v.Set == set {
+			fmt.Printf("%s=%s\n", v.Name, v.Value)
+		}
+	}
 }
 
-// findConfigPath determines which configuration file to use
-func (app *Application) findConfigPath() string {
+// findConfigPath determines which configuration file to use. A path given with --config is used as is,
+// otherwise the first default config file that exists. When there is none, the error lists the paths tried.
+func (app *Application) findConfigPath() (string, error) {
 	// If user specified a config path directly, use that
 	if app.configPath != app.defaultConfigPaths[0] {
-		return app.configPath
+		return app.configPath, nil
 	}
 
 	// Try each default config path
 	for _, configPath := range app.defaultConfigPaths {
-		// Check if file exists
-		_, err := os.Stat(configPath)
-		if err == nil {
-			// Found a config file, use it
-			return configPath
+		if _, err := os.Stat(configPath); err == nil {
+			return configPath, nil
+		}
+	}
+
+	return "", fmt.Errorf("no config file found, tried %s; use --config to set its path",
+		strings.Join(app.defaultConfigPaths, ", "))
+}
+
+// dumpConfig prints the loaded and validated configuration in the requested format
+func (app *Application) dumpConfig(configPath string) error {
+	data, err := cli.Dump(configPath, app.envPaths, app.vaultPassword, app.format, app.redact, app.loadOptions()...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(strings.TrimRight(string(data), "\n"))
+	return nil
+}
+
+// initConfig writes a starter configuration in the requested format, to -config when it is given and
+// to nship.<format> otherwise
+func (app *Application) initConfig() error {
+	configPath := app.configPath
+	if configPath == app.defaultConfigPaths[0] {
+		configPath = config.StarterFileName(app.format)
+	}
+
+	if err := cli.Init(configPath, app.format, app.force); err != nil {
+		return err
+	}
+
+	fmt.Println(util.Success("created " + configPath))
+	return nil
+}
+
+// clearCache removes the stored hashes of executed steps for the selected jobs and target. Only the
+// remote hash storage reads the config, to connect to the targets.
+func (app *Application) clearCache() error {
+	configPath := app.configPath
+	if app.hashStorage == hashStorageRemote {
+		var err error
+		if configPath, err = app.findConfigPath(); err != nil {
+			return err
 		}
 	}
 
-	// If no config file found, use the first default path
-	// This will likely lead to an error, but maintains backward compatibility
-	return app.defaultConfigPaths[0]
+	opts := append(app.hashStorageOptions(), app.connectionOptions()...)
+	opts = append(opts, app.loadOptions()...)
+	jobNames := app.jobNames
+	if len(jobNames) == 0 {
+		jobNames = []string{""}
+	}
+
+	total := 0
+	for _, jobName := range jobNames {
+		removed, err := cli.ClearCache(configPath, app.envPaths, app.vaultPassword, app.targetName, jobName, opts...)
+		total += removed
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Removed %d cached step hashes\n", total)
+	return nil
 }
 
 // executeWithConfig runs the application with the given config path
-func (app *Application) executeWithConfig(configPath string) error {
-	if app.noSkip {
-		cliApp := cli.NewApp()
-		return cliApp.Run(configPath, app.jobName, app.envPaths, app.vaultPassword)
+func (app *Application) executeWithConfig(ctx context.Context, configPath string) error {
+	return cli.RunWithOptionsContext(ctx, configPath, app.jobNames, app.envPaths, app.vaultPassword, app.appOptions()...)
+}
+
+// appOptions builds the CLI application options from the parsed flags
+func (app *Application) appOptions() []cli.AppOption {
+	opts := app.skipOptions()
+	opts = append(opts, app.hashStorageOptions()...)
+	opts = append(opts, app.connectionOptions()...)
+	opts = append(opts, app.preflightOptions()...)
+	opts = append(opts, app.logOptions()...)
+
+	if app.maxReconnects != job.DefaultMaxReconnects {
+		opts = append(opts, cli.WithMaxReconnects(app.maxReconnects))
+	}
+
+	if app.keepGoing {
+		opts = append(opts, cli.WithKeepGoing(true))
+	}
+
+	if app.forceUnlock {
+		opts = append(opts, cli.WithForceUnlock(true))
+	}
+
+	if app.targetOrder != "" {
+		opts = append(opts, cli.WithTargetOrder(app.targetOrder))
+	}
+
+	if app.interactive {
+		opts = append(opts, cli.WithInteractive(true))
+	}
+
+	if app.timeout > 0 {
+		opts = append(opts, cli.WithTimeout(app.timeout))
+	}
+
+	return append(opts, app.loadOptions()...)
+}
+
+// skipOptions returns the CLI application options controlling which unchanged steps are skipped
+func (app *Application) skipOptions() []cli.AppOption {
+	var opts []cli.AppOption
+
+	if !app.noSkip {
+		opts = append(opts, cli.WithSkipUnchanged(true))
+	}
+
+	if len(app.forceJobs) > 0 {
+		opts = append(opts, cli.WithForceJobs(app.forceJobs...))
+	}
+
+	return opts
+}
+
+// logOptions returns the CLI application options controlling where the output of the targets is written
+func (app *Application) logOptions() []cli.AppOption {
+	var opts []cli.AppOption
+
+	if app.logDir != "" {
+		opts = append(opts, cli.WithLogDir(app.logDir))
+	}
+
+	if app.quiet {
+		opts = append(opts, cli.WithQuiet(true))
+	}
+
+	return opts
+}
+
+// connectionOptions returns the CLI application options controlling the connections to targets
+func (app *Application) connectionOptions() []cli.AppOption {
+	var opts []cli.AppOption
+
+	if app.defaultPort > 0 {
+		opts = append(opts, cli.WithDefaultPort(app.defaultPort))
+	}
+
+	if app.maxUploadRate > 0 {
+		opts = append(opts, cli.WithMaxUploadRate(app.maxUploadRate))
+	}
+
+	if app.mergeOutput || app.timestamps {
+		opts = append(opts, cli.WithOutput(ssh.OutputOptions{Merge: app.mergeOutput, Timestamps: app.timestamps}))
+	}
+
+	if app.debugSSH {
+		opts = append(opts, cli.WithDebugSSH(true))
+	}
+
+	return opts
+}
+
+// preflightOptions returns the CLI application options selecting the checks run before any job starts
+func (app *Application) preflightOptions() []cli.AppOption {
+	if app.noPreflight {
+		return []cli.AppOption{cli.WithPreflight(false)}
+	}
+	if app.preflight {
+		return []cli.AppOption{cli.WithConnectionCheck(true)}
+	}
+	return nil
+}
+
+// hashStorageOptions returns the CLI application options selecting where step hashes are stored
+func (app *Application) hashStorageOptions() []cli.AppOption {
+	if app.hashStorage == hashStorageRemote {
+		return []cli.AppOption{cli.WithRemoteHashStorage()}
+	}
+
+	var opts []cli.AppOption
+	if app.stateDir != "" {
+		opts = append(opts, cli.WithStateDir(app.stateDir))
+	}
+	if app.stateFile != "" {
+		opts = append(opts, cli.WithStateFile(app.stateFile))
+	}
+	return opts
+}
+
+// loadOptions returns the CLI application options controlling how the environment and config
+// are loaded, i.e. prompts, vault passwords, environment variables, the config profile and templating
+func (app *Application) loadOptions() []cli.AppOption {
+	var opts []cli.AppOption
+
+	if app.profile != "" {
+		opts = append(opts, cli.WithProfile(app.profile))
+	}
+
+	if app.template {
+		opts = append(opts, cli.WithTemplate(true))
+	}
+
+	if len(app.envVars) > 0 {
+		opts = append(opts, cli.WithEnv(app.envVars))
+	}
+
+	if app.envOverride {
+		opts = append(opts, cli.WithEnvOverride(true))
+	}
+
+	if app.assumeYes {
+		opts = append(opts, cli.WithAssumeYes(true))
+	}
+
+	if app.vaultPassFile != "" {
+		opts = append(opts, cli.WithVaultPasswordFile(app.vaultPassFile))
+	}
+
+	return opts
+}
+
+// envAssumeYes reports whether NSHIP_ASSUME_YES is set to a true value
+func envAssumeYes() bool {
+	value := os.Getenv("NSHIP_ASSUME_YES")
+	assumeYes, err := strconv.ParseBool(value)
+	return err == nil && assumeYes || util.IsYes(value)
+}
+
+// exitCode returns the exit code for a run that failed with err
+func exitCode(err error) int {
+	var deployErr *job.DeploymentError
+	if errors.As(err, &deployErr) && deployErr.Partial() {
+		return exitPartialFailure
+	}
+	return exitFailure
+}
+
+// runInterruptible calls run and returns the exit code of the run. The first signal cancels the
+// context of the run and gives it timeout to run its cleanup hooks; a second signal or the timeout
+// stops waiting for it. An interrupted run exits with exitInterrupted.
+func runInterruptible(run func(ctx context.Context) error, signals <-chan os.Signal, timeout time.Duration) int {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- run(ctx) }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			return 0
+		}
+		log.Print(util.StderrFailure(fmt.Sprintf("Error: %v", err)))
+		return exitCode(err)
+	case sig := <-signals:
+		fmt.Fprintf(os.Stderr, "\nReceived %v, stopping the deployment and running cleanup hooks, press Ctrl-C again to exit immediately\n", sig)
+		cancel()
+	}
+
+	waitForCleanup(done, signals, timeout)
+	return exitInterrupted
+}
+
+// waitForCleanup waits for an interrupted run to finish, at most for timeout or until another signal arrives
+func waitForCleanup(done <-chan error, signals <-chan os.Signal, timeout time.Duration) {
+	select {
+	case err := <-done:
+		if err != nil {
+			log.Print(util.StderrFailure(fmt.Sprintf("Error: %v", err)))
+		}
+	case <-signals:
+		log.Printf("Exiting without waiting for cleanup")
+	case <-time.After(timeout):
+		log.Printf("Cleanup did not finish within %s, exiting", timeout)
 	}
-	return cli.RunWithSkipUnchanged(configPath, app.jobName, app.envPaths, app.vaultPassword, !app.noSkip)
 }
 
 func main() {
 	app := NewApplication()
 	app.ParseFlags()
 
-	if err := app.Run(); err != nil {
-		log.Fatalf("Error: %v", err)
-	}
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	os.Exit(runInterruptible(app.Run, signals, cleanupTimeout))
 }
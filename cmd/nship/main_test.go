@@ -1,12 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/infrastructure/fs"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseFlags(t *testing.T) {
@@ -24,7 +32,7 @@ func TestParseFlags(t *testing.T) {
 		name         string
 		args         []string
 		wantConfig   string
-		wantJob      string
+		wantJobs     []string
 		wantEnv      []string
 		wantPassword string
 		wantVersion  bool
@@ -33,7 +41,7 @@ func TestParseFlags(t *testing.T) {
 			name:         "default values",
 			args:         []string{"nship"},
 			wantConfig:   "nship.yaml",
-			wantJob:      "",
+			wantJobs:     nil,
 			wantEnv:      nil,
 			wantPassword: "",
 			wantVersion:  false,
@@ -42,7 +50,7 @@ func TestParseFlags(t *testing.T) {
 			name:         "all flags set",
 			args:         []string{"nship", "-config", "custom.yaml", "-job", "deploy", "-env-file", "prod.env", "-vault-password", "secret"},
 			wantConfig:   "custom.yaml",
-			wantJob:      "deploy",
+			wantJobs:     []string{"deploy"},
 			wantEnv:      []string{"prod.env"},
 			wantPassword: "secret",
 			wantVersion:  false,
@@ -51,7 +59,7 @@ func TestParseFlags(t *testing.T) {
 			name:         "version flag",
 			args:         []string{"nship", "-version"},
 			wantConfig:   "nship.yaml", // Default value should still be set
-			wantJob:      "",
+			wantJobs:     nil,
 			wantEnv:      nil,
 			wantPassword: "",
 			wantVersion:  true,
@@ -61,7 +69,7 @@ func TestParseFlags(t *testing.T) {
 			args:         []string{"nship", "-env-file", "dev.env,prod.env,secrets.env"},
 			wantConfig:   "nship.yaml",
 			wantEnv:      []string{"dev.env", "prod.env", "secrets.env"},
-			wantJob:      "",
+			wantJobs:     nil,
 			wantPassword: "",
 			wantVersion:  false,
 		},
@@ -70,7 +78,16 @@ func TestParseFlags(t *testing.T) {
 			args:         []string{"nship", "-env-file", "dev.env", "-env-file", "prod.env", "-env-file", "secrets.env"},
 			wantConfig:   "nship.yaml",
 			wantEnv:      []string{"dev.env", "prod.env", "secrets.env"},
-			wantJob:      "",
+			wantJobs:     nil,
+			wantPassword: "",
+			wantVersion:  false,
+		},
+		{
+			name:         "multiple job flags",
+			args:         []string{"nship", "-job", "migrate", "-job", "deploy-*"},
+			wantConfig:   "nship.yaml",
+			wantJobs:     []string{"migrate", "deploy-*"},
+			wantEnv:      nil,
 			wantPassword: "",
 			wantVersion:  false,
 		},
@@ -79,7 +96,7 @@ func TestParseFlags(t *testing.T) {
 			args:         []string{"nship", "-env-file", "dev.env,stage.env", "-env-file", "prod.env"},
 			wantConfig:   "nship.yaml",
 			wantEnv:      []string{"dev.env", "stage.env", "prod.env"},
-			wantJob:      "",
+			wantJobs:     nil,
 			wantPassword: "",
 			wantVersion:  false,
 		},
@@ -99,7 +116,7 @@ func TestParseFlags(t *testing.T) {
 
 			// Check values using testify/assert
 			assert.Equal(t, tt.wantConfig, app.configPath, "configPath mismatch")
-			assert.Equal(t, tt.wantJob, app.jobName, "jobName mismatch")
+			assert.Equal(t, tt.wantJobs, app.jobNames, "jobNames mismatch")
 			assert.Equal(t, tt.wantEnv, app.envPaths, "envPaths mismatch")
 			assert.Equal(t, tt.wantPassword, app.vaultPassword, "vaultPassword mismatch")
 		})
@@ -132,6 +149,621 @@ func TestMultipleEnvFilesFlag(t *testing.T) {
 	assert.Equal(t, []string{"first.env", "second.env"}, app.envPaths, "Failed to collect multiple env-file flags")
 }
 
+func TestParseFlagsSubcommand(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	tests := []struct {
+		name        string
+		args        []string
+		wantCommand string
+		wantConfig  string
+	}{
+		{
+			name:        "no subcommand",
+			args:        []string{"nship", "-config", "custom.yaml"},
+			wantCommand: "",
+			wantConfig:  "custom.yaml",
+		},
+		{
+			name:        "validate subcommand",
+			args:        []string{"nship", "validate", "-config", "custom.yaml"},
+			wantCommand: "validate",
+			wantConfig:  "custom.yaml",
+		},
+		{
+			name:        "dump subcommand",
+			args:        []string{"nship", "dump", "-config", "custom.ts", "-format", "json", "-redact"},
+			wantCommand: "dump",
+			wantConfig:  "custom.ts",
+		},
+		{
+			name:        "init subcommand",
+			args:        []string{"nship", "init", "-format", "ts", "-force"},
+			wantCommand: "init",
+			wantConfig:  "nship.yaml",
+		},
+		{
+			name:        "validate without flags",
+			args:        []string{"nship", "validate"},
+			wantCommand: "validate",
+			wantConfig:  "nship.yaml",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+			os.Args = tt.args
+
+			app := NewApplication()
+			app.ParseFlags()
+
+			assert.Equal(t, tt.wantCommand, app.command, "command mismatch")
+			assert.Equal(t, tt.wantConfig, app.configPath, "configPath mismatch")
+		})
+	}
+}
+
+func TestParseFlagsMaxUploadRate(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-max-upload-rate", "10M"}
+
+	app := NewApplication()
+	app.ParseFlags()
+
+	assert.Equal(t, int64(10*1024*1024), app.maxUploadRate)
+	assert.Len(t, app.appOptions(), 2)
+
+	app.noSkip = true
+	app.maxUploadRate = 0
+	assert.Empty(t, app.appOptions())
+}
+
+func TestParseFlagsOutput(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip", "-merge-output", "-timestamps"}
+
+	app := NewApplication()
+	app.ParseFlags()
+
+	assert.True(t, app.mergeOutput)
+	assert.True(t, app.timestamps)
+	assert.Len(t, app.appOptions(), 1)
+
+	app.mergeOutput = false
+	app.timestamps = false
+	assert.Empty(t, app.appOptions())
+}
+
+func TestParseFlagsTimeout(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip", "-timeout", "15m"}
+
+	app := NewApplication()
+	app.ParseFlags()
+
+	assert.Equal(t, 15*time.Minute, app.timeout)
+	assert.Len(t, app.appOptions(), 1)
+
+	app.timeout = 0
+	assert.Empty(t, app.appOptions(), "no timeout should be the default")
+}
+
+func TestParseFlagsForceJob(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-force-job", "migrate", "--force-job", "deploy-*"}
+
+	app := NewApplication()
+	app.ParseFlags()
+
+	assert.Equal(t, []string{"migrate", "deploy-*"}, app.forceJobs)
+	assert.Len(t, app.skipOptions(), 2)
+
+	app.forceJobs = nil
+	assert.Len(t, app.skipOptions(), 1, "no job should be forced by default")
+}
+
+func TestParseFlagsTargetOrder(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip", "-target-order", "canary,web1,web2"}
+
+	app := NewApplication()
+	app.ParseFlags()
+
+	assert.Equal(t, "canary,web1,web2", app.targetOrder)
+	assert.Len(t, app.appOptions(), 1)
+}
+
+func TestParseFlagsForceUnlock(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip", "-force-unlock"}
+
+	app := NewApplication()
+	app.ParseFlags()
+
+	assert.True(t, app.forceUnlock)
+	assert.Len(t, app.appOptions(), 1)
+}
+
+func TestParseFlagsLogDir(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-log-dir", "logs", "-quiet"}
+
+	app := NewApplication()
+	app.ParseFlags()
+
+	assert.Equal(t, "logs", app.logDir)
+	assert.True(t, app.quiet)
+	assert.Len(t, app.logOptions(), 2)
+
+	app.logDir, app.quiet = "", false
+	assert.Empty(t, app.logOptions())
+}
+
+func TestParseFlagsDebugSSH(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip", "-debug-ssh"}
+
+	app := NewApplication()
+	app.ParseFlags()
+
+	assert.True(t, app.debugSSH)
+	assert.Len(t, app.appOptions(), 1)
+}
+
+func TestParseFlagsMaxReconnects(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip"}
+
+	app := NewApplication()
+	app.ParseFlags()
+	assert.Equal(t, job.DefaultMaxReconnects, app.maxReconnects)
+	assert.Empty(t, app.appOptions(), "the default should need no option")
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip", "-max-reconnects", "0"}
+
+	app = NewApplication()
+	app.ParseFlags()
+	assert.Equal(t, 0, app.maxReconnects)
+	assert.Len(t, app.appOptions(), 1)
+}
+
+func TestParseFlagsDefaultPort(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	os.Args = []string{"nship", "-no-skip", "-default-port", "2222"}
+
+	app := NewApplication()
+	app.ParseFlags()
+	assert.Equal(t, 2222, app.defaultPort)
+	assert.Len(t, app.appOptions(), 1)
+
+	assert.Error(t, app.setDefaultPort("70000"))
+	assert.Error(t, app.setDefaultPort("ssh"))
+	assert.Equal(t, 2222, app.defaultPort, "an invalid port should be rejected")
+}
+
+func TestParseFlagsKeepGoing(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip", "-keep-going"}
+
+	app := NewApplication()
+	app.ParseFlags()
+	assert.True(t, app.keepGoing)
+	assert.Len(t, app.appOptions(), 1)
+}
+
+func TestExitCode(t *testing.T) {
+	assert.Equal(t, exitFailure, exitCode(errors.New("config loading failed")))
+	assert.Equal(t, exitPartialFailure, exitCode(fmt.Errorf("job execution failed: %w", &job.DeploymentError{Total: 2, Failed: 1})))
+	assert.Equal(t, exitFailure, exitCode(fmt.Errorf("job execution failed: %w", &job.DeploymentError{Total: 2, Failed: 2})))
+}
+
+func TestRunInterruptible(t *testing.T) {
+	t.Run("finished run", func(t *testing.T) {
+		signals := make(chan os.Signal, 2)
+		assert.Equal(t, 0, runInterruptible(func(context.Context) error { return nil }, signals, time.Second))
+		assert.Equal(t, exitFailure, runInterruptible(func(context.Context) error { return errors.New("boom") }, signals, time.Second))
+	})
+
+	t.Run("interrupted run cleaning up", func(t *testing.T) {
+		signals := make(chan os.Signal, 2)
+		signals <- os.Interrupt
+
+		cleanedUp := false
+		code := runInterruptible(func(ctx context.Context) error {
+			<-ctx.Done()
+			cleanedUp = true
+			return ctx.Err()
+		}, signals, time.Minute)
+
+		assert.Equal(t, exitInterrupted, code)
+		assert.True(t, cleanedUp, "the run should be waited for while it cleans up")
+	})
+
+	t.Run("second signal", func(t *testing.T) {
+		signals := make(chan os.Signal, 2)
+		signals <- os.Interrupt
+		signals <- os.Interrupt
+
+		block := make(chan struct{})
+		defer close(block)
+		code := runInterruptible(func(context.Context) error {
+			<-block
+			return nil
+		}, signals, time.Minute)
+
+		assert.Equal(t, exitInterrupted, code)
+	})
+
+	t.Run("cleanup timeout", func(t *testing.T) {
+		signals := make(chan os.Signal, 2)
+		signals <- os.Interrupt
+
+		block := make(chan struct{})
+		defer close(block)
+		code := runInterruptible(func(context.Context) error {
+			<-block
+			return nil
+		}, signals, 10*time.Millisecond)
+
+		assert.Equal(t, exitInterrupted, code)
+	})
+}
+
+func TestParseFlagsHashStorage(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip"}
+
+	app := NewApplication()
+	app.ParseFlags()
+	assert.Equal(t, hashStorageLocal, app.hashStorage)
+	assert.Empty(t, app.appOptions())
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip", "-hash-storage=remote"}
+
+	app = NewApplication()
+	app.ParseFlags()
+	assert.Equal(t, hashStorageRemote, app.hashStorage)
+	assert.Len(t, app.appOptions(), 1)
+}
+
+func TestParseFlagsStateDir(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	t.Setenv("NSHIP_STATE_DIR", "/var/cache/nship")
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip"}
+
+	app := NewApplication()
+	app.ParseFlags()
+	assert.Equal(t, "/var/cache/nship", app.stateDir, "NSHIP_STATE_DIR should set the default")
+	assert.Len(t, app.appOptions(), 1)
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip", "-state-dir", "state/prod", "-state-file", "prod.json"}
+
+	app = NewApplication()
+	app.ParseFlags()
+	assert.Equal(t, "state/prod", app.stateDir, "the flag should override NSHIP_STATE_DIR")
+	assert.Equal(t, "prod.json", app.stateFile)
+	assert.Len(t, app.appOptions(), 2)
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip", "-hash-storage", "remote", "-state-file", "prod.json"}
+
+	app = NewApplication()
+	app.ParseFlags()
+	assert.Len(t, app.appOptions(), 1, "local state options should not apply to remote storage")
+}
+
+func TestParseFlagsAssumeYes(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	tests := []struct {
+		name    string
+		args    []string
+		envYes  string
+		wantYes bool
+	}{
+		{name: "default", args: []string{"nship"}, wantYes: false},
+		{name: "yes flag", args: []string{"nship", "-yes"}, wantYes: true},
+		{name: "env true", args: []string{"nship"}, envYes: "1", wantYes: true},
+		{name: "env yes", args: []string{"nship"}, envYes: "yes", wantYes: true},
+		{name: "env false", args: []string{"nship"}, envYes: "false", wantYes: false},
+		{name: "flag overrides env", args: []string{"nship", "-yes=false"}, envYes: "true", wantYes: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NSHIP_ASSUME_YES", tt.envYes)
+			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+			os.Args = tt.args
+
+			app := NewApplication()
+			app.ParseFlags()
+
+			assert.Equal(t, tt.wantYes, app.assumeYes)
+			assert.Equal(t, tt.wantYes, len(app.loadOptions()) == 1)
+		})
+	}
+}
+
+func TestValidateCommand(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	validPath := filepath.Join(tmpDir, "valid.yaml")
+	err := os.WriteFile(validPath, []byte(`
+targets:
+  - host: web.example.com
+    user: admin
+    password: secret
+jobs:
+  - name: deploy
+    steps:
+      - run: echo deploy
+`), 0644)
+	assert.NoError(t, err)
+
+	invalidPath := filepath.Join(tmpDir, "invalid.yaml")
+	err = os.WriteFile(invalidPath, []byte(`
+targets:
+  - host: web.example.com
+jobs:
+  - name: deploy
+    steps:
+      - run: echo deploy
+`), 0644)
+	assert.NoError(t, err)
+
+	app := NewApplication()
+	app.command = commandValidate
+
+	app.configPath = validPath
+	assert.NoError(t, app.Run(context.Background()))
+
+	app.configPath = invalidPath
+	err = app.Run(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "targets[0].user is required")
+}
+
+func TestDumpCommand(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "nship.yaml")
+	err := os.WriteFile(configPath, []byte(`
+targets:
+  - host: web.example.com
+    user: admin
+    password: secret
+jobs:
+  - name: deploy
+    steps:
+      - run: echo deploy
+`), 0644)
+	assert.NoError(t, err)
+
+	app := NewApplication()
+	app.command = commandDump
+	app.configPath = configPath
+
+	for _, format := range []string{"yaml", "json", "toml"} {
+		app.format = format
+		assert.NoError(t, app.Run(context.Background()), "dump to %s failed", format)
+	}
+
+	app.format = "xml"
+	err = app.Run(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported output format")
+}
+
+func TestInitCommand(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "deploy.toml")
+
+	app := NewApplication()
+	app.command = commandInit
+	app.configPath = configPath
+	app.format = "toml"
+	assert.NoError(t, app.Run(context.Background()))
+
+	app.command = commandValidate
+	assert.NoError(t, app.Run(context.Background()), "the starter config should be valid")
+
+	app.command = commandInit
+	err := app.Run(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "already exists")
+
+	app.force = true
+	app.format = "yaml"
+	assert.NoError(t, app.Run(context.Background()))
+	data, err := os.ReadFile(configPath)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "host: example.com")
+}
+
+func TestClearCacheCommand(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	storage := fs.NewFileHashStorage(fs.WithHashFile(stateFile))
+	assert.NoError(t, storage.SaveHash("web", "deploy", 0, "hash"))
+	assert.NoError(t, storage.SaveHash("db", "deploy", 0, "hash"))
+
+	app := NewApplication()
+	app.command = commandClearCache
+	app.configPath = filepath.Join(t.TempDir(), "missing.yaml")
+	app.stateFile = stateFile
+	app.targetName = "web"
+
+	assert.NoError(t, app.Run(context.Background()), "clearing local hashes should not need a configuration")
+
+	hash, err := fs.NewFileHashStorage(fs.WithHashFile(stateFile)).GetHash("db", "deploy", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "hash", hash, "hashes of other targets should be kept")
+}
+
+func TestFindConfigPath(t *testing.T) {
+	t.Chdir(t.TempDir())
+	app := NewApplication()
+
+	_, err := app.findConfigPath()
+	assert.EqualError(t, err, "no config file found, tried nship.yaml, nship.yml; use --config to set its path")
+
+	require.NoError(t, os.WriteFile("nship.yml", []byte("targets: []\n"), 0600))
+	configPath, err := app.findConfigPath()
+	require.NoError(t, err)
+	assert.Equal(t, "nship.yml", configPath, "the next default path should be tried")
+
+	require.NoError(t, os.WriteFile("nship.yaml", []byte("targets: []\n"), 0600))
+	configPath, err = app.findConfigPath()
+	require.NoError(t, err)
+	assert.Equal(t, "nship.yaml", configPath)
+
+	app.configPath = "missing.yaml"
+	configPath, err = app.findConfigPath()
+	require.NoError(t, err)
+	assert.Equal(t, "missing.yaml", configPath, "a path given with --config should be used as is")
+}
+
+func TestRunWithoutConfig(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	for _, command := range []string{"", commandValidate, commandDump} {
+		app := NewApplication()
+		app.command = command
+		err := app.Run(context.Background())
+		assert.ErrorContains(t, err, "no config file found, tried nship.yaml, nship.yml", "command %q", command)
+	}
+
+	app := NewApplication()
+	app.command = commandClearCache
+	app.stateFile = "state.json"
+	assert.NoError(t, app.Run(context.Background()), "clearing local hashes should not need a config")
+
+	app.hashStorage = hashStorageRemote
+	assert.ErrorContains(t, app.Run(context.Background()), "no config file found")
+
+	app = NewApplication()
+	app.command = commandInit
+	assert.NoError(t, app.Run(context.Background()), "init should create the config")
+	assert.FileExists(t, "nship.yaml")
+}
+
 func TestEnvPathsParsing(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -196,7 +828,7 @@ func TestApplicationRun(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := tt.app.Run()
+			err := tt.app.Run(context.Background())
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -217,8 +849,117 @@ func TestMainComponents(t *testing.T) {
 	}
 
 	// This should just print the version and return
-	err := app.Run()
+	err := app.Run(context.Background())
 	assert.NoError(t, err, "Expected no error for version flag")
 
 	// Testing error cases would require mocking the cli.App dependency
 }
+
+func TestParseFlagsTemplate(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip", "-template"}
+
+	app := NewApplication()
+	app.ParseFlags()
+
+	assert.True(t, app.template)
+	assert.Len(t, app.appOptions(), 1)
+}
+
+func TestParseFlagsNoPreflight(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip", "-no-preflight"}
+
+	app := NewApplication()
+	app.ParseFlags()
+
+	assert.True(t, app.noPreflight)
+	assert.Len(t, app.appOptions(), 1)
+}
+
+func TestParseFlagsEnv(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-no-skip", "-env", "RELEASE=1.2.3", "-env", "QUERY=a=b", "-env", "RELEASE=1.2.4"}
+
+	app := NewApplication()
+	app.ParseFlags()
+
+	assert.Equal(t, map[string]string{"RELEASE": "1.2.4", "QUERY": "a=b"}, app.envVars)
+	assert.Len(t, app.appOptions(), 1)
+
+	assert.Error(t, app.addEnvVar("RELEASE"))
+	assert.Error(t, app.addEnvVar("=1.2.3"))
+}
+
+func TestParseFlagsEnvOverride(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-env-override"}
+
+	app := NewApplication()
+	app.ParseFlags()
+
+	assert.True(t, app.envOverride)
+	assert.Len(t, app.loadOptions(), 1)
+}
+
+func TestParseFlagsPrintEnv(t *testing.T) {
+	oldArgs := os.Args
+	oldFlagCommandLine := flag.CommandLine
+
+	defer func() {
+		os.Args = oldArgs
+		flag.CommandLine = oldFlagCommandLine
+	}()
+
+	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	os.Args = []string{"nship", "-print-env", "-env-file", "prod.env"}
+
+	app := NewApplication()
+	app.ParseFlags()
+
+	assert.True(t, app.printEnv)
+	assert.Equal(t, []string{"prod.env"}, app.envPaths)
+}
+
+func TestPreflightOptions(t *testing.T) {
+	app := NewApplication()
+	assert.Empty(t, app.preflightOptions())
+
+	app.preflight = true
+	assert.Len(t, app.preflightOptions(), 1)
+
+	app.noPreflight = true
+	assert.Len(t, app.preflightOptions(), 1, "--no-preflight should win")
+}
@@ -15,6 +15,69 @@ type Builder struct {
 	currentJob *job.Job
 }
 
+// StepOption configures a step added by the builder
+type StepOption func(*job.Step)
+
+// WithStepShell sets the shell running the commands of the step
+func WithStepShell(shell string) StepOption {
+	return func(step *job.Step) {
+		step.Shell = shell
+	}
+}
+
+// WithStepEnv sets the environment variables of the commands of the step. It only applies
+// to run, script_file and run_script steps, Build rejects it on others.
+func WithStepEnv(env map[string]string) StepOption {
+	return func(step *job.Step) {
+		step.Env = env
+	}
+}
+
+// WithStepWorkdir sets the remote directory the commands of the step run in. It only applies
+// to run, script_file and run_script steps, Build rejects it on others.
+func WithStepWorkdir(dir string) StepOption {
+	return func(step *job.Step) {
+		step.Workdir = dir
+	}
+}
+
+// WithStepSudo runs the commands of the step as root through sudo
+func WithStepSudo() StepOption {
+	return func(step *job.Step) {
+		step.Sudo = true
+	}
+}
+
+// WithStepRunOnce runs the step on the first target of the job only
+func WithStepRunOnce() StepOption {
+	return func(step *job.Step) {
+		step.RunOnce = true
+	}
+}
+
+// WithStepFailFast sets whether the run command of the step stops at its first failing line
+func WithStepFailFast(enabled bool) StepOption {
+	return func(step *job.Step) {
+		step.FailFast = &enabled
+	}
+}
+
+// WithStepWhen sets the condition the step only runs under, see job.Condition
+func WithStepWhen(condition string) StepOption {
+	return func(step *job.Step) {
+		step.When = condition
+	}
+}
+
+// WithExclude sets the patterns of files excluded from a copy step. It has no effect on other steps.
+func WithExclude(patterns ...string) StepOption {
+	return func(step *job.Step) {
+		if step.Copy != nil {
+			step.Copy.Exclude = patterns
+		}
+	}
+}
+
 // NewBuilder creates and returns a new Builder instance with an initialized
 // empty configuration.
 func NewBuilder() *Builder {
@@ -48,33 +111,123 @@ func (b *Builder) AddStep(step *job.Step) *Builder {
 	return b
 }
 
+// addStepWith applies opts to step and adds it to the current job
+func (b *Builder) addStepWith(step *job.Step, opts []StepOption) *Builder {
+	for _, opt := range opts {
+		opt(step)
+	}
+	return b.AddStep(step)
+}
+
 // AddRunStep creates and adds a new command execution step with the specified
-// command to the current job. Returns the builder for method chaining.
-func (b *Builder) AddRunStep(command string) *Builder {
+// command and options to the current job. Returns the builder for method chaining.
+func (b *Builder) AddRunStep(command string, opts ...StepOption) *Builder {
 	step := &job.Step{
 		Run: command,
 	}
-	return b.AddStep(step)
+	return b.addStepWith(step, opts)
 }
 
 // AddCopyStep creates and adds a new file copy step with the specified
-// source and destination paths. Returns the builder for method chaining.
-func (b *Builder) AddCopyStep(local, remote string) *Builder {
+// source and destination paths and options. Returns the builder for method chaining.
+func (b *Builder) AddCopyStep(local, remote string, opts ...StepOption) *Builder {
 	step := &job.Step{
 		Copy: &job.CopyStep{
 			Local:  local,
 			Remote: remote,
 		},
 	}
+	return b.addStepWith(step, opts)
+}
+
+// AddDownloadStep adds a new step downloading the remote path from the target
+// to the local path. Returns the builder for method chaining.
+func (b *Builder) AddDownloadStep(remote, local string) *Builder {
+	step := &job.Step{
+		Download: &job.DownloadStep{
+			Remote: remote,
+			Local:  local,
+		},
+	}
+	return b.AddStep(step)
+}
+
+// AddExecStep adds a new step running the local command with its arguments.
+// Returns the builder for method chaining.
+func (b *Builder) AddExecStep(command ...string) *Builder {
+	step := &job.Step{
+		Exec: &job.ExecStep{
+			Command: command,
+		},
+	}
 	return b.AddStep(step)
 }
 
 // AddDockerStep adds a new Docker execution step with the specified
-// Docker configuration. Returns the builder for method chaining.
-func (b *Builder) AddDockerStep(docker *job.DockerStep) *Builder {
+// Docker configuration and options. Returns the builder for method chaining.
+func (b *Builder) AddDockerStep(docker *job.DockerStep, opts ...StepOption) *Builder {
 	step := &job.Step{
 		Docker: docker,
 	}
+	return b.addStepWith(step, opts)
+}
+
+// AddDockerPruneStep adds a new step removing unused Docker images with the
+// specified options. Returns the builder for method chaining.
+func (b *Builder) AddDockerPruneStep(prune *job.DockerPruneStep) *Builder {
+	step := &job.Step{
+		DockerPrune: prune,
+	}
+	return b.AddStep(step)
+}
+
+// AddDockerExecStep adds a new step running a command in a running Docker
+// container with the specified options. Returns the builder for method chaining.
+func (b *Builder) AddDockerExecStep(exec *job.DockerExecStep, opts ...StepOption) *Builder {
+	step := &job.Step{
+		DockerExec: exec,
+	}
+	return b.addStepWith(step, opts)
+}
+
+// AddServiceStep adds a new step performing an action on a system service
+// with the specified options. Returns the builder for method chaining.
+func (b *Builder) AddServiceStep(service *job.ServiceStep, opts ...StepOption) *Builder {
+	step := &job.Step{
+		Service: service,
+	}
+	return b.addStepWith(step, opts)
+}
+
+// AddAssertStep adds a new step checking the exit code and output of a command
+// with the specified options. Returns the builder for method chaining.
+func (b *Builder) AddAssertStep(assert *job.AssertStep, opts ...StepOption) *Builder {
+	step := &job.Step{
+		Assert: assert,
+	}
+	return b.addStepWith(step, opts)
+}
+
+// AddWaitStep adds a new step pausing for the specified duration,
+// e.g. "5s". Returns the builder for method chaining.
+func (b *Builder) AddWaitStep(duration string) *Builder {
+	step := &job.Step{
+		Wait: &job.WaitStep{
+			Duration: duration,
+		},
+	}
+	return b.AddStep(step)
+}
+
+// AddRunScriptStep adds a new step uploading the local script at path and running it
+// with the given arguments. Returns the builder for method chaining.
+func (b *Builder) AddRunScriptStep(path string, args ...string) *Builder {
+	step := &job.Step{
+		RunScript: &job.RunScriptStep{
+			Path: path,
+			Args: args,
+		},
+	}
 	return b.AddStep(step)
 }
 
@@ -83,6 +236,14 @@ func (b *Builder) GetConfig() *Config {
 	return b.config
 }
 
+// Build validates the built configuration and returns it, or the validation error.
+func (b *Builder) Build() (*Config, error) {
+	if err := b.config.Validate(); err != nil {
+		return nil, err
+	}
+	return b.config, nil
+}
+
 // Print marshals the configuration to JSON and prints it to stdout.
 // Returns an error if JSON marshaling fails.
 func (b *Builder) Print() error {
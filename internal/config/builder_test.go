@@ -1,6 +1,8 @@
 package config
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/nickalie/nship/internal/core/job"
@@ -138,3 +140,174 @@ func TestAddStep(t *testing.T) {
 		t.Errorf("Expected step shell to be 'bash', got %s", step.Shell)
 	}
 }
+
+func TestAddWaitStep(t *testing.T) {
+	config := NewBuilder().AddJob("test-job").AddWaitStep("5s").GetConfig()
+
+	step := config.Jobs[0].Steps[0]
+	if step.Wait == nil {
+		t.Fatal("Expected wait step to be set")
+	}
+	if step.Wait.Duration != "5s" {
+		t.Errorf("Expected wait duration to be '5s', got %s", step.Wait.Duration)
+	}
+}
+
+func TestAddExecStep(t *testing.T) {
+	config := NewBuilder().AddJob("test-job").AddExecStep("./switch.sh", "blue").GetConfig()
+
+	step := config.Jobs[0].Steps[0]
+	if step.Exec == nil {
+		t.Fatal("Expected exec step to be set")
+	}
+	if !reflect.DeepEqual(step.Exec.Command, []string{"./switch.sh", "blue"}) {
+		t.Errorf("Expected command to be [./switch.sh blue], got %v", step.Exec.Command)
+	}
+}
+
+func TestAddDockerPruneStep(t *testing.T) {
+	prune := &job.DockerPruneStep{Containers: true, Until: "24h"}
+	config := NewBuilder().AddJob("test-job").AddDockerPruneStep(prune).GetConfig()
+
+	step := config.Jobs[0].Steps[0]
+	if step.DockerPrune != prune {
+		t.Errorf("Expected docker prune step to be %v, got %v", prune, step.DockerPrune)
+	}
+}
+
+func TestAddServiceStep(t *testing.T) {
+	service := &job.ServiceStep{Name: "nginx", Action: job.ServiceRestart, Verify: true}
+	config := NewBuilder().AddJob("test-job").AddServiceStep(service, WithStepSudo()).GetConfig()
+
+	step := config.Jobs[0].Steps[0]
+	if step.Service != service {
+		t.Errorf("Expected service step to be %v, got %v", service, step.Service)
+	}
+	if !step.Sudo {
+		t.Error("Expected service step to use sudo")
+	}
+}
+
+func TestAddAssertStep(t *testing.T) {
+	check := &job.AssertStep{Command: "curl -s localhost/health", Contains: "ok"}
+	config := NewBuilder().AddJob("test-job").AddAssertStep(check).GetConfig()
+
+	step := config.Jobs[0].Steps[0]
+	if step.Assert != check {
+		t.Errorf("Expected assert step to be %v, got %v", check, step.Assert)
+	}
+}
+
+func TestAddDownloadStep(t *testing.T) {
+	config := NewBuilder().AddJob("test-job").AddDownloadStep("/var/log/app.log", "logs/app.log").GetConfig()
+
+	step := config.Jobs[0].Steps[0]
+	if step.Download == nil {
+		t.Fatal("Expected download step to be set")
+	}
+	if step.Download.Remote != "/var/log/app.log" {
+		t.Errorf("Expected remote path to be '/var/log/app.log', got %s", step.Download.Remote)
+	}
+	if step.Download.Local != "logs/app.log" {
+		t.Errorf("Expected local path to be 'logs/app.log', got %s", step.Download.Local)
+	}
+}
+
+func TestAddRunScriptStep(t *testing.T) {
+	config := NewBuilder().AddJob("test-job").AddRunScriptStep("migrate.sh", "--env", "prod").GetConfig()
+
+	step := config.Jobs[0].Steps[0]
+	if step.RunScript == nil {
+		t.Fatal("Expected run script step to be set")
+	}
+	if step.RunScript.Path != "migrate.sh" {
+		t.Errorf("Expected script path to be 'migrate.sh', got %s", step.RunScript.Path)
+	}
+	if len(step.RunScript.Args) != 2 || step.RunScript.Args[1] != "prod" {
+		t.Errorf("Expected script args to be [--env prod], got %v", step.RunScript.Args)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	config, err := NewBuilder().
+		AddTarget(&target.Target{Host: "web.example.com", User: "admin", Password: "secret"}).
+		AddJob("deploy").
+		AddRunStep("make install").
+		Build()
+	if err != nil {
+		t.Fatalf("Expected valid config, got %v", err)
+	}
+	if config.Targets[0].Name != "web.example.com" {
+		t.Errorf("Expected target name to default to its host, got %s", config.Targets[0].Name)
+	}
+
+	_, err = NewBuilder().
+		AddTarget(&target.Target{Host: "web.example.com", User: "admin", Password: "secret"}).
+		AddJob("deploy").
+		AddStep(&job.Step{}).
+		Build()
+	if err == nil || !strings.Contains(err.Error(), "exactly one of run") {
+		t.Errorf("Expected step validation error, got %v", err)
+	}
+}
+
+func TestAddStepsWithOptions(t *testing.T) {
+	env := map[string]string{"APP_ENV": "production"}
+	config := NewBuilder().
+		AddJob("test-job").
+		AddRunStep("make install", WithStepShell("bash"), WithStepEnv(env), WithStepWorkdir("/app")).
+		AddCopyStep("dist/", "/app/", WithStepRunOnce()).
+		AddDockerStep(&job.DockerStep{Image: "nginx"}, WithStepSudo(), WithStepWhen(`target.name == "prod"`)).
+		AddRunStep("make test").
+		GetConfig()
+
+	run := config.Jobs[0].Steps[0]
+	if run.Shell != "bash" || run.Workdir != "/app" || !reflect.DeepEqual(run.Env, env) {
+		t.Errorf("Expected shell, env and workdir to be set on the run step, got %+v", run)
+	}
+	if !config.Jobs[0].Steps[1].RunOnce {
+		t.Error("Expected copy step to run once")
+	}
+	if !config.Jobs[0].Steps[2].Sudo {
+		t.Error("Expected docker step to use sudo")
+	}
+	if config.Jobs[0].Steps[2].When != `target.name == "prod"` {
+		t.Errorf("Expected docker step to have a condition, got %q", config.Jobs[0].Steps[2].When)
+	}
+
+	plain := config.Jobs[0].Steps[3]
+	if plain.Shell != "" || plain.Env != nil || plain.Workdir != "" {
+		t.Errorf("Expected a step without options to keep the defaults, got %+v", plain)
+	}
+}
+
+func TestBuildRejectsStepEnvOnCopySteps(t *testing.T) {
+	_, err := NewBuilder().
+		AddTarget(&target.Target{Host: "web.example.com", User: "admin", Password: "secret"}).
+		AddJob("deploy").
+		AddCopyStep("dist/", "/app/", WithStepEnv(map[string]string{"APP_ENV": "production"})).
+		Build()
+	if err == nil || !strings.Contains(err.Error(), "env can only be used with run") {
+		t.Errorf("Expected env to be rejected on a copy step, got %v", err)
+	}
+}
+
+func TestAddCopyStepWithExclude(t *testing.T) {
+	config := NewBuilder().
+		AddJob("test-job").
+		AddCopyStep("app/", "/srv/app/", WithExclude("*.log", "node_modules")).
+		AddCopyStep("static/", "/srv/static/").
+		AddRunStep("make", WithExclude("*.log")).
+		GetConfig()
+
+	steps := config.Jobs[0].Steps
+	if !reflect.DeepEqual(steps[0].Copy.Exclude, []string{"*.log", "node_modules"}) {
+		t.Errorf("Expected exclude patterns to be [*.log node_modules], got %v", steps[0].Copy.Exclude)
+	}
+	if steps[1].Copy.Exclude != nil {
+		t.Errorf("Expected no exclude patterns without options, got %v", steps[1].Copy.Exclude)
+	}
+	if steps[2].Copy != nil {
+		t.Error("Expected WithExclude to leave other steps unchanged")
+	}
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// gzipExt is the suffix of gzip-compressed config files, e.g. nship.yaml.gz
+const gzipExt = ".gz"
+
+// compressibleFormats lists the extensions of the config formats that can be gzip-compressed.
+// Script configs are run from disk and can't be.
+var compressibleFormats = map[string]bool{".yaml": true, ".yml": true, ".json": true, ".toml": true, ".hcl": true}
+
+// isCompressed reports whether configPath has the .gz suffix of a gzip-compressed config
+func isCompressed(configPath string) bool {
+	return strings.EqualFold(filepath.Ext(configPath), gzipExt)
+}
+
+// uncompressedPath returns configPath without its .gz suffix, whose extension is the format of the config
+func uncompressedPath(configPath string) string {
+	if isCompressed(configPath) {
+		return configPath[:len(configPath)-len(gzipExt)]
+	}
+	return configPath
+}
+
+// configExt returns the lower-case extension of the format of the config at configPath,
+// e.g. .yaml for both nship.yaml and nship.yaml.gz
+func configExt(configPath string) string {
+	return strings.ToLower(filepath.Ext(uncompressedPath(configPath)))
+}
+
+// readFile reads a config file, decompressing it if it is gzip-compressed
+func readFile(configPath string) ([]byte, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if !isCompressed(configPath) {
+		return data, nil
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress config file %s: %w", configPath, err)
+	}
+	defer reader.Close()
+
+	data, err = io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress config file %s: %w", configPath, err)
+	}
+	return data, nil
+}
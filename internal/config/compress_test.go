@@ -0,0 +1,83 @@
+package config
+
+import (
+	"bytes"
+	"compress/gzip"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeGzipConfigFile writes content gzip-compressed to path
+func writeGzipConfigFile(t *testing.T, path, content string) {
+	t.Helper()
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write([]byte(content))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	writeConfigFile(t, path, buf.String())
+}
+
+func TestLoadGzipConfig(t *testing.T) {
+	t.Setenv("NSHIP_TEST_HOST", "web.example.com")
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "nship.yaml.gz")
+	writeGzipConfigFile(t, yamlPath, `
+targets:
+  - name: web
+    host: ${NSHIP_TEST_HOST}
+    user: deploy
+    password: secret
+jobs:
+  - name: deploy
+    steps:
+      - run: echo deploy
+`)
+
+	config, err := NewLoader().Load(yamlPath)
+	require.NoError(t, err)
+	assert.Equal(t, "web.example.com", config.Targets[0].Host)
+	assert.Equal(t, "echo deploy", config.Jobs[0].Steps[0].Run)
+
+	hclPath := filepath.Join(dir, "nship.hcl.GZ")
+	writeGzipConfigFile(t, hclPath, `
+targets {
+  host     = "web.example.com"
+  user     = "deploy"
+  password = "secret"
+}
+jobs {
+  name = "deploy"
+  steps {
+    run = "echo $${HOME}"
+  }
+}
+`)
+
+	config, err = NewLoader().Load(hclPath)
+	require.NoError(t, err)
+	assert.Equal(t, "echo ${HOME}", config.Jobs[0].Steps[0].Run, "compressed HCL should keep its escapes for the parser")
+}
+
+func TestLoadGzipConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	corrupt := filepath.Join(dir, "nship.yaml.gz")
+	writeConfigFile(t, corrupt, "targets: []\n")
+	_, err := NewLoader().Load(corrupt)
+	assert.ErrorContains(t, err, "failed to decompress config file "+corrupt)
+
+	script := filepath.Join(dir, "nship.ts.gz")
+	writeGzipConfigFile(t, script, "export default {}")
+	_, err = NewLoader().Load(script)
+	assert.EqualError(t, err, "unsupported compressed config file extension: .ts.gz")
+
+	unknown := filepath.Join(dir, "nship.gz")
+	writeGzipConfigFile(t, unknown, "targets: []\n")
+	_, err = NewLoader().Load(unknown)
+	assert.EqualError(t, err, "unsupported compressed config file extension: .gz")
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v2"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/util"
+)
+
+// RedactedValue replaces secret values in redacted configurations
+const RedactedValue = "********"
+
+// Marshal serializes the configuration into the given format: yaml, json or toml.
+func Marshal(cfg *Config, format string) ([]byte, error) {
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		return yaml.Marshal(cfg)
+	case "json":
+		return json.MarshalIndent(cfg, "", "  ")
+	case "toml":
+		return toml.Marshal(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported output format: %s", format)
+	}
+}
+
+// Redact returns a copy of the configuration with target passwords, the values of every environment variable and
+// the notification webhook replaced by RedactedValue. Secrets of the config appearing in the commands of steps
+// and hooks are masked like in command output.
+func Redact(cfg *Config) (*Config, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy config: %w", err)
+	}
+
+	var redacted Config
+	if err := json.Unmarshal(data, &redacted); err != nil {
+		return nil, fmt.Errorf("failed to copy config: %w", err)
+	}
+
+	secrets := util.NewSecretRegistry()
+	secrets.Add(cfg.Secrets()...)
+
+	for _, tgt := range redacted.Targets {
+		if tgt.Password != "" {
+			tgt.Password = RedactedValue
+		}
+	}
+	for _, env := range redacted.envs() {
+		redactEnv(env)
+	}
+
+	redactJobs(append(redacted.Jobs, redacted.BeforeAll, redacted.AfterAll), secrets)
+
+	if redacted.Notify != nil {
+		redacted.Notify.Webhook = RedactedValue
+	}
+
+	return &redacted, nil
+}
+
+// redactJobs redacts the steps and hooks of jobs, skipping nil ones
+func redactJobs(jobs []*job.Job, secrets *util.SecretRegistry) {
+	for _, j := range jobs {
+		if j == nil {
+			continue
+		}
+		for _, step := range slices.Concat(j.Steps, j.BeforeJob, j.AfterJob, j.OnFailure) {
+			redactStep(step, secrets)
+		}
+	}
+}
+
+// redactStep replaces the environment variables of a step by RedactedValue and masks secrets in its commands
+func redactStep(step *job.Step, secrets *util.SecretRegistry) {
+	redactEnv(step.Env)
+	step.Run = secrets.Redact(step.Run)
+
+	if step.Docker != nil {
+		redactEnv(step.Docker.Environment)
+	}
+	if step.Exec != nil {
+		redactEnv(step.Exec.Env)
+		redactCommand(step.Exec.Command, secrets)
+	}
+	if step.DockerExec != nil {
+		redactCommand(step.DockerExec.Command, secrets)
+	}
+	if step.RunScript != nil {
+		redactCommand(step.RunScript.Args, secrets)
+	}
+	if step.Assert != nil {
+		step.Assert.Command = secrets.Redact(step.Assert.Command)
+	}
+}
+
+// redactEnv replaces every value of env by RedactedValue
+func redactEnv(env map[string]string) {
+	for k := range env {
+		env[k] = RedactedValue
+	}
+}
+
+// redactCommand masks secrets in each argument of a command
+func redactCommand(args []string, secrets *util.SecretRegistry) {
+	for i, arg := range args {
+		args[i] = secrets.Redact(arg)
+	}
+}
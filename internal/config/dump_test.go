@@ -0,0 +1,101 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+func dumpTestConfig() *Config {
+	return &Config{
+		Targets: []*target.Target{{Name: "web", Host: "web.example.com", User: "admin", Password: "secret"}},
+		Jobs: []*job.Job{{Name: "deploy", Steps: []*job.Step{
+			{Run: "echo deploy"},
+			{Docker: &job.DockerStep{Image: "nginx", Name: "web", Environment: map[string]string{"TOKEN": "abc"}}},
+		}}},
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	cfg := dumpTestConfig()
+
+	unmarshalers := map[string]func([]byte, interface{}) error{
+		"yaml": yaml.Unmarshal,
+		"json": json.Unmarshal,
+		"toml": toml.Unmarshal,
+	}
+
+	for format, unmarshal := range unmarshalers {
+		t.Run(format, func(t *testing.T) {
+			data, err := Marshal(cfg, format)
+			assert.NoError(t, err)
+
+			var parsed Config
+			assert.NoError(t, unmarshal(data, &parsed))
+			assert.Equal(t, "web.example.com", parsed.Targets[0].Host)
+			assert.Equal(t, "secret", parsed.Targets[0].Password)
+			assert.Equal(t, "echo deploy", parsed.Jobs[0].Steps[0].Run)
+			assert.Equal(t, "nginx", parsed.Jobs[0].Steps[1].Docker.Image)
+		})
+	}
+
+	_, err := Marshal(cfg, "xml")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported output format: xml")
+}
+
+func TestRedact(t *testing.T) {
+	cfg := dumpTestConfig()
+
+	redacted, err := Redact(cfg)
+	assert.NoError(t, err)
+
+	assert.Equal(t, RedactedValue, redacted.Targets[0].Password)
+	assert.Equal(t, RedactedValue, redacted.Jobs[0].Steps[1].Docker.Environment["TOKEN"])
+	assert.Equal(t, "web.example.com", redacted.Targets[0].Host)
+
+	// The original configuration must be left untouched
+	assert.Equal(t, "secret", cfg.Targets[0].Password)
+	assert.Equal(t, "abc", cfg.Jobs[0].Steps[1].Docker.Environment["TOKEN"])
+}
+
+func TestRedactEnvAndCommands(t *testing.T) {
+	cfg := dumpTestConfig()
+	cfg.Targets[0].Env = map[string]string{"REGION": "eu-west"}
+	cfg.Jobs[0].Steps = append(cfg.Jobs[0].Steps,
+		&job.Step{Run: "deploy --password secret", Env: map[string]string{"API_TOKEN": "t0ken"}},
+		&job.Step{Exec: &job.ExecStep{Command: []string{"notify", "secret"}, Env: map[string]string{"WEBHOOK": "https://hooks"}}})
+	cfg.Jobs[0].OnFailure = []*job.Step{{Run: "rollback", Env: map[string]string{"API_TOKEN": "t0ken"}}}
+	cfg.BeforeAll = &job.Job{Steps: []*job.Step{{Docker: &job.DockerStep{Image: "redis", Environment: map[string]string{"PASS": "abc"}}}}}
+
+	redacted, err := Redact(cfg)
+	assert.NoError(t, err)
+
+	assert.Equal(t, RedactedValue, redacted.Targets[0].Env["REGION"])
+	steps := redacted.Jobs[0].Steps
+	assert.Equal(t, "deploy --password ***", steps[2].Run, "target passwords should be masked in commands")
+	assert.Equal(t, RedactedValue, steps[2].Env["API_TOKEN"])
+	assert.Equal(t, []string{"notify", "***"}, steps[3].Exec.Command)
+	assert.Equal(t, RedactedValue, steps[3].Exec.Env["WEBHOOK"])
+	assert.Equal(t, RedactedValue, redacted.Jobs[0].OnFailure[0].Env["API_TOKEN"])
+	assert.Equal(t, RedactedValue, redacted.BeforeAll.Steps[0].Docker.Environment["PASS"])
+
+	assert.Equal(t, "deploy --password secret", cfg.Jobs[0].Steps[2].Run, "the original configuration must be left untouched")
+	assert.Equal(t, "eu-west", cfg.Targets[0].Env["REGION"])
+}
+
+func TestRedactNotifyWebhook(t *testing.T) {
+	cfg := dumpTestConfig()
+	cfg.Notify = &NotifyConfig{Webhook: "https://hooks.example.com/secret-token"}
+
+	redacted, err := Redact(cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, RedactedValue, redacted.Notify.Webhook)
+	assert.Equal(t, "https://hooks.example.com/secret-token", cfg.Notify.Webhook)
+}
@@ -0,0 +1,120 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// loadWithIncludes loads the config at configPath and merges every config it includes.
+// visited holds the absolute paths of the files currently being loaded and is used
+// to detect include cycles.
+func (l *DefaultLoader) loadWithIncludes(configPath string, visited map[string]bool) (*Config, error) {
+	absPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve config path: %w", err)
+	}
+
+	if visited[absPath] {
+		return nil, fmt.Errorf("include cycle detected: %s", configPath)
+	}
+	visited[absPath] = true
+	defer delete(visited, absPath)
+
+	config, err := l.loadConfigByExtension(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.mergeIncludes(config, filepath.Dir(configPath), visited); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// mergeIncludes loads the configs listed in config.Include, resolving relative
+// paths against baseDir, and appends their targets and jobs to config.
+func (l *DefaultLoader) mergeIncludes(config *Config, baseDir string, visited map[string]bool) error {
+	includes := config.Include
+	config.Include = nil
+
+	for _, include := range includes {
+		path := include
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+
+		included, err := l.loadWithIncludes(path, visited)
+		if err != nil {
+			return fmt.Errorf("failed to load included config %s: %w", include, err)
+		}
+
+		if err := mergeConfig(config, included); err != nil {
+			return fmt.Errorf("failed to merge included config %s: %w", include, err)
+		}
+	}
+
+	return nil
+}
+
+// mergeConfig appends the targets and jobs of src to dst.
+// Jobs are looked up by name, so a job name defined in both configs is an error,
+// and so are before_all, after_all or notify blocks defined in both.
+func mergeConfig(dst, src *Config) error {
+	if err := mergeGlobalHooks(dst, src); err != nil {
+		return err
+	}
+
+	if err := mergeNotify(dst, src); err != nil {
+		return err
+	}
+
+	jobNames := make(map[string]bool, len(dst.Jobs))
+	for _, j := range dst.Jobs {
+		if j.Name != "" {
+			jobNames[j.Name] = true
+		}
+	}
+
+	for _, j := range src.Jobs {
+		if j.Name != "" && jobNames[j.Name] {
+			return fmt.Errorf("duplicate job name '%s'", j.Name)
+		}
+	}
+
+	dst.Targets = append(dst.Targets, src.Targets...)
+	dst.Jobs = append(dst.Jobs, src.Jobs...)
+
+	return nil
+}
+
+// mergeNotify takes over the notify block of src unless dst already has one
+func mergeNotify(dst, src *Config) error {
+	if src.Notify == nil {
+		return nil
+	}
+	if dst.Notify != nil {
+		return fmt.Errorf("notify defined more than once")
+	}
+	dst.Notify = src.Notify
+	return nil
+}
+
+// mergeGlobalHooks takes over the before_all and after_all blocks of src unless dst already has them
+func mergeGlobalHooks(dst, src *Config) error {
+	if src.BeforeAll != nil {
+		if dst.BeforeAll != nil {
+			return fmt.Errorf("before_all defined more than once")
+		}
+		dst.BeforeAll = src.BeforeAll
+	}
+
+	if src.AfterAll != nil {
+		if dst.AfterAll != nil {
+			return fmt.Errorf("after_all defined more than once")
+		}
+		dst.AfterAll = src.AfterAll
+	}
+
+	return nil
+}
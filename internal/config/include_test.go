@@ -0,0 +1,169 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, path, content string) {
+	t.Helper()
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+}
+
+func TestLoadWithIncludes(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	writeConfigFile(t, filepath.Join(tmpDir, "nship.yaml"), `
+include:
+  - conf/targets.json
+  - conf/jobs.toml
+jobs:
+  - name: setup
+    steps:
+      - run: echo setup
+`)
+	writeConfigFile(t, filepath.Join(tmpDir, "conf", "targets.json"), `{
+  "targets": [{"name": "web", "host": "web.example.com", "user": "admin", "password": "secret"}]
+}`)
+	writeConfigFile(t, filepath.Join(tmpDir, "conf", "jobs.toml"), `
+include = ["more/deploy.yaml"]
+
+[[jobs]]
+name = "build"
+steps = [{ run = "echo build" }]
+`)
+	writeConfigFile(t, filepath.Join(tmpDir, "conf", "more", "deploy.yaml"), `
+jobs:
+  - name: deploy
+    steps:
+      - run: echo deploy
+`)
+
+	config, err := NewLoader().Load(filepath.Join(tmpDir, "nship.yaml"))
+	assert.NoError(t, err)
+
+	assert.Empty(t, config.Include)
+	assert.Len(t, config.Targets, 1)
+	assert.Equal(t, "web", config.Targets[0].Name)
+
+	names := make([]string, 0, len(config.Jobs))
+	for _, j := range config.Jobs {
+		names = append(names, j.Name)
+	}
+	assert.Equal(t, []string{"setup", "build", "deploy"}, names)
+}
+
+func TestLoadWithIncludesDuplicateJob(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	writeConfigFile(t, filepath.Join(tmpDir, "nship.yaml"), `
+include: [jobs.yaml]
+targets:
+  - host: web.example.com
+    user: admin
+    password: secret
+jobs:
+  - name: deploy
+    steps:
+      - run: echo one
+`)
+	writeConfigFile(t, filepath.Join(tmpDir, "jobs.yaml"), `
+jobs:
+  - name: deploy
+    steps:
+      - run: echo two
+`)
+
+	_, err := NewLoader().Load(filepath.Join(tmpDir, "nship.yaml"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "duplicate job name 'deploy'")
+}
+
+func TestLoadWithIncludesGlobalHooks(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	writeConfigFile(t, filepath.Join(tmpDir, "nship.yaml"), `
+include: [hooks.yaml]
+targets:
+  - host: web.example.com
+    user: admin
+    password: secret
+jobs:
+  - name: deploy
+    steps:
+      - run: echo deploy
+before_all:
+  steps:
+    - run: echo maintenance on
+`)
+	writeConfigFile(t, filepath.Join(tmpDir, "hooks.yaml"), `
+after_all:
+  steps:
+    - run: echo maintenance off
+`)
+
+	config, err := NewLoader().Load(filepath.Join(tmpDir, "nship.yaml"))
+	assert.NoError(t, err)
+	assert.Equal(t, "echo maintenance on", config.BeforeAll.Steps[0].Run)
+	assert.Equal(t, "echo maintenance off", config.AfterAll.Steps[0].Run)
+
+	writeConfigFile(t, filepath.Join(tmpDir, "hooks.yaml"), `
+before_all:
+  steps:
+    - run: echo again
+`)
+
+	_, err = NewLoader().Load(filepath.Join(tmpDir, "nship.yaml"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "before_all defined more than once")
+}
+
+func TestLoadWithIncludesCycle(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	writeConfigFile(t, filepath.Join(tmpDir, "a.yaml"), `
+include: [b.yaml]
+targets:
+  - host: web.example.com
+    user: admin
+    password: secret
+jobs:
+  - name: a
+    steps:
+      - run: echo a
+`)
+	writeConfigFile(t, filepath.Join(tmpDir, "b.yaml"), `
+include: [a.yaml]
+jobs:
+  - name: b
+    steps:
+      - run: echo b
+`)
+
+	_, err := NewLoader().Load(filepath.Join(tmpDir, "a.yaml"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "include cycle detected")
+}
+
+func TestLoadWithIncludesMissingFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	writeConfigFile(t, filepath.Join(tmpDir, "nship.yaml"), `
+include: [missing.yaml]
+targets:
+  - host: web.example.com
+    user: admin
+    password: secret
+jobs:
+  - steps:
+      - run: echo test
+`)
+
+	_, err := NewLoader().Load(filepath.Join(tmpDir, "nship.yaml"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to load included config missing.yaml")
+}
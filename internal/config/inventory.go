@@ -0,0 +1,72 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// inventoryOutput runs an inventory command in dir and returns its standard output without showing it,
+// as the targets it prints may hold passwords. Its standard error is shown.
+func inventoryOutput(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	return cmd.Output()
+}
+
+// loadInventory runs the inventory command of config, if any, and adds the targets it prints
+// to those of the config. The command runs in the directory of the config file.
+func (l *DefaultLoader) loadInventory(configPath string, config *Config) error {
+	if config.Inventory == "" {
+		return nil
+	}
+
+	args := strings.Fields(config.Inventory)
+	if len(args) == 0 {
+		return fmt.Errorf("invalid inventory command: %q", config.Inventory)
+	}
+
+	dir := "./"
+	if !strings.HasPrefix(configPath, "cmd:") {
+		dir = filepath.Dir(configPath)
+	}
+
+	output, err := l.invRunner(dir, args...)
+	if err != nil {
+		return fmt.Errorf("inventory command '%s' failed: %w", config.Inventory, err)
+	}
+
+	targets, err := parseInventory(output)
+	if err != nil {
+		return fmt.Errorf("inventory command '%s' printed invalid targets: %w", config.Inventory, err)
+	}
+
+	config.Targets = append(config.Targets, targets...)
+	return nil
+}
+
+// parseInventory parses the JSON array of targets printed by an inventory command. Lines the
+// command logs to standard output before the array are skipped.
+func parseInventory(output []byte) ([]*target.Target, error) {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+
+	err := errors.New("no JSON array of targets found")
+	for i, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "[") {
+			continue
+		}
+
+		var targets []*target.Target
+		if err = json.Unmarshal([]byte(strings.Join(lines[i:], "\n")), &targets); err == nil {
+			return targets, nil
+		}
+	}
+	return nil, err
+}
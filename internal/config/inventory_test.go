@@ -0,0 +1,97 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const inventoryConfig = `
+inventory: sh inventory.sh
+targets:
+  - name: local
+    host: localhost
+    user: admin
+    password: secret
+jobs:
+  - name: deploy
+    steps:
+      - run: echo deploy
+`
+
+func TestLoadWithInventory(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "nship.yaml")
+	writeConfigFile(t, configPath, inventoryConfig)
+	script := `echo "querying hosts" >&2
+cat <<'JSON'
+[
+  {"name": "web1", "host": "web1.example.com", "user": "deploy", "password": "secret"},
+  {"host": "web2.example.com", "user": "deploy", "password": "secret"}
+]
+JSON
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "inventory.sh"), []byte(script), 0600))
+
+	config, err := NewLoader().Load(configPath)
+	require.NoError(t, err)
+
+	names := make([]string, 0, len(config.Targets))
+	for _, tgt := range config.Targets {
+		names = append(names, tgt.Name)
+	}
+	assert.Equal(t, []string{"local", "web1", "web2.example.com"}, names)
+}
+
+func TestLoadWithInventoryErrors(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "nship.yaml")
+	writeConfigFile(t, configPath, inventoryConfig)
+
+	tests := []struct {
+		name        string
+		output      string
+		err         error
+		errContains string
+	}{
+		{
+			name:        "failing command",
+			output:      "no credentials",
+			err:         errors.New("exit status 1"),
+			errContains: "inventory command 'sh inventory.sh' failed: exit status 1",
+		},
+		{
+			name:        "invalid JSON",
+			output:      "web1.example.com\n",
+			errContains: "inventory command 'sh inventory.sh' printed invalid targets",
+		},
+		{
+			name:        "invalid target",
+			output:      `[{"name": "web1", "host": "web1.example.com"}]`,
+			errContains: "targets[1].user is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader := NewLoader().(*DefaultLoader)
+			loader.invRunner = func(dir string, args ...string) ([]byte, error) {
+				assert.Equal(t, filepath.Dir(configPath), dir)
+				assert.Equal(t, []string{"sh", "inventory.sh"}, args)
+				return []byte(tt.output), tt.err
+			}
+
+			_, err := loader.Load(configPath)
+			assert.ErrorContains(t, err, tt.errContains)
+		})
+	}
+}
+
+func TestInventoryOutput(t *testing.T) {
+	output, err := inventoryOutput(t.TempDir(), "sh", "-c", `echo "querying hosts" >&2; echo '[]'`)
+	require.NoError(t, err)
+	assert.Equal(t, "[]\n", string(output), "standard error should not be mixed into the targets")
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -11,9 +12,14 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"unicode"
 
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
 	"github.com/pelletier/go-toml/v2"
 
+	"github.com/nickalie/nship/internal/core/job"
+
 	"github.com/evanw/esbuild/pkg/api"
 	"github.com/go-playground/validator/v10"
 	"gopkg.in/yaml.v2"
@@ -29,18 +35,47 @@ type Loader interface {
 
 // DefaultLoader implements the Loader interface using file-based configuration.
 type DefaultLoader struct {
-	validator *validator.Validate
-	loaders   map[string]func(string) (*Config, error)
-	cmdRunner CommandRunner
+	validator     *validator.Validate
+	loaders       map[string]func(string) (*Config, error)
+	cmdRunner     CommandRunner
+	invRunner     CommandRunner
+	sopsDecrypter SOPSDecrypter
+	profile       string
+	// profileEnv holds the variables of the selected profile while a config is loaded
+	profileEnv map[string]string
+	template   bool
+}
+
+// LoaderOption represents an option for configuring a DefaultLoader
+type LoaderOption func(*DefaultLoader)
+
+// WithProfile selects the profile of the config that is merged over it. An empty name selects none.
+func WithProfile(name string) LoaderOption {
+	return func(l *DefaultLoader) {
+		l.profile = name
+	}
+}
+
+// WithTemplate renders every config file as a Go template before it is parsed, not only
+// the files starting with the "# nship:template" header
+func WithTemplate(enabled bool) LoaderOption {
+	return func(l *DefaultLoader) {
+		l.template = enabled
+	}
 }
 
 // NewLoader creates a new configuration loader with default implementations.
-func NewLoader() Loader {
-	validate := validator.New()
+func NewLoader(opts ...LoaderOption) Loader {
 	loader := &DefaultLoader{
-		validator: validate,
-		loaders:   make(map[string]func(string) (*Config, error)),
-		cmdRunner: execCommand,
+		validator:     newValidator(),
+		loaders:       make(map[string]func(string) (*Config, error)),
+		cmdRunner:     execCommand,
+		invRunner:     inventoryOutput,
+		sopsDecrypter: NewSOPSDecrypter(),
+	}
+
+	for _, opt := range opts {
+		opt(loader)
 	}
 
 	// Register default loaders
@@ -52,6 +87,7 @@ func NewLoader() Loader {
 	loader.loaders[".go"] = loader.loadGolangConfig
 	loader.loaders[".json"] = loader.loadJSONConfig
 	loader.loaders[".toml"] = loader.loadTOMLConfig
+	loader.loaders[".hcl"] = loader.loadHCLConfig
 
 	return loader
 }
@@ -72,8 +108,9 @@ func execCommand(dir string, args ...string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to create stderr pipe: %w", err)
 	}
 
-	// Buffer to collect all output for return
+	// Buffer to collect all output for return, written by both readers
 	var outputBuffer bytes.Buffer
+	var outputMu sync.Mutex
 
 	// Create wait groups to ensure all goroutines complete before we finish
 	var wg sync.WaitGroup
@@ -91,7 +128,9 @@ func execCommand(dir string, args ...string) ([]byte, error) {
 		for scanner.Scan() {
 			line := scanner.Text()
 			fmt.Println(line)
+			outputMu.Lock()
 			outputBuffer.WriteString(line + "\n")
+			outputMu.Unlock()
 		}
 	}()
 
@@ -102,7 +141,9 @@ func execCommand(dir string, args ...string) ([]byte, error) {
 		for scanner.Scan() {
 			line := scanner.Text()
 			fmt.Fprintln(os.Stderr, line)
+			outputMu.Lock()
 			outputBuffer.WriteString(line + "\n")
+			outputMu.Unlock()
 		}
 	}()
 
@@ -118,8 +159,38 @@ func execCommand(dir string, args ...string) ([]byte, error) {
 	return outputBuffer.Bytes(), nil
 }
 
-// Load loads and validates configuration from the specified path.
+// Load loads and validates configuration from the specified path, merging the selected profile over it
+// and adding the targets of its inventory command. The secrets of the config are masked in command output.
 func (l *DefaultLoader) Load(configPath string) (*Config, error) {
+	l.profileEnv = nil
+	defer func() { l.profileEnv = nil }()
+
+	config, err := l.loadUnvalidated(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.profile != "" {
+		config, err = l.applyProfile(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := l.loadInventory(configPath, config); err != nil {
+		return nil, err
+	}
+
+	if err := l.validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// loadUnvalidated loads the configuration from the specified path, or the output of the command
+// following a "cmd:" prefix, together with the configs it includes
+func (l *DefaultLoader) loadUnvalidated(configPath string) (*Config, error) {
 	// Check if the path has "cmd:" prefix
 	if strings.HasPrefix(configPath, "cmd:") {
 		// Extract the command part (everything after "cmd:")
@@ -136,7 +207,7 @@ func (l *DefaultLoader) Load(configPath string) (*Config, error) {
 			return nil, err
 		}
 
-		if err := l.validateConfig(config); err != nil {
+		if err := l.mergeIncludes(config, ".", make(map[string]bool)); err != nil {
 			return nil, err
 		}
 
@@ -144,21 +215,16 @@ func (l *DefaultLoader) Load(configPath string) (*Config, error) {
 	}
 
 	// Regular file-based loading
-	config, err := l.loadConfigByExtension(configPath)
-	if err != nil {
-		return nil, err
-	}
-
-	if err := l.validateConfig(config); err != nil {
-		return nil, err
-	}
-
-	return config, nil
+	return l.loadWithIncludes(configPath, make(map[string]bool))
 }
 
-// loadConfigByExtension loads configuration based on file extension
+// loadConfigByExtension loads configuration based on file extension. Gzip-compressed configs are
+// loaded based on the extension before .gz, e.g. nship.yaml.gz as YAML.
 func (l *DefaultLoader) loadConfigByExtension(configPath string) (*Config, error) {
-	ext := strings.ToLower(filepath.Ext(configPath))
+	ext := configExt(configPath)
+	if isCompressed(configPath) && !compressibleFormats[ext] {
+		return nil, fmt.Errorf("unsupported compressed config file extension: %s%s", ext, gzipExt)
+	}
 
 	loader, ok := l.loaders[ext]
 	if !ok {
@@ -170,14 +236,39 @@ func (l *DefaultLoader) loadConfigByExtension(configPath string) (*Config, error
 
 // validateConfig validates the configuration structure
 func (l *DefaultLoader) validateConfig(config *Config) error {
-	if err := l.validator.Struct(config); err != nil {
+	return validateConfig(l.validator, config)
+}
+
+// Validate validates the configuration like it is validated when loaded, setting the default
+// names of unnamed jobs and targets. The error lists every problem found.
+func (c *Config) Validate() error {
+	return validateConfig(newValidator(), c)
+}
+
+// validateConfig validates the configuration structure with validate and sets default names
+func validateConfig(validate *validator.Validate, config *Config) error {
+	if err := validate.Struct(config); err != nil {
 		if validationErrors, ok := err.(validator.ValidationErrors); ok {
 			return fmt.Errorf("config validation failed: %s", formatValidationErrors(validationErrors))
 		}
 		return fmt.Errorf("config validation failed: %w", err)
 	}
 
-	// Ensure job and target names are set
+	applyDefaultNames(config)
+
+	if err := checkDuplicateNames(config); err != nil {
+		return fmt.Errorf("config validation failed: %w", err)
+	}
+
+	if _, err := job.ExpandMatrix(config.Jobs); err != nil {
+		return fmt.Errorf("config validation failed: %w", err)
+	}
+
+	return nil
+}
+
+// applyDefaultNames ensures job and target names are set, as well as the host of local targets
+func applyDefaultNames(config *Config) {
 	for i, job := range config.Jobs {
 		if job.Name == "" {
 			job.Name = fmt.Sprintf("job-%d", i+1)
@@ -185,36 +276,195 @@ func (l *DefaultLoader) validateConfig(config *Config) error {
 	}
 
 	for _, target := range config.Targets {
+		if target.Local && target.Host == "" {
+			target.Host = "localhost"
+		}
 		if target.Name == "" {
 			target.Name = target.Host
 		}
 	}
+}
+
+// checkDuplicateNames returns an error listing every target and job name used more than once
+func checkDuplicateNames(config *Config) error {
+	targetNames := make([]string, 0, len(config.Targets))
+	for _, target := range config.Targets {
+		targetNames = append(targetNames, target.Name)
+	}
+
+	jobNames := make([]string, 0, len(config.Jobs))
+	for _, job := range config.Jobs {
+		jobNames = append(jobNames, job.Name)
+	}
+
+	var errMsgs []string
+	for _, name := range findDuplicates(targetNames) {
+		errMsgs = append(errMsgs, fmt.Sprintf("duplicate target name '%s'", name))
+	}
+	for _, name := range findDuplicates(jobNames) {
+		errMsgs = append(errMsgs, fmt.Sprintf("duplicate job name '%s'", name))
+	}
+
+	if len(errMsgs) > 0 {
+		return errors.New(strings.Join(errMsgs, "\n"))
+	}
 
 	return nil
 }
 
+// findDuplicates returns the names that occur more than once, in order of first occurrence
+func findDuplicates(names []string) []string {
+	counts := make(map[string]int, len(names))
+	var duplicates []string
+
+	for _, name := range names {
+		counts[name]++
+		if counts[name] == 2 {
+			duplicates = append(duplicates, name)
+		}
+	}
+
+	return duplicates
+}
+
 // formatValidationErrors formats validation errors into a readable string
 func formatValidationErrors(errs validator.ValidationErrors) string {
 	errMsgs := make([]string, 0, len(errs))
 	for _, err := range errs {
-		errMsgs = append(errMsgs, fmt.Sprintf(
-			"Field '%s' failed validation: %s (condition: %s)",
-			err.Field(),
-			err.Tag(),
-			err.Param(),
-		))
+		errMsgs = append(errMsgs, formatValidationError(err))
 	}
 	return strings.Join(errMsgs, "\n")
 }
 
+// validationMessages maps validation tags to functions producing readable messages for them
+var validationMessages = map[string]func(path string, err validator.FieldError) string{
+	"step_action": func(path string, _ validator.FieldError) string {
+		return fmt.Sprintf("%s: exactly one of run/script_file/run_script/copy/download/docker/docker_prune/docker_exec/"+
+			"service/assert/wait/exec required", strings.TrimSuffix(path, "."))
+	},
+	"step_command": func(path string, _ validator.FieldError) string {
+		return fmt.Sprintf("%s can only be used with run, script_file and run_script steps", path)
+	},
+	"required": func(path string, _ validator.FieldError) string {
+		return fmt.Sprintf("%s is required", path)
+	},
+	// Fields such as the host of targets are only optional with a flag set, so they are reported as required
+	"required_unless": func(path string, _ validator.FieldError) string {
+		return fmt.Sprintf("%s is required", path)
+	},
+	"required_without": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s is required when %s is not set", path, toSnakeCase(err.Param()))
+	},
+	"hostname|ip": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s must be a valid hostname or IP address, got '%v'", path, err.Value())
+	},
+	"file": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s must point to an existing file, got '%v'", path, err.Value())
+	},
+	"url": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s must be a valid URL, got '%v'", path, err.Value())
+	},
+	"min": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s must be at least %s", path, err.Param())
+	},
+	"max": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s must be at most %s", path, err.Param())
+	},
+	"oneof": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s must be one of [%s], got '%v'", path, err.Param(), err.Value())
+	},
+	"docker_port": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s: invalid docker port mapping '%v', expected [ip:]host:container[/proto]", path, err.Value())
+	},
+	"docker_volume": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s: invalid docker volume mapping '%v', expected src:dst[:opts]", path, err.Value())
+	},
+	"docker_memory": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s: invalid docker memory limit '%v', expected e.g. 512m or 2g", path, err.Value())
+	},
+	"docker_digest": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s: invalid image digest '%v', expected sha256: followed by 64 hexadecimal digits", path, err.Value())
+	},
+	"excluded_with": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s can't be used together with %s", path, toSnakeCase(err.Param()))
+	},
+	"excluded_without": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s can only be used when %s is set", path, toSnakeCase(err.Param()))
+	},
+	"env_name": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s: invalid environment variable name '%v'", path, err.Value())
+	},
+	"condition": func(path string, err validator.FieldError) string {
+		_, parseErr := job.ParseCondition(fmt.Sprint(err.Value()))
+		return fmt.Sprintf("%s: invalid condition '%v': %v", path, err.Value(), parseErr)
+	},
+	"ssh_cipher": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s: unsupported SSH cipher '%v'", path, err.Value())
+	},
+	"ssh_mac": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s: unsupported SSH MAC '%v'", path, err.Value())
+	},
+	"ssh_kex": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s: unsupported SSH key exchange algorithm '%v'", path, err.Value())
+	},
+	"ssh_host_key": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s: unsupported SSH host key algorithm '%v'", path, err.Value())
+	},
+	"container_path": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s must be an absolute path when copying into a container, got '%v'", path, err.Value())
+	},
+	"container_delete": func(path string, _ validator.FieldError) string {
+		return fmt.Sprintf("%s cannot be used when copying into a container", path)
+	},
+	"service_verify": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s can only be used with the start, restart and reload actions, got '%s'", path, err.Param())
+	},
+	"duration": func(path string, err validator.FieldError) string {
+		return fmt.Sprintf("%s: invalid duration '%v', expected e.g. 5s or 1m30s", path, err.Value())
+	},
+}
+
+// formatValidationError formats a single validation error, prefixed with the
+// path of the offending field, e.g. "targets[2].user is required"
+func formatValidationError(err validator.FieldError) string {
+	path := strings.TrimPrefix(err.Namespace(), "Config.")
+
+	if format, ok := validationMessages[err.Tag()]; ok {
+		return format(path, err)
+	}
+
+	if err.Param() != "" {
+		return fmt.Sprintf("%s failed validation: %s (condition: %s)", path, err.Tag(), err.Param())
+	}
+	return fmt.Sprintf("%s failed validation: %s", path, err.Tag())
+}
+
+// toSnakeCase converts a Go field name such as PrivateKey to its config key private_key
+func toSnakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
 // loadJSONConfig loads configuration from JSON file
 func (l *DefaultLoader) loadJSONConfig(configPath string) (*Config, error) {
-	data, err := os.ReadFile(configPath)
+	data, err := l.readConfigFile(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return nil, err
 	}
 
-	dataStr := replaceEnvVariables(string(data))
+	dataStr, err := l.preprocess(configPath, data)
+	if err != nil {
+		return nil, err
+	}
 
 	var config Config
 	if err := json.Unmarshal([]byte(dataStr), &config); err != nil {
@@ -224,14 +474,31 @@ func (l *DefaultLoader) loadJSONConfig(configPath string) (*Config, error) {
 	return &config, nil
 }
 
+// readConfigFile reads a config file, decompressing and then decrypting it if it is SOPS encrypted
+func (l *DefaultLoader) readConfigFile(configPath string) ([]byte, error) {
+	data, err := readFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsSOPSEncrypted(data, SOPSFormat(uncompressedPath(configPath))) {
+		return data, nil
+	}
+
+	return decryptSOPS(uncompressedPath(configPath), data, l.sopsDecrypter)
+}
+
 // loadTOMLConfig loads configuration from TOML file
 func (l *DefaultLoader) loadTOMLConfig(configPath string) (*Config, error) {
-	data, err := os.ReadFile(configPath)
+	data, err := readFile(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return nil, err
 	}
 
-	dataStr := replaceEnvVariables(string(data))
+	dataStr, err := l.preprocess(configPath, data)
+	if err != nil {
+		return nil, err
+	}
 
 	var config Config
 	if err := toml.Unmarshal([]byte(dataStr), &config); err != nil {
@@ -241,14 +508,59 @@ func (l *DefaultLoader) loadTOMLConfig(configPath string) (*Config, error) {
 	return &config, nil
 }
 
+// loadHCLConfig loads configuration from HCL file
+func (l *DefaultLoader) loadHCLConfig(configPath string) (*Config, error) {
+	data, err := readFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dataStr, err := l.preprocess(configPath, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	if err := decodeHCL([]byte(dataStr), configPath, &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// decodeHCL decodes the HCL config in data, read from filename, into config, moving its profiles
+// blocks to the profiles of the config
+func decodeHCL(data []byte, filename string, config *Config) error {
+	file, diags := hclparse.NewParser().ParseHCL(data, filename)
+	if diags.HasErrors() {
+		return fmt.Errorf("failed to parse HCL: %w", diags)
+	}
+
+	if diags := gohcl.DecodeBody(file.Body, nil, config); diags.HasErrors() {
+		return fmt.Errorf("failed to parse HCL: %w", diags)
+	}
+
+	for _, profile := range config.HCLProfiles {
+		if config.Profiles == nil {
+			config.Profiles = make(map[string]*Profile)
+		}
+		config.Profiles[profile.Name] = &Profile{Targets: profile.Targets, Env: profile.Env}
+	}
+	config.HCLProfiles = nil
+	return nil
+}
+
 // loadYAMLConfig loads configuration from YAML file
 func (l *DefaultLoader) loadYAMLConfig(configPath string) (*Config, error) {
-	data, err := os.ReadFile(configPath)
+	data, err := l.readConfigFile(configPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return nil, err
 	}
 
-	dataStr := replaceEnvVariables(string(data))
+	dataStr, err := l.preprocess(configPath, data)
+	if err != nil {
+		return nil, err
+	}
 
 	var config Config
 	if err := yaml.Unmarshal([]byte(dataStr), &config); err != nil {
@@ -338,11 +650,39 @@ func (l *DefaultLoader) loadCmdConfig(dir string, args ...string) (*Config, erro
 	return &config, nil
 }
 
-// replaceEnvVariables replaces environment variables in the content
-func replaceEnvVariables(content string) string {
-	re := regexp.MustCompile(`\${(\w+)}`)
-	return re.ReplaceAllStringFunc(content, func(s string) string {
-		key := re.FindStringSubmatch(s)[1]
-		return os.Getenv(key)
+// preprocess renders the content of the config at configPath as a Go template if templating is
+// enabled or the content starts with the template header, and then replaces environment variables in it.
+// The variables of the selected profile, which the first config defining it sets, take precedence.
+func (l *DefaultLoader) preprocess(configPath string, data []byte) (string, error) {
+	content := string(data)
+	if l.template || hasTemplateHeader(content) {
+		rendered, err := renderTemplate(configPath, content)
+		if err != nil {
+			return "", err
+		}
+		content = rendered
+	}
+	l.resolveProfileEnv(configPath, content)
+	// HCL unescapes $${ itself, so its escapes are left for the parser
+	return replaceEnvVariables(content, configExt(configPath) == ".hcl", l.getenv), nil
+}
+
+// envVariablePattern matches ${VAR} references and $$ escapes
+var envVariablePattern = regexp.MustCompile(`\$\$|\$\{(\w+)\}`)
+
+// replaceEnvVariables replaces ${VAR} references in the content with the variables returned by getenv. $$ is
+// a literal $, so $${VAR} is the literal text ${VAR}. With keepEscapes, $$ is left as is.
+func replaceEnvVariables(content string, keepEscapes bool, getenv func(string) string) string {
+	return envVariablePattern.ReplaceAllStringFunc(content, func(s string) string {
+		switch {
+		case s == "$$" && keepEscapes:
+			return s
+		case s == "$$":
+			return "$"
+		case s == job.ErrorVariable:
+			// Substituted when on_failure hooks run
+			return s
+		}
+		return getenv(envVariablePattern.FindStringSubmatch(s)[1])
 	})
 }
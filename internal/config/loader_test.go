@@ -12,8 +12,8 @@ import (
 	"testing"
 	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/nickalie/nship/internal/core/job"
 	"github.com/nickalie/nship/internal/core/target"
@@ -79,6 +79,7 @@ jobs:
 	// Second target
 	assert.Equal(t, "db.example.com", config.Targets[1].Host, "Incorrect second target host")
 	assert.Equal(t, "password123", config.Targets[1].Password, "Incorrect second target password")
+	assert.Contains(t, config.Secrets(), "password123", "Target password should be a secret")
 
 	// Verify jobs
 	assert.Len(t, config.Jobs, 2, "Expected 2 jobs")
@@ -103,6 +104,55 @@ jobs:
 	assert.Equal(t, "nginx:latest", dockerStep.Image, "Incorrect docker image")
 }
 
+func TestLoadRunCommandList(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "nship.yaml")
+	content := `
+targets:
+  - host: example.com
+    user: deploy
+    password: secret
+jobs:
+  - name: deploy
+    steps:
+      - run: ./deploy && ./restart
+      - run:
+          - cd /opt/app
+          - git pull
+          - make install
+        sudo: true
+`
+	assert.NoError(t, os.WriteFile(configPath, []byte(content), 0600))
+
+	config, err := NewLoader().Load(configPath)
+	assert.NoError(t, err)
+
+	steps := config.Jobs[0].Steps
+	assert.Equal(t, "./deploy && ./restart", steps[0].Run, "the string form should be kept as it is")
+	assert.Equal(t, "cd /opt/app\ngit pull\nmake install", steps[1].Run)
+	assert.True(t, steps[1].Sudo, "the other fields of the step should still be loaded")
+
+	jsonPath := filepath.Join(t.TempDir(), "nship.json")
+	assert.NoError(t, os.WriteFile(jsonPath, []byte(`{
+		"targets": [{"host": "example.com", "user": "deploy", "password": "secret"}],
+		"jobs": [{"name": "deploy", "steps": [{"run": ["git pull", "make install"], "workdir": "/opt/app"}]}]
+	}`), 0600))
+
+	config, err = NewLoader().Load(jsonPath)
+	assert.NoError(t, err)
+	assert.Equal(t, "git pull\nmake install", config.Jobs[0].Steps[0].Run)
+	assert.Equal(t, "/opt/app", config.Jobs[0].Steps[0].Workdir)
+}
+
+func TestLoadRunCommandListErrors(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "nship.yaml")
+	content := "targets:\n  - host: example.com\n    user: deploy\n    password: secret\n" +
+		"jobs:\n  - name: deploy\n    steps:\n      - run:\n          - make\n          - install: now\n"
+	assert.NoError(t, os.WriteFile(configPath, []byte(content), 0600))
+
+	_, err := NewLoader().Load(configPath)
+	assert.ErrorContains(t, err, "run must be a command or a list of commands")
+}
+
 func TestReplaceEnvVariables(t *testing.T) {
 	// Set environment variables for testing
 	os.Setenv("TEST_HOST", "test.example.com")
@@ -119,7 +169,7 @@ targets:
 `
 
 	// Apply environment variable substitution
-	result := replaceEnvVariables(content)
+	result := replaceEnvVariables(content, false, os.Getenv)
 
 	// Verify substitutions
 	assert.Contains(t, result, "test.example.com", "Expected content to contain substituted host")
@@ -131,16 +181,72 @@ targets:
 
 	// Test with non-existent environment variable
 	content = "host: ${NONEXISTENT_VAR}"
-	result = replaceEnvVariables(content)
+	result = replaceEnvVariables(content, false, os.Getenv)
 
 	// Non-existent variables should be replaced with empty string
 	assert.NotContains(t, result, "${NONEXISTENT_VAR}", "Non-existent environment variable was not replaced")
 	assert.Contains(t, result, "host: ", "Expected 'host: ' after replacement")
+
+	// ${error} is kept for on_failure hooks
+	result = replaceEnvVariables(`run: echo "${error}"`, false, os.Getenv)
+	assert.Equal(t, `run: echo "${error}"`, result)
+}
+
+func TestReplaceEnvVariablesEscapes(t *testing.T) {
+	t.Setenv("TEST_APP", "shop")
+
+	tests := []struct {
+		name        string
+		content     string
+		keepEscapes bool
+		want        string
+	}{
+		{"escaped reference", `run: echo '$${NOT_A_VAR}'`, false, `run: echo '${NOT_A_VAR}'`},
+		{"escaped dollar", `run: echo $$HOME costs 5$$`, false, `run: echo $HOME costs 5$`},
+		{"escape before variable", `path: $$$${TEST_APP}/$${TEST_APP}`, false, `path: $${TEST_APP}/${TEST_APP}`},
+		{"dollar before variable", `path: $$${TEST_APP}`, false, `path: $shop`},
+		{"variables still expand", `name: ${TEST_APP}-$${TEST_APP}`, false, `name: shop-${TEST_APP}`},
+		{"single dollar untouched", `run: echo $HOME $1`, false, `run: echo $HOME $1`},
+		{"escapes kept", `run = "echo $${error} $$"`, true, `run = "echo $${error} $$"`},
+		{"variables expand with escapes kept", `host = "${TEST_APP}"`, true, `host = "shop"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, replaceEnvVariables(tt.content, tt.keepEscapes, os.Getenv))
+		})
+	}
+}
+
+func TestLoadEscapedEnvVariables(t *testing.T) {
+	t.Setenv("TEST_APP", "shop")
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "nship.yaml")
+	yamlContent := "targets:\n  - host: ${TEST_APP}.example.com\n    user: deploy\n    password: secret\n" +
+		"jobs:\n  - name: deploy\n    steps:\n      - run: echo '$${TEST_APP}' $$PPID\n"
+	require.NoError(t, os.WriteFile(yamlPath, []byte(yamlContent), 0600))
+
+	config, err := NewLoader().Load(yamlPath)
+	require.NoError(t, err)
+	assert.Equal(t, "shop.example.com", config.Targets[0].Host)
+	assert.Equal(t, "echo '${TEST_APP}' $PPID", config.Jobs[0].Steps[0].Run)
+
+	// HCL unescapes $${ itself
+	hclPath := filepath.Join(dir, "nship.hcl")
+	hclContent := "targets {\n  host = \"${TEST_APP}.example.com\"\n  user = \"deploy\"\n  password = \"secret\"\n}\n" +
+		"jobs {\n  name = \"deploy\"\n  steps {\n    run = \"echo '$${TEST_APP}'\"\n  }\n}\n"
+	require.NoError(t, os.WriteFile(hclPath, []byte(hclContent), 0600))
+
+	config, err = NewLoader().Load(hclPath)
+	require.NoError(t, err)
+	assert.Equal(t, "shop.example.com", config.Targets[0].Host)
+	assert.Equal(t, "echo '${TEST_APP}'", config.Jobs[0].Steps[0].Run)
 }
 
 // setupTestLoader creates a test loader with a mock command runner
 func setupTestLoader(cmdOutput []byte, cmdErr error) *DefaultLoader {
-	validate := validator.New()
+	validate := newValidator()
 	loader := &DefaultLoader{
 		validator: validate,
 		loaders:   make(map[string]func(string) (*Config, error)),
@@ -409,7 +515,7 @@ func TestAutoNaming(t *testing.T) {
 
 	// Create loader for validation
 	loader := &DefaultLoader{
-		validator: validator.New(),
+		validator: newValidator(),
 	}
 
 	// Validate should set default names
@@ -702,6 +808,11 @@ func TestLoadConfigFormats(t *testing.T) {
 			fileExtension: "toml",
 			configPath:    "config.toml",
 		},
+		{
+			format:        "HCL",
+			fileExtension: "hcl",
+			configPath:    "config.hcl",
+		},
 	}
 
 	for _, tc := range testCases {
@@ -785,6 +896,55 @@ steps = [
   { copy = { local = "./config/nginx.conf", remote = "/etc/nginx/nginx.conf" } },
   { docker = { image = "nginx:latest", name = "web", ports = ["80:80"] } }
 ]
+`
+			case "HCL":
+				configContent = `
+# HCL Configuration
+
+targets {
+  name        = "web-server"
+  host        = "web.example.com"
+  user        = "admin"
+  port        = 2222
+  private_key = "` + safePath + `"
+}
+
+targets {
+  host     = "db.example.com"
+  user     = "admin"
+  password = "password123"
+}
+
+jobs {
+  name = "setup"
+
+  steps {
+    run = "mkdir -p /var/www"
+  }
+
+  steps {
+    run = "chown www-data:www-data /var/www"
+  }
+}
+
+jobs {
+  name = "deploy"
+
+  steps {
+    copy {
+      local  = "./config/nginx.conf"
+      remote = "/etc/nginx/nginx.conf"
+    }
+  }
+
+  steps {
+    docker {
+      image = "nginx:latest"
+      name  = "web"
+      ports = ["80:80"]
+    }
+  }
+}
 `
 			}
 
@@ -874,6 +1034,26 @@ name = "test-job"
 steps = [
   { run = "echo 'Hello World'" }
 ]
+`,
+		},
+		{
+			format:        "HCL",
+			fileExtension: "hcl",
+			configContent: `
+targets {
+  host     = "${TEST_HOST}"
+  user     = "${TEST_USER}"
+  port     = ${TEST_PORT}
+  password = "secret"
+}
+
+jobs {
+  name = "test-job"
+
+  steps {
+    run = "echo 'Hello World'"
+  }
+}
 `,
 		},
 	}
@@ -905,6 +1085,86 @@ steps = [
 	}
 }
 
+func TestLoadHCLDockerConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.hcl")
+	configContent := `
+targets {
+  host     = "web.example.com"
+  user     = "admin"
+  password = "secret"
+}
+
+jobs {
+  name = "deploy"
+
+  steps {
+    shell = "bash"
+
+    docker {
+      image       = "myapp:latest"
+      name        = "myapp"
+      ports       = ["8080:80"]
+      volumes     = ["/data:/data"]
+      networks    = ["backend"]
+      command     = ["npm", "start"]
+      restart     = "always"
+      environment = { NODE_ENV = "production" }
+      labels      = { app = "myapp" }
+
+      build {
+        context = "./app"
+        args    = { VERSION = "1.0.0" }
+      }
+    }
+  }
+
+  steps {
+    copy {
+      local   = "./dist"
+      remote  = "/var/www"
+      exclude = ["*.map"]
+    }
+  }
+}
+`
+	assert.NoError(t, os.WriteFile(configPath, []byte(configContent), 0644))
+
+	config, err := NewLoader().Load(configPath)
+	assert.NoError(t, err)
+
+	docker := config.Jobs[0].Steps[0].Docker
+	assert.NotNil(t, docker)
+	assert.Equal(t, "bash", config.Jobs[0].Steps[0].Shell)
+	assert.Equal(t, "myapp:latest", docker.Image)
+	assert.Equal(t, []string{"8080:80"}, docker.Ports)
+	assert.Equal(t, []string{"/data:/data"}, docker.Volumes)
+	assert.Equal(t, []string{"backend"}, docker.Networks)
+	assert.Equal(t, []string{"npm", "start"}, docker.Command)
+	assert.Equal(t, "always", docker.Restart)
+	assert.Equal(t, map[string]string{"NODE_ENV": "production"}, docker.Environment)
+	assert.Equal(t, map[string]string{"app": "myapp"}, docker.Labels)
+	assert.NotNil(t, docker.Build)
+	assert.Equal(t, "./app", docker.Build.Context)
+	assert.Equal(t, map[string]string{"VERSION": "1.0.0"}, docker.Build.Args)
+
+	copyStep := config.Jobs[0].Steps[1].Copy
+	assert.NotNil(t, copyStep)
+	assert.Equal(t, []string{"*.map"}, copyStep.Exclude)
+}
+
+func TestInvalidHCLConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "invalid.hcl")
+	assert.NoError(t, os.WriteFile(configPath, []byte(`targets {
+  host = "web.example.com"
+`), 0644))
+
+	_, err := NewLoader().Load(configPath)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse HCL")
+}
+
 func TestInvalidJSONConfig(t *testing.T) {
 	// Create temporary invalid JSON config file
 	tmpDir, err := os.MkdirTemp("", "config-test-invalid-json")
@@ -1003,7 +1263,7 @@ func TestLoadCmdConfig(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			// Setup test loader with mock command runner
 			loader := &DefaultLoader{
-				validator: validator.New(),
+				validator: newValidator(),
 				loaders:   make(map[string]func(string) (*Config, error)),
 				cmdRunner: func(dir string, args ...string) ([]byte, error) {
 					// Return our predefined output/error
@@ -1065,7 +1325,7 @@ func TestComplexCmdConfig(t *testing.T) {
 	}
 
 	loader := &DefaultLoader{
-		validator: validator.New(),
+		validator: newValidator(),
 		loaders:   make(map[string]func(string) (*Config, error)),
 		cmdRunner: cmdRunner,
 	}
@@ -1094,7 +1354,7 @@ func TestCmdConfigValidation(t *testing.T) {
 	}
 
 	loader := &DefaultLoader{
-		validator: validator.New(),
+		validator: newValidator(),
 		loaders:   make(map[string]func(string) (*Config, error)),
 		cmdRunner: func(dir string, args ...string) ([]byte, error) {
 			return mockValidOutput(invalidConfig), nil
@@ -1109,3 +1369,128 @@ func TestCmdConfigValidation(t *testing.T) {
 	assert.Contains(t, err.Error(), "config validation failed",
 		"Error should mention validation failure")
 }
+
+func TestLoadMultipleValidationErrors(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "nship.yaml")
+	content := `
+targets:
+  - user: deploy
+    password: secret
+  - host: example.com
+    password: secret
+jobs:
+  - name: deploy
+    steps:
+      - run: make install
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0600))
+
+	var err error
+	assert.NotPanics(t, func() {
+		_, err = NewLoader().Load(configPath)
+	})
+	assert.EqualError(t, err, "config validation failed: targets[0].host is required\ntargets[1].user is required")
+}
+
+func TestDuplicateNames(t *testing.T) {
+	testCases := []struct {
+		name          string
+		configContent string
+		errContains   []string
+	}{
+		{
+			name: "unique names",
+			configContent: `
+targets:
+  - name: web
+    host: web.example.com
+    user: admin
+    password: secret
+  - host: db.example.com
+    user: admin
+    password: secret
+jobs:
+  - name: setup
+    steps:
+      - run: echo setup
+  - steps:
+      - run: echo unnamed
+`,
+		},
+		{
+			name: "duplicate target names",
+			configContent: `
+targets:
+  - name: web
+    host: web1.example.com
+    user: admin
+    password: secret
+  - name: web
+    host: web2.example.com
+    user: admin
+    password: secret
+jobs:
+  - steps:
+      - run: echo test
+`,
+			errContains: []string{"duplicate target name 'web'"},
+		},
+		{
+			name: "duplicate default target names",
+			configContent: `
+targets:
+  - host: web.example.com
+    user: admin
+    password: secret
+  - host: web.example.com
+    user: deploy
+    password: secret
+jobs:
+  - steps:
+      - run: echo test
+`,
+			errContains: []string{"duplicate target name 'web.example.com'"},
+		},
+		{
+			name: "duplicate job names",
+			configContent: `
+targets:
+  - host: web.example.com
+    user: admin
+    password: secret
+jobs:
+  - name: deploy
+    steps:
+      - run: echo one
+  - name: job-3
+    steps:
+      - run: echo two
+  - steps:
+      - run: echo three
+  - name: deploy
+    steps:
+      - run: echo four
+`,
+			errContains: []string{"duplicate job name 'deploy'", "duplicate job name 'job-3'"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "nship.yaml")
+			err := os.WriteFile(configPath, []byte(tc.configContent), 0644)
+			assert.NoError(t, err, "Failed to write config file")
+
+			_, err = NewLoader().Load(configPath)
+			if len(tc.errContains) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.Error(t, err)
+			for _, msg := range tc.errContains {
+				assert.Contains(t, err.Error(), msg)
+			}
+		})
+	}
+}
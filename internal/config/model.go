@@ -2,13 +2,135 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+
 	"github.com/nickalie/nship/internal/core/job"
 	"github.com/nickalie/nship/internal/core/target"
 )
 
 // Config represents the main deployment configuration structure containing
-// targets and jobs definitions.
+// targets and jobs definitions. Include lists additional config files whose
+// targets and jobs are merged into this one at load time. BeforeAll and AfterAll
+// run once on each target before and after its jobs. Notify configures a webhook
+// that is called when the deployment finishes. Project names the namespace under
+// which the hashes of executed steps are stored. DefaultPort is the SSH port of
+// targets that don't set their own. Profiles are named overrides, one of which
+// can be selected when loading the config. Inventory is a command printing a JSON
+// array of further targets. Sensitive names the environment variables whose values
+// are secret and masked in command output.
 type Config struct {
-	Targets []*target.Target `yaml:"targets" json:"targets" toml:"targets" validate:"required,dive"`
-	Jobs    []*job.Job       `yaml:"jobs" json:"jobs" toml:"jobs" validate:"required,dive"`
+	Project     string              `yaml:"project,omitempty" json:"project,omitempty" toml:"project,omitempty" hcl:"project,optional" validate:"omitempty"`                                     //nolint:lll // long struct tag needed for complete configuration
+	DefaultPort int                 `yaml:"default_port,omitempty" json:"default_port,omitempty" toml:"default_port,omitempty" hcl:"default_port,optional" validate:"omitempty,min=1,max=65535"` //nolint:lll // long struct tag needed for complete configuration
+	Include     []string            `yaml:"include,omitempty" json:"include,omitempty" toml:"include,omitempty" hcl:"include,optional" validate:"omitempty"`                                     //nolint:lll // long struct tag needed for complete configuration
+	Inventory   string              `yaml:"inventory,omitempty" json:"inventory,omitempty" toml:"inventory,omitempty" hcl:"inventory,optional" validate:"omitempty"`                             //nolint:lll // long struct tag needed for complete configuration
+	Targets     []*target.Target    `yaml:"targets" json:"targets" toml:"targets" hcl:"targets,block" validate:"required,dive"`
+	Jobs        []*job.Job          `yaml:"jobs" json:"jobs" toml:"jobs" hcl:"jobs,block" validate:"required,dive"`
+	BeforeAll   *job.Job            `yaml:"before_all,omitempty" json:"before_all,omitempty" toml:"before_all,omitempty" hcl:"before_all,block" validate:"omitempty"` //nolint:lll // long struct tag needed for complete configuration
+	AfterAll    *job.Job            `yaml:"after_all,omitempty" json:"after_all,omitempty" toml:"after_all,omitempty" hcl:"after_all,block" validate:"omitempty"`     //nolint:lll // long struct tag needed for complete configuration
+	Notify      *NotifyConfig       `yaml:"notify,omitempty" json:"notify,omitempty" toml:"notify,omitempty" hcl:"notify,block" validate:"omitempty"`                 //nolint:lll // long struct tag needed for complete configuration
+	Profiles    map[string]*Profile `yaml:"profiles,omitempty" json:"profiles,omitempty" toml:"profiles,omitempty" validate:"omitempty"`                              //nolint:lll // long struct tag needed for complete configuration
+	Sensitive   []string            `yaml:"sensitive,omitempty" json:"sensitive,omitempty" toml:"sensitive,omitempty" hcl:"sensitive,optional" validate:"omitempty"`  //nolint:lll // long struct tag needed for complete configuration
+	// HCLProfiles are the profiles of HCL configs, which are moved to Profiles once the config is decoded
+	HCLProfiles []*HCLProfile `yaml:"-" json:"-" toml:"-" hcl:"profiles,block"`
+}
+
+// Namespace returns the namespace under which the hashes of executed steps are stored, so that
+// configs reusing job names don't share their skip state. It is the project if set and otherwise
+// derived from the absolute path of the config file.
+func (c *Config) Namespace(configPath string) string {
+	if c.Project != "" {
+		return c.Project
+	}
+
+	if absPath, err := filepath.Abs(configPath); err == nil {
+		configPath = absPath
+	}
+	sum := sha256.Sum256([]byte(configPath))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Profile overrides parts of a config when it is selected. Targets replace the targets of the
+// config, and Env sets environment variables before ${VAR} references in the config are resolved.
+type Profile struct {
+	Targets []*target.Target  `yaml:"targets,omitempty" json:"targets,omitempty" toml:"targets,omitempty" hcl:"targets,block" validate:"omitempty"` //nolint:lll // long struct tag needed for complete configuration
+	Env     map[string]string `yaml:"env,omitempty" json:"env,omitempty" toml:"env,omitempty" hcl:"env,optional" validate:"omitempty"`
+}
+
+// HCLProfile is a Profile as written in HCL configs, a profiles block labelled with the name of the profile
+type HCLProfile struct {
+	Name    string            `hcl:"name,label"`
+	Targets []*target.Target  `hcl:"targets,block"`
+	Env     map[string]string `hcl:"env,optional"`
+}
+
+// Secrets returns the secret values of the config, i.e. the target passwords and the values of the
+// sensitive environment variables, whether inherited or set by the targets or profiles
+func (c *Config) Secrets() []string {
+	var secrets []string
+	for _, tgt := range c.Targets {
+		if tgt.Password != "" {
+			secrets = append(secrets, tgt.Password)
+		}
+	}
+
+	envs := c.envs()
+	for _, name := range c.Sensitive {
+		secrets = append(secrets, os.Getenv(name))
+		for _, env := range envs {
+			secrets = append(secrets, env[name])
+		}
+	}
+	return secrets
+}
+
+// envs returns the environment variables set by the targets and profiles of the config
+func (c *Config) envs() []map[string]string {
+	var envs []map[string]string
+	for _, tgt := range c.Targets {
+		envs = append(envs, tgt.Env)
+	}
+	for _, profile := range c.Profiles {
+		if profile != nil {
+			envs = append(envs, profile.Env)
+		}
+	}
+	return envs
+}
+
+// ApplyDefaultPort sets the port of targets without one to the config's default port, or to
+// fallback if the config has none. Targets keep using port 22 when both are zero.
+func (c *Config) ApplyDefaultPort(fallback int) {
+	port := c.DefaultPort
+	if port == 0 {
+		port = fallback
+	}
+
+	for _, tgt := range c.Targets {
+		if tgt.Port == 0 {
+			tgt.Port = port
+		}
+	}
+}
+
+// NotifyConfig defines the webhook notified about finished deployments and the outcomes,
+// success and/or failure, to notify about. Without On both are notified.
+type NotifyConfig struct {
+	Webhook string   `yaml:"webhook" json:"webhook" toml:"webhook" hcl:"webhook,optional" validate:"required,url"`
+	On      []string `yaml:"on,omitempty" json:"on,omitempty" toml:"on,omitempty" hcl:"on,optional" validate:"omitempty,dive,oneof=success failure"` //nolint:lll // long struct tag needed for complete configuration
+}
+
+// NotifiesOn reports whether deployments with the given status, success or failure, are notified
+func (n *NotifyConfig) NotifiesOn(status string) bool {
+	if len(n.On) == 0 {
+		return true
+	}
+	for _, on := range n.On {
+		if on == status {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+func TestConfigSecrets(t *testing.T) {
+	t.Setenv("DB_PASSWORD", "inherited-secret")
+	t.Setenv("LOG_LEVEL", "debug")
+
+	cfg := &Config{
+		Targets: []*target.Target{
+			{Host: "web", Password: "password123", Env: map[string]string{"API_TOKEN": "target-token", "REGION": "eu-west"}},
+		},
+		Profiles:  map[string]*Profile{"prod": {Env: map[string]string{"API_TOKEN": "profile-token"}}, "empty": nil},
+		Sensitive: []string{"DB_PASSWORD", "API_TOKEN"},
+	}
+
+	secrets := cfg.Secrets()
+	assert.Subset(t, secrets, []string{"password123", "inherited-secret", "target-token", "profile-token"})
+	assert.NotContains(t, secrets, "eu-west", "only the variables marked sensitive should be secrets")
+	assert.NotContains(t, secrets, "debug")
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v2"
+)
+
+// applyProfile merges the selected profile over config. Its variables were already used to resolve the
+// ${VAR} references of the config while it was loaded, see resolveProfileEnv.
+func (l *DefaultLoader) applyProfile(config *Config) (*Config, error) {
+	profile, err := selectProfile(config, l.profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(profile.Targets) > 0 {
+		config.Targets = profile.Targets
+	}
+	config.Profiles = nil
+
+	return config, nil
+}
+
+// resolveProfileEnv sets the variables of the selected profile from the content of the config file at
+// configPath, unless they were resolved from the main config already. Its ${VAR} references are resolved
+// from the environment first to read the profiles, so the values of profile variables can refer to it.
+// The content is only parsed here; errors are reported when the config itself is parsed.
+func (l *DefaultLoader) resolveProfileEnv(configPath, content string) {
+	if l.profile == "" || l.profileEnv != nil {
+		return
+	}
+
+	if profile := parseProfile(configPath, content, l.profile); profile != nil {
+		l.profileEnv = profile.Env
+		if l.profileEnv == nil {
+			l.profileEnv = map[string]string{}
+		}
+	}
+}
+
+// parseProfile returns the profile with the given name defined in the content of the config file at
+// configPath, or nil if the file doesn't define it or can't be parsed
+func parseProfile(configPath, content, name string) *Profile {
+	parse, ok := profileParsers[configExt(configPath)]
+	if !ok {
+		return nil
+	}
+
+	var config Config
+	// HCL unescapes $${ itself, so its escapes are left for the parser
+	content = replaceEnvVariables(content, configExt(configPath) == ".hcl", os.Getenv)
+	if err := parse([]byte(content), &config); err != nil {
+		return nil
+	}
+	return config.Profiles[name]
+}
+
+// profileParsers parses the formats that support profiles by file extension
+var profileParsers = map[string]func(data []byte, config *Config) error{
+	".yaml": func(data []byte, config *Config) error { return yaml.Unmarshal(data, config) },
+	".yml":  func(data []byte, config *Config) error { return yaml.Unmarshal(data, config) },
+	".json": func(data []byte, config *Config) error { return json.Unmarshal(data, config) },
+	".toml": func(data []byte, config *Config) error { return toml.Unmarshal(data, config) },
+	".hcl":  func(data []byte, config *Config) error { return decodeHCL(data, "profile.hcl", config) },
+}
+
+// getenv returns the value of a variable referenced by the config, from the selected profile
+// if it sets it and from the environment otherwise
+func (l *DefaultLoader) getenv(name string) string {
+	if value, ok := l.profileEnv[name]; ok {
+		return value
+	}
+	return os.Getenv(name)
+}
+
+// selectProfile returns the profile of config with the given name
+func selectProfile(config *Config, name string) (*Profile, error) {
+	profile, ok := config.Profiles[name]
+	if !ok || profile == nil {
+		return nil, fmt.Errorf("profile '%s' not found, available profiles: %v", name, profileNames(config))
+	}
+	return profile, nil
+}
+
+// profileNames returns the sorted names of the profiles of config
+func profileNames(config *Config) []string {
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
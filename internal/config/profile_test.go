@@ -0,0 +1,181 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const profileConfig = `
+targets:
+  - name: local
+    host: localhost
+    user: admin
+    password: secret
+jobs:
+  - name: deploy
+    steps:
+      - run: echo deploying ${RELEASE_CHANNEL}
+profiles:
+  staging:
+    env:
+      RELEASE_CHANNEL: beta
+    targets:
+      - name: staging
+        host: staging.example.com
+        user: deploy
+        password: secret
+  prod:
+    targets:
+      - name: web1
+        host: web1.example.com
+        user: deploy
+        password: secret
+      - name: web2
+        host: web2.example.com
+        user: deploy
+        password: secret
+`
+
+func TestLoadWithProfile(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "nship.yaml")
+	writeConfigFile(t, configPath, profileConfig)
+	t.Setenv("RELEASE_CHANNEL", "stable")
+
+	t.Run("without profile", func(t *testing.T) {
+		config, err := NewLoader().Load(configPath)
+		require.NoError(t, err)
+
+		assert.Len(t, config.Targets, 1)
+		assert.Equal(t, "local", config.Targets[0].Name)
+		assert.Equal(t, "echo deploying stable", config.Jobs[0].Steps[0].Run)
+		assert.Len(t, config.Profiles, 2)
+	})
+
+	t.Run("profile replacing targets", func(t *testing.T) {
+		config, err := NewLoader(WithProfile("prod")).Load(configPath)
+		require.NoError(t, err)
+
+		assert.Len(t, config.Targets, 2)
+		assert.Equal(t, "web1", config.Targets[0].Name)
+		assert.Equal(t, "echo deploying stable", config.Jobs[0].Steps[0].Run)
+		assert.Nil(t, config.Profiles, "the applied profile should not remain in the config")
+	})
+
+	t.Run("profile setting variables", func(t *testing.T) {
+		config, err := NewLoader(WithProfile("staging")).Load(configPath)
+		require.NoError(t, err)
+
+		assert.Len(t, config.Targets, 1)
+		assert.Equal(t, "staging", config.Targets[0].Name)
+		assert.Equal(t, "echo deploying beta", config.Jobs[0].Steps[0].Run)
+		assert.Equal(t, "stable", os.Getenv("RELEASE_CHANNEL"), "profile variables should not change the environment")
+	})
+
+	t.Run("unknown profile", func(t *testing.T) {
+		_, err := NewLoader(WithProfile("qa")).Load(configPath)
+		assert.EqualError(t, err, "profile 'qa' not found, available profiles: [prod staging]")
+	})
+}
+
+func TestLoadWithProfileValidatesTargets(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "nship.yaml")
+	writeConfigFile(t, configPath, `
+targets:
+  - host: localhost
+    user: admin
+    password: secret
+jobs:
+  - name: deploy
+    steps:
+      - run: echo deploy
+profiles:
+  broken:
+    targets:
+      - host: broken.example.com
+`)
+
+	_, err := NewLoader().Load(configPath)
+	assert.NoError(t, err, "profiles that are not selected should not be validated")
+
+	_, err = NewLoader(WithProfile("broken")).Load(configPath)
+	assert.ErrorContains(t, err, "targets[0].user is required")
+}
+
+func TestLoadWithProfileResolvesIncludes(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigFile(t, filepath.Join(dir, "jobs.yaml"), `
+jobs:
+  - name: deploy
+    steps:
+      - run: echo deploying ${RELEASE_CHANNEL}
+`)
+	configPath := filepath.Join(dir, "nship.yaml")
+	writeConfigFile(t, configPath, `
+include:
+  - jobs.yaml
+targets:
+  - name: local
+    host: localhost
+    user: admin
+    password: secret
+profiles:
+  staging:
+    env:
+      RELEASE_CHANNEL: beta-${BUILD}
+`)
+	t.Setenv("BUILD", "42")
+
+	config, err := NewLoader(WithProfile("staging")).Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "echo deploying beta-42", config.Jobs[0].Steps[0].Run,
+		"profile variables should be resolved in included configs and may refer to the environment")
+	assert.Empty(t, os.Getenv("RELEASE_CHANNEL"), "profile variables should not be set in the environment")
+}
+
+func TestLoadWithHCLProfile(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "nship.hcl")
+	writeConfigFile(t, configPath, `
+targets {
+  name     = "local"
+  host     = "localhost"
+  user     = "admin"
+  password = "secret"
+}
+
+jobs {
+  name = "deploy"
+  steps {
+    run = "echo deploying to ${HOST}"
+  }
+}
+
+profiles "prod" {
+  env = { HOST = "web1.example.com" }
+
+  targets {
+    name     = "web1"
+    host     = "web1.example.com"
+    user     = "deploy"
+    password = "secret"
+  }
+}
+`)
+	t.Setenv("HOST", "localhost")
+
+	config, err := NewLoader().Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "echo deploying to localhost", config.Jobs[0].Steps[0].Run)
+	assert.Contains(t, config.Profiles, "prod")
+
+	config, err = NewLoader(WithProfile("prod")).Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "web1", config.Targets[0].Name)
+	assert.Equal(t, "echo deploying to web1.example.com", config.Jobs[0].Steps[0].Run, "profile variables should be used in HCL configs")
+
+	_, err = NewLoader(WithProfile("qa")).Load(configPath)
+	assert.EqualError(t, err, "profile 'qa' not found, available profiles: [prod]")
+}
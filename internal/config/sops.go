@@ -0,0 +1,94 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/getsops/sops/v3/decrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// sopsDotenvPattern matches the metadata SOPS adds to encrypted dotenv files
+var sopsDotenvPattern = regexp.MustCompile(`(?m)^sops_version=`)
+
+// sopsFormats maps file extensions to the SOPS format names
+var sopsFormats = map[string]string{
+	".yaml": "yaml",
+	".yml":  "yaml",
+	".json": "json",
+	".env":  "dotenv",
+}
+
+// SOPSDecrypter defines the interface for decrypting SOPS encrypted content.
+type SOPSDecrypter interface {
+	Decrypt(data []byte, format string) ([]byte, error)
+}
+
+// DefaultSOPSDecrypter implements SOPSDecrypter using the SOPS library and
+// the keys configured in the environment, e.g. SOPS_AGE_KEY_FILE.
+type DefaultSOPSDecrypter struct{}
+
+// NewSOPSDecrypter creates a new instance of the default SOPS decrypter.
+func NewSOPSDecrypter() SOPSDecrypter {
+	return &DefaultSOPSDecrypter{}
+}
+
+// Decrypt decrypts content encrypted with SOPS.
+func (d *DefaultSOPSDecrypter) Decrypt(data []byte, format string) ([]byte, error) {
+	return decrypt.Data(data, format)
+}
+
+// SOPSFormat returns the SOPS format name for a file path based on its extension,
+// or an empty string if SOPS does not support it.
+func SOPSFormat(path string) string {
+	return sopsFormats[filepath.Ext(path)]
+}
+
+// IsSOPSEncrypted reports whether data in the given format carries SOPS metadata.
+func IsSOPSEncrypted(data []byte, format string) bool {
+	if format == "dotenv" {
+		return sopsDotenvPattern.Match(data)
+	}
+
+	var doc map[string]interface{}
+	var err error
+	switch format {
+	case "yaml":
+		err = yaml.Unmarshal(data, &doc)
+	case "json":
+		err = json.Unmarshal(data, &doc)
+	default:
+		return false
+	}
+
+	_, ok := doc["sops"]
+	return err == nil && ok
+}
+
+// LoadSOPSFile loads and decrypts a SOPS encrypted file.
+func LoadSOPSFile(path string, decrypter SOPSDecrypter) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SOPS file: %w", err)
+	}
+
+	return decryptSOPS(path, data, decrypter)
+}
+
+// decryptSOPS decrypts the content of a SOPS encrypted file read from path
+func decryptSOPS(path string, data []byte, decrypter SOPSDecrypter) ([]byte, error) {
+	format := SOPSFormat(path)
+	if !IsSOPSEncrypted(data, format) {
+		return nil, fmt.Errorf("file %s is not SOPS encrypted", path)
+	}
+
+	decrypted, err := decrypter.Decrypt(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("SOPS decryption failed: %w", err)
+	}
+
+	return decrypted, nil
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// MockSOPSDecrypter implements the SOPSDecrypter interface for testing
+type MockSOPSDecrypter struct {
+	decryptFunc func(data []byte, format string) ([]byte, error)
+}
+
+// Decrypt calls the mock function
+func (m *MockSOPSDecrypter) Decrypt(data []byte, format string) ([]byte, error) {
+	return m.decryptFunc(data, format)
+}
+
+const sopsYAMLConfig = `targets: ENC[AES256_GCM,data:abc,type:str]
+sops:
+  version: 3.10.2
+  mac: ENC[AES256_GCM,data:def,type:str]
+`
+
+func TestIsSOPSEncrypted(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format string
+		want   bool
+	}{
+		{name: "yaml with metadata", data: sopsYAMLConfig, format: "yaml", want: true},
+		{name: "plain yaml", data: "targets: []\n", format: "yaml", want: false},
+		{name: "json with metadata", data: `{"a": "ENC[...]", "sops": {"version": "3.10.2"}}`, format: "json", want: true},
+		{name: "plain json", data: `{"a": "b"}`, format: "json", want: false},
+		{name: "dotenv with metadata", data: "API_KEY=ENC[...]\nsops_version=3.10.2\n", format: "dotenv", want: true},
+		{name: "plain dotenv", data: "API_KEY=secret\n", format: "dotenv", want: false},
+		{name: "unsupported format", data: sopsYAMLConfig, format: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsSOPSEncrypted([]byte(tt.data), tt.format))
+		})
+	}
+}
+
+func TestSOPSFormat(t *testing.T) {
+	assert.Equal(t, "yaml", SOPSFormat("secrets.sops.yaml"))
+	assert.Equal(t, "yaml", SOPSFormat("secrets.sops.yml"))
+	assert.Equal(t, "json", SOPSFormat("secrets.sops.json"))
+	assert.Equal(t, "dotenv", SOPSFormat("secrets.sops.env"))
+	assert.Equal(t, "", SOPSFormat("nship.toml"))
+}
+
+func TestLoadSOPSFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	encrypted := filepath.Join(tmpDir, "secrets.sops.yaml")
+	require.NoError(t, os.WriteFile(encrypted, []byte(sopsYAMLConfig), 0600))
+
+	plain := filepath.Join(tmpDir, "plain.sops.yaml")
+	require.NoError(t, os.WriteFile(plain, []byte("targets: []\n"), 0600))
+
+	decrypter := &MockSOPSDecrypter{
+		decryptFunc: func(data []byte, format string) ([]byte, error) {
+			assert.Equal(t, "yaml", format)
+			return []byte("decrypted"), nil
+		},
+	}
+
+	data, err := LoadSOPSFile(encrypted, decrypter)
+	assert.NoError(t, err)
+	assert.Equal(t, "decrypted", string(data))
+
+	_, err = LoadSOPSFile(plain, decrypter)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is not SOPS encrypted")
+
+	_, err = LoadSOPSFile(filepath.Join(tmpDir, "missing.sops.yaml"), decrypter)
+	assert.Error(t, err)
+
+	failing := &MockSOPSDecrypter{
+		decryptFunc: func(data []byte, format string) ([]byte, error) {
+			return nil, errors.New("no key")
+		},
+	}
+	_, err = LoadSOPSFile(encrypted, failing)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "SOPS decryption failed")
+}
+
+func TestLoadSOPSEncryptedConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "nship.sops.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(sopsYAMLConfig), 0600))
+
+	loader := NewLoader().(*DefaultLoader)
+	loader.sopsDecrypter = &MockSOPSDecrypter{
+		decryptFunc: func(data []byte, format string) ([]byte, error) {
+			return []byte(`
+targets:
+  - host: example.com
+    user: deploy
+    password: secret
+jobs:
+  - name: deploy
+    steps:
+      - run: echo deploy
+`), nil
+		},
+	}
+
+	cfg, err := loader.Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", cfg.Targets[0].Password)
+	assert.Equal(t, "deploy", cfg.Jobs[0].Name)
+}
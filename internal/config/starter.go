@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// starterHeader is the comment written above the starter configuration
+var starterHeader = []string{
+	"Starter nship configuration generated by nship init.",
+	"",
+	"Replace the example target with your server. Rather than keeping the password here,",
+	"reference an environment variable such as ${SSH_PASSWORD} loaded with --env-file,",
+	"or set private_key to the path of an SSH key instead.",
+	"",
+	"Check the config with: nship validate",
+	"Deploy with:           nship --job deploy",
+}
+
+// StarterConfig returns the example configuration written by nship init: one target and a job
+// with run, copy and docker steps
+func StarterConfig() (*Config, error) {
+	return NewBuilder().
+		AddTarget(&target.Target{Name: "web", Host: "example.com", User: "deploy", Password: "change-me"}).
+		AddJob("deploy").
+		AddRunStep("mkdir -p /opt/app").
+		AddCopyStep("./dist", "/opt/app").
+		AddDockerStep(&job.DockerStep{
+			Image:   "nginx:alpine",
+			Name:    "web",
+			Ports:   []string{"80:80"},
+			Volumes: []string{"/opt/app:/usr/share/nginx/html:ro"},
+			Restart: "unless-stopped",
+		}).
+		Build()
+}
+
+// StarterFileName returns the name of the file nship init writes in the given format
+func StarterFileName(format string) string {
+	return "nship." + strings.ToLower(format)
+}
+
+// MarshalStarter serializes the starter configuration into the given format (yaml, toml, json or ts),
+// preceded by a comment explaining how to use it where the format supports comments
+func MarshalStarter(format string) ([]byte, error) {
+	cfg, err := StarterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(format) {
+	case "ts":
+		data, err := Marshal(cfg, "json")
+		if err != nil {
+			return nil, err
+		}
+		return fmt.Appendf(nil, "%sexport default %s;\n", starterComment("// "), data), nil
+	case "json":
+		return Marshal(cfg, format)
+	default:
+		data, err := Marshal(cfg, format)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(starterComment("# ")), data...), nil
+	}
+}
+
+// starterComment returns starterHeader as a comment with the given line prefix, followed by an empty line
+func starterComment(prefix string) string {
+	var b strings.Builder
+	for _, line := range starterHeader {
+		b.WriteString(strings.TrimRight(prefix+line, " ") + "\n")
+	}
+	b.WriteString("\n")
+	return b.String()
+}
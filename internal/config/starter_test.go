@@ -0,0 +1,57 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/job"
+)
+
+func TestMarshalStarterLoads(t *testing.T) {
+	for _, format := range []string{"yaml", "toml", "json"} {
+		t.Run(format, func(t *testing.T) {
+			data, err := MarshalStarter(format)
+			require.NoError(t, err)
+
+			configPath := filepath.Join(t.TempDir(), StarterFileName(format))
+			require.NoError(t, os.WriteFile(configPath, data, 0600))
+
+			config, err := NewLoader().Load(configPath)
+			require.NoError(t, err, "the starter config should pass validation")
+
+			require.Len(t, config.Targets, 1)
+			require.Len(t, config.Jobs, 1)
+			types := make([]job.StepType, 0, len(config.Jobs[0].Steps))
+			for _, step := range config.Jobs[0].Steps {
+				types = append(types, step.GetType())
+			}
+			assert.Equal(t, []job.StepType{job.RunStep, job.CopyStepType, job.DockerStepType}, types)
+		})
+	}
+}
+
+func TestMarshalStarterComments(t *testing.T) {
+	data, err := MarshalStarter("yaml")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "# Starter nship configuration"))
+
+	data, err = MarshalStarter("ts")
+	require.NoError(t, err)
+	content := string(data)
+	assert.True(t, strings.HasPrefix(content, "// Starter nship configuration"))
+
+	_, object, ok := strings.Cut(content, "export default ")
+	require.True(t, ok)
+	var config Config
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimSuffix(object, ";\n")), &config))
+	assert.Equal(t, "example.com", config.Targets[0].Host)
+
+	_, err = MarshalStarter("xml")
+	assert.Error(t, err)
+}
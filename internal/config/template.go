@@ -0,0 +1,75 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// templateHeader marks a config file that is rendered as a Go template before it is parsed
+const templateHeader = "# nship:template"
+
+// templateData is the data config templates are rendered with
+type templateData struct {
+	// Env holds the environment variables of the process
+	Env map[string]string
+}
+
+// templateFuncs are the functions available in config templates next to the builtin ones
+var templateFuncs = template.FuncMap{
+	"split": strings.Split,
+	"atoi":  strconv.Atoi,
+}
+
+// hasTemplateHeader reports whether content starts with the template header
+func hasTemplateHeader(content string) bool {
+	firstLine, _, _ := strings.Cut(content, "\n")
+	return strings.TrimSpace(firstLine) == templateHeader
+}
+
+// renderTemplate renders the content of the config at configPath as a Go template with the process
+// environment as .Env. Errors quote the line of the template they occurred on.
+func renderTemplate(configPath, content string) (string, error) {
+	name := filepath.Base(configPath)
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(content)
+	if err != nil {
+		return "", templateError(name, content, err)
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, templateData{Env: environ()}); err != nil {
+		return "", templateError(name, content, err)
+	}
+	return rendered.String(), nil
+}
+
+// environ returns the environment variables of the process as a map
+func environ() map[string]string {
+	env := make(map[string]string)
+	for _, entry := range os.Environ() {
+		if key, value, ok := strings.Cut(entry, "="); ok {
+			env[key] = value
+		}
+	}
+	return env
+}
+
+// templateError wraps an error of the template called name, adding the line of content it refers to
+func templateError(name, content string, err error) error {
+	matches := regexp.MustCompile(`template: ` + regexp.QuoteMeta(name) + `:(\d+)`).FindStringSubmatch(err.Error())
+	if matches == nil {
+		return fmt.Errorf("failed to render config template: %w", err)
+	}
+
+	lineNum, _ := strconv.Atoi(matches[1])
+	lines := strings.Split(content, "\n")
+	if lineNum < 1 || lineNum > len(lines) {
+		return fmt.Errorf("failed to render config template: %w", err)
+	}
+	return fmt.Errorf("failed to render config template: %w\n%5d | %s", err, lineNum, lines[lineNum-1])
+}
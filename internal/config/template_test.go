@@ -0,0 +1,79 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const templatedConfig = `# nship:template
+targets:
+{{- range split .Env.NSHIP_TEST_HOSTS "," }}
+  - host: {{ . }}
+    user: deploy
+    password: ${NSHIP_TEST_PASSWORD}
+{{- end }}
+jobs:
+  - name: deploy
+    steps:
+{{- if eq .Env.NSHIP_TEST_MIGRATE "true" }}
+      - run: ./migrate
+{{- end }}
+      - run: ./deploy
+`
+
+func TestLoadTemplatedConfig(t *testing.T) {
+	t.Setenv("NSHIP_TEST_HOSTS", "web1.example.com,web2.example.com")
+	t.Setenv("NSHIP_TEST_PASSWORD", "secret")
+	t.Setenv("NSHIP_TEST_MIGRATE", "false")
+
+	configPath := filepath.Join(t.TempDir(), "nship.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(templatedConfig), 0600))
+
+	config, err := NewLoader().Load(configPath)
+	require.NoError(t, err)
+
+	require.Len(t, config.Targets, 2)
+	assert.Equal(t, "web2.example.com", config.Targets[1].Host)
+	assert.Equal(t, "secret", config.Targets[1].Password, "variables should be replaced after rendering")
+	require.Len(t, config.Jobs[0].Steps, 1)
+	assert.Equal(t, "./deploy", config.Jobs[0].Steps[0].Run)
+}
+
+func TestLoadConfigTemplateOption(t *testing.T) {
+	t.Setenv("NSHIP_TEST_USER", "deploy")
+
+	configPath := filepath.Join(t.TempDir(), "nship.yaml")
+	content := "targets:\n  - host: example.com\n    user: {{ .Env.NSHIP_TEST_USER }}\n    password: secret\n" +
+		"jobs:\n  - name: deploy\n    steps:\n      - run: echo {{ .Env.NSHIP_TEST_UNSET }}done\n"
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0600))
+
+	_, err := NewLoader().Load(configPath)
+	assert.Error(t, err, "files without the header should not be rendered")
+
+	config, err := NewLoader(WithTemplate(true)).Load(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "deploy", config.Targets[0].User)
+	assert.Equal(t, "echo done", config.Jobs[0].Steps[0].Run, "unset variables should render empty")
+}
+
+func TestRenderTemplateErrors(t *testing.T) {
+	_, err := renderTemplate("configs/nship.yaml", "# nship:template\ntargets:\n  - host: {{ .Env.HOST }}{{ end }}\n")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to render config template")
+	assert.Contains(t, err.Error(), "    3 |   - host: {{ .Env.HOST }}{{ end }}")
+
+	_, err = renderTemplate("nship.yaml", "# nship:template\n\nport: {{ atoi \"many\" }}\n")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "    3 | port: {{ atoi \"many\" }}")
+}
+
+func TestHasTemplateHeader(t *testing.T) {
+	assert.True(t, hasTemplateHeader("# nship:template\ntargets: []"))
+	assert.True(t, hasTemplateHeader("# nship:template  \r\n"))
+	assert.False(t, hasTemplateHeader("targets: []\n# nship:template\n"))
+	assert.False(t, hasTemplateHeader(""))
+}
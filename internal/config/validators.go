@@ -0,0 +1,247 @@
+package config
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+	"github.com/nickalie/nship/internal/util"
+)
+
+var (
+	// dockerPortPattern matches [ip:]host:container[/proto], where ports may be ranges
+	// and the host port may be empty to let Docker pick one.
+	dockerPortPattern = regexp.MustCompile(
+		`^(?:(?:\d{1,3}(?:\.\d{1,3}){3}|\[[0-9a-fA-F:.]+\]):)?(\d+(?:-\d+)?)?:(\d+(?:-\d+)?)(?:/(?:tcp|udp|sctp))?$`,
+	)
+
+	// dockerMemoryPattern matches a Docker memory size, a positive integer with an optional b, k, m or g unit
+	dockerMemoryPattern = regexp.MustCompile(`^[1-9]\d*[bkmgBKMG]?$`)
+
+	// dockerDigestPattern matches the digest of an image, optionally prefixed with its repository, e.g. nginx@sha256:…
+	dockerDigestPattern = regexp.MustCompile(`^([^@\s]+@)?sha256:[0-9a-f]{64}$`)
+
+	// envNamePattern matches the name of an environment variable that can be exported by the shell
+	envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// dockerVolumeOptions lists the mount options accepted in src:dst:opts volume mappings
+	dockerVolumeOptions = map[string]bool{
+		"ro": true, "rw": true, "z": true, "Z": true, "nocopy": true,
+		"consistent": true, "cached": true, "delegated": true,
+		"shared": true, "slave": true, "private": true,
+		"rshared": true, "rslave": true, "rprivate": true,
+	}
+)
+
+// newValidator creates a validator with the custom validations used by the config structs registered.
+// Field names in validation errors are reported using their YAML keys so they match the config files.
+func newValidator() *validator.Validate {
+	validate := validator.New()
+	validate.RegisterTagNameFunc(yamlFieldName)
+	validate.RegisterStructValidation(validateStep, job.Step{})
+	validate.RegisterStructValidation(validateCopyStep, job.CopyStep{})
+	validate.RegisterStructValidation(validateServiceStep, job.ServiceStep{})
+	validate.RegisterStructValidation(validateTarget, target.Target{})
+	_ = validate.RegisterValidation("docker_port", validateDockerPort)
+	_ = validate.RegisterValidation("docker_volume", validateDockerVolume)
+	_ = validate.RegisterValidation("docker_memory", validateDockerMemory)
+	_ = validate.RegisterValidation("docker_digest", validateDockerDigest)
+	_ = validate.RegisterValidation("duration", validateDuration)
+	_ = validate.RegisterValidation("env_name", validateEnvName)
+	_ = validate.RegisterValidation("condition", validateCondition)
+	_ = validate.RegisterValidation("ssh_cipher", validateSSHCipher)
+	_ = validate.RegisterValidation("ssh_mac", validateSSHMAC)
+	_ = validate.RegisterValidation("ssh_kex", validateSSHKex)
+	_ = validate.RegisterValidation("ssh_host_key", validateSSHHostKey)
+	return validate
+}
+
+// yamlFieldName returns the YAML key of a struct field
+func yamlFieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("yaml"), ",", 2)[0]
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// validateStep ensures a step defines exactly one action, and env and workdir only for the actions they apply to
+func validateStep(sl validator.StructLevel) {
+	step := sl.Current().Interface().(job.Step)
+
+	actions := 0
+	actionFields := []bool{
+		step.Run != "", step.ScriptFile != "", step.RunScript != nil,
+		step.Copy != nil, step.Download != nil, step.Docker != nil, step.Wait != nil, step.Exec != nil,
+		step.DockerPrune != nil, step.DockerExec != nil, step.Service != nil, step.Assert != nil,
+	}
+	for _, defined := range actionFields {
+		if defined {
+			actions++
+		}
+	}
+
+	if actions != 1 {
+		sl.ReportError(step, "", "", "step_action", "")
+		return
+	}
+	validateStepCommandOptions(sl, step)
+}
+
+// validateStepCommandOptions ensures env and workdir are only set on run, script_file and run_script steps,
+// the only ones whose commands they apply to
+func validateStepCommandOptions(sl validator.StructLevel, step job.Step) {
+	if step.Run != "" || step.ScriptFile != "" || step.RunScript != nil {
+		return
+	}
+	if len(step.Env) > 0 {
+		sl.ReportError(step.Env, "env", "Env", "step_command", "")
+	}
+	if step.Workdir != "" {
+		sl.ReportError(step.Workdir, "workdir", "Workdir", "step_command", "")
+	}
+}
+
+// validateCopyStep ensures a copy into a container has an absolute destination and does not delete files,
+// as the files of the container are not visible to the copy
+func validateCopyStep(sl validator.StructLevel) {
+	copyStep := sl.Current().Interface().(job.CopyStep)
+	if copyStep.Container == "" {
+		return
+	}
+
+	if !strings.HasPrefix(copyStep.Remote, "/") {
+		sl.ReportError(copyStep.Remote, "remote", "Remote", "container_path", "")
+	}
+	if copyStep.Delete {
+		sl.ReportError(copyStep.Delete, "delete", "Delete", "container_delete", "")
+	}
+}
+
+// validateServiceStep ensures a service is only verified after actions that leave it running
+func validateServiceStep(sl validator.StructLevel) {
+	service := sl.Current().Interface().(job.ServiceStep)
+	if service.Verify && !service.Verifiable() {
+		sl.ReportError(service.Verify, "verify", "Verify", "service_verify", service.Action)
+	}
+}
+
+// validateTarget ensures a target connected over SSH has a password or a private key to log in with
+func validateTarget(sl validator.StructLevel) {
+	tgt := sl.Current().Interface().(target.Target)
+	if tgt.Local || tgt.Password != "" || tgt.PrivateKey != "" {
+		return
+	}
+
+	sl.ReportError(tgt.Password, "password", "Password", "required_without", "PrivateKey")
+	sl.ReportError(tgt.PrivateKey, "private_key", "PrivateKey", "required_without", "Password")
+}
+
+// validateDockerPort checks that a port mapping has the form [ip:]host:container[/proto]
+func validateDockerPort(fl validator.FieldLevel) bool {
+	matches := dockerPortPattern.FindStringSubmatch(fl.Field().String())
+	if matches == nil {
+		return false
+	}
+	return isValidPortRange(matches[1], true) && isValidPortRange(matches[2], false)
+}
+
+// isValidPortRange checks that a port or port range only holds ports between 1 and 65535
+func isValidPortRange(value string, allowEmpty bool) bool {
+	if value == "" {
+		return allowEmpty
+	}
+
+	bounds := strings.SplitN(value, "-", 2)
+	for _, bound := range bounds {
+		port, err := strconv.Atoi(bound)
+		if err != nil || port < 1 || port > 65535 {
+			return false
+		}
+	}
+
+	if len(bounds) == 2 {
+		start, _ := strconv.Atoi(bounds[0])
+		end, _ := strconv.Atoi(bounds[1])
+		return start <= end
+	}
+
+	return true
+}
+
+// validateDockerVolume checks that a volume mapping has the form src:dst[:opts]
+func validateDockerVolume(fl validator.FieldLevel) bool {
+	parts := strings.Split(fl.Field().String(), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return false
+	}
+
+	src, dst := parts[0], parts[1]
+	if src == "" || !strings.HasPrefix(dst, "/") {
+		return false
+	}
+
+	if len(parts) == 3 {
+		for _, opt := range strings.Split(parts[2], ",") {
+			if !dockerVolumeOptions[opt] {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// validateDockerMemory checks that a memory limit uses Docker's size syntax, e.g. 512m or 2g
+func validateDockerMemory(fl validator.FieldLevel) bool {
+	return dockerMemoryPattern.MatchString(fl.Field().String())
+}
+
+// validateDockerDigest checks that a value is an image digest such as sha256:… or nginx@sha256:…
+func validateDockerDigest(fl validator.FieldLevel) bool {
+	return dockerDigestPattern.MatchString(fl.Field().String())
+}
+
+// validateDuration checks that a value is a non-negative Go duration such as 5s or 1m30s
+func validateDuration(fl validator.FieldLevel) bool {
+	d, err := time.ParseDuration(fl.Field().String())
+	return err == nil && d >= 0
+}
+
+// validateCondition checks that a value is a valid when condition, e.g. target.name == "prod"
+func validateCondition(fl validator.FieldLevel) bool {
+	_, err := job.ParseCondition(fl.Field().String())
+	return err == nil
+}
+
+// validateEnvName checks that a value is a valid environment variable name, e.g. APP_ENV
+func validateEnvName(fl validator.FieldLevel) bool {
+	return envNamePattern.MatchString(fl.Field().String())
+}
+
+// validateSSHCipher checks that a value is a cipher supported by crypto/ssh, e.g. aes256-ctr
+func validateSSHCipher(fl validator.FieldLevel) bool {
+	return len(util.SupportedSSHAlgorithms(ssh.Config{Ciphers: []string{fl.Field().String()}}).Ciphers) == 1
+}
+
+// validateSSHMAC checks that a value is a MAC supported by crypto/ssh, e.g. hmac-sha2-256
+func validateSSHMAC(fl validator.FieldLevel) bool {
+	return len(util.SupportedSSHAlgorithms(ssh.Config{MACs: []string{fl.Field().String()}}).MACs) == 1
+}
+
+// validateSSHKex checks that a value is a key exchange algorithm supported by crypto/ssh, e.g. curve25519-sha256
+func validateSSHKex(fl validator.FieldLevel) bool {
+	return len(util.SupportedSSHAlgorithms(ssh.Config{KeyExchanges: []string{fl.Field().String()}}).KeyExchanges) == 1
+}
+
+// validateSSHHostKey checks that a value is a host key algorithm supported by crypto/ssh, e.g. ssh-ed25519
+func validateSSHHostKey(fl validator.FieldLevel) bool {
+	return len(util.SupportedSSHHostKeyAlgorithms([]string{fl.Field().String()})) == 1
+}
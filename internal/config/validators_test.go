@@ -0,0 +1,550 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+func dockerConfig(ports, volumes []string) *Config {
+	return &Config{
+		Targets: []*target.Target{{Host: "localhost", User: "user", Password: "secret"}},
+		Jobs: []*job.Job{
+			{Name: "setup", Steps: []*job.Step{{Run: "echo test"}}},
+			{Name: "deploy", Steps: []*job.Step{
+				{Run: "echo test"},
+				{Docker: &job.DockerStep{Image: "nginx", Name: "web", Ports: ports, Volumes: volumes}},
+			}},
+		},
+	}
+}
+
+func TestValidateDockerPorts(t *testing.T) {
+	validPorts := []string{
+		"80:80",
+		"8080:80/tcp",
+		"53:53/udp",
+		"127.0.0.1:8080:80",
+		"127.0.0.1::80",
+		"[::1]:8080:80",
+		"8000-8010:8000-8010",
+	}
+	for _, port := range validPorts {
+		t.Run("valid "+port, func(t *testing.T) {
+			loader := &DefaultLoader{validator: newValidator()}
+			assert.NoError(t, loader.validateConfig(dockerConfig([]string{port}, nil)))
+		})
+	}
+
+	invalidPorts := []string{
+		"80-80",
+		"80",
+		"80:80:80:80",
+		"abc:80",
+		"8080:80/http",
+		"0:80",
+		"70000:80",
+		"8010-8000:80",
+		"localhost:8080:80",
+	}
+	for _, port := range invalidPorts {
+		t.Run("invalid "+port, func(t *testing.T) {
+			loader := &DefaultLoader{validator: newValidator()}
+			err := loader.validateConfig(dockerConfig([]string{"80:80", port}, nil))
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), "jobs[1].steps[1].docker.ports[1]: invalid docker port mapping '"+port+"'")
+		})
+	}
+}
+
+func TestValidateDockerVolumes(t *testing.T) {
+	validVolumes := []string{
+		"/data:/data",
+		"./data:/var/lib/data",
+		"app-data:/data",
+		"/data:/data:ro",
+		"/data:/data:ro,z",
+	}
+	for _, volume := range validVolumes {
+		t.Run("valid "+volume, func(t *testing.T) {
+			loader := &DefaultLoader{validator: newValidator()}
+			assert.NoError(t, loader.validateConfig(dockerConfig(nil, []string{volume})))
+		})
+	}
+
+	invalidVolumes := []string{
+		"::/data",
+		"/data",
+		":/data",
+		"/data:data",
+		"/data:/data:bogus",
+		"/data:/data:ro:rw",
+	}
+	for _, volume := range invalidVolumes {
+		t.Run("invalid "+volume, func(t *testing.T) {
+			loader := &DefaultLoader{validator: newValidator()}
+			err := loader.validateConfig(dockerConfig(nil, []string{volume}))
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), "jobs[1].steps[1].docker.volumes[0]: invalid docker volume mapping '"+volume+"'")
+		})
+	}
+}
+
+func TestValidateDockerMemory(t *testing.T) {
+	for _, memory := range []string{"512m", "2g", "2G", "1048576", "64k"} {
+		t.Run("valid "+memory, func(t *testing.T) {
+			cfg := dockerConfig(nil, nil)
+			cfg.Jobs[1].Steps[1].Docker.Memory = memory
+			loader := &DefaultLoader{validator: newValidator()}
+			assert.NoError(t, loader.validateConfig(cfg))
+		})
+	}
+
+	for _, memory := range []string{"512mb", "2 g", "1.5g", "0m", "m", "-1g"} {
+		t.Run("invalid "+memory, func(t *testing.T) {
+			cfg := dockerConfig(nil, nil)
+			cfg.Jobs[1].Steps[1].Docker.Memory = memory
+			loader := &DefaultLoader{validator: newValidator()}
+			err := loader.validateConfig(cfg)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), "jobs[1].steps[1].docker.memory: invalid docker memory limit '"+memory+"'")
+		})
+	}
+}
+
+func TestValidateDockerExpectedDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("0a", 32)
+	for _, value := range []string{digest, "nginx@" + digest, "registry.example.com:5000/app@" + digest} {
+		t.Run("valid "+value, func(t *testing.T) {
+			cfg := dockerConfig(nil, nil)
+			cfg.Jobs[1].Steps[1].Docker.ExpectedDigest = value
+			loader := &DefaultLoader{validator: newValidator()}
+			assert.NoError(t, loader.validateConfig(cfg))
+		})
+	}
+
+	for _, value := range []string{"sha256:abc", "sha512:" + strings.Repeat("0a", 32), strings.Repeat("0a", 32), "sha256:" + strings.Repeat("0A", 32)} {
+		t.Run("invalid "+value, func(t *testing.T) {
+			cfg := dockerConfig(nil, nil)
+			cfg.Jobs[1].Steps[1].Docker.ExpectedDigest = value
+			loader := &DefaultLoader{validator: newValidator()}
+			err := loader.validateConfig(cfg)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), "jobs[1].steps[1].docker.expected_digest: invalid image digest '"+value+"'")
+		})
+	}
+
+	t.Run("with build", func(t *testing.T) {
+		cfg := dockerConfig(nil, nil)
+		cfg.Jobs[1].Steps[1].Docker.ExpectedDigest = digest
+		cfg.Jobs[1].Steps[1].Docker.Build = &job.DockerBuildStep{Context: "."}
+		loader := &DefaultLoader{validator: newValidator()}
+		err := loader.validateConfig(cfg)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "jobs[1].steps[1].docker.expected_digest can't be used together with build")
+	})
+}
+
+func TestValidateDockerBuildContext(t *testing.T) {
+	cfg := dockerConfig(nil, nil)
+	cfg.Jobs[1].Steps[1].Docker.Build = &job.DockerBuildStep{Dockerfile: "Dockerfile.prod", Target: "runtime"}
+
+	loader := &DefaultLoader{validator: newValidator()}
+	err := loader.validateConfig(cfg)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "jobs[1].steps[1].docker.build.context is required")
+}
+
+func TestValidateRollingStrategy(t *testing.T) {
+	check := &job.HealthCheck{Command: "curl -f localhost:8080/health", Retries: 5, Interval: "2s"}
+	tests := []struct {
+		name           string
+		strategy       string
+		maxUnavailable int
+		healthCheck    *job.HealthCheck
+		expectErr      string
+	}{
+		{name: "rolling", strategy: job.StrategyRolling, maxUnavailable: 1, healthCheck: check},
+		{name: "rolling without options", strategy: job.StrategyRolling},
+		{name: "unknown strategy", strategy: "blue-green", expectErr: "jobs[1].strategy must be one of [rolling], got 'blue-green'"},
+		{name: "negative max_unavailable", strategy: job.StrategyRolling, maxUnavailable: -1,
+			expectErr: "jobs[1].max_unavailable must be at least 1"},
+		{name: "max_unavailable without strategy", maxUnavailable: 2,
+			expectErr: "jobs[1].max_unavailable can only be used when strategy is set"},
+		{name: "health_check without strategy", healthCheck: check,
+			expectErr: "jobs[1].health_check can only be used when strategy is set"},
+		{name: "health_check without command", strategy: job.StrategyRolling, healthCheck: &job.HealthCheck{},
+			expectErr: "jobs[1].health_check.command is required"},
+		{name: "health_check with invalid interval", strategy: job.StrategyRolling,
+			healthCheck: &job.HealthCheck{Command: "true", Interval: "soon"}, expectErr: "jobs[1].health_check.interval"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := dockerConfig(nil, nil)
+			cfg.Jobs[1].Strategy = tt.strategy
+			cfg.Jobs[1].MaxUnavailable = tt.maxUnavailable
+			cfg.Jobs[1].HealthCheck = tt.healthCheck
+
+			err := cfg.Validate()
+			if tt.expectErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorContains(t, err, tt.expectErr)
+		})
+	}
+}
+
+func TestValidateWaitDuration(t *testing.T) {
+	tests := []struct {
+		duration string
+		valid    bool
+	}{
+		{duration: "5s", valid: true},
+		{duration: "1m30s", valid: true},
+		{duration: "250ms", valid: true},
+		{duration: "5", valid: false},
+		{duration: "-1s", valid: false},
+		{duration: "soon", valid: false},
+	}
+
+	validate := newValidator()
+	for _, tt := range tests {
+		t.Run(tt.duration, func(t *testing.T) {
+			err := validate.Struct(&job.WaitStep{Duration: tt.duration})
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateNotify(t *testing.T) {
+	tests := []struct {
+		name   string
+		notify *NotifyConfig
+		errMsg string
+	}{
+		{name: "valid", notify: &NotifyConfig{Webhook: "https://hooks.example.com/x", On: []string{"failure"}}},
+		{name: "missing webhook", notify: &NotifyConfig{}, errMsg: "notify.webhook is required"},
+		{name: "invalid webhook", notify: &NotifyConfig{Webhook: "not a url"}, errMsg: "notify.webhook must be a valid URL"},
+		{name: "invalid status", notify: &NotifyConfig{Webhook: "https://hooks.example.com/x", On: []string{"done"}},
+			errMsg: "notify.on[0] must be one of [success failure]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := dockerConfig(nil, nil)
+			cfg.Notify = tt.notify
+
+			loader := &DefaultLoader{validator: newValidator()}
+			err := loader.validateConfig(cfg)
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestNotifiesOn(t *testing.T) {
+	assert.True(t, (&NotifyConfig{}).NotifiesOn("success"))
+	assert.True(t, (&NotifyConfig{}).NotifiesOn("failure"))
+	assert.True(t, (&NotifyConfig{On: []string{"failure"}}).NotifiesOn("failure"))
+	assert.False(t, (&NotifyConfig{On: []string{"failure"}}).NotifiesOn("success"))
+}
+
+func TestNamespace(t *testing.T) {
+	assert.Equal(t, "shop", (&Config{Project: "shop"}).Namespace("nship.yaml"))
+
+	cfg := &Config{}
+	assert.Len(t, cfg.Namespace("nship.yaml"), 16)
+	assert.Equal(t, cfg.Namespace("nship.yaml"), cfg.Namespace("./nship.yaml"), "the namespace should use the absolute path")
+	assert.NotEqual(t, cfg.Namespace("shop/nship.yaml"), cfg.Namespace("blog/nship.yaml"))
+}
+
+func TestApplyDefaultPort(t *testing.T) {
+	tests := []struct {
+		name        string
+		targetPort  int
+		defaultPort int
+		fallback    int
+		expected    int
+	}{
+		{name: "target port", targetPort: 2200, defaultPort: 2201, fallback: 2202, expected: 2200},
+		{name: "config default", defaultPort: 2201, fallback: 2202, expected: 2201},
+		{name: "fallback", fallback: 2202, expected: 2202},
+		{name: "ssh default", expected: 22},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tgt := &target.Target{Host: "localhost", Port: tt.targetPort}
+			cfg := &Config{DefaultPort: tt.defaultPort, Targets: []*target.Target{tgt}}
+
+			cfg.ApplyDefaultPort(tt.fallback)
+			assert.Equal(t, tt.expected, tgt.GetPort())
+		})
+	}
+}
+
+func TestValidateScriptFile(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "deploy.sh")
+	require.NoError(t, os.WriteFile(script, []byte("echo deploy"), 0644))
+
+	config := &Config{
+		Targets: []*target.Target{{Host: "localhost", User: "user", Password: "secret"}},
+		Jobs: []*job.Job{
+			{Name: "deploy", Steps: []*job.Step{
+				{ScriptFile: script},
+				{Run: "echo deploy", ScriptFile: script},
+				{ScriptFile: script + ".missing"},
+			}},
+		},
+	}
+
+	loader := &DefaultLoader{validator: newValidator()}
+	err := loader.validateConfig(config)
+	assert.Error(t, err)
+
+	msg := err.Error()
+	assert.NotContains(t, msg, "jobs[0].steps[0]")
+	assert.Contains(t, msg, "jobs[0].steps[1]: exactly one of run/script_file/run_script/copy/download/docker/docker_prune/docker_exec/service/assert/wait/exec required")
+	assert.Contains(t, msg, "jobs[0].steps[2].script_file must point to an existing file")
+}
+
+func TestValidationErrorPaths(t *testing.T) {
+	config := &Config{
+		Targets: []*target.Target{
+			{Host: "web.example.com", User: "admin", Password: "secret"},
+			{Host: "db.example.com", Password: "secret", Port: 70000},
+		},
+		Jobs: []*job.Job{
+			{Name: "setup", Steps: []*job.Step{{Run: "echo test"}}},
+			{Name: "deploy", Steps: []*job.Step{
+				{Run: "echo test"},
+				{Shell: "bash"},
+				{Run: "echo test", Copy: &job.CopyStep{Local: "./app", Remote: "/app"}},
+				{Docker: &job.DockerStep{Image: "nginx", Name: "web", Restart: "sometimes"}},
+			}},
+		},
+	}
+
+	loader := &DefaultLoader{validator: newValidator()}
+	err := loader.validateConfig(config)
+	assert.Error(t, err)
+
+	msg := err.Error()
+	assert.Contains(t, msg, "targets[1].user is required")
+	assert.Contains(t, msg, "targets[1].port must be at most 65535")
+	assert.Contains(t, msg, "jobs[1].steps[1]: exactly one of run/script_file/run_script/copy/download/docker/docker_prune/docker_exec/service/assert/wait/exec required")
+	assert.Contains(t, msg, "jobs[1].steps[2]: exactly one of run/script_file/run_script/copy/download/docker/docker_prune/docker_exec/service/assert/wait/exec required")
+	assert.Contains(t, msg, "jobs[1].steps[3].docker.restart must be one of [no on-failure always unless-stopped], got 'sometimes'")
+	assert.NotContains(t, msg, "targets[0]")
+	assert.NotContains(t, msg, "jobs[0]")
+	assert.NotContains(t, msg, "jobs[1].steps[0]")
+}
+
+func TestValidationErrorRequiredWithout(t *testing.T) {
+	config := &Config{
+		Targets: []*target.Target{{Host: "web.example.com", User: "admin"}},
+		Jobs:    []*job.Job{{Steps: []*job.Step{{Run: "echo test"}}}},
+	}
+
+	loader := &DefaultLoader{validator: newValidator()}
+	err := loader.validateConfig(config)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "targets[0].password is required when private_key is not set")
+	assert.Contains(t, err.Error(), "targets[0].private_key is required when password is not set")
+}
+
+func TestValidateStepEnvNames(t *testing.T) {
+	cfg := &Config{
+		Targets: []*target.Target{{Host: "example.com", User: "deploy", Password: "secret"}},
+		Jobs:    []*job.Job{{Name: "app", Steps: []*job.Step{{Run: "make", Env: map[string]string{"APP_ENV": "prod"}}}}},
+	}
+	loader := &DefaultLoader{validator: newValidator()}
+	assert.NoError(t, loader.validateConfig(cfg))
+
+	cfg.Jobs[0].Steps[0].Env["APP ENV"] = "prod"
+	err := loader.validateConfig(cfg)
+	assert.ErrorContains(t, err, "invalid environment variable name 'APP ENV'")
+
+	cfg.Jobs[0].Steps[0].Env = nil
+	cfg.Targets[0].Env = map[string]string{"PATH": "/opt/app/bin:/usr/bin"}
+	assert.NoError(t, loader.validateConfig(cfg))
+
+	cfg.Targets[0].Env["1PATH"] = "/opt"
+	assert.ErrorContains(t, loader.validateConfig(cfg), "invalid environment variable name '1PATH'")
+}
+
+func TestValidateLocalTarget(t *testing.T) {
+	cfg := &Config{
+		Targets: []*target.Target{{Local: true}, {Name: "build", Host: "127.0.0.1", Local: true}},
+		Jobs:    []*job.Job{{Name: "app", Steps: []*job.Step{{Run: "make"}}}},
+	}
+	loader := &DefaultLoader{validator: newValidator()}
+	require.NoError(t, loader.validateConfig(cfg), "local targets need no user or credentials")
+	assert.Equal(t, "localhost", cfg.Targets[0].Host)
+	assert.Equal(t, "localhost", cfg.Targets[0].GetName())
+	assert.Equal(t, "build", cfg.Targets[1].GetName())
+
+	cfg.Targets[1].Host = "not a host"
+	assert.ErrorContains(t, loader.validateConfig(cfg), "targets[1].host must be a valid hostname or IP address")
+}
+
+func TestValidateTargetSSHAlgorithms(t *testing.T) {
+	cfg := &Config{
+		Targets: []*target.Target{{
+			Host:              "example.com",
+			User:              "deploy",
+			Password:          "secret",
+			Ciphers:           []string{"aes256-ctr", "aes128-cbc"},
+			MACs:              []string{"hmac-sha2-256"},
+			KexAlgorithms:     []string{"curve25519-sha256", "diffie-hellman-group14-sha1"},
+			HostKeyAlgorithms: []string{"ssh-ed25519", "ssh-rsa"},
+		}},
+		Jobs: []*job.Job{{Name: "app", Steps: []*job.Step{{Run: "make"}}}},
+	}
+	loader := &DefaultLoader{validator: newValidator()}
+	assert.NoError(t, loader.validateConfig(cfg))
+
+	cfg.Targets[0].Ciphers = append(cfg.Targets[0].Ciphers, "blowfish-cbc")
+	cfg.Targets[0].MACs = []string{"hmac-md5"}
+	cfg.Targets[0].KexAlgorithms = []string{""}
+	cfg.Targets[0].HostKeyAlgorithms = []string{"x509v3-sign-rsa"}
+	err := loader.validateConfig(cfg)
+	assert.ErrorContains(t, err, "targets[0].ciphers[2]: unsupported SSH cipher 'blowfish-cbc'")
+	assert.ErrorContains(t, err, "targets[0].macs[0]: unsupported SSH MAC 'hmac-md5'")
+	assert.ErrorContains(t, err, "targets[0].kex_algorithms[0]: unsupported SSH key exchange algorithm ''")
+	assert.ErrorContains(t, err, "targets[0].host_key_algorithms[0]: unsupported SSH host key algorithm 'x509v3-sign-rsa'")
+}
+
+func TestValidateTargetPlatform(t *testing.T) {
+	cfg := &Config{
+		Targets: []*target.Target{{Host: "example.com", User: "deploy", Password: "secret", Platform: target.PlatformWindows}},
+		Jobs:    []*job.Job{{Name: "app", Steps: []*job.Step{{Run: "iisreset"}}}},
+	}
+	loader := &DefaultLoader{validator: newValidator()}
+	assert.NoError(t, loader.validateConfig(cfg))
+
+	cfg.Targets[0].Platform = "macos"
+	assert.ErrorContains(t, loader.validateConfig(cfg), "targets[0].platform must be one of [unix windows], got 'macos'")
+}
+
+func TestValidateDockerExec(t *testing.T) {
+	cfg := &Config{
+		Targets: []*target.Target{{Host: "example.com", User: "deploy", Password: "secret"}},
+		Jobs: []*job.Job{{Name: "app", Steps: []*job.Step{
+			{DockerExec: &job.DockerExecStep{Container: "app", Command: []string{"./migrate"}}},
+		}}},
+	}
+	loader := &DefaultLoader{validator: newValidator()}
+	assert.NoError(t, loader.validateConfig(cfg))
+
+	cfg.Jobs[0].Steps[0].DockerExec = &job.DockerExecStep{Command: []string{}}
+	err := loader.validateConfig(cfg)
+	assert.ErrorContains(t, err, "jobs[0].steps[0].docker_exec.container is required")
+	assert.ErrorContains(t, err, "jobs[0].steps[0].docker_exec.command must be at least 1")
+
+	cfg.Jobs[0].Steps[0].Run = "./migrate"
+	assert.ErrorContains(t, loader.validateConfig(cfg), "exactly one of")
+}
+
+func TestValidateService(t *testing.T) {
+	cfg := &Config{
+		Targets: []*target.Target{{Host: "example.com", User: "deploy", Password: "secret"}},
+		Jobs: []*job.Job{{Name: "app", Steps: []*job.Step{
+			{Service: &job.ServiceStep{Name: "nginx", Action: job.ServiceRestart, Verify: true}},
+			{Service: &job.ServiceStep{Name: "nginx", Action: job.ServiceEnable, Manager: job.ServiceManagerOpenRC}},
+		}}},
+	}
+	loader := &DefaultLoader{validator: newValidator()}
+	assert.NoError(t, loader.validateConfig(cfg))
+
+	cfg.Jobs[0].Steps[0].Service = &job.ServiceStep{Action: "bounce", Manager: "upstart"}
+	cfg.Jobs[0].Steps[1].Service.Verify = true
+	err := loader.validateConfig(cfg)
+	assert.ErrorContains(t, err, "jobs[0].steps[0].service.name is required")
+	assert.ErrorContains(t, err, "jobs[0].steps[0].service.action must be one of [start stop restart reload enable disable], got 'bounce'")
+	assert.ErrorContains(t, err, "jobs[0].steps[0].service.manager must be one of [systemd openrc], got 'upstart'")
+	assert.ErrorContains(t, err, "jobs[0].steps[1].service.verify can only be used with the start, restart and reload actions, got 'enable'")
+}
+
+func TestValidateAssert(t *testing.T) {
+	cfg := &Config{
+		Targets: []*target.Target{{Host: "example.com", User: "deploy", Password: "secret"}},
+		Jobs: []*job.Job{{Name: "app", Steps: []*job.Step{
+			{Assert: &job.AssertStep{Command: "curl -s localhost/health", Contains: "ok"}},
+		}}},
+	}
+	loader := &DefaultLoader{validator: newValidator()}
+	assert.NoError(t, loader.validateConfig(cfg))
+
+	cfg.Jobs[0].Steps[0].Assert = &job.AssertStep{ExitCode: 256}
+	err := loader.validateConfig(cfg)
+	assert.ErrorContains(t, err, "jobs[0].steps[0].assert.command is required")
+	assert.ErrorContains(t, err, "jobs[0].steps[0].assert.exit_code must be at most 255")
+}
+
+func TestValidateContainerCopy(t *testing.T) {
+	cfg := &Config{
+		Targets: []*target.Target{{Host: "example.com", User: "deploy", Password: "secret"}},
+		Jobs: []*job.Job{{Name: "app", Steps: []*job.Step{
+			{Copy: &job.CopyStep{Local: "./nginx.conf", Remote: "/etc/nginx/nginx.conf", Container: "web"}},
+		}}},
+	}
+	loader := &DefaultLoader{validator: newValidator()}
+	assert.NoError(t, loader.validateConfig(cfg))
+
+	cfg.Jobs[0].Steps[0].Copy.Remote = "nginx.conf"
+	cfg.Jobs[0].Steps[0].Copy.Delete = true
+	err := loader.validateConfig(cfg)
+	assert.ErrorContains(t, err, "jobs[0].steps[0].copy.remote must be an absolute path when copying into a container, got 'nginx.conf'")
+	assert.ErrorContains(t, err, "jobs[0].steps[0].copy.delete cannot be used when copying into a container")
+
+	cfg.Jobs[0].Steps[0].Copy.Container = ""
+	assert.NoError(t, loader.validateConfig(cfg), "relative paths and delete are allowed on the host")
+}
+
+func TestValidateStepCommandOptions(t *testing.T) {
+	cfg := &Config{
+		Targets: []*target.Target{{Host: "example.com", User: "deploy", Password: "secret"}},
+		Jobs: []*job.Job{{Name: "app", Steps: []*job.Step{
+			{Run: "make", Env: map[string]string{"APP_ENV": "production"}, Workdir: "/srv/app"},
+			{Copy: &job.CopyStep{Local: "./dist", Remote: "/srv/app"}, Env: map[string]string{"APP_ENV": "production"}},
+			{Docker: &job.DockerStep{Image: "nginx", Name: "web"}, Workdir: "/srv/app"},
+		}}},
+	}
+	loader := &DefaultLoader{validator: newValidator()}
+
+	err := loader.validateConfig(cfg)
+	assert.ErrorContains(t, err, "jobs[0].steps[1].env can only be used with run, script_file and run_script steps")
+	assert.ErrorContains(t, err, "jobs[0].steps[2].workdir can only be used with run, script_file and run_script steps")
+	assert.NotContains(t, err.Error(), "steps[0]", "env and workdir apply to run steps")
+}
+
+func TestValidateStepCondition(t *testing.T) {
+	cfg := &Config{
+		Targets: []*target.Target{{Host: "example.com", User: "deploy", Password: "secret"}},
+		Jobs:    []*job.Job{{Name: "app", Steps: []*job.Step{{Run: "make", When: `target.name == "prod"`}}}},
+	}
+	loader := &DefaultLoader{validator: newValidator()}
+	assert.NoError(t, loader.validateConfig(cfg))
+
+	cfg.Jobs[0].Steps[0].When = `target.name = "prod"`
+	err := loader.validateConfig(cfg)
+	assert.ErrorContains(t, err, `jobs[0].steps[0].when: invalid condition 'target.name = "prod"': unexpected character '=' at position 13`)
+}
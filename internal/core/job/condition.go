@@ -0,0 +1,312 @@
+package job
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// Condition is a parsed when expression of a step. It compares quoted strings and variables with == and !=
+// and combines comparisons with &&, || and !, grouped by parentheses. The variables are env.NAME, the local
+// environment variable NAME, and target.name, target.host, target.user and target.port. A variable or
+// string on its own is true unless it is empty or "false", e.g. `env.DEPLOY_DOCS && target.name != "prod"`.
+type Condition struct {
+	root conditionNode
+}
+
+// conditionScope holds the values of the variables a condition is evaluated with
+type conditionScope struct {
+	target *target.Target
+	getenv func(string) string
+}
+
+// conditionNode is a boolean expression of a condition
+type conditionNode interface {
+	eval(scope *conditionScope) bool
+}
+
+// conditionOperand is a string value of a condition
+type conditionOperand interface {
+	value(scope *conditionScope) string
+}
+
+// targetVariables maps the target variables of conditions to their values
+var targetVariables = map[string]func(tgt *target.Target) string{
+	"name": (*target.Target).GetName,
+	"host": func(tgt *target.Target) string { return tgt.Host },
+	"user": func(tgt *target.Target) string { return tgt.User },
+	"port": func(tgt *target.Target) string { return strconv.Itoa(tgt.GetPort()) },
+}
+
+// ParseCondition parses a when expression, see Condition
+func ParseCondition(expr string) (*Condition, error) {
+	tokens, err := tokenizeCondition(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &conditionParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected '%s' at position %d", tok.text, tok.pos+1)
+	}
+	return &Condition{root: root}, nil
+}
+
+// Holds evaluates the condition on tgt, looking up environment variables with getenv
+func (c *Condition) Holds(tgt *target.Target, getenv func(string) string) bool {
+	return c.root.eval(&conditionScope{target: tgt, getenv: getenv})
+}
+
+// ConditionHolds reports whether the when condition of the step holds on tgt. Steps without one always run.
+func (s *Step) ConditionHolds(tgt *target.Target, getenv func(string) string) (bool, error) {
+	if s.When == "" {
+		return true, nil
+	}
+
+	condition, err := ParseCondition(s.When)
+	if err != nil {
+		return false, fmt.Errorf("invalid when condition '%s': %w", s.When, err)
+	}
+	return condition.Holds(tgt, getenv), nil
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+// conditionToken is a lexical token of a condition, pos is its offset in the expression
+type conditionToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// conditionOperators lists the operators and parentheses of conditions, two-character ones first
+var conditionOperators = []string{"==", "!=", "&&", "||", "!", "(", ")"}
+
+// tokenizeCondition splits a condition into tokens
+func tokenizeCondition(expr string) ([]conditionToken, error) {
+	var tokens []conditionToken
+	for pos := 0; pos < len(expr); {
+		if expr[pos] == ' ' || expr[pos] == '\t' || expr[pos] == '\n' {
+			pos++
+			continue
+		}
+
+		tok, err := scanConditionToken(expr, pos)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, tok)
+		pos += len(tok.text)
+		if tok.kind == tokenString {
+			pos += 2
+		}
+	}
+	return append(tokens, conditionToken{kind: tokenEnd, text: "end of condition", pos: len(expr)}), nil
+}
+
+// scanConditionToken returns the token starting at pos. The text of strings excludes their quotes.
+func scanConditionToken(expr string, pos int) (conditionToken, error) {
+	c := expr[pos]
+	if c == '"' || c == '\'' {
+		return scanConditionString(expr, pos)
+	}
+
+	for _, op := range conditionOperators {
+		if strings.HasPrefix(expr[pos:], op) {
+			return conditionToken{kind: tokenOperator, text: op, pos: pos}, nil
+		}
+	}
+
+	if end := identEnd(expr, pos); end > pos {
+		return conditionToken{kind: tokenIdent, text: expr[pos:end], pos: pos}, nil
+	}
+	return conditionToken{}, fmt.Errorf("unexpected character '%c' at position %d", c, pos+1)
+}
+
+// scanConditionString returns the string quoted by the character at pos
+func scanConditionString(expr string, pos int) (conditionToken, error) {
+	end := strings.IndexByte(expr[pos+1:], expr[pos])
+	if end < 0 {
+		return conditionToken{}, fmt.Errorf("unterminated string at position %d", pos+1)
+	}
+	return conditionToken{kind: tokenString, text: expr[pos+1 : pos+1+end], pos: pos}, nil
+}
+
+// identEnd returns the end of the variable name starting at pos
+func identEnd(expr string, pos int) int {
+	for pos < len(expr) && isIdentChar(rune(expr[pos])) {
+		pos++
+	}
+	return pos
+}
+
+// isIdentChar reports whether c may be part of a variable name
+func isIdentChar(c rune) bool {
+	return c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.')
+}
+
+// conditionParser is a recursive descent parser of conditions. && binds tighter than ||.
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+}
+
+func (p *conditionParser) peek() conditionToken {
+	return p.tokens[p.pos]
+}
+
+func (p *conditionParser) next() conditionToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEnd {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is the operator op
+func (p *conditionParser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokenOperator && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *conditionParser) parseOr() (conditionNode, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.accept("||") {
+		var right conditionNode
+		right, err = p.parseAnd()
+		left = orNode{left, right}
+	}
+	return left, err
+}
+
+func (p *conditionParser) parseAnd() (conditionNode, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.accept("&&") {
+		var right conditionNode
+		right, err = p.parseUnary()
+		left = andNode{left, right}
+	}
+	return left, err
+}
+
+func (p *conditionParser) parseUnary() (conditionNode, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		return notNode{operand}, err
+	}
+	if p.accept("(") {
+		node, err := p.parseOr()
+		if err == nil && !p.accept(")") {
+			tok := p.peek()
+			err = fmt.Errorf("expected ')' at position %d, got '%s'", tok.pos+1, tok.text)
+		}
+		return node, err
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses a comparison of two operands or a single operand tested for truth
+func (p *conditionParser) parseComparison() (conditionNode, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range []string{"==", "!="} {
+		if p.accept(op) {
+			right, err := p.parseOperand()
+			return compareNode{left: left, right: right, equal: op == "=="}, err
+		}
+	}
+	return truthNode{left}, nil
+}
+
+// parseOperand parses a quoted string or a variable
+func (p *conditionParser) parseOperand() (conditionOperand, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenString:
+		return literal(tok.text), nil
+	case tokenIdent:
+		return parseVariable(tok)
+	default:
+		return nil, fmt.Errorf("expected a string or variable at position %d, got '%s'", tok.pos+1, tok.text)
+	}
+}
+
+// parseVariable parses an env.NAME or target variable, or the literals true and false
+func parseVariable(tok conditionToken) (conditionOperand, error) {
+	if tok.text == "true" || tok.text == "false" {
+		return literal(tok.text), nil
+	}
+
+	namespace, name, _ := strings.Cut(tok.text, ".")
+	switch {
+	case namespace == "env" && name != "":
+		return envVariable(name), nil
+	case namespace == "target" && targetVariables[name] != nil:
+		return targetVariable(name), nil
+	default:
+		return nil, fmt.Errorf("unknown variable '%s' at position %d, expected env.NAME or target.name, host, user or port",
+			tok.text, tok.pos+1)
+	}
+}
+
+type literal string
+
+func (l literal) value(*conditionScope) string { return string(l) }
+
+type envVariable string
+
+func (v envVariable) value(scope *conditionScope) string { return scope.getenv(string(v)) }
+
+type targetVariable string
+
+func (v targetVariable) value(scope *conditionScope) string {
+	return targetVariables[string(v)](scope.target)
+}
+
+type orNode struct{ left, right conditionNode }
+
+func (n orNode) eval(scope *conditionScope) bool { return n.left.eval(scope) || n.right.eval(scope) }
+
+type andNode struct{ left, right conditionNode }
+
+func (n andNode) eval(scope *conditionScope) bool { return n.left.eval(scope) && n.right.eval(scope) }
+
+type notNode struct{ operand conditionNode }
+
+func (n notNode) eval(scope *conditionScope) bool { return !n.operand.eval(scope) }
+
+type compareNode struct {
+	left, right conditionOperand
+	equal       bool
+}
+
+func (n compareNode) eval(scope *conditionScope) bool {
+	return (n.left.value(scope) == n.right.value(scope)) == n.equal
+}
+
+type truthNode struct{ operand conditionOperand }
+
+func (n truthNode) eval(scope *conditionScope) bool {
+	value := n.operand.value(scope)
+	return value != "" && value != "false"
+}
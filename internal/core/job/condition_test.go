@@ -0,0 +1,86 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+func TestConditionHolds(t *testing.T) {
+	tgt := &target.Target{Name: "prod", Host: "web.example.com", User: "deploy"}
+	env := map[string]string{"FOO": "bar", "DEPLOY_DOCS": "1", "DISABLED": "false"}
+	getenv := func(name string) string { return env[name] }
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`env.FOO == "bar"`, true},
+		{`env.FOO != "bar"`, false},
+		{`env.MISSING == ""`, true},
+		{`target.name == 'prod'`, true},
+		{`target.host == "web.example.com" && target.user == "deploy"`, true},
+		{`target.port == "22"`, true},
+		{`target.name == "staging" || env.FOO == "bar"`, true},
+		{`target.name == "staging" || env.FOO == "baz"`, false},
+		{`!(target.name == "prod")`, false},
+		{`env.DEPLOY_DOCS`, true},
+		{`env.MISSING`, false},
+		{`env.DISABLED`, false},
+		{`!env.MISSING`, true},
+		{`true && !false`, true},
+		{`target.name == "staging" || target.name == "prod" && env.FOO == "baz"`, false},
+		{`(target.name == "staging" || target.name == "prod") && env.FOO == "bar"`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			condition, err := ParseCondition(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, condition.Holds(tgt, getenv))
+		})
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{`env.FOO == "bar`, "unterminated string at position 12"},
+		{`target.region == "eu"`, "unknown variable 'target.region' at position 1"},
+		{`foo == "bar"`, "unknown variable 'foo'"},
+		{`env. == "bar"`, "unknown variable 'env.'"},
+		{`env.FOO ==`, "expected a string or variable at position 11, got 'end of condition'"},
+		{`(env.FOO == "bar"`, "expected ')' at position 18"},
+		{`env.FOO == "bar" env.BAZ`, "unexpected 'env.BAZ' at position 18"},
+		{`env.FOO = "bar"`, "unexpected character '=' at position 9"},
+		{``, "expected a string or variable at position 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseCondition(tt.expr)
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestStepConditionHolds(t *testing.T) {
+	tgt := &target.Target{Name: "prod"}
+	getenv := func(string) string { return "" }
+
+	holds, err := (&Step{Run: "make"}).ConditionHolds(tgt, getenv)
+	require.NoError(t, err)
+	assert.True(t, holds, "steps without a condition should always run")
+
+	holds, err = (&Step{Run: "make", When: `target.name == "staging"`}).ConditionHolds(tgt, getenv)
+	require.NoError(t, err)
+	assert.False(t, holds)
+
+	_, err = (&Step{Run: "make", When: `target.name ==`}).ConditionHolds(tgt, getenv)
+	assert.ErrorContains(t, err, "invalid when condition 'target.name =='")
+}
@@ -1,7 +1,10 @@
 // Package job provides core functionality for defining and executing deployment jobs.
 package job
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 // ConnectionError represents an error that occurs when connecting to a target.
 type ConnectionError struct {
@@ -13,6 +16,11 @@ func (e *ConnectionError) Error() string {
 	return fmt.Sprintf("connection to target %s failed: %v", e.Target, e.Cause)
 }
 
+// Unwrap returns the underlying cause.
+func (e *ConnectionError) Unwrap() error {
+	return e.Cause
+}
+
 // StepError represents an error that occurs during step execution.
 type StepError struct {
 	JobName  string
@@ -26,6 +34,11 @@ func (e *StepError) Error() string {
 	return fmt.Sprintf("job '%s' step %d/%d on '%s' failed: %v", e.JobName, e.StepNum, e.TotalNum, e.Target, e.Cause)
 }
 
+// Unwrap returns the underlying cause.
+func (e *StepError) Unwrap() error {
+	return e.Cause
+}
+
 // CommandError represents an error that occurs when executing a command.
 type CommandError struct {
 	Command string
@@ -40,6 +53,11 @@ func (e *CommandError) Error() string {
 	return fmt.Sprintf("command '%s' failed: %v", e.Command, e.Cause)
 }
 
+// Unwrap returns the underlying cause.
+func (e *CommandError) Unwrap() error {
+	return e.Cause
+}
+
 // CopyError represents an error that occurs during file copying.
 type CopyError struct {
 	Source      string
@@ -51,6 +69,11 @@ func (e *CopyError) Error() string {
 	return fmt.Sprintf("copying '%s' to '%s' failed: %v", e.Source, e.Destination, e.Cause)
 }
 
+// Unwrap returns the underlying cause.
+func (e *CopyError) Unwrap() error {
+	return e.Cause
+}
+
 // DockerError represents an error that occurs during Docker operations.
 type DockerError struct {
 	ContainerName string
@@ -61,3 +84,37 @@ type DockerError struct {
 func (e *DockerError) Error() string {
 	return fmt.Sprintf("Docker operation '%s' on container '%s' failed: %v", e.Operation, e.ContainerName, e.Cause)
 }
+
+// Unwrap returns the underlying cause.
+func (e *DockerError) Unwrap() error {
+	return e.Cause
+}
+
+// DeploymentError summarizes a deployment that kept going after failures. A job run is one job
+// on one target, and jobs that could not run because e.g. the connection failed count as failed.
+type DeploymentError struct {
+	Total  int
+	Failed int
+	Errors []error
+}
+
+func (e *DeploymentError) Error() string {
+	return fmt.Sprintf("%d of %d job runs failed: %v", e.Failed, e.Total, errors.Join(e.Errors...))
+}
+
+// Unwrap returns the failures of the deployment.
+func (e *DeploymentError) Unwrap() []error {
+	return e.Errors
+}
+
+// Partial reports whether some job runs succeeded despite the failures.
+func (e *DeploymentError) Partial() bool {
+	return e.Failed < e.Total
+}
+
+// IsConnectionError reports whether err was caused by a failed or lost connection to a target,
+// as opposed to e.g. a command that exited with a non-zero status.
+func IsConnectionError(err error) bool {
+	var connErr *ConnectionError
+	return errors.As(err, &connErr)
+}
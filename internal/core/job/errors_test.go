@@ -98,3 +98,14 @@ func TestDockerError(t *testing.T) {
 	expected := "Docker operation 'create' on container 'web-app' failed: image not found"
 	assert.Equal(t, expected, err.Error(), "DockerError message doesn't match expected format")
 }
+
+func TestIsConnectionError(t *testing.T) {
+	cause := errors.New("EOF")
+	connErr := &ConnectionError{Target: "web", Cause: cause}
+
+	assert.True(t, IsConnectionError(connErr))
+	assert.True(t, IsConnectionError(&StepError{JobName: "deploy", Cause: connErr}), "wrapped connection errors should be detected")
+	assert.False(t, IsConnectionError(&CommandError{Command: "false", Cause: errors.New("exit status 1")}))
+	assert.False(t, IsConnectionError(nil))
+	assert.ErrorIs(t, connErr, cause, "errors should unwrap to their cause")
+}
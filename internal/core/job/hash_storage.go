@@ -10,6 +10,7 @@ import (
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 
 	"github.com/nickalie/nship/internal/core/target"
 	"github.com/nickalie/nship/internal/util"
@@ -27,44 +28,103 @@ type HashStorage interface {
 	Clear() error
 }
 
+// ScopedHashStorage is a HashStorage that can also remove the hashes of a single target or job
+type ScopedHashStorage interface {
+	HashStorage
+
+	// ClearScope removes the hashes stored for the target and job, where an empty name matches
+	// any, and returns how many were removed
+	ClearScope(targetName, jobName string) (int, error)
+}
+
+// NamespacedHashStorage is a HashStorage that keeps the hashes of different configurations apart
+type NamespacedHashStorage interface {
+	HashStorage
+
+	// SetNamespace sets the namespace of the hashes that are saved and retrieved from now on.
+	// Hashes stored under another namespace, including those stored before namespaces existed, are not found.
+	SetNamespace(namespace string)
+}
+
+// MatchesScope reports whether a hash stored for targetName and jobName falls within the scope
+// of a ClearScope call for scopeTarget and scopeJob
+func MatchesScope(targetName, jobName, scopeTarget, scopeJob string) bool {
+	return (scopeTarget == "" || targetName == scopeTarget) && (scopeJob == "" || jobName == scopeJob)
+}
+
 // StepHasherInterface defines the interface for hash computation
 type StepHasherInterface interface {
 	ComputeHash(step *Step, tgt *target.Target) (string, error)
 }
 
 // StepHasher handles computing hashes for steps
-type StepHasher struct{}
+type StepHasher struct {
+	fs FileSystem
+}
 
-// NewStepHasher creates a new StepHasher
+// NewStepHasher creates a new StepHasher reading copy sources from the local file system
 func NewStepHasher() StepHasherInterface {
-	return &StepHasher{}
+	return NewStepHasherWithFileSystem(OSFileSystem{})
+}
+
+// NewStepHasherWithFileSystem creates a new StepHasher reading copy sources from fsys
+func NewStepHasherWithFileSystem(fsys FileSystem) StepHasherInterface {
+	return &StepHasher{fs: fsys}
 }
 
-// ComputeHash generates a hash for a step based on its configuration
-// For CopyStep, it also considers the source files
+// ComputeHash generates a hash for a step based on its configuration, including the remote
+// destination and exclude patterns of a CopyStep. For a CopyStep it also considers the metadata
+// and content of the source files, and for scripts their content.
 func (h *StepHasher) ComputeHash(step *Step, tgt *target.Target) (string, error) {
 	stepData, err := h.prepareStepData(step, tgt)
 	if err != nil {
 		return "", fmt.Errorf("prepare step data: %w", err)
 	}
 
+	hasher := sha256.New()
+	hasher.Write(stepData)
+
+	if err := h.processLocalFiles(step, hasher); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// processLocalFiles adds the local files a step depends on to the hash
+func (h *StepHasher) processLocalFiles(step *Step, hasher hash.Hash) error {
 	if step.Copy != nil {
-		hasher := sha256.New()
-		hasher.Write(stepData)
 		if err := h.processSourcePath(step.Copy, hasher); err != nil {
-			return "", fmt.Errorf("process source path: %w", err)
+			return fmt.Errorf("process source path: %w", err)
+		}
+	}
+
+	if script := scriptPath(step); script != "" {
+		content, err := h.fs.ReadFile(script)
+		if err != nil {
+			return fmt.Errorf("read script file: %w", err)
 		}
-		return hex.EncodeToString(hasher.Sum(nil)), nil
+		hasher.Write(content)
 	}
 
-	sum := sha256.Sum256(stepData)
-	return hex.EncodeToString(sum[:]), nil
+	return nil
 }
 
-// prepareStepData creates a copy of step data with sorted exclude patterns
+// scriptPath returns the local script a step executes, if any
+func scriptPath(step *Step) string {
+	if step.RunScript != nil {
+		return step.RunScript.Path
+	}
+	return step.ScriptFile
+}
+
+// prepareStepData creates a copy of step data with sorted exclude patterns, unless negated
+// patterns make their order significant. Parallel is left out, as it changes when the step runs, not what it does.
 func (h *StepHasher) prepareStepData(step *Step, tgt *target.Target) ([]byte, error) {
-	if step.Copy != nil && len(step.Copy.Exclude) > 0 {
-		stepCopy := *step
+	stepCopy := *step
+	stepCopy.Parallel = false
+
+	if step.Copy != nil && len(step.Copy.Exclude) > 0 && !util.HasNegatedPattern(step.Copy.Exclude) {
 		copyStepCopy := *step.Copy
 		stepCopy.Copy = &copyStepCopy
 
@@ -72,21 +132,13 @@ func (h *StepHasher) prepareStepData(step *Step, tgt *target.Target) ([]byte, er
 		copyStepCopy.Exclude = make([]string, len(step.Copy.Exclude))
 		copy(copyStepCopy.Exclude, step.Copy.Exclude)
 		sort.Strings(copyStepCopy.Exclude)
-
-		return json.Marshal(struct {
-			Step   *Step
-			Target *target.Target
-		}{
-			Step:   &stepCopy,
-			Target: tgt,
-		})
 	}
 
 	return json.Marshal(struct {
 		Step   *Step
 		Target *target.Target
 	}{
-		Step:   step,
+		Step:   &stepCopy,
 		Target: tgt,
 	})
 }
@@ -100,17 +152,27 @@ func (h *StepHasher) processSourcePath(copyStep *CopyStep, hasher hash.Hash) err
 	}
 
 	// Get file info
-	info, err := os.Stat(localPath)
+	info, err := h.fs.Stat(localPath)
 	if err != nil {
 		return fmt.Errorf("stat source: %w", err)
 	}
 
 	// Add file/directory info to hash
 	addFileInfoToHash(localPath, info, hasher)
+	if err := h.addFileContentToHash(localPath, info, hasher); err != nil {
+		return err
+	}
 
 	if info.IsDir() {
+		exclude, err := util.MergeIgnoreFile(localPath, copyStep.Exclude, h.fs.ReadFile)
+		if err != nil {
+			return err
+		}
+		// The step's own patterns are part of the step data, add those of the ignore file
+		hasher.Write([]byte(strings.Join(exclude[len(copyStep.Exclude):], "\n")))
+
 		// For directories, hash the structure recursively
-		if err := h.hashDirectory(localPath, copyStep.Exclude, hasher); err != nil {
+		if err := h.hashDirectory(localPath, exclude, hasher); err != nil {
 			return fmt.Errorf("hash directory: %w", err)
 		}
 	}
@@ -120,35 +182,90 @@ func (h *StepHasher) processSourcePath(copyStep *CopyStep, hasher hash.Hash) err
 
 // hashDirectory recursively hashes a directory's structure and file metadata
 func (h *StepHasher) hashDirectory(dir string, exclude []string, hasher hash.Hash) error {
-	entries, err := os.ReadDir(dir)
+	entries, err := h.fs.ReadDir(dir)
 	if err != nil {
 		return fmt.Errorf("read directory: %w", err)
 	}
 
 	// Sort entries for consistent hashing
 	entryNames := getSortedEntryNames(entries)
+	links := symlinkNames(entries)
 
 	for _, name := range entryNames {
-		path := filepath.Join(dir, name)
-
-		if util.IsExcluded(path, exclude) {
-			continue
+		if err := h.hashDirEntry(filepath.Join(dir, name), links[name], exclude, hasher); err != nil {
+			return err
 		}
+	}
 
-		info, err := os.Stat(path)
-		if err != nil {
-			return fmt.Errorf("stat entry: %w", err)
-		}
+	return nil
+}
+
+// hashDirEntry adds a directory entry that is not excluded to the hash
+func (h *StepHasher) hashDirEntry(path string, isLink bool, exclude []string, hasher hash.Hash) error {
+	if util.IsExcluded(path, exclude) {
+		return nil
+	}
 
+	info, err := h.fs.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat entry: %w", err)
+	}
+
+	if isLink && info.IsDir() {
+		return h.hashLinkedDir(path, info, exclude, hasher)
+	}
+	return h.hashEntry(path, info, exclude, hasher)
+}
+
+// hashLinkedDir adds a symlinked directory to the hash unless it contains the link itself,
+// in which case only the link is hashed to avoid descending into it forever
+func (h *StepHasher) hashLinkedDir(path string, info os.FileInfo, exclude []string, hasher hash.Hash) error {
+	cyclic, err := util.IsCyclicLink(path, info, h.fs.Stat)
+	if err != nil {
+		return fmt.Errorf("check symlink: %w", err)
+	}
+	if cyclic {
 		addFileInfoToHash(path, info, hasher)
+		return nil
+	}
+	return h.hashEntry(path, info, exclude, hasher)
+}
 
-		if info.IsDir() {
-			if err := h.hashDirectory(path, exclude, hasher); err != nil {
-				return err
-			}
+// symlinkNames returns the names of the entries that are symbolic links
+func symlinkNames(entries []os.DirEntry) map[string]bool {
+	links := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.Type()&os.ModeSymlink != 0 {
+			links[entry.Name()] = true
 		}
 	}
+	return links
+}
+
+// hashEntry adds a directory entry to the hash, descending into subdirectories
+func (h *StepHasher) hashEntry(path string, info os.FileInfo, exclude []string, hasher hash.Hash) error {
+	addFileInfoToHash(path, info, hasher)
+
+	if info.IsDir() {
+		return h.hashDirectory(path, exclude, hasher)
+	}
+	return h.addFileContentToHash(path, info, hasher)
+}
+
+// addFileContentToHash adds the content of a regular file to the hash, so that edits which
+// keep the size and modification time of a file are detected as well
+func (h *StepHasher) addFileContentToHash(path string, info os.FileInfo, hasher hash.Hash) error {
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	content, err := h.fs.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read file: %w", err)
+	}
 
+	fmt.Fprintf(hasher, "%d:", len(content))
+	hasher.Write(content)
 	return nil
 }
 
@@ -2,10 +2,13 @@ package job
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/nickalie/nship/internal/core/target"
+	"github.com/nickalie/nship/internal/util"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -326,3 +329,400 @@ func TestStepHasher_ComputeHash(t *testing.T) {
 		assert.Equal(t, hash2, hash3, "Copy steps with same exclude patterns in different order should have same hash")
 	})
 }
+
+// MockFileSystem implements FileSystem for testing
+type MockFileSystem struct {
+	StatFunc     func(path string) (os.FileInfo, error)
+	ReadDirFunc  func(path string) ([]os.DirEntry, error)
+	ReadFileFunc func(path string) ([]byte, error)
+}
+
+func (m *MockFileSystem) Stat(path string) (os.FileInfo, error) {
+	if m.StatFunc != nil {
+		return m.StatFunc(path)
+	}
+	return nil, os.ErrNotExist
+}
+
+func (m *MockFileSystem) ReadDir(path string) ([]os.DirEntry, error) {
+	if m.ReadDirFunc != nil {
+		return m.ReadDirFunc(path)
+	}
+	return nil, nil
+}
+
+func (m *MockFileSystem) ReadFile(path string) ([]byte, error) {
+	if m.ReadFileFunc != nil {
+		return m.ReadFileFunc(path)
+	}
+	return nil, os.ErrNotExist
+}
+
+func TestStepHasherScriptFile(t *testing.T) {
+	script := "echo one"
+	fsys := &MockFileSystem{
+		ReadFileFunc: func(path string) ([]byte, error) {
+			assert.Equal(t, "deploy.sh", path)
+			return []byte(script), nil
+		},
+	}
+
+	hasher := NewStepHasherWithFileSystem(fsys)
+	step := &Step{ScriptFile: "deploy.sh"}
+	tgt := &target.Target{Name: "web"}
+
+	hash1, err := hasher.ComputeHash(step, tgt)
+	assert.NoError(t, err)
+
+	script = "echo two"
+	hash2, err := hasher.ComputeHash(step, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash1, hash2, "changing the script content should change the hash")
+
+	_, err = NewStepHasherWithFileSystem(&MockFileSystem{}).ComputeHash(step, tgt)
+	assert.Error(t, err, "missing script file should fail")
+
+	runScript := &Step{RunScript: &RunScriptStep{Path: "deploy.sh", Args: []string{"prod"}}}
+	hash3, err := hasher.ComputeHash(runScript, tgt)
+	assert.NoError(t, err)
+
+	script = "echo three"
+	hash4, err := hasher.ComputeHash(runScript, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash3, hash4, "changing the uploaded script should change the hash")
+
+	runScript.RunScript.Args = []string{"staging"}
+	hash5, err := hasher.ComputeHash(runScript, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash4, hash5, "changing the script arguments should change the hash")
+}
+
+func TestStepHasherWithFileSystem(t *testing.T) {
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	size := int64(100)
+
+	fsys := &MockFileSystem{
+		StatFunc: func(path string) (os.FileInfo, error) {
+			if filepath.Base(path) == "dist" {
+				return &MockFileInfoForHashing{IsDirFunc: func() bool { return true }, ModTimeFunc: func() time.Time { return modTime }}, nil
+			}
+			return &MockFileInfoForHashing{SizeFunc: func() int64 { return size }, ModTimeFunc: func() time.Time { return modTime }}, nil
+		},
+		ReadDirFunc: func(path string) ([]os.DirEntry, error) {
+			return []os.DirEntry{&MockDirEntryForHashing{NameFunc: func() string { return "app.js" }}}, nil
+		},
+		ReadFileFunc: func(path string) ([]byte, error) {
+			return []byte("console.log('app')"), nil
+		},
+	}
+
+	hasher := NewStepHasherWithFileSystem(fsys)
+	step := &Step{Copy: &CopyStep{Local: "dist", Remote: "/var/www"}}
+	tgt := &target.Target{Name: "web"}
+
+	hash1, err := hasher.ComputeHash(step, tgt)
+	assert.NoError(t, err, "hashing should only use the provided file system")
+
+	size = 200
+	hash2, err := hasher.ComputeHash(step, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash1, hash2, "changing a file in the file system should change the hash")
+
+	_, err = NewStepHasherWithFileSystem(&MockFileSystem{}).ComputeHash(step, tgt)
+	assert.Error(t, err, "missing source should fail")
+}
+
+func TestStepHasherCopyStepInputs(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "assets"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>v1</h1>"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "assets", "app.css"), []byte("body{}"), 0644))
+
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+	base := &CopyStep{Local: dir, Remote: "/var/www/site", Exclude: []string{"*.log"}}
+
+	baseHash, err := hasher.ComputeHash(&Step{Copy: base}, tgt)
+	assert.NoError(t, err)
+
+	changedRemote := *base
+	changedRemote.Remote = "/var/www/other"
+	hash, err := hasher.ComputeHash(&Step{Copy: &changedRemote}, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, baseHash, hash, "changing only the remote destination should change the hash")
+
+	changedExclude := *base
+	changedExclude.Exclude = []string{"*.tmp"}
+	hash, err = hasher.ComputeHash(&Step{Copy: &changedExclude}, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, baseHash, hash, "changing the exclude patterns should change the hash")
+
+	// Same size and modification time, different content
+	cssPath := filepath.Join(dir, "assets", "app.css")
+	info, err := os.Stat(cssPath)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(cssPath, []byte("div{}\n"), 0644))
+	assert.NoError(t, os.Chtimes(cssPath, info.ModTime(), info.ModTime()))
+
+	hash, err = hasher.ComputeHash(&Step{Copy: base}, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, baseHash, hash, "changing file contents should change the hash")
+}
+
+func TestStepHasherIgnoreFile(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>v1</h1>"), 0644))
+
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+	step := &Step{Copy: &CopyStep{Local: dir, Remote: "/var/www/site"}}
+
+	baseHash, err := hasher.ComputeHash(step, tgt)
+	assert.NoError(t, err)
+
+	// The ignore file excludes itself, so only its patterns can change the hash
+	ignorePath := filepath.Join(dir, util.IgnoreFileName)
+	assert.NoError(t, os.WriteFile(ignorePath, []byte(".nshipignore\n*.log\n"), 0644))
+	logHash, err := hasher.ComputeHash(step, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, baseHash, logHash, "adding an ignore file should change the hash")
+
+	assert.NoError(t, os.WriteFile(ignorePath, []byte(".nshipignore\n*.tmp\n"), 0644))
+	hash, err := hasher.ComputeHash(step, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, logHash, hash, "editing the ignore file should change the hash")
+}
+
+func TestStepHasherNegatedExcludeOrder(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "keep.log"), []byte("content"), 0644))
+
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+
+	hash1, err := hasher.ComputeHash(&Step{Copy: &CopyStep{Local: dir, Remote: "/srv", Exclude: []string{"*.log", "!keep.log"}}}, tgt)
+	assert.NoError(t, err)
+	hash2, err := hasher.ComputeHash(&Step{Copy: &CopyStep{Local: dir, Remote: "/srv", Exclude: []string{"!keep.log", "*.log"}}}, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash1, hash2, "the order of patterns should matter when one is negated")
+}
+
+func TestStepHasherCyclicSymlink(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "index.html"), []byte("index"), 0644))
+	assert.NoError(t, os.Symlink(".", filepath.Join(dir, "self")))
+
+	_, err := NewStepHasher().ComputeHash(&Step{Copy: &CopyStep{Local: dir, Remote: "/var/www"}}, nil)
+	assert.NoError(t, err, "a symlink to a parent directory should not be followed endlessly")
+}
+
+func TestStepHasherSudo(t *testing.T) {
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+
+	plain, err := hasher.ComputeHash(&Step{Run: "systemctl restart app"}, tgt)
+	assert.NoError(t, err)
+
+	sudo, err := hasher.ComputeHash(&Step{Run: "systemctl restart app", Sudo: true}, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, plain, sudo, "enabling sudo should change the hash")
+
+	sudoUser, err := hasher.ComputeHash(&Step{Run: "systemctl restart app", Sudo: true, SudoUser: "app"}, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, sudo, sudoUser, "changing the sudo user should change the hash")
+}
+
+func TestStepHasherWhen(t *testing.T) {
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+
+	plain, err := hasher.ComputeHash(&Step{Run: "make docs"}, tgt)
+	assert.NoError(t, err)
+
+	conditional, err := hasher.ComputeHash(&Step{Run: "make docs", When: `env.DOCS == "1"`}, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, plain, conditional, "adding a condition should change the hash")
+
+	changed, err := hasher.ComputeHash(&Step{Run: "make docs", When: `env.DOCS == "yes"`}, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, conditional, changed, "changing the condition should change the hash")
+}
+
+func TestStepHasherTargetEnvironment(t *testing.T) {
+	hasher := NewStepHasher()
+	step := &Step{Run: "node --version"}
+
+	plain, err := hasher.ComputeHash(step, &target.Target{Name: "web"})
+	assert.NoError(t, err)
+
+	login, err := hasher.ComputeHash(step, &target.Target{Name: "web", LoginShell: true})
+	assert.NoError(t, err)
+	assert.NotEqual(t, plain, login, "running in a login shell should change the hash")
+
+	withEnv, err := hasher.ComputeHash(step, &target.Target{Name: "web", Env: map[string]string{"NODE_ENV": "production"}})
+	assert.NoError(t, err)
+	assert.NotEqual(t, plain, withEnv, "setting target environment variables should change the hash")
+}
+
+func TestStepHasherTargetShell(t *testing.T) {
+	hasher := NewStepHasher()
+	step := &Step{Run: "echo $BASH_VERSION"}
+
+	withoutShell, err := hasher.ComputeHash(step, &target.Target{Name: "web"})
+	assert.NoError(t, err)
+
+	withShell, err := hasher.ComputeHash(step, &target.Target{Name: "web", Shell: "bash"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, withoutShell, withShell, "changing the target default shell should change the hash")
+}
+
+func TestStepHasherExec(t *testing.T) {
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+
+	base, err := hasher.ComputeHash(&Step{Exec: &ExecStep{Command: []string{"./switch.sh", "blue"}}}, tgt)
+	assert.NoError(t, err)
+
+	command, err := hasher.ComputeHash(&Step{Exec: &ExecStep{Command: []string{"./switch.sh", "green"}}}, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, base, command, "changing the command should change the hash")
+
+	env, err := hasher.ComputeHash(&Step{Exec: &ExecStep{Command: []string{"./switch.sh", "blue"}, Env: map[string]string{"LB": "lb1"}}}, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, base, env, "changing the environment should change the hash")
+
+	job, err := hasher.ComputeHash(&Step{Exec: &ExecStep{Command: []string{"./switch.sh", "blue"}, Job: "deploy"}}, tgt)
+	assert.NoError(t, err)
+	assert.Equal(t, base, job, "the job name is not part of the step configuration")
+}
+
+func TestStepHasherDockerResourceLimits(t *testing.T) {
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+
+	base, err := hasher.ComputeHash(&Step{Docker: &DockerStep{Image: "nginx", Name: "web"}}, tgt)
+	assert.NoError(t, err)
+
+	limits := []*DockerStep{
+		{Image: "nginx", Name: "web", Memory: "512m"},
+		{Image: "nginx", Name: "web", CPUs: "1.5"},
+		{Image: "nginx", Name: "web", PidsLimit: 100},
+	}
+	for _, docker := range limits {
+		limited, err := hasher.ComputeHash(&Step{Docker: docker}, tgt)
+		assert.NoError(t, err)
+		assert.NotEqual(t, base, limited, "changing a resource limit should change the hash")
+	}
+}
+
+func TestStepHasherDockerEnvSources(t *testing.T) {
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+
+	base, err := hasher.ComputeHash(&Step{Docker: &DockerStep{Image: "nginx", Name: "web"}}, tgt)
+	assert.NoError(t, err)
+
+	envFile, err := hasher.ComputeHash(&Step{Docker: &DockerStep{Image: "nginx", Name: "web", EnvFile: "/etc/web.env"}}, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, base, envFile, "adding an env file should change the hash")
+
+	fromHost, err := hasher.ComputeHash(&Step{Docker: &DockerStep{Image: "nginx", Name: "web", EnvFromHost: []string{"HOSTNAME"}}}, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, base, fromHost, "passing host variables should change the hash")
+}
+
+func TestStepHasherDockerProcessOptions(t *testing.T) {
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+
+	base, err := hasher.ComputeHash(&Step{Docker: &DockerStep{Image: "nginx", Name: "web"}}, tgt)
+	assert.NoError(t, err)
+
+	options := []*DockerStep{
+		{Image: "nginx", Name: "web", User: "nginx"},
+		{Image: "nginx", Name: "web", Workdir: "/srv"},
+		{Image: "nginx", Name: "web", Entrypoint: []string{"/entrypoint.sh"}},
+	}
+	for _, docker := range options {
+		changed, err := hasher.ComputeHash(&Step{Docker: docker}, tgt)
+		assert.NoError(t, err)
+		assert.NotEqual(t, base, changed, "changing the process options should change the hash")
+	}
+}
+
+func TestStepHasherDockerPrune(t *testing.T) {
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+
+	base, err := hasher.ComputeHash(&Step{DockerPrune: &DockerPruneStep{}}, tgt)
+	assert.NoError(t, err)
+
+	options := []*DockerPruneStep{{All: true}, {Containers: true}, {Until: "24h"}}
+	for _, prune := range options {
+		changed, err := hasher.ComputeHash(&Step{DockerPrune: prune}, tgt)
+		assert.NoError(t, err)
+		assert.NotEqual(t, base, changed, "changing the prune options should change the hash")
+	}
+}
+
+func TestStepHasherService(t *testing.T) {
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+
+	base, err := hasher.ComputeHash(&Step{Service: &ServiceStep{Name: "nginx", Action: ServiceRestart}}, tgt)
+	assert.NoError(t, err)
+
+	changes := []*ServiceStep{
+		{Name: "nginx", Action: ServiceReload},
+		{Name: "apache2", Action: ServiceRestart},
+		{Name: "nginx", Action: ServiceRestart, Manager: ServiceManagerOpenRC},
+		{Name: "nginx", Action: ServiceRestart, Verify: true},
+	}
+	for _, service := range changes {
+		changed, err := hasher.ComputeHash(&Step{Service: service}, tgt)
+		assert.NoError(t, err)
+		assert.NotEqual(t, base, changed, "changing the service action should change the hash")
+	}
+}
+
+func TestStepHasherAssert(t *testing.T) {
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+
+	base, err := hasher.ComputeHash(&Step{Assert: &AssertStep{Command: "curl -s localhost/health"}}, tgt)
+	assert.NoError(t, err)
+
+	changes := []*AssertStep{
+		{Command: "curl -s localhost/ready"},
+		{Command: "curl -s localhost/health", ExitCode: 7},
+		{Command: "curl -s localhost/health", Contains: "ok"},
+	}
+	for _, check := range changes {
+		changed, err := hasher.ComputeHash(&Step{Assert: check}, tgt)
+		assert.NoError(t, err)
+		assert.NotEqual(t, base, changed, "changing the assertion should change the hash")
+	}
+}
+
+func TestStepHasherDockerExpectedDigest(t *testing.T) {
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+
+	base, err := hasher.ComputeHash(&Step{Docker: &DockerStep{Image: "nginx", Name: "web"}}, tgt)
+	assert.NoError(t, err)
+
+	pinned, err := hasher.ComputeHash(&Step{Docker: &DockerStep{Image: "nginx", Name: "web", ExpectedDigest: "sha256:" + strings.Repeat("a", 64)}}, tgt)
+	assert.NoError(t, err)
+	assert.NotEqual(t, base, pinned, "changing the expected digest should change the hash")
+}
+
+func TestStepHasherIgnoresParallel(t *testing.T) {
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+
+	sequential, err := hasher.ComputeHash(&Step{Run: "make"}, tgt)
+	assert.NoError(t, err)
+	parallel, err := hasher.ComputeHash(&Step{Run: "make", Parallel: true}, tgt)
+	assert.NoError(t, err)
+	assert.Equal(t, sequential, parallel, "running a step in parallel should not change its hash")
+}
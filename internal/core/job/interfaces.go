@@ -2,6 +2,9 @@
 package job
 
 import (
+	"context"
+	"os"
+
 	"github.com/nickalie/nship/internal/core/target"
 )
 
@@ -13,7 +16,72 @@ type Client interface {
 	Close()
 }
 
+// ContextClient is a Client that can abort a step in progress when its context is cancelled
+type ContextClient interface {
+	Client
+	// ExecuteStepContext executes a single step like ExecuteStep, stopping it when ctx is cancelled
+	ExecuteStepContext(ctx context.Context, step *Step, stepNum, totalSteps int) error
+}
+
+// OutputClient is a Client whose progress messages and command output can be redirected
+type OutputClient interface {
+	Client
+	// SetOutput makes the client write its progress messages and command output to out
+	SetOutput(out Output)
+}
+
+// LockingClient is a Client that can lock its target against concurrent deployments
+type LockingClient interface {
+	Client
+	// Lock takes the deploy lock of name on the target. A lock held by another deployment fails
+	// unless force is set, in which case it is replaced.
+	Lock(name string, force bool) error
+	// Unlock releases the deploy lock of name taken with Lock
+	Unlock(name string) error
+}
+
 // ClientFactory creates remote clients
 type ClientFactory interface {
 	NewClient(target *target.Target) (Client, error)
 }
+
+// ContextClientFactory is a ClientFactory that can abort connecting when its context is cancelled
+type ContextClientFactory interface {
+	ClientFactory
+	// NewClientContext creates a client like NewClient, giving up on connecting once ctx is done
+	NewClientContext(ctx context.Context, target *target.Target) (Client, error)
+}
+
+// NewClientContext creates a client for target with factory, which gives up on connecting once ctx is done
+// if it is a ContextClientFactory
+func NewClientContext(ctx context.Context, factory ClientFactory, target *target.Target) (Client, error) {
+	if contextFactory, ok := factory.(ContextClientFactory); ok {
+		return contextFactory.NewClientContext(ctx, target)
+	}
+	return factory.NewClient(target)
+}
+
+// FileSystem provides read access to local files, e.g. the sources of copy steps and script files
+type FileSystem interface {
+	Stat(path string) (os.FileInfo, error)
+	ReadDir(path string) ([]os.DirEntry, error)
+	ReadFile(path string) ([]byte, error)
+}
+
+// OSFileSystem implements FileSystem using the local operating system
+type OSFileSystem struct{}
+
+// Stat implements FileSystem
+func (OSFileSystem) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+// ReadDir implements FileSystem
+func (OSFileSystem) ReadDir(path string) ([]os.DirEntry, error) {
+	return os.ReadDir(path)
+}
+
+// ReadFile implements FileSystem
+func (OSFileSystem) ReadFile(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
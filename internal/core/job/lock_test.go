@@ -0,0 +1,78 @@
+package job
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// lockingClient is a recordingClient that records taking and releasing deploy locks among its steps
+type lockingClient struct {
+	recordingClient
+	lockErr error
+	force   bool
+}
+
+func (c *lockingClient) Lock(name string, force bool) error {
+	c.executed = append(c.executed, "lock "+name)
+	c.force = force
+	return c.lockErr
+}
+
+func (c *lockingClient) Unlock(name string) error {
+	c.executed = append(c.executed, "unlock "+name)
+	return nil
+}
+
+func TestExecuteJobsLocksTargets(t *testing.T) {
+	jobs := []*Job{{Name: "deploy", Steps: []*Step{{Run: "migrate"}, {Run: "restart"}}}}
+	afterAll := &Job{Steps: []*Step{{Run: "cleanup"}}}
+
+	tests := []struct {
+		name     string
+		client   *lockingClient
+		lockName string
+		options  []ServiceOption
+		expected []string
+		err      string
+	}{
+		{name: "locked", client: &lockingClient{}, lockName: "shop",
+			expected: []string{"lock shop", "migrate", "restart", "cleanup", "unlock shop"}},
+		{name: "failing job", client: &lockingClient{recordingClient: recordingClient{failingStep: "migrate"}}, lockName: "shop",
+			expected: []string{"lock shop", "migrate", "cleanup", "unlock shop"}, err: "migrate broke"},
+		{name: "locked by other deployment", client: &lockingClient{lockErr: errors.New("target 'web' is locked by alice")}, lockName: "shop",
+			expected: []string{"lock shop"}, err: "target 'web' is locked by alice"},
+		{name: "without lock name", client: &lockingClient{}, expected: []string{"migrate", "restart", "cleanup"}},
+		{name: "without reconnects", client: &lockingClient{}, lockName: "shop", options: []ServiceOption{WithReconnect(0, 0)},
+			expected: []string{"lock shop", "migrate", "restart", "cleanup", "unlock shop"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewService(clientFactoryFunc(func(*target.Target) (Client, error) { return tt.client, nil }), tt.options...)
+			service.SetLockName(tt.lockName)
+			err := service.ExecuteJobsWithHooks([]*target.Target{{Name: "web"}}, jobs, nil, afterAll)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expected, tt.client.executed)
+			assert.False(t, tt.client.force)
+		})
+	}
+}
+
+func TestExecuteJobForceUnlock(t *testing.T) {
+	client := &lockingClient{}
+	service := NewService(clientFactoryFunc(func(*target.Target) (Client, error) { return client, nil }), WithForceUnlock(true))
+	service.SetLockName("shop")
+
+	require.NoError(t, service.ExecuteJob(&target.Target{Name: "web"}, &Job{Name: "deploy", Steps: []*Step{{Run: "migrate"}}}))
+	assert.Equal(t, []string{"lock shop", "migrate", "unlock shop"}, client.executed)
+	assert.True(t, client.force)
+}
@@ -0,0 +1,133 @@
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// matrixVariable matches the ${matrix.KEY} references of a job
+var matrixVariable = regexp.MustCompile(`\$\{matrix\.(\w+)\}`)
+
+// ExpandMatrix returns jobs with every job that has a matrix replaced by one job per combination
+// of the matrix values, in which ${matrix.KEY} references are replaced by the values. Expanded jobs
+// are named after the job and the values, e.g. "deploy[region=eu]", so that their step hashes
+// are stored separately.
+func ExpandMatrix(jobs []*Job) ([]*Job, error) {
+	expanded := make([]*Job, 0, len(jobs))
+	for _, job := range jobs {
+		if len(job.Matrix) == 0 {
+			expanded = append(expanded, job)
+			continue
+		}
+
+		instances, err := expandJob(job)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand matrix of job '%s': %w", job.Name, err)
+		}
+		expanded = append(expanded, instances...)
+	}
+	return expanded, nil
+}
+
+// expandJob returns one instance of job per combination of its matrix values
+func expandJob(job *Job) ([]*Job, error) {
+	template := *job
+	template.Matrix = nil
+	data, err := json.Marshal(&template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	combinations, err := matrixCombinations(job.Matrix)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]*Job, 0, len(combinations))
+	for _, values := range combinations {
+		instance, err := instantiateJob(data, values)
+		if err != nil {
+			return nil, err
+		}
+		instance.Name = fmt.Sprintf("%s[%s]", job.Name, matrixLabel(values))
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+// instantiateJob decodes the JSON encoded job with its matrix references replaced by values
+func instantiateJob(data []byte, values map[string]string) (*Job, error) {
+	var missing string
+	replaced := matrixVariable.ReplaceAllStringFunc(string(data), func(ref string) string {
+		key := matrixVariable.FindStringSubmatch(ref)[1]
+		value, ok := values[key]
+		if !ok {
+			missing = key
+			return ref
+		}
+		// The value is inserted into a JSON string, so it is escaped like one
+		quoted, _ := json.Marshal(value)
+		return string(quoted[1 : len(quoted)-1])
+	})
+	if missing != "" {
+		return nil, fmt.Errorf("unknown matrix key '%s'", missing)
+	}
+
+	var instance Job
+	if err := json.Unmarshal([]byte(replaced), &instance); err != nil {
+		return nil, fmt.Errorf("failed to decode job: %w", err)
+	}
+	return &instance, nil
+}
+
+// matrixCombinations returns every combination of the matrix values, varying the values of
+// the last key in alphabetical order fastest
+func matrixCombinations(matrix map[string][]string) ([]map[string]string, error) {
+	combinations := []map[string]string{{}}
+	for _, key := range sortedKeys(matrix) {
+		if len(matrix[key]) == 0 {
+			return nil, fmt.Errorf("matrix key '%s' has no values", key)
+		}
+
+		next := make([]map[string]string, 0, len(combinations)*len(matrix[key]))
+		for _, combination := range combinations {
+			for _, value := range matrix[key] {
+				next = append(next, withValue(combination, key, value))
+			}
+		}
+		combinations = next
+	}
+	return combinations, nil
+}
+
+// withValue returns a copy of combination that also holds the value of key
+func withValue(combination map[string]string, key, value string) map[string]string {
+	extended := make(map[string]string, len(combination)+1)
+	for k, v := range combination {
+		extended[k] = v
+	}
+	extended[key] = value
+	return extended
+}
+
+// matrixLabel formats the values of a combination as key=value pairs sorted by key
+func matrixLabel(values map[string]string) string {
+	pairs := make([]string, 0, len(values))
+	for _, key := range sortedKeys(values) {
+		pairs = append(pairs, key+"="+values[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// sortedKeys returns the keys of m in alphabetical order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
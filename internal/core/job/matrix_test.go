@@ -0,0 +1,105 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+func TestExpandMatrix(t *testing.T) {
+	plain := &Job{Name: "setup", Steps: []*Step{{Run: "echo ${matrix.region}"}}}
+	deploy := &Job{
+		Name: "deploy",
+		Matrix: map[string][]string{
+			"region": {"eu", "us"},
+			"tier":   {"web", "api"},
+		},
+		Steps: []*Step{
+			{Run: `echo "deploying ${matrix.tier} to ${matrix.region}"`},
+			{Copy: &CopyStep{Local: "./dist/${matrix.tier}", Remote: "/srv/${matrix.region}/${matrix.tier}"}},
+		},
+		OnFailure: []*Step{{Run: "echo ${matrix.region} failed: ${error}"}},
+	}
+
+	jobs, err := ExpandMatrix([]*Job{plain, deploy})
+	require.NoError(t, err)
+	require.Len(t, jobs, 5)
+
+	assert.Same(t, plain, jobs[0], "jobs without a matrix should be kept as they are")
+
+	names := make([]string, 0, len(jobs))
+	for _, job := range jobs[1:] {
+		names = append(names, job.Name)
+		assert.Nil(t, job.Matrix)
+	}
+	assert.Equal(t, []string{
+		"deploy[region=eu,tier=web]",
+		"deploy[region=eu,tier=api]",
+		"deploy[region=us,tier=web]",
+		"deploy[region=us,tier=api]",
+	}, names)
+
+	instance := jobs[2]
+	assert.Equal(t, `echo "deploying api to eu"`, instance.Steps[0].Run)
+	assert.Equal(t, "./dist/api", instance.Steps[1].Copy.Local)
+	assert.Equal(t, "/srv/eu/api", instance.Steps[1].Copy.Remote)
+	assert.Equal(t, "echo eu failed: ${error}", instance.OnFailure[0].Run)
+	assert.Contains(t, deploy.Steps[0].Run, "${matrix.tier}", "the original job should not be modified")
+}
+
+func TestExpandMatrixEscapesValues(t *testing.T) {
+	job := &Job{
+		Name:   "deploy",
+		Matrix: map[string][]string{"message": {`say "hi"\now`}},
+		Steps:  []*Step{{Run: "echo ${matrix.message}"}},
+	}
+
+	jobs, err := ExpandMatrix([]*Job{job})
+	require.NoError(t, err)
+	assert.Equal(t, `echo say "hi"\now`, jobs[0].Steps[0].Run)
+}
+
+func TestExpandMatrixErrors(t *testing.T) {
+	_, err := ExpandMatrix([]*Job{{
+		Name:   "deploy",
+		Matrix: map[string][]string{"region": {"eu"}},
+		Steps:  []*Step{{Run: "echo ${matrix.zone}"}},
+	}})
+	assert.EqualError(t, err, "failed to expand matrix of job 'deploy': unknown matrix key 'zone'")
+
+	_, err = ExpandMatrix([]*Job{{
+		Name:   "deploy",
+		Matrix: map[string][]string{"region": {}},
+		Steps:  []*Step{{Run: "echo deploy"}},
+	}})
+	assert.EqualError(t, err, "failed to expand matrix of job 'deploy': matrix key 'region' has no values")
+}
+
+func TestExecuteJobsExpandsMatrix(t *testing.T) {
+	client := &recordingClient{}
+	mockClientFactory := &MockClientFactory{}
+	mockClientFactory.On("NewClient", mock.Anything).Return(client, nil)
+
+	var savedJobs []string
+	mockHashStorage := &MockHashStorage{
+		SaveHashFunc: func(_, jobName string, _ int, _ string) error {
+			savedJobs = append(savedJobs, jobName)
+			return nil
+		},
+	}
+
+	job := &Job{
+		Name:   "deploy",
+		Matrix: map[string][]string{"region": {"eu", "us"}},
+		Steps:  []*Step{{Run: "deploy ${matrix.region}"}},
+	}
+
+	err := NewService(mockClientFactory, WithHashStorage(mockHashStorage)).ExecuteJobs([]*target.Target{{Name: "web"}}, []*Job{job})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deploy eu", "deploy us"}, client.executed)
+	assert.Equal(t, []string{"deploy[region=eu]", "deploy[region=us]"}, savedJobs, "each instance should store its own hashes")
+}
@@ -1,45 +1,289 @@
 package job
 
+import (
+	"strings"
+	"time"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
 // Job represents a collection of steps to be executed on targets.
+// BeforeJob hooks run before the steps, AfterJob hooks after they succeed and
+// OnFailure hooks when a hook or step fails. Hooks always run, even when skipping unchanged steps.
+// A job with a Matrix runs once per combination of its values, see ExpandMatrix.
+// With the rolling Strategy, the HealthCheck gates each target before the next one is deployed. MaxUnavailable
+// is the number of targets deployed at once, but targets are always deployed one at a time, so values above 1
+// behave like 1.
+//
+//nolint:lll // long struct tags needed for complete configuration
 type Job struct {
-	Name  string  `yaml:"name,omitempty" json:"name,omitempty" toml:"name,omitempty" validate:"omitempty"`
-	Steps []*Step `yaml:"steps" json:"steps" toml:"steps" validate:"required,dive"`
+	Name           string              `yaml:"name,omitempty" json:"name,omitempty" toml:"name,omitempty" hcl:"name,optional" validate:"omitempty"`
+	Steps          []*Step             `yaml:"steps" json:"steps" toml:"steps" hcl:"steps,block" validate:"required,dive"`
+	BeforeJob      []*Step             `yaml:"before_job,omitempty" json:"before_job,omitempty" toml:"before_job,omitempty" hcl:"before_job,block" validate:"omitempty,dive"`
+	AfterJob       []*Step             `yaml:"after_job,omitempty" json:"after_job,omitempty" toml:"after_job,omitempty" hcl:"after_job,block" validate:"omitempty,dive"`
+	OnFailure      []*Step             `yaml:"on_failure,omitempty" json:"on_failure,omitempty" toml:"on_failure,omitempty" hcl:"on_failure,block" validate:"omitempty,dive"`
+	Matrix         map[string][]string `yaml:"matrix,omitempty" json:"matrix,omitempty" toml:"matrix,omitempty" hcl:"matrix,optional" validate:"omitempty"`
+	Strategy       string              `yaml:"strategy,omitempty" json:"strategy,omitempty" toml:"strategy,omitempty" hcl:"strategy,optional" validate:"omitempty,oneof=rolling"`
+	MaxUnavailable int                 `yaml:"max_unavailable,omitempty" json:"max_unavailable,omitempty" toml:"max_unavailable,omitempty" hcl:"max_unavailable,optional" validate:"omitempty,min=1,excluded_without=Strategy"`
+	HealthCheck    *HealthCheck        `yaml:"health_check,omitempty" json:"health_check,omitempty" toml:"health_check,omitempty" hcl:"health_check,block" validate:"omitempty,excluded_without=Strategy"`
+}
+
+// StrategyRolling deploys a job to one target at a time, checking the health of each target before the next one
+const StrategyRolling = "rolling"
+
+// HealthCheck runs Command on a target after the steps of a rolling job succeeded on it. The command is retried
+// up to Retries times, waiting Interval, 5s by default, between attempts, and the job fails on the target unless
+// it exits with 0.
+//
+//nolint:lll // long struct tags needed for complete configuration
+type HealthCheck struct {
+	Command  string `yaml:"command" json:"command" toml:"command" hcl:"command,optional" validate:"required"`
+	Retries  int    `yaml:"retries,omitempty" json:"retries,omitempty" toml:"retries,omitempty" hcl:"retries,optional" validate:"min=0"`
+	Interval string `yaml:"interval,omitempty" json:"interval,omitempty" toml:"interval,omitempty" hcl:"interval,optional" validate:"omitempty,duration"`
+}
+
+// DefaultHealthCheckInterval is the time waited between the attempts of a health check without an interval
+const DefaultHealthCheckInterval = "5s"
+
+// GetInterval returns the time waited between the attempts of the health check
+func (h *HealthCheck) GetInterval() string {
+	if h.Interval == "" {
+		return DefaultHealthCheckInterval
+	}
+	return h.Interval
 }
 
+// ErrorVariable is replaced with a reference to ErrorEnvVariable in the commands of OnFailure hooks
+const ErrorVariable = "${error}"
+
+// ErrorEnvVariable is the environment variable holding the failure message in OnFailure hooks
+const ErrorEnvVariable = "NSHIP_ERROR"
+
 // Step defines a single deployment action that can be either
-// a command execution, file copy operation, or Docker operation.
+// a command execution (inline or from a local script file), uploaded script,
+// file copy operation, file download, Docker operation, Docker prune, command in a running container, wait,
+// service action, assertion or local command. RunOnce steps
+// run on the first target of a job only. Env and Workdir set the environment variables and working directory
+// of the commands of run, script_file and run_script steps. FailFast controls whether a run command stops at
+// its first failing line, see FailsFast. A step with a When condition is skipped when it is false, see Condition.
+// Consecutive Parallel steps run at the same time.
+//
+//nolint:lll // long struct tags needed for complete configuration
 type Step struct {
-	Run    string      `yaml:"run,omitempty" json:"run,omitempty" toml:"run,omitempty" validate:"required_without_all=Copy Shell Docker"`
-	Copy   *CopyStep   `yaml:"copy,omitempty" json:"copy,omitempty" toml:"copy,omitempty" validate:"required_without_all=Run Shell Docker"`
-	Shell  string      `yaml:"shell,omitempty" json:"shell,omitempty" toml:"shell,omitempty" validate:"omitempty"`
-	Docker *DockerStep `yaml:"docker,omitempty" json:"docker,omitempty" toml:"docker,omitempty" validate:"required_without_all=Run Copy Shell"`
+	Run         string            `yaml:"run,omitempty" json:"run,omitempty" toml:"run,omitempty" hcl:"run,optional" validate:"omitempty"`
+	ScriptFile  string            `yaml:"script_file,omitempty" json:"script_file,omitempty" toml:"script_file,omitempty" hcl:"script_file,optional" validate:"omitempty,file"`
+	Copy        *CopyStep         `yaml:"copy,omitempty" json:"copy,omitempty" toml:"copy,omitempty" hcl:"copy,block" validate:"omitempty"`
+	Shell       string            `yaml:"shell,omitempty" json:"shell,omitempty" toml:"shell,omitempty" hcl:"shell,optional" validate:"omitempty"`
+	Env         map[string]string `yaml:"env,omitempty" json:"env,omitempty" toml:"env,omitempty" hcl:"env,optional" validate:"omitempty,dive,keys,env_name,endkeys"`
+	Workdir     string            `yaml:"workdir,omitempty" json:"workdir,omitempty" toml:"workdir,omitempty" hcl:"workdir,optional" validate:"omitempty"`
+	Docker      *DockerStep       `yaml:"docker,omitempty" json:"docker,omitempty" toml:"docker,omitempty" hcl:"docker,block" validate:"omitempty"`
+	Wait        *WaitStep         `yaml:"wait,omitempty" json:"wait,omitempty" toml:"wait,omitempty" hcl:"wait,block" validate:"omitempty"`
+	RunScript   *RunScriptStep    `yaml:"run_script,omitempty" json:"run_script,omitempty" toml:"run_script,omitempty" hcl:"run_script,block" validate:"omitempty"`
+	Download    *DownloadStep     `yaml:"download,omitempty" json:"download,omitempty" toml:"download,omitempty" hcl:"download,block" validate:"omitempty"`
+	Exec        *ExecStep         `yaml:"exec,omitempty" json:"exec,omitempty" toml:"exec,omitempty" hcl:"exec,block" validate:"omitempty"`
+	DockerPrune *DockerPruneStep  `yaml:"docker_prune,omitempty" json:"docker_prune,omitempty" toml:"docker_prune,omitempty" hcl:"docker_prune,block" validate:"omitempty"`
+	DockerExec  *DockerExecStep   `yaml:"docker_exec,omitempty" json:"docker_exec,omitempty" toml:"docker_exec,omitempty" hcl:"docker_exec,block" validate:"omitempty"`
+	Service     *ServiceStep      `yaml:"service,omitempty" json:"service,omitempty" toml:"service,omitempty" hcl:"service,block" validate:"omitempty"`
+	Assert      *AssertStep       `yaml:"assert,omitempty" json:"assert,omitempty" toml:"assert,omitempty" hcl:"assert,block" validate:"omitempty"`
+	Sudo        bool              `yaml:"sudo,omitempty" json:"sudo,omitempty" toml:"sudo,omitempty" hcl:"sudo,optional" validate:"omitempty"`
+	SudoUser    string            `yaml:"sudo_user,omitempty" json:"sudo_user,omitempty" toml:"sudo_user,omitempty" hcl:"sudo_user,optional" validate:"omitempty"`
+	RunOnce     bool              `yaml:"run_once,omitempty" json:"run_once,omitempty" toml:"run_once,omitempty" hcl:"run_once,optional" validate:"omitempty"`
+	Parallel    bool              `yaml:"parallel,omitempty" json:"parallel,omitempty" toml:"parallel,omitempty" hcl:"parallel,optional" validate:"omitempty"`
+	FailFast    *bool             `yaml:"fail_fast,omitempty" json:"fail_fast,omitempty" toml:"fail_fast,omitempty" hcl:"fail_fast,optional" validate:"omitempty"`
+	When        string            `yaml:"when,omitempty" json:"when,omitempty" toml:"when,omitempty" hcl:"when,optional" validate:"omitempty,condition"`
+}
+
+// RunScriptStep uploads a local script to the target, runs it with optional arguments
+// and removes it afterwards. Without an Interpreter the script is executed directly.
+//
+//nolint:lll // long struct tags needed for complete configuration
+type RunScriptStep struct {
+	Path        string   `yaml:"path" json:"path" toml:"path" hcl:"path,optional" validate:"required,file"`
+	Args        []string `yaml:"args,omitempty" json:"args,omitempty" toml:"args,omitempty" hcl:"args,optional" validate:"omitempty"`
+	Interpreter string   `yaml:"interpreter,omitempty" json:"interpreter,omitempty" toml:"interpreter,omitempty" hcl:"interpreter,optional" validate:"omitempty"`
+}
+
+// DownloadStep copies a file or directory from the target to the local machine.
+// The remote content can't be hashed beforehand, so downloads run every time
+// unless SkipUnchanged allows skipping them while their configuration is unchanged.
+//
+//nolint:lll // long struct tags needed for complete configuration
+type DownloadStep struct {
+	Remote        string `yaml:"remote" json:"remote" toml:"remote" hcl:"remote,optional" validate:"required"`
+	Local         string `yaml:"local" json:"local" toml:"local" hcl:"local,optional" validate:"required"`
+	SkipUnchanged bool   `yaml:"skip_unchanged,omitempty" json:"skip_unchanged,omitempty" toml:"skip_unchanged,omitempty" hcl:"skip_unchanged,optional" validate:"omitempty"`
 }
 
-// DockerBuildStep defines Docker build configuration parameters.
+// ExecStep runs a local command, passing the target, job and step it runs for in NSHIP_*
+// environment variables next to Env. The step fails when the command exits with a non-zero status.
+//
+//nolint:lll // long struct tags needed for complete configuration
+type ExecStep struct {
+	Command []string          `yaml:"command" json:"command" toml:"command" hcl:"command,optional" validate:"required,min=1,dive,required"`
+	Env     map[string]string `yaml:"env,omitempty" json:"env,omitempty" toml:"env,omitempty" hcl:"env,optional" validate:"omitempty"`
+	// Job is the name of the job running the step, set by the Service before execution
+	Job string `yaml:"-" json:"-" toml:"-"`
+}
+
+// WaitStep pauses the job locally for the given duration, e.g. "5s" or "1m30s".
+type WaitStep struct {
+	Duration string `yaml:"duration" json:"duration" toml:"duration" hcl:"duration,optional" validate:"required,duration"`
+}
+
+// GetDuration returns the parsed wait duration.
+func (w *WaitStep) GetDuration() (time.Duration, error) {
+	return time.ParseDuration(w.Duration)
+}
+
+// DockerBuildStep defines Docker build configuration parameters. Target selects the stage of a
+// multi-stage Dockerfile to build.
+//
+//nolint:lll // long struct tags needed for complete configuration
 type DockerBuildStep struct {
-	Context string            `yaml:"context" json:"context" toml:"context" validate:"required"`
-	Args    map[string]string `yaml:"args,omitempty" json:"args,omitempty" toml:"args,omitempty" validate:"omitempty"`
+	Context    string            `yaml:"context" json:"context" toml:"context" hcl:"context,optional" validate:"required"`
+	Args       map[string]string `yaml:"args,omitempty" json:"args,omitempty" toml:"args,omitempty" hcl:"args,optional" validate:"omitempty"`
+	Dockerfile string            `yaml:"dockerfile,omitempty" json:"dockerfile,omitempty" toml:"dockerfile,omitempty" hcl:"dockerfile,optional" validate:"omitempty"`
+	Target     string            `yaml:"target,omitempty" json:"target,omitempty" toml:"target,omitempty" hcl:"target,optional" validate:"omitempty"`
+	NoCache    bool              `yaml:"no_cache,omitempty" json:"no_cache,omitempty" toml:"no_cache,omitempty" hcl:"no_cache,optional" validate:"omitempty"`
+	Platform   string            `yaml:"platform,omitempty" json:"platform,omitempty" toml:"platform,omitempty" hcl:"platform,optional" validate:"omitempty"`
 }
 
 // DockerStep defines Docker container configuration and execution parameters.
+// Memory, CPUs and PidsLimit limit the resources of the container, e.g. "512m", "1.5" and 100.
+// EnvFile is a file on the target read with --env-file and EnvFromHost names variables passed on
+// from the environment of the target. Environment overrides EnvFromHost, which overrides EnvFile.
+// Entrypoint replaces the entrypoint of the image; its first element is the executable and the others
+// are passed to it before Command. Recreate is DockerRecreateAlways or DockerRecreateOnChange.
+// ExpectedDigest, e.g. "sha256:…", makes the step pull Image and fail unless it has that digest.
+//
+//nolint:lll // long struct tags needed for complete configuration
 type DockerStep struct {
-	Image       string            `yaml:"image" json:"image" toml:"image" validate:"required"`
-	Name        string            `yaml:"name" json:"name" toml:"name" validate:"required"`
-	Build       *DockerBuildStep  `yaml:"build,omitempty" json:"build,omitempty" toml:"build,omitempty" validate:"omitempty"`
-	Environment map[string]string `yaml:"environment" json:"environment" toml:"environment" validate:"omitempty"`
-	Ports       []string          `yaml:"ports" json:"ports" toml:"ports" validate:"omitempty,dive,required"`
-	Volumes     []string          `yaml:"volumes" json:"volumes" toml:"volumes" validate:"omitempty,dive,required"`
-	Labels      map[string]string `yaml:"labels" json:"labels" toml:"labels" validate:"omitempty"`
-	Networks    []string          `yaml:"networks" json:"networks" toml:"networks" validate:"omitempty,dive,required"`
-	Command     []string          `yaml:"command" json:"command" toml:"command" validate:"omitempty,dive,required"`
-	Restart     string            `yaml:"restart" json:"restart" toml:"restart" validate:"omitempty,oneof=no on-failure always unless-stopped"`
+	Image          string            `yaml:"image" json:"image" toml:"image" hcl:"image,optional" validate:"required"`
+	Name           string            `yaml:"name" json:"name" toml:"name" hcl:"name,optional" validate:"required"`
+	Build          *DockerBuildStep  `yaml:"build,omitempty" json:"build,omitempty" toml:"build,omitempty" hcl:"build,block" validate:"omitempty"`
+	ExpectedDigest string            `yaml:"expected_digest,omitempty" json:"expected_digest,omitempty" toml:"expected_digest,omitempty" hcl:"expected_digest,optional" validate:"omitempty,excluded_with=Build,docker_digest"`
+	Environment    map[string]string `yaml:"environment" json:"environment" toml:"environment" hcl:"environment,optional" validate:"omitempty"`
+	EnvFile        string            `yaml:"env_file,omitempty" json:"env_file,omitempty" toml:"env_file,omitempty" hcl:"env_file,optional" validate:"omitempty"`
+	EnvFromHost    []string          `yaml:"env_from_host,omitempty" json:"env_from_host,omitempty" toml:"env_from_host,omitempty" hcl:"env_from_host,optional" validate:"omitempty,dive,required"`
+	Ports          []string          `yaml:"ports" json:"ports" toml:"ports" hcl:"ports,optional" validate:"omitempty,dive,required,docker_port"`
+	Volumes        []string          `yaml:"volumes" json:"volumes" toml:"volumes" hcl:"volumes,optional" validate:"omitempty,dive,required,docker_volume"`
+	Labels         map[string]string `yaml:"labels" json:"labels" toml:"labels" hcl:"labels,optional" validate:"omitempty"`
+	Networks       []string          `yaml:"networks" json:"networks" toml:"networks" hcl:"networks,optional" validate:"omitempty,dive,required"`
+	Command        []string          `yaml:"command" json:"command" toml:"command" hcl:"command,optional" validate:"omitempty,dive,required"`
+	User           string            `yaml:"user,omitempty" json:"user,omitempty" toml:"user,omitempty" hcl:"user,optional" validate:"omitempty"`
+	Workdir        string            `yaml:"workdir,omitempty" json:"workdir,omitempty" toml:"workdir,omitempty" hcl:"workdir,optional" validate:"omitempty"`
+	Entrypoint     []string          `yaml:"entrypoint,omitempty" json:"entrypoint,omitempty" toml:"entrypoint,omitempty" hcl:"entrypoint,optional" validate:"omitempty,dive,required"`
+	Recreate       string            `yaml:"recreate,omitempty" json:"recreate,omitempty" toml:"recreate,omitempty" hcl:"recreate,optional" validate:"omitempty,oneof=always on-change"`
+	Restart        string            `yaml:"restart" json:"restart" toml:"restart" hcl:"restart,optional" validate:"omitempty,oneof=no on-failure always unless-stopped"`
+	Memory         string            `yaml:"memory,omitempty" json:"memory,omitempty" toml:"memory,omitempty" hcl:"memory,optional" validate:"omitempty,docker_memory"`
+	CPUs           string            `yaml:"cpus,omitempty" json:"cpus,omitempty" toml:"cpus,omitempty" hcl:"cpus,optional" validate:"omitempty,numeric"`
+	PidsLimit      int               `yaml:"pids_limit,omitempty" json:"pids_limit,omitempty" toml:"pids_limit,omitempty" hcl:"pids_limit,optional" validate:"omitempty,min=-1"`
+}
+
+const (
+	// DockerRecreateAlways replaces the container every time the docker step runs. It is the default.
+	DockerRecreateAlways = "always"
+	// DockerRecreateOnChange keeps a running container created from the current image with the same configuration
+	DockerRecreateOnChange = "on-change"
+)
+
+// DockerPruneStep removes dangling Docker images from the target, or all images not used by a container
+// when All is set.
+// Containers removes stopped containers before the images, and Until only prunes what was created
+// longer than that duration ago, e.g. "24h".
+//
+//nolint:lll // long struct tags needed for complete configuration
+type DockerPruneStep struct {
+	All        bool   `yaml:"all,omitempty" json:"all,omitempty" toml:"all,omitempty" hcl:"all,optional" validate:"omitempty"`
+	Containers bool   `yaml:"containers,omitempty" json:"containers,omitempty" toml:"containers,omitempty" hcl:"containers,optional" validate:"omitempty"`
+	Until      string `yaml:"until,omitempty" json:"until,omitempty" toml:"until,omitempty" hcl:"until,optional" validate:"omitempty,duration"`
+}
+
+// DockerExecStep runs Command in the running container Container on the target, as User and in Workdir
+// of the container when set.
+//
+//nolint:lll // long struct tags needed for complete configuration
+type DockerExecStep struct {
+	Container string   `yaml:"container" json:"container" toml:"container" hcl:"container,optional" validate:"required"`
+	Command   []string `yaml:"command" json:"command" toml:"command" hcl:"command,optional" validate:"required,min=1"`
+	User      string   `yaml:"user,omitempty" json:"user,omitempty" toml:"user,omitempty" hcl:"user,optional" validate:"omitempty"`
+	Workdir   string   `yaml:"workdir,omitempty" json:"workdir,omitempty" toml:"workdir,omitempty" hcl:"workdir,optional" validate:"omitempty"`
+}
+
+// AssertStep runs Command on the target and fails unless it exits with ExitCode, 0 by default, and its output
+// contains Contains, if set.
+//
+//nolint:lll // long struct tags needed for complete configuration
+type AssertStep struct {
+	Command  string `yaml:"command" json:"command" toml:"command" hcl:"command,optional" validate:"required"`
+	ExitCode int    `yaml:"exit_code,omitempty" json:"exit_code,omitempty" toml:"exit_code,omitempty" hcl:"exit_code,optional" validate:"min=0,max=255"`
+	Contains string `yaml:"contains,omitempty" json:"contains,omitempty" toml:"contains,omitempty" hcl:"contains,optional" validate:"omitempty"`
+}
+
+// ServiceStep performs Action on the system service Name with its service Manager, systemd or openrc,
+// defaulting to systemd. Verify makes the step fail unless the service is active after a start, restart or reload.
+//
+//nolint:lll // long struct tags needed for complete configuration
+type ServiceStep struct {
+	Name    string `yaml:"name" json:"name" toml:"name" hcl:"name,optional" validate:"required"`
+	Action  string `yaml:"action" json:"action" toml:"action" hcl:"action,optional" validate:"required,oneof=start stop restart reload enable disable"`
+	Manager string `yaml:"manager,omitempty" json:"manager,omitempty" toml:"manager,omitempty" hcl:"manager,optional" validate:"omitempty,oneof=systemd openrc"`
+	Verify  bool   `yaml:"verify,omitempty" json:"verify,omitempty" toml:"verify,omitempty" hcl:"verify,optional" validate:"omitempty"`
+}
+
+// Supported service actions
+const (
+	ServiceStart   = "start"
+	ServiceStop    = "stop"
+	ServiceRestart = "restart"
+	ServiceReload  = "reload"
+	ServiceEnable  = "enable"
+	ServiceDisable = "disable"
+)
+
+// Supported service managers
+const (
+	ServiceManagerSystemd = "systemd"
+	ServiceManagerOpenRC  = "openrc"
+)
+
+// GetManager returns the service manager, defaulting to systemd if not specified
+func (s *ServiceStep) GetManager() string {
+	if s.Manager == "" {
+		return ServiceManagerSystemd
+	}
+	return s.Manager
+}
+
+// Verifiable reports whether the action leaves the service running, so that Verify can check it is active
+func (s *ServiceStep) Verifiable() bool {
+	return s.Action == ServiceStart || s.Action == ServiceRestart || s.Action == ServiceReload
 }
 
 // CopyStep defines source and destination paths for file copy operations.
+// Concurrency sets how many files of a directory are uploaded in parallel.
+// PreserveTimes controls whether remote files get the local modification time and defaults to true.
+// Delete removes remote files that no longer exist locally; AllowDeleteAll permits this even
+// when the local directory is empty, which would otherwise be refused. With a Container, the files are
+// uploaded to a temporary path on the target and copied from there to Remote inside that container.
+//
+//nolint:lll // long struct tags needed for complete configuration
 type CopyStep struct {
-	Local   string   `yaml:"local" json:"local" toml:"local" validate:"required"`
-	Remote  string   `yaml:"remote" json:"remote" toml:"remote" validate:"required"`
-	Exclude []string `yaml:"exclude,omitempty" json:"exclude,omitempty" toml:"exclude,omitempty" validate:"omitempty,dive,required"`
+	Local          string   `yaml:"local" json:"local" toml:"local" hcl:"local,optional" validate:"required"`
+	Remote         string   `yaml:"remote" json:"remote" toml:"remote" hcl:"remote,optional" validate:"required"`
+	Exclude        []string `yaml:"exclude,omitempty" json:"exclude,omitempty" toml:"exclude,omitempty" hcl:"exclude,optional" validate:"omitempty,dive,required"`
+	Concurrency    int      `yaml:"concurrency,omitempty" json:"concurrency,omitempty" toml:"concurrency,omitempty" hcl:"concurrency,optional" validate:"omitempty,min=1"`
+	PreserveTimes  *bool    `yaml:"preserve_times,omitempty" json:"preserve_times,omitempty" toml:"preserve_times,omitempty" hcl:"preserve_times,optional" validate:"omitempty"`
+	Delete         bool     `yaml:"delete,omitempty" json:"delete,omitempty" toml:"delete,omitempty" hcl:"delete,optional" validate:"omitempty"`
+	AllowDeleteAll bool     `yaml:"allow_delete_all,omitempty" json:"allow_delete_all,omitempty" toml:"allow_delete_all,omitempty" hcl:"allow_delete_all,optional" validate:"omitempty"`
+	Compress       bool     `yaml:"compress,omitempty" json:"compress,omitempty" toml:"compress,omitempty" hcl:"compress,optional" validate:"omitempty"`
+	FollowSymlinks bool     `yaml:"follow_symlinks,omitempty" json:"follow_symlinks,omitempty" toml:"follow_symlinks,omitempty" hcl:"follow_symlinks,optional" validate:"omitempty"`
+	Container      string   `yaml:"container,omitempty" json:"container,omitempty" toml:"container,omitempty" hcl:"container,optional" validate:"omitempty"`
+}
+
+// ShouldPreserveTimes reports whether copied files keep their local modification time.
+func (c *CopyStep) ShouldPreserveTimes() bool {
+	return c.PreserveTimes == nil || *c.PreserveTimes
 }
 
 // GetShell returns the shell to use for command execution, defaulting to sh if not specified.
@@ -50,6 +294,43 @@ func (s *Step) GetShell() string {
 	return s.Shell
 }
 
+// DefaultWindowsShell is the shell running the steps on Windows targets that set none
+const DefaultWindowsShell = "powershell"
+
+// GetShellFor returns the shell to use for the step on tgt: the step's own shell,
+// then the target's default shell, then sh, or powershell on Windows targets.
+func (s *Step) GetShellFor(tgt *target.Target) string {
+	switch {
+	case s.Shell != "" || tgt == nil:
+		return s.GetShell()
+	case tgt.Shell != "":
+		return tgt.Shell
+	case tgt.IsWindows():
+		return DefaultWindowsShell
+	}
+	return s.GetShell()
+}
+
+// FailsFast reports whether the run command of the step stops at its first failing line. Unless FailFast
+// is set, this is the case for commands of more than one line.
+func (s *Step) FailsFast() bool {
+	if s.FailFast != nil {
+		return *s.FailFast
+	}
+	return strings.Contains(strings.TrimSpace(s.Run), "\n")
+}
+
+// UsesSudo reports whether the step's commands run through sudo. Setting SudoUser implies Sudo.
+func (s *Step) UsesSudo() bool {
+	return s.Sudo || s.SudoUser != ""
+}
+
+// AlwaysRuns reports whether the step runs even when its configuration is unchanged.
+// This is the case for downloads, as the remote content they fetch can't be hashed beforehand.
+func (s *Step) AlwaysRuns() bool {
+	return s.Download != nil && !s.Download.SkipUnchanged
+}
+
 // StepType represents the type of deployment step.
 type StepType int
 
@@ -60,19 +341,49 @@ const (
 	CopyStepType
 	// DockerStepType represents a Docker container operation step.
 	DockerStepType
+	// WaitStepType represents a pause between steps.
+	WaitStepType
+	// RunScriptStepType represents an uploaded and executed script step.
+	RunScriptStepType
+	// DownloadStepType represents a download from the target.
+	DownloadStepType
+	// ExecStepType represents a local command execution step.
+	ExecStepType
+	// DockerPruneStepType represents a removal of unused Docker images.
+	DockerPruneStepType
+	// DockerExecStepType represents a command run in a running Docker container.
+	DockerExecStepType
+	// ServiceStepType represents an action on a system service.
+	ServiceStepType
+	// AssertStepType represents a command whose exit code and output are checked.
+	AssertStepType
 )
 
+// stepTypes lists the step types with a check whether a step is of that type, in the order GetType tries them
+var stepTypes = []struct {
+	stepType StepType
+	matches  func(s *Step) bool
+}{
+	{RunStep, func(s *Step) bool { return s.Run != "" || s.ScriptFile != "" }},
+	{CopyStepType, func(s *Step) bool { return s.Copy != nil }},
+	{DockerStepType, func(s *Step) bool { return s.Docker != nil }},
+	{WaitStepType, func(s *Step) bool { return s.Wait != nil }},
+	{RunScriptStepType, func(s *Step) bool { return s.RunScript != nil }},
+	{DownloadStepType, func(s *Step) bool { return s.Download != nil }},
+	{ExecStepType, func(s *Step) bool { return s.Exec != nil }},
+	{DockerPruneStepType, func(s *Step) bool { return s.DockerPrune != nil }},
+	{DockerExecStepType, func(s *Step) bool { return s.DockerExec != nil }},
+	{ServiceStepType, func(s *Step) bool { return s.Service != nil }},
+	{AssertStepType, func(s *Step) bool { return s.Assert != nil }},
+}
+
 // GetType returns the type of step.
 func (s *Step) GetType() StepType {
-	switch {
-	case s.Run != "":
-		return RunStep
-	case s.Copy != nil:
-		return CopyStepType
-	case s.Docker != nil:
-		return DockerStepType
-	default:
-		// This shouldn't happen if validation is working properly
-		panic("invalid step: no type detected")
+	for _, t := range stepTypes {
+		if t.matches(s) {
+			return t.stepType
+		}
 	}
+	// This shouldn't happen if validation is working properly
+	panic("invalid step: no type detected")
 }
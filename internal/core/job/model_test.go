@@ -1,6 +1,7 @@
 package job
 
 import (
+	"github.com/nickalie/nship/internal/core/target"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -35,6 +36,19 @@ func TestGetShell(t *testing.T) {
 	}
 }
 
+func TestGetShellFor(t *testing.T) {
+	bash := &target.Target{Shell: "bash"}
+
+	assert.Equal(t, "zsh", (&Step{Run: "id", Shell: "zsh"}).GetShellFor(bash), "step shell should take precedence")
+	assert.Equal(t, "bash", (&Step{Run: "id"}).GetShellFor(bash), "target shell should be the fallback")
+	assert.Equal(t, "sh", (&Step{Run: "id"}).GetShellFor(&target.Target{}), "sh should be the default")
+	assert.Equal(t, "sh", (&Step{Run: "id"}).GetShellFor(nil))
+
+	windows := &target.Target{Platform: target.PlatformWindows}
+	assert.Equal(t, "powershell", (&Step{Run: "id"}).GetShellFor(windows), "powershell should be the default on Windows")
+	assert.Equal(t, "pwsh", (&Step{Run: "id", Shell: "pwsh"}).GetShellFor(windows))
+}
+
 func TestGetType(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -68,6 +82,71 @@ func TestGetType(t *testing.T) {
 			},
 			expectedType: DockerStepType,
 		},
+		{
+			name: "script file step",
+			step: Step{
+				ScriptFile: "deploy.sh",
+			},
+			expectedType: RunStep,
+		},
+		{
+			name: "run script step",
+			step: Step{
+				RunScript: &RunScriptStep{
+					Path: "migrate.sh",
+				},
+			},
+			expectedType: RunScriptStepType,
+		},
+		{
+			name: "wait step",
+			step: Step{
+				Wait: &WaitStep{
+					Duration: "5s",
+				},
+			},
+			expectedType: WaitStepType,
+		},
+		{
+			name: "download step",
+			step: Step{
+				Download: &DownloadStep{
+					Remote: "/var/log/app.log",
+					Local:  "app.log",
+				},
+			},
+			expectedType: DownloadStepType,
+		},
+		{
+			name: "exec step",
+			step: Step{
+				Exec: &ExecStep{
+					Command: []string{"./switch.sh", "blue"},
+				},
+			},
+			expectedType: ExecStepType,
+		},
+		{
+			name: "docker prune step",
+			step: Step{
+				DockerPrune: &DockerPruneStep{Until: "24h"},
+			},
+			expectedType: DockerPruneStepType,
+		},
+		{
+			name: "service step",
+			step: Step{
+				Service: &ServiceStep{Name: "nginx", Action: ServiceRestart},
+			},
+			expectedType: ServiceStepType,
+		},
+		{
+			name: "assert step",
+			step: Step{
+				Assert: &AssertStep{Command: "curl -s localhost/health", Contains: "ok"},
+			},
+			expectedType: AssertStepType,
+		},
 	}
 
 	for _, tt := range tests {
@@ -77,6 +156,12 @@ func TestGetType(t *testing.T) {
 	}
 }
 
+func TestAlwaysRuns(t *testing.T) {
+	assert.False(t, (&Step{Run: "echo hello"}).AlwaysRuns())
+	assert.True(t, (&Step{Download: &DownloadStep{Remote: "/a", Local: "a"}}).AlwaysRuns())
+	assert.False(t, (&Step{Download: &DownloadStep{Remote: "/a", Local: "a", SkipUnchanged: true}}).AlwaysRuns())
+}
+
 func TestPanicOnInvalidStepType(t *testing.T) {
 	step := Step{}
 
@@ -84,3 +169,39 @@ func TestPanicOnInvalidStepType(t *testing.T) {
 		step.GetType()
 	}, "GetType() should panic on invalid step type")
 }
+
+func TestShouldPreserveTimes(t *testing.T) {
+	enabled := true
+	disabled := false
+
+	assert.True(t, (&CopyStep{}).ShouldPreserveTimes(), "times should be preserved by default")
+	assert.True(t, (&CopyStep{PreserveTimes: &enabled}).ShouldPreserveTimes())
+	assert.False(t, (&CopyStep{PreserveTimes: &disabled}).ShouldPreserveTimes())
+}
+
+func TestFailsFast(t *testing.T) {
+	assert.False(t, (&Step{Run: "make"}).FailsFast())
+	assert.False(t, (&Step{Run: "make\n"}).FailsFast(), "a trailing newline should not make a command multi-line")
+	assert.True(t, (&Step{Run: "make\nmake install"}).FailsFast())
+
+	enabled, disabled := true, false
+	assert.True(t, (&Step{Run: "make", FailFast: &enabled}).FailsFast())
+	assert.False(t, (&Step{Run: "make\nmake install", FailFast: &disabled}).FailsFast())
+}
+
+func TestUsesSudo(t *testing.T) {
+	assert.False(t, (&Step{Run: "id"}).UsesSudo())
+	assert.True(t, (&Step{Run: "id", Sudo: true}).UsesSudo())
+	assert.True(t, (&Step{Run: "id", SudoUser: "deploy"}).UsesSudo(), "a sudo user should imply sudo")
+}
+
+func TestServiceStep(t *testing.T) {
+	service := &ServiceStep{Name: "nginx", Action: ServiceRestart}
+	assert.Equal(t, ServiceManagerSystemd, service.GetManager(), "systemd should be the default service manager")
+	assert.True(t, service.Verifiable())
+
+	service.Manager = ServiceManagerOpenRC
+	service.Action = ServiceEnable
+	assert.Equal(t, ServiceManagerOpenRC, service.GetManager())
+	assert.False(t, service.Verifiable(), "enabling a service doesn't start it")
+}
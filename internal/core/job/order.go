@@ -0,0 +1,63 @@
+package job
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// Orders in which a deployment visits its targets, besides an explicit list of target names
+const (
+	// TargetOrderFile keeps the order of the targets in the config, the default
+	TargetOrderFile = "file"
+	// TargetOrderReverse visits the targets in the reverse order of the config
+	TargetOrderReverse = "reverse"
+	// TargetOrderSorted visits the targets sorted by name
+	TargetOrderSorted = "sorted"
+)
+
+// OrderTargets returns targets in the given order: file, reverse, sorted, or a comma-separated
+// list of target names such as "canary,web1,web2", which must name every target exactly once.
+// An empty order keeps the order of the config.
+func OrderTargets(targets []*target.Target, order string) ([]*target.Target, error) {
+	switch strings.TrimSpace(order) {
+	case "", TargetOrderFile:
+		return targets, nil
+	case TargetOrderReverse:
+		ordered := slices.Clone(targets)
+		slices.Reverse(ordered)
+		return ordered, nil
+	case TargetOrderSorted:
+		return slices.SortedStableFunc(slices.Values(targets), func(a, b *target.Target) int {
+			return strings.Compare(a.GetName(), b.GetName())
+		}), nil
+	}
+	return listTargetOrder(targets, strings.Split(order, ","))
+}
+
+// listTargetOrder returns targets in the order of names, failing when names omit a target or name an unknown one
+func listTargetOrder(targets []*target.Target, names []string) ([]*target.Target, error) {
+	remaining := make(map[string]*target.Target, len(targets))
+	for _, tgt := range targets {
+		remaining[tgt.GetName()] = tgt
+	}
+
+	ordered := make([]*target.Target, 0, len(targets))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		tgt, ok := remaining[name]
+		if !ok {
+			return nil, fmt.Errorf("target order: unknown or repeated target '%s'", name)
+		}
+		ordered = append(ordered, tgt)
+		delete(remaining, name)
+	}
+
+	if len(remaining) > 0 {
+		return nil, fmt.Errorf("target order: missing targets %s", strings.Join(slices.Sorted(maps.Keys(remaining)), ", "))
+	}
+	return ordered, nil
+}
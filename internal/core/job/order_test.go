@@ -0,0 +1,77 @@
+package job
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+func targetNames(targets []*target.Target) []string {
+	names := make([]string, len(targets))
+	for i, tgt := range targets {
+		names[i] = tgt.GetName()
+	}
+	return names
+}
+
+func TestOrderTargets(t *testing.T) {
+	targets := []*target.Target{{Name: "web2"}, {Name: "canary"}, {Host: "db.example.com"}, {Name: "web1"}}
+
+	tests := []struct {
+		name      string
+		order     string
+		expected  []string
+		expectErr string
+	}{
+		{name: "default", expected: []string{"web2", "canary", "db.example.com", "web1"}},
+		{name: "file", order: "file", expected: []string{"web2", "canary", "db.example.com", "web1"}},
+		{name: "reverse", order: "reverse", expected: []string{"web1", "db.example.com", "canary", "web2"}},
+		{name: "sorted", order: "sorted", expected: []string{"canary", "db.example.com", "web1", "web2"}},
+		{name: "list", order: "canary, web1,web2,db.example.com", expected: []string{"canary", "web1", "web2", "db.example.com"}},
+		{name: "list omitting targets", order: "canary,web1", expectErr: "target order: missing targets db.example.com, web2"},
+		{name: "list with unknown target", order: "canary,web3,web1,web2,db.example.com",
+			expectErr: "target order: unknown or repeated target 'web3'"},
+		{name: "list with repeated target", order: "canary,canary,web1,web2,db.example.com",
+			expectErr: "target order: unknown or repeated target 'canary'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ordered, err := OrderTargets(targets, tt.order)
+			if tt.expectErr != "" {
+				assert.EqualError(t, err, tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, targetNames(ordered))
+		})
+	}
+	assert.Equal(t, "web2", targets[0].Name, "the targets should not be reordered in place")
+}
+
+func TestExecuteJobsTargetOrder(t *testing.T) {
+	targets := []*target.Target{{Name: "web1"}, {Name: "web2"}, {Name: "canary"}}
+	jobs := []*Job{{Name: "deploy", Steps: []*Step{{Run: "deploy"}}}}
+
+	var visited []string
+	factory := &MockClientFactory{}
+	factory.On("NewClient", mock.Anything).Run(func(args mock.Arguments) {
+		visited = append(visited, args.Get(0).(*target.Target).Name)
+	}).Return(&recordingClient{}, nil)
+
+	require.NoError(t, NewService(factory, WithTargetOrder("canary,web1,web2")).ExecuteJobs(targets, jobs))
+	assert.Equal(t, []string{"canary", "web1", "web2"}, visited)
+
+	visited = nil
+	require.NoError(t, NewService(factory, WithTargetOrder(TargetOrderReverse)).ExecuteJobs(targets, jobs))
+	assert.Equal(t, []string{"canary", "web2", "web1"}, visited)
+
+	visited = nil
+	err := NewService(factory, WithTargetOrder("canary")).ExecuteJobs(targets, jobs)
+	assert.EqualError(t, err, "target order: missing targets web1, web2")
+	assert.Empty(t, visited, "no target should run when the order is invalid")
+}
@@ -0,0 +1,129 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/nickalie/nship/internal/core/target"
+	"github.com/nickalie/nship/internal/util"
+)
+
+// Output is where the progress messages and the command output of a target are written.
+// The zero Output writes to os.Stdout and os.Stderr.
+type Output struct {
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// NewOutput creates an Output writing progress messages and standard output to stdout
+// and standard error to stderr
+func NewOutput(stdout, stderr io.Writer) Output {
+	return Output{stdout: stdout, stderr: stderr}
+}
+
+// Stdout returns the writer of progress messages and standard output
+func (o Output) Stdout() io.Writer {
+	if o.stdout == nil {
+		return os.Stdout
+	}
+	return o.stdout
+}
+
+// Stderr returns the writer of standard error
+func (o Output) Stderr() io.Writer {
+	if o.stderr == nil {
+		return os.Stderr
+	}
+	return o.stderr
+}
+
+// isDefault reports whether the Output writes to os.Stdout and os.Stderr because it was never set
+func (o Output) isDefault() bool {
+	return o.stdout == nil && o.stderr == nil
+}
+
+// outputTailLines is how many lines of output of a step are kept for its result
+const outputTailLines = 20
+
+// OutputTail keeps the last lines written to it. It is safe for concurrent use.
+type OutputTail struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// Write implements io.Writer, keeping the last outputTailLines lines of p
+func (t *OutputTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lines = append(t.lines, strings.Split(strings.TrimSuffix(string(p), "\n"), "\n")...)
+	if len(t.lines) > outputTailLines {
+		t.lines = t.lines[len(t.lines)-outputTailLines:]
+	}
+	return len(p), nil
+}
+
+// String returns the kept lines
+func (t *OutputTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.Join(t.lines, "\n")
+}
+
+// Reset removes the kept lines
+func (t *OutputTail) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lines = nil
+}
+
+// outputTailKey is the context key of the OutputTail of the step being executed
+type outputTailKey struct{}
+
+// WithOutputTail returns a copy of ctx carrying tail, which clients that support it write the output
+// of the step executed with ctx to. Each step gets a tail of its own, so parallel steps don't mix their output.
+func WithOutputTail(ctx context.Context, tail *OutputTail) context.Context {
+	return context.WithValue(ctx, outputTailKey{}, tail)
+}
+
+// OutputTailFrom returns the tail carried by ctx, or nil if it carries none
+func OutputTailFrom(ctx context.Context) *OutputTail {
+	tail, _ := ctx.Value(outputTailKey{}).(*OutputTail)
+	return tail
+}
+
+// LogFileName returns the name of the log file of a target in the log directory
+func LogFileName(targetName string) string {
+	return util.SafeFileName(targetName) + ".log"
+}
+
+// forTarget returns a copy of the service writing to the output of tgt and a function closing the output.
+// Unless the service is quiet, the output is written to the console. With a log directory, it is also
+// written to the log file of tgt, which is truncated first.
+func (s *Service) forTarget(tgt *target.Target) (*Service, func() error, error) {
+	console := Output{}
+	if s.quiet {
+		console = NewOutput(io.Discard, io.Discard)
+	}
+
+	run := *s
+	run.output = console
+	if s.logDir == "" {
+		return &run, func() error { return nil }, nil
+	}
+
+	if err := os.MkdirAll(s.logDir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.Create(filepath.Join(s.logDir, LogFileName(tgt.GetName())))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create log file of target %s: %w", tgt.GetName(), err)
+	}
+	run.output = NewOutput(io.MultiWriter(console.Stdout(), file), io.MultiWriter(console.Stderr(), file))
+	return &run, file.Close, nil
+}
@@ -0,0 +1,107 @@
+package job
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// outputClient is a recordingClient that writes its progress and command output to the output set by the service
+type outputClient struct {
+	recordingClient
+	out Output
+}
+
+func (c *outputClient) SetOutput(out Output) { c.out = out }
+
+func (c *outputClient) ExecuteStep(step *Step, stepNum, totalSteps int) error {
+	fmt.Fprintf(c.out.Stdout(), "[%d/%d] %s\n", stepNum, totalSteps, step.Run)
+	fmt.Fprintf(c.out.Stderr(), "stderr of %s\n", step.Run)
+	return c.recordingClient.ExecuteStep(step, stepNum, totalSteps)
+}
+
+func outputClients(failingStep string) ClientFactory {
+	return clientFactoryFunc(func(*target.Target) (Client, error) {
+		return &outputClient{recordingClient: recordingClient{failingStep: failingStep}}, nil
+	})
+}
+
+func TestLogFileName(t *testing.T) {
+	assert.Equal(t, "web-1.example.com.log", LogFileName("web-1.example.com"))
+	assert.Equal(t, "eu_web_1_.log", LogFileName("eu/web 1*"))
+	assert.Equal(t, ".._x.log", LogFileName("../x"))
+}
+
+func TestExecuteJobsLogDir(t *testing.T) {
+	logDir := filepath.Join(t.TempDir(), "logs")
+	targets := []*target.Target{{Name: "web"}, {Name: "db/primary"}}
+	jobs := []*Job{{Name: "deploy", BeforeJob: []*Step{{Run: "backup"}}, Steps: []*Step{{Run: "migrate"}, {Run: "restart"}}}}
+
+	service := NewService(outputClients("restart"), WithLogDir(logDir), WithKeepGoing(true), WithQuiet(true))
+	err := service.ExecuteJobs(targets, jobs)
+	assert.ErrorContains(t, err, "restart broke")
+
+	for _, name := range []string{"web", "db/primary"} {
+		content, readErr := os.ReadFile(filepath.Join(logDir, LogFileName(name)))
+		require.NoError(t, readErr)
+		assert.Equal(t, "["+name+"] Running before_job hooks for job 'deploy'\n"+
+			"[1/1] backup\nstderr of backup\n[1/2] migrate\nstderr of migrate\n[2/2] restart\nstderr of restart\n", string(content))
+	}
+
+	require.NoError(t, NewService(outputClients(""), WithLogDir(logDir), WithQuiet(true)).ExecuteJob(targets[0], &Job{Name: "check", Steps: []*Step{{Run: "uptime"}}}))
+	content, err := os.ReadFile(filepath.Join(logDir, "web.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "[1/1] uptime\nstderr of uptime\n", string(content), "the log file should hold the last deployment only")
+}
+
+func TestExecuteJobsQuiet(t *testing.T) {
+	console, err := os.CreateTemp(t.TempDir(), "stdout")
+	require.NoError(t, err)
+	defer console.Close()
+
+	stdout := os.Stdout
+	os.Stdout = console
+	defer func() { os.Stdout = stdout }()
+
+	jobs := []*Job{{Name: "deploy", Steps: []*Step{{Run: "migrate"}}}}
+	require.NoError(t, NewService(outputClients(""), WithQuiet(true)).ExecuteJobs([]*target.Target{{Name: "web"}}, jobs))
+	require.NoError(t, NewService(outputClients("")).ExecuteJobs([]*target.Target{{Name: "db"}}, jobs))
+	os.Stdout = stdout
+
+	content, err := os.ReadFile(console.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "[1/1] migrate\n", string(content), "only the deployment that isn't quiet should print")
+}
+
+func TestExecuteJobsLogDirFailure(t *testing.T) {
+	logDir := filepath.Join(t.TempDir(), "logs")
+	require.NoError(t, os.WriteFile(logDir, nil, 0644))
+
+	client := &outputClient{}
+	service := NewService(clientFactoryFunc(func(*target.Target) (Client, error) { return client, nil }), WithLogDir(logDir))
+	err := service.ExecuteJobs([]*target.Target{{Name: "web"}}, []*Job{{Name: "deploy", Steps: []*Step{{Run: "migrate"}}}})
+	assert.ErrorContains(t, err, "failed to create log directory")
+	assert.Empty(t, client.executed, "no step should run when the log file can't be created")
+}
+
+func TestOutputTailKeepsLastLines(t *testing.T) {
+	var tail OutputTail
+	for i := range 30 {
+		fmt.Fprintf(&tail, "line %d\n", i)
+	}
+
+	lines := strings.Split(tail.String(), "\n")
+	assert.Len(t, lines, outputTailLines)
+	assert.Equal(t, "line 10", lines[0])
+	assert.Equal(t, "line 29", lines[len(lines)-1])
+
+	tail.Reset()
+	assert.Empty(t, tail.String())
+}
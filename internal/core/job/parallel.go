@@ -0,0 +1,70 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// parallelGroupEnd returns the index after the group of steps starting at start. Consecutive parallel
+// steps form a group; any other step is a group of its own.
+func parallelGroupEnd(steps []*Step, start int) int {
+	end := start + 1
+	if !steps[start].Parallel {
+		return end
+	}
+	for end < len(steps) && steps[end].Parallel {
+		end++
+	}
+	return end
+}
+
+// executeParallelSteps executes the steps of job from start to end that are not skipped at the same time,
+// each over a session of its own, and waits for all of them. A failing step doesn't stop the others. Once all
+// finished, results and hashes are recorded in step order and the errors of the failed steps are returned.
+func (s *Service) executeParallelSteps(ctx context.Context, client Client, tgt *target.Target, job *Job, start, end int,
+	stepShouldExecute []bool) error {
+	fmt.Fprintf(s.output.Stdout(), "[%s] Running steps %d-%d of job '%s' in parallel\n", tgt.GetName(), start+1, end, job.Name)
+
+	results := make([]StepResult, end-start)
+	var wg sync.WaitGroup
+	for i := start; i < end; i++ {
+		// Skipping is decided before any step starts, as it records run-once steps as handled
+		if s.skipStep(tgt, job, i, job.Steps[i], stepShouldExecute[i]) {
+			results[i-start] = StepResult{Status: StepSkipped}
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i-start] = s.executeTimedStep(ctx, client, tgt, job, i)
+		}()
+	}
+	wg.Wait()
+
+	return s.finishParallelSteps(tgt, job, start, results)
+}
+
+// finishParallelSteps records the results of a group of parallel steps starting at start and stores the hashes
+// of the steps that succeeded, returning the joined errors of the failed steps
+func (s *Service) finishParallelSteps(tgt *target.Target, job *Job, start int, results []StepResult) error {
+	var errs []error
+	for offset, result := range results {
+		stepIndex := start + offset
+		s.recordStep(tgt, job, stepIndex, result)
+
+		switch {
+		case result.Err != nil:
+			errs = append(errs, result.Err)
+		case result.Status == StepSucceeded && s.hashStorage != nil:
+			if err := s.storeStepHash(tgt, job, stepIndex, job.Steps[stepIndex]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
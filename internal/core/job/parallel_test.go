@@ -0,0 +1,213 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// barrierClient holds parallel steps until parallel of them are running at the same time, failing them if
+// that doesn't happen, and records the steps in the order they finish
+type barrierClient struct {
+	parallel int
+	failing  string
+	all      chan struct{}
+
+	mu       sync.Mutex
+	running  int
+	finished []string
+}
+
+func newBarrierClient(parallel int, failing string) *barrierClient {
+	return &barrierClient{parallel: parallel, failing: failing, all: make(chan struct{})}
+}
+
+func (c *barrierClient) ExecuteStep(step *Step, _, _ int) error {
+	if step.Parallel {
+		c.mu.Lock()
+		c.running++
+		if c.running == c.parallel {
+			close(c.all)
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.all:
+		case <-time.After(5 * time.Second):
+			return errors.New(step.Run + " did not run in parallel")
+		}
+	}
+
+	c.mu.Lock()
+	c.finished = append(c.finished, step.Run)
+	c.mu.Unlock()
+	if step.Run == c.failing {
+		return errors.New(step.Run + " broke")
+	}
+	return nil
+}
+
+func (c *barrierClient) Close() {}
+
+// parallelJob returns a job whose steps a, b and c run in parallel between prepare and finish
+func parallelJob() *Job {
+	return &Job{Name: "deploy", Steps: []*Step{
+		{Run: "prepare"},
+		{Run: "a", Parallel: true},
+		{Run: "b", Parallel: true},
+		{Run: "c", Parallel: true},
+		{Run: "finish"},
+	}}
+}
+
+func TestParallelGroupEnd(t *testing.T) {
+	steps := []*Step{{Parallel: true}, {Parallel: true}, {}, {Parallel: true}, {}, {Parallel: true}}
+	assert.Equal(t, 2, parallelGroupEnd(steps, 0))
+	assert.Equal(t, 2, parallelGroupEnd(steps, 1))
+	assert.Equal(t, 3, parallelGroupEnd(steps, 2))
+	assert.Equal(t, 4, parallelGroupEnd(steps, 3), "a single parallel step is a group of its own")
+	assert.Equal(t, 6, parallelGroupEnd(steps, 5))
+}
+
+func TestExecuteJobParallelSteps(t *testing.T) {
+	client := newBarrierClient(3, "")
+	factory := clientFactoryFunc(func(*target.Target) (Client, error) { return client, nil })
+	service := NewService(factory)
+
+	results, err := service.ExecuteJobWithResults(context.Background(), &target.Target{Name: "web"}, parallelJob())
+	require.NoError(t, err)
+
+	require.Len(t, client.finished, 5)
+	assert.Equal(t, "prepare", client.finished[0])
+	assert.ElementsMatch(t, []string{"a", "b", "c"}, client.finished[1:4])
+	assert.Equal(t, "finish", client.finished[4], "the step after a group should wait for all of its steps")
+
+	require.Len(t, results, 5)
+	for i, result := range results {
+		assert.Equal(t, i, result.Index, "results should be recorded in step order")
+		assert.Equal(t, StepSucceeded, result.Status)
+	}
+}
+
+// tailBarrierClient is a barrierClient writing the command of each step to the output tail of its context
+type tailBarrierClient struct {
+	*barrierClient
+}
+
+func (c tailBarrierClient) ExecuteStepContext(ctx context.Context, step *Step, stepNum, totalSteps int) error {
+	tail := OutputTailFrom(ctx)
+	fmt.Fprintf(tail, "%s started\n", step.Run)
+	err := c.ExecuteStep(step, stepNum, totalSteps)
+	fmt.Fprintf(tail, "%s done\n", step.Run)
+	return err
+}
+
+func TestExecuteJobParallelStepsKeepOwnOutputTail(t *testing.T) {
+	client := tailBarrierClient{newBarrierClient(3, "")}
+	factory := clientFactoryFunc(func(*target.Target) (Client, error) { return client, nil })
+
+	results, err := NewService(factory).ExecuteJobWithResults(context.Background(), &target.Target{Name: "web"}, parallelJob())
+	require.NoError(t, err)
+
+	for i, step := range parallelJob().Steps {
+		assert.Equal(t, step.Run+" started\n"+step.Run+" done", results[i].OutputTail,
+			"steps running at the same time should not mix their output")
+	}
+}
+
+func TestExecuteJobParallelStepFails(t *testing.T) {
+	client := newBarrierClient(3, "b")
+	factory := clientFactoryFunc(func(*target.Target) (Client, error) { return client, nil })
+
+	var mu sync.Mutex
+	var saved []int
+	storage := &MockHashStorage{SaveHashFunc: func(_, _ string, stepIndex int, _ string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		saved = append(saved, stepIndex)
+		return nil
+	}}
+	service := NewService(factory, WithHashStorage(storage))
+
+	results, err := service.ExecuteJobWithResults(context.Background(), &target.Target{Name: "web"}, parallelJob())
+	assert.EqualError(t, err, "b broke")
+
+	assert.ElementsMatch(t, []string{"prepare", "a", "b", "c"}, client.finished, "the other steps of the group should finish")
+	assert.Equal(t, []int{0, 1, 3}, saved, "hashes should be stored for the steps that succeeded")
+
+	statuses := make([]StepStatus, 0, len(results))
+	for _, result := range results {
+		statuses = append(statuses, result.Status)
+	}
+	assert.Equal(t, []StepStatus{StepSucceeded, StepSucceeded, StepFailed, StepSucceeded}, statuses)
+}
+
+func TestExecuteJobParallelStepsSkipUnchanged(t *testing.T) {
+	client := newBarrierClient(2, "")
+	factory := clientFactoryFunc(func(*target.Target) (Client, error) { return client, nil })
+
+	job := parallelJob()
+	hasher := NewStepHasher()
+	tgt := &target.Target{Name: "web"}
+	hashes := make(map[int]string)
+	for i, step := range job.Steps[:2] {
+		hash, err := hasher.ComputeHash(step, tgt)
+		require.NoError(t, err)
+		hashes[i] = hash
+	}
+	storage := &MockHashStorage{GetHashFunc: func(_, _ string, stepIndex int) (string, error) {
+		return hashes[stepIndex], nil
+	}}
+	service := NewService(factory, WithHashStorage(storage), WithSkipUnchanged(true))
+
+	results, err := service.ExecuteJobWithResults(context.Background(), tgt, job)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"b", "c", "finish"}, client.finished)
+	assert.Equal(t, StepSkipped, results[1].Status, "unchanged parallel steps should be skipped on their own")
+}
+
+func TestExecuteJobParallelStepsReconnectOnce(t *testing.T) {
+	connErr := &ConnectionError{Target: "web", Cause: errors.New("EOF")}
+	lost := newBarrierClient(2, "")
+
+	var mu sync.Mutex
+	var clients int
+	factory := clientFactoryFunc(func(*target.Target) (Client, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		clients++
+		if clients == 1 {
+			return &failingClient{barrier: lost, err: connErr}, nil
+		}
+		return newBarrierClient(2, ""), nil
+	})
+	service := NewService(factory, WithReconnect(3, time.Millisecond))
+	service.sleep = func(time.Duration) {}
+
+	job := &Job{Name: "deploy", Steps: []*Step{{Run: "a", Parallel: true}, {Run: "b", Parallel: true}}}
+	require.NoError(t, service.ExecuteJob(&target.Target{Name: "web"}, job))
+	assert.Equal(t, 2, clients, "the connection lost by both steps should be re-established once")
+}
+
+// failingClient fails every step with err once its barrier let the parallel steps through
+type failingClient struct {
+	barrier *barrierClient
+	err     error
+}
+
+func (c *failingClient) ExecuteStep(step *Step, stepNum, totalSteps int) error {
+	if err := c.barrier.ExecuteStep(step, stepNum, totalSteps); err != nil {
+		return err
+	}
+	return c.err
+}
+
+func (c *failingClient) Close() {}
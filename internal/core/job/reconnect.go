@@ -0,0 +1,113 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+const (
+	// DefaultMaxReconnects is how often a lost connection is re-established during a job by default
+	DefaultMaxReconnects = 3
+	// DefaultReconnectBackoff is the delay before the first reconnect, doubled for each further attempt
+	DefaultReconnectBackoff = time.Second
+)
+
+// reconnectingClient wraps a Client and, when a step fails because the connection was lost,
+// re-establishes the client through the factory and executes the step again. Parallel steps may use it
+// at the same time; when they lose the connection together, it is re-established once.
+type reconnectingClient struct {
+	mu            sync.Mutex
+	client        Client
+	factory       ClientFactory
+	target        *target.Target
+	maxReconnects int
+	backoff       time.Duration
+	sleep         func(time.Duration)
+	// output is set as the output of the clients created when reconnecting
+	output Output
+}
+
+// ExecuteStep implements Client. Steps failing for other reasons, such as a command
+// exiting with a non-zero status, are never retried.
+func (c *reconnectingClient) ExecuteStep(step *Step, stepNum, totalSteps int) error {
+	return c.ExecuteStepContext(context.Background(), step, stepNum, totalSteps)
+}
+
+// ExecuteStepContext implements ContextClient. Once ctx is cancelled, the step is not retried.
+func (c *reconnectingClient) ExecuteStepContext(ctx context.Context, step *Step, stepNum, totalSteps int) error {
+	client := c.current()
+	err := executeStep(ctx, client, step, stepNum, totalSteps)
+	for attempt := 1; c.shouldReconnect(ctx, err, attempt); attempt++ {
+		reconnected, reconnectErr := c.reconnect(ctx, attempt, err, client)
+		if reconnectErr != nil {
+			err = reconnectErr
+			continue
+		}
+		client = reconnected
+		err = executeStep(ctx, client, step, stepNum, totalSteps)
+	}
+	return err
+}
+
+// current returns the client steps are executed with
+func (c *reconnectingClient) current() Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client
+}
+
+// shouldReconnect reports whether a step that failed with err is retried for the given attempt
+func (c *reconnectingClient) shouldReconnect(ctx context.Context, err error, attempt int) bool {
+	return err != nil && IsConnectionError(err) && attempt <= c.maxReconnects && ctx.Err() == nil
+}
+
+// reconnect waits for the backoff of the given attempt and replaces the failed client with a new one, which
+// it returns. If another step replaced the failed client in the meantime, the current client is returned.
+func (c *reconnectingClient) reconnect(ctx context.Context, attempt int, cause error, failed Client) (Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != failed {
+		return c.client, nil
+	}
+
+	delay := c.backoff << (attempt - 1)
+	fmt.Fprintf(c.output.Stdout(), "[%s] Connection lost (%v), reconnecting in %s (attempt %d/%d)...\n",
+		c.target.GetName(), cause, delay, attempt, c.maxReconnects)
+	c.sleep(delay)
+
+	client, err := NewClientContext(ctx, c.factory, c.target)
+	if err != nil {
+		return nil, err
+	}
+
+	setOutput(client, c.output)
+	c.client.Close()
+	c.client = client
+	return client, nil
+}
+
+// Lock implements LockingClient for clients that can lock their target
+func (c *reconnectingClient) Lock(name string, force bool) error {
+	if lockingClient, ok := c.current().(LockingClient); ok {
+		return lockingClient.Lock(name, force)
+	}
+	return nil
+}
+
+// Unlock implements LockingClient for clients that can lock their target. After a reconnect,
+// the lock is released over the new connection.
+func (c *reconnectingClient) Unlock(name string) error {
+	if lockingClient, ok := c.current().(LockingClient); ok {
+		return lockingClient.Unlock(name)
+	}
+	return nil
+}
+
+// Close implements Client
+func (c *reconnectingClient) Close() {
+	c.current().Close()
+}
@@ -0,0 +1,173 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nickalie/nship/internal/core/target"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// flakyClient fails the step running failingStep with err the first failures times
+type flakyClient struct {
+	failingStep string
+	failures    *int
+	err         error
+	executed    *[]string
+	closed      bool
+}
+
+func (c *flakyClient) ExecuteStep(step *Step, _, _ int) error {
+	*c.executed = append(*c.executed, step.Run)
+	if step.Run == c.failingStep && *c.failures > 0 {
+		*c.failures--
+		return c.err
+	}
+	return nil
+}
+
+func (c *flakyClient) Close() {
+	c.closed = true
+}
+
+// clientFactoryFunc adapts a function to the ClientFactory interface
+type clientFactoryFunc func(tgt *target.Target) (Client, error)
+
+func (f clientFactoryFunc) NewClient(tgt *target.Target) (Client, error) {
+	return f(tgt)
+}
+
+func TestExecuteJobReconnects(t *testing.T) {
+	tgt := &target.Target{Name: "web"}
+	job := &Job{Name: "deploy", Steps: []*Step{{Run: "step1"}, {Run: "step2"}, {Run: "step3"}}}
+	connErr := &ConnectionError{Target: "web", Cause: errors.New("EOF")}
+
+	tests := []struct {
+		name         string
+		err          error
+		failures     int
+		wantErr      bool
+		wantExecuted []string
+		wantDelays   []time.Duration
+	}{
+		{
+			name:         "resumes from the failed step",
+			err:          connErr,
+			failures:     2,
+			wantExecuted: []string{"step1", "step2", "step2", "step2", "step3"},
+			wantDelays:   []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:         "gives up after the maximum number of reconnects",
+			err:          connErr,
+			failures:     5,
+			wantErr:      true,
+			wantExecuted: []string{"step1", "step2", "step2", "step2", "step2"},
+			wantDelays:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:         "never retries failed commands",
+			err:          &CommandError{Command: "step2", Cause: errors.New("exit status 1")},
+			failures:     1,
+			wantErr:      true,
+			wantExecuted: []string{"step1", "step2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var executed []string
+			failures := tt.failures
+			var clients []*flakyClient
+
+			factory := clientFactoryFunc(func(*target.Target) (Client, error) {
+				client := &flakyClient{failingStep: "step2", failures: &failures, err: tt.err, executed: &executed}
+				clients = append(clients, client)
+				return client, nil
+			})
+
+			var delays []time.Duration
+			service := NewService(factory, WithReconnect(3, time.Second))
+			service.sleep = func(d time.Duration) { delays = append(delays, d) }
+
+			err := service.ExecuteJob(tgt, job)
+
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantExecuted, executed)
+			assert.Equal(t, tt.wantDelays, delays)
+			for _, client := range clients {
+				assert.True(t, client.closed, "every client should be closed")
+			}
+		})
+	}
+}
+
+func TestExecuteJobReconnectFailure(t *testing.T) {
+	tgt := &target.Target{Name: "web"}
+	job := &Job{Name: "deploy", Steps: []*Step{{Run: "step1"}}}
+	connErr := &ConnectionError{Target: "web", Cause: errors.New("EOF")}
+
+	mockClient := &MockClient{}
+	mockClient.On("ExecuteStep", mock.Anything, 1, 1).Return(connErr)
+	mockClient.On("Close").Return()
+
+	factory := &MockClientFactory{}
+	factory.On("NewClient", tgt).Return(mockClient, nil).Once()
+	factory.On("NewClient", tgt).Return(nil, connErr)
+
+	service := NewService(factory, WithReconnect(2, time.Second))
+	service.sleep = func(time.Duration) {}
+
+	err := service.ExecuteJob(tgt, job)
+	assert.ErrorIs(t, err, connErr)
+	factory.AssertNumberOfCalls(t, "NewClient", 3)
+	mockClient.AssertNumberOfCalls(t, "ExecuteStep", 1)
+}
+
+func TestExecuteJobReconnectDisabled(t *testing.T) {
+	tgt := &target.Target{Name: "web"}
+	job := &Job{Name: "deploy", Steps: []*Step{{Run: "step1"}}}
+
+	mockClient := &MockClient{}
+	mockClient.On("ExecuteStep", mock.Anything, 1, 1).Return(&ConnectionError{Target: "web", Cause: errors.New("EOF")})
+	mockClient.On("Close").Return()
+
+	factory := &MockClientFactory{}
+	factory.On("NewClient", tgt).Return(mockClient, nil)
+
+	err := NewService(factory, WithReconnect(0, time.Second)).ExecuteJob(tgt, job)
+	assert.Error(t, err)
+	factory.AssertNumberOfCalls(t, "NewClient", 1)
+}
+
+func TestReconnectingClientCancelled(t *testing.T) {
+	failures := 3
+	var executed []string
+	client := &flakyClient{
+		failingStep: "deploy",
+		failures:    &failures,
+		err:         &ConnectionError{Target: "web", Cause: errors.New("EOF")},
+		executed:    &executed,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reconnects := 0
+	rc := &reconnectingClient{
+		client: client,
+		factory: clientFactoryFunc(func(*target.Target) (Client, error) {
+			reconnects++
+			return client, nil
+		}),
+		target:        &target.Target{Name: "web"},
+		maxReconnects: 3,
+		sleep:         func(time.Duration) { cancel() },
+	}
+
+	err := rc.ExecuteStepContext(ctx, &Step{Run: "deploy"}, 1, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, reconnects)
+	assert.Equal(t, []string{"deploy"}, executed, "the step should not be retried once cancelled")
+}
@@ -0,0 +1,92 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// StepStatus is the outcome of a step
+type StepStatus string
+
+const (
+	// StepSucceeded is the status of a step that was executed successfully
+	StepSucceeded StepStatus = "succeeded"
+	// StepFailed is the status of a step that was executed and failed
+	StepFailed StepStatus = "failed"
+	// StepSkipped is the status of a step that was skipped as unchanged or as a run-once step already run
+	StepSkipped StepStatus = "skipped"
+)
+
+// StepResult describes how a step of a job was handled on a target. Index is the position of the step
+// in the job, starting at 0. OutputTail holds the last lines of output of executed steps if the client
+// writes them to the OutputTail of the context of the step.
+type StepResult struct {
+	Target     string
+	Job        string
+	Index      int
+	Type       StepType
+	Status     StepStatus
+	Duration   time.Duration
+	OutputTail string
+	Err        error
+}
+
+// stepRecorder collects the results of the steps handled by a service
+type stepRecorder struct {
+	results []StepResult
+}
+
+// ExecuteJobWithResults executes a job like ExecuteJobContext and also returns the results of its steps
+func (s *Service) ExecuteJobWithResults(ctx context.Context, tgt *target.Target, job *Job) ([]StepResult, error) {
+	run := *s
+	run.recorder = &stepRecorder{}
+	err := run.ExecuteJobContext(ctx, tgt, job)
+	return run.recorder.results, err
+}
+
+// ExecuteJobsWithResults executes jobs like ExecuteJobsWithHooksContext and also returns the results of the
+// job steps in the order they were handled: skipped and executed steps up to and including a failed one.
+// Hook steps are not included.
+func (s *Service) ExecuteJobsWithResults(
+	ctx context.Context, targets []*target.Target, jobs []*Job, beforeAll, afterAll *Job,
+) ([]StepResult, error) {
+	run := *s
+	run.recorder = &stepRecorder{}
+	err := run.ExecuteJobsWithHooksContext(ctx, targets, jobs, beforeAll, afterAll)
+	return run.recorder.results, err
+}
+
+// executeRecordedStep executes a step of job and records its result
+func (s *Service) executeRecordedStep(ctx context.Context, client Client, tgt *target.Target, job *Job, stepIndex int) error {
+	result := s.executeTimedStep(ctx, client, tgt, job, stepIndex)
+	s.recordStep(tgt, job, stepIndex, result)
+	return result.Err
+}
+
+// executeTimedStep executes a step of job and returns its result without recording it
+func (s *Service) executeTimedStep(ctx context.Context, client Client, tgt *target.Target, job *Job, stepIndex int) StepResult {
+	tail := &OutputTail{}
+	start := time.Now()
+	err := s.executeJobStep(WithOutputTail(ctx, tail), client, tgt, job, job.Steps[stepIndex], stepIndex+1, len(job.Steps))
+
+	result := StepResult{Status: StepSucceeded, Duration: time.Since(start), Err: err, OutputTail: tail.String()}
+	if err != nil {
+		result.Status = StepFailed
+	}
+	return result
+}
+
+// recordStep completes result with the step it describes and records it, if the service collects results
+func (s *Service) recordStep(tgt *target.Target, job *Job, stepIndex int, result StepResult) {
+	if s.recorder == nil {
+		return
+	}
+
+	result.Target = tgt.GetName()
+	result.Job = job.Name
+	result.Index = stepIndex
+	result.Type = job.Steps[stepIndex].GetType()
+	s.recorder.results = append(s.recorder.results, result)
+}
@@ -0,0 +1,83 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// tailRecordingClient is a recordingClient that writes the output of each command to the tail of its context
+type tailRecordingClient struct {
+	recordingClient
+}
+
+func (c *tailRecordingClient) ExecuteStepContext(ctx context.Context, step *Step, stepNum, totalSteps int) error {
+	if tail := OutputTailFrom(ctx); tail != nil {
+		fmt.Fprintf(tail, "output of %s\n", step.Run)
+	}
+	return c.ExecuteStep(step, stepNum, totalSteps)
+}
+
+func TestExecuteJobsWithResults(t *testing.T) {
+	client := &tailRecordingClient{recordingClient{failingStep: "restart"}}
+	mockClientFactory := &MockClientFactory{}
+	mockClientFactory.On("NewClient", mock.Anything).Return(client, nil)
+
+	jobs := []*Job{{
+		Name:      "app",
+		Steps:     []*Step{{Run: "migrate", RunOnce: true}, {Run: "deploy"}, {Run: "restart"}, {Run: "verify"}},
+		OnFailure: []*Step{{Run: "rollback"}},
+	}}
+
+	service := NewService(mockClientFactory, WithKeepGoing(true))
+	results, err := service.ExecuteJobsWithResults(context.Background(), []*target.Target{{Name: "web1"}, {Name: "web2"}}, jobs, nil, nil)
+	require.Error(t, err)
+
+	type summary struct {
+		Target string
+		Index  int
+		Status StepStatus
+	}
+	var summaries []summary
+	for _, result := range results {
+		summaries = append(summaries, summary{result.Target, result.Index, result.Status})
+		assert.Equal(t, "app", result.Job)
+		assert.Equal(t, RunStep, result.Type)
+	}
+	assert.Equal(t, []summary{
+		{"web1", 0, StepSucceeded}, {"web1", 1, StepSucceeded}, {"web1", 2, StepFailed},
+		{"web2", 0, StepSkipped}, {"web2", 1, StepSucceeded}, {"web2", 2, StepFailed},
+	}, summaries, "the run-once step should be skipped on web2 and steps after a failure should not be reported")
+
+	assert.Equal(t, "output of deploy", results[1].OutputTail)
+	assert.EqualError(t, results[2].Err, "restart broke")
+	assert.Empty(t, results[3].OutputTail, "skipped steps have no output")
+	assert.Nil(t, results[3].Err)
+}
+
+func TestExecuteJobWithResultsSkipsUnchanged(t *testing.T) {
+	tgt := &target.Target{Name: "web1"}
+	job := &Job{Name: "app", Steps: []*Step{{Run: "deploy"}}}
+
+	client := &recordingClient{}
+	mockClientFactory := &MockClientFactory{}
+	mockClientFactory.On("NewClient", tgt).Return(client, nil)
+
+	service := NewService(mockClientFactory, WithSkipUnchanged(true))
+	service.stepHasher = &MockStepHasher{ComputeHashFunc: func(*Step, *target.Target) (string, error) { return "hash", nil }}
+	service.hashStorage = &MockHashStorage{GetHashFunc: func(string, string, int) (string, error) { return "hash", nil }}
+
+	results, err := service.ExecuteJobWithResults(context.Background(), tgt, job)
+	require.NoError(t, err)
+
+	require.Len(t, results, 1)
+	assert.Equal(t, StepSkipped, results[0].Status)
+	assert.Empty(t, client.executed)
+	assert.Nil(t, service.recorder, "only the copy of the service running the job should record results")
+}
@@ -1,9 +1,17 @@
 package job
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"os"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/nickalie/nship/internal/core/target"
+	"github.com/nickalie/nship/internal/util"
 )
 
 // Service handles the execution of jobs
@@ -12,8 +20,35 @@ type Service struct {
 	hashStorage   HashStorage
 	stepHasher    StepHasherInterface
 	skipUnchanged bool
+	namespace     string
+	keepGoing     bool
+	// forceJobs holds the names or glob patterns of the jobs whose steps run even when unchanged
+	forceJobs []string
+	// targetOrder is the order in which targets are visited, see OrderTargets
+	targetOrder string
+	// logDir is the directory of the log files of the targets, none when empty
+	logDir string
+	// quiet disables writing the output of the targets to the console
+	quiet bool
+	// output receives the progress messages and command output of the target being deployed
+	output Output
+	// lockName is the name of the deploy lock taken on each target, none when empty
+	lockName string
+	// forceUnlock replaces deploy locks held by other deployments
+	forceUnlock bool
+	// Reconnect settings for connections lost during a job
+	maxReconnects    int
+	reconnectBackoff time.Duration
+	sleep            func(time.Duration)
+	// runOnceDone tracks the run-once steps handled during ExecuteJobs, keyed by job name and step index
+	runOnceDone map[string]bool
+	// recorder collects the step results during ExecuteJobsWithResults and ExecuteJobWithResults
+	recorder *stepRecorder
 }
 
+// runOnceTarget is the synthetic target name under which hashes of run-once steps are stored
+const runOnceTarget = "*run_once*"
+
 // ServiceOption represents an option for configuring a Service
 type ServiceOption func(*Service)
 
@@ -24,6 +59,14 @@ func WithHashStorage(storage HashStorage) ServiceOption {
 	}
 }
 
+// WithNamespace keeps the hashes of the service apart from those of other configurations using
+// the same hash storage, if the storage supports namespaces
+func WithNamespace(namespace string) ServiceOption {
+	return func(s *Service) {
+		s.namespace = namespace
+	}
+}
+
 // WithSkipUnchanged sets whether unchanged steps should be skipped
 func WithSkipUnchanged(skip bool) ServiceOption {
 	return func(s *Service) {
@@ -31,27 +74,123 @@ func WithSkipUnchanged(skip bool) ServiceOption {
 	}
 }
 
+// WithForceJobs executes all steps of the named jobs even when they are unchanged, while the steps of
+// other jobs are still skipped. Names may be glob patterns, e.g. deploy-*, and also match the jobs
+// a matrix job expands into.
+func WithForceJobs(names ...string) ServiceOption {
+	return func(s *Service) {
+		s.forceJobs = append(s.forceJobs, names...)
+	}
+}
+
+// WithTargetOrder sets the order in which the targets are visited: file, reverse, sorted
+// or a comma-separated list of all target names. See OrderTargets.
+func WithTargetOrder(order string) ServiceOption {
+	return func(s *Service) {
+		s.targetOrder = order
+	}
+}
+
+// WithLogDir sets a directory to which the progress messages and command output of each target
+// are also written, into a log file per target named after it. An empty dir writes no log files.
+func WithLogDir(dir string) ServiceOption {
+	return func(s *Service) {
+		s.logDir = dir
+	}
+}
+
+// WithQuiet disables writing the progress messages and command output of the targets to the console.
+// They are still written to the log files of WithLogDir.
+func WithQuiet(quiet bool) ServiceOption {
+	return func(s *Service) {
+		s.quiet = quiet
+	}
+}
+
+// WithForceUnlock makes deployments replace the deploy locks of targets held by other deployments,
+// e.g. locks left behind by a deployment that crashed. See SetLockName.
+func WithForceUnlock(force bool) ServiceOption {
+	return func(s *Service) {
+		s.forceUnlock = force
+	}
+}
+
+// WithKeepGoing sets whether the remaining jobs and targets are still executed after a job failed
+func WithKeepGoing(keepGoing bool) ServiceOption {
+	return func(s *Service) {
+		s.keepGoing = keepGoing
+	}
+}
+
+// WithFileSystem sets the file system used to read copy step sources when computing step hashes
+func WithFileSystem(fsys FileSystem) ServiceOption {
+	return func(s *Service) {
+		s.stepHasher = NewStepHasherWithFileSystem(fsys)
+	}
+}
+
+// WithReconnect sets how often a connection lost during a job is re-established and the delay
+// before the first attempt, which doubles for each further one. Zero reconnects disables it.
+func WithReconnect(maxReconnects int, backoff time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.maxReconnects = maxReconnects
+		s.reconnectBackoff = backoff
+	}
+}
+
 // NewService creates a new Service with the given options
 func NewService(clientFactory ClientFactory, opts ...ServiceOption) *Service {
 	service := &Service{
-		clientFactory: clientFactory,
-		stepHasher:    NewStepHasher(),
+		clientFactory:    clientFactory,
+		stepHasher:       NewStepHasher(),
+		maxReconnects:    DefaultMaxReconnects,
+		reconnectBackoff: DefaultReconnectBackoff,
+		sleep:            time.Sleep,
 	}
 
 	for _, opt := range opts {
 		opt(service)
 	}
 
+	if storage, ok := service.hashStorage.(NamespacedHashStorage); ok && service.namespace != "" {
+		storage.SetNamespace(service.namespace)
+	}
+
 	return service
 }
 
-// determineStepsToExecute returns a slice indicating which steps need execution
+// SetLockName makes the following deployments lock each target under name before running jobs on it
+// and unlock it afterwards, so that deployments with the same name don't run on a target at the same time.
+// A target locked by another deployment fails. Only targets whose clients implement LockingClient are locked.
+// An empty name disables locking.
+func (s *Service) SetLockName(name string) {
+	s.lockName = name
+}
+
+// determineStepsToExecute returns a slice indicating which steps need execution.
+// Steps that always run or whose condition is false don't make the steps after them run.
 func (s *Service) determineStepsToExecute(tgt *target.Target, job *Job) ([]bool, error) {
 	stepShouldExecute := make([]bool, len(job.Steps))
+	forced := s.forced(job)
 	var foundChange bool
 
 	for i, step := range job.Steps {
-		shouldExecute, err := s.shouldExecuteStep(tgt, job, i, step, false)
+		holds, err := step.ConditionHolds(tgt, os.Getenv)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		if !holds {
+			msg := fmt.Sprintf("[%s] Step %d in job '%s' [skipped, condition false]", tgt.GetName(), i+1, job.Name)
+			fmt.Fprintln(s.output.Stdout(), util.Skipped(msg))
+			continue
+		}
+
+		if step.AlwaysRuns() {
+			stepShouldExecute[i] = true
+			continue
+		}
+
+		shouldExecute, err := s.shouldExecuteStep(tgt, job, i, step, forced)
 		if err != nil {
 			return nil, err
 		}
@@ -63,68 +202,525 @@ func (s *Service) determineStepsToExecute(tgt *target.Target, job *Job) ([]bool,
 	return stepShouldExecute, nil
 }
 
-// executeRequiredSteps executes the steps marked as required
-func (s *Service) executeRequiredSteps(client Client, tgt *target.Target, job *Job, stepShouldExecute []bool) error {
-	for i, step := range job.Steps {
-		if !stepShouldExecute[i] {
-			continue
+// executeRequiredSteps executes the steps marked as required. Groups of consecutive parallel steps
+// run at the same time, and the steps after a group wait for all of its steps.
+func (s *Service) executeRequiredSteps(ctx context.Context, client Client, tgt *target.Target, job *Job, stepShouldExecute []bool) error {
+	for start := 0; start < len(job.Steps); {
+		end := parallelGroupEnd(job.Steps, start)
+		var err error
+		if end-start > 1 {
+			err = s.executeParallelSteps(ctx, client, tgt, job, start, end, stepShouldExecute)
+		} else {
+			err = s.executeRequiredStep(ctx, client, tgt, job, start, stepShouldExecute[start])
 		}
-
-		if err := client.ExecuteStep(step, i+1, len(job.Steps)); err != nil {
+		if err != nil {
 			return err
 		}
+		start = end
+	}
+	return nil
+}
 
-		if s.hashStorage != nil {
-			if err := s.storeStepHash(tgt, job, i, step); err != nil {
-				return err
-			}
-		}
+// executeRequiredStep executes a step unless it is skipped and stores its hash
+func (s *Service) executeRequiredStep(ctx context.Context, client Client, tgt *target.Target, job *Job, stepIndex int,
+	shouldExecute bool) error {
+	step := job.Steps[stepIndex]
+	if s.skipStep(tgt, job, stepIndex, step, shouldExecute) {
+		s.recordStep(tgt, job, stepIndex, StepResult{Status: StepSkipped})
+		return nil
+	}
+
+	if err := s.executeRecordedStep(ctx, client, tgt, job, stepIndex); err != nil {
+		return err
+	}
+
+	if s.hashStorage != nil {
+		return s.storeStepHash(tgt, job, stepIndex, step)
 	}
 	return nil
 }
 
-// ExecuteJob executes a job on a target
+// skipStep reports whether a step is skipped on tgt because its condition is false, it is a run-once step
+// handled on an earlier target or it doesn't need execution. Conditions are checked first so that a run-once
+// step whose condition is false still runs on a later target where it holds.
+func (s *Service) skipStep(tgt *target.Target, job *Job, stepIndex int, step *Step, shouldExecute bool) bool {
+	// invalid conditions were reported by determineStepsToExecute
+	if holds, _ := step.ConditionHolds(tgt, os.Getenv); !holds {
+		return true
+	}
+	return s.runOnceHandled(tgt, job, stepIndex, step) || !shouldExecute
+}
+
+// runOnceHandled reports whether a run-once step was already run or skipped as unchanged
+// on an earlier target, and otherwise records that it is handled on this one
+func (s *Service) runOnceHandled(tgt *target.Target, job *Job, stepIndex int, step *Step) bool {
+	if !step.RunOnce || s.runOnceDone == nil {
+		return false
+	}
+
+	key := fmt.Sprintf("%s:%d", job.Name, stepIndex)
+	if s.runOnceDone[key] {
+		msg := fmt.Sprintf("[%s] Step %d in job '%s' [skipped, run-once already executed]", tgt.GetName(), stepIndex+1, job.Name)
+		fmt.Fprintln(s.output.Stdout(), util.Skipped(msg))
+		return true
+	}
+
+	s.runOnceDone[key] = true
+	return false
+}
+
+// hashTarget returns the target name a step's hash is stored under and the target included in the hash.
+// Run-once steps use a synthetic target so that their hash is the same for every target.
+func hashTarget(tgt *target.Target, step *Step) (string, *target.Target) {
+	if step.RunOnce {
+		return runOnceTarget, nil
+	}
+	return tgt.GetName(), tgt
+}
+
+// ExecuteJob executes a job on a target over a connection of its own, running its hooks around the steps
 func (s *Service) ExecuteJob(tgt *target.Target, job *Job) error {
-	client, err := s.clientFactory.NewClient(tgt)
+	return s.ExecuteJobContext(context.Background(), tgt, job)
+}
+
+// ExecuteJobContext executes a job like ExecuteJob. When ctx is cancelled, the step in progress
+// is stopped if the client supports it, the on_failure hooks run and no further steps are started.
+func (s *Service) ExecuteJobContext(ctx context.Context, tgt *target.Target, job *Job) error {
+	run, closeOutput, err := s.forTarget(tgt)
+	if err != nil {
+		return err
+	}
+
+	err = run.executeJobOnNewClient(ctx, tgt, job)
+	if closeErr := closeOutput(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to close log file: %w", closeErr)
+	}
+	return err
+}
+
+// executeJobOnNewClient executes a job on a target over a connection of its own
+func (s *Service) executeJobOnNewClient(ctx context.Context, tgt *target.Target, job *Job) error {
+	client, err := s.newClient(ctx, tgt)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 
+	unlock, err := s.lockTarget(client, tgt)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.executeJob(ctx, client, tgt, job)
+}
+
+// executeJob executes a job on a target using client, running its hooks around the steps.
+// The on_failure hooks also run when the job was cancelled, as they clean up after it.
+func (s *Service) executeJob(ctx context.Context, client Client, tgt *target.Target, job *Job) error {
+	if err := s.executeSteps(ctx, client, tgt, job); err != nil {
+		hooks := failureHooks(job.OnFailure, err, tgt)
+		if hookErr := s.executeHooks(context.WithoutCancel(ctx), client, tgt, job, "on_failure", hooks); hookErr != nil {
+			return fmt.Errorf("%w; %v", err, hookErr)
+		}
+		return err
+	}
+
+	return s.executeHooks(ctx, client, tgt, job, "after_job", job.AfterJob)
+}
+
+// newClient creates a client for the target that writes to the output of the service
+// and reconnects when the connection is lost during a step. Connecting is given up once ctx is done.
+func (s *Service) newClient(ctx context.Context, tgt *target.Target) (Client, error) {
+	client, err := NewClientContext(ctx, s.clientFactory, tgt)
+	if err != nil {
+		return nil, err
+	}
+	setOutput(client, s.output)
+	if s.maxReconnects <= 0 {
+		return client, nil
+	}
+
+	return &reconnectingClient{
+		client:        client,
+		factory:       s.clientFactory,
+		target:        tgt,
+		maxReconnects: s.maxReconnects,
+		backoff:       s.reconnectBackoff,
+		sleep:         s.sleep,
+		output:        s.output,
+	}, nil
+}
+
+// setOutput makes client write to out, unless out is the default output or the client can't be redirected
+func setOutput(client Client, out Output) {
+	if outputClient, ok := client.(OutputClient); ok && !out.isDefault() {
+		outputClient.SetOutput(out)
+	}
+}
+
+// executeSteps runs the before_job hooks and then the steps of the job that need execution
+func (s *Service) executeSteps(ctx context.Context, client Client, tgt *target.Target, job *Job) error {
+	if err := s.executeHooks(ctx, client, tgt, job, "before_job", job.BeforeJob); err != nil {
+		return err
+	}
+
 	stepShouldExecute, err := s.determineStepsToExecute(tgt, job)
 	if err != nil {
 		return err
 	}
 
-	return s.executeRequiredSteps(client, tgt, job, stepShouldExecute)
+	if err := s.executeRequiredSteps(ctx, client, tgt, job, stepShouldExecute); err != nil {
+		return err
+	}
+	return s.checkHealth(ctx, client, tgt, job)
+}
+
+// checkHealth runs the health check of a rolling job on tgt until it passes, waiting the interval of the check
+// between attempts, so that the next target is only deployed once tgt is healthy
+func (s *Service) checkHealth(ctx context.Context, client Client, tgt *target.Target, job *Job) error {
+	check := job.HealthCheck
+	if job.Strategy != StrategyRolling || check == nil {
+		return nil
+	}
+
+	fmt.Fprintf(s.output.Stdout(), "[%s] Checking health for job '%s'\n", tgt.GetName(), job.Name)
+	assert := &Step{Assert: &AssertStep{Command: check.Command}}
+	wait := &Step{Wait: &WaitStep{Duration: check.GetInterval()}}
+	attempts := check.Retries + 1
+	for attempt := 1; ; attempt++ {
+		err := s.executeJobStep(ctx, client, tgt, job, assert, attempt, attempts)
+		if err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			return fmt.Errorf("health check failed after %d attempts: %w", attempts, err)
+		}
+		if err := s.executeJobStep(ctx, client, tgt, job, wait, attempt, attempts); err != nil {
+			return err
+		}
+	}
+}
+
+// executeHooks runs all hook steps of the given kind. Hooks are never skipped as unchanged and store no hashes,
+// but they are skipped when their condition is false.
+func (s *Service) executeHooks(ctx context.Context, client Client, tgt *target.Target, job *Job, kind string, hooks []*Step) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(s.output.Stdout(), "[%s] Running %s hooks for job '%s'\n", tgt.GetName(), kind, job.Name)
+	for i, hook := range hooks {
+		holds, err := hook.ConditionHolds(tgt, os.Getenv)
+		if err != nil {
+			return fmt.Errorf("%s hook %d/%d: %w", kind, i+1, len(hooks), err)
+		}
+		if !holds {
+			msg := fmt.Sprintf("[%s] %s hook %d in job '%s' [skipped, condition false]", tgt.GetName(), kind, i+1, job.Name)
+			fmt.Fprintln(s.output.Stdout(), util.Skipped(msg))
+			continue
+		}
+
+		if err := s.executeJobStep(ctx, client, tgt, job, hook, i+1, len(hooks)); err != nil {
+			return fmt.Errorf("%s hook %d/%d failed: %w", kind, i+1, len(hooks), err)
+		}
+	}
+	return nil
+}
+
+// executeJobStep executes a step of job on tgt and reports when it was interrupted by cancelling ctx
+func (s *Service) executeJobStep(ctx context.Context, client Client, tgt *target.Target, job *Job, step *Step,
+	stepNum, totalSteps int) error {
+	started := ctx.Err() == nil
+	err := executeStep(ctx, client, withJobContext(step, job), stepNum, totalSteps)
+	if err != nil && started && ctx.Err() != nil {
+		msg := fmt.Sprintf("[%s] Interrupted step %d/%d of job '%s'", tgt.GetName(), stepNum, totalSteps, job.Name)
+		fmt.Fprintln(s.output.Stdout(), util.Failure(msg))
+	}
+	return err
+}
+
+// executeStep executes a step through client unless ctx is already cancelled, passing ctx on
+// to clients that can stop the step in progress
+func executeStep(ctx context.Context, client Client, step *Step, stepNum, totalSteps int) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("deployment cancelled: %w", err)
+	}
+
+	if contextClient, ok := client.(ContextClient); ok {
+		return contextClient.ExecuteStepContext(ctx, step, stepNum, totalSteps)
+	}
+	return client.ExecuteStep(step, stepNum, totalSteps)
+}
+
+// withJobContext returns a copy of an exec step that carries the name of its job, and other steps as they are
+func withJobContext(step *Step, job *Job) *Step {
+	if step.Exec == nil {
+		return step
+	}
+
+	stepCopy := *step
+	execCopy := *step.Exec
+	execCopy.Job = job.Name
+	stepCopy.Exec = &execCopy
+	return &stepCopy
+}
+
+// failureHooks returns copies of the on_failure hooks of a job failed on tgt, which get the failure message in
+// ErrorEnvVariable. The message is never inserted into shell code, so quotes or $() in it can't run as commands:
+// ${error} in run commands is replaced with a reference to the variable, and in the arguments of run_script
+// and exec hooks, which are passed without being interpreted, with the message itself.
+func failureHooks(hooks []*Step, cause error, tgt *target.Target) []*Step {
+	msg := cause.Error()
+	result := make([]*Step, len(hooks))
+	for i, hook := range hooks {
+		hookCopy := *hook
+		hookCopy.Env = withVariable(hook.Env, ErrorEnvVariable, msg)
+		hookCopy.Run = strings.ReplaceAll(hook.Run, ErrorVariable, errorReference(tgt))
+		if hook.RunScript != nil {
+			scriptCopy := *hook.RunScript
+			scriptCopy.Args = replaceErrorVariable(hook.RunScript.Args, msg)
+			hookCopy.RunScript = &scriptCopy
+		}
+		if hook.Exec != nil {
+			execCopy := *hook.Exec
+			execCopy.Command = replaceErrorVariable(hook.Exec.Command, msg)
+			execCopy.Env = withVariable(hook.Exec.Env, ErrorEnvVariable, msg)
+			hookCopy.Exec = &execCopy
+		}
+		result[i] = &hookCopy
+	}
+	return result
+}
+
+// errorReference returns the reference to ErrorEnvVariable in the shell of tgt
+func errorReference(tgt *target.Target) string {
+	if tgt.IsWindows() {
+		return "$env:" + ErrorEnvVariable
+	}
+	return "${" + ErrorEnvVariable + "}"
+}
+
+// replaceErrorVariable returns a copy of args with ${error} replaced by msg
+func replaceErrorVariable(args []string, msg string) []string {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = strings.ReplaceAll(arg, ErrorVariable, msg)
+	}
+	return result
+}
+
+// withVariable returns a copy of env with name set to value
+func withVariable(env map[string]string, name, value string) map[string]string {
+	result := make(map[string]string, len(env)+1)
+	maps.Copy(result, env)
+	result[name] = value
+	return result
 }
 
 // ExecuteJobs executes multiple jobs on multiple targets
 func (s *Service) ExecuteJobs(targets []*target.Target, jobs []*Job) error {
+	return s.ExecuteJobsWithHooks(targets, jobs, nil, nil)
+}
+
+// ExecuteJobsWithHooks executes multiple jobs on multiple targets, running beforeAll on each
+// target before its jobs and afterAll after them, even when a job failed. Either may be nil.
+// Their steps always run and are never skipped as unchanged. Execution stops at the first failure
+// unless the service keeps going, in which case the failures are returned as a *DeploymentError.
+// Jobs with a matrix are expanded into one job per combination of values first, and the targets
+// are visited in the target order of the service.
+func (s *Service) ExecuteJobsWithHooks(targets []*target.Target, jobs []*Job, beforeAll, afterAll *Job) error {
+	return s.ExecuteJobsWithHooksContext(context.Background(), targets, jobs, beforeAll, afterAll)
+}
+
+// ExecuteJobsWithHooksContext executes jobs like ExecuteJobsWithHooks. When ctx is cancelled, the step
+// in progress is stopped if the client supports it and no further steps, jobs or targets are started,
+// even when the service keeps going. The on_failure and afterAll hooks still run to clean up.
+func (s *Service) ExecuteJobsWithHooksContext(ctx context.Context, targets []*target.Target, jobs []*Job, beforeAll, afterAll *Job) error {
+	targets, jobs, err := s.plan(targets, jobs)
+	if err != nil {
+		return err
+	}
+
+	run := *s
+	run.runOnceDone = make(map[string]bool)
+
+	summary := &DeploymentError{}
 	for _, tgt := range targets {
-		for _, job := range jobs {
-			if err := s.ExecuteJob(tgt, job); err != nil {
-				return fmt.Errorf("failed to execute job %s on target %s: %w", job.Name, tgt.GetName(), err)
+		failed, err := run.executeOnTarget(ctx, tgt, jobs, beforeAll, afterAll)
+		summary.Total += len(jobs)
+		summary.Failed += failed
+		if err != nil {
+			if !s.keepGoing || ctx.Err() != nil {
+				return err
 			}
+			summary.Errors = append(summary.Errors, err)
 		}
 	}
+
+	if len(summary.Errors) == 0 {
+		return nil
+	}
+	return summary
+}
+
+// plan returns the targets in the target order of the service and the jobs with their matrices expanded
+func (s *Service) plan(targets []*target.Target, jobs []*Job) ([]*target.Target, []*Job, error) {
+	targets, err := OrderTargets(targets, s.targetOrder)
+	if err != nil {
+		return nil, nil, err
+	}
+	jobs, err = ExpandMatrix(jobs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return targets, jobs, nil
+}
+
+// executeOnTarget runs the global hooks and the jobs on a target, writing to the output of the target,
+// which is closed once all of them have finished, and returns how many jobs failed
+func (s *Service) executeOnTarget(ctx context.Context, tgt *target.Target, jobs []*Job, beforeAll, afterAll *Job) (int, error) {
+	run, closeOutput, err := s.forTarget(tgt)
+	if err != nil {
+		return len(jobs), err
+	}
+
+	failed, err := run.executeOnConnection(ctx, tgt, jobs, beforeAll, afterAll)
+	if closeErr := closeOutput(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to close log file: %w", closeErr)
+	}
+	return failed, err
+}
+
+// executeOnConnection runs the global hooks and the jobs on a target, sharing one connection
+// that is closed once all of them have finished, and returns how many jobs failed
+func (s *Service) executeOnConnection(ctx context.Context, tgt *target.Target, jobs []*Job, beforeAll, afterAll *Job) (int, error) {
+	client, err := s.newClient(ctx, tgt)
+	if err != nil {
+		return len(jobs), err
+	}
+	defer client.Close()
+
+	unlock, err := s.lockTarget(client, tgt)
+	if err != nil {
+		return len(jobs), err
+	}
+	defer unlock()
+
+	failed, err := s.executeTargetJobs(ctx, client, tgt, jobs, beforeAll)
+	if afterErr := s.executeGlobalHook(context.WithoutCancel(ctx), client, tgt, "after_all", afterAll); afterErr != nil {
+		if err == nil {
+			return failed, afterErr
+		}
+		return failed, fmt.Errorf("%w; %v", err, afterErr)
+	}
+	return failed, err
+}
+
+// lockTarget takes the deploy lock of the service on tgt, if any, and returns the function releasing it.
+// Failing to release the lock is only reported, as the deployment itself has finished.
+func (s *Service) lockTarget(client Client, tgt *target.Target) (func(), error) {
+	lockingClient, ok := client.(LockingClient)
+	if !ok || s.lockName == "" {
+		return func() {}, nil
+	}
+
+	if err := lockingClient.Lock(s.lockName, s.forceUnlock); err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := lockingClient.Unlock(s.lockName); err != nil {
+			fmt.Fprintf(s.output.Stdout(), "Warning: failed to release the deploy lock of target '%s': %v\n", tgt.GetName(), err)
+		}
+	}, nil
+}
+
+// executeTargetJobs runs beforeAll and then the jobs on a target, stopping at the first failure
+// unless the service keeps going, and returns how many jobs failed
+func (s *Service) executeTargetJobs(ctx context.Context, client Client, tgt *target.Target, jobs []*Job, beforeAll *Job) (int, error) {
+	if err := s.executeGlobalHook(ctx, client, tgt, "before_all", beforeAll); err != nil {
+		return len(jobs), err
+	}
+
+	var errs []error
+	for _, job := range jobs {
+		err := s.executeJob(ctx, client, tgt, job)
+		if err == nil {
+			continue
+		}
+
+		err = fmt.Errorf("failed to execute job %s on target %s: %w", job.Name, tgt.GetName(), err)
+		if !s.keepGoing || ctx.Err() != nil {
+			return 1, err
+		}
+		errs = append(errs, err)
+	}
+	return len(errs), errors.Join(errs...)
+}
+
+// executeGlobalHook runs a before_all or after_all job on a target without hashing its steps
+func (s *Service) executeGlobalHook(ctx context.Context, client Client, tgt *target.Target, kind string, hook *Job) error {
+	if hook == nil {
+		return nil
+	}
+
+	hookJob := *hook
+	if hookJob.Name == "" {
+		hookJob.Name = kind
+	}
+
+	if err := s.withoutHashes().executeJob(ctx, client, tgt, &hookJob); err != nil {
+		return fmt.Errorf("%s failed on target %s: %w", kind, tgt.GetName(), err)
+	}
 	return nil
 }
 
+// withoutHashes returns a copy of the service that executes every step and stores no hashes
+func (s *Service) withoutHashes() *Service {
+	service := *s
+	service.hashStorage = nil
+	return &service
+}
+
 // storeStepHash stores the hash of a step
 func (s *Service) storeStepHash(tgt *target.Target, job *Job, stepIndex int, step *Step) error {
-	hash, err := s.stepHasher.ComputeHash(step, tgt)
+	targetName, hashTgt := hashTarget(tgt, step)
+	hash, err := s.stepHasher.ComputeHash(step, hashTgt)
 	if err != nil {
 		return fmt.Errorf("failed to compute step hash: %v", err)
 	}
 
-	if err := s.hashStorage.SaveHash(tgt.GetName(), job.Name, stepIndex, hash); err != nil {
+	if err := s.hashStorage.SaveHash(targetName, job.Name, stepIndex, hash); err != nil {
 		return fmt.Errorf("failed to save step hash: %v", err)
 	}
 
 	return nil
 }
 
+// forced reports whether the steps of job run even when they are unchanged. A job expanded from a matrix
+// is forced when the matrix job is.
+func (s *Service) forced(job *Job) bool {
+	for _, pattern := range s.forceJobs {
+		if MatchesJob(pattern, job) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesJob reports whether pattern is the name of job or a glob pattern matching it. Matrix instances
+// such as deploy[region=eu] are also matched by the name of their job.
+func MatchesJob(pattern string, job *Job) bool {
+	base, _, _ := strings.Cut(job.Name, "[")
+	return matchJobName(pattern, job.Name) || matchJobName(pattern, base)
+}
+
+// matchJobName reports whether name is the job name or matches the glob pattern
+func matchJobName(pattern, name string) bool {
+	ok, err := path.Match(pattern, name)
+	return pattern == name || (err == nil && ok)
+}
+
 // shouldSkipExecution checks if step execution can be skipped based on service configuration
 func (s *Service) shouldSkipExecution(forceExecute bool) bool {
 	return !forceExecute && s.skipUnchanged && s.hashStorage != nil
@@ -132,12 +728,13 @@ func (s *Service) shouldSkipExecution(forceExecute bool) bool {
 
 // getStepHashes computes current hash and retrieves stored hash
 func (s *Service) getStepHashes(tgt *target.Target, job *Job, stepIndex int, step *Step) (currentHash, storedHash string, err error) {
-	currentHash, err = s.stepHasher.ComputeHash(step, tgt)
+	targetName, hashTgt := hashTarget(tgt, step)
+	currentHash, err = s.stepHasher.ComputeHash(step, hashTgt)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to compute step hash: %w", err)
 	}
 
-	storedHash, err = s.hashStorage.GetHash(tgt.GetName(), job.Name, stepIndex)
+	storedHash, err = s.hashStorage.GetHash(targetName, job.Name, stepIndex)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to get stored hash: %w", err)
 	}
@@ -159,7 +756,8 @@ func (s *Service) shouldExecuteStep(tgt *target.Target, job *Job, stepIndex int,
 
 	shouldExecute := storedHash == "" || storedHash != currentHash
 	if !shouldExecute {
-		fmt.Printf("[%s] Skipping step %d in job '%s' (unchanged)\n", tgt.GetName(), stepIndex+1, job.Name)
+		msg := fmt.Sprintf("[%s] Skipping step %d in job '%s' (unchanged)", tgt.GetName(), stepIndex+1, job.Name)
+		fmt.Fprintln(s.output.Stdout(), util.Skipped(msg))
 	}
 
 	return shouldExecute, nil
@@ -1,8 +1,10 @@
 package job
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/nickalie/nship/internal/core/target"
@@ -37,6 +39,27 @@ func TestNewServiceWithOptions(t *testing.T) {
 	assert.Equal(t, false, service.skipUnchanged, "SkipUnchanged option was not applied")
 }
 
+// namespacedHashStorage records the namespace set on it
+type namespacedHashStorage struct {
+	MockHashStorage
+	namespace string
+}
+
+func (m *namespacedHashStorage) SetNamespace(namespace string) {
+	m.namespace = namespace
+}
+
+func TestNewServiceWithNamespace(t *testing.T) {
+	storage := &namespacedHashStorage{}
+
+	NewService(&MockClientFactory{}, WithNamespace("shop"), WithHashStorage(storage))
+	assert.Equal(t, "shop", storage.namespace, "the namespace should apply regardless of option order")
+
+	storage = &namespacedHashStorage{namespace: "blog"}
+	NewService(&MockClientFactory{}, WithHashStorage(storage), WithNamespace(""))
+	assert.Equal(t, "blog", storage.namespace, "an empty namespace should leave the storage unchanged")
+}
+
 func TestShouldExecuteStep(t *testing.T) {
 	// Create step for testing
 	step := &Step{Run: "echo test"}
@@ -228,7 +251,7 @@ func TestStepSkipping(t *testing.T) {
 				// Set up stored hashes for all steps
 				hashStore = make(map[string]string)
 				for i, step := range job.Steps {
-					hash, _ := (&StepHasher{}).ComputeHash(step, tgt)
+					hash, _ := NewStepHasher().ComputeHash(step, tgt)
 					key := fmt.Sprintf("%s:%s:%d", tgt.GetName(), job.Name, i)
 					hashStore[key] = hash
 				}
@@ -242,7 +265,7 @@ func TestStepSkipping(t *testing.T) {
 				// Set up stored hashes for all steps
 				hashStore = make(map[string]string)
 				for i, step := range job.Steps {
-					hash, _ := (&StepHasher{}).ComputeHash(step, tgt)
+					hash, _ := NewStepHasher().ComputeHash(step, tgt)
 					key := fmt.Sprintf("%s:%s:%d", tgt.GetName(), job.Name, i)
 					hashStore[key] = hash
 				}
@@ -257,7 +280,7 @@ func TestStepSkipping(t *testing.T) {
 				hashStore = make(map[string]string)
 
 				// Step 0 has matching hash
-				hash, _ := (&StepHasher{}).ComputeHash(job.Steps[0], tgt)
+				hash, _ := NewStepHasher().ComputeHash(job.Steps[0], tgt)
 				key := fmt.Sprintf("%s:%s:%d", tgt.GetName(), job.Name, 0)
 				hashStore[key] = hash
 
@@ -266,7 +289,7 @@ func TestStepSkipping(t *testing.T) {
 				hashStore[key] = "different_hash"
 
 				// Step 2 has matching hash, but we expect it to run due to step 1 change
-				hash, _ = (&StepHasher{}).ComputeHash(job.Steps[2], tgt)
+				hash, _ = NewStepHasher().ComputeHash(job.Steps[2], tgt)
 				key = fmt.Sprintf("%s:%s:%d", tgt.GetName(), job.Name, 2)
 				hashStore[key] = hash
 			},
@@ -309,6 +332,58 @@ func TestStepSkipping(t *testing.T) {
 	}
 }
 
+func TestDownloadStepAlwaysRuns(t *testing.T) {
+	tgt := &target.Target{Name: "test-target", Host: "localhost", User: "user", Password: "password"}
+	download := &DownloadStep{Remote: "/var/log/app.log", Local: "app.log"}
+	job := &Job{
+		Name: "test-job",
+		Steps: []*Step{
+			{Run: "echo step1"},
+			{Download: download},
+			{Run: "echo step3"},
+		},
+	}
+
+	hashStore := make(map[int]string)
+	mockHashStorage := &MockHashStorage{
+		SaveHashFunc: func(_, _ string, stepIndex int, hash string) error {
+			hashStore[stepIndex] = hash
+			return nil
+		},
+		GetHashFunc: func(_, _ string, stepIndex int) (string, error) {
+			return hashStore[stepIndex], nil
+		},
+	}
+
+	for _, skipUnchanged := range []bool{false, true} {
+		download.SkipUnchanged = skipUnchanged
+		for i, step := range job.Steps {
+			hashStore[i], _ = NewStepHasher().ComputeHash(step, tgt)
+		}
+
+		var executed []int
+		mockClient := &MockClient{}
+		mockClient.On("ExecuteStep", mock.Anything, mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) {
+				executed = append(executed, args.Get(1).(int)-1)
+			}).
+			Return(nil)
+		mockClient.On("Close").Return()
+
+		mockClientFactory := &MockClientFactory{}
+		mockClientFactory.On("NewClient", mock.Anything).Return(mockClient, nil)
+
+		service := NewService(mockClientFactory, WithHashStorage(mockHashStorage), WithSkipUnchanged(true))
+		assert.NoError(t, service.ExecuteJob(tgt, job))
+
+		if skipUnchanged {
+			assert.Empty(t, executed, "unchanged download with skip_unchanged should be skipped")
+		} else {
+			assert.Equal(t, []int{1}, executed, "download should run without making later steps run")
+		}
+	}
+}
+
 func TestClearHashes(t *testing.T) {
 	mockClientFactory := &MockClientFactory{}
 
@@ -348,3 +423,625 @@ func TestClearHashes(t *testing.T) {
 		assert.Error(t, err, "Expected error but got nil")
 	})
 }
+
+// recordingClient records the commands of executed steps and fails the step running failingStep
+type recordingClient struct {
+	failingStep string
+	executed    []string
+}
+
+func (c *recordingClient) ExecuteStep(step *Step, _, _ int) error {
+	// The failure message of on_failure hooks is expanded like the shell of a target would
+	c.executed = append(c.executed, strings.ReplaceAll(step.Run, "${"+ErrorEnvVariable+"}", step.Env[ErrorEnvVariable]))
+	if step.Run == c.failingStep {
+		return errors.New(step.Run + " broke")
+	}
+	return nil
+}
+
+func (c *recordingClient) Close() {}
+
+func TestExecuteJobHooks(t *testing.T) {
+	tgt := &target.Target{Name: "test-target"}
+
+	tests := []struct {
+		name        string
+		failingStep string
+		expected    []string
+		expectErr   bool
+	}{
+		{
+			name:     "after_job hooks run on success",
+			expected: []string{"before", "step1", "step2", "after"},
+		},
+		{
+			name:        "on_failure hooks run when a step fails",
+			failingStep: "step1",
+			expected:    []string{"before", "step1", "notify: step1 broke"},
+			expectErr:   true,
+		},
+		{
+			name:        "on_failure hooks run when a before_job hook fails",
+			failingStep: "before",
+			expected:    []string{"before", "notify: before_job hook 1/1 failed: before broke"},
+			expectErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &Job{
+				Name:      "test-job",
+				Steps:     []*Step{{Run: "step1"}, {Run: "step2"}},
+				BeforeJob: []*Step{{Run: "before"}},
+				AfterJob:  []*Step{{Run: "after"}},
+				OnFailure: []*Step{{Run: "notify: ${error}"}},
+			}
+
+			client := &recordingClient{failingStep: tt.failingStep}
+			mockClientFactory := &MockClientFactory{}
+			mockClientFactory.On("NewClient", tgt).Return(client, nil)
+
+			err := NewService(mockClientFactory).ExecuteJob(tgt, job)
+
+			assert.Equal(t, tt.expectErr, err != nil)
+			assert.Equal(t, tt.expected, client.executed)
+			assert.Equal(t, "notify: ${error}", job.OnFailure[0].Run, "hook definitions should not be modified")
+		})
+	}
+}
+
+func TestFailureHooks(t *testing.T) {
+	hooks := []*Step{
+		{Run: `./notify.sh "failed: ${error}"`, Env: map[string]string{"CHANNEL": "ops"}},
+		{RunScript: &RunScriptStep{Path: "notify.sh", Args: []string{"--message", "${error}"}}},
+		{Exec: &ExecStep{Command: []string{"./notify.sh", "${error}"}}},
+	}
+	cause := errors.New(`"; rm -rf / #$(reboot)`)
+
+	result := failureHooks(hooks, cause, &target.Target{Name: "web"})
+	assert.Equal(t, `./notify.sh "failed: ${NSHIP_ERROR}"`, result[0].Run, "the message should not be inserted into shell code")
+	assert.Equal(t, map[string]string{"CHANNEL": "ops", ErrorEnvVariable: cause.Error()}, result[0].Env)
+	assert.Equal(t, []string{"--message", cause.Error()}, result[1].RunScript.Args)
+	assert.Equal(t, []string{"./notify.sh", cause.Error()}, result[2].Exec.Command)
+	assert.Equal(t, cause.Error(), result[2].Exec.Env[ErrorEnvVariable])
+
+	assert.Equal(t, map[string]string{"CHANNEL": "ops"}, hooks[0].Env, "hook definitions should not be modified")
+	assert.Equal(t, "${error}", hooks[1].RunScript.Args[1])
+	assert.Equal(t, "${error}", hooks[2].Exec.Command[1])
+
+	windows := failureHooks(hooks, cause, &target.Target{Name: "iis", Platform: target.PlatformWindows})
+	assert.Equal(t, `./notify.sh "failed: $env:NSHIP_ERROR"`, windows[0].Run)
+}
+
+func TestExecuteJobHooksAlwaysRun(t *testing.T) {
+	tgt := &target.Target{Name: "test-target"}
+	job := &Job{
+		Name:      "test-job",
+		Steps:     []*Step{{Run: "step1"}},
+		BeforeJob: []*Step{{Run: "before"}},
+		AfterJob:  []*Step{{Run: "after"}},
+	}
+
+	hash, _ := NewStepHasher().ComputeHash(job.Steps[0], tgt)
+	mockHashStorage := &MockHashStorage{
+		GetHashFunc: func(targetName, jobName string, stepIndex int) (string, error) {
+			return hash, nil
+		},
+	}
+
+	client := &recordingClient{}
+	mockClientFactory := &MockClientFactory{}
+	mockClientFactory.On("NewClient", tgt).Return(client, nil)
+
+	service := NewService(mockClientFactory, WithHashStorage(mockHashStorage), WithSkipUnchanged(true))
+	assert.NoError(t, service.ExecuteJob(tgt, job))
+	assert.Equal(t, []string{"before", "after"}, client.executed, "hooks should run even when all steps are unchanged")
+}
+
+func TestExecuteJobFailingOnFailureHook(t *testing.T) {
+	tgt := &target.Target{Name: "test-target"}
+	job := &Job{
+		Name:      "test-job",
+		Steps:     []*Step{{Run: "step1"}},
+		OnFailure: []*Step{{Run: "notify"}},
+	}
+
+	stepErr := errors.New("boom")
+	mockClient := &MockClient{}
+	mockClient.On("ExecuteStep", mock.Anything, mock.Anything, mock.Anything).Return(stepErr)
+	mockClient.On("Close").Return()
+
+	mockClientFactory := &MockClientFactory{}
+	mockClientFactory.On("NewClient", tgt).Return(mockClient, nil)
+
+	err := NewService(mockClientFactory).ExecuteJob(tgt, job)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "on_failure hook 1/1 failed")
+	assert.ErrorIs(t, err, stepErr, "the step error should be kept")
+}
+
+func TestExecuteJobPassesJobToExecSteps(t *testing.T) {
+	tgt := &target.Target{Name: "test-target"}
+	exec := &ExecStep{Command: []string{"./switch.sh"}}
+	job := &Job{
+		Name:      "blue-green",
+		Steps:     []*Step{{Exec: exec}},
+		BeforeJob: []*Step{{Exec: exec}},
+	}
+
+	var jobs []string
+	mockClient := &MockClient{}
+	mockClient.On("ExecuteStep", mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { jobs = append(jobs, args.Get(0).(*Step).Exec.Job) }).
+		Return(nil)
+	mockClient.On("Close").Return()
+
+	mockClientFactory := &MockClientFactory{}
+	mockClientFactory.On("NewClient", tgt).Return(mockClient, nil)
+
+	assert.NoError(t, NewService(mockClientFactory).ExecuteJob(tgt, job))
+	assert.Equal(t, []string{"blue-green", "blue-green"}, jobs)
+	assert.Empty(t, exec.Job, "step definitions should not be modified")
+}
+
+// cancellingClient is a ContextClient that cancels the deployment while running the step cancelStep
+type cancellingClient struct {
+	recordingClient
+	cancelStep string
+	cancel     context.CancelFunc
+}
+
+func (c *cancellingClient) ExecuteStepContext(ctx context.Context, step *Step, stepNum, totalSteps int) error {
+	if step.Run == c.cancelStep {
+		c.executed = append(c.executed, step.Run)
+		c.cancel()
+		return ctx.Err()
+	}
+	return c.ExecuteStep(step, stepNum, totalSteps)
+}
+
+func TestExecuteJobsCancelled(t *testing.T) {
+	for _, keepGoing := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(context.Background())
+		client := &cancellingClient{cancelStep: "deploy", cancel: cancel}
+		mockClientFactory := &MockClientFactory{}
+		mockClientFactory.On("NewClient", mock.Anything).Return(client, nil)
+
+		jobs := []*Job{
+			{Name: "app", Steps: []*Step{{Run: "deploy"}, {Run: "restart"}}, OnFailure: []*Step{{Run: "notify"}}},
+			{Name: "cleanup", Steps: []*Step{{Run: "prune"}}},
+		}
+		afterAll := &Job{Steps: []*Step{{Run: "maintenance off"}}}
+
+		service := NewService(mockClientFactory, WithKeepGoing(keepGoing))
+		err := service.ExecuteJobsWithHooksContext(ctx, []*target.Target{{Name: "web1"}, {Name: "web2"}}, jobs, nil, afterAll)
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, []string{"deploy", "notify", "maintenance off"}, client.executed,
+			"only the cleanup hooks should run after cancelling (keep going: %v)", keepGoing)
+	}
+}
+
+func TestExecuteStepCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	client := &recordingClient{}
+	err := executeStep(ctx, client, &Step{Run: "deploy"}, 1, 1)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, client.executed, "steps should not start once the deployment is cancelled")
+}
+
+// contextClientFactory is a ContextClientFactory failing to connect once its context is done
+type contextClientFactory struct {
+	MockClientFactory
+}
+
+func (f *contextClientFactory) NewClientContext(ctx context.Context, tgt *target.Target) (Client, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return f.NewClient(tgt)
+}
+
+func TestExecuteJobContextConnectCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	factory := &contextClientFactory{}
+	service := NewService(factory)
+	err := service.ExecuteJobContext(ctx, &target.Target{Name: "web1"}, &Job{Name: "app", Steps: []*Step{{Run: "deploy"}}})
+
+	assert.ErrorIs(t, err, context.Canceled, "connecting should get the context of the deployment")
+	factory.AssertNotCalled(t, "NewClient", mock.Anything)
+}
+
+func TestExecuteJobsWithHooks(t *testing.T) {
+	targets := []*target.Target{{Name: "web1"}, {Name: "web2"}}
+	beforeAll := &Job{Steps: []*Step{{Run: "maintenance on"}}}
+	afterAll := &Job{Steps: []*Step{{Run: "maintenance off"}}}
+	jobs := []*Job{
+		{Name: "deploy", Steps: []*Step{{Run: "deploy"}}},
+		{Name: "migrate", Steps: []*Step{{Run: "migrate"}}},
+	}
+
+	t.Run("wraps the jobs on each target", func(t *testing.T) {
+		client := &recordingClient{}
+		mockClientFactory := &MockClientFactory{}
+		mockClientFactory.On("NewClient", mock.Anything).Return(client, nil)
+
+		err := NewService(mockClientFactory).ExecuteJobsWithHooks(targets, jobs, beforeAll, afterAll)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			"maintenance on", "deploy", "migrate", "maintenance off",
+			"maintenance on", "deploy", "migrate", "maintenance off",
+		}, client.executed)
+	})
+
+	t.Run("after_all runs when a job fails", func(t *testing.T) {
+		client := &recordingClient{failingStep: "deploy"}
+		mockClientFactory := &MockClientFactory{}
+		mockClientFactory.On("NewClient", mock.Anything).Return(client, nil)
+
+		err := NewService(mockClientFactory).ExecuteJobsWithHooks(targets, jobs, beforeAll, afterAll)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to execute job deploy on target web1")
+		assert.Equal(t, []string{"maintenance on", "deploy", "maintenance off"}, client.executed)
+	})
+
+	t.Run("global hooks are never skipped", func(t *testing.T) {
+		mockHashStorage := &MockHashStorage{
+			GetHashFunc: func(targetName, jobName string, stepIndex int) (string, error) {
+				return "", errors.New("hash storage should not be used for global hooks")
+			},
+			SaveHashFunc: func(targetName, jobName string, stepIndex int, hash string) error {
+				return errors.New("hash storage should not be used for global hooks")
+			},
+		}
+
+		client := &recordingClient{}
+		mockClientFactory := &MockClientFactory{}
+		mockClientFactory.On("NewClient", mock.Anything).Return(client, nil)
+
+		service := NewService(mockClientFactory, WithHashStorage(mockHashStorage), WithSkipUnchanged(true))
+		err := service.ExecuteJobsWithHooks(targets[:1], nil, beforeAll, afterAll)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"maintenance on", "maintenance off"}, client.executed)
+	})
+}
+
+func TestExecuteJobsKeepGoing(t *testing.T) {
+	web1 := &target.Target{Name: "web1"}
+	web2 := &target.Target{Name: "web2"}
+	jobs := []*Job{
+		{Name: "deploy", Steps: []*Step{{Run: "deploy"}}},
+		{Name: "migrate", Steps: []*Step{{Run: "migrate"}}},
+	}
+
+	t.Run("remaining jobs and targets run after a failure", func(t *testing.T) {
+		client := &recordingClient{failingStep: "deploy"}
+		mockClientFactory := &MockClientFactory{}
+		mockClientFactory.On("NewClient", mock.Anything).Return(client, nil)
+
+		err := NewService(mockClientFactory, WithKeepGoing(true)).ExecuteJobs([]*target.Target{web1, web2}, jobs)
+
+		var deployErr *DeploymentError
+		assert.ErrorAs(t, err, &deployErr)
+		assert.Equal(t, 4, deployErr.Total)
+		assert.Equal(t, 2, deployErr.Failed)
+		assert.True(t, deployErr.Partial())
+		assert.Contains(t, err.Error(), "failed to execute job deploy on target web1")
+		assert.Contains(t, err.Error(), "failed to execute job deploy on target web2")
+		assert.Equal(t, []string{"deploy", "migrate", "deploy", "migrate"}, client.executed)
+	})
+
+	t.Run("jobs of an unreachable target fail", func(t *testing.T) {
+		client := &recordingClient{}
+		mockClientFactory := &MockClientFactory{}
+		mockClientFactory.On("NewClient", web1).Return(nil, &ConnectionError{Target: "web1", Cause: errors.New("refused")})
+		mockClientFactory.On("NewClient", web2).Return(client, nil)
+
+		err := NewService(mockClientFactory, WithKeepGoing(true)).ExecuteJobs([]*target.Target{web1, web2}, jobs)
+
+		var deployErr *DeploymentError
+		assert.ErrorAs(t, err, &deployErr)
+		assert.Equal(t, 2, deployErr.Failed)
+		assert.True(t, deployErr.Partial())
+		assert.True(t, IsConnectionError(err))
+		assert.Equal(t, []string{"deploy", "migrate"}, client.executed)
+	})
+
+	t.Run("all job runs failed", func(t *testing.T) {
+		client := &recordingClient{failingStep: "deploy"}
+		mockClientFactory := &MockClientFactory{}
+		mockClientFactory.On("NewClient", mock.Anything).Return(client, nil)
+
+		err := NewService(mockClientFactory, WithKeepGoing(true)).ExecuteJobs([]*target.Target{web1, web2}, jobs[:1])
+
+		var deployErr *DeploymentError
+		assert.ErrorAs(t, err, &deployErr)
+		assert.False(t, deployErr.Partial())
+	})
+
+	t.Run("success", func(t *testing.T) {
+		mockClientFactory := &MockClientFactory{}
+		mockClientFactory.On("NewClient", mock.Anything).Return(&recordingClient{}, nil)
+
+		err := NewService(mockClientFactory, WithKeepGoing(true)).ExecuteJobs([]*target.Target{web1, web2}, jobs)
+		assert.NoError(t, err)
+	})
+}
+
+// healthClient records the executed steps and fails the first unhealthy health check assertions
+type healthClient struct {
+	unhealthy int
+	executed  []string
+}
+
+func (c *healthClient) ExecuteStep(step *Step, _, _ int) error {
+	switch {
+	case step.Assert != nil:
+		c.executed = append(c.executed, "check "+step.Assert.Command)
+		if c.unhealthy > 0 {
+			c.unhealthy--
+			return errors.New("unhealthy")
+		}
+	case step.Wait != nil:
+		c.executed = append(c.executed, "wait "+step.Wait.Duration)
+	default:
+		c.executed = append(c.executed, step.Run)
+	}
+	return nil
+}
+
+func (c *healthClient) Close() {}
+
+func TestExecuteJobsRollingHealthCheck(t *testing.T) {
+	targets := []*target.Target{{Name: "web1"}, {Name: "web2"}}
+	rolling := func(check *HealthCheck) []*Job {
+		return []*Job{{
+			Name:        "deploy",
+			Steps:       []*Step{{Run: "deploy"}},
+			OnFailure:   []*Step{{Run: "rollback"}},
+			Strategy:    StrategyRolling,
+			HealthCheck: check,
+		}}
+	}
+
+	t.Run("next target waits until healthy", func(t *testing.T) {
+		client := &healthClient{unhealthy: 1}
+		mockClientFactory := &MockClientFactory{}
+		mockClientFactory.On("NewClient", mock.Anything).Return(client, nil)
+
+		err := NewService(mockClientFactory).ExecuteJobs(targets, rolling(&HealthCheck{Command: "curl -f localhost", Retries: 2}))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			"deploy", "check curl -f localhost", "wait 5s", "check curl -f localhost",
+			"deploy", "check curl -f localhost",
+		}, client.executed)
+	})
+
+	t.Run("unhealthy target stops the deployment", func(t *testing.T) {
+		client := &healthClient{unhealthy: 10}
+		mockClientFactory := &MockClientFactory{}
+		mockClientFactory.On("NewClient", mock.Anything).Return(client, nil)
+
+		err := NewService(mockClientFactory).ExecuteJobs(targets, rolling(&HealthCheck{Command: "true", Retries: 1, Interval: "1s"}))
+		assert.ErrorContains(t, err, "failed to execute job deploy on target web1: health check failed after 2 attempts: unhealthy")
+		assert.Equal(t, []string{"deploy", "check true", "wait 1s", "check true", "rollback"}, client.executed)
+	})
+
+	t.Run("without health check", func(t *testing.T) {
+		client := &healthClient{}
+		mockClientFactory := &MockClientFactory{}
+		mockClientFactory.On("NewClient", mock.Anything).Return(client, nil)
+
+		assert.NoError(t, NewService(mockClientFactory).ExecuteJobs(targets, rolling(nil)))
+		assert.Equal(t, []string{"deploy", "deploy"}, client.executed)
+	})
+}
+
+func TestExecuteJobsReusesConnection(t *testing.T) {
+	targets := []*target.Target{{Name: "web1"}, {Name: "web2"}}
+	jobs := []*Job{
+		{Name: "build", Steps: []*Step{{Run: "build"}}},
+		{Name: "deploy", Steps: []*Step{{Run: "deploy"}}},
+		{Name: "verify", Steps: []*Step{{Run: "verify"}}},
+	}
+
+	t.Run("one connection per target", func(t *testing.T) {
+		mockClient := &MockClient{}
+		mockClient.On("ExecuteStep", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockClient.On("Close").Return()
+
+		factory := &MockClientFactory{}
+		factory.On("NewClient", mock.Anything).Return(mockClient, nil)
+
+		assert.NoError(t, NewService(factory).ExecuteJobs(targets, jobs))
+		factory.AssertNumberOfCalls(t, "NewClient", 2)
+		mockClient.AssertNumberOfCalls(t, "ExecuteStep", 6)
+		mockClient.AssertNumberOfCalls(t, "Close", 2)
+	})
+
+	t.Run("connection is closed when a job fails", func(t *testing.T) {
+		mockClient := &MockClient{}
+		mockClient.On("ExecuteStep", mock.Anything, mock.Anything, mock.Anything).Return(errors.New("boom"))
+		mockClient.On("Close").Return()
+
+		factory := &MockClientFactory{}
+		factory.On("NewClient", mock.Anything).Return(mockClient, nil)
+
+		err := NewService(factory).ExecuteJobs(targets, jobs)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to execute job build on target web1")
+		factory.AssertNumberOfCalls(t, "NewClient", 1)
+		mockClient.AssertNumberOfCalls(t, "Close", 1)
+	})
+
+	t.Run("connection failure", func(t *testing.T) {
+		factory := &MockClientFactory{}
+		factory.On("NewClient", mock.Anything).Return(nil, &ConnectionError{Target: "web1", Cause: errors.New("refused")})
+
+		err := NewService(factory).ExecuteJobs(targets, jobs)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "connection to target web1 failed")
+		factory.AssertNumberOfCalls(t, "NewClient", 1)
+	})
+}
+
+func TestExecuteJobsRunOnce(t *testing.T) {
+	targets := []*target.Target{{Name: "web1"}, {Name: "web2"}, {Name: "web3"}}
+	jobs := []*Job{{Name: "deploy", Steps: []*Step{
+		{Run: "migrate", RunOnce: true},
+		{Run: "restart"},
+	}}}
+
+	t.Run("runs on the first target only", func(t *testing.T) {
+		client := &recordingClient{}
+		factory := &MockClientFactory{}
+		factory.On("NewClient", mock.Anything).Return(client, nil)
+
+		service := NewService(factory)
+		assert.NoError(t, service.ExecuteJobs(targets, jobs))
+		assert.Equal(t, []string{"migrate", "restart", "restart", "restart"}, client.executed)
+
+		client.executed = nil
+		assert.NoError(t, service.ExecuteJobs(targets, jobs))
+		assert.Equal(t, []string{"migrate", "restart", "restart", "restart"}, client.executed, "each run should execute it again")
+	})
+
+	t.Run("hash is stored under a synthetic target", func(t *testing.T) {
+		hashStore := make(map[string]string)
+		hashStorage := &MockHashStorage{
+			SaveHashFunc: func(targetName, jobName string, stepIndex int, hash string) error {
+				hashStore[fmt.Sprintf("%s:%s:%d", targetName, jobName, stepIndex)] = hash
+				return nil
+			},
+			GetHashFunc: func(targetName, jobName string, stepIndex int) (string, error) {
+				return hashStore[fmt.Sprintf("%s:%s:%d", targetName, jobName, stepIndex)], nil
+			},
+		}
+
+		client := &recordingClient{}
+		factory := &MockClientFactory{}
+		factory.On("NewClient", mock.Anything).Return(client, nil)
+
+		service := NewService(factory, WithHashStorage(hashStorage), WithSkipUnchanged(true))
+		assert.NoError(t, service.ExecuteJobs(targets, jobs))
+		assert.Contains(t, hashStore, runOnceTarget+":deploy:0")
+		assert.NotContains(t, hashStore, "web1:deploy:0")
+		assert.Contains(t, hashStore, "web2:deploy:1")
+
+		client.executed = nil
+		assert.NoError(t, service.ExecuteJobs(targets, jobs))
+		assert.Empty(t, client.executed, "unchanged run-once steps should be skipped on all targets")
+	})
+}
+
+func TestExecuteJobsWhen(t *testing.T) {
+	t.Setenv("NSHIP_TEST_DOCS", "1")
+	targets := []*target.Target{{Name: "staging"}, {Name: "prod"}}
+	jobs := []*Job{{
+		Name:      "deploy",
+		BeforeJob: []*Step{{Run: "notify", When: `target.name == "prod"`}},
+		Steps: []*Step{
+			{Run: "migrate", RunOnce: true, When: `target.name == "prod"`},
+			{Run: "docs", When: `env.NSHIP_TEST_DOCS && target.name != "prod"`},
+			{Run: "seed", When: `env.NSHIP_TEST_MISSING == "yes"`},
+			{Run: "restart"},
+		},
+	}}
+
+	t.Run("skips steps whose condition is false", func(t *testing.T) {
+		client := &recordingClient{}
+		factory := &MockClientFactory{}
+		factory.On("NewClient", mock.Anything).Return(client, nil)
+
+		service := NewService(factory)
+		assert.NoError(t, service.ExecuteJobs(targets, jobs))
+		assert.Equal(t, []string{"docs", "restart", "notify", "migrate", "restart"}, client.executed,
+			"a run-once step should run on the first target where its condition holds")
+	})
+
+	t.Run("skipped steps store no hash and don't make later steps run", func(t *testing.T) {
+		hashStore := make(map[string]string)
+		hashStorage := &MockHashStorage{
+			SaveHashFunc: func(targetName, jobName string, stepIndex int, hash string) error {
+				hashStore[fmt.Sprintf("%s:%s:%d", targetName, jobName, stepIndex)] = hash
+				return nil
+			},
+			GetHashFunc: func(targetName, jobName string, stepIndex int) (string, error) {
+				return hashStore[fmt.Sprintf("%s:%s:%d", targetName, jobName, stepIndex)], nil
+			},
+		}
+
+		client := &recordingClient{}
+		factory := &MockClientFactory{}
+		factory.On("NewClient", mock.Anything).Return(client, nil)
+
+		service := NewService(factory, WithHashStorage(hashStorage), WithSkipUnchanged(true))
+		assert.NoError(t, service.ExecuteJobs(targets, jobs))
+		assert.NotContains(t, hashStore, "staging:deploy:2")
+
+		client.executed = nil
+		assert.NoError(t, service.ExecuteJobs(targets, jobs))
+		assert.Equal(t, []string{"notify"}, client.executed)
+	})
+
+	t.Run("invalid condition", func(t *testing.T) {
+		factory := &MockClientFactory{}
+		factory.On("NewClient", mock.Anything).Return(&recordingClient{}, nil)
+
+		invalid := []*Job{{Name: "deploy", Steps: []*Step{{Run: "make"}, {Run: "make", When: `target.zone == "eu"`}}}}
+		err := NewService(factory).ExecuteJobs(targets, invalid)
+		assert.ErrorContains(t, err, "step 2: invalid when condition")
+	})
+}
+
+func TestExecuteJobsForceJobs(t *testing.T) {
+	hashStore := make(map[string]string)
+	hashStorage := &MockHashStorage{
+		SaveHashFunc: func(targetName, jobName string, stepIndex int, hash string) error {
+			hashStore[fmt.Sprintf("%s:%s:%d", targetName, jobName, stepIndex)] = hash
+			return nil
+		},
+		GetHashFunc: func(targetName, jobName string, stepIndex int) (string, error) {
+			return hashStore[fmt.Sprintf("%s:%s:%d", targetName, jobName, stepIndex)], nil
+		},
+	}
+	targets := []*target.Target{{Name: "web"}}
+	jobs := []*Job{
+		{Name: "build", Steps: []*Step{{Run: "make"}, {Run: "make test"}}},
+		{Name: "deploy", Steps: []*Step{{Run: "deploy ${matrix.region}"}}, Matrix: map[string][]string{"region": {"eu", "us"}}},
+		{Name: "notify", Steps: []*Step{{Run: "notify"}}},
+	}
+
+	client := &recordingClient{}
+	factory := &MockClientFactory{}
+	factory.On("NewClient", mock.Anything).Return(client, nil)
+	assert.NoError(t, NewService(factory, WithHashStorage(hashStorage), WithSkipUnchanged(true)).ExecuteJobs(targets, jobs))
+	assert.Len(t, client.executed, 5)
+
+	tests := []struct {
+		name  string
+		force []string
+		want  []string
+	}{
+		{"no job forced", nil, nil},
+		{"forced job", []string{"build"}, []string{"make", "make test"}},
+		{"forced matrix job", []string{"deploy"}, []string{"deploy eu", "deploy us"}},
+		{"forced matrix instance", []string{"deploy[region=us]"}, []string{"deploy us"}},
+		{"glob", []string{"b*", "no*"}, []string{"make", "make test", "notify"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client.executed = nil
+			service := NewService(factory, WithHashStorage(hashStorage), WithSkipUnchanged(true), WithForceJobs(tt.force...))
+			assert.NoError(t, service.ExecuteJobs(targets, jobs))
+			assert.Equal(t, tt.want, client.executed)
+		})
+	}
+}
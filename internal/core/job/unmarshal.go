@@ -0,0 +1,84 @@
+package job
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// plainStep has the fields of Step without its unmarshalers
+type plainStep Step
+
+// JoinRunCommands joins the commands of a run step given as a list into one script. Like other multi-line
+// commands, it stops at the first failing command unless the step disables FailFast.
+func JoinRunCommands(commands []string) string {
+	return strings.Join(commands, "\n")
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, accepting run as a single command or a list of commands
+func (s *Step) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var fields yaml.MapSlice
+	if err := unmarshal(&fields); err != nil {
+		return err
+	}
+
+	for i, field := range fields {
+		list, ok := field.Value.([]interface{})
+		if field.Key != "run" || !ok {
+			continue
+		}
+
+		commands, err := runCommands(list)
+		if err != nil {
+			return err
+		}
+		fields[i].Value = JoinRunCommands(commands)
+
+		data, err := yaml.Marshal(fields)
+		if err != nil {
+			return err
+		}
+		return yaml.Unmarshal(data, (*plainStep)(s))
+	}
+
+	return unmarshal((*plainStep)(s))
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting run as a single command or a list of commands
+func (s *Step) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+
+	if run, ok := fields["run"]; ok && bytes.HasPrefix(bytes.TrimSpace(run), []byte("[")) {
+		var commands []string
+		if err := json.Unmarshal(run, &commands); err != nil {
+			return fmt.Errorf("run must be a command or a list of commands: %w", err)
+		}
+		fields["run"], _ = json.Marshal(JoinRunCommands(commands))
+
+		var err error
+		if data, err = json.Marshal(fields); err != nil {
+			return err
+		}
+	}
+
+	return json.Unmarshal(data, (*plainStep)(s))
+}
+
+// runCommands returns the commands of a run step given as a YAML list
+func runCommands(list []interface{}) ([]string, error) {
+	commands := make([]string, 0, len(list))
+	for _, item := range list {
+		command, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("run must be a command or a list of commands, got list item %v", item)
+		}
+		commands = append(commands, command)
+	}
+	return commands, nil
+}
@@ -0,0 +1,31 @@
+package job
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestUnmarshalRunList(t *testing.T) {
+	var fromYAML Step
+	require.NoError(t, yaml.Unmarshal([]byte("run:\n  - make\n  - make install\nshell: bash\n"), &fromYAML))
+	assert.Equal(t, "make\nmake install", fromYAML.Run)
+	assert.Equal(t, "bash", fromYAML.Shell)
+
+	var fromJSON Step
+	require.NoError(t, json.Unmarshal([]byte(`{"run": ["make", "make install"], "shell": "bash"}`), &fromJSON))
+	assert.Equal(t, fromYAML, fromJSON)
+
+	var single Step
+	require.NoError(t, yaml.Unmarshal([]byte("run: make && make install\n"), &single))
+	assert.Equal(t, "make && make install", single.Run)
+
+	listData, _ := json.Marshal(fromYAML)
+	singleData, _ := json.Marshal(single)
+	assert.NotEqual(t, string(listData), string(singleData), "the list form should be hashed as its own command")
+
+	assert.Error(t, json.Unmarshal([]byte(`{"run": ["make", 1]}`), &fromJSON))
+}
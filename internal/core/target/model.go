@@ -3,12 +3,68 @@ package target
 
 // Target defines a deployment destination with connection details.
 type Target struct {
-	Name       string `yaml:"name" json:"name" toml:"name" validate:"omitempty"`
-	Host       string `yaml:"host" json:"host" toml:"host" validate:"required,hostname|ip"`
-	User       string `yaml:"user" json:"user" toml:"user" validate:"required"`
-	Password   string `yaml:"password" json:"password" toml:"password" validate:"required_without=PrivateKey"`
-	PrivateKey string `yaml:"private_key,omitempty" json:"private_key,omitempty" toml:"private_key,omitempty" validate:"required_without=Password,omitempty,file"` //nolint:lll // long struct tag needed for complete configuration
-	Port       int    `yaml:"port,omitempty" json:"port,omitempty" toml:"port,omitempty" validate:"omitempty,min=1,max=65535"`
+	Name       string `yaml:"name" json:"name" toml:"name" hcl:"name,optional" validate:"omitempty"`
+	Host       string `yaml:"host" json:"host" toml:"host" hcl:"host,optional" validate:"required_unless=Local true,omitempty,hostname|ip"` //nolint:lll // long struct tag needed for complete configuration
+	User       string `yaml:"user" json:"user" toml:"user" hcl:"user,optional" validate:"required_unless=Local true"`
+	Password   string `yaml:"password" json:"password" toml:"password" hcl:"password,optional" validate:"omitempty"`
+	PrivateKey string `yaml:"private_key,omitempty" json:"private_key,omitempty" toml:"private_key,omitempty" hcl:"private_key,optional" validate:"omitempty,file"` //nolint:lll // long struct tag needed for complete configuration
+	Port       int    `yaml:"port,omitempty" json:"port,omitempty" toml:"port,omitempty" hcl:"port,optional" validate:"omitempty,min=1,max=65535"`                  //nolint:lll // long struct tag needed for complete configuration
+	// Local runs the steps on the machine running nship instead of connecting over SSH. Host defaults
+	// to localhost, and user, password and private key are not needed.
+	Local bool `yaml:"local,omitempty" json:"local,omitempty" toml:"local,omitempty" hcl:"local,optional" validate:"omitempty"`
+	// MaxSessions limits the SSH sessions open at the same time on one connection
+	MaxSessions int `yaml:"max_sessions,omitempty" json:"max_sessions,omitempty" toml:"max_sessions,omitempty" hcl:"max_sessions,optional" validate:"omitempty,min=2"` //nolint:lll // long struct tag needed for complete configuration
+	// Shell is the default shell for steps that do not set their own
+	Shell string `yaml:"shell,omitempty" json:"shell,omitempty" toml:"shell,omitempty" hcl:"shell,optional" validate:"omitempty"`
+	// LoginShell runs the commands of steps in a login shell, which reads the profile scripts of the user
+	LoginShell bool `yaml:"login_shell,omitempty" json:"login_shell,omitempty" toml:"login_shell,omitempty" hcl:"login_shell,optional" validate:"omitempty"` //nolint:lll // long struct tag needed for complete configuration
+	// Env sets environment variables for all commands run on the target. They are passed with the SSH session
+	// where the server accepts them and set by the commands otherwise.
+	Env map[string]string `yaml:"env,omitempty" json:"env,omitempty" toml:"env,omitempty" hcl:"env,optional" validate:"omitempty,dive,keys,env_name,endkeys"` //nolint:lll // long struct tag needed for complete configuration
+	// TransferMethod selects how files are transferred: sftp, scp, or auto (the default), which falls back
+	// to scp when the server has no SFTP subsystem
+	TransferMethod string `yaml:"transfer_method,omitempty" json:"transfer_method,omitempty" toml:"transfer_method,omitempty" hcl:"transfer_method,optional" validate:"omitempty,oneof=sftp scp auto"` //nolint:lll // long struct tag needed for complete configuration
+	// Platform is the operating system of the target, unix (the default) or windows. Steps on Windows targets run with PowerShell.
+	Platform string `yaml:"platform,omitempty" json:"platform,omitempty" toml:"platform,omitempty" hcl:"platform,optional" validate:"omitempty,oneof=unix windows"` //nolint:lll // long struct tag needed for complete configuration
+	// ProxyCommand is a command whose standard input and output are used as the connection to the target instead
+	// of dialing it directly, e.g. "cloudflared access ssh --hostname %h". %h and %p are replaced by host and port.
+	ProxyCommand string `yaml:"proxy_command,omitempty" json:"proxy_command,omitempty" toml:"proxy_command,omitempty" hcl:"proxy_command,optional" validate:"omitempty"` //nolint:lll // long struct tag needed for complete configuration
+	// Ciphers restricts the ciphers offered in the SSH handshake, in order of preference
+	Ciphers []string `yaml:"ciphers,omitempty" json:"ciphers,omitempty" toml:"ciphers,omitempty" hcl:"ciphers,optional" validate:"omitempty,dive,ssh_cipher"` //nolint:lll // long struct tag needed for complete configuration
+	// MACs restricts the message authentication codes offered in the SSH handshake, in order of preference
+	MACs []string `yaml:"macs,omitempty" json:"macs,omitempty" toml:"macs,omitempty" hcl:"macs,optional" validate:"omitempty,dive,ssh_mac"` //nolint:lll // long struct tag needed for complete configuration
+	// KexAlgorithms restricts the key exchange algorithms offered in the SSH handshake, in order of preference
+	KexAlgorithms []string `yaml:"kex_algorithms,omitempty" json:"kex_algorithms,omitempty" toml:"kex_algorithms,omitempty" hcl:"kex_algorithms,optional" validate:"omitempty,dive,ssh_kex"` //nolint:lll // long struct tag needed for complete configuration
+	// HostKeyAlgorithms restricts the host key algorithms accepted in the SSH handshake, in order of preference
+	HostKeyAlgorithms []string `yaml:"host_key_algorithms,omitempty" json:"host_key_algorithms,omitempty" toml:"host_key_algorithms,omitempty" hcl:"host_key_algorithms,optional" validate:"omitempty,dive,ssh_host_key"` //nolint:lll // long struct tag needed for complete configuration
+	// RequireConfirm makes nship ask for confirmation before running jobs on the target
+	RequireConfirm bool `yaml:"require_confirm,omitempty" json:"require_confirm,omitempty" toml:"require_confirm,omitempty" hcl:"require_confirm,optional" validate:"omitempty"` //nolint:lll // long struct tag needed for complete configuration
+}
+
+// Supported target platforms
+const (
+	PlatformUnix    = "unix"
+	PlatformWindows = "windows"
+)
+
+// IsWindows reports whether the target runs Windows
+func (t *Target) IsWindows() bool {
+	return t.Platform == PlatformWindows
+}
+
+// Supported file transfer methods
+const (
+	TransferSFTP = "sftp"
+	TransferSCP  = "scp"
+	TransferAuto = "auto"
+)
+
+// GetTransferMethod returns the file transfer method, defaulting to auto if not specified.
+func (t *Target) GetTransferMethod() string {
+	if t.TransferMethod == "" {
+		return TransferAuto
+	}
+	return t.TransferMethod
 }
 
 // GetPort returns the SSH port to use, defaulting to 22 if not specified.
@@ -19,6 +75,17 @@ func (t *Target) GetPort() int {
 	return t.Port
 }
 
+// DefaultMaxSessions matches the default MaxSessions of OpenSSH servers
+const DefaultMaxSessions = 10
+
+// GetMaxSessions returns the maximum number of SSH sessions per connection, defaulting to DefaultMaxSessions.
+func (t *Target) GetMaxSessions() int {
+	if t.MaxSessions == 0 {
+		return DefaultMaxSessions
+	}
+	return t.MaxSessions
+}
+
 // GetName returns the target name, defaulting to host if not specified.
 func (t *Target) GetName() string {
 	if t.Name == "" {
@@ -87,3 +87,8 @@ func TestGetName(t *testing.T) {
 		})
 	}
 }
+
+func TestGetMaxSessions(t *testing.T) {
+	assert.Equal(t, DefaultMaxSessions, (&Target{}).GetMaxSessions(), "should default to the OpenSSH limit")
+	assert.Equal(t, 4, (&Target{MaxSessions: 4}).GetMaxSessions())
+}
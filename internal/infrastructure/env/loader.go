@@ -2,35 +2,90 @@
 package env
 
 import (
-	"bufio"
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
-	"syscall"
 
 	"github.com/joho/godotenv"
 	"github.com/nickalie/nship/internal/config"
-	"golang.org/x/term"
+	"github.com/nickalie/nship/internal/util"
+	"gopkg.in/yaml.v2"
 )
 
 // Loader defines the interface for loading environment variables.
 type Loader interface {
 	Load(path, vaultPassword string) error
+	SetVariables(values map[string]string) error
+	IsSet(name string) bool
 }
 
 // DefaultLoader implements the Loader interface using godotenv.
 type DefaultLoader struct {
-	vaultDecrypter config.VaultDecrypter
+	vaultDecrypter    config.VaultDecrypter
+	sopsDecrypter     config.SOPSDecrypter
+	assumeYes         bool
+	vaultPasswordFile string
+	secrets           *util.SecretRegistry
+	override          bool
+	// set holds the names of the environment variables set by the loader
+	set map[string]bool
+}
+
+// LoaderOption represents an option for configuring a DefaultLoader
+type LoaderOption func(*DefaultLoader)
+
+// WithAssumeYes disables interactive prompts; a missing vault password becomes an error.
+func WithAssumeYes(assumeYes bool) LoaderOption {
+	return func(l *DefaultLoader) {
+		l.assumeYes = assumeYes
+	}
+}
+
+// WithVaultPasswordFile reads the vault password from the file at path when no password is given explicitly.
+func WithVaultPasswordFile(path string) LoaderOption {
+	return func(l *DefaultLoader) {
+		l.vaultPasswordFile = path
+	}
+}
+
+// WithOverride makes the variables of environment files override those inherited from the parent process,
+// which are kept by default
+func WithOverride(override bool) LoaderOption {
+	return func(l *DefaultLoader) {
+		l.override = override
+	}
+}
+
+// WithSecrets registers the values loaded from encrypted files in secrets, so that they are masked in command output
+func WithSecrets(secrets *util.SecretRegistry) LoaderOption {
+	return func(l *DefaultLoader) {
+		l.secrets = secrets
+	}
 }
 
 // NewLoader creates a new environment loader with default implementations.
-func NewLoader() Loader {
-	return &DefaultLoader{
+func NewLoader(opts ...LoaderOption) Loader {
+	loader := &DefaultLoader{
 		vaultDecrypter: config.NewVaultDecrypter(),
+		sopsDecrypter:  config.NewSOPSDecrypter(),
+		secrets:        util.NewSecretRegistry(),
+		set:            make(map[string]bool),
 	}
+
+	for _, opt := range opts {
+		opt(loader)
+	}
+
+	return loader
 }
 
-// Load loads environment variables from a file.
+// Load loads environment variables from a file. They override the variables of files loaded before, so of
+// several files the last one takes precedence, but not those inherited from the parent process unless the
+// loader is created with WithOverride.
 func (l *DefaultLoader) Load(path, vaultPassword string) error {
 	if path == "" {
 		return nil
@@ -40,13 +95,32 @@ func (l *DefaultLoader) Load(path, vaultPassword string) error {
 		return l.loadVaultFile(path, vaultPassword)
 	}
 
-	return godotenv.Load(path)
+	if isSOPSFile(path) {
+		return l.loadSOPSFile(path)
+	}
+
+	values, err := godotenv.Read(path)
+	if err != nil {
+		return err
+	}
+	return l.setVariables(values)
+}
+
+// SplitVaultPassword splits an env file entry of the form password@path into its
+// password and path. Entries without a password, or whose path is not a vault file,
+// are returned unchanged with an empty password.
+func SplitVaultPassword(entry string) (path, password string) {
+	i := strings.LastIndex(entry, "@")
+	if i <= 0 || !strings.HasSuffix(entry, ".vault") {
+		return entry, ""
+	}
+	return entry[i+1:], entry[:i]
 }
 
 // loadVaultFile loads environment variables from an Ansible Vault encrypted file.
 func (l *DefaultLoader) loadVaultFile(path, password string) error {
 	// Handle password resolution
-	password, err := resolveVaultPassword(password, path)
+	password, err := l.resolveVaultPassword(password, path)
 	if err != nil {
 		return err
 	}
@@ -58,20 +132,60 @@ func (l *DefaultLoader) loadVaultFile(path, password string) error {
 	}
 
 	// Parse environment variables
-	return setEnvironmentVariables(decrypted)
+	return l.setEnvironmentVariables(decrypted)
+}
+
+// isSOPSFile reports whether path names a SOPS encrypted file such as secrets.sops.env
+func isSOPSFile(path string) bool {
+	ext := filepath.Ext(path)
+	return config.SOPSFormat(path) != "" && strings.HasSuffix(strings.TrimSuffix(path, ext), ".sops")
+}
+
+// loadSOPSFile loads environment variables from a SOPS encrypted dotenv, YAML or JSON file.
+// Their values are secret and masked in command output.
+func (l *DefaultLoader) loadSOPSFile(path string) error {
+	decrypted, err := config.LoadSOPSFile(path, l.sopsDecrypter)
+	if err != nil {
+		return err
+	}
+
+	if config.SOPSFormat(path) == "dotenv" {
+		return l.setEnvironmentVariables(string(decrypted))
+	}
+
+	// YAML is a superset of JSON, so both are parsed the same way
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(decrypted, &values); err != nil {
+		return fmt.Errorf("environment unmarshaling failed: %w", err)
+	}
+
+	envMap := make(map[string]string, len(values))
+	for k, v := range values {
+		envMap[k] = fmt.Sprint(v)
+	}
+	return l.setSecretVariables(envMap)
 }
 
-// resolveVaultPassword determines the password to use for decryption
-func resolveVaultPassword(password, vaultPath string) (string, error) {
+// resolveVaultPassword determines the password to use for decryption: an explicit password,
+// then the password file, then VAULT_PASSWORD. The user is only prompted when assumeYes is not set.
+func (l *DefaultLoader) resolveVaultPassword(password, vaultPath string) (string, error) {
 	if password != "" {
 		return password, nil
 	}
 
+	if l.vaultPasswordFile != "" {
+		return readPasswordFile(l.vaultPasswordFile)
+	}
+
 	// Check environment variable
 	if envPwd := os.Getenv("VAULT_PASSWORD"); envPwd != "" {
 		return envPwd, nil
 	}
 
+	if l.assumeYes {
+		return "", fmt.Errorf("vault password required for %s: use --vault-password or VAULT_PASSWORD", vaultPath)
+	}
+
 	// Prompt user for password
 	promptedPwd, err := promptVaultPassword(vaultPath)
 	if err != nil {
@@ -80,39 +194,87 @@ func resolveVaultPassword(password, vaultPath string) (string, error) {
 	return promptedPwd, nil
 }
 
-// setEnvironmentVariables parses and sets environment variables from decrypted content
-func setEnvironmentVariables(decrypted string) error {
+// readPasswordFile reads a vault password from a file, warning when other users can read it
+func readPasswordFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read vault password file: %w", err)
+	}
+
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o004 != 0 {
+		fmt.Printf("Warning: vault password file %s is world-readable\n", path)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read vault password file: %w", err)
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+// setEnvironmentVariables parses and sets environment variables from decrypted content.
+// Their values are secret and masked in command output.
+func (l *DefaultLoader) setEnvironmentVariables(decrypted string) error {
 	envMap, err := godotenv.Unmarshal(decrypted)
 	if err != nil {
 		return fmt.Errorf("environment unmarshaling failed: %w", err)
 	}
 
-	for k, v := range envMap {
-		if err := os.Setenv(k, v); err != nil {
-			return fmt.Errorf("failed to set environment variable %s: %w", k, err)
-		}
+	return l.setSecretVariables(envMap)
+}
+
+// setSecretVariables sets environment variables like setVariables and masks their values in command output
+func (l *DefaultLoader) setSecretVariables(values map[string]string) error {
+	for _, v := range values {
+		l.secrets.Add(v)
 	}
+	return l.setVariables(values)
+}
 
-	return nil
+// setVariables sets the variables of an environment file with SetVariables, skipping those inherited
+// from the parent process unless override is set
+func (l *DefaultLoader) setVariables(values map[string]string) error {
+	if !l.override {
+		values = maps.Clone(values)
+		maps.DeleteFunc(values, func(name, _ string) bool { return l.inherited(name) })
+	}
+	return l.SetVariables(values)
 }
 
-// promptVaultPassword prompts the user for a vault password for the specified vault file.
-func promptVaultPassword(vaultPath string) (string, error) {
-	fmt.Printf("Enter vault password for %s: ", vaultPath)
+// inherited reports whether the environment variable name was inherited from the parent process
+// rather than set by the loader, even if to an empty value
+func (l *DefaultLoader) inherited(name string) bool {
+	_, ok := os.LookupEnv(name)
+	return ok && !l.IsSet(name)
+}
 
-	password, err := term.ReadPassword(int(syscall.Stdin)) //nolint:unconvert //int is required for Windows compatibility
-	if err == nil {
-		fmt.Println() // Add newline after password input
-		return string(password), nil
+// SetVariables sets environment variables, overriding existing values. They are set in the order
+// of their names, so the result never depends on map iteration order.
+func (l *DefaultLoader) SetVariables(values map[string]string) error {
+	if l.set == nil {
+		l.set = make(map[string]bool)
 	}
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		if err := os.Setenv(key, values[key]); err != nil {
+			return fmt.Errorf("failed to set environment variable %s: %w", key, err)
+		}
+		l.set[key] = true
+	}
+	return nil
+}
+
+// IsSet reports whether the environment variable name was set by the loader, i.e. loaded from an
+// environment file or set with SetVariables, rather than inherited from the parent process
+func (l *DefaultLoader) IsSet(name string) bool {
+	return l.set[name]
+}
 
-	// Final fallback: Regular input (with warning)
-	fmt.Println("\nWarning: Unable to hide password input. Password will be visible.")
-	reader := bufio.NewReader(os.Stdin)
-	passwordBytes, err := reader.ReadBytes('\n')
+// promptVaultPassword prompts the user for a vault password for the specified vault file.
+func promptVaultPassword(vaultPath string) (string, error) {
+	password, err := util.PromptSecret(fmt.Sprintf("Enter vault password for %s: ", vaultPath))
 	if err != nil {
 		return "", fmt.Errorf("failed to read password: %w", err)
 	}
-
-	return strings.TrimSpace(string(passwordBytes)), nil
+	return password, nil
 }
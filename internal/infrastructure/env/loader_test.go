@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/nickalie/nship/internal/util"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -257,7 +258,8 @@ func TestSetEnvironmentVariablesInvalidContent(t *testing.T) {
 	// Try to set environment variables from invalid content
 	// Use a malformed string that should cause godotenv.Unmarshal to fail
 	// The godotenv library requires a specific format and will fail on certain invalid inputs
-	err := setEnvironmentVariables("===INVALID===CONTENT===")
+	loader := &DefaultLoader{}
+	err := loader.setEnvironmentVariables("===INVALID===CONTENT===")
 
 	assert.Error(t, err, "Expected error when setting environment variables from invalid content")
 }
@@ -269,13 +271,16 @@ func TestSetEnvironmentVariablesMultipleVars(t *testing.T) {
 	os.Unsetenv("VAR3")
 
 	// Set multiple environment variables
-	err := setEnvironmentVariables("VAR1=value1\nVAR2=value2\nVAR3=value3")
+	secrets := util.NewSecretRegistry()
+	loader := NewLoader(WithSecrets(secrets)).(*DefaultLoader)
+	err := loader.setEnvironmentVariables("VAR1=value1\nVAR2=value2\nVAR3=value3")
 	assert.NoError(t, err, "Expected no error setting multiple environment variables")
 
 	// Check that all variables were set
 	assert.Equal(t, "value1", os.Getenv("VAR1"), "VAR1 was not set correctly")
 	assert.Equal(t, "value2", os.Getenv("VAR2"), "VAR2 was not set correctly")
 	assert.Equal(t, "value3", os.Getenv("VAR3"), "VAR3 was not set correctly")
+	assert.Equal(t, "*** ***", secrets.Redact("value1 value3"), "Decrypted values should be registered as secrets")
 }
 
 func TestResolveVaultPasswordPrecedence(t *testing.T) {
@@ -286,18 +291,65 @@ func TestResolveVaultPasswordPrecedence(t *testing.T) {
 	defer os.Unsetenv("VAULT_PASSWORD")
 
 	// Direct password should take precedence
-	password, err := resolveVaultPassword("direct-password", "test-vault.vault")
+	password, err := (&DefaultLoader{}).resolveVaultPassword("direct-password", "test-vault.vault")
 	assert.NoError(t, err, "Unexpected error resolving password")
 	assert.Equal(t, "direct-password", password, "Direct password should be used")
 
 	// Environment variable should be used if no direct password
-	password, err = resolveVaultPassword("", "test-vault.vault")
+	password, err = (&DefaultLoader{}).resolveVaultPassword("", "test-vault.vault")
 	assert.NoError(t, err, "Unexpected error resolving password")
 	assert.Equal(t, "env-password", password, "Environment variable password should be used")
 
 	// Skip testing prompt since it requires stdin interaction
 }
 
+func TestResolveVaultPasswordFilePrecedence(t *testing.T) {
+	tempDir, cleanup := setupTest(t)
+	defer cleanup()
+
+	passwordFile := filepath.Join(tempDir, "vault-pass")
+	require.NoError(t, os.WriteFile(passwordFile, []byte("file-password\n"), 0600))
+
+	t.Setenv("VAULT_PASSWORD", "env-password")
+	loader := &DefaultLoader{vaultPasswordFile: passwordFile}
+
+	// Explicit password takes precedence over the password file
+	password, err := loader.resolveVaultPassword("direct-password", "test-vault.vault")
+	assert.NoError(t, err)
+	assert.Equal(t, "direct-password", password)
+
+	// Password file takes precedence over VAULT_PASSWORD and is trimmed
+	password, err = loader.resolveVaultPassword("", "test-vault.vault")
+	assert.NoError(t, err)
+	assert.Equal(t, "file-password", password)
+
+	// A missing password file is an error rather than a fallback
+	loader.vaultPasswordFile = filepath.Join(tempDir, "missing")
+	_, err = loader.resolveVaultPassword("", "test-vault.vault")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read vault password file")
+}
+
+func TestResolveVaultPasswordAssumeYes(t *testing.T) {
+	os.Unsetenv("VAULT_PASSWORD")
+
+	// A missing password must fail instead of prompting
+	loader := &DefaultLoader{assumeYes: true}
+	_, err := loader.resolveVaultPassword("", "test-vault.vault")
+	assert.Error(t, err, "Expected error when no password is available and prompts are disabled")
+	assert.Contains(t, err.Error(), "vault password required for test-vault.vault")
+
+	password, err := loader.resolveVaultPassword("direct-password", "test-vault.vault")
+	assert.NoError(t, err, "Unexpected error resolving password")
+	assert.Equal(t, "direct-password", password, "Direct password should be used")
+}
+
+func TestNewLoaderWithAssumeYes(t *testing.T) {
+	loader, ok := NewLoader(WithAssumeYes(true)).(*DefaultLoader)
+	assert.True(t, ok, "NewLoader should return a *DefaultLoader")
+	assert.True(t, loader.assumeYes, "assumeYes should be set")
+}
+
 // TestMockVaultDecrypter tests the mock implementation to ensure it behaves as expected
 func TestMockVaultDecrypter(t *testing.T) {
 	mockDecrypter := &MockVaultDecrypter{
@@ -326,7 +378,8 @@ func TestSetEnvironmentVariablesCustomError(t *testing.T) {
 	// invalid environment variable names or values.
 
 	// Try with empty content - should not error
-	err := setEnvironmentVariables("")
+	loader := &DefaultLoader{}
+	err := loader.setEnvironmentVariables("")
 	assert.NoError(t, err, "Expected no error for empty content")
 }
 
@@ -365,3 +418,140 @@ func TestLoadWithCustomDecrypter(t *testing.T) {
 	assert.NoError(t, err, "Expected no error loading vault file with custom decrypter")
 	assert.Equal(t, "custom_value", os.Getenv("CUSTOM_KEY"), "Environment variable from vault with custom decrypter was not set correctly")
 }
+
+func TestSplitVaultPassword(t *testing.T) {
+	tests := []struct {
+		entry        string
+		wantPath     string
+		wantPassword string
+	}{
+		{entry: "secrets.vault", wantPath: "secrets.vault"},
+		{entry: "secret@secrets.vault", wantPath: "secrets.vault", wantPassword: "secret"},
+		{entry: "p@ss@secrets.vault", wantPath: "secrets.vault", wantPassword: "p@ss"},
+		{entry: "@secrets.vault", wantPath: "@secrets.vault"},
+		{entry: "user@host/prod.env", wantPath: "user@host/prod.env"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			path, password := SplitVaultPassword(tt.entry)
+			assert.Equal(t, tt.wantPath, path)
+			assert.Equal(t, tt.wantPassword, password)
+		})
+	}
+}
+
+// MockSOPSDecrypter implements the config.SOPSDecrypter interface for testing
+type MockSOPSDecrypter struct {
+	decryptFunc func(data []byte, format string) ([]byte, error)
+}
+
+// Decrypt calls the mock function
+func (m *MockSOPSDecrypter) Decrypt(data []byte, format string) ([]byte, error) {
+	return m.decryptFunc(data, format)
+}
+
+func TestLoadSOPSFiles(t *testing.T) {
+	tempDir, cleanup := setupTest(t)
+	defer cleanup()
+
+	tests := []struct {
+		name      string
+		file      string
+		content   string
+		decrypted string
+	}{
+		{
+			name:      "dotenv",
+			file:      "secrets.sops.env",
+			content:   "SOPS_DOTENV_KEY=ENC[...]\nsops_version=3.10.2\n",
+			decrypted: "SOPS_DOTENV_KEY=dotenv_value\n",
+		},
+		{
+			name:      "yaml",
+			file:      "secrets.sops.yaml",
+			content:   "SOPS_YAML_KEY: ENC[...]\nsops:\n  version: 3.10.2\n",
+			decrypted: "SOPS_YAML_KEY: yaml_value\n",
+		},
+		{
+			name:      "json",
+			file:      "secrets.sops.json",
+			content:   `{"SOPS_JSON_KEY": "ENC[...]", "sops": {"version": "3.10.2"}}`,
+			decrypted: `{"SOPS_JSON_KEY": "json_value"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempDir, tt.file)
+			require.NoError(t, os.WriteFile(path, []byte(tt.content), 0600))
+
+			loader := &DefaultLoader{
+				sopsDecrypter: &MockSOPSDecrypter{
+					decryptFunc: func(data []byte, format string) ([]byte, error) {
+						assert.Equal(t, tt.content, string(data), "Content mismatch")
+						return []byte(tt.decrypted), nil
+					},
+				},
+			}
+
+			require.NoError(t, loader.Load(path, ""))
+		})
+	}
+
+	assert.Equal(t, "dotenv_value", os.Getenv("SOPS_DOTENV_KEY"))
+	assert.Equal(t, "yaml_value", os.Getenv("SOPS_YAML_KEY"))
+	assert.Equal(t, "json_value", os.Getenv("SOPS_JSON_KEY"))
+}
+
+func TestIsSOPSFile(t *testing.T) {
+	assert.True(t, isSOPSFile("secrets.sops.env"))
+	assert.True(t, isSOPSFile("config/secrets.sops.yaml"))
+	assert.False(t, isSOPSFile("secrets.env"))
+	assert.False(t, isSOPSFile("sops.yaml"))
+	assert.False(t, isSOPSFile("secrets.sops.toml"))
+}
+
+func TestLoadLaterFilesOverrideEarlierOnes(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.env")
+	prod := filepath.Join(dir, "prod.env")
+	require.NoError(t, os.WriteFile(base, []byte("NSHIP_TEST_HOST=base.example.com\nNSHIP_TEST_USER=deploy\n"), 0600))
+	require.NoError(t, os.WriteFile(prod, []byte("NSHIP_TEST_HOST=prod.example.com\n"), 0600))
+
+	t.Setenv("NSHIP_TEST_USER", "inherited")
+	t.Setenv("NSHIP_TEST_HOST", "")
+	require.NoError(t, os.Unsetenv("NSHIP_TEST_HOST"))
+
+	loader := NewLoader()
+	require.NoError(t, loader.Load(base, ""))
+	require.NoError(t, loader.Load(prod, ""))
+
+	assert.Equal(t, "prod.example.com", os.Getenv("NSHIP_TEST_HOST"), "the last file should take precedence")
+	assert.Equal(t, "inherited", os.Getenv("NSHIP_TEST_USER"), "files should not override inherited variables")
+}
+
+func TestLoadWithOverride(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prod.env")
+	require.NoError(t, os.WriteFile(path, []byte("NSHIP_TEST_REGION=eu-west\n"), 0600))
+	t.Setenv("NSHIP_TEST_REGION", "")
+
+	require.NoError(t, NewLoader(WithOverride(true)).Load(path, ""))
+	assert.Equal(t, "eu-west", os.Getenv("NSHIP_TEST_REGION"), "files should override inherited variables, even empty ones")
+}
+
+func TestSetVariables(t *testing.T) {
+	t.Setenv("NSHIP_TEST_A", "old")
+	t.Setenv("NSHIP_TEST_B", "")
+
+	loader := NewLoader()
+	require.NoError(t, loader.SetVariables(map[string]string{"NSHIP_TEST_A": "1", "NSHIP_TEST_B": "2"}))
+	assert.Equal(t, "1", os.Getenv("NSHIP_TEST_A"))
+	assert.Equal(t, "2", os.Getenv("NSHIP_TEST_B"))
+
+	assert.True(t, loader.IsSet("NSHIP_TEST_A"))
+	assert.False(t, loader.IsSet("NSHIP_TEST_NEVER_SET"))
+	assert.False(t, NewLoader().IsSet("NSHIP_TEST_A"), "each loader should only know the variables it set")
+
+	assert.Error(t, loader.SetVariables(map[string]string{"": "invalid"}))
+}
@@ -0,0 +1,65 @@
+package fs
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/nickalie/nship/internal/util"
+)
+
+// compressedSuffix is appended to the remote path of the temporary compressed upload
+const compressedSuffix = ".nship.gz"
+
+// CommandRunner runs shell commands on the remote host
+type CommandRunner interface {
+	RunCommand(cmd string) error
+}
+
+// WithCompression returns a copy of the Copier that gzips file content in transit and
+// decompresses it on the remote host with gunzip executed through runner.
+// A nil runner disables compression.
+func (c *Copier) WithCompression(runner CommandRunner) *Copier {
+	copier := *c
+	copier.runner = runner
+	return &copier
+}
+
+// uploadCompressed writes gzipped content of src to a temporary remote file and
+// decompresses it into the remote path. The temporary file is always removed.
+func (c *Copier) uploadCompressed(src io.Reader, remote string) error {
+	tmp := remote + compressedSuffix
+
+	err := c.writeCompressed(src, tmp)
+	if err == nil {
+		cmd := fmt.Sprintf("gunzip -c %s > %s", util.ShellQuote(tmp), util.ShellQuote(remote))
+		if runErr := c.runner.RunCommand(cmd); runErr != nil {
+			err = fmt.Errorf("decompress remote file: %w", runErr)
+		}
+	}
+
+	_ = c.client.RemoveAll(tmp)
+	return err
+}
+
+// writeCompressed gzips src into the remote file at path
+func (c *Copier) writeCompressed(src io.Reader, path string) error {
+	remoteFile, err := c.client.Create(path)
+	if err != nil {
+		return fmt.Errorf("create destination file: %s, %w", path, err)
+	}
+
+	gz := gzip.NewWriter(c.throttle(remoteFile))
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := remoteFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("copy compressed file content: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package fs
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// MockCommandRunner implements CommandRunner for testing
+type MockCommandRunner struct {
+	RunCommandFunc func(cmd string) error
+}
+
+func (m *MockCommandRunner) RunCommand(cmd string) error {
+	if m.RunCommandFunc != nil {
+		return m.RunCommandFunc(cmd)
+	}
+	return nil
+}
+
+func TestCopyFileCompressed(t *testing.T) {
+	content := []byte(strings.Repeat("compressible text\n", 100))
+	sourceFile := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(sourceFile, content, 0644))
+
+	var uploaded bytes.Buffer
+	var createdPath, chmodPath string
+	var removed []string
+
+	mockSFTP := &MockSFTPClient{
+		CreateFunc: func(path string) (io.WriteCloser, error) {
+			createdPath = path
+			return &MockWriteCloser{WriteFunc: uploaded.Write}, nil
+		},
+		ChmodFunc: func(path string, mode os.FileMode) error {
+			chmodPath = path
+			return nil
+		},
+		RemoveAllFunc: func(path string) error {
+			removed = append(removed, path)
+			return nil
+		},
+	}
+
+	var commands []string
+	runner := &MockCommandRunner{
+		RunCommandFunc: func(cmd string) error {
+			commands = append(commands, cmd)
+			return nil
+		},
+	}
+
+	err := NewCopier(mockSFTP).WithCompression(runner).CopyFile(sourceFile, "remote/file.txt")
+	require.NoError(t, err)
+
+	assert.Equal(t, "remote/file.txt.nship-tmp.nship.gz", createdPath)
+	assert.Equal(t, []string{"gunzip -c 'remote/file.txt.nship-tmp.nship.gz' > 'remote/file.txt.nship-tmp'"}, commands)
+	assert.Equal(t, []string{"remote/file.txt.nship-tmp.nship.gz"}, removed)
+	assert.Equal(t, "remote/file.txt.nship-tmp", chmodPath)
+	assert.Less(t, uploaded.Len(), len(content), "compressed upload should be smaller than the source")
+
+	reader, err := gzip.NewReader(&uploaded)
+	require.NoError(t, err)
+	decompressed, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content, decompressed)
+}
+
+func TestCopyFileCompressedDecompressError(t *testing.T) {
+	sourceFile := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(sourceFile, []byte("content"), 0644))
+
+	var removed []string
+	mockSFTP := &MockSFTPClient{
+		RemoveAllFunc: func(path string) error {
+			removed = append(removed, path)
+			return nil
+		},
+	}
+	runner := &MockCommandRunner{
+		RunCommandFunc: func(cmd string) error {
+			return errors.New("gunzip failed")
+		},
+	}
+
+	err := NewCopier(mockSFTP).WithCompression(runner).CopyFile(sourceFile, "remote/file.txt")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "decompress remote file")
+	assert.Equal(t, []string{"remote/file.txt.nship-tmp.nship.gz"}, removed, "temporary file should be removed")
+}
@@ -2,10 +2,13 @@
 package fs
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
 	"github.com/nickalie/nship/internal/util"
 )
@@ -13,19 +16,104 @@ import (
 // SFTPClient abstracts SFTP operations
 type SFTPClient interface {
 	Create(path string) (io.WriteCloser, error)
+	Open(path string) (io.ReadCloser, error)
 	MkdirAll(path string) error
 	Chmod(path string, mode os.FileMode) error
 	Stat(path string) (os.FileInfo, error)
+	Chtimes(path string, atime, mtime time.Time) error
+	ReadDir(path string) ([]os.FileInfo, error)
+	Remove(path string) error
+	RemoveAll(path string) error
+	Rename(oldname, newname string) error
+	Symlink(oldname, newname string) error
 }
 
+// tempSuffix is appended to the remote path of a file while it is being uploaded
+const tempSuffix = ".nship-tmp"
+
 // Copier handles file copy operations
 type Copier struct {
-	client SFTPClient
+	client           SFTPClient
+	concurrency      int
+	preserveTimes    bool
+	deleteExtraneous bool
+	allowDeleteAll   bool
+	limiter          *RateLimiter
+	runner           CommandRunner
+	followSymlinks   bool
+	// out receives the messages about skipped and deleted files, os.Stdout when nil
+	out io.Writer
+}
+
+// fileTransfer describes a single file to upload
+type fileTransfer struct {
+	local  string
+	remote string
 }
 
-// NewCopier creates a new Copier instance
+// NewCopier creates a new Copier instance that uploads files sequentially
 func NewCopier(client SFTPClient) *Copier {
-	return &Copier{client: client}
+	return NewCopierWithConcurrency(client, 1)
+}
+
+// NewCopierWithConcurrency creates a new Copier instance that uploads up to
+// concurrency files of a directory in parallel
+func NewCopierWithConcurrency(client SFTPClient, concurrency int) *Copier {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Copier{client: client, concurrency: concurrency, preserveTimes: true}
+}
+
+// WithConcurrency returns a copy of the Copier using the given upload concurrency.
+// Values below 2 keep the current setting.
+func (c *Copier) WithConcurrency(concurrency int) *Copier {
+	copier := *c
+	if concurrency > 1 {
+		copier.concurrency = concurrency
+	}
+	return &copier
+}
+
+// WithPreserveTimes returns a copy of the Copier that sets the modification time
+// of uploaded files to that of the local files when preserve is true.
+func (c *Copier) WithPreserveTimes(preserve bool) *Copier {
+	copier := *c
+	copier.preserveTimes = preserve
+	return &copier
+}
+
+// WithDelete returns a copy of the Copier that, when deleteExtraneous is true, removes
+// remote files and directories of a copied directory that have no local counterpart.
+// Unless allowDeleteAll is set, pruning is refused when the local directory is empty.
+func (c *Copier) WithDelete(deleteExtraneous, allowDeleteAll bool) *Copier {
+	copier := *c
+	copier.deleteExtraneous = deleteExtraneous
+	copier.allowDeleteAll = allowDeleteAll
+	return &copier
+}
+
+// WithRateLimiter returns a copy of the Copier whose uploads are throttled by limiter.
+// A nil limiter disables throttling.
+func (c *Copier) WithRateLimiter(limiter *RateLimiter) *Copier {
+	copier := *c
+	copier.limiter = limiter
+	return &copier
+}
+
+// WithOutput returns a copy of the Copier that writes its messages about skipped and deleted files to w
+func (c *Copier) WithOutput(w io.Writer) *Copier {
+	copier := *c
+	copier.out = w
+	return &copier
+}
+
+// output returns the writer of the messages of the Copier
+func (c *Copier) output() io.Writer {
+	if c.out == nil {
+		return os.Stdout
+	}
+	return c.out
 }
 
 // CopyPath copies a file or directory
@@ -41,7 +129,9 @@ func (c *Copier) CopyPath(local, remote string, exclude []string) error {
 	return c.CopyFile(local, remote)
 }
 
-// CopyFile copies a single file
+// CopyFile copies a single file. The content is uploaded to a temporary file next to the
+// destination, which replaces the destination only once it is completely written, so an
+// interrupted upload never leaves a partially written destination behind.
 func (c *Copier) CopyFile(local, remote string) error {
 	localFile, err := os.Open(local)
 	if err != nil {
@@ -54,17 +144,34 @@ func (c *Copier) CopyFile(local, remote string) error {
 		return fmt.Errorf("create destination directory: %w", err)
 	}
 
-	remoteFile, err := c.client.Create(remote)
-	if err != nil {
-		return fmt.Errorf("create destination file: %s, %w", remote, err)
+	tmp := remote + tempSuffix
+	if err := c.writeFile(localFile, tmp); err != nil {
+		_ = c.client.Remove(tmp)
+		return err
 	}
-	defer remoteFile.Close()
 
-	if _, err := io.Copy(remoteFile, localFile); err != nil {
-		return fmt.Errorf("copy file content: %w", err)
+	if err := c.client.Rename(tmp, remote); err != nil {
+		_ = c.client.Remove(tmp)
+		return fmt.Errorf("replace destination file: %w", err)
 	}
 
-	localInfo, err := os.Stat(local)
+	return nil
+}
+
+// writeFile uploads the content of the local file to the remote path and applies its
+// permissions and, if enabled, its modification time
+func (c *Copier) writeFile(localFile *os.File, remote string) error {
+	var err error
+	if c.runner != nil {
+		err = c.uploadCompressed(localFile, remote)
+	} else {
+		err = c.upload(localFile, remote)
+	}
+	if err != nil {
+		return err
+	}
+
+	localInfo, err := localFile.Stat()
 	if err != nil {
 		return fmt.Errorf("stat source file: %w", err)
 	}
@@ -73,11 +180,67 @@ func (c *Copier) CopyFile(local, remote string) error {
 		return fmt.Errorf("set file permissions: %w", err)
 	}
 
+	if c.preserveTimes {
+		if err := c.client.Chtimes(remote, localInfo.ModTime(), localInfo.ModTime()); err != nil {
+			return fmt.Errorf("set file modification time: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// upload writes the content of src to the remote file. Closing the file can fail too,
+// as clients may only send the content once it is complete.
+func (c *Copier) upload(src io.Reader, remote string) error {
+	remoteFile, err := c.client.Create(remote)
+	if err != nil {
+		return fmt.Errorf("create destination file: %s, %w", remote, err)
+	}
+
+	_, err = io.Copy(c.throttle(remoteFile), src)
+	if closeErr := remoteFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("copy file content: %w", err)
+	}
+
 	return nil
 }
 
-// CopyDir copies a directory recursively
+// throttle wraps w with the rate limiter, if one is configured
+func (c *Copier) throttle(w io.Writer) io.Writer {
+	if c.limiter == nil {
+		return w
+	}
+	return c.limiter.Writer(w)
+}
+
+// CopyDir copies a directory recursively. The patterns of a .nshipignore file in local are
+// excluded along with exclude.
 func (c *Copier) CopyDir(local, remote string, exclude []string) error {
+	exclude, err := util.MergeIgnoreFile(local, exclude, os.ReadFile)
+	if err != nil {
+		return err
+	}
+
+	if err := c.copyDir(local, remote, exclude); err != nil {
+		return err
+	}
+
+	if c.deleteExtraneous {
+		return c.pruneExtraneous(local, remote, exclude)
+	}
+
+	return nil
+}
+
+// copyDir uploads the contents of a directory, sequentially or concurrently
+func (c *Copier) copyDir(local, remote string, exclude []string) error {
+	if c.concurrency > 1 {
+		return c.copyDirConcurrently(local, remote, exclude)
+	}
+
 	if err := c.client.MkdirAll(remote); err != nil {
 		return fmt.Errorf("create destination directory: %w", err)
 	}
@@ -105,24 +268,19 @@ func (c *Copier) processEntry(entry os.DirEntry, local, remote string, exclude [
 
 	// Check exclusion first, before trying to access the file
 	if util.IsExcluded(localPath, exclude) {
-		fmt.Println("Skipping excluded file:", localPath)
+		fmt.Fprintln(c.output(), "Skipping excluded file:", localPath)
 		return nil
 	}
 
-	if entry.IsDir() {
-		return c.CopyDir(localPath, remotePath, exclude)
+	if isSymlink(entry) {
+		return c.processSymlink(localPath, remotePath, exclude)
 	}
 
-	ok, err := c.shouldTransferFile(localPath, remotePath)
-	if err != nil {
-		return fmt.Errorf("check file transfer: %w", err)
-	}
-	if !ok {
-		fmt.Println("Skipping file, no changes detected:", localPath)
-		return nil
+	if entry.IsDir() {
+		return c.copyDir(localPath, remotePath, exclude)
 	}
 
-	return c.CopyFile(localPath, remotePath)
+	return c.transferFile(fileTransfer{local: localPath, remote: remotePath})
 }
 
 func (c *Copier) shouldTransferFile(localPath, remotePath string) (bool, error) {
@@ -145,3 +303,104 @@ func (c *Copier) shouldTransferFile(localPath, remotePath string) (bool, error)
 
 	return localInfo.Size() != remoteInfo.Size(), nil
 }
+
+// copyDirConcurrently creates the remote directory tree first and then
+// uploads the files using a pool of c.concurrency workers
+func (c *Copier) copyDirConcurrently(local, remote string, exclude []string) error {
+	var files []fileTransfer
+	if err := c.createDirTree(local, remote, exclude, &files); err != nil {
+		return err
+	}
+
+	return c.transferFiles(files)
+}
+
+// createDirTree creates remote directories in walk order, so parents always exist
+// before their children, and collects the files to upload
+func (c *Copier) createDirTree(local, remote string, exclude []string, files *[]fileTransfer) error {
+	if err := c.client.MkdirAll(remote); err != nil {
+		return fmt.Errorf("create destination directory: %w", err)
+	}
+
+	entries, err := os.ReadDir(local)
+	if err != nil {
+		return fmt.Errorf("read source directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if err := c.collectEntry(entry, local, remote, exclude, files); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collectEntry creates the remote directories below a directory entry and collects its files to upload
+func (c *Copier) collectEntry(entry os.DirEntry, local, remote string, exclude []string, files *[]fileTransfer) error {
+	localPath := filepath.Join(local, entry.Name())
+	remotePath := filepath.ToSlash(filepath.Join(remote, entry.Name()))
+
+	if util.IsExcluded(localPath, exclude) {
+		fmt.Fprintln(c.output(), "Skipping excluded file:", localPath)
+		return nil
+	}
+
+	if isSymlink(entry) {
+		return c.collectSymlink(localPath, remotePath, exclude, files)
+	}
+
+	if !entry.IsDir() {
+		*files = append(*files, fileTransfer{local: localPath, remote: remotePath})
+		return nil
+	}
+
+	return c.createDirTree(localPath, remotePath, exclude, files)
+}
+
+// transferFiles uploads files in parallel and returns all per-file errors joined together
+func (c *Copier) transferFiles(files []fileTransfer) error {
+	queue := make(chan fileTransfer)
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+
+	for i := 0; i < c.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for file := range queue {
+				if err := c.transferFile(file); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("%s: %w", file.local, err))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, file := range files {
+		queue <- file
+	}
+	close(queue)
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// transferFile uploads a single file unless it is unchanged on the remote
+func (c *Copier) transferFile(file fileTransfer) error {
+	ok, err := c.shouldTransferFile(file.local, file.remote)
+	if err != nil {
+		return fmt.Errorf("check file transfer: %w", err)
+	}
+	if !ok {
+		fmt.Fprintln(c.output(), util.Skipped("Skipping file, no changes detected: "+file.local))
+		return nil
+	}
+
+	return c.CopyFile(file.local, file.remote)
+}
@@ -6,7 +6,10 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/nickalie/nship/internal/util"
 	"github.com/stretchr/testify/assert"
@@ -15,10 +18,17 @@ import (
 
 // MockSFTPClient implements SFTPClient for testing
 type MockSFTPClient struct {
-	CreateFunc   func(path string) (io.WriteCloser, error)
-	MkdirAllFunc func(path string) error
-	ChmodFunc    func(path string, mode os.FileMode) error
-	StatFunc     func(path string) (os.FileInfo, error)
+	CreateFunc    func(path string) (io.WriteCloser, error)
+	OpenFunc      func(path string) (io.ReadCloser, error)
+	MkdirAllFunc  func(path string) error
+	ChmodFunc     func(path string, mode os.FileMode) error
+	StatFunc      func(path string) (os.FileInfo, error)
+	ChtimesFunc   func(path string, atime, mtime time.Time) error
+	ReadDirFunc   func(path string) ([]os.FileInfo, error)
+	RemoveFunc    func(path string) error
+	RemoveAllFunc func(path string) error
+	RenameFunc    func(oldname, newname string) error
+	SymlinkFunc   func(oldname, newname string) error
 }
 
 // Create implements SFTPClient.Create
@@ -29,6 +39,14 @@ func (m *MockSFTPClient) Create(path string) (io.WriteCloser, error) {
 	return &MockWriteCloser{}, nil
 }
 
+// Open implements SFTPClient.Open
+func (m *MockSFTPClient) Open(path string) (io.ReadCloser, error) {
+	if m.OpenFunc != nil {
+		return m.OpenFunc(path)
+	}
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
 // MkdirAll implements SFTPClient.MkdirAll
 func (m *MockSFTPClient) MkdirAll(path string) error {
 	if m.MkdirAllFunc != nil {
@@ -53,6 +71,54 @@ func (m *MockSFTPClient) Stat(path string) (os.FileInfo, error) {
 	return &MockFileInfo{}, nil
 }
 
+// Chtimes implements SFTPClient.Chtimes
+func (m *MockSFTPClient) Chtimes(path string, atime, mtime time.Time) error {
+	if m.ChtimesFunc != nil {
+		return m.ChtimesFunc(path, atime, mtime)
+	}
+	return nil
+}
+
+// ReadDir implements SFTPClient.ReadDir
+func (m *MockSFTPClient) ReadDir(path string) ([]os.FileInfo, error) {
+	if m.ReadDirFunc != nil {
+		return m.ReadDirFunc(path)
+	}
+	return nil, nil
+}
+
+// Remove implements SFTPClient.Remove
+func (m *MockSFTPClient) Remove(path string) error {
+	if m.RemoveFunc != nil {
+		return m.RemoveFunc(path)
+	}
+	return nil
+}
+
+// Rename implements SFTPClient.Rename
+func (m *MockSFTPClient) Rename(oldname, newname string) error {
+	if m.RenameFunc != nil {
+		return m.RenameFunc(oldname, newname)
+	}
+	return nil
+}
+
+// Symlink implements SFTPClient.Symlink
+func (m *MockSFTPClient) Symlink(oldname, newname string) error {
+	if m.SymlinkFunc != nil {
+		return m.SymlinkFunc(oldname, newname)
+	}
+	return nil
+}
+
+// RemoveAll implements SFTPClient.RemoveAll
+func (m *MockSFTPClient) RemoveAll(path string) error {
+	if m.RemoveAllFunc != nil {
+		return m.RemoveAllFunc(path)
+	}
+	return nil
+}
+
 func TestCopyFile(t *testing.T) {
 	// Create temporary test directory
 	tempDir, cleanup := setupTestEnvironment(t)
@@ -120,7 +186,7 @@ func TestCopyDir(t *testing.T) {
 			return nil
 		},
 		CreateFunc: func(path string) (io.WriteCloser, error) {
-			createdFiles[path] = true
+			createdFiles[strings.TrimSuffix(path, tempSuffix)] = true
 			return &MockWriteCloser{}, nil
 		},
 		StatFunc: func(path string) (os.FileInfo, error) {
@@ -476,3 +542,296 @@ func TestProcessEntry(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+func TestCopyDirConcurrently(t *testing.T) {
+	tempDir, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	sourceDir := filepath.Join(tempDir, "local")
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "a", "b"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "skip"), 0755))
+
+	expectedFiles := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		for _, dir := range []string{"", "a", filepath.Join("a", "b")} {
+			name := filepath.Join(dir, fmt.Sprintf("file%d.txt", i))
+			require.NoError(t, os.WriteFile(filepath.Join(sourceDir, name), []byte("content"), 0644))
+			expectedFiles[filepath.ToSlash(filepath.Join("remote", name))] = true
+		}
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "skip", "ignored.txt"), []byte("content"), 0644))
+
+	var mu sync.Mutex
+	createdDirs := map[string]bool{}
+	createdFiles := map[string]bool{}
+	var dirOrder []string
+
+	mockSFTP := &MockSFTPClient{
+		MkdirAllFunc: func(path string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if !createdDirs[path] {
+				dirOrder = append(dirOrder, path)
+			}
+			createdDirs[path] = true
+			return nil
+		},
+		CreateFunc: func(path string) (io.WriteCloser, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.True(t, createdDirs[filepath.ToSlash(filepath.Dir(path))], "parent of %s created after the file", path)
+			createdFiles[strings.TrimSuffix(path, tempSuffix)] = true
+			return &MockWriteCloser{}, nil
+		},
+		StatFunc: func(path string) (os.FileInfo, error) {
+			return nil, os.ErrNotExist
+		},
+	}
+
+	copier := NewCopierWithConcurrency(mockSFTP, 4)
+	err := copier.CopyDir(sourceDir, "remote", []string{"skip"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, expectedFiles, createdFiles)
+	assert.Equal(t, []string{"remote", "remote/a", "remote/a/b"}, dirOrder)
+}
+
+func TestCopyDirIgnoreFile(t *testing.T) {
+	tempDir, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	sourceDir := filepath.Join(tempDir, "local")
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "cache"), 0755))
+	for _, name := range []string{"app.js", "debug.log", "app.bak", filepath.Join("cache", "data.js")} {
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, name), []byte("content"), 0644))
+	}
+	ignore := "# build leftovers\n*.log\n\ncache/\n"
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, util.IgnoreFileName), []byte(ignore), 0644))
+
+	createdFiles := map[string]bool{}
+	mockSFTP := &MockSFTPClient{
+		CreateFunc: func(path string) (io.WriteCloser, error) {
+			createdFiles[strings.TrimSuffix(path, tempSuffix)] = true
+			return &MockWriteCloser{}, nil
+		},
+		StatFunc: func(path string) (os.FileInfo, error) {
+			return nil, os.ErrNotExist
+		},
+	}
+
+	err := NewCopier(mockSFTP).CopyDir(sourceDir, "remote", []string{"*.bak"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"remote/app.js": true, "remote/" + util.IgnoreFileName: true}, createdFiles,
+		"patterns of the ignore file should be excluded along with those of the step")
+}
+
+func TestCopyDirNegatedPatterns(t *testing.T) {
+	tempDir, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	sourceDir := filepath.Join(tempDir, "local")
+	for _, dir := range []string{"logs", "cache"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, dir), 0755))
+		for _, name := range []string{"keep.log", "debug.log"} {
+			require.NoError(t, os.WriteFile(filepath.Join(sourceDir, dir, name), []byte("content"), 0644))
+		}
+	}
+
+	createdFiles := map[string]bool{}
+	mockSFTP := &MockSFTPClient{
+		CreateFunc: func(path string) (io.WriteCloser, error) {
+			createdFiles[strings.TrimSuffix(path, tempSuffix)] = true
+			return &MockWriteCloser{}, nil
+		},
+		StatFunc: func(path string) (os.FileInfo, error) {
+			return nil, os.ErrNotExist
+		},
+	}
+
+	// Like in .gitignore, files can't be re-included once their directory is excluded, but
+	// they can when only the contents of the directory are
+	err := NewCopier(mockSFTP).CopyDir(sourceDir, "remote", []string{"cache", "**/logs/**", "!keep.log"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"remote/logs/keep.log": true}, createdFiles)
+}
+
+func TestCopyDirConcurrentlyAggregatesErrors(t *testing.T) {
+	tempDir, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	sourceDir := filepath.Join(tempDir, "local")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	for _, name := range []string{"ok.txt", "bad1.txt", "bad2.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, name), []byte("content"), 0644))
+	}
+
+	mockSFTP := &MockSFTPClient{
+		CreateFunc: func(path string) (io.WriteCloser, error) {
+			if strings.Contains(path, "bad") {
+				return nil, fmt.Errorf("permission denied")
+			}
+			return &MockWriteCloser{}, nil
+		},
+		StatFunc: func(path string) (os.FileInfo, error) {
+			return nil, os.ErrNotExist
+		},
+	}
+
+	err := NewCopier(mockSFTP).WithConcurrency(2).CopyDir(sourceDir, "remote", nil)
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "bad1.txt")
+	assert.Contains(t, err.Error(), "bad2.txt")
+	assert.NotContains(t, err.Error(), "ok.txt")
+}
+
+func TestNewCopierWithConcurrency(t *testing.T) {
+	assert.Equal(t, 1, NewCopier(&MockSFTPClient{}).concurrency)
+	assert.Equal(t, 1, NewCopierWithConcurrency(&MockSFTPClient{}, 0).concurrency)
+	assert.Equal(t, 8, NewCopierWithConcurrency(&MockSFTPClient{}, 8).concurrency)
+	assert.Equal(t, 8, NewCopierWithConcurrency(&MockSFTPClient{}, 8).WithConcurrency(0).concurrency)
+	assert.Equal(t, 4, NewCopier(&MockSFTPClient{}).WithConcurrency(4).concurrency)
+}
+
+func TestCopyFileAtomic(t *testing.T) {
+	sourceFile := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(sourceFile, []byte("content"), 0644))
+
+	var calls []string
+	mockSFTP := &MockSFTPClient{
+		CreateFunc: func(path string) (io.WriteCloser, error) {
+			calls = append(calls, "create "+path)
+			return &MockWriteCloser{}, nil
+		},
+		ChmodFunc: func(path string, mode os.FileMode) error {
+			calls = append(calls, "chmod "+path)
+			return nil
+		},
+		RenameFunc: func(oldname, newname string) error {
+			calls = append(calls, "rename "+oldname+" "+newname)
+			return nil
+		},
+	}
+
+	require.NoError(t, NewCopier(mockSFTP).WithPreserveTimes(false).CopyFile(sourceFile, "remote/file.txt"))
+	assert.Equal(t, []string{
+		"create remote/file.txt.nship-tmp",
+		"chmod remote/file.txt.nship-tmp",
+		"rename remote/file.txt.nship-tmp remote/file.txt",
+	}, calls)
+}
+
+func TestCopyFileAtomicCleanup(t *testing.T) {
+	sourceFile := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(sourceFile, []byte("content"), 0644))
+
+	tests := []struct {
+		name   string
+		client func(*MockSFTPClient)
+		errMsg string
+	}{
+		{
+			name: "write fails",
+			client: func(m *MockSFTPClient) {
+				m.CreateFunc = func(path string) (io.WriteCloser, error) {
+					return &MockWriteCloser{WriteFunc: func(p []byte) (int, error) {
+						return 0, fmt.Errorf("connection lost")
+					}}, nil
+				}
+			},
+			errMsg: "copy file content",
+		},
+		{
+			name: "close fails",
+			client: func(m *MockSFTPClient) {
+				m.CreateFunc = func(path string) (io.WriteCloser, error) {
+					return &MockWriteCloser{CloseFunc: func() error {
+						return fmt.Errorf("scp: disk full")
+					}}, nil
+				}
+			},
+			errMsg: "copy file content: scp: disk full",
+		},
+		{
+			name: "chmod fails",
+			client: func(m *MockSFTPClient) {
+				m.ChmodFunc = func(path string, mode os.FileMode) error {
+					return fmt.Errorf("permission denied")
+				}
+			},
+			errMsg: "set file permissions",
+		},
+		{
+			name: "rename fails",
+			client: func(m *MockSFTPClient) {
+				m.RenameFunc = func(oldname, newname string) error {
+					return fmt.Errorf("permission denied")
+				}
+			},
+			errMsg: "replace destination file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var removed []string
+			renamed := false
+			mockSFTP := &MockSFTPClient{
+				RemoveFunc: func(path string) error {
+					removed = append(removed, path)
+					return nil
+				},
+				RenameFunc: func(oldname, newname string) error {
+					renamed = true
+					return nil
+				},
+			}
+			tt.client(mockSFTP)
+
+			err := NewCopier(mockSFTP).CopyFile(sourceFile, "remote/file.txt")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+			assert.Equal(t, []string{"remote/file.txt.nship-tmp"}, removed, "temporary file should be removed")
+			assert.False(t, renamed, "destination should not be replaced")
+		})
+	}
+}
+
+func TestCopyFilePreservesTimes(t *testing.T) {
+	tempDir, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	sourceFile := filepath.Join(tempDir, "file.txt")
+	require.NoError(t, os.WriteFile(sourceFile, []byte("content"), 0644))
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, os.Chtimes(sourceFile, modTime, modTime))
+
+	var chtimesPath string
+	var chtimesMtime time.Time
+	mockSFTP := &MockSFTPClient{
+		ChtimesFunc: func(path string, atime, mtime time.Time) error {
+			chtimesPath = path
+			chtimesMtime = mtime
+			return nil
+		},
+	}
+
+	err := NewCopier(mockSFTP).CopyFile(sourceFile, "remote/file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "remote/file.txt"+tempSuffix, chtimesPath, "times should be set before the file is renamed")
+	assert.True(t, modTime.Equal(chtimesMtime), "remote mtime should match local mtime")
+
+	chtimesPath = ""
+	err = NewCopier(mockSFTP).WithPreserveTimes(false).CopyFile(sourceFile, "remote/file.txt")
+	assert.NoError(t, err)
+	assert.Empty(t, chtimesPath, "Chtimes should not be called when times are not preserved")
+
+	mockSFTP.ChtimesFunc = func(path string, atime, mtime time.Time) error {
+		return fmt.Errorf("operation unsupported")
+	}
+	err = NewCopier(mockSFTP).CopyFile(sourceFile, "remote/file.txt")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "set file modification time")
+}
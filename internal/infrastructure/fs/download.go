@@ -0,0 +1,115 @@
+package fs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// DownloadPath copies a remote file or directory to the local machine
+func (c *Copier) DownloadPath(remote, local string) error {
+	remoteInfo, err := c.client.Stat(remote)
+	if err != nil {
+		return fmt.Errorf("stat source: %w", err)
+	}
+
+	if remoteInfo.IsDir() {
+		return c.DownloadDir(remote, local)
+	}
+	return c.DownloadFile(remote, local)
+}
+
+// DownloadFile copies a single remote file to the local machine
+func (c *Copier) DownloadFile(remote, local string) error {
+	remoteInfo, err := c.client.Stat(remote)
+	if err != nil {
+		return fmt.Errorf("stat source file: %w", err)
+	}
+
+	remoteFile, err := c.client.Open(remote)
+	if err != nil {
+		return fmt.Errorf("open source file: %w", err)
+	}
+	defer remoteFile.Close()
+
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return fmt.Errorf("create destination directory: %w", err)
+	}
+
+	if err := c.download(remoteFile, local, remoteInfo.Mode().Perm()); err != nil {
+		return err
+	}
+
+	if c.preserveTimes {
+		if err := os.Chtimes(local, remoteInfo.ModTime(), remoteInfo.ModTime()); err != nil {
+			return fmt.Errorf("set file modification time: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// download writes the content of src to the local file and applies the remote permissions
+func (c *Copier) download(src io.Reader, local string, mode os.FileMode) error {
+	localFile, err := os.OpenFile(local, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("create destination file: %s, %w", local, err)
+	}
+	defer localFile.Close()
+
+	if _, err := io.Copy(c.throttle(localFile), src); err != nil {
+		return fmt.Errorf("copy file content: %w", err)
+	}
+
+	if err := localFile.Chmod(mode); err != nil {
+		return fmt.Errorf("set file permissions: %w", err)
+	}
+
+	return nil
+}
+
+// DownloadDir copies a remote directory recursively to the local machine.
+// Symlinks to directories are skipped, as they may point to a directory containing them.
+func (c *Copier) DownloadDir(remote, local string) error {
+	if err := os.MkdirAll(local, 0755); err != nil {
+		return fmt.Errorf("create destination directory: %w", err)
+	}
+
+	entries, err := c.client.ReadDir(remote)
+	if err != nil {
+		return fmt.Errorf("read source directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if err := c.downloadEntry(entry, remote, local); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// downloadEntry copies a single entry of a remote directory to the local machine
+func (c *Copier) downloadEntry(entry os.FileInfo, remote, local string) error {
+	remotePath := path.Join(remote, entry.Name())
+	localPath := filepath.Join(local, entry.Name())
+
+	if entry.Mode()&os.ModeSymlink != 0 {
+		info, err := c.client.Stat(remotePath)
+		if err != nil {
+			return fmt.Errorf("resolve symlink %s: %w", remotePath, err)
+		}
+		if info.IsDir() {
+			fmt.Fprintln(c.output(), "Skipping symlinked directory:", remotePath)
+			return nil
+		}
+		return c.DownloadFile(remotePath, localPath)
+	}
+
+	if entry.IsDir() {
+		return c.DownloadDir(remotePath, localPath)
+	}
+	return c.DownloadFile(remotePath, localPath)
+}
@@ -0,0 +1,116 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// remoteEntry returns file information of a remote entry with the given mode
+func remoteEntry(name string, mode os.FileMode, modTime time.Time) *MockFileInfo {
+	return &MockFileInfo{
+		NameFunc:    func() string { return name },
+		ModeFunc:    func() os.FileMode { return mode },
+		IsDirFunc:   func() bool { return mode.IsDir() },
+		ModTimeFunc: func() time.Time { return modTime },
+	}
+}
+
+// newDownloadSFTP returns an SFTP client serving the given remote directories and file contents
+func newDownloadSFTP(dirs map[string][]os.FileInfo, files map[string]string, infos map[string]os.FileInfo) *MockSFTPClient {
+	return &MockSFTPClient{
+		StatFunc: func(path string) (os.FileInfo, error) {
+			if info, ok := infos[path]; ok {
+				return info, nil
+			}
+			return nil, os.ErrNotExist
+		},
+		ReadDirFunc: func(path string) ([]os.FileInfo, error) {
+			return dirs[path], nil
+		},
+		OpenFunc: func(path string) (io.ReadCloser, error) {
+			content, ok := files[path]
+			if !ok {
+				return nil, os.ErrNotExist
+			}
+			return io.NopCloser(strings.NewReader(content)), nil
+		},
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	sftp := newDownloadSFTP(nil,
+		map[string]string{"/etc/app.conf": "port=80"},
+		map[string]os.FileInfo{"/etc/app.conf": remoteEntry("app.conf", 0600, modTime)},
+	)
+
+	local := filepath.Join(t.TempDir(), "backup", "app.conf")
+	require.NoError(t, NewCopier(sftp).DownloadPath("/etc/app.conf", local))
+
+	content, err := os.ReadFile(local)
+	require.NoError(t, err)
+	assert.Equal(t, "port=80", string(content))
+
+	info, err := os.Stat(local)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.True(t, info.ModTime().Equal(modTime))
+}
+
+func TestDownloadDir(t *testing.T) {
+	now := time.Now()
+	sftp := newDownloadSFTP(
+		map[string][]os.FileInfo{
+			"/srv/app": {
+				remoteEntry("config.yml", 0644, now),
+				remoteEntry("logs", os.ModeDir|0755, now),
+				remoteEntry("current", os.ModeSymlink|0777, now),
+				remoteEntry("latest.log", os.ModeSymlink|0777, now),
+			},
+			"/srv/app/logs": {remoteEntry("out.log", 0644, now)},
+		},
+		map[string]string{
+			"/srv/app/config.yml":   "a: 1",
+			"/srv/app/logs/out.log": "started",
+			"/srv/app/latest.log":   "started",
+		},
+		map[string]os.FileInfo{
+			"/srv/app":              remoteEntry("app", os.ModeDir|0755, now),
+			"/srv/app/config.yml":   remoteEntry("config.yml", 0644, now),
+			"/srv/app/logs/out.log": remoteEntry("out.log", 0644, now),
+			"/srv/app/current":      remoteEntry("current", os.ModeDir|0755, now),
+			"/srv/app/latest.log":   remoteEntry("latest.log", 0644, now),
+		},
+	)
+
+	local := filepath.Join(t.TempDir(), "app")
+	require.NoError(t, NewCopier(sftp).WithPreserveTimes(false).DownloadPath("/srv/app", local))
+
+	for name, expected := range map[string]string{
+		"config.yml":   "a: 1",
+		"logs/out.log": "started",
+		"latest.log":   "started",
+	} {
+		content, err := os.ReadFile(filepath.Join(local, name))
+		require.NoError(t, err, name)
+		assert.Equal(t, expected, string(content), name)
+	}
+
+	_, err := os.Stat(filepath.Join(local, "current"))
+	assert.True(t, os.IsNotExist(err), "symlinked directories should be skipped")
+}
+
+func TestDownloadPathMissingSource(t *testing.T) {
+	sftp := newDownloadSFTP(nil, nil, nil)
+
+	err := NewCopier(sftp).DownloadPath("/missing", filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.Contains(t, err.Error(), "stat source")
+}
@@ -18,6 +18,7 @@ const (
 
 // StepHash represents hash data for a specific job step
 type StepHash struct {
+	Namespace  string `json:"namespace,omitempty"`
 	TargetName string `json:"target"`
 	JobName    string `json:"job"`
 	StepIndex  int    `json:"step"`
@@ -27,14 +28,41 @@ type StepHash struct {
 // FileHashStorage implements HashStorage using the file system
 type FileHashStorage struct {
 	baseDir string
-	mu      sync.RWMutex
-	hashes  map[string]StepHash
-	loaded  bool
+	// file is an explicit path of the hash file that replaces the one in baseDir
+	file      string
+	namespace string
+	mu        sync.RWMutex
+	hashes    map[string]StepHash
+	loaded    bool
 }
 
-// NewFileHashStorage creates a new FileHashStorage with the default directory
-func NewFileHashStorage() *FileHashStorage {
-	return NewFileHashStorageWithPath(DefaultHashDir)
+// FileHashStorageOption represents an option for configuring a FileHashStorage
+type FileHashStorageOption func(*FileHashStorage)
+
+// WithHashDir stores the hash file in dir instead of the default directory. An empty dir keeps the default.
+func WithHashDir(dir string) FileHashStorageOption {
+	return func(s *FileHashStorage) {
+		if dir != "" {
+			s.baseDir = dir
+		}
+	}
+}
+
+// WithHashFile stores the hashes in the file at path, taking precedence over the hash directory.
+// An empty path keeps the file in the hash directory.
+func WithHashFile(path string) FileHashStorageOption {
+	return func(s *FileHashStorage) {
+		s.file = path
+	}
+}
+
+// NewFileHashStorage creates a new FileHashStorage with the default directory unless changed by opts
+func NewFileHashStorage(opts ...FileHashStorageOption) *FileHashStorage {
+	storage := NewFileHashStorageWithPath(DefaultHashDir)
+	for _, opt := range opts {
+		opt(storage)
+	}
+	return storage
 }
 
 // NewFileHashStorageWithPath creates a new FileHashStorage with a custom directory
@@ -55,10 +83,11 @@ func (s *FileHashStorage) SaveHash(targetName, jobName string, stepIndex int, ha
 	}
 
 	// Create the key for this hash
-	key := makeHashKey(targetName, jobName, stepIndex)
+	key := makeHashKey(s.namespace, targetName, jobName, stepIndex)
 
 	// Store the hash in memory
 	s.hashes[key] = StepHash{
+		Namespace:  s.namespace,
 		TargetName: targetName,
 		JobName:    jobName,
 		StepIndex:  stepIndex,
@@ -78,7 +107,7 @@ func (s *FileHashStorage) GetHash(targetName, jobName string, stepIndex int) (st
 		return "", err
 	}
 
-	key := makeHashKey(targetName, jobName, stepIndex)
+	key := makeHashKey(s.namespace, targetName, jobName, stepIndex)
 	if hash, ok := s.hashes[key]; ok {
 		return hash.Hash, nil
 	}
@@ -93,6 +122,14 @@ func (s *FileHashStorage) Clear() error {
 
 	s.hashes = make(map[string]StepHash)
 
+	// An explicit hash file may live next to unrelated files, so only the file itself is removed
+	if s.file != "" {
+		if err := os.Remove(s.file); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove hash file: %w", err)
+		}
+		return nil
+	}
+
 	// Remove the entire hash directory
 	err := os.RemoveAll(s.baseDir)
 	if err != nil && !os.IsNotExist(err) {
@@ -102,6 +139,38 @@ func (s *FileHashStorage) Clear() error {
 	return nil
 }
 
+// SetNamespace sets the namespace of the hashes that are saved and retrieved from now on
+func (s *FileHashStorage) SetNamespace(namespace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.namespace = namespace
+}
+
+// ClearScope removes the hashes stored for the target and job, where an empty name matches any,
+// and returns how many were removed
+func (s *FileHashStorage) ClearScope(targetName, jobName string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.ensureLoaded(); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for key, hash := range s.hashes {
+		if job.MatchesScope(hash.TargetName, hash.JobName, targetName, jobName) {
+			delete(s.hashes, key)
+			removed++
+		}
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.persist()
+}
+
 // ensureLoaded makes sure the hashes are loaded from disk
 func (s *FileHashStorage) ensureLoaded() error {
 	if s.loaded {
@@ -129,7 +198,7 @@ func (s *FileHashStorage) ensureLoaded() error {
 	// Convert to map for faster lookups
 	s.hashes = make(map[string]StepHash, len(hashList))
 	for _, hash := range hashList {
-		key := makeHashKey(hash.TargetName, hash.JobName, hash.StepIndex)
+		key := makeHashKey(hash.Namespace, hash.TargetName, hash.JobName, hash.StepIndex)
 		s.hashes[key] = hash
 	}
 
@@ -153,7 +222,7 @@ func (s *FileHashStorage) persist() error {
 
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(s.getHashFilePath())
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	if err := os.MkdirAll(dir, 0700); err != nil {
 		return fmt.Errorf("failed to create hash directory: %w", err)
 	}
 
@@ -167,13 +236,23 @@ func (s *FileHashStorage) persist() error {
 
 // getHashFilePath returns the path to the hash file
 func (s *FileHashStorage) getHashFilePath() string {
+	if s.file != "" {
+		return s.file
+	}
 	return filepath.Join(s.baseDir, "step_hashes.json")
 }
 
-// makeHashKey creates a unique key for a hash
-func makeHashKey(targetName, jobName string, stepIndex int) string {
-	return fmt.Sprintf("%s:%s:%d", targetName, jobName, stepIndex)
+// makeHashKey creates a unique key for a hash. Keys without a namespace keep the format
+// used before namespaces existed.
+func makeHashKey(namespace, targetName, jobName string, stepIndex int) string {
+	if namespace == "" {
+		return fmt.Sprintf("%s:%s:%d", targetName, jobName, stepIndex)
+	}
+	return fmt.Sprintf("%s|%s:%s:%d", namespace, targetName, jobName, stepIndex)
 }
 
-// Ensure FileHashStorage implements the HashStorage interface
-var _ job.HashStorage = (*FileHashStorage)(nil)
+// Ensure FileHashStorage implements the ScopedHashStorage and NamespacedHashStorage interfaces
+var (
+	_ job.ScopedHashStorage     = (*FileHashStorage)(nil)
+	_ job.NamespacedHashStorage = (*FileHashStorage)(nil)
+)
@@ -96,22 +96,105 @@ func TestFileHashStorage_LoadFromFile(t *testing.T) {
 	assert.Equal(t, "hash2", hash, "Retrieved hash doesn't match expected value")
 }
 
+func TestFileHashStorage_StateDir(t *testing.T) {
+	stateDir := filepath.Join(t.TempDir(), "state", "production")
+
+	storage := NewFileHashStorage(WithHashDir(stateDir))
+	assert.NoError(t, storage.SaveHash("target1", "job1", 0, "hash1"))
+
+	info, err := os.Stat(stateDir)
+	assert.NoError(t, err, "State directory should be created")
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm(), "State directory should only be accessible by the owner")
+	assert.FileExists(t, filepath.Join(stateDir, "step_hashes.json"))
+}
+
+func TestFileHashStorage_StateFile(t *testing.T) {
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "production.json")
+	unrelated := filepath.Join(dir, "unrelated.txt")
+	assert.NoError(t, os.WriteFile(unrelated, []byte("keep"), 0644))
+
+	storage := NewFileHashStorage(WithHashDir(filepath.Join(dir, "ignored")), WithHashFile(stateFile))
+	assert.NoError(t, storage.SaveHash("target1", "job1", 0, "hash1"))
+	assert.FileExists(t, stateFile)
+	assert.NoDirExists(t, filepath.Join(dir, "ignored"), "The state file takes precedence over the directory")
+
+	hash, err := NewFileHashStorage(WithHashFile(stateFile)).GetHash("target1", "job1", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "hash1", hash)
+
+	assert.NoError(t, storage.Clear())
+	assert.NoFileExists(t, stateFile)
+	assert.FileExists(t, unrelated, "Clearing an explicit state file should not touch other files")
+}
+
+func TestFileHashStorage_ClearScope(t *testing.T) {
+	storage := NewFileHashStorageWithPath(t.TempDir())
+	assert.NoError(t, storage.SaveHash("web", "deploy", 0, "hash1"))
+	assert.NoError(t, storage.SaveHash("web", "deploy", 1, "hash2"))
+	assert.NoError(t, storage.SaveHash("web", "backup", 0, "hash3"))
+	assert.NoError(t, storage.SaveHash("db", "deploy", 0, "hash4"))
+
+	removed, err := storage.ClearScope("web", "deploy")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	removed, err = storage.ClearScope("", "backup")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	hash, err := storage.GetHash("db", "deploy", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "hash4", hash, "Hashes outside the scope should be kept")
+
+	removed, err = storage.ClearScope("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+}
+
+func TestFileHashStorage_Namespace(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Hashes stored before namespaces existed have none
+	legacy := NewFileHashStorageWithPath(tempDir)
+	assert.NoError(t, legacy.SaveHash("web", "deploy", 0, "legacy"))
+
+	shop := NewFileHashStorageWithPath(tempDir)
+	shop.SetNamespace("shop")
+	hash, err := shop.GetHash("web", "deploy", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, hash, "Hashes without a namespace should be a cache miss")
+	assert.NoError(t, shop.SaveHash("web", "deploy", 0, "shop"))
+
+	blog := NewFileHashStorageWithPath(tempDir)
+	blog.SetNamespace("blog")
+	assert.NoError(t, blog.SaveHash("web", "deploy", 0, "blog"))
+
+	reloaded := NewFileHashStorageWithPath(tempDir)
+	reloaded.SetNamespace("shop")
+	hash, err = reloaded.GetHash("web", "deploy", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "shop", hash, "Configs reusing job names should not clobber each other's hashes")
+}
+
 func TestMakeHashKey(t *testing.T) {
 	tests := []struct {
+		namespace  string
 		targetName string
 		jobName    string
 		stepIndex  int
 		expected   string
 	}{
-		{"target1", "job1", 0, "target1:job1:0"},
-		{"target2", "job2", 1, "target2:job2:1"},
-		{"", "", 0, "::0"},
+		{"", "target1", "job1", 0, "target1:job1:0"},
+		{"", "target2", "job2", 1, "target2:job2:1"},
+		{"", "", "", 0, "::0"},
+		{"shop", "target1", "job1", 0, "shop|target1:job1:0"},
 	}
 
 	for _, tt := range tests {
-		key := makeHashKey(tt.targetName, tt.jobName, tt.stepIndex)
+		key := makeHashKey(tt.namespace, tt.targetName, tt.jobName, tt.stepIndex)
 		assert.Equal(t, tt.expected, key,
-			"makeHashKey(%s, %s, %d) returned unexpected result",
-			tt.targetName, tt.jobName, tt.stepIndex)
+			"makeHashKey(%s, %s, %s, %d) returned unexpected result",
+			tt.namespace, tt.targetName, tt.jobName, tt.stepIndex)
 	}
 }
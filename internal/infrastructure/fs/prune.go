@@ -0,0 +1,87 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nickalie/nship/internal/util"
+)
+
+// pruneExtraneous removes remote entries below remote that have no matching local entry below local.
+// Excluded paths are left untouched on the remote.
+func (c *Copier) pruneExtraneous(local, remote string, exclude []string) error {
+	if !c.allowDeleteAll {
+		empty, err := isEmptySource(local, exclude)
+		if err != nil {
+			return err
+		}
+		if empty {
+			return fmt.Errorf("refusing to delete all files in %s: local source %s is empty", remote, local)
+		}
+	}
+
+	return c.pruneDir(local, remote, exclude)
+}
+
+// pruneDir walks a remote directory and removes entries missing locally
+func (c *Copier) pruneDir(local, remote string, exclude []string) error {
+	remoteEntries, err := c.client.ReadDir(remote)
+	if err != nil {
+		return fmt.Errorf("read destination directory: %w", err)
+	}
+
+	for _, remoteEntry := range remoteEntries {
+		localPath := filepath.Join(local, remoteEntry.Name())
+		remotePath := filepath.ToSlash(filepath.Join(remote, remoteEntry.Name()))
+
+		if util.IsExcluded(localPath, exclude) {
+			continue
+		}
+
+		if err := c.pruneEntry(localPath, remotePath, remoteEntry, exclude); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pruneEntry removes a remote entry whose local counterpart is missing or of a different type,
+// and descends into directories present on both sides
+func (c *Copier) pruneEntry(localPath, remotePath string, remoteEntry os.FileInfo, exclude []string) error {
+	localInfo, err := c.localStat(localPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("stat local file: %w", err)
+	}
+
+	if err != nil || localInfo.IsDir() != remoteEntry.IsDir() {
+		fmt.Fprintln(c.output(), "Deleting extraneous file:", remotePath)
+		if err := c.client.RemoveAll(remotePath); err != nil {
+			return fmt.Errorf("delete extraneous file %s: %w", remotePath, err)
+		}
+		return nil
+	}
+
+	if remoteEntry.IsDir() {
+		return c.pruneDir(localPath, remotePath, exclude)
+	}
+
+	return nil
+}
+
+// isEmptySource reports whether a local directory has no entries left after exclusions
+func isEmptySource(local string, exclude []string) (bool, error) {
+	entries, err := os.ReadDir(local)
+	if err != nil {
+		return false, fmt.Errorf("read source directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !util.IsExcluded(filepath.Join(local, entry.Name()), exclude) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,112 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// remoteTree returns a ReadDir implementation serving the given remote directory listing
+func remoteTree(tree map[string][]os.FileInfo) func(path string) ([]os.FileInfo, error) {
+	return func(path string) ([]os.FileInfo, error) {
+		return tree[path], nil
+	}
+}
+
+func remoteFile(name string) os.FileInfo {
+	return &MockFileInfo{NameFunc: func() string { return name }}
+}
+
+func remoteDir(name string) os.FileInfo {
+	return &MockFileInfo{NameFunc: func() string { return name }, IsDirFunc: func() bool { return true }}
+}
+
+func TestCopyDirDeletesExtraneousFiles(t *testing.T) {
+	tempDir, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	sourceDir := filepath.Join(tempDir, "local")
+	require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "keep"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "app.js"), []byte("content"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "keep", "index.html"), []byte("content"), 0644))
+
+	var removed []string
+	mockSFTP := &MockSFTPClient{
+		StatFunc: func(path string) (os.FileInfo, error) {
+			return nil, os.ErrNotExist
+		},
+		ReadDirFunc: remoteTree(map[string][]os.FileInfo{
+			"remote": {
+				remoteFile("app.js"),
+				remoteFile("old.js"),
+				remoteFile("server.log"),
+				remoteDir("keep"),
+				remoteDir("stale"),
+			},
+			"remote/keep": {remoteFile("index.html"), remoteFile("removed.html")},
+		}),
+		RemoveAllFunc: func(path string) error {
+			removed = append(removed, path)
+			return nil
+		},
+	}
+
+	copier := NewCopier(mockSFTP).WithDelete(true, false)
+	err := copier.CopyDir(sourceDir, "remote", []string{"*.log"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"remote/old.js", "remote/keep/removed.html", "remote/stale"}, removed)
+}
+
+func TestCopyDirWithoutDeleteKeepsExtraneousFiles(t *testing.T) {
+	tempDir, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	sourceDir := filepath.Join(tempDir, "local")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "app.js"), []byte("content"), 0644))
+
+	mockSFTP := &MockSFTPClient{
+		StatFunc: func(path string) (os.FileInfo, error) {
+			return nil, os.ErrNotExist
+		},
+		ReadDirFunc: func(path string) ([]os.FileInfo, error) {
+			t.Fatalf("remote directory should not be listed")
+			return nil, nil
+		},
+	}
+
+	assert.NoError(t, NewCopier(mockSFTP).CopyDir(sourceDir, "remote", nil))
+}
+
+func TestCopyDirDeleteRefusesEmptySource(t *testing.T) {
+	tempDir, cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	sourceDir := filepath.Join(tempDir, "local")
+	require.NoError(t, os.MkdirAll(sourceDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "debug.log"), []byte("content"), 0644))
+
+	var removed []string
+	mockSFTP := &MockSFTPClient{
+		ReadDirFunc: remoteTree(map[string][]os.FileInfo{
+			"remote": {remoteFile("app.js")},
+		}),
+		RemoveAllFunc: func(path string) error {
+			removed = append(removed, path)
+			return nil
+		},
+	}
+
+	err := NewCopier(mockSFTP).WithDelete(true, false).CopyDir(sourceDir, "remote", []string{"*.log"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "refusing to delete all files in remote")
+	assert.Empty(t, removed)
+
+	err = NewCopier(mockSFTP).WithDelete(true, true).CopyDir(sourceDir, "remote", []string{"*.log"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"remote/app.js"}, removed)
+}
@@ -0,0 +1,59 @@
+package fs
+
+import (
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the aggregate throughput of all writers created from it using a token bucket.
+// The bucket holds at most one second worth of tokens and starts empty.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a RateLimiter allowing bytesPerSecond bytes per second
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	return &RateLimiter{
+		rate: float64(bytesPerSecond),
+		last: time.Now(),
+	}
+}
+
+// WaitN blocks until n more bytes may be sent without exceeding the rate
+func (l *RateLimiter) WaitN(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// Writer wraps w so that every write is throttled by the limiter
+func (l *RateLimiter) Writer(w io.Writer) io.Writer {
+	return &rateLimitedWriter{writer: w, limiter: l}
+}
+
+// rateLimitedWriter is an io.Writer throttled by a RateLimiter
+type rateLimitedWriter struct {
+	writer  io.Writer
+	limiter *RateLimiter
+}
+
+// Write waits for the limiter and then writes p to the underlying writer
+func (w *rateLimitedWriter) Write(p []byte) (int, error) {
+	w.limiter.WaitN(len(p))
+	return w.writer.Write(p)
+}
@@ -0,0 +1,46 @@
+package fs
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterThrottlesWrites(t *testing.T) {
+	limiter := NewRateLimiter(1 << 20)
+	var buf bytes.Buffer
+
+	start := time.Now()
+	n, err := io.Copy(limiter.Writer(&buf), bytes.NewReader(make([]byte, 256<<10)))
+	elapsed := time.Since(start)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(256<<10), n)
+	assert.GreaterOrEqual(t, elapsed, 200*time.Millisecond)
+	assert.Less(t, elapsed, 600*time.Millisecond)
+}
+
+func TestRateLimiterSharedAcrossWriters(t *testing.T) {
+	limiter := NewRateLimiter(1 << 20)
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := io.Copy(limiter.Writer(io.Discard), bytes.NewReader(make([]byte, 64<<10)))
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	// Four writers of 64KB share a 1MB/s budget, so together they need about 0.25s
+	assert.GreaterOrEqual(t, elapsed, 200*time.Millisecond)
+	assert.Less(t, elapsed, 600*time.Millisecond)
+}
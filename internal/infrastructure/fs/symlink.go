@@ -0,0 +1,126 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nickalie/nship/internal/util"
+)
+
+// symlinkAction describes how a symlink found in a copied directory is copied
+type symlinkAction int
+
+const (
+	// recreateLink creates a symlink with the same target on the remote
+	recreateLink symlinkAction = iota
+	// copyLinkedFile uploads the file the link points to
+	copyLinkedFile
+	// copyLinkedDir copies the directory the link points to
+	copyLinkedDir
+	// skipCyclicLink skips a link pointing to a directory that contains it
+	skipCyclicLink
+)
+
+// WithFollowSymlinks returns a copy of the Copier that, when follow is true, copies the files and
+// directories symlinks point to instead of recreating the symlinks on the remote.
+func (c *Copier) WithFollowSymlinks(follow bool) *Copier {
+	copier := *c
+	copier.followSymlinks = follow
+	return &copier
+}
+
+// isSymlink reports whether a directory entry is a symbolic link
+func isSymlink(entry os.DirEntry) bool {
+	return entry.Type()&os.ModeSymlink != 0
+}
+
+// localStat returns the information of a local path, describing symlinks themselves unless they are followed
+func (c *Copier) localStat(path string) (os.FileInfo, error) {
+	if c.followSymlinks {
+		return os.Stat(path)
+	}
+	return os.Lstat(path)
+}
+
+// resolveSymlink decides how the symlink at localPath is copied
+func (c *Copier) resolveSymlink(localPath string) (symlinkAction, error) {
+	if !c.followSymlinks {
+		return recreateLink, nil
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return 0, fmt.Errorf("resolve symlink %s: %w", localPath, err)
+	}
+	if !info.IsDir() {
+		return copyLinkedFile, nil
+	}
+
+	cyclic, err := util.IsCyclicLink(localPath, info, os.Stat)
+	if err != nil {
+		return 0, fmt.Errorf("check symlink %s: %w", localPath, err)
+	}
+	if cyclic {
+		return skipCyclicLink, nil
+	}
+	return copyLinkedDir, nil
+}
+
+// processSymlink copies a symlink found in a copied directory
+func (c *Copier) processSymlink(localPath, remotePath string, exclude []string) error {
+	action, err := c.resolveSymlink(localPath)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case recreateLink:
+		return c.CopySymlink(localPath, remotePath)
+	case copyLinkedDir:
+		return c.copyDir(localPath, remotePath, exclude)
+	case skipCyclicLink:
+		fmt.Fprintln(c.output(), "Skipping cyclic symlink:", localPath)
+		return nil
+	default:
+		return c.transferFile(fileTransfer{local: localPath, remote: remotePath})
+	}
+}
+
+// collectSymlink handles a symlink found while creating the remote directory tree for a concurrent copy
+func (c *Copier) collectSymlink(localPath, remotePath string, exclude []string, files *[]fileTransfer) error {
+	action, err := c.resolveSymlink(localPath)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case recreateLink:
+		return c.CopySymlink(localPath, remotePath)
+	case copyLinkedDir:
+		return c.createDirTree(localPath, remotePath, exclude, files)
+	case skipCyclicLink:
+		fmt.Fprintln(c.output(), "Skipping cyclic symlink:", localPath)
+		return nil
+	default:
+		*files = append(*files, fileTransfer{local: localPath, remote: remotePath})
+		return nil
+	}
+}
+
+// CopySymlink recreates the local symlink at local as a symlink with the same target at remote,
+// replacing a file or symlink already there
+func (c *Copier) CopySymlink(local, remote string) error {
+	linkTarget, err := os.Readlink(local)
+	if err != nil {
+		return fmt.Errorf("read symlink: %w", err)
+	}
+
+	// A missing destination is the common case, and any other problem is reported by Symlink below
+	_ = c.client.Remove(remote)
+
+	if err := c.client.Symlink(filepath.ToSlash(linkTarget), remote); err != nil {
+		return fmt.Errorf("create symlink %s: %w", remote, err)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createSymlinkTree creates a directory with a regular file, a link to it, a link to a
+// directory outside the tree and a link back to the tree itself
+func createSymlinkTree(t *testing.T) string {
+	root := t.TempDir()
+	src := filepath.Join(root, "src")
+	shared := filepath.Join(root, "shared")
+
+	require.NoError(t, os.MkdirAll(src, 0755))
+	require.NoError(t, os.MkdirAll(shared, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "app.conf"), []byte("conf"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(shared, "lib.js"), []byte("lib"), 0644))
+	require.NoError(t, os.Symlink("app.conf", filepath.Join(src, "current.conf")))
+	require.NoError(t, os.Symlink(shared, filepath.Join(src, "shared")))
+	require.NoError(t, os.Symlink(".", filepath.Join(src, "self")))
+
+	return src
+}
+
+// recordingSFTPClient records the created files and symlinks
+func recordingSFTPClient() (*MockSFTPClient, *[]string, map[string]string) {
+	var mu sync.Mutex
+	created := []string{}
+	links := map[string]string{}
+
+	client := &MockSFTPClient{
+		StatFunc: func(path string) (os.FileInfo, error) {
+			return nil, os.ErrNotExist
+		},
+		CreateFunc: func(path string) (io.WriteCloser, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			created = append(created, strings.TrimSuffix(path, tempSuffix))
+			return &MockWriteCloser{}, nil
+		},
+		SymlinkFunc: func(oldname, newname string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			links[newname] = oldname
+			return nil
+		},
+	}
+	return client, &created, links
+}
+
+func TestCopyDirRecreatesSymlinks(t *testing.T) {
+	src := createSymlinkTree(t)
+
+	for _, concurrency := range []int{1, 4} {
+		client, created, links := recordingSFTPClient()
+
+		err := NewCopierWithConcurrency(client, concurrency).CopyDir(src, "/app", nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"/app/app.conf"}, *created, "only regular files should be uploaded")
+		assert.Equal(t, map[string]string{
+			"/app/current.conf": "app.conf",
+			"/app/shared":       filepath.ToSlash(filepath.Join(filepath.Dir(src), "shared")),
+			"/app/self":         ".",
+		}, links)
+	}
+}
+
+func TestCopyDirFollowsSymlinks(t *testing.T) {
+	src := createSymlinkTree(t)
+
+	for _, concurrency := range []int{1, 4} {
+		client, created, links := recordingSFTPClient()
+
+		err := NewCopierWithConcurrency(client, concurrency).WithFollowSymlinks(true).CopyDir(src, "/app", nil)
+		require.NoError(t, err, "a cyclic symlink should not cause an endless copy")
+
+		sort.Strings(*created)
+		assert.Equal(t, []string{"/app/app.conf", "/app/current.conf", "/app/shared/lib.js"}, *created)
+		assert.Empty(t, links)
+	}
+}
+
+func TestPruneKeepsRecreatedSymlinks(t *testing.T) {
+	src := createSymlinkTree(t)
+	removed := []string{}
+
+	client := &MockSFTPClient{
+		ReadDirFunc: func(path string) ([]os.FileInfo, error) {
+			return []os.FileInfo{
+				&MockFileInfo{NameFunc: func() string { return "app.conf" }},
+				&MockFileInfo{NameFunc: func() string { return "shared" }, ModeFunc: func() os.FileMode { return os.ModeSymlink }},
+			}, nil
+		},
+		RemoveAllFunc: func(path string) error {
+			removed = append(removed, path)
+			return nil
+		},
+	}
+
+	err := NewCopier(client).WithDelete(true, false).pruneExtraneous(src, "/app", nil)
+	require.NoError(t, err)
+	assert.Empty(t, removed, "a symlink to a directory should match the recreated remote symlink")
+}
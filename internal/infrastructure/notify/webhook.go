@@ -0,0 +1,91 @@
+// Package notify sends notifications about finished deployments.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// StatusSuccess marks a deployment in which all jobs succeeded
+	StatusSuccess = "success"
+	// StatusFailure marks a deployment in which a job failed
+	StatusFailure = "failure"
+)
+
+// defaultTimeout limits how long posting a notification may take
+const defaultTimeout = 10 * time.Second
+
+// Event describes the outcome of a deployment. Text summarizes it for chat webhooks
+// such as Slack that display a text field.
+type Event struct {
+	Text     string   `json:"text"`
+	Targets  []string `json:"targets"`
+	Jobs     []string `json:"jobs"`
+	Status   string   `json:"status"`
+	Duration string   `json:"duration"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// NewEvent creates an event for a deployment that took duration and failed with err, if not nil
+func NewEvent(targets, jobs []string, duration time.Duration, err error) Event {
+	event := Event{
+		Targets:  targets,
+		Jobs:     jobs,
+		Status:   StatusSuccess,
+		Duration: duration.Round(time.Millisecond).String(),
+	}
+
+	if err != nil {
+		event.Status = StatusFailure
+		event.Error = err.Error()
+	}
+
+	event.Text = fmt.Sprintf("nship deployment of [%s] to [%s] finished with %s after %s",
+		strings.Join(jobs, ", "), strings.Join(targets, ", "), event.Status, event.Duration)
+	if err != nil {
+		event.Text += ": " + event.Error
+	}
+
+	return event
+}
+
+// WebhookNotifier posts events as JSON to a webhook URL
+type WebhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier creates a notifier posting to url
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return NewWebhookNotifierWithClient(url, &http.Client{Timeout: defaultTimeout})
+}
+
+// NewWebhookNotifierWithClient creates a notifier posting to url with the given HTTP client
+func NewWebhookNotifierWithClient(url string, client *http.Client) *WebhookNotifier {
+	return &WebhookNotifier{url: url, client: client}
+}
+
+// Notify posts the event to the webhook and fails on a non-2xx response
+func (n *WebhookNotifier) Notify(event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewEvent(t *testing.T) {
+	event := NewEvent([]string{"web"}, []string{"deploy"}, 1500*time.Millisecond, nil)
+	assert.Equal(t, StatusSuccess, event.Status)
+	assert.Equal(t, "1.5s", event.Duration)
+	assert.Empty(t, event.Error)
+	assert.Equal(t, "nship deployment of [deploy] to [web] finished with success after 1.5s", event.Text)
+
+	event = NewEvent([]string{"web"}, []string{"deploy"}, time.Second, errors.New("boom"))
+	assert.Equal(t, StatusFailure, event.Status)
+	assert.Equal(t, "boom", event.Error)
+	assert.True(t, strings.HasSuffix(event.Text, "failure after 1s: boom"))
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	event := NewEvent([]string{"web1", "web2"}, []string{"deploy"}, time.Second, errors.New("boom"))
+	assert.NoError(t, NewWebhookNotifier(server.URL).Notify(event))
+	assert.Equal(t, event, received)
+}
+
+func TestWebhookNotifierErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	err := NewWebhookNotifier(server.URL).Notify(Event{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "500")
+}
+
+func TestWebhookNotifierUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	err := NewWebhookNotifier(url).Notify(Event{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to post notification")
+}
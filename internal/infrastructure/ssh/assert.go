@@ -0,0 +1,91 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/util"
+)
+
+// exitCode returns the exit code of a command that failed with err, and false if it did not exit on its own,
+// e.g. because the connection was lost. Remote commands fail with ssh.ExitError, those of local targets with
+// exec.ExitError.
+func exitCode(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+
+	var remote *ssh.ExitError
+	if errors.As(err, &remote) {
+		return remote.ExitStatus(), true
+	}
+	var local interface{ ExitCode() int }
+	if errors.As(err, &local) && local.ExitCode() >= 0 {
+		return local.ExitCode(), true
+	}
+	return 0, false
+}
+
+// executeAssert runs the command of an assert step and fails unless its exit code and output match the
+// expected ones, printing what was expected and what the command did for each mismatch. Secrets are masked
+// in the command and output shown.
+func (c *SSHClient) executeAssert(ctx context.Context, step *job.Step, stepNum, totalSteps int) error {
+	check := step.Assert
+	command := c.secrets.Redact(check.Command)
+	fmt.Fprintf(c.out.Stdout(), "[%d/%d] Asserting '%s'...\n", stepNum, totalSteps, command)
+
+	session, err := c.sshClient.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	var output bytes.Buffer
+	err = c.runWithSudoCheck(ctx, step, func(stderr io.Writer) error {
+		return c.runShell(ctx, session, c.stepShell(step), check.Command,
+			io.MultiWriter(c.stdout(ctx), &output), io.MultiWriter(stderr, &output))
+	})
+	code, exited := exitCode(err)
+	if !exited || ctx.Err() != nil {
+		return fmt.Errorf("failed to run assertion '%s': %w", command, err)
+	}
+
+	mismatches := assertMismatches(check, code, output.String())
+	if len(mismatches) == 0 {
+		fmt.Fprintln(c.out.Stdout(), util.Success(fmt.Sprintf("[%d/%d] Assertion passed", stepNum, totalSteps)))
+		return nil
+	}
+	for i, mismatch := range mismatches {
+		mismatches[i] = c.secrets.Redact(mismatch)
+		fmt.Fprintf(c.out.Stderr(), "[%d/%d] %s\n", stepNum, totalSteps, mismatches[i])
+	}
+	return fmt.Errorf("assertion '%s' failed: %s", command, strings.Join(mismatches, "; "))
+}
+
+// assertMismatches compares the exit code and output of the command of an assert step with the expected ones
+// and describes each mismatch with the expected and actual value
+func assertMismatches(check *job.AssertStep, code int, output string) []string {
+	var mismatches []string
+	if code != check.ExitCode {
+		mismatches = append(mismatches, fmt.Sprintf("expected exit code %d, got %d", check.ExitCode, code))
+	}
+	if check.Contains != "" && !strings.Contains(output, check.Contains) {
+		mismatches = append(mismatches, fmt.Sprintf("expected output to contain '%s', got '%s'",
+			check.Contains, lastLine(output)))
+	}
+	return mismatches
+}
+
+// lastLine returns the last non-empty line of output, which is usually enough to see what a check printed
+// without repeating all of its output, which was already shown
+func lastLine(output string) string {
+	output = strings.TrimRight(output, "\r\n")
+	return strings.TrimSpace(output[strings.LastIndex(output, "\n")+1:])
+}
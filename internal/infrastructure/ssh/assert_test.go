@@ -0,0 +1,91 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+	"github.com/nickalie/nship/internal/util"
+)
+
+func TestExitCode(t *testing.T) {
+	code, exited := exitCode(nil)
+	assert.True(t, exited)
+	assert.Equal(t, 0, code)
+
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	code, exited = exitCode(&job.CommandError{Command: "exit 3", Cause: err})
+	assert.True(t, exited)
+	assert.Equal(t, 3, code)
+
+	_, exited = exitCode(errors.New("connection lost"))
+	assert.False(t, exited, "errors other than exit codes should not be compared")
+}
+
+func TestAssertMismatches(t *testing.T) {
+	check := &job.AssertStep{Command: "curl -s localhost/health", ExitCode: 0, Contains: "ok"}
+	assert.Empty(t, assertMismatches(check, 0, "status: ok\n"))
+	assert.Equal(t, []string{"expected exit code 0, got 7", "expected output to contain 'ok', got 'status: down'"},
+		assertMismatches(check, 7, "checking\nstatus: down\n\n"))
+
+	check = &job.AssertStep{Command: "test -f /etc/app.conf", ExitCode: 1}
+	assert.Empty(t, assertMismatches(check, 1, ""))
+}
+
+func TestExecuteAssert(t *testing.T) {
+	client, _, out := newLocalTestClient(t, &target.Target{Host: "localhost", Local: true})
+
+	step := &job.Step{Assert: &job.AssertStep{Command: "echo healthy", Contains: "healthy"}}
+	require.NoError(t, client.ExecuteStep(step, 1, 1))
+	assert.Contains(t, out.String(), "Assertion passed")
+
+	step = &job.Step{Assert: &job.AssertStep{Command: "echo missing >&2; exit 2", ExitCode: 2, Contains: "missing"}}
+	require.NoError(t, client.ExecuteStep(step, 1, 1), "the expected exit code and standard error should match")
+
+	out.Reset()
+	step = &job.Step{Assert: &job.AssertStep{Command: "echo starting; exit 1", Contains: "healthy"}}
+	err := client.ExecuteStep(step, 1, 1)
+	assert.EqualError(t, err, "assertion 'echo starting; exit 1' failed: expected exit code 0, got 1; "+
+		"expected output to contain 'healthy', got 'starting'")
+	assert.Contains(t, out.String(), "expected exit code 0, got 1", "mismatches should be printed")
+}
+
+func TestExecuteAssertRedactsSecrets(t *testing.T) {
+	secrets := util.NewSecretRegistry()
+	secrets.Add("hunter2")
+	client, _, _ := newLocalTestClient(t, &target.Target{Host: "localhost", Local: true})
+	client.secrets = secrets
+	var stdout, stderr bytes.Buffer
+	client.SetOutput(job.NewOutput(&stdout, &stderr))
+
+	step := &job.Step{Assert: &job.AssertStep{Command: "echo token=hunter2", Contains: "healthy"}}
+	err := client.ExecuteStep(step, 1, 1)
+	assert.EqualError(t, err, "assertion 'echo token=***' failed: expected output to contain 'healthy', got 'token=***'")
+	assert.NotContains(t, stdout.String()+stderr.String(), "hunter2", "secrets should be masked in the output")
+	assert.Contains(t, stderr.String(), "expected output to contain 'healthy'", "mismatches should be printed to stderr")
+	assert.NotContains(t, stdout.String(), "expected output to contain")
+}
+
+func TestExecuteAssertConnectionLost(t *testing.T) {
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				return &MockSSHSession{WaitFunc: func() error { return errors.New("EOF") }}, nil
+			},
+		},
+		target: &target.Target{Name: "test-target"},
+		out:    job.NewOutput(io.Discard, io.Discard),
+	}
+
+	step := &job.Step{Assert: &job.AssertStep{Command: "true"}}
+	err := client.ExecuteStep(step, 1, 1)
+	assert.ErrorContains(t, err, "failed to run assertion 'true'")
+	assert.NotContains(t, err.Error(), "exit code", "a command that didn't exit should not be compared")
+}
@@ -6,13 +6,21 @@
 package ssh
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/nickalie/nship/internal/core/job"
 	"github.com/nickalie/nship/internal/core/target"
 	"github.com/nickalie/nship/internal/infrastructure/fs"
+	"github.com/nickalie/nship/internal/util"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
@@ -23,17 +31,57 @@ type SSHClient struct {
 	sftpClient SFTPClientInterface
 	copier     fs.Copier
 	target     *target.Target
+	sink       *outputSink
+	secrets    *util.SecretRegistry
+	// out receives the progress messages and the output of local commands
+	out job.Output
+	// warnings raised while connecting are printed to the standard error of out before the first step
+	warnings []string
+	warnOnce sync.Once
 }
 
 // ClientFactory implements job.ClientFactory using SSH
 type ClientFactory struct {
 	sshDialer     SSHDialer
 	sftpConnector SFTPConnector
+	uploadLimiter *fs.RateLimiter
+	hashStorage   *RemoteHashStorage
+	output        *outputSink
+	debug         *slog.Logger
+	secrets       *util.SecretRegistry
 }
 
-// SSHDialer defines an interface for creating SSH connections
+// ClientFactoryOption represents an option for configuring a ClientFactory
+type ClientFactoryOption func(*ClientFactory)
+
+// WithMaxUploadRate limits the combined upload rate of all clients created by the factory
+// to bytesPerSecond. Zero disables throttling.
+func WithMaxUploadRate(bytesPerSecond int64) ClientFactoryOption {
+	return func(f *ClientFactory) {
+		if bytesPerSecond > 0 {
+			f.uploadLimiter = fs.NewRateLimiter(bytesPerSecond)
+		}
+	}
+}
+
+// WithOutput sets how the clients created by the factory write the output of remote commands.
+// The clients share the output, so their lines never interleave.
+func WithOutput(options OutputOptions) ClientFactoryOption {
+	return func(f *ClientFactory) {
+		f.output = newOutputSink(os.Stdout, os.Stderr, options)
+	}
+}
+
+// WithSecrets masks the values of secrets in the output of the commands run by the clients created by the factory
+func WithSecrets(secrets *util.SecretRegistry) ClientFactoryOption {
+	return func(f *ClientFactory) {
+		f.secrets = secrets
+	}
+}
+
+// SSHDialer defines an interface for creating SSH connections. Dialing gives up once ctx is done.
 type SSHDialer interface {
-	Dial(network, addr string, config *ssh.ClientConfig) (*ssh.Client, error)
+	Dial(ctx context.Context, network, addr string, config *ssh.ClientConfig) (*ssh.Client, error)
 }
 
 // SFTPConnector defines an interface for creating SFTP clients
@@ -41,12 +89,16 @@ type SFTPConnector interface {
 	NewClient(sshClient *ssh.Client) (*sftp.Client, error)
 }
 
-// DefaultSSHDialer implements SSHDialer using ssh.Dial
+// DefaultSSHDialer implements SSHDialer over TCP like ssh.Dial
 type DefaultSSHDialer struct{}
 
 // Dial creates a new SSH connection
-func (d *DefaultSSHDialer) Dial(network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
-	return ssh.Dial(network, addr, config)
+func (d *DefaultSSHDialer) Dial(ctx context.Context, network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := (&net.Dialer{Timeout: config.Timeout}).DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return newClientConn(ctx, nil, conn, addr, config)
 }
 
 // DefaultSFTPConnector implements SFTPConnector using sftp.NewClient
@@ -58,11 +110,17 @@ func (c *DefaultSFTPConnector) NewClient(sshClient *ssh.Client) (*sftp.Client, e
 }
 
 // NewClientFactory creates a new SSH client factory with default implementations
-func NewClientFactory() *ClientFactory {
-	return &ClientFactory{
+func NewClientFactory(opts ...ClientFactoryOption) *ClientFactory {
+	factory := &ClientFactory{
 		sshDialer:     &DefaultSSHDialer{},
 		sftpConnector: &DefaultSFTPConnector{},
 	}
+
+	for _, opt := range opts {
+		opt(factory)
+	}
+
+	return factory
 }
 
 // NewClientFactoryWithDeps creates a new SSH client factory with custom dependencies
@@ -73,38 +131,125 @@ func NewClientFactoryWithDeps(dialer SSHDialer, connector SFTPConnector) *Client
 	}
 }
 
-// NewClient creates a new SSH client for the given target
+// NewClient creates a new SSH client for the given target, or a client running the steps locally for local targets
 func (f *ClientFactory) NewClient(tgt *target.Target) (job.Client, error) {
+	return f.NewClientContext(context.Background(), tgt)
+}
+
+// NewClientContext implements job.ContextClientFactory. Dialing, the proxy command and the handshake
+// are given up once ctx is done.
+func (f *ClientFactory) NewClientContext(ctx context.Context, tgt *target.Target) (job.Client, error) {
+	if tgt.Local {
+		return f.newLocalClient(tgt)
+	}
+
 	sshConfig := &ssh.ClientConfig{
-		User:            tgt.User,
-		Auth:            getAuthMethods(tgt),
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         5 * time.Second,
+		Config: ssh.Config{
+			Ciphers:      tgt.Ciphers,
+			MACs:         tgt.MACs,
+			KeyExchanges: tgt.KexAlgorithms,
+		},
+		User:              tgt.User,
+		Auth:              getAuthMethods(tgt),
+		HostKeyCallback:   ssh.InsecureIgnoreHostKey(),
+		HostKeyAlgorithms: tgt.HostKeyAlgorithms,
+		Timeout:           5 * time.Second,
 	}
 
-	sshClient, err := f.sshDialer.Dial("tcp", fmt.Sprintf("%s:%d", tgt.Host, tgt.GetPort()), sshConfig)
+	var logger *slog.Logger
+	if f.debug != nil {
+		logger = f.debug.With("target", tgt.GetName())
+		sshConfig.Auth = debugAuthMethods(logger, tgt)
+		sshConfig.BannerCallback = debugBanner(logger)
+	}
+
+	sshClient, err := f.dialer(tgt, logger).Dial(ctx, "tcp", fmt.Sprintf("%s:%d", tgt.Host, tgt.GetPort()), sshConfig)
 	if err != nil {
 		return nil, &job.ConnectionError{
 			Target: tgt.GetName(),
-			Cause:  err,
+			Cause:  explainHandshakeError(err, tgt),
 		}
 	}
 
-	sftpClient, err := f.sftpConnector.NewClient(sshClient)
+	sessions := newSessionLimiter(NewSSHAdapter(sshClient), tgt.GetMaxSessions())
+	files, warning, err := f.fileClient(tgt, sshClient, sessions)
 	if err != nil {
 		sshClient.Close()
-		return nil, fmt.Errorf("SFTP connection failed: %w", err)
+		return nil, err
 	}
 
-	sftpAdapter := NewSFTPAdapter(sftpClient)
-	copier := fs.NewCopier(sftpAdapter)
+	if f.hashStorage != nil {
+		f.hashStorage.Attach(tgt.GetName(), files)
+	}
+	copier := fs.NewCopier(files).WithRateLimiter(f.uploadLimiter)
 
-	return &SSHClient{
-		sshClient:  NewSSHAdapter(sshClient),
-		sftpClient: sftpAdapter,
+	client := &SSHClient{
+		sshClient:  sessions,
+		sftpClient: files,
 		copier:     *copier,
 		target:     tgt,
-	}, nil
+		sink:       f.output,
+		secrets:    f.secrets,
+	}
+	if warning != "" {
+		client.warnings = append(client.warnings, warning)
+	}
+	return client, nil
+}
+
+// fileClient returns the client transferring files to tgt with its transfer method. With auto, SFTP is used
+// unless the server rejects the SFTP subsystem, in which case the scp client opens its sessions with sessions
+// and a warning about the fallback is returned. Other SFTP errors are returned as they are. The SFTP subsystem
+// occupies one session of the connection for its whole lifetime, so it takes a slot of sessions for good.
+func (f *ClientFactory) fileClient(tgt *target.Target, sshClient *ssh.Client, sessions *sessionLimiter) (
+	files SFTPClientInterface, warning string, err error) {
+	method := tgt.GetTransferMethod()
+	if method != target.TransferSCP {
+		sftpClient, err := f.sftpConnector.NewClient(sshClient)
+		switch {
+		case err == nil:
+			sessions.reserve()
+			return newReopeningSFTP(NewSFTPAdapter(sftpClient), f.sftpOpener(sshClient)), "", nil
+		case method == target.TransferSFTP || tgt.IsWindows() || !isSubsystemRejected(err):
+			return nil, "", fmt.Errorf("SFTP connection failed: %w", err)
+		}
+		warning = fmt.Sprintf("Warning: SFTP is not available on '%s' (%v), falling back to scp", tgt.GetName(), err)
+	}
+
+	if tgt.IsWindows() {
+		return nil, "", fmt.Errorf("scp transfers are not supported on Windows target '%s'", tgt.GetName())
+	}
+	return newSCPClient(sessions), warning, nil
+}
+
+// sftpOpener returns the function opening another SFTP client over sshClient, used after the previous one
+// was interrupted
+func (f *ClientFactory) sftpOpener(sshClient *ssh.Client) func() (SFTPClientInterface, error) {
+	return func() (SFTPClientInterface, error) {
+		sftpClient, err := f.sftpConnector.NewClient(sshClient)
+		if err != nil {
+			return nil, fmt.Errorf("SFTP connection failed: %w", err)
+		}
+		return NewSFTPAdapter(sftpClient), nil
+	}
+}
+
+// isSubsystemRejected reports whether err is the error of crypto/ssh for a subsystem request the server rejected,
+// which has no sentinel to compare with
+func isSubsystemRejected(err error) bool {
+	return strings.Contains(err.Error(), "ssh: subsystem request failed")
+}
+
+// dialer returns the dialer connecting to tgt, which logs to logger unless it is nil
+func (f *ClientFactory) dialer(tgt *target.Target, logger *slog.Logger) SSHDialer {
+	switch {
+	case tgt.ProxyCommand != "":
+		return newProxyCommandDialer(tgt, logger)
+	case logger != nil:
+		return &debugDialer{logger: logger}
+	default:
+		return f.sshDialer
+	}
 }
 
 // NewSSHClientWithDeps creates a new SSH client with provided dependencies
@@ -119,17 +264,131 @@ func NewSSHClientWithDeps(sshClient SSHClientInterface, sftpClient SFTPClientInt
 }
 
 // ExecuteStep implements the Client interface by executing a single deployment step.
+// Failures caused by a lost connection are reported as job.ConnectionError.
 func (c *SSHClient) ExecuteStep(step *job.Step, stepNum, totalSteps int) error {
-	switch step.GetType() {
-	case job.RunStep:
-		return c.executeCommand(step, stepNum, totalSteps)
-	case job.CopyStepType:
-		return c.executeCopy(step.Copy, stepNum, totalSteps)
-	case job.DockerStepType:
-		return c.executeDocker(step, stepNum, totalSteps)
-	default:
+	return c.ExecuteStepContext(context.Background(), step, stepNum, totalSteps)
+}
+
+// ExecuteStepContext implements job.ContextClient. When ctx is cancelled, a running remote command
+// is sent SIGTERM and transfers in progress are aborted. The file client stays usable for cleanup steps.
+func (c *SSHClient) ExecuteStepContext(ctx context.Context, step *job.Step, stepNum, totalSteps int) error {
+	c.warnOnce.Do(c.printWarnings)
+	if err := c.checkPlatform(step); err != nil {
+		return err
+	}
+
+	stop := context.AfterFunc(ctx, c.interruptTransfers)
+	defer stop()
+
+	if tail := job.OutputTailFrom(ctx); tail != nil {
+		tail.Reset()
+	}
+	err := c.executeStep(ctx, step, stepNum, totalSteps)
+	if err != nil && ctx.Err() != nil {
+		return fmt.Errorf("step cancelled: %w", ctx.Err())
+	}
+	if err != nil && !job.IsConnectionError(err) && isTransportError(err) {
+		return &job.ConnectionError{Target: c.target.GetName(), Cause: err}
+	}
+	return err
+}
+
+// isTransportError reports whether err was caused by the SSH connection rather than the executed step,
+// e.g. a session that ended without an exit status or a closed connection
+func isTransportError(err error) bool {
+	var exitMissing *ssh.ExitMissingError
+	var netErr net.Error
+	return errors.As(err, &exitMissing) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, sftp.ErrSSHFxConnectionLost)
+}
+
+// stepHandler executes a step of one type
+type stepHandler func(c *SSHClient, ctx context.Context, step *job.Step, stepNum, totalSteps int) error
+
+// stepHandlers maps each step type to the method executing it
+var stepHandlers = map[job.StepType]stepHandler{
+	job.RunStep:             (*SSHClient).executeCommand,
+	job.AssertStepType:      (*SSHClient).executeAssert,
+	job.CopyStepType:        (*SSHClient).executeCopyStep,
+	job.DockerStepType:      (*SSHClient).executeDocker,
+	job.DockerPruneStepType: (*SSHClient).executeDockerPrune,
+	job.DockerExecStepType:  (*SSHClient).executeDockerExec,
+	job.RunScriptStepType:   (*SSHClient).executeRunScript,
+	job.ServiceStepType:     (*SSHClient).executeService,
+	job.DownloadStepType: func(c *SSHClient, _ context.Context, step *job.Step, stepNum, totalSteps int) error {
+		return c.executeDownload(step.Download, stepNum, totalSteps)
+	},
+	job.WaitStepType: func(c *SSHClient, ctx context.Context, step *job.Step, stepNum, totalSteps int) error {
+		return c.executeWait(ctx, step.Wait, stepNum, totalSteps)
+	},
+	job.ExecStepType: func(c *SSHClient, ctx context.Context, step *job.Step, stepNum, totalSteps int) error {
+		return c.executeExec(ctx, step.Exec, stepNum, totalSteps)
+	},
+}
+
+// executeStep runs the step according to its type
+func (c *SSHClient) executeStep(ctx context.Context, step *job.Step, stepNum, totalSteps int) error {
+	handler, ok := stepHandlers[step.GetType()]
+	if !ok {
 		return fmt.Errorf("invalid step configuration")
 	}
+	return handler(c, ctx, step, stepNum, totalSteps)
+}
+
+// output returns the sink for the output of remote commands
+func (c *SSHClient) output() *outputSink {
+	if c.sink == nil {
+		return defaultOutput
+	}
+	return c.sink
+}
+
+// stdout returns the writer for the standard output of the commands of the step executed with ctx,
+// which is also kept in the output tail of ctx
+func (c *SSHClient) stdout(ctx context.Context) io.Writer {
+	return withOutputTail(ctx, c.output().Stdout())
+}
+
+// stderr returns the writer for the standard error of the commands of the step executed with ctx,
+// which is also kept in the output tail of ctx
+func (c *SSHClient) stderr(ctx context.Context) io.Writer {
+	return withOutputTail(ctx, c.output().Stderr())
+}
+
+// withOutputTail returns a writer writing to w and to the output tail of ctx, if it carries one
+func withOutputTail(ctx context.Context, w io.Writer) io.Writer {
+	if tail := job.OutputTailFrom(ctx); tail != nil {
+		return io.MultiWriter(w, tail)
+	}
+	return w
+}
+
+// SetOutput implements job.OutputClient. The output of remote commands keeps the options of the sink of the client.
+func (c *SSHClient) SetOutput(out job.Output) {
+	c.out = out
+	c.sink = newOutputSink(out.Stdout(), out.Stderr(), c.output().options)
+	c.copier = *c.copier.WithOutput(out.Stdout())
+}
+
+// printWarnings prints the warnings raised while connecting to the standard error of the client
+func (c *SSHClient) printWarnings() {
+	for _, warning := range c.warnings {
+		fmt.Fprintln(c.out.Stderr(), warning)
+	}
+}
+
+// interruptTransfers fails the transfers in progress. File clients that can't be interrupted are closed.
+func (c *SSHClient) interruptTransfers() {
+	switch files := c.sftpClient.(type) {
+	case nil:
+	case interrupter:
+		files.Interrupt()
+	default:
+		_ = files.Close()
+	}
 }
 
 // Close implements the Client interface by releasing resources.
@@ -158,15 +417,12 @@ func getAuthMethods(tgt *target.Target) []ssh.AuthMethod {
 	return methods
 }
 
+// loadPrivateKey returns an auth method for the private key in keyPath, parsing the key only
+// once for all targets using it
 func loadPrivateKey(keyPath string) (ssh.AuthMethod, error) {
-	key, err := os.ReadFile(keyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read private key: %w", err)
-	}
-
-	signer, err := ssh.ParsePrivateKey(key)
+	signer, err := privateKeys.signer(keyPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %w", err)
+		return nil, err
 	}
 
 	return ssh.PublicKeys(signer), nil
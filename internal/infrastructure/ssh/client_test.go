@@ -1,14 +1,25 @@
 package ssh
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"io"
+	"math/rand/v2"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nickalie/nship/internal/core/job"
 	"github.com/nickalie/nship/internal/core/target"
+	"github.com/nickalie/nship/internal/infrastructure/fs"
+	"github.com/nickalie/nship/internal/util"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
 )
 
 // MockSSHSession for testing
@@ -17,6 +28,9 @@ type MockSSHSession struct {
 	WaitFunc       func() error
 	StdoutPipeFunc func() (io.Reader, error)
 	StderrPipeFunc func() (io.Reader, error)
+	StdinPipeFunc  func() (io.WriteCloser, error)
+	SetenvFunc     func(name, value string) error
+	SignalFunc     func(ssh.Signal) error
 	CloseFunc      func() error
 }
 
@@ -48,6 +62,36 @@ func (m *MockSSHSession) StderrPipe() (io.Reader, error) {
 	return &MockReader{}, nil
 }
 
+func (m *MockSSHSession) StdinPipe() (io.WriteCloser, error) {
+	if m.StdinPipeFunc != nil {
+		return m.StdinPipeFunc()
+	}
+	return nopWriteCloser{io.Discard}, nil
+}
+
+// nopWriteCloser adds a no-op Close method to an io.Writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func (m *MockSSHSession) Setenv(name, value string) error {
+	if m.SetenvFunc != nil {
+		return m.SetenvFunc(name, value)
+	}
+	return nil
+}
+
+func (m *MockSSHSession) Signal(sig ssh.Signal) error {
+	if m.SignalFunc != nil {
+		return m.SignalFunc(sig)
+	}
+	return nil
+}
+
 func (m *MockSSHSession) Close() error {
 	if m.CloseFunc != nil {
 		return m.CloseFunc()
@@ -95,6 +139,14 @@ func (m *MockSFTPClient) Create(path string) (io.WriteCloser, error) {
 	return nil, errors.New("not implemented")
 }
 
+func (m *MockSFTPClient) CreateExclusive(path string) (io.WriteCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *MockSFTPClient) Open(path string) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
 func (m *MockSFTPClient) MkdirAll(path string) error {
 	return errors.New("not implemented")
 }
@@ -107,6 +159,30 @@ func (m *MockSFTPClient) Stat(path string) (os.FileInfo, error) {
 	return nil, errors.New("not implemented")
 }
 
+func (m *MockSFTPClient) Chtimes(path string, atime, mtime time.Time) error {
+	return errors.New("not implemented")
+}
+
+func (m *MockSFTPClient) ReadDir(path string) ([]os.FileInfo, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (m *MockSFTPClient) Remove(path string) error {
+	return errors.New("not implemented")
+}
+
+func (m *MockSFTPClient) RemoveAll(path string) error {
+	return errors.New("not implemented")
+}
+
+func (m *MockSFTPClient) Rename(oldname, newname string) error {
+	return errors.New("not implemented")
+}
+
+func (m *MockSFTPClient) Symlink(oldname, newname string) error {
+	return errors.New("not implemented")
+}
+
 // MockReader implements io.Reader for testing
 type MockReader struct {
 	ReadFunc func(p []byte) (n int, err error)
@@ -157,6 +233,135 @@ func TestExecuteStep_RunStep(t *testing.T) {
 	assert.True(t, sftpClient.closed, "SFTP client was not closed")
 }
 
+func TestExecuteStepKeepsOutputTail(t *testing.T) {
+	var output string
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				return &MockSSHSession{
+					StdoutPipeFunc: func() (io.Reader, error) { return strings.NewReader(output), nil },
+					StderrPipeFunc: func() (io.Reader, error) { return strings.NewReader("warning\n"), nil },
+				}, nil
+			},
+		},
+		target: &target.Target{Name: "test-target"},
+		sink:   newOutputSink(io.Discard, io.Discard, OutputOptions{}),
+	}
+
+	first := &job.OutputTail{}
+	output = "first\n"
+	require.NoError(t, client.ExecuteStepContext(job.WithOutputTail(context.Background(), first), &job.Step{Run: "echo first"}, 1, 2))
+	assert.ElementsMatch(t, []string{"first", "warning"}, strings.Split(first.String(), "\n"))
+
+	second := &job.OutputTail{}
+	output = "second\n"
+	require.NoError(t, client.ExecuteStepContext(job.WithOutputTail(context.Background(), second), &job.Step{Run: "echo second"}, 2, 2))
+	assert.NotContains(t, second.String(), "first", "the tail should only hold the output of its step")
+	assert.Contains(t, second.String(), "second")
+	assert.NotContains(t, first.String(), "second", "later steps should not change the tail of a step")
+}
+
+func TestExecuteStepSetOutput(t *testing.T) {
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				return &MockSSHSession{
+					StdoutPipeFunc: func() (io.Reader, error) { return strings.NewReader("deployed\n"), nil },
+					StderrPipeFunc: func() (io.Reader, error) { return strings.NewReader("warning\n"), nil },
+				}, nil
+			},
+		},
+		target: &target.Target{Name: "test-target"},
+		sink:   newOutputSink(io.Discard, io.Discard, OutputOptions{Merge: true}),
+	}
+
+	var stdout, stderr bytes.Buffer
+	client.SetOutput(job.NewOutput(&stdout, &stderr))
+	require.NoError(t, client.ExecuteStep(&job.Step{Run: "deploy"}, 1, 1))
+	require.NoError(t, client.ExecuteStep(&job.Step{Wait: &job.WaitStep{Duration: "1ms"}}, 1, 1))
+
+	output := stdout.String()
+	assert.Contains(t, output, "[1/1] Executing command...\n")
+	assert.Contains(t, output, "[stdout] deployed\n")
+	assert.Contains(t, output, "[stderr] warning\n", "the options of the sink should be kept")
+	assert.Contains(t, output, "[1/1] Waiting 1ms...\n")
+	assert.Empty(t, stderr.String())
+}
+
+func TestExecuteStep_WaitStep(t *testing.T) {
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				t.Fatal("wait step should not open an SSH session")
+				return nil, nil
+			},
+		},
+		target: &target.Target{Name: "test-target"},
+	}
+
+	start := time.Now()
+	err := client.ExecuteStep(&job.Step{Wait: &job.WaitStep{Duration: "50ms"}}, 1, 1)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+	err = client.ExecuteStep(&job.Step{Wait: &job.WaitStep{Duration: "soon"}}, 1, 1)
+	assert.Error(t, err)
+}
+
+func TestExecuteStepContextCancelsWait(t *testing.T) {
+	client := &SSHClient{target: &target.Target{Name: "test-target"}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := client.ExecuteStepContext(ctx, &job.Step{Wait: &job.WaitStep{Duration: "1h"}}, 1, 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Minute)
+}
+
+func TestExecuteStepContextCancelsCommand(t *testing.T) {
+	closed := make(chan struct{})
+	var signals []ssh.Signal
+	session := &MockSSHSession{
+		WaitFunc: func() error {
+			<-closed
+			return &ssh.ExitMissingError{}
+		},
+		SignalFunc: func(sig ssh.Signal) error {
+			signals = append(signals, sig)
+			return nil
+		},
+		CloseFunc: func() error {
+			select {
+			case <-closed:
+			default:
+				close(closed)
+			}
+			return nil
+		},
+	}
+	sftpClosed := make(chan struct{})
+	client := &SSHClient{
+		sshClient:  &MockSSHClient{NewSessionFunc: func() (SSHSession, error) { return session, nil }},
+		sftpClient: &MockSFTPClient{CloseFunc: func() error { close(sftpClosed); return nil }},
+		target:     &target.Target{Name: "test-target"},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	err := client.ExecuteStepContext(ctx, &job.Step{Run: "sleep 3600"}, 1, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, job.IsConnectionError(err), "a cancelled step should not be retried as a lost connection")
+	assert.Equal(t, []ssh.Signal{ssh.SIGTERM}, signals)
+	select {
+	case <-sftpClosed:
+	case <-time.After(time.Second):
+		t.Error("the SFTP client should be closed to abort transfers")
+	}
+}
+
 func TestExecuteCommand_Error(t *testing.T) {
 	sshClient := &MockSSHClient{
 		NewSessionFunc: func() (SSHSession, error) {
@@ -186,7 +391,7 @@ func TestExecuteCommand_Error(t *testing.T) {
 		Run: "failing-command",
 	}
 
-	err := client.executeCommand(step, 1, 3)
+	err := client.executeCommand(context.Background(), step, 1, 3)
 	assert.Error(t, err, "executeCommand should return an error when the command fails")
 
 	commandErr, ok := err.(*job.CommandError)
@@ -194,6 +399,30 @@ func TestExecuteCommand_Error(t *testing.T) {
 	assert.NotEmpty(t, commandErr.Command, "CommandError.Command should not be empty")
 }
 
+func TestCompressionRunner(t *testing.T) {
+	newClient := func(waitErr error) *SSHClient {
+		return &SSHClient{
+			sshClient: &MockSSHClient{
+				NewSessionFunc: func() (SSHSession, error) {
+					return &MockSSHSession{
+						StartFunc: func(cmd string) error {
+							assert.Contains(t, cmd, "command -v gunzip")
+							return nil
+						},
+						WaitFunc: func() error {
+							return waitErr
+						},
+					}, nil
+				},
+			},
+			target: &target.Target{Name: "test-target"},
+		}
+	}
+
+	assert.NotNil(t, newClient(nil).compressionRunner(), "runner expected when gunzip is available")
+	assert.Nil(t, newClient(errors.New("exit status 1")).compressionRunner(), "no runner expected without gunzip")
+}
+
 func TestNewClientFactory(t *testing.T) {
 	factory := NewClientFactory()
 	assert.NotNil(t, factory, "NewClientFactory should not return nil")
@@ -201,6 +430,46 @@ func TestNewClientFactory(t *testing.T) {
 	assert.NotNil(t, factory.sftpConnector, "ClientFactory.sftpConnector should not be nil")
 }
 
+func TestNewClientFactoryWithMaxUploadRate(t *testing.T) {
+	assert.Nil(t, NewClientFactory().uploadLimiter, "no limiter expected by default")
+	assert.Nil(t, NewClientFactory(WithMaxUploadRate(0)).uploadLimiter, "zero rate should disable throttling")
+	assert.NotNil(t, NewClientFactory(WithMaxUploadRate(1024)).uploadLimiter, "limiter expected for positive rate")
+}
+
+// recordingDialer records the config of the last dial and fails it
+type recordingDialer struct {
+	config *ssh.ClientConfig
+}
+
+func (d *recordingDialer) Dial(_ context.Context, _, _ string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	d.config = config
+	return nil, errors.New("connection refused")
+}
+
+func TestNewClientAlgorithms(t *testing.T) {
+	dialer := &recordingDialer{}
+	factory := NewClientFactory()
+	factory.sshDialer = dialer
+
+	tgt := &target.Target{
+		Host:          "legacy.example.com",
+		User:          "deploy",
+		Password:      "secret",
+		Ciphers:       []string{"aes128-cbc"},
+		MACs:          []string{"hmac-sha1"},
+		KexAlgorithms: []string{"diffie-hellman-group1-sha1"},
+	}
+	_, err := factory.NewClient(tgt)
+	require.Error(t, err)
+
+	assert.Equal(t, []string{"aes128-cbc"}, dialer.config.Ciphers)
+	assert.Equal(t, []string{"hmac-sha1"}, dialer.config.MACs)
+	assert.Equal(t, []string{"diffie-hellman-group1-sha1"}, dialer.config.KeyExchanges)
+
+	_, _ = factory.NewClient(&target.Target{Host: "example.com", User: "deploy", Password: "secret"})
+	assert.Nil(t, dialer.config.Ciphers, "targets without algorithms should use the crypto/ssh defaults")
+}
+
 func TestGetAuthMethods(t *testing.T) {
 	// Cannot fully test without mocking ssh.AuthMethod, but can test basic logic
 	tests := []struct {
@@ -306,16 +575,488 @@ func TestRunShellCommand(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := runShellCommand(tt.session, "sh", "echo test", io.Discard, io.Discard)
+			err := (&SSHClient{}).runShell(context.Background(), tt.session, "sh", "echo test", io.Discard, io.Discard)
 			if tt.expectError {
-				assert.Error(t, err, "runShellCommand should return an error")
+				assert.Error(t, err, "runShell should return an error")
 			} else {
-				assert.NoError(t, err, "runShellCommand should not return an error")
+				assert.NoError(t, err, "runShell should not return an error")
 			}
 		})
 	}
 }
 
+func TestRunShellScript(t *testing.T) {
+	script := "set -e\necho 'it''s' \"$HOME\" `date`\n"
+	var stdin bytes.Buffer
+	done := make(chan struct{})
+
+	session := &MockSSHSession{
+		StartFunc: func(cmd string) error {
+			assert.Equal(t, "bash -s", cmd, "script should be passed through stdin, not argv")
+			return nil
+		},
+		StdinPipeFunc: func() (io.WriteCloser, error) {
+			return &closeNotifier{Writer: &stdin, closed: done}, nil
+		},
+		WaitFunc: func() error {
+			<-done
+			return nil
+		},
+	}
+
+	err := runShellScript(context.Background(), session, "bash", []byte(script), io.Discard, io.Discard, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, script, stdin.String(), "script should be streamed unchanged")
+}
+
+func TestPipeOutputRedactsSecrets(t *testing.T) {
+	secrets := util.NewSecretRegistry()
+	secrets.Add("s3cr3t-token")
+
+	var out bytes.Buffer
+	pipeOutput(strings.NewReader("login with s3cr3t-token\ndone\n"), &out, secrets)
+
+	assert.Equal(t, "login with ***\ndone\n", out.String())
+	assert.NotContains(t, out.String(), "s3cr3t-token")
+}
+
+func TestExecuteCommand_ScriptFile(t *testing.T) {
+	client := &SSHClient{
+		sshClient: &MockSSHClient{},
+		target:    &target.Target{Name: "test-target"},
+	}
+
+	err := client.executeCommand(context.Background(), &job.Step{ScriptFile: "/nonexistent/script.sh"}, 1, 1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read script file")
+}
+
+func TestExecuteStepConnectionLost(t *testing.T) {
+	tests := []struct {
+		name        string
+		waitErr     error
+		wantConnErr bool
+	}{
+		{name: "session ended without exit status", waitErr: &ssh.ExitMissingError{}, wantConnErr: true},
+		{name: "connection closed", waitErr: io.EOF, wantConnErr: true},
+		{name: "command failed", waitErr: errors.New("Process exited with status 1"), wantConnErr: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &SSHClient{
+				sshClient: &MockSSHClient{
+					NewSessionFunc: func() (SSHSession, error) {
+						return &MockSSHSession{WaitFunc: func() error { return tt.waitErr }}, nil
+					},
+				},
+				target: &target.Target{Name: "test-target"},
+			}
+
+			err := client.ExecuteStep(&job.Step{Run: "echo test"}, 1, 1)
+			assert.Error(t, err)
+			assert.Equal(t, tt.wantConnErr, job.IsConnectionError(err))
+		})
+	}
+}
+
+func TestStepShell(t *testing.T) {
+	client := &SSHClient{target: &target.Target{Name: "test-target"}}
+	assert.Equal(t, "sh", client.stepShell(&job.Step{Run: "id"}))
+	assert.Equal(t, "sudo -n bash", client.stepShell(&job.Step{Run: "id", Shell: "bash", Sudo: true}))
+	assert.Equal(t, "sudo -n -u 'deploy' sh", client.stepShell(&job.Step{Run: "id", SudoUser: "deploy"}))
+
+	client.target.Shell = "bash"
+	assert.Equal(t, "bash", client.stepShell(&job.Step{Run: "id"}), "target shell should be the default")
+	assert.Equal(t, "zsh", client.stepShell(&job.Step{Run: "id", Shell: "zsh"}), "step shell should win")
+}
+
+func TestExecuteCommand_Sudo(t *testing.T) {
+	var command string
+	passwordRequired := false
+
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				return &MockSSHSession{
+					StartFunc: func(cmd string) error {
+						command = cmd
+						return nil
+					},
+					StderrPipeFunc: func() (io.Reader, error) {
+						if passwordRequired {
+							return strings.NewReader("sudo: a password is required\n"), nil
+						}
+						return &MockReader{}, nil
+					},
+					WaitFunc: func() error {
+						if passwordRequired {
+							return errors.New("exit status 1")
+						}
+						return nil
+					},
+				}, nil
+			},
+		},
+		target: &target.Target{Name: "test-target"},
+	}
+
+	step := &job.Step{Run: "systemctl restart app", Sudo: true}
+	assert.NoError(t, client.executeCommand(context.Background(), step, 1, 1))
+	assert.Equal(t, "sudo -n sh -c 'systemctl restart app'", command)
+
+	passwordRequired = true
+	err := client.executeCommand(context.Background(), step, 1, 1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "requires a password, configure passwordless sudo")
+
+	err = client.executeCommand(context.Background(), &job.Step{Run: "systemctl restart app"}, 1, 1)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "passwordless sudo", "steps without sudo keep the plain command error")
+}
+
+// scriptSFTPClient records the upload, permission change and removal of a script
+type scriptSFTPClient struct {
+	MockSFTPClient
+	uploaded bytes.Buffer
+	created  string
+	mode     os.FileMode
+	removed  []string
+}
+
+func (m *scriptSFTPClient) Create(path string) (io.WriteCloser, error) {
+	m.created = path
+	return nopWriteCloser{&m.uploaded}, nil
+}
+
+func (m *scriptSFTPClient) Rename(oldname, newname string) error {
+	m.created = newname
+	return nil
+}
+
+func (m *scriptSFTPClient) MkdirAll(path string) error {
+	return nil
+}
+
+func (m *scriptSFTPClient) Chmod(path string, mode os.FileMode) error {
+	m.mode = mode
+	return nil
+}
+
+func (m *scriptSFTPClient) Chtimes(path string, atime, mtime time.Time) error {
+	return nil
+}
+
+func (m *scriptSFTPClient) RemoveAll(path string) error {
+	m.removed = append(m.removed, path)
+	return nil
+}
+
+func TestExecuteRunScript(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "migrate.sh")
+	assert.NoError(t, os.WriteFile(scriptPath, []byte("echo migrate"), 0644))
+
+	for _, waitErr := range []error{nil, errors.New("exit status 1")} {
+		sftpClient := &scriptSFTPClient{}
+		var command string
+
+		client := &SSHClient{
+			sshClient: &MockSSHClient{
+				NewSessionFunc: func() (SSHSession, error) {
+					return &MockSSHSession{
+						StartFunc: func(cmd string) error {
+							command = cmd
+							return nil
+						},
+						WaitFunc: func() error {
+							return waitErr
+						},
+					}, nil
+				},
+			},
+			sftpClient: sftpClient,
+			copier:     *fs.NewCopier(sftpClient),
+			target:     &target.Target{Name: "test-target"},
+		}
+
+		step := &job.Step{RunScript: &job.RunScriptStep{Path: scriptPath, Args: []string{"--env", "prod env"}, Interpreter: "bash"}}
+		err := client.ExecuteStep(step, 1, 1)
+
+		if waitErr == nil {
+			assert.NoError(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+		assert.Equal(t, "echo migrate", sftpClient.uploaded.String(), "script should be uploaded")
+		assert.True(t, strings.HasPrefix(sftpClient.created, "/tmp/nship-"), "script should be uploaded to a temporary path")
+		assert.True(t, strings.HasSuffix(sftpClient.created, "-migrate.sh"))
+		assert.Equal(t, os.FileMode(0700), sftpClient.mode, "script should be made executable")
+		escaped, _ := escapeCommand("bash '" + sftpClient.created + "' '--env' 'prod env'")
+		assert.Contains(t, command, escaped)
+		assert.Equal(t, []string{sftpClient.created}, sftpClient.removed, "script should be removed")
+	}
+}
+
+func TestExecuteContainerCopy(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "app.conf")
+	require.NoError(t, os.WriteFile(configPath, []byte("listen 80"), 0644))
+
+	sftpClient := &scriptSFTPClient{}
+	var command string
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				return &MockSSHSession{
+					StartFunc: func(cmd string) error {
+						command = cmd
+						return nil
+					},
+				}, nil
+			},
+		},
+		sftpClient: sftpClient,
+		copier:     *fs.NewCopier(sftpClient),
+		target:     &target.Target{Name: "test-target"},
+	}
+
+	step := &job.Step{Copy: &job.CopyStep{Local: configPath, Remote: "/etc/app/app.conf", Container: "app"}}
+	require.NoError(t, client.ExecuteStep(step, 1, 1))
+
+	assert.Equal(t, "listen 80", sftpClient.uploaded.String())
+	assert.True(t, strings.HasPrefix(sftpClient.created, "/tmp/nship-"), "the file should be staged in a temporary path")
+	escaped, _ := escapeCommand("docker cp '" + sftpClient.created + "' 'app:/etc/app/app.conf'")
+	assert.Contains(t, command, escaped)
+	assert.Equal(t, []string{sftpClient.created}, sftpClient.removed, "the staged file should be removed")
+}
+
+func TestDockerCopyCommand(t *testing.T) {
+	assert.Equal(t, "docker cp '/tmp/nship-1-dist/.' 'web:/usr/share/nginx/html'",
+		dockerCopyCommand("/tmp/nship-1-dist", true, "web", "/usr/share/nginx/html"))
+	assert.Equal(t, "docker cp '/tmp/nship-1-app.conf' 'web:/etc/app.conf'",
+		dockerCopyCommand("/tmp/nship-1-app.conf", false, "web", "/etc/app.conf"))
+}
+
+func TestExecuteDownloadError(t *testing.T) {
+	sftpClient := &MockSFTPClient{}
+	client := &SSHClient{
+		sftpClient: sftpClient,
+		copier:     *fs.NewCopier(sftpClient),
+		target:     &target.Target{Name: "test-target"},
+	}
+
+	step := &job.Step{Download: &job.DownloadStep{Remote: "/var/log/app.log", Local: filepath.Join(t.TempDir(), "app.log")}}
+	err := client.ExecuteStep(step, 1, 1)
+
+	var copyErr *job.CopyError
+	assert.ErrorAs(t, err, &copyErr)
+	assert.Equal(t, "/var/log/app.log", copyErr.Source)
+	assert.Equal(t, step.Download.Local, copyErr.Destination)
+}
+
+func TestExecuteExec(t *testing.T) {
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				t.Fatal("exec step should not open an SSH session")
+				return nil, nil
+			},
+		},
+		target: &target.Target{Name: "web", Host: "10.0.0.1", User: "deploy"},
+	}
+
+	out := filepath.Join(t.TempDir(), "out")
+	script := `printf '%s %s %s %s %s %s %s' "$NSHIP_TARGET_NAME" "$NSHIP_TARGET_HOST" "$NSHIP_TARGET_PORT" ` +
+		`"$NSHIP_TARGET_USER" "$NSHIP_JOB" "$NSHIP_STEP" "$COLOR" > "$1"`
+	step := &job.Step{Exec: &job.ExecStep{
+		Command: []string{"sh", "-c", script, "sh", out},
+		Env:     map[string]string{"COLOR": "blue", "NSHIP_JOB": "overridden"},
+		Job:     "switch",
+	}}
+
+	require.NoError(t, client.ExecuteStep(step, 2, 3))
+
+	content, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "web 10.0.0.1 22 deploy switch 2 blue", string(content))
+
+	err = client.ExecuteStep(&job.Step{Exec: &job.ExecStep{Command: []string{"sh", "-c", "exit 3"}}}, 1, 1)
+	assert.ErrorContains(t, err, "local command 'sh' failed: exit status 3")
+	assert.False(t, job.IsConnectionError(err))
+}
+
+func TestExecuteExecRedactsSecrets(t *testing.T) {
+	secrets := util.NewSecretRegistry()
+	secrets.Add("s3cr3t-token")
+
+	var stdout, stderr bytes.Buffer
+	client := &SSHClient{target: &target.Target{Name: "web", Host: "10.0.0.1"}, secrets: secrets}
+	client.SetOutput(job.NewOutput(&stdout, &stderr))
+
+	step := &job.Step{Exec: &job.ExecStep{Command: []string{"sh", "-c", "echo token s3cr3t-token; echo login s3cr3t-token >&2"}}}
+	require.NoError(t, client.ExecuteStep(step, 1, 1))
+	assert.Contains(t, stdout.String(), "token ***\n")
+	assert.Equal(t, "login ***\n", stderr.String())
+}
+
+func TestExecuteExecOutputOptions(t *testing.T) {
+	client := &SSHClient{
+		target: &target.Target{Name: "web", Host: "10.0.0.1"},
+		sink:   newOutputSink(io.Discard, io.Discard, OutputOptions{Merge: true}),
+	}
+	var stdout bytes.Buffer
+	client.SetOutput(job.NewOutput(&stdout, io.Discard))
+
+	tail := &job.OutputTail{}
+	step := &job.Step{Exec: &job.ExecStep{Command: []string{"sh", "-c", "echo built; echo cache miss >&2"}}}
+	require.NoError(t, client.ExecuteStepContext(job.WithOutputTail(context.Background(), tail), step, 1, 1))
+
+	assert.Contains(t, stdout.String(), "[stdout] built\n")
+	assert.Contains(t, stdout.String(), "[stderr] cache miss\n", "local output should be merged like remote output")
+	assert.Contains(t, tail.String(), "cache miss", "local output should be kept in the output tail")
+}
+
+func TestRunScriptCommand(t *testing.T) {
+	assert.Equal(t, "'/tmp/s.sh'", runScriptCommand(&job.RunScriptStep{}, "/tmp/s.sh"))
+	assert.Equal(t, "python3 -u '/tmp/s.py' 'a b' 'it'\\''s'",
+		runScriptCommand(&job.RunScriptStep{Interpreter: "python3 -u", Args: []string{"a b", "it's"}}, "/tmp/s.py"))
+}
+
+func TestCommandPrelude(t *testing.T) {
+	assert.Empty(t, commandPrelude(&job.Step{Run: "make"}))
+
+	step := &job.Step{Run: "make", Workdir: "/srv/my app", Env: map[string]string{"B": "it's", "A": "1"}}
+	assert.Equal(t, "cd '/srv/my app' || exit 1\nexport A='1'\nexport B='it'\\''s'\n", commandPrelude(step))
+}
+
+func TestRunCommandFailFast(t *testing.T) {
+	client := &SSHClient{target: &target.Target{Name: "web", Shell: "bash"}}
+
+	assert.Equal(t, "make", client.runCommand(&job.Step{Run: "make"}), "single commands should run unchanged")
+	assert.Equal(t, "set -euo pipefail\nmake\nmake install", client.runCommand(&job.Step{Run: "make\nmake install"}))
+	assert.Equal(t, "set -e\nmake\nmake install", client.runCommand(&job.Step{Run: "make\nmake install", Shell: "/bin/sh"}))
+
+	disabled := false
+	assert.Equal(t, "make\nmake install", client.runCommand(&job.Step{Run: "make\nmake install", FailFast: &disabled}))
+
+	enabled := true
+	step := &job.Step{Run: "make", Workdir: "/srv", FailFast: &enabled}
+	assert.Equal(t, "set -euo pipefail\ncd '/srv' || exit 1\nmake", client.runCommand(step))
+}
+
+func TestFailFastPrelude(t *testing.T) {
+	assert.Equal(t, "set -euo pipefail\n", failFastPrelude("/usr/bin/bash -l"))
+	assert.Equal(t, "set -euo pipefail\n", failFastPrelude("zsh"))
+	assert.Equal(t, "set -e\n", failFastPrelude("sh"))
+	assert.Equal(t, "set -e\n", failFastPrelude(""))
+
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+	out, err := exec.Command("sh", "-c", failFastPrelude("sh")+"echo first\nfalse\necho second").Output()
+	assert.Error(t, err, "the script should fail at the failing line")
+	assert.Equal(t, "first\n", string(out), "lines after the failing one should not run")
+}
+
+func TestExecuteCommandWithEnvAndWorkdir(t *testing.T) {
+	var command string
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				return &MockSSHSession{StartFunc: func(cmd string) error {
+					command = cmd
+					return nil
+				}}, nil
+			},
+		},
+		target: &target.Target{Name: "test-target"},
+		sink:   newOutputSink(io.Discard, io.Discard, OutputOptions{}),
+	}
+
+	step := &job.Step{Run: "make install", Workdir: "/app", Env: map[string]string{"APP_ENV": "prod"}}
+	require.NoError(t, client.executeCommand(context.Background(), step, 1, 1))
+	assert.Equal(t, "sh -c 'cd '\\''/app'\\'' || exit 1\nexport APP_ENV='\\''prod'\\''\nmake install'", command)
+}
+
+func TestExecuteCommandTargetEnv(t *testing.T) {
+	var command string
+	var accepted []string
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				return &MockSSHSession{
+					StartFunc: func(cmd string) error {
+						command = cmd
+						return nil
+					},
+					SetenvFunc: func(name, value string) error {
+						if name == "LANG" {
+							accepted = append(accepted, name+"="+value)
+							return nil
+						}
+						return errors.New("ssh: setenv failed")
+					},
+				}, nil
+			},
+		},
+		target: &target.Target{Name: "test-target", Env: map[string]string{"LANG": "C.UTF-8", "PATH": "/opt/app/bin:/usr/bin"}},
+		sink:   newOutputSink(io.Discard, io.Discard, OutputOptions{}),
+	}
+
+	step := &job.Step{Run: "make install", Env: map[string]string{"APP_ENV": "prod"}}
+	require.NoError(t, client.executeCommand(context.Background(), step, 1, 1))
+	assert.Equal(t, []string{"LANG=C.UTF-8"}, accepted)
+	assert.Equal(t, "sh -c 'export PATH='\\''/opt/app/bin:/usr/bin'\\''\nexport APP_ENV='\\''prod'\\''\nmake install'", command,
+		"variables rejected by the server should be set by the command, before those of the step")
+
+	accepted = nil
+	require.NoError(t, client.executeCommand(context.Background(), &job.Step{Run: "make install", Sudo: true}, 1, 1))
+	assert.Empty(t, accepted, "sudo resets the environment of the session")
+	assert.Equal(t, "sudo -n sh -c 'export LANG='\\''C.UTF-8'\\''\nexport PATH='\\''/opt/app/bin:/usr/bin'\\''\nmake install'", command)
+}
+
+func TestExecuteCommandLoginShell(t *testing.T) {
+	var command string
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				return &MockSSHSession{StartFunc: func(cmd string) error {
+					command = cmd
+					return nil
+				}}, nil
+			},
+		},
+		target: &target.Target{Name: "test-target", Shell: "bash", LoginShell: true},
+		sink:   newOutputSink(io.Discard, io.Discard, OutputOptions{}),
+	}
+
+	require.NoError(t, client.executeCommand(context.Background(), &job.Step{Run: "node --version"}, 1, 1))
+	assert.Equal(t, "bash -lc 'node --version'", command)
+
+	require.NoError(t, client.executeCommand(context.Background(), &job.Step{Run: "node --version", Sudo: true}, 1, 1))
+	assert.Equal(t, "sudo -n bash -lc 'node --version'", command)
+
+	scriptPath := filepath.Join(t.TempDir(), "deploy.sh")
+	require.NoError(t, os.WriteFile(scriptPath, []byte("npm ci"), 0644))
+	require.NoError(t, client.executeCommand(context.Background(), &job.Step{ScriptFile: scriptPath}, 1, 1))
+	assert.Equal(t, "bash -l -s", command)
+
+	client.target.Platform = target.PlatformWindows
+	client.target.Shell = ""
+	require.NoError(t, client.executeCommand(context.Background(), &job.Step{Run: "node --version"}, 1, 1))
+	assert.True(t, strings.HasPrefix(command, "powershell -NonInteractive -EncodedCommand "), "PowerShell should load the profile")
+}
+
+// closeNotifier is a WriteCloser that closes a channel when closed
+type closeNotifier struct {
+	io.Writer
+	closed chan struct{}
+}
+
+func (c *closeNotifier) Close() error {
+	close(c.closed)
+	return nil
+}
+
 func TestEscapeCommand(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -335,19 +1076,69 @@ func TestEscapeCommand(t *testing.T) {
 		{
 			name:     "command with backticks",
 			cmd:      "echo `date`",
-			expected: "'echo \\`date\\`'",
+			expected: "'echo `date`'",
 		},
 		{
 			name:     "complex command",
 			cmd:      "grep -i 'error' `find /var/log -name '*.log'`",
-			expected: "'grep -i '\\''error'\\'' \\`find /var/log -name '\\''*.log'\\''\\`'",
+			expected: "'grep -i '\\''error'\\'' `find /var/log -name '\\''*.log'\\''`'",
+		},
+		{
+			name:     "multi-line command with expansions",
+			cmd:      "echo $HOME \\n!!\necho done",
+			expected: "'echo $HOME \\n!!\necho done'",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := escapeCommand(tt.cmd)
+			result, err := escapeCommand(tt.cmd)
+			assert.NoError(t, err)
 			assert.Equal(t, tt.expected, result, "escapeCommand returned unexpected result")
 		})
 	}
 }
+
+func TestEscapeCommandRejectsNUL(t *testing.T) {
+	_, err := escapeCommand("echo a\x00b")
+	assert.ErrorIs(t, err, errNULInCommand)
+
+	err = (&SSHClient{}).runShell(context.Background(), &MockSSHSession{
+		StartFunc: func(string) error {
+			t.Fatal("a command with a NUL byte should not be started")
+			return nil
+		},
+	}, "sh", "echo a\x00b", io.Discard, io.Discard)
+	assert.ErrorIs(t, err, errNULInCommand)
+}
+
+// TestEscapeCommandRoundTrip checks that a real shell parses escaped commands back into the exact
+// original bytes, for tricky and random strings
+func TestEscapeCommandRoundTrip(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+
+	inputs := []string{
+		"", "'", "''", "\\", "\\'", "'\\''", "$HOME", "${PATH}", "$(id)", "`id`", "!!", "!$", "a\nb\n",
+		"\r\n", "\"quoted\"", "*", "~", "#comment", "a;b|c&d", "-n", "\t\v\f", "\xff\xfe", "日本語",
+	}
+	random := rand.New(rand.NewPCG(1, 2))
+	for range 200 {
+		buf := make([]byte, random.IntN(64))
+		for i := range buf {
+			buf[i] = byte(1 + random.IntN(255))
+		}
+		inputs = append(inputs, string(buf))
+	}
+
+	for _, input := range inputs {
+		escaped, err := escapeCommand(input)
+		require.NoError(t, err)
+
+		out, err := exec.Command(sh, "-c", "printf '%s' "+escaped).Output()
+		require.NoError(t, err, "input %q", input)
+		assert.Equal(t, input, string(out), "input %q did not round-trip through %s", input, escaped)
+	}
+}
@@ -0,0 +1,162 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// WithDebugLog logs the connections of the clients created by the factory to logger at debug level:
+// dialing, the handshake, authentication attempts and their outcome, and the amount of traffic.
+// Keys, passwords and the transferred data are never logged.
+func WithDebugLog(logger *slog.Logger) ClientFactoryOption {
+	return func(f *ClientFactory) {
+		f.debug = logger
+	}
+}
+
+// debugDialer implements SSHDialer over TCP like ssh.Dial, logging the connection
+type debugDialer struct {
+	logger *slog.Logger
+}
+
+// Dial implements SSHDialer
+func (d *debugDialer) Dial(ctx context.Context, network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	start := time.Now()
+	d.logger.Debug("dialing", "address", addr)
+	conn, err := (&net.Dialer{Timeout: config.Timeout}).DialContext(ctx, network, addr)
+	if err != nil {
+		d.logger.Debug("dial failed", "address", addr, "error", err)
+		return nil, err
+	}
+	d.logger.Debug("connected", "address", addr, "duration", since(start))
+
+	return newClientConn(ctx, d.logger, conn, addr, config)
+}
+
+// newClientConn establishes an SSH connection over conn, giving up on the handshake once ctx is done.
+// With a logger, the handshake and the traffic of the connection are logged.
+func newClientConn(ctx context.Context, logger *slog.Logger, conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if logger == nil {
+		c, chans, reqs, err := handshake(ctx, conn, addr, config)
+		if err != nil {
+			return nil, err
+		}
+		return ssh.NewClient(c, chans, reqs), nil
+	}
+
+	start := time.Now()
+	c, chans, reqs, err := handshake(ctx, &debugConn{Conn: conn, logger: logger, opened: start}, addr, config)
+	if err != nil {
+		logger.Debug("handshake failed", "duration", since(start), "error", err)
+		return nil, err
+	}
+	logger.Debug("authenticated", "user", c.User(), "server_version", string(c.ServerVersion()), "duration", since(start))
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// handshake performs the SSH handshake over conn like ssh.NewClientConn. Until it completes, the deadline
+// of ctx applies to conn, and cancelling ctx interrupts it.
+func handshake(ctx context.Context, conn net.Conn, addr string, config *ssh.ClientConfig) (
+	ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, nil, nil, fmt.Errorf("ssh handshake interrupted: %w", ctx.Err())
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	_ = conn.SetDeadline(time.Time{})
+	return c, chans, reqs, nil
+}
+
+// debugAuthMethods returns the auth methods of tgt like getAuthMethods, logging each attempt
+func debugAuthMethods(logger *slog.Logger, tgt *target.Target) []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+
+	if tgt.PrivateKey != "" {
+		signer, err := privateKeys.signer(tgt.PrivateKey)
+		if err != nil {
+			logger.Debug("private key not used", "path", tgt.PrivateKey, "error", err)
+		} else {
+			methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+				logger.Debug("trying public key authentication", "key", ssh.FingerprintSHA256(signer.PublicKey()))
+				return []ssh.Signer{signer}, nil
+			}))
+		}
+	}
+
+	if tgt.Password != "" {
+		methods = append(methods, ssh.PasswordCallback(func() (string, error) {
+			logger.Debug("trying password authentication")
+			return tgt.Password, nil
+		}))
+	}
+
+	return methods
+}
+
+// debugBanner returns a banner callback logging the banner sent by the server
+func debugBanner(logger *slog.Logger) ssh.BannerCallback {
+	return func(message string) error {
+		logger.Debug("server banner", "message", strings.TrimSpace(message))
+		return nil
+	}
+}
+
+// debugConn is a net.Conn counting the bytes sent and received, which it logs when closed
+type debugConn struct {
+	net.Conn
+	logger   *slog.Logger
+	opened   time.Time
+	sent     atomic.Int64
+	received atomic.Int64
+	once     sync.Once
+}
+
+// Read implements net.Conn
+func (c *debugConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.received.Add(int64(n))
+	return n, err
+}
+
+// Write implements net.Conn
+func (c *debugConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.sent.Add(int64(n))
+	return n, err
+}
+
+// Close implements net.Conn. The traffic is logged on the first call only.
+func (c *debugConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		c.logger.Debug("connection closed", "duration", since(c.opened),
+			"sent_bytes", c.sent.Load(), "received_bytes", c.received.Load())
+	})
+	return err
+}
+
+// since returns the time elapsed since start, rounded for logging
+func since(start time.Time) time.Duration {
+	return time.Since(start).Round(time.Millisecond)
+}
@@ -0,0 +1,159 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// startPasswordServer starts an SSH server accepting the password secret and returns its address
+func startPasswordServer(t *testing.T) string {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+		BannerCallback: func(ssh.ConnMetadata) string {
+			return "Authorized access only\n"
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				sc, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					_ = ch.Reject(ssh.Prohibited, "no channels")
+				}
+				sc.Close()
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// debugClientConfig returns the client config NewClient uses for tgt when debugging to logger
+func debugClientConfig(logger *slog.Logger, tgt *target.Target) *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            tgt.User,
+		Auth:            debugAuthMethods(logger, tgt),
+		BannerCallback:  debugBanner(logger),
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	}
+}
+
+func TestDebugDialer(t *testing.T) {
+	addr := startPasswordServer(t)
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	tgt := &target.Target{User: "deploy", Password: "secret", PrivateKey: filepath.Join(t.TempDir(), "missing")}
+	client, err := (&debugDialer{logger: logger}).Dial(context.Background(), "tcp", addr, debugClientConfig(logger, tgt))
+	require.NoError(t, err)
+	require.NoError(t, client.Close())
+
+	log := buf.String()
+	for _, msg := range []string{
+		`msg=dialing address=` + addr,
+		`msg=connected`,
+		`msg="private key not used"`,
+		`msg="server banner" message="Authorized access only"`,
+		`msg="trying password authentication"`,
+		`msg=authenticated user=deploy server_version=SSH-2.0-Go`,
+		`msg="connection closed"`,
+	} {
+		assert.Contains(t, log, msg)
+	}
+	assert.Regexp(t, `sent_bytes=[1-9]\d* received_bytes=[1-9]\d*`, log)
+	assert.NotContains(t, log, "secret", "the password should never be logged")
+}
+
+func TestDebugDialerAuthFailure(t *testing.T) {
+	addr := startPasswordServer(t)
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	tgt := &target.Target{User: "deploy", Password: "guess"}
+	_, err := (&debugDialer{logger: logger}).Dial(context.Background(), "tcp", addr, debugClientConfig(logger, tgt))
+	require.Error(t, err)
+
+	log := buf.String()
+	assert.Contains(t, log, `msg="handshake failed"`)
+	assert.Contains(t, log, "unable to authenticate")
+	assert.NotContains(t, log, "guess", "the password should never be logged")
+}
+
+func TestDebugAuthMethodsPublicKey(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	writePrivateKey(t, keyPath)
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	methods := debugAuthMethods(logger, &target.Target{PrivateKey: keyPath})
+	assert.Len(t, methods, 1)
+	assert.Empty(t, buf.String(), "attempts should be logged when the server is asked, not before")
+}
+
+func TestNewClientContextHandshakeTimeout(t *testing.T) {
+	// The server accepts connections but never sends its version
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	tgt := &target.Target{Host: "127.0.0.1", Port: addr.Port, User: "deploy", Password: "secret"}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = NewClientFactory().NewClientContext(ctx, tgt)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second, "the handshake should be given up at the deadline")
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = (&DefaultSSHDialer{}).Dial(cancelled, "tcp", addr.String(), &ssh.ClientConfig{Timeout: time.Second})
+	assert.ErrorIs(t, err, context.Canceled, "a cancelled context should not be dialed")
+}
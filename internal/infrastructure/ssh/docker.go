@@ -1,42 +1,69 @@
 package ssh
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
-	"os"
+	"io"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/nickalie/nship/internal/core/job"
 )
 
+// dockerConfigLabel is the container label holding the hash of the step configuration the container was created with
+const dockerConfigLabel = "nship.config"
+
+// reclaimedSpacePattern matches the summary line printed by docker prune commands
+var reclaimedSpacePattern = regexp.MustCompile(`Total reclaimed space:\s*(\S+)`)
+
 // DockerCommandBuilder constructs Docker commands
 type DockerCommandBuilder struct {
 	docker *job.DockerStep
+	syntax shellSyntax
 }
 
-// NewDockerCommandBuilder creates a new DockerCommandBuilder
+// NewDockerCommandBuilder creates a new DockerCommandBuilder for POSIX shells
 func NewDockerCommandBuilder(docker *job.DockerStep) *DockerCommandBuilder {
-	return &DockerCommandBuilder{docker: docker}
+	return newDockerCommandBuilder(docker, posixSyntax{})
+}
+
+// newDockerCommandBuilder creates a DockerCommandBuilder building commands in syntax
+func newDockerCommandBuilder(docker *job.DockerStep, syntax shellSyntax) *DockerCommandBuilder {
+	return &DockerCommandBuilder{docker: docker, syntax: syntax}
 }
 
 // BuildCommands builds a list of Docker commands
 func (b *DockerCommandBuilder) BuildCommands() []string {
-	commands := make([]string, 0)
+	return append(b.BuildImageCommands(), b.BuildContainerCommands()...)
+}
 
-	// Build image if build specification is provided
-	if b.docker.Build != nil {
-		buildCmd := b.buildDockerBuildCommand()
-		commands = append(commands, buildCmd)
+// BuildImageCommands builds the commands building the image if a build specification is provided
+func (b *DockerCommandBuilder) BuildImageCommands() []string {
+	if b.docker.Build == nil {
+		return nil
 	}
+	return []string{b.buildDockerBuildCommand()}
+}
+
+// BuildContainerCommands builds the commands replacing the container with a new one and starting it
+func (b *DockerCommandBuilder) BuildContainerCommands() []string {
+	commands := make([]string, 0)
 
 	// Remove existing container if any
 	if b.docker.Name != "" {
-		commands = append(commands, fmt.Sprintf("docker rm -f %s 2>/dev/null || true", b.docker.Name))
+		commands = append(commands, b.syntax.ignoreFailure("docker rm -f "+b.docker.Name))
 	}
 
 	// Create networks if any
 	for _, network := range b.docker.Networks {
-		commands = append(commands, fmt.Sprintf("docker network create %s 2>/dev/null || true", network))
+		commands = append(commands, b.syntax.ignoreFailure("docker network create "+network))
 	}
 
 	// Create container
@@ -53,6 +80,68 @@ func (b *DockerCommandBuilder) BuildCommands() []string {
 	return commands
 }
 
+// BuildInspectCommand builds a command printing the ID of the image and, if the container exists,
+// the image it was created from, whether it is running and the config hash it was created with.
+// The label name is a raw string of the template, as Windows PowerShell drops double quotes from arguments.
+func (b *DockerCommandBuilder) BuildInspectCommand() string {
+	containerFormat := fmt.Sprintf("container={{.Image}} running={{.State.Running}} config={{index .Config.Labels `%s`}}", dockerConfigLabel)
+	return strings.Join([]string{
+		b.syntax.ignoreFailure(fmt.Sprintf("docker image inspect --format %s %s", b.syntax.quote("image={{.Id}}"), b.docker.Image)),
+		b.syntax.ignoreFailure(fmt.Sprintf("docker inspect --format %s %s", b.syntax.quote(containerFormat), b.docker.Name)),
+	}, "\n")
+}
+
+// BuildPullCommands builds the commands pulling the image when its digest is verified, so that the digest
+// is that of the image currently in the registry rather than that of an image pulled earlier
+func (b *DockerCommandBuilder) BuildPullCommands() []string {
+	if b.docker.ExpectedDigest == "" {
+		return nil
+	}
+	return []string{"docker pull " + b.docker.Image}
+}
+
+// BuildDigestCommand builds a command printing the repository digests of the image, one per line
+func (b *DockerCommandBuilder) BuildDigestCommand() string {
+	return fmt.Sprintf("docker image inspect --format %s %s", b.syntax.quote("{{range .RepoDigests}}{{println .}}{{end}}"), b.docker.Image)
+}
+
+// digestMatches reports whether one of the repository digests printed by the digest command is expected,
+// which is a digest such as sha256:… or a repository digest such as nginx@sha256:…
+func digestMatches(output, expected string) bool {
+	for _, repoDigest := range strings.Fields(output) {
+		_, digest, _ := strings.Cut(repoDigest, "@")
+		if repoDigest == expected || digest == expected {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigHash returns a hash of the step configuration, stored in a label of containers
+// that are only recreated when it changes
+func (b *DockerCommandBuilder) ConfigHash() string {
+	data, _ := json.Marshal(b.docker)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// containerUpToDate reports whether the output of the inspect command shows a running container
+// created from the current image with the given config hash
+func containerUpToDate(output, configHash string) bool {
+	lines := strings.Split(output, "\n")
+	var imageID string
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+		if id, ok := strings.CutPrefix(lines[i], "image="); ok {
+			imageID = id
+		}
+	}
+	if imageID == "" {
+		return false
+	}
+	return slices.Contains(lines, fmt.Sprintf("container=%s running=true config=%s", imageID, configHash))
+}
+
 // buildDockerBuildCommand builds a docker build command
 func (b *DockerCommandBuilder) buildDockerBuildCommand() string {
 	args := []string{"docker build"}
@@ -63,12 +152,34 @@ func (b *DockerCommandBuilder) buildDockerBuildCommand() string {
 	// Add build arguments
 	args = append(args, b.appendDockerBuildArgs("--build-arg", b.docker.Build.Args)...)
 
+	// Add build options
+	args = append(args, b.appendDockerBuildOptions()...)
+
 	// Add build context
 	args = append(args, b.docker.Build.Context)
 
 	return strings.Join(args, " ")
 }
 
+// appendDockerBuildOptions appends the Dockerfile, target stage, cache and platform options of the build
+func (b *DockerCommandBuilder) appendDockerBuildOptions() []string {
+	build := b.docker.Build
+	var args []string
+	if build.Dockerfile != "" {
+		args = append(args, "-f", build.Dockerfile)
+	}
+	if build.Target != "" {
+		args = append(args, "--target", build.Target)
+	}
+	if build.NoCache {
+		args = append(args, "--no-cache")
+	}
+	if build.Platform != "" {
+		args = append(args, "--platform", build.Platform)
+	}
+	return args
+}
+
 // appendDockerBuildArgs appends Docker build arguments
 func (b *DockerCommandBuilder) appendDockerBuildArgs(flag string, args map[string]string) []string {
 	buildArgs := make([]string, 0, len(args)*2)
@@ -95,6 +206,49 @@ func (b *DockerCommandBuilder) buildDockerCreateCommand() string {
 	if b.docker.Restart != "" {
 		args = append(args, "--restart", b.docker.Restart)
 	}
+	args = append(args, b.appendResourceLimits()...)
+	args = append(args, b.appendEnvironment()...)
+	args = append(args, b.appendDockerArgs("-p", b.docker.Ports)...)
+	args = append(args, b.appendDockerArgs("-v", b.docker.Volumes)...)
+	args = append(args, b.appendDockerLabels("-l", b.docker.Labels)...)
+	if b.docker.Recreate == job.DockerRecreateOnChange {
+		args = append(args, "-l", fmt.Sprintf("%s=%s", dockerConfigLabel, b.ConfigHash()))
+	}
+	args = append(args, b.appendDockerArgs("--network", b.docker.Networks)...)
+	args = append(args, b.appendProcessOptions()...)
+	args = append(args, b.docker.Image)
+	if len(b.docker.Entrypoint) > 1 {
+		args = append(args, b.docker.Entrypoint[1:]...)
+	}
+	args = append(args, b.docker.Command...)
+	return strings.Join(args, " ")
+}
+
+// appendProcessOptions appends the user, working directory and entrypoint of the container process.
+// Docker only accepts the executable in --entrypoint, so its arguments are added after the image.
+func (b *DockerCommandBuilder) appendProcessOptions() []string {
+	var args []string
+	if b.docker.User != "" {
+		args = append(args, "--user", b.docker.User)
+	}
+	if b.docker.Workdir != "" {
+		args = append(args, "--workdir", b.docker.Workdir)
+	}
+	if len(b.docker.Entrypoint) > 0 {
+		args = append(args, "--entrypoint", b.docker.Entrypoint[0])
+	}
+	return args
+}
+
+// appendEnvironment appends the environment of the container. Docker lets later flags override
+// earlier ones, so the env file comes first, then the variables from the host and then the inline ones.
+func (b *DockerCommandBuilder) appendEnvironment() []string {
+	var args []string
+	if b.docker.EnvFile != "" {
+		args = append(args, "--env-file", b.docker.EnvFile)
+	}
+	args = append(args, b.appendDockerArgs("-e", b.docker.EnvFromHost)...)
+
 	// Get env keys and sort them for consistent order
 	envKeys := make([]string, 0, len(b.docker.Environment))
 	for k := range b.docker.Environment {
@@ -103,15 +257,24 @@ func (b *DockerCommandBuilder) buildDockerCreateCommand() string {
 	sort.Strings(envKeys)
 	// Add environment variables in sorted order
 	for _, k := range envKeys {
-		args = append(args, "-e", fmt.Sprintf("%s=%q", k, b.docker.Environment[k]))
+		args = append(args, "-e", b.syntax.assignment(k, b.docker.Environment[k]))
 	}
-	args = append(args, b.appendDockerArgs("-p", b.docker.Ports)...)
-	args = append(args, b.appendDockerArgs("-v", b.docker.Volumes)...)
-	args = append(args, b.appendDockerLabels("-l", b.docker.Labels)...)
-	args = append(args, b.appendDockerArgs("--network", b.docker.Networks)...)
-	args = append(args, b.docker.Image)
-	args = append(args, b.docker.Command...)
-	return strings.Join(args, " ")
+	return args
+}
+
+// appendResourceLimits appends the flags limiting the memory, CPUs and processes of the container
+func (b *DockerCommandBuilder) appendResourceLimits() []string {
+	var args []string
+	if b.docker.Memory != "" {
+		args = append(args, "--memory", b.docker.Memory)
+	}
+	if b.docker.CPUs != "" {
+		args = append(args, "--cpus", b.docker.CPUs)
+	}
+	if b.docker.PidsLimit != 0 {
+		args = append(args, "--pids-limit", strconv.Itoa(b.docker.PidsLimit))
+	}
+	return args
 }
 
 // appendDockerArgs appends Docker arguments
@@ -127,15 +290,65 @@ func (b *DockerCommandBuilder) appendDockerArgs(flag string, values []string) []
 func (b *DockerCommandBuilder) appendDockerLabels(flag string, labels map[string]string) []string {
 	args := make([]string, 0, len(labels)*2)
 	for k, v := range labels {
-		args = append(args, flag, fmt.Sprintf("%s=%q", k, v))
+		args = append(args, flag, b.syntax.assignment(k, v))
 	}
 	return args
 }
 
-// executeDocker executes Docker commands on the remote host
-func (c *SSHClient) executeDocker(step *job.Step, stepNum, totalSteps int) error {
-	docker := step.Docker
-	fmt.Printf("[%d/%d] Running Docker container '%s'...\n", stepNum, totalSteps, docker.Name)
+// BuildDockerPruneCommands builds the commands removing stopped containers and unused images
+func BuildDockerPruneCommands(prune *job.DockerPruneStep) []string {
+	var filter []string
+	if prune.Until != "" {
+		filter = []string{"--filter", "until=" + prune.Until}
+	}
+
+	commands := make([]string, 0, 2)
+	if prune.Containers {
+		commands = append(commands, strings.Join(append([]string{"docker container prune -f"}, filter...), " "))
+	}
+
+	imageCmd := []string{"docker image prune -f"}
+	if prune.All {
+		imageCmd = append(imageCmd, "-a")
+	}
+	commands = append(commands, strings.Join(append(imageCmd, filter...), " "))
+	return commands
+}
+
+// BuildDockerExecCommand builds the command running the command of a docker exec step in its container
+func BuildDockerExecCommand(exec *job.DockerExecStep) string {
+	return buildDockerExecCommand(exec, posixSyntax{})
+}
+
+// buildDockerExecCommand builds the command of a docker exec step, quoting its arguments in syntax
+func buildDockerExecCommand(exec *job.DockerExecStep, syntax shellSyntax) string {
+	args := []string{"docker exec"}
+	if exec.User != "" {
+		args = append(args, "-u", syntax.quote(exec.User))
+	}
+	if exec.Workdir != "" {
+		args = append(args, "-w", syntax.quote(exec.Workdir))
+	}
+	args = append(args, syntax.quote(exec.Container))
+	for _, arg := range exec.Command {
+		args = append(args, syntax.quote(arg))
+	}
+	return strings.Join(args, " ")
+}
+
+// parseReclaimedSpace returns the space reported as reclaimed in the output of docker prune commands
+func parseReclaimedSpace(output string) []string {
+	var reclaimed []string
+	for _, match := range reclaimedSpacePattern.FindAllStringSubmatch(output, -1) {
+		reclaimed = append(reclaimed, match[1])
+	}
+	return reclaimed
+}
+
+// executeDockerExec runs the command of a docker exec step in its running container on the remote host
+func (c *SSHClient) executeDockerExec(ctx context.Context, step *job.Step, stepNum, totalSteps int) error {
+	exec := step.DockerExec
+	fmt.Fprintf(c.out.Stdout(), "[%d/%d] Running command in Docker container '%s'...\n", stepNum, totalSteps, exec.Container)
 
 	session, err := c.sshClient.NewSession()
 	if err != nil {
@@ -143,14 +356,119 @@ func (c *SSHClient) executeDocker(step *job.Step, stepNum, totalSteps int) error
 	}
 	defer session.Close()
 
-	builder := NewDockerCommandBuilder(docker)
-	commands := builder.BuildCommands()
-	err = runShellCommand(session, step.GetShell(), strings.Join(commands, "\n"), os.Stdout, os.Stderr)
+	err = c.runWithSudoCheck(ctx, step, func(stderr io.Writer) error {
+		return c.runShell(ctx, session, c.stepShell(step), buildDockerExecCommand(exec, c.syntax()), c.stdout(ctx), stderr)
+	})
+	if err != nil {
+		return &job.DockerError{ContainerName: exec.Container, Operation: "exec", Cause: err}
+	}
+	return nil
+}
+
+// executeDockerPrune removes unused Docker objects on the remote host and reports the reclaimed space
+func (c *SSHClient) executeDockerPrune(ctx context.Context, step *job.Step, stepNum, totalSteps int) error {
+	fmt.Fprintf(c.out.Stdout(), "[%d/%d] Pruning Docker images...\n", stepNum, totalSteps)
 
+	session, err := c.sshClient.NewSession()
 	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	var output bytes.Buffer
+	commands := BuildDockerPruneCommands(step.DockerPrune)
+	err = c.runWithSudoCheck(ctx, step, func(stderr io.Writer) error {
+		return c.runShell(ctx, session, c.stepShell(step), c.syntax().joinAll(commands), io.MultiWriter(c.stdout(ctx), &output), stderr)
+	})
+	if err != nil {
+		return fmt.Errorf("docker prune failed: %w", err)
+	}
+
+	if reclaimed := parseReclaimedSpace(output.String()); len(reclaimed) > 0 {
+		fmt.Fprintf(c.out.Stdout(), "[%d/%d] Reclaimed %s\n", stepNum, totalSteps, strings.Join(reclaimed, " + "))
+	}
+	return nil
+}
+
+// executeDocker executes Docker commands on the remote host. With the on-change recreate policy,
+// a running container created from the current image with the same configuration is kept.
+func (c *SSHClient) executeDocker(ctx context.Context, step *job.Step, stepNum, totalSteps int) error {
+	docker := step.Docker
+	fmt.Fprintf(c.out.Stdout(), "[%d/%d] Running Docker container '%s'...\n", stepNum, totalSteps, docker.Name)
+
+	builder := newDockerCommandBuilder(docker, c.syntax())
+	if err := c.verifyImageDigest(ctx, step, builder); err != nil {
+		return err
+	}
+	if docker.Recreate != job.DockerRecreateOnChange {
+		return c.runDockerCommands(ctx, step, "create/start", builder.BuildCommands(), c.stdout(ctx))
+	}
+
+	if err := c.runDockerCommands(ctx, step, "build", builder.BuildImageCommands(), c.stdout(ctx)); err != nil {
+		return err
+	}
+
+	var state bytes.Buffer
+	if err := c.runDockerCommands(ctx, step, "inspect", []string{builder.BuildInspectCommand()}, &state); err != nil {
+		return err
+	}
+	if containerUpToDate(state.String(), builder.ConfigHash()) {
+		fmt.Fprintf(c.out.Stdout(), "[%d/%d] Container '%s' is up to date, skipping recreate\n", stepNum, totalSteps, docker.Name)
+		return nil
+	}
+
+	return c.runDockerCommands(ctx, step, "create/start", builder.BuildContainerCommands(), c.stdout(ctx))
+}
+
+// verifyImageDigest pulls the image of a docker step with an expected digest and fails unless the image has that digest
+func (c *SSHClient) verifyImageDigest(ctx context.Context, step *job.Step, builder *DockerCommandBuilder) error {
+	docker := step.Docker
+	if docker.ExpectedDigest == "" {
+		return nil
+	}
+
+	if err := c.runDockerCommands(ctx, step, "pull", builder.BuildPullCommands(), c.stdout(ctx)); err != nil {
+		return err
+	}
+
+	var digests bytes.Buffer
+	if err := c.runDockerCommands(ctx, step, "inspect", []string{builder.BuildDigestCommand()}, &digests); err != nil {
+		return err
+	}
+	if !digestMatches(digests.String(), docker.ExpectedDigest) {
+		actual := strings.Join(strings.Fields(digests.String()), ", ")
+		if actual == "" {
+			actual = "none"
+		}
 		return &job.DockerError{
 			ContainerName: docker.Name,
-			Operation:     "create/start",
+			Operation:     "verify digest",
+			Cause:         fmt.Errorf("image %s has digest %s, expected %s", docker.Image, actual, docker.ExpectedDigest),
+		}
+	}
+	return nil
+}
+
+// runDockerCommands runs the commands of a docker step in a session of their own, writing their output
+// to stdout and reporting a failure as a DockerError of the operation
+func (c *SSHClient) runDockerCommands(ctx context.Context, step *job.Step, operation string, commands []string, stdout io.Writer) error {
+	if len(commands) == 0 {
+		return nil
+	}
+
+	session, err := c.sshClient.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	err = c.runWithSudoCheck(ctx, step, func(stderr io.Writer) error {
+		return c.runShell(ctx, session, c.stepShell(step), strings.Join(commands, "\n"), stdout, stderr)
+	})
+	if err != nil {
+		return &job.DockerError{
+			ContainerName: step.Docker.Name,
+			Operation:     operation,
 			Cause:         err,
 		}
 	}
@@ -1,11 +1,15 @@
 package ssh
 
 import (
+	"errors"
+	"io"
 	"strings"
 	"testing"
 
 	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestDockerCommandBuilder_BuildCommands(t *testing.T) {
@@ -207,6 +211,46 @@ func TestBuildDockerCreateCommand(t *testing.T) {
 				Name:  "web",
 			},
 			expectedParts: []string{"docker create", "--name web", "nginx:latest"},
+			unexpected:    []string{"--memory", "--cpus", "--pids-limit"},
+		},
+		{
+			name: "create with env file and host environment",
+			dockerStep: &job.DockerStep{
+				Image:       "app:latest",
+				Name:        "app",
+				EnvFile:     "/etc/app/app.env",
+				EnvFromHost: []string{"AWS_REGION", "HOSTNAME"},
+				Environment: map[string]string{"AWS_REGION": "eu-west-1"},
+			},
+			expectedParts: []string{
+				"--env-file /etc/app/app.env -e AWS_REGION -e HOSTNAME -e AWS_REGION=\"eu-west-1\"",
+				"app:latest",
+			},
+		},
+		{
+			name: "create with user, workdir and entrypoint",
+			dockerStep: &job.DockerStep{
+				Image:      "app:latest",
+				Name:       "app",
+				User:       "1000:1000",
+				Workdir:    "/srv/app",
+				Entrypoint: []string{"/docker-entrypoint.sh", "--verbose"},
+				Command:    []string{"serve", "--port", "8080"},
+			},
+			expectedParts: []string{
+				"--user 1000:1000 --workdir /srv/app --entrypoint /docker-entrypoint.sh app:latest --verbose serve --port 8080",
+			},
+		},
+		{
+			name: "create with resource limits",
+			dockerStep: &job.DockerStep{
+				Image:     "nginx:latest",
+				Name:      "web",
+				Memory:    "512m",
+				CPUs:      "1.5",
+				PidsLimit: 100,
+			},
+			expectedParts: []string{"docker create", "--name web", "--memory 512m", "--cpus 1.5", "--pids-limit 100", "nginx:latest"},
 		},
 		{
 			name: "create with restart policy",
@@ -302,7 +346,24 @@ func TestBuildDockerBuildCommand(t *testing.T) {
 					Context: "./src",
 				},
 			},
-			expectedParts: []string{"docker build", "-t app:v1", "./src"},
+			expectedParts: []string{"docker build -t app:v1 ./src"},
+			unexpected:    []string{"-f", "--target", "--no-cache", "--platform"},
+		},
+		{
+			name: "build with dockerfile, target, no cache and platform",
+			dockerStep: &job.DockerStep{
+				Image: "app:v2",
+				Build: &job.DockerBuildStep{
+					Context:    "./src",
+					Dockerfile: "./src/Dockerfile.prod",
+					Target:     "runtime",
+					NoCache:    true,
+					Platform:   "linux/amd64",
+				},
+			},
+			expectedParts: []string{
+				"docker build -t app:v2 -f ./src/Dockerfile.prod --target runtime --no-cache --platform linux/amd64 ./src",
+			},
 		},
 		{
 			name: "build with args",
@@ -387,3 +448,357 @@ func TestAppendDockerLabels(t *testing.T) {
 	})
 	assert.Len(t, args, 4, "Expected 4 args for 2 labels")
 }
+
+func TestBuildDockerPruneCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		prune    *job.DockerPruneStep
+		expected []string
+	}{
+		{
+			name:     "dangling images",
+			prune:    &job.DockerPruneStep{},
+			expected: []string{"docker image prune -f"},
+		},
+		{
+			name:     "all unused images",
+			prune:    &job.DockerPruneStep{All: true},
+			expected: []string{"docker image prune -f -a"},
+		},
+		{
+			name:  "containers and images older than a day",
+			prune: &job.DockerPruneStep{Containers: true, All: true, Until: "24h"},
+			expected: []string{
+				"docker container prune -f --filter until=24h",
+				"docker image prune -f -a --filter until=24h",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, BuildDockerPruneCommands(tt.prune))
+		})
+	}
+}
+
+func TestParseReclaimedSpace(t *testing.T) {
+	output := "Deleted Containers:\nabc123\n\nTotal reclaimed space: 12.5MB\nDeleted Images:\nuntagged: app:v1\n\nTotal reclaimed space: 1.2GB\n"
+	assert.Equal(t, []string{"12.5MB", "1.2GB"}, parseReclaimedSpace(output))
+	assert.Empty(t, parseReclaimedSpace("nothing to prune\n"))
+}
+
+func TestExecuteDockerPrune(t *testing.T) {
+	var command string
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				return &MockSSHSession{
+					StartFunc: func(cmd string) error {
+						command = cmd
+						return nil
+					},
+					StdoutPipeFunc: func() (io.Reader, error) {
+						return strings.NewReader("Total reclaimed space: 1.2GB\n"), nil
+					},
+					StderrPipeFunc: func() (io.Reader, error) {
+						return &MockReader{}, nil
+					},
+				}, nil
+			},
+		},
+		target: &target.Target{Name: "test-target"},
+	}
+
+	err := client.ExecuteStep(&job.Step{DockerPrune: &job.DockerPruneStep{Until: "24h"}}, 1, 1)
+	assert.NoError(t, err)
+	assert.Contains(t, command, "docker image prune -f --filter until=24h")
+	assert.NotContains(t, command, "container prune")
+}
+
+func TestExecuteDockerPruneError(t *testing.T) {
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				return &MockSSHSession{
+					WaitFunc: func() error {
+						return errors.New("permission denied")
+					},
+				}, nil
+			},
+		},
+		target: &target.Target{Name: "test-target"},
+	}
+
+	err := client.ExecuteStep(&job.Step{DockerPrune: &job.DockerPruneStep{}}, 1, 1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "docker prune failed")
+}
+
+func TestBuildDockerExecCommand(t *testing.T) {
+	assert.Equal(t, "docker exec 'app' 'php' 'artisan' 'migrate'",
+		BuildDockerExecCommand(&job.DockerExecStep{Container: "app", Command: []string{"php", "artisan", "migrate"}}))
+	assert.Equal(t, `docker exec -u 'www-data' -w '/var/www' 'app' 'sh' '-c' 'echo '\''done'\'''`,
+		BuildDockerExecCommand(&job.DockerExecStep{
+			Container: "app",
+			Command:   []string{"sh", "-c", "echo 'done'"},
+			User:      "www-data",
+			Workdir:   "/var/www",
+		}))
+}
+
+func TestExecuteDockerExec(t *testing.T) {
+	var command string
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				return &MockSSHSession{
+					StartFunc: func(cmd string) error {
+						command = cmd
+						return nil
+					},
+				}, nil
+			},
+		},
+		target: &target.Target{Name: "test-target"},
+	}
+
+	step := &job.Step{DockerExec: &job.DockerExecStep{Container: "app", Command: []string{"./migrate"}}}
+	assert.NoError(t, client.ExecuteStep(step, 1, 1))
+	assert.Contains(t, command, "docker exec")
+	assert.Contains(t, command, "./migrate")
+
+	client.sshClient = &MockSSHClient{
+		NewSessionFunc: func() (SSHSession, error) {
+			return &MockSSHSession{WaitFunc: func() error { return errors.New("No such container: app") }}, nil
+		},
+	}
+	err := client.ExecuteStep(step, 1, 1)
+	var dockerErr *job.DockerError
+	require.ErrorAs(t, err, &dockerErr)
+	assert.Equal(t, "app", dockerErr.ContainerName)
+	assert.Equal(t, "exec", dockerErr.Operation)
+}
+
+func TestDockerConfigLabel(t *testing.T) {
+	always := NewDockerCommandBuilder(&job.DockerStep{Image: "nginx", Name: "web"})
+	assert.NotContains(t, always.buildDockerCreateCommand(), "nship.config")
+
+	onChange := NewDockerCommandBuilder(&job.DockerStep{Image: "nginx", Name: "web", Recreate: job.DockerRecreateOnChange})
+	assert.Contains(t, onChange.buildDockerCreateCommand(), "-l nship.config="+onChange.ConfigHash())
+
+	changed := NewDockerCommandBuilder(&job.DockerStep{Image: "nginx", Name: "web", Recreate: job.DockerRecreateOnChange, Ports: []string{"80:80"}})
+	assert.NotEqual(t, onChange.ConfigHash(), changed.ConfigHash(), "changing the configuration should change the config hash")
+}
+
+func TestContainerUpToDate(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected bool
+	}{
+		{
+			name:     "running container with current image and config",
+			output:   "image=sha256:abc\ncontainer=sha256:abc running=true config=hash1\n",
+			expected: true,
+		},
+		{
+			name:     "container created from an older image",
+			output:   "image=sha256:def\ncontainer=sha256:abc running=true config=hash1\n",
+			expected: false,
+		},
+		{
+			name:     "container created with another config",
+			output:   "image=sha256:abc\ncontainer=sha256:abc running=true config=hash0\n",
+			expected: false,
+		},
+		{
+			name:     "stopped container",
+			output:   "image=sha256:abc\ncontainer=sha256:abc running=false config=hash1\n",
+			expected: false,
+		},
+		{
+			name:     "missing container",
+			output:   "image=sha256:abc\n",
+			expected: false,
+		},
+		{
+			name:     "missing image",
+			output:   "container=sha256:abc running=true config=hash1\n",
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, containerUpToDate(tt.output, "hash1"))
+		})
+	}
+}
+
+// dockerStateClient returns a client whose first session prints the container state reported by the
+// inspect command and records the commands of all sessions
+func dockerStateClient(state func(builder *DockerCommandBuilder) string, docker *job.DockerStep, commands *[]string) *SSHClient {
+	return &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				output := ""
+				if len(*commands) == 0 {
+					output = state(NewDockerCommandBuilder(docker))
+				}
+				return &MockSSHSession{
+					StartFunc: func(cmd string) error {
+						*commands = append(*commands, cmd)
+						return nil
+					},
+					StdoutPipeFunc: func() (io.Reader, error) {
+						return strings.NewReader(output), nil
+					},
+					StderrPipeFunc: func() (io.Reader, error) {
+						return &MockReader{}, nil
+					},
+				}, nil
+			},
+		},
+		target: &target.Target{Name: "test-target"},
+	}
+}
+
+func TestExecuteDockerOnChange(t *testing.T) {
+	docker := &job.DockerStep{Image: "nginx:latest", Name: "web", Recreate: job.DockerRecreateOnChange}
+
+	t.Run("unchanged container is kept", func(t *testing.T) {
+		var commands []string
+		client := dockerStateClient(func(builder *DockerCommandBuilder) string {
+			return "image=sha256:abc\ncontainer=sha256:abc running=true config=" + builder.ConfigHash() + "\n"
+		}, docker, &commands)
+
+		err := client.ExecuteStep(&job.Step{Docker: docker}, 1, 1)
+		assert.NoError(t, err)
+		require.Len(t, commands, 1, "only the inspect command should run")
+		assert.Contains(t, commands[0], "docker inspect")
+	})
+
+	t.Run("changed container is recreated", func(t *testing.T) {
+		var commands []string
+		client := dockerStateClient(func(*DockerCommandBuilder) string {
+			return "image=sha256:def\ncontainer=sha256:abc running=true config=outdated\n"
+		}, docker, &commands)
+
+		err := client.ExecuteStep(&job.Step{Docker: docker}, 1, 1)
+		assert.NoError(t, err)
+		require.Len(t, commands, 2, "the inspect and recreate commands should run")
+		assert.Contains(t, commands[1], "docker rm -f web")
+		assert.Contains(t, commands[1], "docker create")
+	})
+
+	t.Run("image is built before inspecting", func(t *testing.T) {
+		built := &job.DockerStep{Image: "app:latest", Name: "app", Recreate: job.DockerRecreateOnChange, Build: &job.DockerBuildStep{Context: "."}}
+		var commands []string
+		client := dockerStateClient(func(*DockerCommandBuilder) string { return "" }, built, &commands)
+
+		err := client.ExecuteStep(&job.Step{Docker: built}, 1, 1)
+		assert.NoError(t, err)
+		require.Len(t, commands, 3)
+		assert.Contains(t, commands[0], "docker build")
+		assert.Contains(t, commands[1], "docker inspect")
+		assert.Contains(t, commands[2], "docker create")
+	})
+}
+
+func TestBuildDigestCommands(t *testing.T) {
+	builder := NewDockerCommandBuilder(&job.DockerStep{Image: "nginx:1.27", Name: "web"})
+	assert.Empty(t, builder.BuildPullCommands(), "images should only be pulled when their digest is verified")
+
+	digest := "sha256:" + strings.Repeat("a", 64)
+	builder = NewDockerCommandBuilder(&job.DockerStep{Image: "nginx:1.27", Name: "web", ExpectedDigest: digest})
+	assert.Equal(t, []string{"docker pull nginx:1.27"}, builder.BuildPullCommands())
+	assert.Equal(t, "docker image inspect --format '{{range .RepoDigests}}{{println .}}{{end}}' nginx:1.27", builder.BuildDigestCommand())
+}
+
+func TestDigestMatches(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	other := "sha256:" + strings.Repeat("b", 64)
+	output := "registry.example.com/app@" + other + "\nnginx@" + digest + "\n"
+
+	assert.True(t, digestMatches(output, digest))
+	assert.True(t, digestMatches(output, "nginx@"+digest))
+	assert.False(t, digestMatches(output, "httpd@"+digest), "the repository should match when it is given")
+	assert.False(t, digestMatches("nginx@"+other+"\n", digest))
+	assert.False(t, digestMatches("", digest), "images without repository digests should not match")
+}
+
+// dockerOutputClient returns a client whose sessions print the given outputs in turn and records their commands
+func dockerOutputClient(outputs []string, commands *[]string) *SSHClient {
+	return &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				output := ""
+				if len(*commands) < len(outputs) {
+					output = outputs[len(*commands)]
+				}
+				return &MockSSHSession{
+					StartFunc: func(cmd string) error {
+						*commands = append(*commands, cmd)
+						return nil
+					},
+					StdoutPipeFunc: func() (io.Reader, error) { return strings.NewReader(output), nil },
+					StderrPipeFunc: func() (io.Reader, error) { return &MockReader{}, nil },
+				}, nil
+			},
+		},
+		target: &target.Target{Name: "test-target"},
+		sink:   newOutputSink(io.Discard, io.Discard, OutputOptions{}),
+		out:    job.NewOutput(io.Discard, io.Discard),
+	}
+}
+
+func TestExecuteDockerExpectedDigest(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+	docker := &job.DockerStep{Image: "nginx:1.27", Name: "web", ExpectedDigest: digest}
+
+	t.Run("matching digest", func(t *testing.T) {
+		var commands []string
+		client := dockerOutputClient([]string{"pulled\n", "nginx@" + digest + "\n"}, &commands)
+
+		require.NoError(t, client.ExecuteStep(&job.Step{Docker: docker}, 1, 1))
+		require.Len(t, commands, 3)
+		assert.Contains(t, commands[0], "docker pull nginx:1.27")
+		assert.Contains(t, commands[1], "docker image inspect")
+		assert.Contains(t, commands[2], "docker create")
+	})
+
+	t.Run("mismatching digest", func(t *testing.T) {
+		var commands []string
+		other := "nginx@sha256:" + strings.Repeat("b", 64)
+		client := dockerOutputClient([]string{"pulled\n", other + "\n"}, &commands)
+
+		err := client.ExecuteStep(&job.Step{Docker: docker}, 1, 1)
+		var dockerErr *job.DockerError
+		require.ErrorAs(t, err, &dockerErr)
+		assert.Equal(t, "verify digest", dockerErr.Operation)
+		assert.EqualError(t, dockerErr.Cause, "image nginx:1.27 has digest "+other+", expected "+digest)
+		assert.Len(t, commands, 2, "the container should not be replaced")
+	})
+
+	t.Run("image without digest", func(t *testing.T) {
+		var commands []string
+		client := dockerOutputClient(nil, &commands)
+
+		err := client.ExecuteStep(&job.Step{Docker: docker}, 1, 1)
+		assert.ErrorContains(t, err, "image nginx:1.27 has digest none, expected "+digest)
+	})
+
+	t.Run("on-change recreate", func(t *testing.T) {
+		onChange := *docker
+		onChange.Recreate = job.DockerRecreateOnChange
+		var commands []string
+		client := dockerOutputClient([]string{"pulled\n", "nginx@" + digest + "\n", "image=sha256:abc\n"}, &commands)
+
+		require.NoError(t, client.ExecuteStep(&job.Step{Docker: &onChange}, 1, 1))
+		require.Len(t, commands, 4)
+		assert.Contains(t, commands[0], "docker pull")
+		assert.Contains(t, commands[2], "docker inspect --format")
+		assert.Contains(t, commands[3], "docker create")
+	})
+}
@@ -0,0 +1,271 @@
+package ssh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/infrastructure/fs"
+)
+
+// DefaultStateFile is the path of the state file on a target, relative to the home directory of the login user
+const DefaultStateFile = ".nship/state.json"
+
+// RemoteHashStorage implements job.HashStorage by keeping the step hashes of each target
+// in a state file on that target. It reads and writes the file over the SFTP connection
+// of the clients created by a ClientFactory configured with WithRemoteHashStorage.
+type RemoteHashStorage struct {
+	path      string
+	namespace string
+	mu        sync.Mutex
+	states    map[string]*remoteState
+	current   *remoteState
+}
+
+// remoteState holds the SFTP connection to a target and the hashes loaded from its state file
+type remoteState struct {
+	sftp   SFTPClientInterface
+	hashes map[string]fs.StepHash
+	loaded bool
+}
+
+// NewRemoteHashStorage creates a new RemoteHashStorage using the default state file
+func NewRemoteHashStorage() *RemoteHashStorage {
+	return NewRemoteHashStorageWithPath(DefaultStateFile)
+}
+
+// NewRemoteHashStorageWithPath creates a new RemoteHashStorage using a custom state file path on the targets
+func NewRemoteHashStorageWithPath(statePath string) *RemoteHashStorage {
+	return &RemoteHashStorage{
+		path:   statePath,
+		states: make(map[string]*remoteState),
+	}
+}
+
+// WithRemoteHashStorage makes the factory attach the SFTP connection of every client it creates
+// to storage, so that the hashes of a target are stored on that target
+func WithRemoteHashStorage(storage *RemoteHashStorage) ClientFactoryOption {
+	return func(f *ClientFactory) {
+		f.hashStorage = storage
+	}
+}
+
+// Attach sets the SFTP connection used to access the state file of the named target. Hashes stored
+// under names without a connection of their own, such as those of run-once steps, are kept in the
+// state file of the most recently attached target and looked up in those of all attached targets.
+func (s *RemoteHashStorage) Attach(targetName string, client SFTPClientInterface) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[targetName]
+	if !ok {
+		state = &remoteState{hashes: make(map[string]fs.StepHash)}
+		s.states[targetName] = state
+	}
+	state.sftp = client
+	s.current = state
+}
+
+// SaveHash stores a hash for a job step on a specific target
+func (s *RemoteHashStorage) SaveHash(targetName, jobName string, stepIndex int, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, err := s.loadedState(targetName)
+	if err != nil {
+		return err
+	}
+
+	state.hashes[makeHashKey(s.namespace, targetName, jobName, stepIndex)] = fs.StepHash{
+		Namespace:  s.namespace,
+		TargetName: targetName,
+		JobName:    jobName,
+		StepIndex:  stepIndex,
+		Hash:       hash,
+	}
+
+	return state.persist(s.path)
+}
+
+// GetHash retrieves a hash for a job step on a specific target
+func (s *RemoteHashStorage) GetHash(targetName, jobName string, stepIndex int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := makeHashKey(s.namespace, targetName, jobName, stepIndex)
+	if _, ok := s.states[targetName]; ok || s.current == nil {
+		state, err := s.loadedState(targetName)
+		if err != nil {
+			return "", err
+		}
+		return state.hashes[key].Hash, nil
+	}
+
+	// Names without a connection of their own may have been stored on any target
+	for _, state := range s.states {
+		if err := state.ensureLoaded(s.path); err != nil {
+			return "", err
+		}
+		if hash, ok := state.hashes[key]; ok {
+			return hash.Hash, nil
+		}
+	}
+	return "", nil
+}
+
+// SetNamespace sets the namespace of the hashes that are saved and retrieved from now on
+func (s *RemoteHashStorage) SetNamespace(namespace string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.namespace = namespace
+}
+
+// Clear removes the state files of all attached targets
+func (s *RemoteHashStorage) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, state := range s.states {
+		err := state.sftp.RemoveAll(s.path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove state file on target '%s': %w", name, err)
+		}
+		state.hashes = make(map[string]fs.StepHash)
+		state.loaded = true
+	}
+
+	return nil
+}
+
+// ClearScope removes the hashes stored for the target and job from the state files of all attached
+// targets, where an empty name matches any, and returns how many were removed
+func (s *RemoteHashStorage) ClearScope(targetName, jobName string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	for name, state := range s.states {
+		removed, err := state.clearScope(s.path, targetName, jobName)
+		if err != nil {
+			return total, fmt.Errorf("failed to clear hashes on target '%s': %w", name, err)
+		}
+		total += removed
+	}
+	return total, nil
+}
+
+// clearScope removes the matching hashes from the state file and returns how many were removed
+func (r *remoteState) clearScope(statePath, targetName, jobName string) (int, error) {
+	if err := r.ensureLoaded(statePath); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for key, hash := range r.hashes {
+		if job.MatchesScope(hash.TargetName, hash.JobName, targetName, jobName) {
+			delete(r.hashes, key)
+			removed++
+		}
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, r.persist(statePath)
+}
+
+// loadedState returns the state of the named target with its state file loaded
+func (s *RemoteHashStorage) loadedState(targetName string) (*remoteState, error) {
+	state, ok := s.states[targetName]
+	if !ok {
+		state = s.current
+	}
+	if state == nil {
+		return nil, fmt.Errorf("no connection to target '%s' to access its state file", targetName)
+	}
+
+	if err := state.ensureLoaded(s.path); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// ensureLoaded reads the state file from the target unless it was already read
+func (r *remoteState) ensureLoaded(statePath string) error {
+	if r.loaded {
+		return nil
+	}
+
+	file, err := r.sftp.Open(statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		r.loaded = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open state file: %w", err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var hashList []fs.StepHash
+	if err := json.Unmarshal(data, &hashList); err != nil {
+		return fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	for _, hash := range hashList {
+		r.hashes[makeHashKey(hash.Namespace, hash.TargetName, hash.JobName, hash.StepIndex)] = hash
+	}
+
+	r.loaded = true
+	return nil
+}
+
+// persist writes the hashes to the state file on the target
+func (r *remoteState) persist(statePath string) error {
+	hashList := make([]fs.StepHash, 0, len(r.hashes))
+	for _, hash := range r.hashes {
+		hashList = append(hashList, hash)
+	}
+
+	data, err := json.MarshalIndent(hashList, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal hashes: %w", err)
+	}
+
+	if err := r.sftp.MkdirAll(path.Dir(statePath)); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	file, err := r.sftp.Create(statePath)
+	if err != nil {
+		return fmt.Errorf("failed to create state file: %w", err)
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	return file.Close()
+}
+
+// makeHashKey creates a unique key for a hash
+func makeHashKey(namespace, targetName, jobName string, stepIndex int) string {
+	return fmt.Sprintf("%s|%s:%s:%d", namespace, targetName, jobName, stepIndex)
+}
+
+// Ensure RemoteHashStorage implements the ScopedHashStorage and NamespacedHashStorage interfaces
+var (
+	_ job.ScopedHashStorage     = (*RemoteHashStorage)(nil)
+	_ job.NamespacedHashStorage = (*RemoteHashStorage)(nil)
+)
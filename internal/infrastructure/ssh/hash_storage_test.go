@@ -0,0 +1,135 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stateSFTPClient keeps the files written over SFTP in memory
+type stateSFTPClient struct {
+	MockSFTPClient
+	files map[string]*bytes.Buffer
+}
+
+func newStateSFTPClient() *stateSFTPClient {
+	return &stateSFTPClient{files: make(map[string]*bytes.Buffer)}
+}
+
+func (m *stateSFTPClient) Open(path string) (io.ReadCloser, error) {
+	file, ok := m.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(file.Bytes())), nil
+}
+
+func (m *stateSFTPClient) Create(path string) (io.WriteCloser, error) {
+	m.files[path] = &bytes.Buffer{}
+	return nopWriteCloser{m.files[path]}, nil
+}
+
+func (m *stateSFTPClient) MkdirAll(path string) error {
+	return nil
+}
+
+func (m *stateSFTPClient) RemoveAll(path string) error {
+	if _, ok := m.files[path]; !ok {
+		return os.ErrNotExist
+	}
+	delete(m.files, path)
+	return nil
+}
+
+func TestRemoteHashStorageKeepsHashesOnEachTarget(t *testing.T) {
+	web, db := newStateSFTPClient(), newStateSFTPClient()
+
+	storage := NewRemoteHashStorage()
+	storage.Attach("web", web)
+	storage.Attach("db", db)
+
+	assert.NoError(t, storage.SaveHash("web", "deploy", 0, "web-hash"))
+	assert.NoError(t, storage.SaveHash("db", "deploy", 0, "db-hash"))
+
+	assert.Contains(t, web.files[DefaultStateFile].String(), "web-hash")
+	assert.NotContains(t, web.files[DefaultStateFile].String(), "db-hash")
+	assert.Contains(t, db.files[DefaultStateFile].String(), "db-hash")
+
+	// A new storage reads the hashes back from the state files
+	reloaded := NewRemoteHashStorage()
+	reloaded.Attach("web", web)
+	reloaded.Attach("db", db)
+
+	hash, err := reloaded.GetHash("web", "deploy", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "web-hash", hash)
+
+	hash, err = reloaded.GetHash("db", "deploy", 1)
+	assert.NoError(t, err)
+	assert.Empty(t, hash)
+}
+
+func TestRemoteHashStorageUsesCurrentTargetForUnknownNames(t *testing.T) {
+	web := newStateSFTPClient()
+
+	storage := NewRemoteHashStorageWithPath("state/hashes.json")
+	storage.Attach("web", web)
+
+	assert.NoError(t, storage.SaveHash("*run_once*", "migrate", 0, "once-hash"))
+	assert.Contains(t, web.files["state/hashes.json"].String(), "once-hash")
+
+	hash, err := storage.GetHash("*run_once*", "migrate", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "once-hash", hash)
+
+	// The hash is still found after switching to another target
+	storage.Attach("db", newStateSFTPClient())
+	hash, err = storage.GetHash("*run_once*", "migrate", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "once-hash", hash)
+}
+
+func TestRemoteHashStorageWithoutConnection(t *testing.T) {
+	storage := NewRemoteHashStorage()
+
+	_, err := storage.GetHash("web", "deploy", 0)
+	assert.ErrorContains(t, err, "no connection to target 'web'")
+}
+
+func TestRemoteHashStorageClear(t *testing.T) {
+	web, db := newStateSFTPClient(), newStateSFTPClient()
+
+	storage := NewRemoteHashStorage()
+	storage.Attach("web", web)
+	storage.Attach("db", db)
+	assert.NoError(t, storage.SaveHash("web", "deploy", 0, "web-hash"))
+
+	assert.NoError(t, storage.Clear(), "targets without a state file are not an error")
+	assert.Empty(t, web.files)
+
+	hash, err := storage.GetHash("web", "deploy", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, hash)
+}
+
+func TestRemoteHashStorageClearScope(t *testing.T) {
+	web, db := newStateSFTPClient(), newStateSFTPClient()
+
+	storage := NewRemoteHashStorage()
+	storage.Attach("web", web)
+	assert.NoError(t, storage.SaveHash("web", "deploy", 0, "web-deploy"))
+	assert.NoError(t, storage.SaveHash("web", "backup", 0, "web-backup"))
+	storage.Attach("db", db)
+	assert.NoError(t, storage.SaveHash("db", "deploy", 0, "db-deploy"))
+
+	removed, err := storage.ClearScope("", "deploy")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	assert.NotContains(t, web.files[DefaultStateFile].String(), "web-deploy")
+	assert.Contains(t, web.files[DefaultStateFile].String(), "web-backup")
+	assert.NotContains(t, db.files[DefaultStateFile].String(), "db-deploy")
+}
@@ -0,0 +1,63 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// privateKeys caches the private keys of all targets, so that a key file shared by many targets
+// is parsed once rather than for every connection
+var privateKeys = newKeyCache(ssh.ParsePrivateKey)
+
+// keyCache holds parsed private keys by path. A key is parsed again when the modification time
+// or size of its file changes.
+type keyCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedKey
+	parse   func(pemBytes []byte) (ssh.Signer, error)
+}
+
+// cachedKey is a parsed private key with the state of its file when it was parsed
+type cachedKey struct {
+	signer  ssh.Signer
+	modTime time.Time
+	size    int64
+}
+
+// newKeyCache creates an empty cache parsing keys with parse
+func newKeyCache(parse func(pemBytes []byte) (ssh.Signer, error)) *keyCache {
+	return &keyCache{entries: make(map[string]cachedKey), parse: parse}
+}
+
+// signer returns the parsed private key in keyPath. Concurrent calls for the same key wait for
+// a single parse instead of each parsing the file.
+func (c *keyCache) signer(keyPath string) (ssh.Signer, error) {
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[keyPath]; ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.signer, nil
+	}
+
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	signer, err := c.parse(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	c.entries[keyPath] = cachedKey{signer: signer, modTime: info.ModTime(), size: info.Size()}
+	return signer, nil
+}
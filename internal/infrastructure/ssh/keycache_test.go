@@ -0,0 +1,90 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// writePrivateKey writes a new ed25519 private key in OpenSSH format to path
+func writePrivateKey(t *testing.T, path string) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(key, "")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(block), 0600))
+}
+
+// countingKeyCache returns a key cache counting how often it parses a key
+func countingKeyCache() (*keyCache, *atomic.Int32) {
+	var parsed atomic.Int32
+	return newKeyCache(func(pemBytes []byte) (ssh.Signer, error) {
+		parsed.Add(1)
+		return ssh.ParsePrivateKey(pemBytes)
+	}), &parsed
+}
+
+func TestKeyCacheParsesOnce(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	writePrivateKey(t, keyPath)
+	cache, parsed := countingKeyCache()
+
+	var wg sync.WaitGroup
+	signers := make([]ssh.Signer, 10)
+	for i := range signers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			signer, err := cache.signer(keyPath)
+			assert.NoError(t, err)
+			signers[i] = signer
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), parsed.Load(), "a key shared by many targets should be parsed once")
+	for _, signer := range signers {
+		assert.Same(t, signers[0], signer)
+	}
+}
+
+func TestKeyCacheReparsesChangedFile(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	writePrivateKey(t, keyPath)
+	cache, parsed := countingKeyCache()
+
+	first, err := cache.signer(keyPath)
+	require.NoError(t, err)
+
+	writePrivateKey(t, keyPath)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(keyPath, later, later))
+
+	second, err := cache.signer(keyPath)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), parsed.Load(), "a changed key file should be parsed again")
+	assert.NotEqual(t, first.PublicKey().Marshal(), second.PublicKey().Marshal())
+}
+
+func TestKeyCacheErrors(t *testing.T) {
+	dir := t.TempDir()
+	cache, _ := countingKeyCache()
+
+	_, err := cache.signer(filepath.Join(dir, "missing"))
+	assert.ErrorContains(t, err, "failed to read private key")
+
+	invalid := filepath.Join(dir, "invalid")
+	require.NoError(t, os.WriteFile(invalid, []byte("not a key"), 0600))
+	_, err = cache.signer(invalid)
+	assert.ErrorContains(t, err, "failed to parse private key")
+}
@@ -0,0 +1,55 @@
+package ssh
+
+import "sync"
+
+// sessionLimiter wraps an SSHClientInterface so that at most a fixed number of sessions
+// are open at the same time. NewSession blocks until a session is closed once the limit is reached.
+type sessionLimiter struct {
+	SSHClientInterface
+	slots chan struct{}
+}
+
+// newSessionLimiter limits the sessions opened through client to maxSessions
+func newSessionLimiter(client SSHClientInterface, maxSessions int) *sessionLimiter {
+	return &sessionLimiter{
+		SSHClientInterface: client,
+		slots:              make(chan struct{}, maxSessions),
+	}
+}
+
+// NewSession implements SSHClientInterface
+func (l *sessionLimiter) NewSession() (SSHSession, error) {
+	l.slots <- struct{}{}
+
+	session, err := l.SSHClientInterface.NewSession()
+	if err != nil {
+		<-l.slots
+		return nil, err
+	}
+
+	return &limitedSession{SSHSession: session, release: l.release}, nil
+}
+
+// reserve takes a session slot that is never freed, for a session open as long as the connection
+func (l *sessionLimiter) reserve() {
+	l.slots <- struct{}{}
+}
+
+// release frees a session slot
+func (l *sessionLimiter) release() {
+	<-l.slots
+}
+
+// limitedSession frees its slot in the session limiter when closed
+type limitedSession struct {
+	SSHSession
+	release func()
+	once    sync.Once
+}
+
+// Close implements SSHSession. The slot is freed on the first call only.
+func (s *limitedSession) Close() error {
+	err := s.SSHSession.Close()
+	s.once.Do(s.release)
+	return err
+}
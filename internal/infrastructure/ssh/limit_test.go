@@ -0,0 +1,68 @@
+package ssh
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionLimiterBlocksAtLimit(t *testing.T) {
+	limiter := newSessionLimiter(&MockSSHClient{}, 2)
+
+	first, err := limiter.NewSession()
+	assert.NoError(t, err)
+	_, err = limiter.NewSession()
+	assert.NoError(t, err)
+
+	opened := make(chan struct{})
+	go func() {
+		_, _ = limiter.NewSession()
+		close(opened)
+	}()
+
+	select {
+	case <-opened:
+		t.Fatal("third session should block while two are open")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.NoError(t, first.Close())
+	assert.NoError(t, first.Close(), "closing twice should not free a second slot")
+
+	select {
+	case <-opened:
+	case <-time.After(time.Second):
+		t.Fatal("third session should open once a session is closed")
+	}
+
+	assert.Len(t, limiter.slots, 2)
+}
+
+func TestSessionLimiterReleasesOnError(t *testing.T) {
+	limiter := newSessionLimiter(&MockSSHClient{
+		NewSessionFunc: func() (SSHSession, error) {
+			return nil, errors.New("administratively prohibited: open failed")
+		},
+	}, 1)
+
+	for i := 0; i < 3; i++ {
+		_, err := limiter.NewSession()
+		assert.Error(t, err)
+	}
+	assert.Empty(t, limiter.slots, "failed sessions should not occupy a slot")
+}
+
+func TestSessionLimiterReleasesOnSessionCloseError(t *testing.T) {
+	limiter := newSessionLimiter(&MockSSHClient{
+		NewSessionFunc: func() (SSHSession, error) {
+			return &MockSSHSession{CloseFunc: func() error { return errors.New("EOF") }}, nil
+		},
+	}, 1)
+
+	session, err := limiter.NewSession()
+	assert.NoError(t, err)
+	assert.Error(t, session.Close())
+	assert.Empty(t, limiter.slots, "the slot should be freed even when closing fails")
+}
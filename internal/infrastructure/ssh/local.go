@@ -0,0 +1,311 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+	"github.com/nickalie/nship/internal/infrastructure/fs"
+)
+
+// errLocalNotStarted is returned when waiting for or signalling a local session whose command was not started
+var errLocalNotStarted = errors.New("command not started")
+
+// localSignals maps the signals sent to sessions to the signals of the local operating system
+var localSignals = map[ssh.Signal]os.Signal{
+	ssh.SIGINT:  os.Interrupt,
+	ssh.SIGKILL: os.Kill,
+	ssh.SIGTERM: syscall.SIGTERM,
+}
+
+// newLocalClient creates a client running the steps of the local target tgt on this machine instead of
+// connecting over SSH. Like after an SSH login, commands run and relative paths are resolved in the home
+// directory of the user.
+func (f *ClientFactory) newLocalClient(tgt *target.Target) (job.Client, error) {
+	if tgt.IsWindows() {
+		return nil, fmt.Errorf("local target '%s' can't use the windows platform, as its commands run with sh", tgt.GetName())
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the home directory for local target '%s': %w", tgt.GetName(), err)
+	}
+
+	files := &localFiles{dir: home}
+	if f.hashStorage != nil {
+		f.hashStorage.Attach(tgt.GetName(), files)
+	}
+
+	return &SSHClient{
+		sshClient:  localSessions{dir: home},
+		sftpClient: files,
+		copier:     *fs.NewCopier(files),
+		target:     tgt,
+		sink:       f.output,
+		secrets:    f.secrets,
+	}, nil
+}
+
+// localSessions implements SSHClientInterface by opening local sessions, which run their commands in dir
+type localSessions struct {
+	dir string
+}
+
+// NewSession implements SSHClientInterface
+func (l localSessions) NewSession() (SSHSession, error) {
+	return &localSession{dir: l.dir}, nil
+}
+
+// Close implements SSHClientInterface
+func (localSessions) Close() error {
+	return nil
+}
+
+// localSession implements SSHSession by running commands with the local sh, like the login shell of a target.
+// Its pipes are operating system pipes, so like in an SSH session, the output ends once every process
+// writing it exited, and closing the session stops reading it.
+type localSession struct {
+	dir string
+	env []string
+	// child holds the ends of the pipes passed to the command, which are closed once it started
+	child []*os.File
+	// stdin, stdout and stderr are the ends of the pipes passed to the command, if the pipe was requested
+	stdin, stdout, stderr *os.File
+	// own holds the ends of the pipes returned to the caller, which are closed along with the session
+	own []*os.File
+
+	mu  sync.Mutex
+	cmd *exec.Cmd
+}
+
+// pipe creates a pipe, keeping the end passed to the command and returning the other one
+func (s *localSession) pipe(childReads bool) (child, own *os.File, err error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create pipe: %w", err)
+	}
+	child, own = w, r
+	if childReads {
+		child, own = r, w
+	}
+	s.child = append(s.child, child)
+	s.own = append(s.own, own)
+	return child, own, nil
+}
+
+// StdoutPipe implements SSHSession
+func (s *localSession) StdoutPipe() (io.Reader, error) {
+	w, r, err := s.pipe(false)
+	if err != nil {
+		return nil, err
+	}
+	s.stdout = w
+	return r, nil
+}
+
+// StderrPipe implements SSHSession
+func (s *localSession) StderrPipe() (io.Reader, error) {
+	w, r, err := s.pipe(false)
+	if err != nil {
+		return nil, err
+	}
+	s.stderr = w
+	return r, nil
+}
+
+// StdinPipe implements SSHSession
+func (s *localSession) StdinPipe() (io.WriteCloser, error) {
+	r, w, err := s.pipe(true)
+	if err != nil {
+		return nil, err
+	}
+	s.stdin = r
+	return w, nil
+}
+
+// Setenv implements SSHSession. The variable is set for the command started next.
+func (s *localSession) Setenv(name, value string) error {
+	s.env = append(s.env, name+"="+value)
+	return nil
+}
+
+// Start implements SSHSession, running cmd with sh -c
+func (s *localSession) Start(cmd string) error {
+	command := exec.Command("sh", "-c", cmd)
+	command.Dir = s.dir
+	if len(s.env) > 0 {
+		command.Env = append(os.Environ(), s.env...)
+	}
+	// Unset pipes must stay nil interfaces, which exec connects to the null device
+	if s.stdin != nil {
+		command.Stdin = s.stdin
+	}
+	if s.stdout != nil {
+		command.Stdout = s.stdout
+	}
+	if s.stderr != nil {
+		command.Stderr = s.stderr
+	}
+
+	err := command.Start()
+	closeFiles(s.child)
+	if err != nil {
+		closeFiles(s.own)
+		return err
+	}
+
+	s.mu.Lock()
+	s.cmd = command
+	s.mu.Unlock()
+	return nil
+}
+
+// started returns the started command, or nil if Start didn't succeed
+func (s *localSession) started() *exec.Cmd {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cmd
+}
+
+// Wait implements SSHSession
+func (s *localSession) Wait() error {
+	cmd := s.started()
+	if cmd == nil {
+		return errLocalNotStarted
+	}
+	return cmd.Wait()
+}
+
+// Signal implements SSHSession
+func (s *localSession) Signal(sig ssh.Signal) error {
+	cmd := s.started()
+	if cmd == nil {
+		return errLocalNotStarted
+	}
+	signal, ok := localSignals[sig]
+	if !ok {
+		return fmt.Errorf("unsupported signal %s", sig)
+	}
+	return cmd.Process.Signal(signal)
+}
+
+// Close implements SSHSession. Like closing an SSH session, it ends a command that is still running and
+// stops reading its output, which children of the command left running may keep open.
+func (s *localSession) Close() error {
+	if cmd := s.started(); cmd != nil {
+		_ = cmd.Process.Kill()
+	}
+	closeFiles(s.own)
+	return nil
+}
+
+// closeFiles closes files, ignoring errors of files closed before
+func closeFiles(files []*os.File) {
+	for _, file := range files {
+		_ = file.Close()
+	}
+}
+
+// localFiles implements SFTPClientInterface with the local file system. Relative paths are resolved
+// against dir, like SFTP servers resolve them against the home directory of the user.
+type localFiles struct {
+	dir string
+}
+
+// path returns the local path of name
+func (f *localFiles) path(name string) string {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(f.dir, name)
+}
+
+// Create implements SFTPClientInterface
+func (f *localFiles) Create(path string) (io.WriteCloser, error) {
+	return os.Create(f.path(path))
+}
+
+// CreateExclusive implements SFTPClientInterface
+func (f *localFiles) CreateExclusive(path string) (io.WriteCloser, error) {
+	return os.OpenFile(f.path(path), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+}
+
+// Open implements SFTPClientInterface
+func (f *localFiles) Open(path string) (io.ReadCloser, error) {
+	return os.Open(f.path(path))
+}
+
+// MkdirAll implements SFTPClientInterface
+func (f *localFiles) MkdirAll(path string) error {
+	return os.MkdirAll(f.path(path), 0755)
+}
+
+// Chmod implements SFTPClientInterface
+func (f *localFiles) Chmod(path string, mode os.FileMode) error {
+	return os.Chmod(f.path(path), mode)
+}
+
+// Stat implements SFTPClientInterface
+func (f *localFiles) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(f.path(path))
+}
+
+// Chtimes implements SFTPClientInterface
+func (f *localFiles) Chtimes(path string, atime, mtime time.Time) error {
+	return os.Chtimes(f.path(path), atime, mtime)
+}
+
+// ReadDir implements SFTPClientInterface
+func (f *localFiles) ReadDir(path string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(f.path(path))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Remove implements SFTPClientInterface
+func (f *localFiles) Remove(path string) error {
+	return os.Remove(f.path(path))
+}
+
+// RemoveAll implements SFTPClientInterface
+func (f *localFiles) RemoveAll(path string) error {
+	return os.RemoveAll(f.path(path))
+}
+
+// Rename implements SFTPClientInterface, replacing an existing file at newname
+func (f *localFiles) Rename(oldname, newname string) error {
+	return os.Rename(f.path(oldname), f.path(newname))
+}
+
+// Symlink implements SFTPClientInterface. The target oldname is kept as given, so relative targets
+// stay relative to the directory of the link.
+func (f *localFiles) Symlink(oldname, newname string) error {
+	return os.Symlink(filepath.FromSlash(oldname), f.path(newname))
+}
+
+// Close implements SFTPClientInterface
+func (f *localFiles) Close() error {
+	return nil
+}
@@ -0,0 +1,134 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// newLocalTestClient returns a client of a local target whose home directory is a temporary directory, and its output
+func newLocalTestClient(t *testing.T, tgt *target.Target) (*SSHClient, string, *bytes.Buffer) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	client, err := NewClientFactory().NewClient(tgt)
+	require.NoError(t, err)
+	t.Cleanup(client.Close)
+
+	var out bytes.Buffer
+	local := client.(*SSHClient)
+	local.SetOutput(job.NewOutput(&out, &out))
+	return local, home, &out
+}
+
+func TestLocalClientRun(t *testing.T) {
+	client, home, out := newLocalTestClient(t, &target.Target{Name: "local", Host: "localhost", Local: true,
+		Env: map[string]string{"GREETING": "hello"}})
+
+	require.NoError(t, client.ExecuteStep(&job.Step{Run: "echo \"$GREETING from $(pwd)\" && echo created > file.txt"}, 1, 1))
+	assert.Contains(t, out.String(), "hello from "+home, "commands should run in the home directory with the target environment")
+
+	data, err := os.ReadFile(filepath.Join(home, "file.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "created\n", string(data))
+}
+
+func TestLocalClientFailingCommand(t *testing.T) {
+	client, _, _ := newLocalTestClient(t, &target.Target{Host: "localhost", Local: true})
+
+	tail := &job.OutputTail{}
+	err := client.ExecuteStepContext(job.WithOutputTail(context.Background(), tail), &job.Step{Run: "echo oops >&2; exit 3"}, 1, 1)
+	var cmdErr *job.CommandError
+	require.ErrorAs(t, err, &cmdErr)
+	assert.Contains(t, tail.String(), "oops")
+	assert.False(t, job.IsConnectionError(err), "failing commands should not be reported as connection errors")
+}
+
+func TestLocalClientCancel(t *testing.T) {
+	client, _, _ := newLocalTestClient(t, &target.Target{Host: "localhost", Local: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := client.ExecuteStepContext(ctx, &job.Step{Run: "sleep 10"}, 1, 1)
+	assert.ErrorContains(t, err, "step cancelled")
+	assert.Less(t, time.Since(start), 5*time.Second, "the command should be stopped")
+}
+
+func TestLocalClientCopy(t *testing.T) {
+	client, home, _ := newLocalTestClient(t, &target.Target{Host: "localhost", Local: true})
+
+	src := filepath.Join(t.TempDir(), "app")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "static"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(src, "static", "index.html"), []byte("<h1>app</h1>"), 0644))
+
+	step := &job.Step{Copy: &job.CopyStep{Local: src, Remote: "www/app"}}
+	require.NoError(t, client.ExecuteStep(step, 1, 1))
+
+	data, err := os.ReadFile(filepath.Join(home, "www", "app", "static", "index.html"))
+	require.NoError(t, err, "relative destinations should be resolved against the home directory")
+	assert.Equal(t, "<h1>app</h1>", string(data))
+
+	absolute := filepath.Join(t.TempDir(), "copy")
+	step = &job.Step{Copy: &job.CopyStep{Local: src, Remote: filepath.ToSlash(absolute)}}
+	require.NoError(t, client.ExecuteStep(step, 1, 1))
+	assert.FileExists(t, filepath.Join(absolute, "static", "index.html"))
+}
+
+func TestLocalClientDocker(t *testing.T) {
+	client, home, _ := newLocalTestClient(t, &target.Target{Host: "localhost", Local: true})
+
+	// A fake docker records the commands run by the step
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho \"$@\" >> \"$HOME/docker.log\"\n"
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "docker"), []byte(script), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	require.NoError(t, client.ExecuteStep(&job.Step{Docker: &job.DockerStep{Image: "nginx", Name: "web"}}, 1, 1))
+
+	data, err := os.ReadFile(filepath.Join(home, "docker.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "create --name web")
+	assert.Contains(t, string(data), "start web")
+}
+
+func TestLocalClientLock(t *testing.T) {
+	client, home, _ := newLocalTestClient(t, &target.Target{Host: "localhost", Local: true})
+
+	require.NoError(t, client.Lock("deploy", false))
+	assert.FileExists(t, filepath.Join(home, LockDir, "deploy.lock"))
+
+	err := client.Lock("deploy", false)
+	assert.ErrorContains(t, err, "is locked by", "the lock should be exclusive")
+
+	require.NoError(t, client.Unlock("deploy"))
+	assert.NoFileExists(t, filepath.Join(home, LockDir, "deploy.lock"))
+}
+
+func TestLocalClientWindowsPlatform(t *testing.T) {
+	_, err := NewClientFactory().NewClient(&target.Target{Name: "local", Local: true, Platform: target.PlatformWindows})
+	assert.ErrorContains(t, err, "local target 'local' can't use the windows platform")
+}
+
+func TestLocalFilesPath(t *testing.T) {
+	files := &localFiles{dir: filepath.FromSlash("/home/deploy")}
+	assert.Equal(t, filepath.FromSlash("/home/deploy/.nship/state.json"), files.path(".nship/state.json"))
+	assert.Equal(t, filepath.FromSlash("/srv/app"), files.path("/srv/app"))
+}
+
+func TestLocalSessionSignalBeforeStart(t *testing.T) {
+	session := &localSession{}
+	assert.ErrorIs(t, session.Signal("TERM"), errLocalNotStarted)
+	assert.ErrorIs(t, session.Wait(), errLocalNotStarted)
+	assert.NoError(t, session.Close())
+}
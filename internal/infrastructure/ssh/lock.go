@@ -0,0 +1,154 @@
+package ssh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/nickalie/nship/internal/util"
+)
+
+// LockDir is the directory of the deploy locks on a target, relative to the home directory of the login user
+const LockDir = ".nship"
+
+// LockStaleAfter is the age after which a deploy lock is considered left behind by a deployment that died
+const LockStaleAfter = 6 * time.Hour
+
+// deployLock is the content of a lock file, identifying the deployment holding the lock
+type deployLock struct {
+	User string    `json:"user"`
+	Host string    `json:"host"`
+	PID  int       `json:"pid"`
+	Time time.Time `json:"time"`
+}
+
+// currentLock returns the lock of the deployment run by this process
+func currentLock() deployLock {
+	host, _ := os.Hostname()
+	user := os.Getenv("USER")
+	if user == "" {
+		user = os.Getenv("USERNAME")
+	}
+	return deployLock{User: user, Host: host, PID: os.Getpid(), Time: time.Now().UTC()}
+}
+
+// ownedByThisProcess reports whether the lock was taken by this process
+func (l deployLock) ownedByThisProcess() bool {
+	current := currentLock()
+	return l.Host == current.Host && l.PID == current.PID
+}
+
+func (l deployLock) String() string {
+	return fmt.Sprintf("%s@%s (pid %d) since %s", l.User, l.Host, l.PID, l.Time.Local().Format(time.DateTime))
+}
+
+// lockPath returns the path of the lock file of name on a target
+func lockPath(name string) string {
+	return path.Join(LockDir, util.SafeFileName(name)+".lock")
+}
+
+// Lock implements job.LockingClient. The lock file is created exclusively, so only one deployment gets
+// the lock. A lock held by another deployment fails the deployment unless it is older than LockStaleAfter
+// or force is set, in which case the lock is removed and taken. Two deployments replacing the same lock
+// at once may both remove it, and the one taking the lock first may then lose it to the other.
+func (c *SSHClient) Lock(name string, force bool) error {
+	if err := c.sftpClient.MkdirAll(LockDir); err != nil {
+		return fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	lockFile := lockPath(name)
+	err := c.createLock(lockFile)
+	if !errors.Is(err, os.ErrExist) {
+		return err
+	}
+
+	if err := c.checkLock(lockFile, force); err != nil {
+		return err
+	}
+	if err := c.sftpClient.Remove(lockFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove deploy lock: %w", err)
+	}
+	return c.createLock(lockFile)
+}
+
+// createLock creates the lock file, failing with an error matching os.ErrExist if it exists
+func (c *SSHClient) createLock(lockFile string) error {
+	data, err := json.Marshal(currentLock())
+	if err != nil {
+		return fmt.Errorf("failed to marshal deploy lock: %w", err)
+	}
+
+	file, err := c.sftpClient.CreateExclusive(lockFile)
+	if errors.Is(err, os.ErrExist) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create deploy lock: %w", err)
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write deploy lock: %w", err)
+	}
+	return file.Close()
+}
+
+// checkLock returns an error unless the existing lock file may be replaced because it is stale or force is set
+func (c *SSHClient) checkLock(lockFile string, force bool) error {
+	holder, err := c.readLock(lockFile)
+	switch {
+	case force && err != nil:
+		fmt.Fprintf(c.out.Stdout(), "Warning: removing the unreadable deploy lock of target '%s'\n", c.target.GetName())
+	case force:
+		fmt.Fprintf(c.out.Stdout(), "Warning: removing the deploy lock of target '%s' held by %s\n", c.target.GetName(), holder)
+	case err != nil:
+		return fmt.Errorf("target '%s' is locked by another deployment, but its lock can't be read (%w); "+
+			"remove it with --force-unlock", c.target.GetName(), err)
+	case time.Since(holder.Time) < LockStaleAfter:
+		return fmt.Errorf("target '%s' is locked by %s; if that deployment is no longer running, remove the lock with --force-unlock",
+			c.target.GetName(), holder)
+	default:
+		fmt.Fprintf(c.out.Stdout(), "Warning: removing the stale deploy lock of target '%s' held by %s\n", c.target.GetName(), holder)
+	}
+	return nil
+}
+
+// readLock reads the lock file
+func (c *SSHClient) readLock(lockFile string) (deployLock, error) {
+	var lock deployLock
+	file, err := c.sftpClient.Open(lockFile)
+	if err != nil {
+		return lock, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return lock, err
+	}
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return lock, fmt.Errorf("invalid lock file %s: %w", lockFile, err)
+	}
+	return lock, nil
+}
+
+// Unlock implements job.LockingClient. The lock file is only removed while it holds the lock of this process,
+// so a deployment whose lock was removed with force doesn't release the lock of the deployment that took it.
+func (c *SSHClient) Unlock(name string) error {
+	lockFile := lockPath(name)
+	holder, err := c.readLock(lockFile)
+	if err != nil {
+		return fmt.Errorf("failed to read deploy lock: %w", err)
+	}
+	if !holder.ownedByThisProcess() {
+		return fmt.Errorf("the deploy lock was taken over by %s", holder)
+	}
+	if err := c.sftpClient.Remove(lockFile); err != nil {
+		return fmt.Errorf("failed to remove deploy lock: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// newLockClient returns a client whose SFTP server has home as its working directory, and its progress output
+func newLockClient(t *testing.T, home string) (*SSHClient, *bytes.Buffer) {
+	var out bytes.Buffer
+	client := &SSHClient{
+		sftpClient: NewSFTPAdapter(newPipeSFTPClient(t, sftp.WithServerWorkingDirectory(home))),
+		target:     &target.Target{Name: "web"},
+		out:        job.NewOutput(&out, &out),
+	}
+	return client, &out
+}
+
+// writeLock writes a lock file held by another process into home
+func writeLock(t *testing.T, home, name string, lock deployLock) string {
+	data, err := json.Marshal(lock)
+	require.NoError(t, err)
+	lockFile := filepath.Join(home, LockDir, name+".lock")
+	require.NoError(t, os.MkdirAll(filepath.Dir(lockFile), 0755))
+	require.NoError(t, os.WriteFile(lockFile, data, 0644))
+	return lockFile
+}
+
+func TestLockUnlock(t *testing.T) {
+	home := t.TempDir()
+	client, _ := newLockClient(t, home)
+
+	require.NoError(t, client.Lock("shop/api", false))
+	lockFile := filepath.Join(home, ".nship", "shop_api.lock")
+	data, err := os.ReadFile(lockFile)
+	require.NoError(t, err)
+	var lock deployLock
+	require.NoError(t, json.Unmarshal(data, &lock))
+	assert.Equal(t, os.Getpid(), lock.PID)
+	assert.WithinDuration(t, time.Now(), lock.Time, time.Minute)
+
+	other, _ := newLockClient(t, home)
+	err = other.Lock("shop/api", false)
+	assert.ErrorContains(t, err, "target 'web' is locked by ")
+	assert.ErrorContains(t, err, "remove the lock with --force-unlock")
+	require.NoError(t, other.Lock("blog", false), "locks of other projects should not conflict")
+
+	require.NoError(t, client.Unlock("shop/api"))
+	assert.NoFileExists(t, lockFile)
+	require.NoError(t, other.Lock("shop/api", false))
+}
+
+func TestLockHeldByOtherDeployment(t *testing.T) {
+	holder := deployLock{User: "alice", Host: "laptop", PID: 42, Time: time.Now().Add(-time.Minute)}
+
+	tests := []struct {
+		name      string
+		lock      deployLock
+		content   string
+		force     bool
+		expectErr string
+		warning   string
+	}{
+		{name: "fresh", lock: holder, expectErr: "target 'web' is locked by alice@laptop (pid 42) since "},
+		{name: "fresh with force", lock: holder, force: true,
+			warning: "Warning: removing the deploy lock of target 'web' held by alice@laptop (pid 42)"},
+		{name: "stale", lock: deployLock{User: "bob", Host: "ci", PID: 7, Time: time.Now().Add(-LockStaleAfter - time.Minute)},
+			warning: "Warning: removing the stale deploy lock of target 'web' held by bob@ci (pid 7)"},
+		{name: "unreadable", content: "{", expectErr: "target 'web' is locked by another deployment, but its lock can't be read"},
+		{name: "unreadable with force", content: "{", force: true, warning: "Warning: removing the unreadable deploy lock of target 'web'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			home := t.TempDir()
+			lockFile := writeLock(t, home, "shop", tt.lock)
+			if tt.content != "" {
+				require.NoError(t, os.WriteFile(lockFile, []byte(tt.content), 0644))
+			}
+			before, err := os.ReadFile(lockFile)
+			require.NoError(t, err)
+
+			client, out := newLockClient(t, home)
+			err = client.Lock("shop", tt.force)
+			after, readErr := os.ReadFile(lockFile)
+			require.NoError(t, readErr)
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+				assert.Equal(t, before, after, "the lock should be kept")
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Contains(t, out.String(), tt.warning)
+			assert.Contains(t, string(after), `"pid":`+strconv.Itoa(os.Getpid()), "the lock should be taken")
+		})
+	}
+}
+
+func TestUnlockTakenOver(t *testing.T) {
+	home := t.TempDir()
+	client, _ := newLockClient(t, home)
+	require.NoError(t, client.Lock("shop", false))
+
+	lockFile := writeLock(t, home, "shop", deployLock{User: "alice", Host: "laptop", PID: 42, Time: time.Now()})
+	assert.ErrorContains(t, client.Unlock("shop"), "the deploy lock was taken over by alice@laptop (pid 42)")
+	assert.FileExists(t, lockFile, "the lock of the other deployment should be kept")
+
+	require.NoError(t, os.Remove(lockFile))
+	assert.ErrorContains(t, client.Unlock("shop"), "failed to read deploy lock")
+}
+
+func TestSFTPAdapterCreateExclusive(t *testing.T) {
+	dir := t.TempDir()
+	client := NewSFTPAdapter(newPipeSFTPClient(t))
+	file := filepath.Join(dir, "lock")
+
+	w, err := client.CreateExclusive(file)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("mine"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	_, err = client.CreateExclusive(file)
+	assert.ErrorIs(t, err, os.ErrExist)
+	content, err := os.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "mine", string(content))
+
+	_, err = client.CreateExclusive(filepath.Join(dir, "missing", "lock"))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, os.ErrExist)
+}
@@ -0,0 +1,92 @@
+package ssh
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nickalie/nship/internal/core/target"
+	"github.com/nickalie/nship/internal/util"
+)
+
+// negotiationErrorPattern matches the handshake error of crypto/ssh when the client and the server
+// have no algorithm in common, capturing the kind of algorithm and the algorithms the server offered
+var negotiationErrorPattern = regexp.MustCompile(
+	`no common algorithm for ([^;]+); client offered: \[[^\]]*\], server offered: \[([^\]]*)\]`)
+
+// authErrorPattern matches the handshake error of crypto/ssh when the server rejected every authentication
+// method, capturing the methods that were tried
+var authErrorPattern = regexp.MustCompile(`unable to authenticate, attempted methods \[([^\]]*)\]`)
+
+// algorithmOption describes the target option enabling algorithms of one kind
+type algorithmOption struct {
+	name      string
+	supported func(offered []string) []string
+}
+
+// algorithmOptions maps the kinds of algorithms in negotiation errors to the target options enabling them
+var algorithmOptions = map[string]algorithmOption{
+	"key exchange": {"kex_algorithms", func(offered []string) []string {
+		return util.SupportedSSHAlgorithms(ssh.Config{KeyExchanges: offered}).KeyExchanges
+	}},
+	"cipher": {"ciphers", func(offered []string) []string {
+		return util.SupportedSSHAlgorithms(ssh.Config{Ciphers: offered}).Ciphers
+	}},
+	"MAC": {"macs", func(offered []string) []string {
+		return util.SupportedSSHAlgorithms(ssh.Config{MACs: offered}).MACs
+	}},
+	"host key": {"host_key_algorithms", util.SupportedSSHHostKeyAlgorithms},
+}
+
+// explainHandshakeError adds to a failed algorithm negotiation what the server offered and how to enable
+// a matching algorithm, and to a failed authentication which user and methods the server rejected.
+// Other errors are returned as is.
+func explainHandshakeError(err error, tgt *target.Target) error {
+	if match := negotiationErrorPattern.FindStringSubmatch(err.Error()); match != nil {
+		return explainNegotiationError(err, match[1], strings.Fields(match[2]))
+	}
+	if match := authErrorPattern.FindStringSubmatch(err.Error()); match != nil {
+		return explainAuthError(err, tgt, strings.Fields(match[1]))
+	}
+	return err
+}
+
+// explainNegotiationError adds to a failed negotiation of kind algorithms the algorithms the server offered
+// and the target option enabling one of them
+func explainNegotiationError(err error, kind string, offered []string) error {
+	hint := fmt.Sprintf("the server only offers these %s algorithms: %s", kind, strings.Join(offered, ", "))
+
+	option, ok := algorithmOption{}, false
+	for suffix, o := range algorithmOptions {
+		if strings.HasSuffix(kind, suffix) {
+			option, ok = o, true
+		}
+	}
+	if !ok {
+		return fmt.Errorf("%w\n%s", err, hint)
+	}
+
+	if enable := option.supported(offered); len(enable) > 0 {
+		return fmt.Errorf("%w\n%s\nthey may be disabled by default as insecure, enable one of them with %s in the target config, e.g. %s: [%s]",
+			err, hint, option.name, option.name, enable[0])
+	}
+	return fmt.Errorf("%w\n%s\nnone of them is supported by nship", err, hint)
+}
+
+// explainAuthError adds to a failed authentication the user and the methods the server rejected, and what
+// to check in the target config
+func explainAuthError(err error, tgt *target.Target, methods []string) error {
+	hint := fmt.Sprintf("the server rejected user '%s' with the methods tried: %s", tgt.User, strings.Join(methods, ", "))
+	switch {
+	case tgt.PrivateKey != "" && !slices.Contains(methods, "publickey"):
+		_, keyErr := loadPrivateKey(tgt.PrivateKey)
+		return fmt.Errorf("%w\n%s\nthe private key %s could not be used: %v", err, hint, tgt.PrivateKey, keyErr)
+	case tgt.Password == "" && tgt.PrivateKey == "":
+		return fmt.Errorf("%w\n%s\nthe target sets neither password nor private_key", err, hint)
+	}
+	return fmt.Errorf("%w\n%s\ncheck user, password and private_key of the target, and that the key is in the authorized_keys of the user",
+		err, hint)
+}
@@ -0,0 +1,116 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+func TestExplainHandshakeError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  string
+		want string
+	}{
+		{
+			name: "legacy key exchange",
+			err: "ssh: handshake failed: ssh: no common algorithm for key exchange; " +
+				"client offered: [curve25519-sha256 ecdh-sha2-nistp256], server offered: [diffie-hellman-group1-sha1 kex-strange]",
+			want: "the server only offers these key exchange algorithms: diffie-hellman-group1-sha1, kex-strange\n" +
+				"they may be disabled by default as insecure, enable one of them with kex_algorithms in the target config, " +
+				"e.g. kex_algorithms: [diffie-hellman-group1-sha1]",
+		},
+		{
+			name: "legacy cipher",
+			err: "ssh: handshake failed: ssh: no common algorithm for client to server cipher; " +
+				"client offered: [aes128-gcm@openssh.com], server offered: [aes128-cbc 3des-cbc]",
+			want: "the server only offers these client to server cipher algorithms: aes128-cbc, 3des-cbc\n" +
+				"they may be disabled by default as insecure, enable one of them with ciphers in the target config, " +
+				"e.g. ciphers: [aes128-cbc]",
+		},
+		{
+			name: "unsupported MAC",
+			err: "ssh: handshake failed: ssh: no common algorithm for server to client MAC; " +
+				"client offered: [hmac-sha2-256], server offered: [umac-64@openssh.com]",
+			want: "the server only offers these server to client MAC algorithms: umac-64@openssh.com\n" +
+				"none of them is supported by nship",
+		},
+		{
+			name: "host key",
+			err: "ssh: handshake failed: ssh: no common algorithm for host key; " +
+				"client offered: [ssh-ed25519], server offered: [ssh-rsa]",
+			want: "the server only offers these host key algorithms: ssh-rsa\n" +
+				"they may be disabled by default as insecure, enable one of them with host_key_algorithms in the target config, " +
+				"e.g. host_key_algorithms: [ssh-rsa]",
+		},
+		{
+			name: "password rejected",
+			err:  "ssh: handshake failed: ssh: unable to authenticate, attempted methods [none password], no supported methods remain",
+			want: "the server rejected user 'deploy' with the methods tried: none, password\n" +
+				"check user, password and private_key of the target, and that the key is in the authorized_keys of the user",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cause := errors.New(tt.err)
+			err := explainHandshakeError(cause, &target.Target{User: "deploy", Password: "secret"})
+			assert.ErrorIs(t, err, cause)
+			assert.Equal(t, tt.err+"\n"+tt.want, err.Error())
+		})
+	}
+
+	other := errors.New("dial tcp 10.0.0.1:22: i/o timeout")
+	assert.Equal(t, other, explainHandshakeError(other, &target.Target{}), "other errors should be returned as is")
+}
+
+func TestExplainAuthError(t *testing.T) {
+	cause := errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none], no supported methods remain")
+
+	err := explainHandshakeError(cause, &target.Target{User: "deploy", PrivateKey: "/missing/id_ed25519"})
+	assert.ErrorIs(t, err, cause)
+	assert.Contains(t, err.Error(), "the private key /missing/id_ed25519 could not be used: ")
+
+	err = explainHandshakeError(cause, &target.Target{User: "deploy"})
+	assert.Contains(t, err.Error(), "the target sets neither password nor private_key")
+}
+
+func TestExplainHandshakeErrorFromServer(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	serverConfig := &ssh.ServerConfig{
+		Config:       ssh.Config{KeyExchanges: []string{"diffie-hellman-group1-sha1"}},
+		NoClientAuth: true,
+	}
+	serverConfig.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _, _, _ = ssh.NewServerConn(conn, serverConfig)
+	}()
+
+	_, err = ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "deploy",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.Error(t, err)
+	assert.Contains(t, explainHandshakeError(err, &target.Target{}).Error(), "e.g. kex_algorithms: [diffie-hellman-group1-sha1]")
+}
@@ -0,0 +1,97 @@
+package ssh
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// OutputOptions configures how the output of remote commands is written
+type OutputOptions struct {
+	// Merge writes the lines of standard error to standard output, tagging each line with its stream
+	Merge bool
+	// Timestamps prefixes each line with the time it was received
+	Timestamps bool
+}
+
+// timestampFormat is the format of the times prefixed to output lines
+const timestampFormat = "15:04:05.000"
+
+// Output streams, used as tags of merged lines
+const (
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+)
+
+// outputSink writes the output of remote commands line by line. A mutex serializes the lines of
+// all streams and clients writing to it, so lines are never split or interleaved with each other.
+type outputSink struct {
+	mu      sync.Mutex
+	stdout  io.Writer
+	stderr  io.Writer
+	options OutputOptions
+	now     func() time.Time
+}
+
+// defaultOutput is the sink of clients that have none, writing lines unchanged to os.Stdout and os.Stderr
+var defaultOutput = newOutputSink(os.Stdout, os.Stderr, OutputOptions{})
+
+// newOutputSink creates an outputSink writing to stdout and stderr
+func newOutputSink(stdout, stderr io.Writer, options OutputOptions) *outputSink {
+	return &outputSink{stdout: stdout, stderr: stderr, options: options, now: time.Now}
+}
+
+// Stdout returns a writer for the standard output of a command
+func (s *outputSink) Stdout() io.Writer {
+	return &lineWriter{sink: s, stream: streamStdout}
+}
+
+// Stderr returns a writer for the standard error of a command
+func (s *outputSink) Stderr() io.Writer {
+	return &lineWriter{sink: s, stream: streamStderr}
+}
+
+// writeLine writes a complete line of stream with the configured prefixes
+func (s *outputSink) writeLine(stream string, line []byte) error {
+	var prefix string
+	if s.options.Timestamps {
+		prefix = s.now().Format(timestampFormat) + " "
+	}
+	if s.options.Merge {
+		prefix += "[" + stream + "] "
+	}
+
+	w := s.stdout
+	if stream == streamStderr && !s.options.Merge {
+		w = s.stderr
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := w.Write(append([]byte(prefix), line...))
+	return err
+}
+
+// lineWriter buffers the output of a stream and passes it to the sink one complete line at a time
+type lineWriter struct {
+	sink   *outputSink
+	stream string
+	buf    []byte
+}
+
+// Write implements io.Writer
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := w.sink.writeLine(w.stream, w.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+}
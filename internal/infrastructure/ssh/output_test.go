@@ -0,0 +1,86 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputSinkSeparateStreams(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	sink := newOutputSink(&stdout, &stderr, OutputOptions{})
+
+	fmt.Fprintln(sink.Stdout(), "building")
+	fmt.Fprintln(sink.Stderr(), "warning: cache miss")
+
+	assert.Equal(t, "building\n", stdout.String(), "lines should be written unchanged by default")
+	assert.Equal(t, "warning: cache miss\n", stderr.String())
+}
+
+func TestOutputSinkMergeAndTimestamps(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	sink := newOutputSink(&stdout, &stderr, OutputOptions{Merge: true, Timestamps: true})
+	sink.now = func() time.Time { return time.Date(2024, 1, 1, 12, 30, 45, 123000000, time.UTC) }
+
+	fmt.Fprintln(sink.Stdout(), "building")
+	fmt.Fprintln(sink.Stderr(), "warning: cache miss")
+
+	assert.Equal(t, "12:30:45.123 [stdout] building\n12:30:45.123 [stderr] warning: cache miss\n", stdout.String())
+	assert.Empty(t, stderr.String(), "merged stderr should be written to stdout")
+}
+
+func TestOutputSinkBuffersPartialLines(t *testing.T) {
+	var stdout bytes.Buffer
+	sink := newOutputSink(&stdout, &stdout, OutputOptions{Merge: true})
+
+	out := sink.Stdout()
+	_, _ = out.Write([]byte("progress: 10%"))
+	assert.Empty(t, stdout.String(), "a partial line should not be written")
+
+	_, _ = out.Write([]byte(" done\nnext"))
+	assert.Equal(t, "[stdout] progress: 10% done\n", stdout.String())
+}
+
+func TestOutputSinkKeepsLinesWhole(t *testing.T) {
+	var out bytes.Buffer
+	sink := newOutputSink(&out, &out, OutputOptions{})
+
+	streams := map[string]io.Writer{"out": sink.Stdout(), "err": sink.Stderr()}
+
+	var wg sync.WaitGroup
+	for text, w := range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			line := strings.Repeat(text, 10) + "\n"
+			for range 200 {
+				// Write every line in small chunks to provoke interleaving
+				for i := 0; i < len(line); i += 3 {
+					_, _ = w.Write([]byte(line[i:min(i+3, len(line))]))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Len(t, lines, 400)
+	for _, line := range lines {
+		assert.Contains(t, []string{strings.Repeat("out", 10), strings.Repeat("err", 10)}, line, "lines should never be split")
+	}
+}
+
+func TestPipeOutputLongLines(t *testing.T) {
+	long := strings.Repeat("x", 200*1024)
+
+	var out bytes.Buffer
+	pipeOutput(strings.NewReader(long+"\r\nafter\nlast"), &out, nil)
+
+	assert.Equal(t, long+"\nafter\nlast\n", out.String(), "lines longer than the scanner buffer should not stop the output")
+}
@@ -0,0 +1,131 @@
+package ssh
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/nickalie/nship/internal/core/job"
+)
+
+// powershellShells lists the executables of Windows PowerShell and PowerShell 7
+var powershellShells = map[string]bool{"powershell": true, "pwsh": true}
+
+// powershellQuotes lists the characters PowerShell accepts as single quotes, which are escaped by doubling them
+const powershellQuotes = "'‘’‚‛"
+
+// powershellSyntax is the syntax of Windows PowerShell and PowerShell 7 on Windows targets.
+// With profile set, PowerShell loads the profile scripts of the user like a login shell.
+type powershellSyntax struct {
+	profile bool
+}
+
+// command passes script Base64-encoded in -EncodedCommand, so it needs no escaping for cmd.exe or PowerShell,
+// whichever is the default shell of the OpenSSH server. Progress output is silenced, as PowerShell writes it
+// to standard error as CLIXML when its output is not a console.
+func (p powershellSyntax) command(shell, script string) (string, error) {
+	script = "$ProgressPreference = 'SilentlyContinue'\n" + script
+	units := utf16.Encode([]rune(script))
+	encoded := make([]byte, 0, len(units)*2)
+	for _, unit := range units {
+		encoded = binary.LittleEndian.AppendUint16(encoded, unit)
+	}
+	options := "-NoProfile -NonInteractive"
+	if p.profile {
+		options = "-NonInteractive"
+	}
+	return fmt.Sprintf("%s %s -EncodedCommand %s", shell, options, base64.StdEncoding.EncodeToString(encoded)), nil
+}
+
+func (p powershellSyntax) prelude(step *job.Step) string {
+	var prelude strings.Builder
+	if step.Workdir != "" {
+		fmt.Fprintf(&prelude, "Set-Location -LiteralPath %s -ErrorAction Stop\n", p.quote(step.Workdir))
+	}
+	prelude.WriteString(p.setEnv(step.Env))
+	return prelude.String()
+}
+
+func (p powershellSyntax) setEnv(env map[string]string) string {
+	var prelude strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		fmt.Fprintf(&prelude, "${env:%s} = %s\n", name, p.quote(env[name]))
+	}
+	return prelude.String()
+}
+
+// failFast stops at failing cmdlets and, from PowerShell 7.3 on, at native commands exiting with an error
+func (powershellSyntax) failFast(string) string {
+	return "$ErrorActionPreference = 'Stop'\n$PSNativeCommandUseErrorActionPreference = $true\n"
+}
+
+func (powershellSyntax) quote(s string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('\'')
+	for _, r := range s {
+		if strings.ContainsRune(powershellQuotes, r) {
+			quoted.WriteRune(r)
+		}
+		quoted.WriteRune(r)
+	}
+	quoted.WriteByte('\'')
+	return quoted.String()
+}
+
+func (p powershellSyntax) assignment(key, value string) string { return p.quote(key + "=" + value) }
+
+func (powershellSyntax) ignoreFailure(cmd string) string {
+	return cmd + " 2>$null; $global:LASTEXITCODE = 0"
+}
+
+func (powershellSyntax) joinAll(commands []string) string {
+	return strings.Join(commands, "\nif ($LASTEXITCODE -ne 0) { exit $LASTEXITCODE }\n")
+}
+
+// tempPath returns a path relative to the home directory, where the SFTP server of Windows OpenSSH starts
+func (powershellSyntax) tempPath(name string) string { return name }
+
+func (p powershellSyntax) runScript(script *job.RunScriptStep, remotePath string) string {
+	parts := []string{"&"}
+	if script.Interpreter != "" {
+		parts = []string{script.Interpreter}
+	}
+	parts = append(parts, fmt.Sprintf("(Join-Path $HOME %s)", p.quote(remotePath)))
+	for _, arg := range script.Args {
+		parts = append(parts, p.quote(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// isPowerShell reports whether shell runs Windows PowerShell or PowerShell 7
+func isPowerShell(shell string) bool {
+	fields := strings.Fields(shell)
+	if len(fields) == 0 {
+		return false
+	}
+	name := fields[0][strings.LastIndexAny(fields[0], `/\`)+1:]
+	return powershellShells[strings.TrimSuffix(strings.ToLower(name), ".exe")]
+}
+
+// checkPlatform rejects steps using features that Windows targets don't support
+func (c *SSHClient) checkPlatform(step *job.Step) error {
+	if !c.windows() {
+		return nil
+	}
+
+	switch shell := step.GetShellFor(c.target); {
+	case !isPowerShell(shell):
+		return fmt.Errorf("shell '%s' is not supported on Windows target '%s', use powershell or pwsh", shell, c.target.GetName())
+	case step.UsesSudo():
+		return fmt.Errorf("sudo is not supported on Windows target '%s'", c.target.GetName())
+	case step.Copy != nil && step.Copy.Container != "":
+		return fmt.Errorf("copying into containers is not supported on Windows target '%s'", c.target.GetName())
+	case step.Service != nil:
+		return fmt.Errorf("service steps are not supported on Windows target '%s'", c.target.GetName())
+	}
+	return nil
+}
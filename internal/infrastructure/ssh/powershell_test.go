@@ -0,0 +1,190 @@
+package ssh
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+	"github.com/nickalie/nship/internal/infrastructure/fs"
+)
+
+// decodePowerShellCommand returns the script of a command line built by powershellSyntax
+func decodePowerShellCommand(t *testing.T, line string) string {
+	t.Helper()
+	prefix, encoded, ok := strings.Cut(line, " -NoProfile -NonInteractive -EncodedCommand ")
+	require.True(t, ok, "unexpected command line %q", line)
+	assert.NotContains(t, prefix, " ")
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	require.NoError(t, err)
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(data[2*i:])
+	}
+	script, ok := strings.CutPrefix(string(utf16.Decode(units)), "$ProgressPreference = 'SilentlyContinue'\n")
+	require.True(t, ok, "progress output should be silenced")
+	return script
+}
+
+func TestPowerShellCommand(t *testing.T) {
+	script := "Write-Output \"it's $env:USERNAME\"\r\nGet-ChildItem C:\\ | Select-Object -First 1 # 🚀"
+	line, err := powershellSyntax{}.command("pwsh", script)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "pwsh -NoProfile -NonInteractive -EncodedCommand "))
+	assert.Equal(t, script, decodePowerShellCommand(t, line))
+}
+
+func TestPowerShellQuote(t *testing.T) {
+	syntax := powershellSyntax{}
+	assert.Equal(t, "''", syntax.quote(""))
+	assert.Equal(t, "'C:\\Program Files\\app'", syntax.quote("C:\\Program Files\\app"))
+	assert.Equal(t, "'$HOME `n \"x\"'", syntax.quote("$HOME `n \"x\""), "only single quotes should be special")
+	assert.Equal(t, "'it''s'", syntax.quote("it's"))
+	assert.Equal(t, "'it’’s'", syntax.quote("it’s"), "typographic quotes end PowerShell strings too")
+}
+
+func TestPowerShellPrelude(t *testing.T) {
+	syntax := powershellSyntax{}
+	assert.Empty(t, syntax.prelude(&job.Step{Run: "dir"}))
+
+	step := &job.Step{Run: "dir", Workdir: "C:\\apps\\my app", Env: map[string]string{"B": "it's", "A": "1"}}
+	assert.Equal(t, "Set-Location -LiteralPath 'C:\\apps\\my app' -ErrorAction Stop\n${env:A} = '1'\n${env:B} = 'it''s'\n",
+		syntax.prelude(step))
+	assert.Equal(t, "$ErrorActionPreference = 'Stop'\n$PSNativeCommandUseErrorActionPreference = $true\n",
+		syntax.failFast("powershell"))
+}
+
+func TestPowerShellRunScript(t *testing.T) {
+	syntax := powershellSyntax{}
+	assert.Equal(t, "nship-1-deploy.ps1", syntax.tempPath("nship-1-deploy.ps1"))
+	assert.Equal(t, "& (Join-Path $HOME 'nship-1-deploy.ps1')", syntax.runScript(&job.RunScriptStep{}, "nship-1-deploy.ps1"))
+	assert.Equal(t, "python -u (Join-Path $HOME 'nship-1-s.py') 'a b' 'it''s'",
+		syntax.runScript(&job.RunScriptStep{Interpreter: "python -u", Args: []string{"a b", "it's"}}, "nship-1-s.py"))
+}
+
+func TestIsPowerShell(t *testing.T) {
+	for _, shell := range []string{"powershell", "pwsh", "PowerShell.exe", "C:\\PowerShell\\7\\pwsh.exe", "/usr/bin/pwsh -Login"} {
+		assert.True(t, isPowerShell(shell), shell)
+	}
+	for _, shell := range []string{"", "cmd", "cmd.exe", "sh", "powershell-preview"} {
+		assert.False(t, isPowerShell(shell), shell)
+	}
+}
+
+func TestPowerShellDockerCommands(t *testing.T) {
+	builder := newDockerCommandBuilder(&job.DockerStep{
+		Image:       "mcr.microsoft.com/windows/servercore/iis",
+		Name:        "web",
+		Environment: map[string]string{"GREETING": "it's $HOME"},
+		Labels:      map[string]string{"team": "web ops"},
+		Networks:    []string{"frontend"},
+	}, powershellSyntax{})
+
+	assert.Equal(t, []string{
+		"docker rm -f web 2>$null; $global:LASTEXITCODE = 0",
+		"docker network create frontend 2>$null; $global:LASTEXITCODE = 0",
+		"docker create --name web -e 'GREETING=it''s $HOME' -l 'team=web ops' --network frontend mcr.microsoft.com/windows/servercore/iis",
+		"docker network connect frontend web",
+		"docker start web",
+	}, builder.BuildContainerCommands())
+
+	assert.Equal(t, "docker image inspect --format 'image={{.Id}}' mcr.microsoft.com/windows/servercore/iis 2>$null; $global:LASTEXITCODE = 0\n"+
+		"docker inspect --format 'container={{.Image}} running={{.State.Running}} config={{index .Config.Labels `nship.config`}}' web"+
+		" 2>$null; $global:LASTEXITCODE = 0",
+		builder.BuildInspectCommand())
+
+	assert.Equal(t, "docker exec -w 'C:\\app' 'web' 'cmd' '/c' 'echo it''s'",
+		buildDockerExecCommand(&job.DockerExecStep{Container: "web", Workdir: "C:\\app", Command: []string{"cmd", "/c", "echo it's"}},
+			powershellSyntax{}))
+
+	assert.Equal(t, "docker container prune -f\nif ($LASTEXITCODE -ne 0) { exit $LASTEXITCODE }\ndocker image prune -f -a",
+		powershellSyntax{}.joinAll(BuildDockerPruneCommands(&job.DockerPruneStep{Containers: true, All: true})))
+}
+
+// windowsClient returns a client of a Windows target recording the commands it starts
+func windowsClient(sftpClient SFTPClientInterface, commands *[]string) *SSHClient {
+	return &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				return &MockSSHSession{
+					StartFunc: func(cmd string) error {
+						*commands = append(*commands, cmd)
+						return nil
+					},
+				}, nil
+			},
+		},
+		sftpClient: sftpClient,
+		copier:     *fs.NewCopier(sftpClient),
+		target:     &target.Target{Name: "win", Platform: target.PlatformWindows},
+	}
+}
+
+func TestExecuteStepWindows(t *testing.T) {
+	var commands []string
+	client := windowsClient(&MockSFTPClient{}, &commands)
+
+	step := &job.Step{Run: "iisreset\nGet-Website", Workdir: "C:\\inetpub", Env: map[string]string{"SITE": "default"}}
+	require.NoError(t, client.ExecuteStep(step, 1, 1))
+	require.Len(t, commands, 1)
+	assert.True(t, strings.HasPrefix(commands[0], "powershell "), "powershell should be the default shell")
+	// Commands of more than one line fail fast
+	assert.Equal(t, "$ErrorActionPreference = 'Stop'\n$PSNativeCommandUseErrorActionPreference = $true\n"+
+		"Set-Location -LiteralPath 'C:\\inetpub' -ErrorAction Stop\n${env:SITE} = 'default'\niisreset\nGet-Website",
+		decodePowerShellCommand(t, commands[0]))
+
+	scriptPath := filepath.Join(t.TempDir(), "deploy.ps1")
+	require.NoError(t, os.WriteFile(scriptPath, []byte("Write-Output deploy"), 0644))
+	require.NoError(t, client.ExecuteStep(&job.Step{ScriptFile: scriptPath, Shell: "pwsh"}, 1, 1))
+	require.Len(t, commands, 2)
+	assert.True(t, strings.HasPrefix(commands[1], "pwsh "))
+	assert.Equal(t, "Write-Output deploy", decodePowerShellCommand(t, commands[1]))
+}
+
+func TestExecuteRunScriptWindows(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "deploy.ps1")
+	require.NoError(t, os.WriteFile(scriptPath, []byte("Write-Output deploy"), 0644))
+
+	sftpClient := &scriptSFTPClient{}
+	var commands []string
+	client := windowsClient(sftpClient, &commands)
+
+	require.NoError(t, client.ExecuteStep(&job.Step{RunScript: &job.RunScriptStep{Path: scriptPath, Args: []string{"prod"}}}, 1, 1))
+	assert.True(t, strings.HasPrefix(sftpClient.created, "nship-"), "script should be uploaded to the home directory")
+	assert.NotEqual(t, os.FileMode(0700), sftpClient.mode, "Windows scripts should not be made executable")
+	require.Len(t, commands, 1)
+	assert.Equal(t, "& (Join-Path $HOME '"+sftpClient.created+"') 'prod'", decodePowerShellCommand(t, commands[0]))
+	assert.Equal(t, []string{sftpClient.created}, sftpClient.removed)
+}
+
+func TestCheckPlatformWindows(t *testing.T) {
+	var commands []string
+	client := windowsClient(&MockSFTPClient{}, &commands)
+
+	for _, tt := range []struct {
+		step     *job.Step
+		expected string
+	}{
+		{&job.Step{Run: "dir", Shell: "cmd"}, "shell 'cmd' is not supported on Windows target 'win', use powershell or pwsh"},
+		{&job.Step{Run: "dir", Sudo: true}, "sudo is not supported on Windows target 'win'"},
+		{&job.Step{Copy: &job.CopyStep{Local: "app.conf", Remote: "C:\\app", Container: "app"}},
+			"copying into containers is not supported on Windows target 'win'"},
+		{&job.Step{Service: &job.ServiceStep{Name: "W3SVC", Action: job.ServiceRestart}},
+			"service steps are not supported on Windows target 'win'"},
+	} {
+		assert.EqualError(t, client.ExecuteStep(tt.step, 1, 1), tt.expected)
+	}
+	assert.Empty(t, commands, "unsupported steps should not run anything")
+
+	client.target = &target.Target{Name: "linux"}
+	assert.NoError(t, client.checkPlatform(&job.Step{Run: "id", Shell: "bash", Sudo: true}))
+}
@@ -0,0 +1,156 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// proxyCommandDialer implements SSHDialer by running a proxy command and speaking SSH over its
+// standard input and output, like the ProxyCommand option of OpenSSH
+type proxyCommandDialer struct {
+	args   []string
+	logger *slog.Logger
+}
+
+// newProxyCommandDialer creates a dialer running the proxy command of tgt, which logs the connection
+// to logger unless it is nil
+func newProxyCommandDialer(tgt *target.Target, logger *slog.Logger) *proxyCommandDialer {
+	return &proxyCommandDialer{args: proxyCommandArgs(tgt), logger: logger}
+}
+
+// proxyCommandArgs splits the proxy command of tgt into arguments, replacing %h with the host,
+// %p with the port and %% with a literal %
+func proxyCommandArgs(tgt *target.Target) []string {
+	replacer := strings.NewReplacer("%h", tgt.Host, "%p", strconv.Itoa(tgt.GetPort()), "%%", "%")
+	args := strings.Fields(tgt.ProxyCommand)
+	for i, arg := range args {
+		args[i] = replacer.Replace(arg)
+	}
+	return args
+}
+
+// Dial starts the proxy command and establishes an SSH connection to addr through it, giving up once
+// ctx is done. The command is killed when the connection is closed.
+func (d *proxyCommandDialer) Dial(ctx context.Context, _, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := startProxyCommand(d.args)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newClientConn(ctx, d.logger, conn, addr, config)
+	if err != nil {
+		conn.Close()
+		if output := conn.stderr.String(); output != "" {
+			return nil, fmt.Errorf("%w\nproxy command output:\n%s", err, output)
+		}
+		return nil, err
+	}
+	return client, nil
+}
+
+// proxyConn is a net.Conn over the standard input and output of a proxy command
+type proxyConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr *job.OutputTail
+}
+
+// startProxyCommand starts the command given by args and returns a connection over its standard input and output
+func startProxyCommand(args []string) (*proxyConn, error) {
+	if len(args) == 0 {
+		return nil, errors.New("proxy command is empty")
+	}
+
+	conn := &proxyConn{cmd: exec.Command(args[0], args[1:]...), stderr: &job.OutputTail{}}
+	conn.cmd.Stderr = conn.stderr
+	// Don't wait forever for children of the command that keep its stderr open
+	conn.cmd.WaitDelay = time.Second
+
+	var err error
+	if conn.stdin, err = conn.cmd.StdinPipe(); err != nil {
+		return nil, fmt.Errorf("failed to create proxy command stdin pipe: %w", err)
+	}
+	if conn.stdout, err = conn.cmd.StdoutPipe(); err != nil {
+		return nil, fmt.Errorf("failed to create proxy command stdout pipe: %w", err)
+	}
+	if err := conn.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start proxy command: %w", err)
+	}
+	return conn, nil
+}
+
+// Read implements net.Conn
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.stdout.Read(b)
+}
+
+// Write implements net.Conn
+func (c *proxyConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+// Close implements net.Conn, killing the proxy command and waiting for it to exit
+func (c *proxyConn) Close() error {
+	c.stdin.Close()
+	_ = c.cmd.Process.Kill()
+	_ = c.cmd.Wait()
+	return nil
+}
+
+// LocalAddr implements net.Conn
+func (c *proxyConn) LocalAddr() net.Addr {
+	return proxyAddr{}
+}
+
+// RemoteAddr implements net.Conn
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return proxyAddr{}
+}
+
+// SetDeadline implements net.Conn
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	return errors.Join(c.SetReadDeadline(t), c.SetWriteDeadline(t))
+}
+
+// SetReadDeadline implements net.Conn by setting the deadline of the pipe from the standard output of the command
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	if pipe, ok := c.stdout.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return pipe.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn by setting the deadline of the pipe to the standard input of the command
+func (c *proxyConn) SetWriteDeadline(t time.Time) error {
+	if pipe, ok := c.stdin.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return pipe.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+// proxyAddr is the address of both ends of a proxyConn
+type proxyAddr struct{}
+
+// Network implements net.Addr
+func (proxyAddr) Network() string {
+	return "proxy-command"
+}
+
+// String implements net.Addr
+func (proxyAddr) String() string {
+	return "proxy-command"
+}
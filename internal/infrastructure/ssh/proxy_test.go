@@ -0,0 +1,95 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+func TestProxyCommandArgs(t *testing.T) {
+	tgt := &target.Target{
+		Host:         "db.internal",
+		Port:         2222,
+		ProxyCommand: "cloudflared access ssh --hostname %h  --destination %h:%p --label 100%%",
+	}
+	assert.Equal(t, []string{
+		"cloudflared", "access", "ssh", "--hostname", "db.internal", "--destination", "db.internal:2222", "--label", "100%",
+	}, proxyCommandArgs(tgt))
+}
+
+func TestProxyConn(t *testing.T) {
+	conn, err := startProxyCommand([]string{"cat"})
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf), "data should pass through the command")
+
+	require.NoError(t, conn.Close())
+	assert.NotNil(t, conn.cmd.ProcessState, "the command should have exited")
+}
+
+func TestProxyConnCloseKillsCommand(t *testing.T) {
+	conn, err := startProxyCommand([]string{"sleep", "60"})
+	require.NoError(t, err)
+
+	require.NoError(t, conn.Close())
+	require.NotNil(t, conn.cmd.ProcessState)
+	assert.False(t, conn.cmd.ProcessState.Success(), "the command should have been killed")
+}
+
+func TestStartProxyCommandErrors(t *testing.T) {
+	_, err := startProxyCommand(nil)
+	assert.EqualError(t, err, "proxy command is empty")
+
+	_, err = startProxyCommand([]string{filepath.Join(t.TempDir(), "missing")})
+	assert.ErrorContains(t, err, "failed to start proxy command")
+}
+
+func TestNewClientProxyCommandFailure(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "proxy.sh")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho \"cannot reach $1:$2\" >&2\nexit 1\n"), 0700))
+
+	tgt := &target.Target{Name: "db", Host: "db.internal", User: "deploy", Password: "secret", ProxyCommand: script + " %h %p"}
+	_, err := NewClientFactory().NewClient(tgt)
+	require.Error(t, err)
+
+	var connErr *job.ConnectionError
+	require.True(t, errors.As(err, &connErr))
+	assert.Equal(t, "db", connErr.Target)
+	assert.Contains(t, err.Error(), "cannot reach db.internal:22", "the output of the proxy command should be reported")
+}
+
+func TestProxyConnDeadline(t *testing.T) {
+	conn, err := startProxyCommand([]string{"sleep", "60"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(50*time.Millisecond)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded, "reading should stop at the deadline")
+}
+
+func TestNewClientContextProxyCommandTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	tgt := &target.Target{Name: "db", Host: "db.internal", User: "deploy", Password: "secret", ProxyCommand: "sleep 60"}
+	start := time.Now()
+	_, err := NewClientFactory().NewClientContext(ctx, tgt)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second, "the handshake should be given up at the deadline")
+}
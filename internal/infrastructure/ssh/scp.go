@@ -0,0 +1,449 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nickalie/nship/internal/util"
+)
+
+// errSCPClosed is returned for operations of an scp client after it was closed
+var errSCPClosed = errors.New("scp client is closed")
+
+// scpMissing is printed by the stat commands of the scp client for paths that don't exist
+const scpMissing = "missing"
+
+// scpStatFunc defines nship_stat, which prints the size, hexadecimal mode, modification time and name
+// of files with GNU stat or, failing that, BSD stat
+const scpStatFunc = `nship_stat() { stat -c '%s %f %Y %n' "$@" 2>/dev/null || stat -f '%z %Xp %m %N' "$@"; }` + "\n"
+
+// scpClient implements SFTPClientInterface for servers without the SFTP subsystem. Files are transferred
+// with the scp protocol and the other operations run POSIX commands, each in a session of its own.
+type scpClient struct {
+	sessions SSHClientInterface
+	mu       sync.Mutex
+	open     map[SSHSession]struct{}
+	closed   bool
+}
+
+// newSCPClient creates an scp client opening its sessions with sessions
+func newSCPClient(sessions SSHClientInterface) *scpClient {
+	return &scpClient{sessions: sessions, open: make(map[SSHSession]struct{})}
+}
+
+// session opens a session, which is closed along with the client unless release is called first
+func (c *scpClient) session() (session SSHSession, release func(), err error) {
+	if c.isClosed() {
+		return nil, nil, errSCPClosed
+	}
+	session, err = c.sessions.NewSession()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		_ = session.Close()
+		return nil, nil, errSCPClosed
+	}
+	c.open[session] = struct{}{}
+
+	release = func() {
+		c.mu.Lock()
+		delete(c.open, session)
+		c.mu.Unlock()
+		_ = session.Close()
+	}
+	return session, release, nil
+}
+
+func (c *scpClient) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// Close closes the sessions of running operations, failing them, and rejects new operations
+func (c *scpClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for session := range c.open {
+		_ = session.Close()
+	}
+	return nil
+}
+
+// Interrupt implements interrupter by closing the sessions of running operations, failing them.
+// Unlike Close, later operations open new sessions.
+func (c *scpClient) Interrupt() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for session := range c.open {
+		_ = session.Close()
+	}
+}
+
+// run runs cmd with the login shell of the user and returns its standard output
+func (c *scpClient) run(cmd string) (string, error) {
+	session, release, err := c.session()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	var stdout, stderr bytes.Buffer
+	if err := runSession(context.Background(), session, cmd, nil, &stdout, &stderr, nil); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %w", msg, err)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// runf runs the command formatted from format and the shell-quoted paths
+func (c *scpClient) runf(format string, paths ...string) error {
+	args := make([]any, len(paths))
+	for i, p := range paths {
+		args[i] = util.ShellQuote(p)
+	}
+	_, err := c.run(fmt.Sprintf(format, args...))
+	return err
+}
+
+// MkdirAll implements SFTPClientInterface
+func (c *scpClient) MkdirAll(p string) error {
+	return c.runf("mkdir -p -- %s", p)
+}
+
+// Chmod implements SFTPClientInterface
+func (c *scpClient) Chmod(p string, mode os.FileMode) error {
+	return c.runf(fmt.Sprintf("chmod %o -- %%s", mode.Perm()), p)
+}
+
+// Chtimes implements SFTPClientInterface. touch sets whole seconds only.
+func (c *scpClient) Chtimes(p string, atime, mtime time.Time) error {
+	const touchTime = "200601021504.05"
+	return c.runf(fmt.Sprintf("TZ=UTC0 touch -c -a -t %s -- %%[1]s && TZ=UTC0 touch -c -m -t %s -- %%[1]s",
+		atime.UTC().Format(touchTime), mtime.UTC().Format(touchTime)), p)
+}
+
+// Remove implements SFTPClientInterface by removing a file or an empty directory
+func (c *scpClient) Remove(p string) error {
+	return c.runf("rm -- %[1]s 2>/dev/null || rmdir -- %[1]s", p)
+}
+
+// RemoveAll implements SFTPClientInterface
+func (c *scpClient) RemoveAll(p string) error {
+	return c.runf("rm -rf -- %s", p)
+}
+
+// Rename implements SFTPClientInterface, replacing an existing file at newname
+func (c *scpClient) Rename(oldname, newname string) error {
+	return c.runf("mv -f -- %s %s", oldname, newname)
+}
+
+// Symlink implements SFTPClientInterface
+func (c *scpClient) Symlink(oldname, newname string) error {
+	return c.runf("ln -s -- %s %s", oldname, newname)
+}
+
+// Stat implements SFTPClientInterface, following symbolic links
+func (c *scpClient) Stat(p string) (os.FileInfo, error) {
+	output, err := c.run(fmt.Sprintf("%sif [ -e %[2]s ]; then nship_stat -L -- %[2]s; else echo %[3]s; fi",
+		scpStatFunc, util.ShellQuote(p), scpMissing))
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(output) == scpMissing {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: os.ErrNotExist}
+	}
+	return parseStatLine(strings.TrimSpace(output))
+}
+
+// ReadDir implements SFTPClientInterface. Like SFTP, it doesn't follow symbolic links.
+func (c *scpClient) ReadDir(p string) ([]os.FileInfo, error) {
+	output, err := c.run(scpStatFunc + fmt.Sprintf("cd -- %s 2>/dev/null || { echo %s; exit 0; }\n", util.ShellQuote(p), scpMissing) +
+		`for f in .* *; do case $f in .|..) continue;; esac; if [ -e "$f" ] || [ -L "$f" ]; then nship_stat -- "$f"; fi; done`)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(output) == scpMissing {
+		return nil, &os.PathError{Op: "readdir", Path: p, Err: os.ErrNotExist}
+	}
+
+	var entries []os.FileInfo
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		info, err := parseStatLine(line)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, info)
+	}
+	return entries, nil
+}
+
+// Create implements SFTPClientInterface. The scp protocol announces the size of a file before its content,
+// so the content is buffered in a local temporary file and uploaded when the writer is closed.
+func (c *scpClient) Create(p string) (io.WriteCloser, error) {
+	buffer, err := os.CreateTemp("", "nship-scp-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload buffer: %w", err)
+	}
+	return &scpWriter{client: c, path: p, buffer: buffer}, nil
+}
+
+// CreateExclusive implements SFTPClientInterface. The file is created with the noclobber option of the shell,
+// which fails if it exists, and then written like with Create.
+func (c *scpClient) CreateExclusive(p string) (io.WriteCloser, error) {
+	if err := c.runf("set -C && : > %s", p); err != nil {
+		if _, statErr := c.Stat(p); statErr == nil {
+			return nil, &os.PathError{Op: "create", Path: p, Err: os.ErrExist}
+		}
+		return nil, err
+	}
+	return c.Create(p)
+}
+
+// Open implements SFTPClientInterface by receiving the file with scp -f
+func (c *scpClient) Open(p string) (io.ReadCloser, error) {
+	session, release, err := c.session()
+	if err != nil {
+		return nil, err
+	}
+
+	stdin, acks, err := startSCP(session, "scp -f "+util.ShellQuote(p))
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	size, err := receiveSCPHeader(stdin, acks)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	content := &io.LimitedReader{R: acks, N: size}
+	return &scpReader{LimitedReader: content, stdin: stdin, acks: acks, session: session, release: release}, nil
+}
+
+// upload sends size bytes of content to the remote path with scp -t
+func (c *scpClient) upload(remote string, size int64, content io.Reader) error {
+	session, release, err := c.session()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	stdin, acks, err := startSCP(session, "scp -t "+util.ShellQuote(remote))
+	if err != nil {
+		return err
+	}
+
+	if err := sendSCPFile(stdin, acks, path.Base(remote), size, content); err != nil {
+		return err
+	}
+	_ = stdin.Close()
+	return session.Wait()
+}
+
+// startSCP starts an scp command in session and returns its input and its output, from which the responses are read
+func startSCP(session SSHSession, cmd string) (io.WriteCloser, *bufio.Reader, error) {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get stdin pipe: %w", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get stdout pipe: %w", err)
+	}
+	if err := session.Start(cmd); err != nil {
+		return nil, nil, fmt.Errorf("failed to start scp: %w", err)
+	}
+	return stdin, bufio.NewReader(stdout), nil
+}
+
+// sendSCPFile sends a file to scp -t: once it is ready, the C message with the mode, size and name,
+// then the content followed by a zero byte. Each step is acknowledged by scp.
+func sendSCPFile(stdin io.Writer, acks *bufio.Reader, name string, size int64, content io.Reader) error {
+	if err := readSCPAck(acks); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(stdin, "C0644 %d %s\n", size, name); err != nil {
+		return fmt.Errorf("failed to send scp header: %w", err)
+	}
+	if err := readSCPAck(acks); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(stdin, content, size); err != nil {
+		return fmt.Errorf("failed to send file content: %w", err)
+	}
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return fmt.Errorf("failed to send file content: %w", err)
+	}
+	return readSCPAck(acks)
+}
+
+// receiveSCPHeader tells scp -f to send the file and returns its size from the C message
+func receiveSCPHeader(stdin io.Writer, acks *bufio.Reader) (int64, error) {
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return 0, fmt.Errorf("failed to start scp transfer: %w", err)
+	}
+
+	line, err := acks.ReadString('\n')
+	if err != nil {
+		return 0, fmt.Errorf("failed to read scp response: %w", err)
+	}
+	if line[0] == 1 || line[0] == 2 {
+		return 0, fmt.Errorf("scp: %s", strings.TrimSpace(line[1:]))
+	}
+
+	var mode uint32
+	var size int64
+	if _, err := fmt.Sscanf(line, "C%o %d ", &mode, &size); err != nil {
+		return 0, fmt.Errorf("unexpected scp message %q", strings.TrimSpace(line))
+	}
+
+	_, err = stdin.Write([]byte{0})
+	return size, err
+}
+
+// readSCPAck reads the response of scp to a message, a zero byte or an error message
+func readSCPAck(acks *bufio.Reader) error {
+	b, err := acks.ReadByte()
+	if err != nil {
+		return fmt.Errorf("failed to read scp response: %w", err)
+	}
+	if b == 0 {
+		return nil
+	}
+	msg, _ := acks.ReadString('\n')
+	return fmt.Errorf("scp: %s", strings.TrimSpace(msg))
+}
+
+// scpWriter buffers the content of a file created with an scp client until it is closed
+type scpWriter struct {
+	client *scpClient
+	path   string
+	buffer *os.File
+}
+
+// Write implements io.Writer
+func (w *scpWriter) Write(p []byte) (int, error) {
+	return w.buffer.Write(p)
+}
+
+// Close uploads the buffered content and removes the buffer
+func (w *scpWriter) Close() error {
+	defer os.Remove(w.buffer.Name())
+	defer w.buffer.Close()
+
+	size, err := w.buffer.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = w.buffer.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read upload buffer: %w", err)
+	}
+	return w.client.upload(w.path, size, w.buffer)
+}
+
+// scpReader reads the content of a file received from scp -f
+type scpReader struct {
+	*io.LimitedReader
+	stdin   io.WriteCloser
+	acks    *bufio.Reader
+	session SSHSession
+	release func()
+}
+
+// Close completes the transfer if the content was read completely and closes the session
+func (r *scpReader) Close() error {
+	defer r.release()
+	if r.N > 0 {
+		return nil
+	}
+
+	err := readSCPAck(r.acks)
+	if err == nil {
+		_, err = r.stdin.Write([]byte{0})
+	}
+	_ = r.stdin.Close()
+	return errors.Join(err, r.session.Wait())
+}
+
+// parseStatLine parses a line printed by nship_stat
+func parseStatLine(line string) (os.FileInfo, error) {
+	fields := strings.SplitN(line, " ", 4)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("unexpected stat output %q", line)
+	}
+
+	size, sizeErr := strconv.ParseInt(fields[0], 10, 64)
+	mode, modeErr := strconv.ParseUint(strings.TrimPrefix(fields[1], "0x"), 16, 32)
+	mtime, mtimeErr := strconv.ParseInt(fields[2], 10, 64)
+	if err := errors.Join(sizeErr, modeErr, mtimeErr); err != nil {
+		return nil, fmt.Errorf("unexpected stat output %q: %w", line, err)
+	}
+
+	return &scpFileInfo{
+		name:    path.Base(fields[3]),
+		size:    size,
+		mode:    unixFileMode(uint32(mode)),
+		modTime: time.Unix(mtime, 0),
+	}, nil
+}
+
+// Unix file type bits of st_mode
+const (
+	unixTypeMask    = 0170000
+	unixTypeDir     = 0040000
+	unixTypeRegular = 0100000
+	unixTypeSymlink = 0120000
+)
+
+// unixFileMode converts the st_mode of a Unix file to an os.FileMode
+func unixFileMode(mode uint32) os.FileMode {
+	fileMode := os.FileMode(mode & 0777)
+	switch mode & unixTypeMask {
+	case unixTypeDir:
+		fileMode |= os.ModeDir
+	case unixTypeSymlink:
+		fileMode |= os.ModeSymlink
+	case unixTypeRegular:
+	default:
+		fileMode |= os.ModeIrregular
+	}
+	return fileMode
+}
+
+// scpFileInfo implements os.FileInfo for files listed with nship_stat
+type scpFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (i *scpFileInfo) Name() string       { return i.name }
+func (i *scpFileInfo) Size() int64        { return i.size }
+func (i *scpFileInfo) Mode() os.FileMode  { return i.mode }
+func (i *scpFileInfo) ModTime() time.Time { return i.modTime }
+func (i *scpFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *scpFileInfo) Sys() any           { return nil }
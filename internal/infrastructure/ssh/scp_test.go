@@ -0,0 +1,254 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+	"github.com/nickalie/nship/internal/infrastructure/fs"
+)
+
+// newLocalSCPClient returns an scp client running scp and the other commands locally
+func newLocalSCPClient(t *testing.T) *scpClient {
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp is not installed")
+	}
+	return newSCPClient(localSessions{})
+}
+
+func TestSCPClientFiles(t *testing.T) {
+	client := newLocalSCPClient(t)
+	dir := filepath.Join(t.TempDir(), "remote dir")
+	file := filepath.Join(dir, "it's.txt")
+
+	require.NoError(t, client.MkdirAll(dir))
+	w, err := client.Create(file)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello scp"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	require.NoError(t, client.Chmod(file, 0600))
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	require.NoError(t, client.Chtimes(file, mtime, mtime))
+
+	info, err := client.Stat(file)
+	require.NoError(t, err)
+	assert.Equal(t, "it's.txt", info.Name())
+	assert.Equal(t, int64(9), info.Size())
+	assert.Equal(t, os.FileMode(0600), info.Mode())
+	assert.True(t, mtime.Equal(info.ModTime()), "got %s", info.ModTime())
+
+	r, err := client.Open(file)
+	require.NoError(t, err)
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	assert.Equal(t, "hello scp", string(content))
+
+	require.NoError(t, client.Rename(file, filepath.Join(dir, "renamed.txt")))
+	require.NoError(t, client.Symlink("renamed.txt", filepath.Join(dir, "link")))
+	require.NoError(t, client.MkdirAll(filepath.Join(dir, ".hidden")))
+
+	entries, err := client.ReadDir(dir)
+	require.NoError(t, err)
+	modes := make(map[string]os.FileMode)
+	for _, entry := range entries {
+		modes[entry.Name()] = entry.Mode().Type()
+	}
+	assert.Equal(t, map[string]os.FileMode{".hidden": os.ModeDir, "link": os.ModeSymlink, "renamed.txt": 0}, modes)
+
+	require.NoError(t, client.Remove(filepath.Join(dir, "link")))
+	require.NoError(t, client.Remove(filepath.Join(dir, ".hidden")))
+	assert.Error(t, client.Remove(filepath.Join(dir, "link")), "removing a missing file should fail")
+
+	require.NoError(t, client.RemoveAll(dir))
+	_, err = client.Stat(dir)
+	assert.True(t, os.IsNotExist(err), "got %v", err)
+	_, err = client.ReadDir(dir)
+	assert.True(t, os.IsNotExist(err), "got %v", err)
+}
+
+func TestSCPClientCopier(t *testing.T) {
+	client := newLocalSCPClient(t)
+	local := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(local, "css"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "index.html"), []byte("<h1>hi</h1>"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "css", "site.css"), []byte("h1 {}"), 0644))
+
+	remote := filepath.Join(t.TempDir(), "site")
+	require.NoError(t, os.MkdirAll(remote, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(remote, "stale.html"), []byte("old"), 0644))
+
+	copier := fs.NewCopier(client).WithDelete(true, false)
+	require.NoError(t, copier.CopyPath(local, remote, nil))
+
+	var files []string
+	require.NoError(t, filepath.WalkDir(remote, func(p string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(remote, p)
+			files = append(files, rel)
+		}
+		return err
+	}))
+	sort.Strings(files)
+	assert.Equal(t, []string{filepath.Join("css", "site.css"), "index.html"}, files)
+
+	downloaded := t.TempDir()
+	require.NoError(t, copier.DownloadPath(remote, downloaded))
+	content, err := os.ReadFile(filepath.Join(downloaded, "css", "site.css"))
+	require.NoError(t, err)
+	assert.Equal(t, "h1 {}", string(content))
+}
+
+func TestSCPClientErrors(t *testing.T) {
+	client := newLocalSCPClient(t)
+	dir := t.TempDir()
+
+	_, err := client.Open(filepath.Join(dir, "missing.txt"))
+	assert.ErrorContains(t, err, "No such file or directory")
+
+	w, err := client.CreateExclusive(filepath.Join(dir, "lock"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	_, err = client.CreateExclusive(filepath.Join(dir, "lock"))
+	assert.ErrorIs(t, err, os.ErrExist)
+
+	w, err = client.Create(filepath.Join(dir, "missing", "file.txt"))
+	require.NoError(t, err)
+	assert.ErrorContains(t, w.Close(), "scp: ", "errors of scp should be reported when the file is uploaded")
+
+	require.NoError(t, client.Close())
+	assert.ErrorIs(t, client.MkdirAll(dir), errSCPClosed)
+	_, err = client.Open(filepath.Join(dir, "missing.txt"))
+	assert.ErrorIs(t, err, errSCPClosed)
+}
+
+func TestParseStatLine(t *testing.T) {
+	info, err := parseStatLine("1024 81a4 1714979289 /srv/app/my file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "my file.txt", info.Name())
+	assert.Equal(t, int64(1024), info.Size())
+	assert.Equal(t, os.FileMode(0644), info.Mode())
+	assert.Equal(t, int64(1714979289), info.ModTime().Unix())
+
+	info, err = parseStatLine("4096 0x41ed 1714979289 css")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir(), "the hexadecimal mode of BSD stat should be parsed")
+	assert.Equal(t, os.ModeDir|0755, info.Mode())
+
+	_, err = parseStatLine("stat: command not found")
+	assert.ErrorContains(t, err, "unexpected stat output")
+}
+
+func TestUnixFileMode(t *testing.T) {
+	assert.Equal(t, os.FileMode(0644), unixFileMode(0100644))
+	assert.Equal(t, os.ModeDir|0755, unixFileMode(0040755))
+	assert.Equal(t, os.ModeSymlink|0777, unixFileMode(0120777))
+	assert.Equal(t, os.ModeIrregular|0600, unixFileMode(0010600))
+}
+
+// stubSFTPConnector returns the SFTP client or error it was created with and counts its calls
+type stubSFTPConnector struct {
+	client *sftp.Client
+	err    error
+	calls  int
+}
+
+func (c *stubSFTPConnector) NewClient(*ssh.Client) (*sftp.Client, error) {
+	c.calls++
+	return c.client, c.err
+}
+
+// newPipeSFTPClient returns an SFTP client connected to an in-process server
+func newPipeSFTPClient(t *testing.T, options ...sftp.ServerOption) *sftp.Client {
+	serverConn, clientConn := net.Pipe()
+	server, err := sftp.NewServer(serverConn, options...)
+	require.NoError(t, err)
+	go func() { _ = server.Serve() }()
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client
+}
+
+func TestFileClientTransferMethod(t *testing.T) {
+	unavailable := errors.New("ssh: subsystem request failed")
+	tests := []struct {
+		name      string
+		target    target.Target
+		connector *stubSFTPConnector
+		expectSCP bool
+		expectErr string
+		calls     int
+	}{
+		{name: "auto with SFTP", connector: &stubSFTPConnector{client: newPipeSFTPClient(t)}, calls: 1},
+		{name: "auto without SFTP", connector: &stubSFTPConnector{err: unavailable}, expectSCP: true, calls: 1},
+		{name: "auto with other SFTP error", connector: &stubSFTPConnector{err: io.EOF},
+			expectErr: "SFTP connection failed: EOF", calls: 1},
+		{name: "sftp without SFTP", target: target.Target{TransferMethod: target.TransferSFTP}, connector: &stubSFTPConnector{err: unavailable},
+			expectErr: "SFTP connection failed: ssh: subsystem request failed", calls: 1},
+		{name: "scp", target: target.Target{TransferMethod: target.TransferSCP}, connector: &stubSFTPConnector{}, expectSCP: true},
+		{name: "windows without SFTP", target: target.Target{Platform: target.PlatformWindows}, connector: &stubSFTPConnector{err: unavailable},
+			expectErr: "SFTP connection failed", calls: 1},
+		{name: "windows scp", target: target.Target{Name: "win", Platform: target.PlatformWindows, TransferMethod: target.TransferSCP},
+			connector: &stubSFTPConnector{}, expectErr: "scp transfers are not supported on Windows target 'win'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory := &ClientFactory{sftpConnector: tt.connector}
+			sessions := newSessionLimiter(&MockSSHClient{}, target.DefaultMaxSessions)
+			client, warning, err := factory.fileClient(&tt.target, nil, sessions)
+			assert.Equal(t, tt.calls, tt.connector.calls)
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+				return
+			}
+
+			require.NoError(t, err)
+			_, isSCP := client.(*scpClient)
+			assert.Equal(t, tt.expectSCP, isSCP)
+			assert.Equal(t, tt.expectSCP && tt.calls > 0, warning != "", "only falling back to scp should warn")
+			if tt.expectSCP {
+				assert.Empty(t, sessions.slots, "scp should leave every session to commands")
+			} else {
+				assert.Len(t, sessions.slots, 1, "SFTP should occupy a session")
+			}
+		})
+	}
+}
+
+func TestSFTPFallbackWarning(t *testing.T) {
+	client := &SSHClient{
+		sshClient: &MockSSHClient{NewSessionFunc: func() (SSHSession, error) { return &MockSSHSession{}, nil }},
+		target:    &target.Target{Name: "test-target"},
+		warnings:  []string{"Warning: SFTP is not available on 'test-target', falling back to scp"},
+	}
+	var stdout, stderr bytes.Buffer
+	client.SetOutput(job.NewOutput(&stdout, &stderr))
+
+	require.NoError(t, client.ExecuteStep(&job.Step{Run: "true"}, 1, 2))
+	require.NoError(t, client.ExecuteStep(&job.Step{Run: "true"}, 2, 2))
+	assert.Equal(t, "Warning: SFTP is not available on 'test-target', falling back to scp\n", stderr.String(),
+		"the warning should be printed to stderr once")
+	assert.NotContains(t, stdout.String(), "Warning")
+}
@@ -0,0 +1,75 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/util"
+)
+
+// openrcRunlevelActions maps the service actions OpenRC performs with rc-update to its subcommands
+var openrcRunlevelActions = map[string]string{
+	job.ServiceEnable:  "add",
+	job.ServiceDisable: "del",
+}
+
+// buildServiceCommand returns the command performing the action of a service step with its service manager.
+// OpenRC enables and disables services by adding them to and deleting them from the default runlevel.
+func buildServiceCommand(service *job.ServiceStep) string {
+	name := util.ShellQuote(service.Name)
+	if service.GetManager() != job.ServiceManagerOpenRC {
+		return fmt.Sprintf("systemctl %s %s", service.Action, name)
+	}
+	if subcommand, ok := openrcRunlevelActions[service.Action]; ok {
+		return fmt.Sprintf("rc-update %s %s", subcommand, name)
+	}
+	return fmt.Sprintf("rc-service %s %s", name, service.Action)
+}
+
+// buildServiceStatusCommand returns the command that fails unless the service of a service step is running
+func buildServiceStatusCommand(service *job.ServiceStep) string {
+	name := util.ShellQuote(service.Name)
+	if service.GetManager() == job.ServiceManagerOpenRC {
+		return fmt.Sprintf("rc-service %s status", name)
+	}
+	return fmt.Sprintf("systemctl is-active %s", name)
+}
+
+// executeService performs the action of a service step on the remote host and, if the step verifies it,
+// checks that the service is active afterward
+func (c *SSHClient) executeService(ctx context.Context, step *job.Step, stepNum, totalSteps int) error {
+	service := step.Service
+	fmt.Fprintf(c.out.Stdout(), "[%d/%d] Running %s on service '%s'...\n", stepNum, totalSteps, service.Action, service.Name)
+
+	if err := c.runServiceCommand(ctx, step, buildServiceCommand(service), c.stdout(ctx)); err != nil {
+		return fmt.Errorf("failed to %s service '%s': %w", service.Action, service.Name, err)
+	}
+	if !service.Verify {
+		return nil
+	}
+
+	var status bytes.Buffer
+	if err := c.runServiceCommand(ctx, step, buildServiceStatusCommand(service), io.MultiWriter(c.stdout(ctx), &status)); err != nil {
+		return fmt.Errorf("service '%s' is not active after %s, status '%s': %w",
+			service.Name, service.Action, strings.TrimSpace(status.String()), err)
+	}
+	fmt.Fprintln(c.out.Stdout(), util.Success(fmt.Sprintf("[%d/%d] Service '%s' is active", stepNum, totalSteps, service.Name)))
+	return nil
+}
+
+// runServiceCommand runs cmd of a service step in a session of its own, writing its standard output to stdout
+func (c *SSHClient) runServiceCommand(ctx context.Context, step *job.Step, cmd string, stdout io.Writer) error {
+	session, err := c.sshClient.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	return c.runWithSudoCheck(ctx, step, func(stderr io.Writer) error {
+		return c.runShell(ctx, session, c.stepShell(step), cmd, stdout, stderr)
+	})
+}
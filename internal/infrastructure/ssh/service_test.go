@@ -0,0 +1,119 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+)
+
+// serviceClient returns a client whose commands print status, where is-active fails if active is false,
+// recording every command it runs
+func serviceClient(status string, active bool, commands *[]string) (*SSHClient, *bytes.Buffer) {
+	var out bytes.Buffer
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				var cmd string
+				return &MockSSHSession{
+					StartFunc: func(command string) error {
+						cmd = command
+						*commands = append(*commands, command)
+						return nil
+					},
+					StdoutPipeFunc: func() (io.Reader, error) { return strings.NewReader(status), nil },
+					StderrPipeFunc: func() (io.Reader, error) { return &MockReader{}, nil },
+					WaitFunc: func() error {
+						if !active && strings.Contains(cmd, "is-active") {
+							return errors.New("Process exited with status 3")
+						}
+						return nil
+					},
+				}, nil
+			},
+		},
+		target: &target.Target{Name: "test-target"},
+		sink:   newOutputSink(io.Discard, io.Discard, OutputOptions{}),
+		out:    job.NewOutput(&out, io.Discard),
+	}
+	return client, &out
+}
+
+func TestBuildServiceCommand(t *testing.T) {
+	tests := []struct {
+		service *job.ServiceStep
+		command string
+		status  string
+	}{
+		{&job.ServiceStep{Name: "nginx", Action: job.ServiceRestart}, "systemctl restart 'nginx'", "systemctl is-active 'nginx'"},
+		{&job.ServiceStep{Name: "nginx", Action: job.ServiceEnable, Manager: job.ServiceManagerSystemd},
+			"systemctl enable 'nginx'", "systemctl is-active 'nginx'"},
+		{&job.ServiceStep{Name: "nginx", Action: job.ServiceReload, Manager: job.ServiceManagerOpenRC},
+			"rc-service 'nginx' reload", "rc-service 'nginx' status"},
+		{&job.ServiceStep{Name: "nginx", Action: job.ServiceEnable, Manager: job.ServiceManagerOpenRC}, "rc-update add 'nginx'", ""},
+		{&job.ServiceStep{Name: "nginx", Action: job.ServiceDisable, Manager: job.ServiceManagerOpenRC}, "rc-update del 'nginx'", ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.command, buildServiceCommand(tt.service))
+		if tt.status != "" {
+			assert.Equal(t, tt.status, buildServiceStatusCommand(tt.service))
+		}
+	}
+}
+
+func TestExecuteService(t *testing.T) {
+	var commands []string
+	client, _ := serviceClient("", true, &commands)
+
+	step := &job.Step{Service: &job.ServiceStep{Name: "nginx", Action: job.ServiceStop}, Sudo: true}
+	require.NoError(t, client.ExecuteStep(step, 1, 1))
+	require.Len(t, commands, 1, "the service should only be checked when the step verifies it")
+	assert.Contains(t, commands[0], "sudo -n")
+	assert.Contains(t, commands[0], "systemctl stop")
+}
+
+func TestExecuteServiceVerify(t *testing.T) {
+	step := &job.Step{Service: &job.ServiceStep{Name: "nginx", Action: job.ServiceRestart, Verify: true}}
+
+	t.Run("active", func(t *testing.T) {
+		var commands []string
+		client, out := serviceClient("active\n", true, &commands)
+
+		require.NoError(t, client.ExecuteStep(step, 1, 1))
+		require.Len(t, commands, 2)
+		assert.Contains(t, commands[0], "systemctl restart")
+		assert.Contains(t, commands[1], "systemctl is-active")
+		assert.Contains(t, out.String(), "Service 'nginx' is active")
+	})
+
+	t.Run("not active", func(t *testing.T) {
+		var commands []string
+		client, _ := serviceClient("failed\n", false, &commands)
+
+		err := client.ExecuteStep(step, 1, 1)
+		assert.ErrorContains(t, err, "service 'nginx' is not active after restart, status 'failed'")
+	})
+}
+
+func TestExecuteServiceFailure(t *testing.T) {
+	client := &SSHClient{
+		sshClient: &MockSSHClient{
+			NewSessionFunc: func() (SSHSession, error) {
+				return &MockSSHSession{WaitFunc: func() error { return errors.New("Unit nginx.service not found") }}, nil
+			},
+		},
+		target: &target.Target{Name: "test-target"},
+		out:    job.NewOutput(io.Discard, io.Discard),
+	}
+
+	step := &job.Step{Service: &job.ServiceStep{Name: "nginx", Action: job.ServiceStart, Verify: true}}
+	assert.ErrorContains(t, client.ExecuteStep(step, 1, 1), "failed to start service 'nginx'")
+}
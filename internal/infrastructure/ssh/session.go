@@ -2,13 +2,27 @@ package ssh
 
 import (
 	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+	"github.com/nickalie/nship/internal/infrastructure/fs"
+	"github.com/nickalie/nship/internal/util"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
@@ -19,6 +33,9 @@ type SSHSession interface {
 	Wait() error
 	StdoutPipe() (io.Reader, error)
 	StderrPipe() (io.Reader, error)
+	StdinPipe() (io.WriteCloser, error)
+	Setenv(name, value string) error
+	Signal(sig ssh.Signal) error
 	Close() error
 }
 
@@ -31,9 +48,18 @@ type SSHClientInterface interface {
 // SFTPClientInterface represents SFTP client functionality
 type SFTPClientInterface interface {
 	Create(path string) (io.WriteCloser, error)
+	// CreateExclusive creates a file like Create, but fails with an error matching os.ErrExist if it exists
+	CreateExclusive(path string) (io.WriteCloser, error)
+	Open(path string) (io.ReadCloser, error)
 	MkdirAll(path string) error
 	Chmod(path string, mode os.FileMode) error
 	Stat(path string) (os.FileInfo, error)
+	Chtimes(path string, atime, mtime time.Time) error
+	ReadDir(path string) ([]os.FileInfo, error)
+	Remove(path string) error
+	RemoveAll(path string) error
+	Rename(oldname, newname string) error
+	Symlink(oldname, newname string) error
 	Close() error
 }
 
@@ -67,14 +93,45 @@ func (a *SFTPAdapter) Create(path string) (io.WriteCloser, error) {
 	return a.Client.Create(path)
 }
 
+// CreateExclusive implements SFTPClientInterface. OpenSSH reports existing files as a generic failure,
+// so the file is looked up when creating it fails.
+func (a *SFTPAdapter) CreateExclusive(path string) (io.WriteCloser, error) {
+	file, err := a.Client.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err == nil {
+		return file, nil
+	}
+	if _, statErr := a.Client.Lstat(path); statErr == nil {
+		return nil, &os.PathError{Op: "create", Path: path, Err: os.ErrExist}
+	}
+	return nil, err
+}
+
+// Open implements SFTPClientInterface
+func (a *SFTPAdapter) Open(path string) (io.ReadCloser, error) {
+	return a.Client.Open(path)
+}
+
+// Rename implements SFTPClientInterface. It replaces an existing file at newname, using the
+// posix-rename extension when the server supports it, as plain SFTP renames refuse to overwrite.
+func (a *SFTPAdapter) Rename(oldname, newname string) error {
+	if _, ok := a.Client.HasExtension("posix-rename@openssh.com"); ok {
+		return a.Client.PosixRename(oldname, newname)
+	}
+
+	if err := a.Client.Remove(newname); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return a.Client.Rename(oldname, newname)
+}
+
 // MkdirAll implements SFTPClientInterface
 func (a *SFTPAdapter) MkdirAll(path string) error {
 	return a.Client.MkdirAll(path)
 }
 
 // executeCommand executes a command on the remote host
-func (c *SSHClient) executeCommand(step *job.Step, stepNum, totalSteps int) error {
-	fmt.Printf("[%d/%d] Executing command...\n", stepNum, totalSteps)
+func (c *SSHClient) executeCommand(ctx context.Context, step *job.Step, stepNum, totalSteps int) error {
+	fmt.Fprintf(c.out.Stdout(), "[%d/%d] Executing command...\n", stepNum, totalSteps)
 
 	session, err := c.sshClient.NewSession()
 	if err != nil {
@@ -82,25 +139,425 @@ func (c *SSHClient) executeCommand(step *job.Step, stepNum, totalSteps int) erro
 	}
 	defer session.Close()
 
-	return runShellCommand(session, step.GetShell(), step.Run, os.Stdout, os.Stderr)
+	if step.ScriptFile != "" {
+		script, err := os.ReadFile(step.ScriptFile)
+		if err != nil {
+			return fmt.Errorf("failed to read script file: %w", err)
+		}
+		script = append([]byte(c.syntax().prelude(step)), script...)
+		return c.runWithSudoCheck(ctx, step, func(stderr io.Writer) error {
+			return c.runScript(ctx, session, c.stepShell(step), script, c.stdout(ctx), stderr)
+		})
+	}
+
+	return c.runWithSudoCheck(ctx, step, func(stderr io.Writer) error {
+		return c.runShell(ctx, session, c.stepShell(step), c.runCommand(step), c.stdout(ctx), stderr)
+	})
+}
+
+// executeCopyStep copies the files of a copy step to the remote host or into a container on it
+func (c *SSHClient) executeCopyStep(ctx context.Context, step *job.Step, stepNum, totalSteps int) error {
+	if step.Copy.Container != "" {
+		return c.executeContainerCopy(ctx, step, stepNum, totalSteps)
+	}
+	fmt.Fprintf(c.out.Stdout(), "[%d/%d] Copying '%s' to '%s'...\n", stepNum, totalSteps, step.Copy.Local, step.Copy.Remote)
+	return c.executeCopy(step.Copy, step.Copy.Remote)
 }
 
-// executeCopy copies files to the remote host
-func (c *SSHClient) executeCopy(copyStep *job.CopyStep, stepNum, totalSteps int) error {
-	fmt.Printf("[%d/%d] Copying '%s' to '%s'...\n", stepNum, totalSteps, copyStep.Local, copyStep.Remote)
-	err := c.copier.CopyPath(copyStep.Local, copyStep.Remote, copyStep.Exclude)
+// executeContainerCopy uploads the files of a copy step to a temporary path on the remote host and copies
+// them from there into the container with docker cp. The temporary path is removed afterwards.
+func (c *SSHClient) executeContainerCopy(ctx context.Context, step *job.Step, stepNum, totalSteps int) error {
+	copyStep := step.Copy
+	fmt.Fprintf(c.out.Stdout(), "[%d/%d] Copying '%s' to '%s:%s'...\n",
+		stepNum, totalSteps, copyStep.Local, copyStep.Container, copyStep.Remote)
+
+	localInfo, err := os.Stat(copyStep.Local)
+	if err != nil {
+		return &job.CopyError{Source: copyStep.Local, Destination: copyStep.Remote, Cause: fmt.Errorf("stat source: %w", err)}
+	}
+
+	staging, err := c.tempRemotePath(copyStep.Local)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.sftpClient.RemoveAll(staging) }()
+
+	if err := c.executeCopy(copyStep, staging); err != nil {
+		return err
+	}
+
+	session, err := c.sshClient.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	err = c.runWithSudoCheck(ctx, step, func(stderr io.Writer) error {
+		cmd := dockerCopyCommand(staging, localInfo.IsDir(), copyStep.Container, copyStep.Remote)
+		return c.runShell(ctx, session, c.stepShell(step), cmd, c.stdout(ctx), stderr)
+	})
+	if err != nil {
+		return &job.DockerError{ContainerName: copyStep.Container, Operation: "cp", Cause: err}
+	}
+	return nil
+}
+
+// dockerCopyCommand builds the docker cp command copying the file or the contents of the directory
+// at staging to remote in container
+func dockerCopyCommand(staging string, isDir bool, container, remote string) string {
+	if isDir {
+		staging += "/."
+	}
+	return fmt.Sprintf("docker cp %s %s", util.ShellQuote(staging), util.ShellQuote(container+":"+remote))
+}
+
+// executeCopy copies the files of copyStep to remote on the remote host
+func (c *SSHClient) executeCopy(copyStep *job.CopyStep, remote string) error {
+	copier := c.copier.
+		WithConcurrency(copyStep.Concurrency).
+		WithPreserveTimes(copyStep.ShouldPreserveTimes()).
+		WithDelete(copyStep.Delete, copyStep.AllowDeleteAll).
+		WithFollowSymlinks(copyStep.FollowSymlinks)
+	if copyStep.Compress {
+		copier = copier.WithCompression(c.compressionRunner())
+	}
+	err := copier.CopyPath(copyStep.Local, remote, copyStep.Exclude)
 	if err != nil {
 		return &job.CopyError{
 			Source:      copyStep.Local,
-			Destination: copyStep.Remote,
+			Destination: remote,
 			Cause:       err,
 		}
 	}
 	return nil
 }
 
-// runShellCommand runs a shell command and pipes output to the provided writers
-func runShellCommand(session SSHSession, shell, cmd string, stdout, stderr io.Writer) error {
+// executeDownload copies files from the remote host to the local machine
+func (c *SSHClient) executeDownload(download *job.DownloadStep, stepNum, totalSteps int) error {
+	fmt.Fprintf(c.out.Stdout(), "[%d/%d] Downloading '%s' to '%s'...\n", stepNum, totalSteps, download.Remote, download.Local)
+	if err := c.copier.DownloadPath(download.Remote, download.Local); err != nil {
+		return &job.CopyError{
+			Source:      download.Remote,
+			Destination: download.Local,
+			Cause:       err,
+		}
+	}
+	return nil
+}
+
+// executeRunScript uploads a local script to a temporary remote path, runs it and
+// removes it afterwards, also when it fails
+func (c *SSHClient) executeRunScript(ctx context.Context, step *job.Step, stepNum, totalSteps int) error {
+	script := step.RunScript
+	fmt.Fprintf(c.out.Stdout(), "[%d/%d] Running script '%s'...\n", stepNum, totalSteps, script.Path)
+
+	remotePath, err := c.tempRemotePath(script.Path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = c.sftpClient.RemoveAll(remotePath) }()
+
+	if err := c.copier.CopyFile(script.Path, remotePath); err != nil {
+		return &job.CopyError{Source: script.Path, Destination: remotePath, Cause: err}
+	}
+
+	// Windows has no executable permission, scripts are run by the interpreter for their extension
+	if !c.windows() {
+		if err := c.sftpClient.Chmod(remotePath, 0700); err != nil {
+			return fmt.Errorf("failed to make script executable: %w", err)
+		}
+	}
+
+	session, err := c.sshClient.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	return c.runWithSudoCheck(ctx, step, func(stderr io.Writer) error {
+		cmd := c.syntax().prelude(step) + c.syntax().runScript(script, remotePath)
+		return c.runShell(ctx, session, c.stepShell(step), cmd, c.stdout(ctx), stderr)
+	})
+}
+
+// tempRemotePath returns a unique remote path for uploading the file or directory at localPath
+func (c *SSHClient) tempRemotePath(localPath string) (string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", fmt.Errorf("failed to generate temporary remote name: %w", err)
+	}
+	return c.syntax().tempPath(fmt.Sprintf("nship-%s-%s", hex.EncodeToString(suffix), filepath.Base(localPath))), nil
+}
+
+// runScriptCommand builds the command running the uploaded script with its interpreter and arguments
+func runScriptCommand(script *job.RunScriptStep, remotePath string) string {
+	parts := []string{util.ShellQuote(remotePath)}
+	if script.Interpreter != "" {
+		parts = append([]string{script.Interpreter}, parts...)
+	}
+	for _, arg := range script.Args {
+		parts = append(parts, util.ShellQuote(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+// pipefailShells lists the shells that support set -o pipefail
+var pipefailShells = map[string]bool{"bash": true, "zsh": true, "ksh": true, "mksh": true}
+
+// runCommand returns the command of a run step with its prelude, stopping at the first failing line
+// if the step fails fast
+func (c *SSHClient) runCommand(step *job.Step) string {
+	command := c.syntax().prelude(step) + step.Run
+	if step.FailsFast() {
+		command = c.syntax().failFast(step.GetShellFor(c.target)) + command
+	}
+	return command
+}
+
+// failFastPrelude returns the command making shell exit at the first failing command and on unset variables,
+// also within pipelines if it supports that
+func failFastPrelude(shell string) string {
+	fields := strings.Fields(shell)
+	if len(fields) > 0 && pipefailShells[path.Base(fields[0])] {
+		return "set -euo pipefail\n"
+	}
+	return "set -e\n"
+}
+
+// commandPrelude returns the shell commands changing to the working directory of the step and exporting
+// its environment variables, to run before the commands of the step
+func commandPrelude(step *job.Step) string {
+	var prelude strings.Builder
+	if step.Workdir != "" {
+		fmt.Fprintf(&prelude, "cd %s || exit 1\n", util.ShellQuote(step.Workdir))
+	}
+	prelude.WriteString(exportPrelude(step.Env))
+	return prelude.String()
+}
+
+// exportPrelude returns the shell commands exporting the variables of env
+func exportPrelude(env map[string]string) string {
+	var prelude strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(env)) {
+		fmt.Fprintf(&prelude, "export %s=%s\n", name, util.ShellQuote(env[name]))
+	}
+	return prelude.String()
+}
+
+// sudoPasswordPrompt is the message sudo -n prints when the user may not run commands without a password
+const sudoPasswordPrompt = "a password is required"
+
+// stepShell returns the shell running the step's commands on the target,
+// prefixed with sudo when the step requires it
+func (c *SSHClient) stepShell(step *job.Step) string {
+	shell := step.GetShellFor(c.target)
+	if !step.UsesSudo() {
+		return shell
+	}
+	if step.SudoUser != "" {
+		return fmt.Sprintf("sudo -n -u %s %s", util.ShellQuote(step.SudoUser), shell)
+	}
+	return "sudo -n " + shell
+}
+
+// runWithSudoCheck calls run with the writer for standard error and, for steps using sudo,
+// turns a failure caused by sudo asking for a password into a clear error
+func (c *SSHClient) runWithSudoCheck(ctx context.Context, step *job.Step, run func(stderr io.Writer) error) error {
+	if !step.UsesSudo() {
+		return run(c.stderr(ctx))
+	}
+
+	detector := &sudoPasswordDetector{w: c.stderr(ctx)}
+	err := run(detector)
+	if err != nil && detector.required {
+		return fmt.Errorf("sudo on '%s' requires a password, configure passwordless sudo for the login user: %w",
+			c.target.GetName(), err)
+	}
+	return err
+}
+
+// sudoPasswordDetector passes output through and records whether sudo refused to run without a password
+type sudoPasswordDetector struct {
+	w        io.Writer
+	required bool
+}
+
+// Write implements io.Writer
+func (d *sudoPasswordDetector) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), sudoPasswordPrompt) {
+		d.required = true
+	}
+	return d.w.Write(p)
+}
+
+// executeWait pauses locally for the duration of the wait step, or until ctx is cancelled
+func (c *SSHClient) executeWait(ctx context.Context, waitStep *job.WaitStep, stepNum, totalSteps int) error {
+	duration, err := waitStep.GetDuration()
+	if err != nil {
+		return fmt.Errorf("invalid wait duration: %w", err)
+	}
+
+	fmt.Fprintf(c.out.Stdout(), "[%d/%d] Waiting %s...\n", stepNum, totalSteps, duration)
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// executeExec runs a local command, streaming its output, with the target, job and step
+// passed in NSHIP_* environment variables. The command is killed when ctx is cancelled.
+func (c *SSHClient) executeExec(ctx context.Context, execStep *job.ExecStep, stepNum, totalSteps int) error {
+	fmt.Fprintf(c.out.Stdout(), "[%d/%d] Executing '%s' locally...\n", stepNum, totalSteps, execStep.Command[0])
+
+	cmd := exec.CommandContext(ctx, execStep.Command[0], execStep.Command[1:]...)
+	cmd.Env = append(os.Environ(), execEnv(execStep, c.target, stepNum)...)
+	if err := c.runLocal(cmd, c.stdout(ctx), c.stderr(ctx)); err != nil {
+		return fmt.Errorf("local command '%s' failed: %w", execStep.Command[0], err)
+	}
+	return nil
+}
+
+// runLocal runs a local command and pipes its output to stdout and stderr like the output of remote commands,
+// masking secrets
+func (c *SSHClient) runLocal(cmd *exec.Cmd, stdout, stderr io.Writer) error {
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stdout pipe: %w", err)
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stderr pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// The pipes are fully read before waiting, as Wait closes them
+	pipeOutputs(stdoutPipe, stderrPipe, stdout, stderr, c.secrets).Wait()
+	return cmd.Wait()
+}
+
+// execEnv returns the environment variables added for an exec step. The NSHIP_* variables
+// come last, so that they take precedence over those of the step.
+func execEnv(execStep *job.ExecStep, tgt *target.Target, stepNum int) []string {
+	env := make([]string, 0, len(execStep.Env)+6)
+	for key, value := range execStep.Env {
+		env = append(env, key+"="+value)
+	}
+
+	return append(env,
+		"NSHIP_TARGET_NAME="+tgt.GetName(),
+		"NSHIP_TARGET_HOST="+tgt.Host,
+		"NSHIP_TARGET_PORT="+strconv.Itoa(tgt.GetPort()),
+		"NSHIP_TARGET_USER="+tgt.User,
+		"NSHIP_JOB="+execStep.Job,
+		"NSHIP_STEP="+strconv.Itoa(stepNum),
+	)
+}
+
+// compressionRunner returns the client as the runner for remote decompression,
+// or nil with a warning when gunzip is not available on the target
+func (c *SSHClient) compressionRunner() fs.CommandRunner {
+	if c.windows() {
+		fmt.Fprintf(c.out.Stdout(), "Warning: compression is not supported on Windows target '%s', copying without compression\n",
+			c.target.GetName())
+		return nil
+	}
+	if err := c.RunCommand("command -v gunzip"); err != nil {
+		fmt.Fprintf(c.out.Stdout(), "Warning: gunzip not found on '%s', copying without compression\n", c.target.GetName())
+		return nil
+	}
+	return c
+}
+
+// RunCommand implements fs.CommandRunner by running cmd with sh, or powershell on Windows targets,
+// on the remote host. Standard output is discarded.
+func (c *SSHClient) RunCommand(cmd string) error {
+	session, err := c.sshClient.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+
+	shell := "sh"
+	if c.windows() {
+		shell = job.DefaultWindowsShell
+	}
+	ctx := context.Background()
+	return c.runShell(ctx, session, shell, cmd, io.Discard, c.stderr(ctx))
+}
+
+// runShell runs a command with shell in the syntax of the target and pipes output to the provided writers
+func (c *SSHClient) runShell(ctx context.Context, session SSHSession, shell, cmd string, stdout, stderr io.Writer) error {
+	line, err := c.syntax().command(shell, c.sessionEnv(session, shell)+cmd)
+	if err != nil {
+		return err
+	}
+	return runSession(ctx, session, line, nil, stdout, stderr, c.secrets)
+}
+
+// runScript runs a script with shell. On Windows targets it is passed like a command,
+// as PowerShell does not read scripts with blocks reliably from standard input.
+func (c *SSHClient) runScript(ctx context.Context, session SSHSession, shell string, script []byte, stdout, stderr io.Writer) error {
+	if c.windows() {
+		return c.runShell(ctx, session, shell, string(script), stdout, stderr)
+	}
+	script = append([]byte(c.sessionEnv(session, shell)), script...)
+	if c.loginShell() {
+		shell += " -l"
+	}
+	return runShellScript(ctx, session, shell, script, stdout, stderr, c.secrets)
+}
+
+// sessionEnv sets the environment variables of the target in session and returns the commands setting those
+// the server rejects, as OpenSSH only accepts the variables listed in AcceptEnv. Shells run with sudo get
+// all of them from the commands, as sudo resets the environment.
+func (c *SSHClient) sessionEnv(session SSHSession, shell string) string {
+	if c.target == nil || len(c.target.Env) == 0 {
+		return ""
+	}
+
+	rejected := c.target.Env
+	if !strings.HasPrefix(shell, "sudo ") {
+		rejected = make(map[string]string)
+		for _, name := range slices.Sorted(maps.Keys(c.target.Env)) {
+			if err := session.Setenv(name, c.target.Env[name]); err != nil {
+				rejected[name] = c.target.Env[name]
+			}
+		}
+	}
+	return c.syntax().setEnv(rejected)
+}
+
+// runShellScript runs a script by streaming it to the standard input of the shell,
+// so its content needs no escaping
+func runShellScript(ctx context.Context, session SSHSession, shell string, script []byte, stdout, stderr io.Writer,
+	secrets *util.SecretRegistry) error {
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stdin pipe: %w", err)
+	}
+
+	writeScript := func() {
+		go func() {
+			_, _ = stdin.Write(script)
+			_ = stdin.Close()
+		}()
+	}
+
+	return runSession(ctx, session, shell+" -s", writeScript, stdout, stderr, secrets)
+}
+
+// runSession starts cmd in the session, calls onStart if set once the command is running,
+// and pipes the command output to the provided writers, masking secrets, until it finishes. When ctx is
+// cancelled, the command is sent SIGTERM and the session is closed.
+func runSession(ctx context.Context, session SSHSession, cmd string, onStart func(), stdout, stderr io.Writer,
+	secrets *util.SecretRegistry) error {
 	stdoutPipe, err := session.StdoutPipe()
 	if err != nil {
 		return fmt.Errorf("failed to get stdout pipe: %w", err)
@@ -111,23 +568,22 @@ func runShellCommand(session SSHSession, shell, cmd string, stdout, stderr io.Wr
 		return fmt.Errorf("failed to get stderr pipe: %w", err)
 	}
 
-	cmd = fmt.Sprintf("%s -c %s", shell, escapeCommand(cmd))
 	if err := session.Start(cmd); err != nil {
 		return fmt.Errorf("failed to start command: %w", err)
 	}
 
-	// Use WaitGroup to ensure output is fully processed
-	var wg sync.WaitGroup
-	wg.Add(2)
+	stop := context.AfterFunc(ctx, func() {
+		_ = session.Signal(ssh.SIGTERM)
+		_ = session.Close()
+	})
+	defer stop()
 
-	go func() {
-		pipeOutput(stdoutPipe, stdout)
-		wg.Done()
-	}()
-	go func() {
-		pipeOutput(stderrPipe, stderr)
-		wg.Done()
-	}()
+	if onStart != nil {
+		onStart()
+	}
+
+	// Pipe output until both streams are fully processed
+	wg := pipeOutputs(stdoutPipe, stderrPipe, stdout, stderr, secrets)
 
 	// Wait for command to finish
 	err = session.Wait()
@@ -145,16 +601,48 @@ func runShellCommand(session SSHSession, shell, cmd string, stdout, stderr io.Wr
 	return nil
 }
 
-// escapeCommand escapes special characters in shell commands
-func escapeCommand(cmd string) string {
-	cmd = "'" + strings.ReplaceAll(cmd, "'", "'\\''") + "'"
-	return strings.ReplaceAll(cmd, "`", "\\`")
+// errNULInCommand is returned for commands containing a NUL byte, which can't be passed to a shell
+var errNULInCommand = errors.New("command contains a NUL byte")
+
+// escapeCommand quotes a command as a single word for the POSIX login shell of the target, which passes
+// it on unchanged to the shell running the step. Inside single quotes every byte but the single quote
+// is literal, including $, \, `, ! and newlines; a single quote ends the quoting, is escaped and reopens it.
+func escapeCommand(cmd string) (string, error) {
+	if strings.IndexByte(cmd, 0) >= 0 {
+		return "", errNULInCommand
+	}
+	return util.ShellQuote(cmd), nil
 }
 
-// pipeOutput pipes output from a reader to a writer
-func pipeOutput(r io.Reader, w io.Writer) {
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		fmt.Fprintln(w, scanner.Text())
+// pipeOutputs pipes the standard output and error of a command to stdout and stderr in the background
+// and returns the WaitGroup that is done once both are fully written
+func pipeOutputs(stdoutPipe, stderrPipe io.Reader, stdout, stderr io.Writer, secrets *util.SecretRegistry) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		pipeOutput(stdoutPipe, stdout, secrets)
+		wg.Done()
+	}()
+	go func() {
+		pipeOutput(stderrPipe, stderr, secrets)
+		wg.Done()
+	}()
+	return &wg
+}
+
+// pipeOutput pipes output from a reader to a writer one line at a time, masking secrets.
+// Lines of any length are written whole, each in a single write.
+func pipeOutput(r io.Reader, w io.Writer, secrets *util.SecretRegistry) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			fmt.Fprintln(w, secrets.Redact(line))
+		}
+		if err != nil {
+			return
+		}
 	}
 }
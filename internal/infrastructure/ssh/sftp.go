@@ -0,0 +1,182 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// errSFTPClosed is returned for operations of an SFTP client after it was closed
+var errSFTPClosed = errors.New("sftp client is closed")
+
+// interrupter is implemented by file clients that can fail their operations in progress
+// while staying usable for later operations
+type interrupter interface {
+	Interrupt()
+}
+
+// reopeningSFTP implements SFTPClientInterface with an SFTP client that is replaced by a new one opened
+// with open when it was interrupted, so that cleanup after a cancelled step can still transfer files
+type reopeningSFTP struct {
+	mu      sync.Mutex
+	current SFTPClientInterface
+	open    func() (SFTPClientInterface, error)
+	closed  bool
+}
+
+// newReopeningSFTP creates a reopening SFTP client starting with client
+func newReopeningSFTP(client SFTPClientInterface, open func() (SFTPClientInterface, error)) *reopeningSFTP {
+	return &reopeningSFTP{current: client, open: open}
+}
+
+// client returns the current SFTP client, opening a new one if the previous one was interrupted
+func (r *reopeningSFTP) client() (SFTPClientInterface, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil, errSFTPClosed
+	}
+	if r.current == nil {
+		client, err := r.open()
+		if err != nil {
+			return nil, err
+		}
+		r.current = client
+	}
+	return r.current, nil
+}
+
+// release closes the current SFTP client, failing its transfers in progress
+func (r *reopeningSFTP) release() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
+
+// Interrupt implements interrupter by closing the current SFTP client. The next operation opens a new one.
+func (r *reopeningSFTP) Interrupt() {
+	_ = r.release()
+}
+
+// Close implements SFTPClientInterface and rejects later operations
+func (r *reopeningSFTP) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	return r.release()
+}
+
+// Create implements SFTPClientInterface
+func (r *reopeningSFTP) Create(path string) (io.WriteCloser, error) {
+	client, err := r.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.Create(path)
+}
+
+// CreateExclusive implements SFTPClientInterface
+func (r *reopeningSFTP) CreateExclusive(path string) (io.WriteCloser, error) {
+	client, err := r.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.CreateExclusive(path)
+}
+
+// Open implements SFTPClientInterface
+func (r *reopeningSFTP) Open(path string) (io.ReadCloser, error) {
+	client, err := r.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.Open(path)
+}
+
+// MkdirAll implements SFTPClientInterface
+func (r *reopeningSFTP) MkdirAll(path string) error {
+	client, err := r.client()
+	if err != nil {
+		return err
+	}
+	return client.MkdirAll(path)
+}
+
+// Chmod implements SFTPClientInterface
+func (r *reopeningSFTP) Chmod(path string, mode os.FileMode) error {
+	client, err := r.client()
+	if err != nil {
+		return err
+	}
+	return client.Chmod(path, mode)
+}
+
+// Stat implements SFTPClientInterface
+func (r *reopeningSFTP) Stat(path string) (os.FileInfo, error) {
+	client, err := r.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.Stat(path)
+}
+
+// Chtimes implements SFTPClientInterface
+func (r *reopeningSFTP) Chtimes(path string, atime, mtime time.Time) error {
+	client, err := r.client()
+	if err != nil {
+		return err
+	}
+	return client.Chtimes(path, atime, mtime)
+}
+
+// ReadDir implements SFTPClientInterface
+func (r *reopeningSFTP) ReadDir(path string) ([]os.FileInfo, error) {
+	client, err := r.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.ReadDir(path)
+}
+
+// Remove implements SFTPClientInterface
+func (r *reopeningSFTP) Remove(path string) error {
+	client, err := r.client()
+	if err != nil {
+		return err
+	}
+	return client.Remove(path)
+}
+
+// RemoveAll implements SFTPClientInterface
+func (r *reopeningSFTP) RemoveAll(path string) error {
+	client, err := r.client()
+	if err != nil {
+		return err
+	}
+	return client.RemoveAll(path)
+}
+
+// Rename implements SFTPClientInterface
+func (r *reopeningSFTP) Rename(oldname, newname string) error {
+	client, err := r.client()
+	if err != nil {
+		return err
+	}
+	return client.Rename(oldname, newname)
+}
+
+// Symlink implements SFTPClientInterface
+func (r *reopeningSFTP) Symlink(oldname, newname string) error {
+	client, err := r.client()
+	if err != nil {
+		return err
+	}
+	return client.Symlink(oldname, newname)
+}
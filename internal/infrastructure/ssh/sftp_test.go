@@ -0,0 +1,89 @@
+package ssh
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+	"github.com/nickalie/nship/internal/infrastructure/fs"
+)
+
+func TestCleanupAfterCancellation(t *testing.T) {
+	home := t.TempDir()
+	open := func() (SFTPClientInterface, error) {
+		return NewSFTPAdapter(newPipeSFTPClient(t, sftp.WithServerWorkingDirectory(home))), nil
+	}
+	first, err := open()
+	require.NoError(t, err)
+	files := newReopeningSFTP(first, open)
+
+	closed := make(chan struct{})
+	var once sync.Once
+	session := &MockSSHSession{
+		WaitFunc: func() error {
+			<-closed
+			return &ssh.ExitMissingError{}
+		},
+		CloseFunc: func() error {
+			once.Do(func() { close(closed) })
+			return nil
+		},
+	}
+	client := &SSHClient{
+		sshClient:  &MockSSHClient{NewSessionFunc: func() (SSHSession, error) { return session, nil }},
+		sftpClient: files,
+		copier:     *fs.NewCopier(files),
+		target:     &target.Target{Name: "web"},
+		out:        job.NewOutput(os.Stdout, os.Stderr),
+	}
+	require.NoError(t, client.Lock("shop", false))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	err = client.ExecuteStepContext(ctx, &job.Step{Run: "sleep 3600"}, 1, 1)
+	require.ErrorIs(t, err, context.Canceled)
+	_, err = first.Stat(".")
+	assert.Error(t, err, "the SFTP client should be closed to abort transfers")
+
+	// on_failure hooks run with the cancellation of the deployment removed
+	local := filepath.Join(t.TempDir(), "report.txt")
+	require.NoError(t, os.WriteFile(local, []byte("failed"), 0644))
+	step := &job.Step{Copy: &job.CopyStep{Local: local, Remote: "report.txt"}}
+	require.NoError(t, client.ExecuteStepContext(context.WithoutCancel(ctx), step, 1, 1))
+	data, err := os.ReadFile(filepath.Join(home, "report.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "failed", string(data))
+
+	require.NoError(t, client.Unlock("shop"))
+	assert.NoFileExists(t, filepath.Join(home, LockDir, "shop.lock"))
+}
+
+func TestReopeningSFTPClose(t *testing.T) {
+	opened := 0
+	files := newReopeningSFTP(NewSFTPAdapter(newPipeSFTPClient(t)), func() (SFTPClientInterface, error) {
+		opened++
+		return NewSFTPAdapter(newPipeSFTPClient(t)), nil
+	})
+
+	_, err := files.Stat(".")
+	require.NoError(t, err)
+	files.Interrupt()
+	_, err = files.Stat(".")
+	require.NoError(t, err)
+	assert.Equal(t, 1, opened)
+
+	require.NoError(t, files.Close())
+	_, err = files.Stat(".")
+	assert.ErrorIs(t, err, errSFTPClosed)
+	assert.Equal(t, 1, opened, "a closed client should not be reopened")
+}
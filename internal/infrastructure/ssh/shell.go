@@ -0,0 +1,87 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/util"
+)
+
+// shellSyntax builds the commands nship runs on a target in the language of the target's shells
+type shellSyntax interface {
+	// command returns the command line running script with shell
+	command(shell, script string) (string, error)
+	// prelude returns the commands changing to the working directory of the step and setting its environment variables
+	prelude(step *job.Step) string
+	// setEnv returns the commands setting the variables of env
+	setEnv(env map[string]string) string
+	// failFast returns the commands making shell stop at the first failing command
+	failFast(shell string) string
+	// quote quotes s as a single argument
+	quote(s string) string
+	// assignment returns the key=value argument of docker options such as -e and -l
+	assignment(key, value string) string
+	// ignoreFailure returns cmd with its error output discarded and its failure ignored
+	ignoreFailure(cmd string) string
+	// joinAll joins commands into one that stops at the first failing command
+	joinAll(commands []string) string
+	// tempPath returns the remote path of a temporary upload named name
+	tempPath(name string) string
+	// runScript returns the command running the script uploaded to remotePath with its interpreter and arguments
+	runScript(script *job.RunScriptStep, remotePath string) string
+}
+
+// posixSyntax is the syntax of sh and compatible shells on Unix targets. With login set, commands run in a login shell.
+type posixSyntax struct {
+	login bool
+}
+
+func (p posixSyntax) command(shell, script string) (string, error) {
+	escaped, err := escapeCommand(script)
+	if err != nil {
+		return "", err
+	}
+	if p.login {
+		return fmt.Sprintf("%s -lc %s", shell, escaped), nil
+	}
+	return fmt.Sprintf("%s -c %s", shell, escaped), nil
+}
+
+func (posixSyntax) prelude(step *job.Step) string { return commandPrelude(step) }
+
+func (posixSyntax) setEnv(env map[string]string) string { return exportPrelude(env) }
+
+func (posixSyntax) failFast(shell string) string { return failFastPrelude(shell) }
+
+func (posixSyntax) quote(s string) string { return util.ShellQuote(s) }
+
+func (posixSyntax) assignment(key, value string) string { return fmt.Sprintf("%s=%q", key, value) }
+
+func (posixSyntax) ignoreFailure(cmd string) string { return cmd + " 2>/dev/null || true" }
+
+func (posixSyntax) joinAll(commands []string) string { return strings.Join(commands, " && ") }
+
+func (posixSyntax) tempPath(name string) string { return "/tmp/" + name }
+
+func (posixSyntax) runScript(script *job.RunScriptStep, remotePath string) string {
+	return runScriptCommand(script, remotePath)
+}
+
+// syntax returns the syntax of the commands run on the target of the client
+func (c *SSHClient) syntax() shellSyntax {
+	if c.windows() {
+		return powershellSyntax{profile: c.loginShell()}
+	}
+	return posixSyntax{login: c.loginShell()}
+}
+
+// loginShell reports whether commands run in a login shell on the target of the client
+func (c *SSHClient) loginShell() bool {
+	return c.target != nil && c.target.LoginShell
+}
+
+// windows reports whether the target of the client runs Windows
+func (c *SSHClient) windows() bool {
+	return c.target != nil && c.target.IsWindows()
+}
@@ -5,19 +5,35 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/nickalie/nship/internal/config"
 	"github.com/nickalie/nship/internal/core/job"
 	"github.com/nickalie/nship/internal/core/target"
 	"github.com/nickalie/nship/internal/infrastructure/env"
 	"github.com/nickalie/nship/internal/infrastructure/fs"
+	"github.com/nickalie/nship/internal/infrastructure/notify"
 	"github.com/nickalie/nship/internal/infrastructure/ssh"
+	"github.com/nickalie/nship/internal/util"
 )
 
 // EnvLoader defines the interface for loading environment variables
 type EnvLoader interface {
 	Load(path, vaultPassword string) error
+	// SetVariables sets environment variables, overriding those of the environment files
+	SetVariables(values map[string]string) error
+	// IsSet reports whether the environment variable name was set by the loader rather than inherited
+	IsSet(name string) bool
 }
 
 // ConfigLoader defines the interface for loading configuration
@@ -27,37 +43,77 @@ type ConfigLoader interface {
 
 // JobService defines the interface for job execution
 type JobService interface {
-	ExecuteJobs(targets []*target.Target, jobs []*job.Job) error
+	ExecuteJobsWithHooksContext(ctx context.Context, targets []*target.Target, jobs []*job.Job, beforeAll, afterAll *job.Job) error
 }
 
+// LockingJobService is a JobService that locks the targets of a deployment against concurrent deployments
+type LockingJobService interface {
+	JobService
+	// SetLockName sets the name of the lock taken on each target by the following deployments
+	SetLockName(name string)
+}
+
+// Notifier sends a notification about a finished deployment
+type Notifier interface {
+	Notify(event notify.Event) error
+}
+
+// ConfirmFunc asks the user a yes/no question and reports whether it was answered with yes
+type ConfirmFunc func(question string) (bool, error)
+
 // App represents the main application structure that handles
 // configuration loading and job execution.
 type App struct {
 	envLoader    EnvLoader
 	configLoader ConfigLoader
 	jobService   JobService
+	interactive  bool
+	assumeYes    bool
+	confirm      ConfirmFunc
+	notifier     Notifier
+	hashStorage  job.ScopedHashStorage
+	defaultPort  int
+	envVars      map[string]string
+	// secrets holds the secret values of the environment and the configuration, masked in command output
+	secrets *util.SecretRegistry
+	// forceJobs holds the names of the jobs whose steps run even when unchanged, checked against the config
+	forceJobs []string
+	// timeout bounds the duration of a deployment, zero means no limit
+	timeout time.Duration
+	// skipPreflight disables the checks run before any job starts
+	skipPreflight bool
+	// checkConnections adds connecting to every target to the checks run before any job starts
+	checkConnections bool
+	// clientFactory creates the clients of the connection checks, by default SSH clients
+	clientFactory job.ClientFactory
+	// stderr receives the warnings of the app, by default the standard error
+	stderr io.Writer
+	// Options used to rebuild the default loaders and job service when an AppOption changes them
+	envOptions      []env.LoaderOption
+	configOptions   []config.LoaderOption
+	clientOptions   []ssh.ClientFactoryOption
+	serviceOptions  []job.ServiceOption
+	hashFileOptions []fs.FileHashStorageOption
 }
 
 // NewApp creates and returns a new App instance with default implementations
 // for all dependencies.
 func NewApp() *App {
-	envLoader := env.NewLoader()
+	secrets := util.NewSecretRegistry()
+	envLoader := env.NewLoader(env.WithSecrets(secrets))
 	configLoader := config.NewLoader()
-	clientFactory := ssh.NewClientFactory()
+	clientFactory := ssh.NewClientFactory(ssh.WithSecrets(secrets))
 	jobService := job.NewService(clientFactory)
 
-	return &App{
-		envLoader:    envLoader,
-		configLoader: configLoader,
-		jobService:   jobService,
-	}
+	return newApp(secrets, envLoader, configLoader, jobService)
 }
 
 // NewAppWithSkipUnchanged creates a new App instance with skip unchanged option
 func NewAppWithSkipUnchanged(skipUnchanged bool) *App {
-	envLoader := env.NewLoader()
+	secrets := util.NewSecretRegistry()
+	envLoader := env.NewLoader(env.WithSecrets(secrets))
 	configLoader := config.NewLoader()
-	clientFactory := ssh.NewClientFactory()
+	clientFactory := ssh.NewClientFactory(ssh.WithSecrets(secrets))
 	hashStorage := fs.NewFileHashStorage()
 
 	jobService := job.NewService(
@@ -66,19 +122,26 @@ func NewAppWithSkipUnchanged(skipUnchanged bool) *App {
 		job.WithSkipUnchanged(skipUnchanged),
 	)
 
-	return &App{
-		envLoader:    envLoader,
-		configLoader: configLoader,
-		jobService:   jobService,
-	}
+	return newApp(secrets, envLoader, configLoader, jobService)
 }
 
 // NewAppWithDeps creates and returns a new App instance with custom dependencies
 func NewAppWithDeps(envLoader EnvLoader, configLoader ConfigLoader, jobService JobService) *App {
+	return newApp(util.NewSecretRegistry(), envLoader, configLoader, jobService)
+}
+
+// newApp creates an App with the given dependencies. The default loaders and job service rebuilt
+// by AppOptions register and mask the secrets in secrets.
+func newApp(secrets *util.SecretRegistry, envLoader EnvLoader, configLoader ConfigLoader, jobService JobService) *App {
 	return &App{
-		envLoader:    envLoader,
-		configLoader: configLoader,
-		jobService:   jobService,
+		envLoader:     envLoader,
+		configLoader:  configLoader,
+		jobService:    jobService,
+		confirm:       util.Confirm,
+		stderr:        os.Stderr,
+		secrets:       secrets,
+		envOptions:    []env.LoaderOption{env.WithSecrets(secrets)},
+		clientOptions: []ssh.ClientFactoryOption{ssh.WithSecrets(secrets)},
 	}
 }
 
@@ -96,8 +159,16 @@ func RunWithSkipUnchanged(configPath, jobName string, envPaths []string, vaultPa
 
 // RunWithOptions executes the application with the provided parameters and all options
 func RunWithOptions(configPath, jobName string, envPaths []string, vaultPassword string, opts ...AppOption) error {
+	return RunWithOptionsContext(context.Background(), configPath, jobNameArgs(jobName), envPaths, vaultPassword, opts...)
+}
+
+// RunWithOptionsContext executes the application like RunWithOptions, running the jobs named in jobNames in
+// the order given, or all jobs if it is empty, and cancelling the deployment when ctx is cancelled
+func RunWithOptionsContext(
+	ctx context.Context, configPath string, jobNames, envPaths []string, vaultPassword string, opts ...AppOption,
+) error {
 	app := NewAppWithOptions(opts...)
-	return app.Run(configPath, jobName, envPaths, vaultPassword)
+	return app.RunContext(ctx, configPath, jobNames, envPaths, vaultPassword)
 }
 
 // AppOption is a function that modifies an App
@@ -106,16 +177,259 @@ type AppOption func(*App)
 // WithSkipUnchanged returns an option that configures step skipping behavior
 func WithSkipUnchanged(skipUnchanged bool) AppOption {
 	return func(app *App) {
-		hashStorage := fs.NewFileHashStorage()
-		clientFactory := ssh.NewClientFactory()
-		app.jobService = job.NewService(
-			clientFactory,
-			job.WithHashStorage(hashStorage),
-			job.WithSkipUnchanged(skipUnchanged),
-		)
+		if app.hashStorage == nil {
+			app.hashStorage = fs.NewFileHashStorage(app.hashFileOptions...)
+		}
+		app.serviceOptions = append(app.serviceOptions, job.WithSkipUnchanged(skipUnchanged))
+		app.rebuildJobService()
+	}
+}
+
+// WithForceJobs returns an option that executes all steps of the named jobs even when they are unchanged,
+// while unchanged steps of other jobs are still skipped. Names may be glob patterns like with --job.
+func WithForceJobs(names ...string) AppOption {
+	return func(app *App) {
+		app.forceJobs = append(app.forceJobs, names...)
+		app.serviceOptions = append(app.serviceOptions, job.WithForceJobs(names...))
+		app.rebuildJobService()
+	}
+}
+
+// WithTargetOrder returns an option that visits the targets in the given order: file, reverse,
+// sorted or a comma-separated list of all target names
+func WithTargetOrder(order string) AppOption {
+	return func(app *App) {
+		app.serviceOptions = append(app.serviceOptions, job.WithTargetOrder(order))
+		app.rebuildJobService()
+	}
+}
+
+// WithStateDir returns an option that stores the hashes of executed steps in dir instead of
+// the default hash directory. An empty dir keeps the default.
+func WithStateDir(dir string) AppOption {
+	return withHashFileOption(fs.WithHashDir(dir))
+}
+
+// WithStateFile returns an option that stores the hashes of executed steps in the file at path,
+// taking precedence over the state directory
+func WithStateFile(path string) AppOption {
+	return withHashFileOption(fs.WithHashFile(path))
+}
+
+// withHashFileOption returns an option that stores the hashes of executed steps locally using opt
+func withHashFileOption(opt fs.FileHashStorageOption) AppOption {
+	return func(app *App) {
+		app.hashFileOptions = append(app.hashFileOptions, opt)
+		app.hashStorage = fs.NewFileHashStorage(app.hashFileOptions...)
+		app.rebuildJobService()
+	}
+}
+
+// WithRemoteHashStorage returns an option that keeps the step hashes of each target in a
+// state file on that target instead of the local hash directory
+func WithRemoteHashStorage() AppOption {
+	return func(app *App) {
+		storage := ssh.NewRemoteHashStorage()
+		app.hashStorage = storage
+		app.clientOptions = append(app.clientOptions, ssh.WithRemoteHashStorage(storage))
+		app.rebuildJobService()
+	}
+}
+
+// WithMaxUploadRate returns an option that limits the combined upload rate of
+// copy steps to bytesPerSecond. Zero disables throttling.
+func WithMaxUploadRate(bytesPerSecond int64) AppOption {
+	return func(app *App) {
+		app.clientOptions = append(app.clientOptions, ssh.WithMaxUploadRate(bytesPerSecond))
+		app.rebuildJobService()
+	}
+}
+
+// WithOutput returns an option that sets how the output of remote commands is written,
+// i.e. whether standard error is merged into standard output and lines get timestamps
+func WithOutput(options ssh.OutputOptions) AppOption {
+	return func(app *App) {
+		app.clientOptions = append(app.clientOptions, ssh.WithOutput(options))
+		app.rebuildJobService()
+	}
+}
+
+// WithDebugSSH returns an option that logs the SSH connections to targets to standard error:
+// dialing, handshakes, authentication attempts and the amount of traffic
+func WithDebugSSH(enabled bool) AppOption {
+	return func(app *App) {
+		if !enabled {
+			return
+		}
+		logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		app.clientOptions = append(app.clientOptions, ssh.WithDebugLog(logger))
+		app.rebuildJobService()
+	}
+}
+
+// WithTimeout returns an option that stops the deployment when it takes longer than timeout,
+// like cancelling its context. Zero means no limit.
+func WithTimeout(timeout time.Duration) AppOption {
+	return func(app *App) {
+		app.timeout = timeout
+	}
+}
+
+// WithMaxReconnects returns an option that sets how often a connection lost during a job
+// is re-established before the job fails. Zero disables reconnecting.
+func WithMaxReconnects(maxReconnects int) AppOption {
+	return func(app *App) {
+		app.serviceOptions = append(app.serviceOptions, job.WithReconnect(maxReconnects, job.DefaultReconnectBackoff))
+		app.rebuildJobService()
+	}
+}
+
+// WithDefaultPort returns an option that connects to targets on port when neither they nor
+// the config set a port
+func WithDefaultPort(port int) AppOption {
+	return func(app *App) {
+		app.defaultPort = port
+	}
+}
+
+// WithKeepGoing returns an option that executes the remaining jobs and targets after a job
+// failed. The failures are then returned as a *job.DeploymentError.
+func WithKeepGoing(keepGoing bool) AppOption {
+	return func(app *App) {
+		app.serviceOptions = append(app.serviceOptions, job.WithKeepGoing(keepGoing))
+		app.rebuildJobService()
+	}
+}
+
+// WithForceUnlock returns an option that replaces the deploy locks of targets held by other deployments
+func WithForceUnlock(force bool) AppOption {
+	return func(app *App) {
+		app.serviceOptions = append(app.serviceOptions, job.WithForceUnlock(force))
+		app.rebuildJobService()
 	}
 }
 
+// WithLogDir returns an option that also writes the progress messages and command output
+// of each target to a log file per target in dir
+func WithLogDir(dir string) AppOption {
+	return func(app *App) {
+		app.serviceOptions = append(app.serviceOptions, job.WithLogDir(dir))
+		app.rebuildJobService()
+	}
+}
+
+// WithQuiet returns an option that doesn't write the progress messages and command output
+// of the targets to the console, only to the log files of WithLogDir
+func WithQuiet(quiet bool) AppOption {
+	return func(app *App) {
+		app.serviceOptions = append(app.serviceOptions, job.WithQuiet(quiet))
+		app.rebuildJobService()
+	}
+}
+
+// WithInteractive returns an option that enables prompting for confirmation before
+// running jobs on targets that require it. Without it such targets cause an error.
+func WithInteractive(interactive bool) AppOption {
+	return func(app *App) {
+		app.interactive = interactive
+	}
+}
+
+// WithAssumeYes returns an option that answers all prompts with yes, so that runs never
+// block on input. A vault password that is not provided becomes an error instead of a prompt.
+func WithAssumeYes(assumeYes bool) AppOption {
+	return func(app *App) {
+		app.assumeYes = assumeYes
+		app.envOptions = append(app.envOptions, env.WithAssumeYes(assumeYes))
+		app.envLoader = env.NewLoader(app.envOptions...)
+	}
+}
+
+// WithEnv returns an option that sets environment variables after the environment files are
+// loaded, overriding their values
+func WithEnv(vars map[string]string) AppOption {
+	return func(app *App) {
+		app.envVars = vars
+	}
+}
+
+// WithEnvOverride returns an option that makes the variables of environment files override those
+// nship was started with, which are kept by default
+func WithEnvOverride(override bool) AppOption {
+	return func(app *App) {
+		app.envOptions = append(app.envOptions, env.WithOverride(override))
+		app.envLoader = env.NewLoader(app.envOptions...)
+	}
+}
+
+// WithVaultPasswordFile returns an option that reads the vault password from the file at path
+// when no vault password is given explicitly.
+func WithVaultPasswordFile(path string) AppOption {
+	return func(app *App) {
+		app.envOptions = append(app.envOptions, env.WithVaultPasswordFile(path))
+		app.envLoader = env.NewLoader(app.envOptions...)
+	}
+}
+
+// WithProfile returns an option that merges the named profile of the config over it.
+// Loading fails when the config has no such profile.
+func WithProfile(name string) AppOption {
+	return func(app *App) {
+		app.configOptions = append(app.configOptions, config.WithProfile(name))
+		app.configLoader = config.NewLoader(app.configOptions...)
+	}
+}
+
+// WithTemplate returns an option that renders every config file as a Go template before it is
+// parsed, not only the files starting with the "# nship:template" header
+func WithTemplate(enabled bool) AppOption {
+	return func(app *App) {
+		app.configOptions = append(app.configOptions, config.WithTemplate(enabled))
+		app.configLoader = config.NewLoader(app.configOptions...)
+	}
+}
+
+// WithPreflight returns an option that enables or disables the checks run before any job starts,
+// such as whether the local sources of copy steps exist. They are enabled by default.
+func WithPreflight(enabled bool) AppOption {
+	return func(app *App) {
+		app.skipPreflight = !enabled
+	}
+}
+
+// WithConnectionCheck returns an option that connects to every target before any job starts and
+// aborts the deployment, listing the targets that could not be reached, if any connection fails
+func WithConnectionCheck(enabled bool) AppOption {
+	return func(app *App) {
+		app.checkConnections = enabled
+	}
+}
+
+// WithNotifier returns an option that sends deployment notifications through notifier
+// instead of the webhook configured in the notify block. The block's on filter still applies.
+func WithNotifier(notifier Notifier) AppOption {
+	return func(app *App) {
+		app.notifier = notifier
+	}
+}
+
+// WithStderr returns an option that writes the warnings of the app, such as a failed deployment
+// notification, to w instead of the standard error
+func WithStderr(w io.Writer) AppOption {
+	return func(app *App) {
+		app.stderr = w
+	}
+}
+
+// rebuildJobService replaces the job service with a default one using the accumulated options
+func (a *App) rebuildJobService() {
+	serviceOptions := a.serviceOptions
+	if a.hashStorage != nil {
+		serviceOptions = append([]job.ServiceOption{job.WithHashStorage(a.hashStorage)}, serviceOptions...)
+	}
+	a.jobService = job.NewService(ssh.NewClientFactory(a.clientOptions...), serviceOptions...)
+}
+
 // NewAppWithOptions creates a new App with the provided options
 func NewAppWithOptions(opts ...AppOption) *App {
 	app := NewApp()
@@ -129,34 +443,271 @@ func NewAppWithOptions(opts ...AppOption) *App {
 	return app
 }
 
-// Run executes the application with the provided configuration, job name,
-// environment paths, and vault password.
-func (a *App) Run(configPath, jobName string, envPaths []string, vaultPassword string) error {
+// Validate loads the environment files and the configuration exactly as Run does,
+// without connecting to any target, and returns the validated configuration.
+func Validate(configPath string, envPaths []string, vaultPassword string, opts ...AppOption) (*config.Config, error) {
+	app := NewAppWithOptions(opts...)
+	return app.LoadConfig(configPath, envPaths, vaultPassword)
+}
+
+// Dump loads and validates the configuration like Validate and serializes the result
+// into the given format. Secrets are replaced with a placeholder when redact is set.
+func Dump(configPath string, envPaths []string, vaultPassword, format string, redact bool, opts ...AppOption) ([]byte, error) {
+	cfg, err := Validate(configPath, envPaths, vaultPassword, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if redact {
+		if cfg, err = config.Redact(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return config.Marshal(cfg, format)
+}
+
+// Init writes the starter configuration in the given format to configPath. An existing file is only
+// overwritten when force is set.
+func Init(configPath, format string, force bool) error {
+	if _, err := os.Stat(configPath); err == nil && !force {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", configPath)
+	}
+
+	data, err := config.MarshalStarter(format)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(configPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", configPath, err)
+	}
+	return nil
+}
+
+// EnvVariable is an environment variable available to the ${VAR} references of the config
+type EnvVariable struct {
+	Name  string
+	Value string
+	// Set reports whether nship set the variable from an environment file or --env rather than inheriting it
+	Set bool
+}
+
+// sensitiveNamePattern matches the names of environment variables that likely hold secrets
+var sensitiveNamePattern = regexp.MustCompile(`(?i)PASS|SECRET|TOKEN|API_?KEY|PRIVATE_?KEY|CREDENTIAL`)
+
+// Env loads the environment files exactly as Run does and returns the resulting environment variables
+// sorted by name. Only the values nship set are shown: inherited values, values loaded from encrypted files
+// and values of variables named like secrets are redacted.
+func Env(envPaths []string, vaultPassword string, opts ...AppOption) ([]EnvVariable, error) {
+	app := NewAppWithOptions(opts...)
+	if err := app.loadEnvironments(envPaths, vaultPassword); err != nil {
+		return nil, fmt.Errorf("environment loading failed: %w", err)
+	}
+
+	var vars []EnvVariable
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		set := app.envLoader.IsSet(name)
+		vars = append(vars, EnvVariable{Name: name, Value: app.shownEnvValue(name, value, set), Set: set})
+	}
+
+	slices.SortFunc(vars, func(a, b EnvVariable) int { return strings.Compare(a.Name, b.Name) })
+	return vars, nil
+}
+
+// shownEnvValue returns the value of an environment variable as Env shows it, redacted unless nship set it
+// to a value that is not a secret
+func (a *App) shownEnvValue(name, value string, set bool) string {
+	if value == "" || (set && !a.secrets.Contains(value) && !sensitiveNamePattern.MatchString(name)) {
+		return value
+	}
+	return config.RedactedValue
+}
+
+// ClearCache removes the stored hashes of executed steps so that they run again, optionally limited
+// to a target and a job, and returns how many were removed. Hashes stored remotely are cleared on
+// the targets of the configuration, which is only loaded in that case.
+func ClearCache(configPath string, envPaths []string, vaultPassword, targetName, jobName string, opts ...AppOption) (int, error) {
+	app := NewAppWithOptions(opts...)
+	return app.ClearCache(configPath, envPaths, vaultPassword, targetName, jobName)
+}
+
+// ClearCache removes the stored hashes of the target and job, where an empty name matches any,
+// from the configured hash storage and returns how many were removed
+func (a *App) ClearCache(configPath string, envPaths []string, vaultPassword, targetName, jobName string) (int, error) {
+	storage := a.hashStorage
+	if storage == nil {
+		storage = fs.NewFileHashStorage(a.hashFileOptions...)
+	}
+
+	if _, remote := storage.(*ssh.RemoteHashStorage); remote {
+		clients, err := a.connectTargets(configPath, envPaths, vaultPassword, targetName)
+		defer closeClients(clients)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	removed, err := storage.ClearScope(targetName, jobName)
+	if err != nil {
+		return removed, fmt.Errorf("failed to clear cache: %w", err)
+	}
+	return removed, nil
+}
+
+// connectTargets loads the configuration and connects to its targets, or only to the one named
+// targetName if given, so that the remote hash storage can access their state files
+func (a *App) connectTargets(configPath string, envPaths []string, vaultPassword, targetName string) ([]job.Client, error) {
+	cfg, err := a.LoadConfig(configPath, envPaths, vaultPassword)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ApplyDefaultPort(a.defaultPort)
+
+	factory := ssh.NewClientFactory(a.clientOptions...)
+	var clients []job.Client
+	for _, tgt := range cfg.Targets {
+		if targetName != "" && tgt.GetName() != targetName {
+			continue
+		}
+
+		client, err := factory.NewClient(tgt)
+		if err != nil {
+			return clients, err
+		}
+		clients = append(clients, client)
+	}
+
+	if len(clients) == 0 && targetName != "" {
+		return nil, fmt.Errorf("target '%s' not found", targetName)
+	}
+	return clients, nil
+}
+
+// closeClients closes all clients
+func closeClients(clients []job.Client) {
+	for _, client := range clients {
+		client.Close()
+	}
+}
+
+// LoadConfig loads the environment files and then loads and validates the configuration.
+func (a *App) LoadConfig(configPath string, envPaths []string, vaultPassword string) (*config.Config, error) {
 	// Load environment variables
 	if err := a.loadEnvironments(envPaths, vaultPassword); err != nil {
-		return fmt.Errorf("environment loading failed: %w", err)
+		return nil, fmt.Errorf("environment loading failed: %w", err)
 	}
 
 	// Load configuration
 	cfg, err := a.configLoader.Load(configPath)
 	if err != nil {
-		return fmt.Errorf("config loading failed: %w", err)
+		return nil, fmt.Errorf("config loading failed: %w", err)
+	}
+	a.secrets.Add(cfg.Secrets()...)
+
+	return cfg, nil
+}
+
+// Run executes the application with the provided configuration, job name,
+// environment paths, and vault password.
+func (a *App) Run(configPath, jobName string, envPaths []string, vaultPassword string) error {
+	return a.RunContext(context.Background(), configPath, jobNameArgs(jobName), envPaths, vaultPassword)
+}
+
+// jobNameArgs returns the job names to run for a single job name, where an empty name selects all jobs
+func jobNameArgs(jobName string) []string {
+	if jobName == "" {
+		return nil
 	}
+	return []string{jobName}
+}
+
+// RunContext executes the application like Run, running the jobs named in jobNames in the order given,
+// or all jobs if it is empty. When ctx is cancelled or the timeout set with WithTimeout passes, the step
+// in progress is stopped and no further steps are started.
+func (a *App) RunContext(ctx context.Context, configPath string, jobNames, envPaths []string, vaultPassword string) error {
+	if a.timeout <= 0 {
+		return a.run(ctx, configPath, jobNames, envPaths, vaultPassword)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+
+	err := a.run(ctx, configPath, jobNames, envPaths, vaultPassword)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("deployment timed out after %s: %w", a.timeout, err)
+	}
+	return err
+}
+
+// run loads the configuration and executes the selected jobs
+func (a *App) run(ctx context.Context, configPath string, jobNames, envPaths []string, vaultPassword string) error {
+	cfg, err := a.LoadConfig(configPath, envPaths, vaultPassword)
+	if err != nil {
+		return err
+	}
+	cfg.ApplyDefaultPort(a.defaultPort)
 
 	// Get list of jobs to run
-	jobs, err := a.getJobsToRun(cfg, jobName)
+	jobs, err := a.getJobsToRun(cfg, jobNames)
 	if err != nil {
 		return fmt.Errorf("job selection failed: %w", err)
 	}
 
+	if err := a.preflight(ctx, cfg, jobs); err != nil {
+		return err
+	}
+
+	if err := a.confirmTargets(cfg.Targets, jobs); err != nil {
+		return err
+	}
+
+	a.setNamespace(cfg.Namespace(configPath))
+
 	// Execute jobs
-	if err := a.jobService.ExecuteJobs(cfg.Targets, jobs); err != nil {
+	start := time.Now()
+	err = a.jobService.ExecuteJobsWithHooksContext(ctx, cfg.Targets, jobs, cfg.BeforeAll, cfg.AfterAll)
+	a.notify(cfg, jobs, time.Since(start), err)
+	if err != nil {
 		return fmt.Errorf("job execution failed: %w", err)
 	}
 
 	return nil
 }
 
+// setNamespace keeps the step hashes and the deploy locks of the config apart from those of other configs
+func (a *App) setNamespace(namespace string) {
+	if storage, ok := a.hashStorage.(job.NamespacedHashStorage); ok {
+		storage.SetNamespace(namespace)
+	}
+	if service, ok := a.jobService.(LockingJobService); ok {
+		service.SetLockName(namespace)
+	}
+}
+
+// notify reports the outcome of the deployment if the config asks for it. Failing to send
+// the notification is only logged, so it never changes the outcome of the deployment.
+func (a *App) notify(cfg *config.Config, jobs []*job.Job, duration time.Duration, execErr error) {
+	if cfg.Notify == nil {
+		return
+	}
+
+	event := notify.NewEvent(targetNames(cfg.Targets), jobNameList(jobs), duration, execErr)
+	if !cfg.Notify.NotifiesOn(event.Status) {
+		return
+	}
+
+	notifier := a.notifier
+	if notifier == nil {
+		notifier = notify.NewWebhookNotifier(cfg.Notify.Webhook)
+	}
+
+	if err := notifier.Notify(event); err != nil {
+		fmt.Fprintf(a.stderr, "Warning: failed to send deployment notification: %v\n", err)
+	}
+}
+
 // GetJobService returns the job service for testing
 func (a *App) GetJobService() JobService {
 	return a.jobService
@@ -172,27 +723,144 @@ func (a *App) GetEnvLoader() EnvLoader {
 	return a.envLoader
 }
 
-// loadEnvironments loads all environment files
+// loadEnvironments loads all environment files in order, so that later files override earlier ones,
+// and then sets the variables given with WithEnv over them. Vault files given as password@path
+// are decrypted with their own password instead of vaultPassword.
 func (a *App) loadEnvironments(envPaths []string, vaultPassword string) error {
-	for _, path := range envPaths {
-		if err := a.envLoader.Load(path, vaultPassword); err != nil {
+	for _, entry := range envPaths {
+		path, password := env.SplitVaultPassword(entry)
+		if password == "" {
+			password = vaultPassword
+		}
+
+		if err := a.envLoader.Load(path, password); err != nil {
 			return fmt.Errorf("failed to load environment file %s: %w", path, err)
 		}
 	}
-	return nil
+	return a.envLoader.SetVariables(a.envVars)
 }
 
-// getJobsToRun determines which jobs to run based on the config and job name
-func (a *App) getJobsToRun(cfg *config.Config, jobName string) ([]*job.Job, error) {
-	if jobName == "" {
+// getJobsToRun determines which jobs to run based on the config and the job names given with --job,
+// in the order given. A name containing glob metacharacters, e.g. deploy-*, selects every job matching
+// it in the order of the config. Jobs selected more than once run only the first time.
+func (a *App) getJobsToRun(cfg *config.Config, names []string) ([]*job.Job, error) {
+	if err := checkForcedJobs(cfg, a.forceJobs); err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
 		return cfg.Jobs, nil
 	}
 
-	for _, j := range cfg.Jobs {
-		if j.Name == jobName {
+	var jobs []*job.Job
+	selected := make(map[*job.Job]bool)
+	for _, name := range names {
+		matched, err := selectJobs(cfg.Jobs, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, j := range matched {
+			if !selected[j] {
+				selected[j] = true
+				jobs = append(jobs, j)
+			}
+		}
+	}
+	return jobs, nil
+}
+
+// checkForcedJobs checks that every job forced to run with --force-job is in the config, as a job
+// or a matrix instance of a job such as deploy[region=eu], matching them like the job service does
+func checkForcedJobs(cfg *config.Config, names []string) error {
+	jobs, err := job.ExpandMatrix(cfg.Jobs)
+	if err != nil {
+		return fmt.Errorf("cannot force job: %w", err)
+	}
+
+	for _, name := range names {
+		if !slices.ContainsFunc(jobs, func(j *job.Job) bool { return job.MatchesJob(name, j) }) {
+			return fmt.Errorf("cannot force job: job '%s' not found, available jobs: %s", name, jobNames(jobs))
+		}
+	}
+	return nil
+}
+
+// selectJobs returns the job named name, or the jobs matching it if it is a glob pattern
+func selectJobs(jobs []*job.Job, name string) ([]*job.Job, error) {
+	if strings.ContainsAny(name, "*?[") {
+		return matchJobs(jobs, name)
+	}
+
+	for _, j := range jobs {
+		if j.Name == name {
 			return []*job.Job{j}, nil
 		}
 	}
 
-	return nil, fmt.Errorf("job '%s' not found", jobName)
+	return nil, fmt.Errorf("job '%s' not found, available jobs: %s", name, jobNames(jobs))
+}
+
+// matchJobs returns the jobs whose names match the glob pattern
+func matchJobs(jobs []*job.Job, pattern string) ([]*job.Job, error) {
+	var matched []*job.Job
+	for _, j := range jobs {
+		ok, err := path.Match(pattern, j.Name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid job pattern '%s': %w", pattern, err)
+		}
+		if ok {
+			matched = append(matched, j)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no job matches '%s', available jobs: %s", pattern, jobNames(jobs))
+	}
+	return matched, nil
+}
+
+// confirmTargets asks for approval before jobs run on targets that require confirmation
+func (a *App) confirmTargets(targets []*target.Target, jobs []*job.Job) error {
+	for _, tgt := range targets {
+		if !tgt.RequireConfirm || a.assumeYes {
+			continue
+		}
+
+		if !a.interactive {
+			return fmt.Errorf("target '%s' requires confirmation, run with --interactive or --yes", tgt.GetName())
+		}
+
+		ok, err := a.confirm(fmt.Sprintf("Run jobs [%s] on target '%s'?", jobNames(jobs), tgt.GetName()))
+		if err != nil {
+			return fmt.Errorf("confirmation failed: %w", err)
+		}
+		if !ok {
+			return fmt.Errorf("deployment to target '%s' aborted by user", tgt.GetName())
+		}
+	}
+
+	return nil
+}
+
+// jobNames returns the names of the jobs separated by commas
+func jobNames(jobs []*job.Job) string {
+	return strings.Join(jobNameList(jobs), ", ")
+}
+
+// jobNameList returns the names of the jobs
+func jobNameList(jobs []*job.Job) []string {
+	names := make([]string, len(jobs))
+	for i, j := range jobs {
+		names[i] = j.Name
+	}
+	return names
+}
+
+// targetNames returns the names of the targets
+func targetNames(targets []*target.Target) []string {
+	names := make([]string, len(targets))
+	for i, tgt := range targets {
+		names[i] = tgt.GetName()
+	}
+	return names
 }
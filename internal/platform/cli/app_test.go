@@ -1,14 +1,25 @@
 package cli
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nickalie/nship/internal/config"
 	"github.com/nickalie/nship/internal/core/job"
 	"github.com/nickalie/nship/internal/core/target"
+	"github.com/nickalie/nship/internal/infrastructure/fs"
+	"github.com/nickalie/nship/internal/infrastructure/notify"
+	"github.com/nickalie/nship/internal/infrastructure/ssh"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 // MockEnvLoader implements EnvLoader for testing
@@ -21,6 +32,20 @@ func (m *MockEnvLoader) Load(path, vaultPassword string) error {
 	return args.Error(0)
 }
 
+// SetVariables sets the variables like the default loader, so that tests need not expect it
+func (m *MockEnvLoader) SetVariables(values map[string]string) error {
+	for name, value := range values {
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MockEnvLoader) IsSet(string) bool {
+	return false
+}
+
 // MockConfigLoader implements ConfigLoader for testing
 type MockConfigLoader struct {
 	mock.Mock
@@ -39,8 +64,8 @@ type MockJobService struct {
 	mock.Mock
 }
 
-func (m *MockJobService) ExecuteJobs(targets []*target.Target, jobs []*job.Job) error {
-	args := m.Called(targets, jobs)
+func (m *MockJobService) ExecuteJobsWithHooksContext(_ context.Context, targets []*target.Target, jobs []*job.Job, beforeAll, afterAll *job.Job) error {
+	args := m.Called(targets, jobs, beforeAll, afterAll)
 	return args.Error(0)
 }
 
@@ -72,7 +97,7 @@ func TestApp_Run(t *testing.T) {
 				}
 
 				configLoader.On("Load", "config.yaml").Return(defaultConfig, nil)
-				jobService.On("ExecuteJobs", defaultConfig.Targets, defaultConfig.Jobs).Return(nil)
+				jobService.On("ExecuteJobsWithHooksContext", defaultConfig.Targets, defaultConfig.Jobs, (*job.Job)(nil), (*job.Job)(nil)).Return(nil)
 			},
 			wantErr: false,
 		},
@@ -134,7 +159,7 @@ func TestApp_Run(t *testing.T) {
 					},
 				}
 				configLoader.On("Load", "config.yaml").Return(cfg, nil)
-				jobService.On("ExecuteJobs", cfg.Targets, cfg.Jobs).Return(errors.New("job execution error"))
+				jobService.On("ExecuteJobsWithHooksContext", cfg.Targets, cfg.Jobs, (*job.Job)(nil), (*job.Job)(nil)).Return(errors.New("job execution error"))
 			},
 			wantErr:     true,
 			errContains: "job execution failed",
@@ -175,6 +200,68 @@ func TestApp_Run(t *testing.T) {
 	}
 }
 
+func TestApp_LoadConfig(t *testing.T) {
+	cfg := &config.Config{
+		Targets: []*target.Target{{Name: "default-target", Host: "localhost", User: "user", Password: "hunter22"}},
+		Jobs:    []*job.Job{{Name: "default-job", Steps: []*job.Step{{Run: "echo test"}}}},
+	}
+
+	t.Run("loads environments and config without executing jobs", func(t *testing.T) {
+		mockEnvLoader := new(MockEnvLoader)
+		mockConfigLoader := new(MockConfigLoader)
+		mockJobService := new(MockJobService)
+
+		mockEnvLoader.On("Load", "prod.env", "secret").Return(nil)
+		mockConfigLoader.On("Load", "config.yaml").Return(cfg, nil)
+
+		app := NewAppWithDeps(mockEnvLoader, mockConfigLoader, mockJobService)
+		loaded, err := app.LoadConfig("config.yaml", []string{"prod.env"}, "secret")
+
+		assert.NoError(t, err)
+		assert.Same(t, cfg, loaded)
+		assert.True(t, app.secrets.Contains("hunter22"), "the secrets of the config should be masked in command output")
+		mockEnvLoader.AssertExpectations(t)
+		mockConfigLoader.AssertExpectations(t)
+		mockJobService.AssertNotCalled(t, "ExecuteJobsWithHooksContext", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("config loading error", func(t *testing.T) {
+		mockConfigLoader := new(MockConfigLoader)
+		mockConfigLoader.On("Load", "config.yaml").Return(nil, errors.New("invalid config"))
+
+		app := NewAppWithDeps(new(MockEnvLoader), mockConfigLoader, new(MockJobService))
+		_, err := app.LoadConfig("config.yaml", nil, "")
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "config loading failed: invalid config")
+	})
+}
+
+func TestDump(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "nship.yaml")
+	err := os.WriteFile(configPath, []byte(`
+targets:
+  - host: web.example.com
+    user: admin
+    password: secret
+jobs:
+  - name: deploy
+    steps:
+      - run: echo deploy
+`), 0644)
+	assert.NoError(t, err)
+
+	data, err := Dump(configPath, nil, "", "yaml", false)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "password: secret")
+	assert.Contains(t, string(data), "name: web.example.com")
+
+	data, err = Dump(configPath, nil, "", "json", true)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"password": "`+config.RedactedValue+`"`)
+	assert.NotContains(t, string(data), "secret")
+}
+
 func TestNewApp(t *testing.T) {
 	app := NewApp()
 	assert.NotNil(t, app, "NewApp() returned nil")
@@ -241,6 +328,45 @@ func TestNewAppWithOptions(t *testing.T) {
 		// we can verify that a jobService was created
 	})
 
+	t.Run("with WithRemoteHashStorage option", func(t *testing.T) {
+		for _, opts := range [][]AppOption{
+			{WithRemoteHashStorage(), WithSkipUnchanged(true)},
+			{WithSkipUnchanged(true), WithRemoteHashStorage()},
+		} {
+			app := NewAppWithOptions(opts...)
+
+			_, isRemote := app.hashStorage.(*ssh.RemoteHashStorage)
+			assert.True(t, isRemote, "remote storage should win regardless of option order")
+			assert.Len(t, app.clientOptions, 2, "the storage option should be added once, next to the secrets")
+		}
+	})
+
+	t.Run("with WithStateFile option", func(t *testing.T) {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		app := NewAppWithOptions(WithStateFile(stateFile), WithSkipUnchanged(true))
+
+		assert.NoError(t, app.hashStorage.SaveHash("web", "deploy", 0, "hash"))
+		assert.FileExists(t, stateFile, "the state file should be kept when skipping is enabled later")
+	})
+
+	t.Run("with WithProfile option", func(t *testing.T) {
+		configPath := filepath.Join(t.TempDir(), "nship.yaml")
+		assert.NoError(t, os.WriteFile(configPath, []byte(`
+targets:
+  - host: localhost
+    user: admin
+    password: secret
+jobs:
+  - name: deploy
+    steps:
+      - run: echo deploy
+`), 0644))
+
+		app := NewAppWithOptions(WithProfile("prod"))
+		_, err := app.LoadConfig(configPath, nil, "")
+		assert.ErrorContains(t, err, "profile 'prod' not found")
+	})
+
 	t.Run("with multiple options", func(t *testing.T) {
 		// Create a custom option for testing
 		customOption := func(app *App) {
@@ -266,6 +392,176 @@ func TestNewAppWithOptions(t *testing.T) {
 	})
 }
 
+func TestApp_RunKeepGoing(t *testing.T) {
+	cfg := &config.Config{
+		Targets: []*target.Target{{Name: "web", Host: "web.example.com", User: "deploy"}},
+		Jobs:    []*job.Job{{Name: "deploy", Steps: []*job.Step{{Run: "echo deploy"}}}},
+	}
+	configLoader := new(MockConfigLoader)
+	configLoader.On("Load", "nship.yaml").Return(cfg, nil)
+
+	app := NewAppWithDeps(new(MockEnvLoader), configLoader, nil)
+	WithKeepGoing(true)(app)
+	assert.Len(t, app.serviceOptions, 1)
+
+	jobService := new(MockJobService)
+	jobService.On("ExecuteJobsWithHooksContext", cfg.Targets, cfg.Jobs, (*job.Job)(nil), (*job.Job)(nil)).
+		Return(&job.DeploymentError{Total: 2, Failed: 1, Errors: []error{errors.New("deploy failed")}})
+	app.jobService = jobService
+
+	err := app.Run("nship.yaml", "", nil, "")
+	var deployErr *job.DeploymentError
+	assert.ErrorAs(t, err, &deployErr, "the failures should stay available to callers")
+	assert.True(t, deployErr.Partial())
+}
+
+// stuckJobService is a JobService whose step never finishes until its context is cancelled
+type stuckJobService struct{}
+
+func (stuckJobService) ExecuteJobsWithHooksContext(ctx context.Context, targets []*target.Target, jobs []*job.Job, _, _ *job.Job) error {
+	<-ctx.Done()
+	return &job.StepError{JobName: jobs[0].Name, Target: targets[0].Name, StepNum: 2, TotalNum: 3, Cause: ctx.Err()}
+}
+
+func TestApp_RunTimeout(t *testing.T) {
+	cfg := &config.Config{
+		Targets: []*target.Target{{Name: "web", Host: "web.example.com", User: "deploy"}},
+		Jobs:    []*job.Job{{Name: "deploy", Steps: []*job.Step{{Run: "sleep 3600"}}}},
+	}
+	configLoader := new(MockConfigLoader)
+	configLoader.On("Load", "nship.yaml").Return(cfg, nil)
+
+	app := NewAppWithDeps(new(MockEnvLoader), configLoader, stuckJobService{})
+	WithTimeout(50 * time.Millisecond)(app)
+
+	start := time.Now()
+	err := app.Run("nship.yaml", "", nil, "")
+	assert.Less(t, time.Since(start), 5*time.Second, "the deployment should stop at the timeout")
+	assert.EqualError(t, err, "deployment timed out after 50ms: job execution failed: "+
+		"job 'deploy' step 2/3 on 'web' failed: context deadline exceeded")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestApp_RunTimeoutNotReached(t *testing.T) {
+	cfg := &config.Config{
+		Targets: []*target.Target{{Name: "web", Host: "web.example.com", User: "deploy"}},
+		Jobs:    []*job.Job{{Name: "deploy", Steps: []*job.Step{{Run: "echo deploy"}}}},
+	}
+	configLoader := new(MockConfigLoader)
+	configLoader.On("Load", "nship.yaml").Return(cfg, nil)
+	jobService := new(MockJobService)
+	jobService.On("ExecuteJobsWithHooksContext", cfg.Targets, cfg.Jobs, (*job.Job)(nil), (*job.Job)(nil)).
+		Return(errors.New("deploy failed"))
+
+	app := NewAppWithDeps(new(MockEnvLoader), configLoader, jobService)
+	WithTimeout(time.Hour)(app)
+
+	assert.EqualError(t, app.Run("nship.yaml", "", nil, ""), "job execution failed: deploy failed",
+		"failures before the timeout should not be reported as one")
+}
+
+func TestApp_RunNamespacesHashes(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	assert.NoError(t, fs.NewFileHashStorage(fs.WithHashFile(stateFile)).SaveHash("web", "deploy", 0, "legacy"))
+
+	cfg := &config.Config{
+		Project: "shop",
+		Targets: []*target.Target{{Name: "web", Host: "web.example.com", User: "deploy"}},
+		Jobs:    []*job.Job{{Name: "deploy", Steps: []*job.Step{{Run: "echo deploy"}}}},
+	}
+	configLoader := new(MockConfigLoader)
+	configLoader.On("Load", "nship.yaml").Return(cfg, nil)
+	jobService := new(MockJobService)
+	jobService.On("ExecuteJobsWithHooksContext", cfg.Targets, cfg.Jobs, (*job.Job)(nil), (*job.Job)(nil)).Return(nil)
+
+	app := NewAppWithDeps(new(MockEnvLoader), configLoader, jobService)
+	WithStateFile(stateFile)(app)
+	app.jobService = jobService
+
+	assert.NoError(t, app.Run("nship.yaml", "", nil, ""))
+
+	hash, err := app.hashStorage.GetHash("web", "deploy", 0)
+	assert.NoError(t, err)
+	assert.Empty(t, hash, "hashes stored without a namespace should be a cache miss")
+}
+
+// lockingJobService is a MockJobService recording the lock name it was given
+type lockingJobService struct {
+	MockJobService
+	lockName string
+}
+
+func (s *lockingJobService) SetLockName(name string) { s.lockName = name }
+
+func TestApp_RunLocksTargets(t *testing.T) {
+	cfg := &config.Config{
+		Project: "shop",
+		Targets: []*target.Target{{Name: "web", Host: "web.example.com", User: "deploy"}},
+		Jobs:    []*job.Job{{Name: "deploy", Steps: []*job.Step{{Run: "echo deploy"}}}},
+	}
+	configLoader := new(MockConfigLoader)
+	configLoader.On("Load", "nship.yaml").Return(cfg, nil)
+	jobService := &lockingJobService{}
+	jobService.On("ExecuteJobsWithHooksContext", cfg.Targets, cfg.Jobs, (*job.Job)(nil), (*job.Job)(nil)).Return(nil)
+
+	app := NewAppWithDeps(new(MockEnvLoader), configLoader, jobService)
+	assert.NoError(t, app.Run("nship.yaml", "", nil, ""))
+	assert.Equal(t, "shop", jobService.lockName, "targets should be locked under the project")
+
+	WithForceUnlock(true)(app)
+	_, ok := app.jobService.(LockingJobService)
+	assert.True(t, ok, "the default job service should lock targets")
+}
+
+func TestApp_RunDefaultPort(t *testing.T) {
+	cfg := &config.Config{
+		Targets: []*target.Target{
+			{Name: "web", Host: "web.example.com", User: "deploy"},
+			{Name: "db", Host: "db.example.com", User: "deploy", Port: 2200},
+		},
+		Jobs: []*job.Job{{Name: "deploy", Steps: []*job.Step{{Run: "echo deploy"}}}},
+	}
+	configLoader := new(MockConfigLoader)
+	configLoader.On("Load", "nship.yaml").Return(cfg, nil)
+	jobService := new(MockJobService)
+	jobService.On("ExecuteJobsWithHooksContext", cfg.Targets, cfg.Jobs, (*job.Job)(nil), (*job.Job)(nil)).Return(nil)
+
+	app := NewAppWithDeps(new(MockEnvLoader), configLoader, jobService)
+	WithDefaultPort(2222)(app)
+
+	assert.NoError(t, app.Run("nship.yaml", "", nil, ""))
+	assert.Equal(t, 2222, cfg.Targets[0].GetPort())
+	assert.Equal(t, 2200, cfg.Targets[1].GetPort(), "the port of a target should take precedence")
+}
+
+func TestClearCache(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	storage := fs.NewFileHashStorage(fs.WithHashFile(stateFile))
+	assert.NoError(t, storage.SaveHash("web", "deploy", 0, "hash"))
+	assert.NoError(t, storage.SaveHash("web", "backup", 0, "hash"))
+
+	removed, err := ClearCache("nship.yaml", nil, "", "", "deploy", WithStateFile(stateFile))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	removed, err = ClearCache("nship.yaml", nil, "", "", "", WithStateFile(stateFile))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+}
+
+func TestClearCacheRemoteUnknownTarget(t *testing.T) {
+	configLoader := new(MockConfigLoader)
+	configLoader.On("Load", "nship.yaml").Return(&config.Config{
+		Targets: []*target.Target{{Name: "web", Host: "web.example.com", User: "deploy"}},
+	}, nil)
+
+	app := NewAppWithDeps(new(MockEnvLoader), configLoader, new(MockJobService))
+	WithRemoteHashStorage()(app)
+
+	_, err := app.ClearCache("nship.yaml", nil, "", "db", "")
+	assert.ErrorContains(t, err, "target 'db' not found")
+}
+
 func TestGetJobsToRun(t *testing.T) {
 	allJobs := []*job.Job{
 		{Name: "job1", Steps: []*job.Step{{Run: "echo job1"}}},
@@ -307,7 +603,7 @@ func TestGetJobsToRun(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			jobs, err := app.getJobsToRun(cfg, tt.jobName)
+			jobs, err := app.getJobsToRun(cfg, jobNameArgs(tt.jobName))
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("getJobsToRun() error = %v, wantErr %v", err, tt.wantErr)
@@ -327,6 +623,86 @@ func TestGetJobsToRun(t *testing.T) {
 	}
 }
 
+func TestGetJobsToRunPattern(t *testing.T) {
+	cfg := &config.Config{Jobs: []*job.Job{
+		{Name: "deploy-web"}, {Name: "build"}, {Name: "deploy-api"}, {Name: "deploy"},
+	}}
+	app := &App{}
+
+	jobs, err := app.getJobsToRun(cfg, []string{"deploy-*"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deploy-web", "deploy-api"}, jobNameList(jobs), "matching jobs should run in config order")
+
+	jobs, err = app.getJobsToRun(cfg, []string{"deploy-[aw]??"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deploy-web", "deploy-api"}, jobNameList(jobs))
+
+	jobs, err = app.getJobsToRun(cfg, []string{"deploy"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deploy"}, jobNameList(jobs), "names without metacharacters should match exactly")
+
+	_, err = app.getJobsToRun(cfg, []string{"test-*"})
+	assert.EqualError(t, err, "no job matches 'test-*', available jobs: deploy-web, build, deploy-api, deploy")
+
+	_, err = app.getJobsToRun(cfg, []string{"deploy-["})
+	assert.ErrorContains(t, err, "invalid job pattern 'deploy-['")
+
+	_, err = app.getJobsToRun(cfg, []string{"test"})
+	assert.EqualError(t, err, "job 'test' not found, available jobs: deploy-web, build, deploy-api, deploy")
+}
+
+func TestGetJobsToRunMultiple(t *testing.T) {
+	cfg := &config.Config{Jobs: []*job.Job{
+		{Name: "build"}, {Name: "migrate"}, {Name: "deploy-web"}, {Name: "deploy-api"},
+	}}
+	app := &App{}
+
+	jobs, err := app.getJobsToRun(cfg, []string{"migrate", "build"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"migrate", "build"}, jobNameList(jobs), "jobs should run in the order given")
+
+	jobs, err = app.getJobsToRun(cfg, []string{"deploy-api", "deploy-*", "migrate"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deploy-api", "deploy-web", "migrate"}, jobNameList(jobs), "jobs selected twice should run once")
+
+	_, err = app.getJobsToRun(cfg, []string{"build", "test"})
+	assert.EqualError(t, err, "job 'test' not found, available jobs: build, migrate, deploy-web, deploy-api")
+}
+
+func TestWithTargetOrder(t *testing.T) {
+	app := &App{}
+	WithTargetOrder("reverse")(app)
+	assert.Len(t, app.serviceOptions, 1)
+	assert.NotNil(t, app.jobService)
+}
+
+func TestGetJobsToRunForcedJobs(t *testing.T) {
+	cfg := &config.Config{Jobs: []*job.Job{{Name: "build"}, {Name: "deploy-web"}, {Name: "deploy-api"}}}
+	app := &App{}
+	WithForceJobs("build", "deploy-*")(app)
+
+	jobs, err := app.getJobsToRun(cfg, []string{"deploy-web"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deploy-web"}, jobNameList(jobs), "forcing a job should not select it")
+
+	app = &App{}
+	WithForceJobs("test")(app)
+	_, err = app.getJobsToRun(cfg, nil)
+	assert.EqualError(t, err, "cannot force job: job 'test' not found, available jobs: build, deploy-web, deploy-api")
+
+	cfg.Jobs = append(cfg.Jobs, &job.Job{Name: "migrate", Matrix: map[string][]string{"db": {"main", "audit"}}})
+	app = &App{}
+	WithForceJobs("migrate[db=audit]", "migrate")(app)
+	_, err = app.getJobsToRun(cfg, nil)
+	assert.NoError(t, err, "matrix jobs and their instances should be accepted")
+
+	app = &App{}
+	WithForceJobs("migrate[db=logs]")(app)
+	_, err = app.getJobsToRun(cfg, nil)
+	assert.ErrorContains(t, err, "job 'migrate[db=logs]' not found")
+	assert.ErrorContains(t, err, "migrate[db=main], migrate[db=audit]", "matrix instances should be listed")
+}
+
 func TestLoadEnvironments(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -352,6 +728,17 @@ func TestLoadEnvironments(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:          "vault files with own passwords",
+			envPaths:      []string{"first-secret@first.vault", "second.vault", "p@ss@third.vault"},
+			vaultPassword: "global",
+			setupMock: func(m *MockEnvLoader) {
+				m.On("Load", "first.vault", "first-secret").Return(nil)
+				m.On("Load", "second.vault", "global").Return(nil)
+				m.On("Load", "third.vault", "p@ss").Return(nil)
+			},
+			wantErr: false,
+		},
 		{
 			name:     "env loading error",
 			envPaths: []string{"env1.yaml", "error.yaml"},
@@ -383,6 +770,66 @@ func TestLoadEnvironments(t *testing.T) {
 	}
 }
 
+func TestLoadEnvironmentsPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.env")
+	prod := filepath.Join(dir, "prod.env")
+	assert.NoError(t, os.WriteFile(base, []byte("NSHIP_TEST_HOST=base\nNSHIP_TEST_USER=base\nNSHIP_TEST_PORT=base\n"), 0600))
+	assert.NoError(t, os.WriteFile(prod, []byte("NSHIP_TEST_HOST=prod\nNSHIP_TEST_USER=prod\n"), 0600))
+	unsetenv(t, "NSHIP_TEST_HOST", "NSHIP_TEST_USER", "NSHIP_TEST_PORT")
+
+	app := NewAppWithOptions(WithEnv(map[string]string{"NSHIP_TEST_HOST": "flag"}))
+	assert.NoError(t, app.loadEnvironments([]string{base, prod}, ""))
+
+	assert.Equal(t, "flag", os.Getenv("NSHIP_TEST_HOST"), "--env should override env files")
+	assert.Equal(t, "prod", os.Getenv("NSHIP_TEST_USER"), "later env files should override earlier ones")
+	assert.Equal(t, "base", os.Getenv("NSHIP_TEST_PORT"))
+}
+
+func TestLoadEnvironmentsKeepsInheritedVariables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prod.env")
+	assert.NoError(t, os.WriteFile(path, []byte("NSHIP_TEST_STAGE=prod\n"), 0600))
+	t.Setenv("NSHIP_TEST_STAGE", "ci")
+
+	assert.NoError(t, NewApp().loadEnvironments([]string{path}, ""))
+	assert.Equal(t, "ci", os.Getenv("NSHIP_TEST_STAGE"), "the environment nship was started with should win by default")
+
+	assert.NoError(t, NewAppWithOptions(WithEnvOverride(true)).loadEnvironments([]string{path}, ""))
+	assert.Equal(t, "prod", os.Getenv("NSHIP_TEST_STAGE"))
+}
+
+// unsetenv unsets the environment variables names for the duration of the test
+func unsetenv(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		t.Setenv(name, "")
+		require.NoError(t, os.Unsetenv(name))
+	}
+}
+
+func TestEnv(t *testing.T) {
+	envFile := filepath.Join(t.TempDir(), "prod.env")
+	assert.NoError(t, os.WriteFile(envFile, []byte("NSHIP_TEST_RELEASE=1.2.3\nNSHIP_TEST_DB_PASSWORD=hunter22\n"), 0600))
+	t.Setenv("NSHIP_TEST_INHERITED", "yes")
+	unsetenv(t, "NSHIP_TEST_RELEASE", "NSHIP_TEST_DB_PASSWORD")
+
+	vars, err := Env([]string{envFile}, "")
+	assert.NoError(t, err)
+
+	found := make(map[string]EnvVariable)
+	for _, v := range vars {
+		found[v.Name] = v
+	}
+	assert.Equal(t, EnvVariable{Name: "NSHIP_TEST_RELEASE", Value: "1.2.3", Set: true}, found["NSHIP_TEST_RELEASE"])
+	assert.Equal(t, EnvVariable{Name: "NSHIP_TEST_DB_PASSWORD", Value: "********", Set: true}, found["NSHIP_TEST_DB_PASSWORD"])
+	assert.Equal(t, EnvVariable{Name: "NSHIP_TEST_INHERITED", Value: "********"}, found["NSHIP_TEST_INHERITED"],
+		"inherited values should be redacted")
+	assert.True(t, slices.IsSortedFunc(vars, func(a, b EnvVariable) int { return strings.Compare(a.Name, b.Name) }))
+
+	_, err = Env([]string{filepath.Join(t.TempDir(), "missing.env")}, "")
+	assert.Error(t, err)
+}
+
 func TestGetJobService(t *testing.T) {
 	mockService := new(MockJobService)
 	app := &App{
@@ -416,7 +863,7 @@ func TestGlobalRunFunction(t *testing.T) {
 
 	// Setup expected behavior
 	mockConfigLoader.On("Load", "config.yaml").Return(testConfig, nil)
-	mockJobService.On("ExecuteJobs", testConfig.Targets, testConfig.Jobs).Return(nil)
+	mockJobService.On("ExecuteJobsWithHooksContext", testConfig.Targets, testConfig.Jobs, (*job.Job)(nil), (*job.Job)(nil)).Return(nil)
 
 	// Create app with mocked dependencies
 	app := NewAppWithDeps(mockEnvLoader, mockConfigLoader, mockJobService)
@@ -432,3 +879,192 @@ func TestGlobalRunFunction(t *testing.T) {
 	// Note: We're not testing the global Run function directly,
 	// but we're testing the core functionality it relies on
 }
+
+func TestApp_RunGlobalHooks(t *testing.T) {
+	mockConfigLoader := new(MockConfigLoader)
+	mockJobService := new(MockJobService)
+
+	testConfig := &config.Config{
+		Targets:   []*target.Target{{Name: "test-target", Host: "localhost", User: "user"}},
+		Jobs:      []*job.Job{{Name: "test-job", Steps: []*job.Step{{Run: "echo test"}}}},
+		BeforeAll: &job.Job{Steps: []*job.Step{{Run: "echo before"}}},
+		AfterAll:  &job.Job{Steps: []*job.Step{{Run: "echo after"}}},
+	}
+
+	mockConfigLoader.On("Load", "config.yaml").Return(testConfig, nil)
+	mockJobService.On("ExecuteJobsWithHooksContext", testConfig.Targets, testConfig.Jobs, testConfig.BeforeAll, testConfig.AfterAll).Return(nil)
+
+	app := NewAppWithDeps(new(MockEnvLoader), mockConfigLoader, mockJobService)
+	assert.NoError(t, app.Run("config.yaml", "", nil, ""))
+	mockJobService.AssertExpectations(t)
+}
+
+// fakeNotifier records sent events and fails with err
+type fakeNotifier struct {
+	events []notify.Event
+	err    error
+}
+
+func (n *fakeNotifier) Notify(event notify.Event) error {
+	n.events = append(n.events, event)
+	return n.err
+}
+
+func TestApp_RunNotify(t *testing.T) {
+	tests := []struct {
+		name       string
+		on         []string
+		execErr    error
+		notifyErr  error
+		wantStatus string
+	}{
+		{name: "success", wantStatus: notify.StatusSuccess},
+		{name: "failure", execErr: errors.New("boom"), wantStatus: notify.StatusFailure},
+		{name: "filtered out", on: []string{notify.StatusFailure}},
+		{name: "notifier error is ignored", notifyErr: errors.New("unreachable"), wantStatus: notify.StatusSuccess},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Targets: []*target.Target{{Name: "web", Host: "localhost", User: "user"}},
+				Jobs:    []*job.Job{{Name: "deploy", Steps: []*job.Step{{Run: "echo test"}}}},
+				Notify:  &config.NotifyConfig{Webhook: "https://hooks.example.com/x", On: tt.on},
+			}
+
+			configLoader := new(MockConfigLoader)
+			configLoader.On("Load", "config.yaml").Return(cfg, nil)
+			jobService := new(MockJobService)
+			jobService.On("ExecuteJobsWithHooksContext", cfg.Targets, cfg.Jobs, (*job.Job)(nil), (*job.Job)(nil)).Return(tt.execErr)
+
+			notifier := &fakeNotifier{err: tt.notifyErr}
+			app := NewAppWithDeps(new(MockEnvLoader), configLoader, jobService)
+			WithNotifier(notifier)(app)
+
+			err := app.Run("config.yaml", "", nil, "")
+			assert.Equal(t, tt.execErr != nil, err != nil, "notifications must not change the result")
+
+			if tt.wantStatus == "" {
+				assert.Empty(t, notifier.events)
+				return
+			}
+
+			assert.Len(t, notifier.events, 1)
+			event := notifier.events[0]
+			assert.Equal(t, tt.wantStatus, event.Status)
+			assert.Equal(t, []string{"web"}, event.Targets)
+			assert.Equal(t, []string{"deploy"}, event.Jobs)
+			if tt.execErr != nil {
+				assert.Contains(t, event.Error, "boom")
+			}
+		})
+	}
+}
+
+func TestApp_RunNotifyFailureWarning(t *testing.T) {
+	cfg := &config.Config{
+		Targets: []*target.Target{{Name: "web", Host: "localhost", User: "user"}},
+		Jobs:    []*job.Job{{Name: "deploy", Steps: []*job.Step{{Run: "echo test"}}}},
+		Notify:  &config.NotifyConfig{Webhook: "https://hooks.example.com/x"},
+	}
+
+	configLoader := new(MockConfigLoader)
+	configLoader.On("Load", "config.yaml").Return(cfg, nil)
+	jobService := new(MockJobService)
+	partial := &job.DeploymentError{Total: 2, Failed: 1, Errors: []error{errors.New("boom")}}
+	jobService.On("ExecuteJobsWithHooksContext", cfg.Targets, cfg.Jobs, (*job.Job)(nil), (*job.Job)(nil)).Return(partial)
+
+	var stderr bytes.Buffer
+	app := NewAppWithDeps(new(MockEnvLoader), configLoader, jobService)
+	WithNotifier(&fakeNotifier{err: errors.New("unreachable")})(app)
+	WithStderr(&stderr)(app)
+
+	err := app.Run("config.yaml", "", nil, "")
+	var deployErr *job.DeploymentError
+	require.ErrorAs(t, err, &deployErr, "the exit code must still report the partial failure")
+	assert.True(t, deployErr.Partial())
+	assert.Equal(t, "Warning: failed to send deployment notification: unreachable\n", stderr.String())
+}
+
+func TestApp_RunWithoutNotify(t *testing.T) {
+	cfg := &config.Config{
+		Targets: []*target.Target{{Name: "web", Host: "localhost", User: "user"}},
+		Jobs:    []*job.Job{{Name: "deploy", Steps: []*job.Step{{Run: "echo test"}}}},
+	}
+
+	configLoader := new(MockConfigLoader)
+	configLoader.On("Load", "config.yaml").Return(cfg, nil)
+	jobService := new(MockJobService)
+	jobService.On("ExecuteJobsWithHooksContext", cfg.Targets, cfg.Jobs, (*job.Job)(nil), (*job.Job)(nil)).Return(nil)
+
+	notifier := &fakeNotifier{}
+	app := NewAppWithDeps(new(MockEnvLoader), configLoader, jobService)
+	WithNotifier(notifier)(app)
+
+	assert.NoError(t, app.Run("config.yaml", "", nil, ""))
+	assert.Empty(t, notifier.events, "nothing is sent without a notify block")
+}
+
+func TestApp_RunConfirmation(t *testing.T) {
+	cfg := &config.Config{
+		Targets: []*target.Target{
+			{Name: "staging", Host: "staging.example.com", User: "user"},
+			{Name: "production", Host: "prod.example.com", User: "user", RequireConfirm: true},
+		},
+		Jobs: []*job.Job{
+			{Name: "build", Steps: []*job.Step{{Run: "make"}}},
+			{Name: "deploy", Steps: []*job.Step{{Run: "make deploy"}}},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		interactive bool
+		assumeYes   bool
+		answer      bool
+		answerErr   error
+		wantRun     bool
+		errContains string
+	}{
+		{name: "non-interactive", interactive: false, errContains: "target 'production' requires confirmation"},
+		{name: "assume yes", assumeYes: true, wantRun: true},
+		{name: "assume yes overrides prompt", interactive: true, assumeYes: true, wantRun: true},
+		{name: "confirmed", interactive: true, answer: true, wantRun: true},
+		{name: "declined", interactive: true, answer: false, errContains: "aborted by user"},
+		{name: "prompt error", interactive: true, answerErr: errors.New("EOF"), errContains: "confirmation failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configLoader := new(MockConfigLoader)
+			configLoader.On("Load", "config.yaml").Return(cfg, nil)
+			jobService := new(MockJobService)
+			jobService.On("ExecuteJobsWithHooksContext", cfg.Targets, cfg.Jobs, (*job.Job)(nil), (*job.Job)(nil)).Return(nil)
+
+			var questions []string
+			app := NewAppWithDeps(new(MockEnvLoader), configLoader, jobService)
+			WithInteractive(tt.interactive)(app)
+			app.assumeYes = tt.assumeYes
+			app.confirm = func(question string) (bool, error) {
+				questions = append(questions, question)
+				return tt.answer, tt.answerErr
+			}
+
+			err := app.Run("config.yaml", "", nil, "")
+			if !tt.wantRun {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errContains)
+				jobService.AssertNotCalled(t, "ExecuteJobsWithHooksContext", cfg.Targets, cfg.Jobs, mock.Anything, mock.Anything)
+			} else {
+				assert.NoError(t, err)
+				jobService.AssertCalled(t, "ExecuteJobsWithHooksContext", cfg.Targets, cfg.Jobs, mock.Anything, mock.Anything)
+			}
+
+			if tt.interactive && !tt.assumeYes {
+				assert.Equal(t, []string{"Run jobs [build, deploy] on target 'production'?"}, questions)
+			} else {
+				assert.Empty(t, questions, "no prompt expected")
+			}
+		})
+	}
+}
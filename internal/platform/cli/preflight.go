@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/nickalie/nship/internal/config"
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+	"github.com/nickalie/nship/internal/infrastructure/ssh"
+)
+
+// preflight runs the checks that must pass before any job starts, so that a deployment does not fail
+// halfway through for reasons that can be detected up front. All failed checks are reported together.
+func (a *App) preflight(ctx context.Context, cfg *config.Config, jobs []*job.Job) error {
+	if a.skipPreflight {
+		return nil
+	}
+
+	errs := []error{checkLocalSources(jobs, cfg.BeforeAll, cfg.AfterAll)}
+	if a.checkConnections {
+		errs = append(errs, checkConnections(ctx, a.preflightClientFactory(), cfg.Targets))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("preflight checks failed:\n%w", err)
+	}
+	return nil
+}
+
+// preflightClientFactory returns the factory of the clients used to check the connections to targets
+func (a *App) preflightClientFactory() job.ClientFactory {
+	if a.clientFactory != nil {
+		return a.clientFactory
+	}
+	return ssh.NewClientFactory(a.clientOptions...)
+}
+
+// checkConnections connects to all targets at once and closes the connections again, returning an error
+// listing the targets that could not be reached. Connecting is given up once ctx is done.
+func checkConnections(ctx context.Context, factory job.ClientFactory, targets []*target.Target) error {
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, tgt := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, err := job.NewClientContext(ctx, factory, tgt)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			client.Close()
+		}()
+	}
+	wg.Wait()
+
+	var unreachable []string
+	for i, err := range errs {
+		if err != nil {
+			unreachable = append(unreachable, fmt.Sprintf("%s: %v", targets[i].GetName(), connectionCause(err)))
+		}
+	}
+
+	if len(unreachable) > 0 {
+		return fmt.Errorf("unreachable targets:\n  %s", strings.Join(unreachable, "\n  "))
+	}
+	return nil
+}
+
+// connectionCause returns the cause of a connection error, which names its target, or err itself
+func connectionCause(err error) error {
+	var connErr *job.ConnectionError
+	if errors.As(err, &connErr) {
+		return connErr.Cause
+	}
+	return err
+}
+
+// checkLocalSources returns an error listing the local files and directories read by the steps of jobs,
+// their hooks and the given hooks that don't exist. Nil hooks are ignored.
+func checkLocalSources(jobs []*job.Job, hooks ...*job.Job) error {
+	steps, err := allSteps(jobs, hooks)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, step := range steps {
+		for _, path := range localSources(step) {
+			if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+				missing = append(missing, path)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("local sources not found:\n  %s", strings.Join(missing, "\n  "))
+	}
+	return nil
+}
+
+// allSteps returns the steps of jobs with their matrices expanded, including their hooks, and the
+// steps of the given hooks
+func allSteps(jobs, hooks []*job.Job) ([]*job.Step, error) {
+	expanded, err := job.ExpandMatrix(jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []*job.Step
+	for _, j := range expanded {
+		steps = append(steps, j.BeforeJob...)
+		steps = append(steps, j.Steps...)
+		steps = append(steps, j.AfterJob...)
+		steps = append(steps, j.OnFailure...)
+	}
+	for _, hook := range hooks {
+		if hook != nil {
+			steps = append(steps, hook.Steps...)
+		}
+	}
+	return steps, nil
+}
+
+// localSources returns the local paths a step reads
+func localSources(step *job.Step) []string {
+	var paths []string
+	if step.Copy != nil {
+		paths = append(paths, step.Copy.Local)
+	}
+	if step.ScriptFile != "" {
+		paths = append(paths, step.ScriptFile)
+	}
+	if step.RunScript != nil {
+		paths = append(paths, step.RunScript.Path)
+	}
+	return paths
+}
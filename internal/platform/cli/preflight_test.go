@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/nickalie/nship/internal/config"
+	"github.com/nickalie/nship/internal/core/job"
+	"github.com/nickalie/nship/internal/core/target"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCheckLocalSources(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "app.tar")
+	assert.NoError(t, os.WriteFile(existing, nil, 0600))
+
+	jobs := []*job.Job{{
+		Name:      "deploy",
+		Steps:     []*job.Step{{Copy: &job.CopyStep{Local: existing, Remote: "/opt/app.tar"}}, {Run: "make"}},
+		AfterJob:  []*job.Step{{ScriptFile: filepath.Join(dir, "verify.sh")}},
+		OnFailure: []*job.Step{{RunScript: &job.RunScriptStep{Path: filepath.Join(dir, "rollback.sh")}}},
+	}, {
+		Name:   "assets",
+		Matrix: map[string][]string{"tier": {"web", "api"}},
+		Steps:  []*job.Step{{Copy: &job.CopyStep{Local: filepath.Join(dir, "${matrix.tier}"), Remote: "/srv"}}},
+	}}
+	afterAll := &job.Job{Steps: []*job.Step{{ScriptFile: filepath.Join(dir, "cleanup.sh")}}}
+
+	err := checkLocalSources(jobs, nil, afterAll)
+	assert.EqualError(t, err, "local sources not found:\n  "+
+		filepath.Join(dir, "verify.sh")+"\n  "+
+		filepath.Join(dir, "rollback.sh")+"\n  "+
+		filepath.Join(dir, "web")+"\n  "+
+		filepath.Join(dir, "api")+"\n  "+
+		filepath.Join(dir, "cleanup.sh"))
+
+	assert.NoError(t, checkLocalSources(nil))
+}
+
+func TestApp_RunPreflight(t *testing.T) {
+	cfg := &config.Config{
+		Targets: []*target.Target{{Name: "web", Host: "example.com", User: "deploy"}},
+		Jobs:    []*job.Job{{Name: "deploy", Steps: []*job.Step{{Copy: &job.CopyStep{Local: "missing.tar", Remote: "/opt"}}}}},
+	}
+
+	mockEnvLoader := new(MockEnvLoader)
+	mockConfigLoader := new(MockConfigLoader)
+	mockConfigLoader.On("Load", "nship.yaml").Return(cfg, nil)
+	mockJobService := new(MockJobService)
+
+	app := NewAppWithDeps(mockEnvLoader, mockConfigLoader, mockJobService)
+	err := app.Run("nship.yaml", "", nil, "")
+	assert.ErrorContains(t, err, "preflight checks failed:\nlocal sources not found:\n  missing.tar")
+	mockJobService.AssertNotCalled(t, "ExecuteJobsWithHooksContext", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	mockJobService.On("ExecuteJobsWithHooksContext", cfg.Targets, cfg.Jobs, (*job.Job)(nil), (*job.Job)(nil)).Return(nil)
+	WithPreflight(false)(app)
+	assert.NoError(t, app.Run("nship.yaml", "", nil, ""))
+}
+
+// preflightClient is a client that records whether it was closed
+type preflightClient struct {
+	closed bool
+}
+
+func (c *preflightClient) ExecuteStep(*job.Step, int, int) error { return nil }
+func (c *preflightClient) Close()                                { c.closed = true }
+
+// preflightClientFactory creates preflightClients, failing for the targets in unreachable
+type preflightClientFactory struct {
+	unreachable map[string]bool
+	mu          sync.Mutex
+	clients     []*preflightClient
+}
+
+func (f *preflightClientFactory) NewClient(tgt *target.Target) (job.Client, error) {
+	if f.unreachable[tgt.GetName()] {
+		return nil, &job.ConnectionError{Target: tgt.GetName(), Cause: errors.New("connection refused")}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	client := &preflightClient{}
+	f.clients = append(f.clients, client)
+	return client, nil
+}
+
+func TestCheckConnections(t *testing.T) {
+	targets := []*target.Target{{Name: "web1"}, {Name: "web2"}, {Name: "web3"}}
+
+	factory := &preflightClientFactory{}
+	assert.NoError(t, checkConnections(context.Background(), factory, targets))
+	assert.Len(t, factory.clients, 3)
+	for _, client := range factory.clients {
+		assert.True(t, client.closed, "connections should be closed again")
+	}
+
+	factory = &preflightClientFactory{unreachable: map[string]bool{"web1": true, "web3": true}}
+	err := checkConnections(context.Background(), factory, targets)
+	assert.EqualError(t, err, "unreachable targets:\n"+
+		"  web1: connection refused\n"+
+		"  web3: connection refused")
+}
+
+func TestApp_RunConnectionCheck(t *testing.T) {
+	cfg := &config.Config{
+		Targets: []*target.Target{{Name: "web1"}, {Name: "web2"}},
+		Jobs:    []*job.Job{{Name: "deploy", Steps: []*job.Step{{Copy: &job.CopyStep{Local: "missing.tar", Remote: "/opt"}}}}},
+	}
+
+	mockConfigLoader := new(MockConfigLoader)
+	mockConfigLoader.On("Load", "nship.yaml").Return(cfg, nil)
+	mockJobService := new(MockJobService)
+
+	app := NewAppWithDeps(new(MockEnvLoader), mockConfigLoader, mockJobService)
+	WithConnectionCheck(true)(app)
+	app.clientFactory = &preflightClientFactory{unreachable: map[string]bool{"web2": true}}
+
+	err := app.Run("nship.yaml", "", nil, "")
+	assert.ErrorContains(t, err, "local sources not found:\n  missing.tar\nunreachable targets:\n  web2: ", "all failed checks should be reported")
+	mockJobService.AssertNotCalled(t, "ExecuteJobsWithHooksContext", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,62 @@
+package util
+
+import (
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/term"
+)
+
+// ANSI escape codes of the status colors
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+)
+
+// colorStdout and colorStderr report whether status messages written to stdout and stderr are colorized
+var colorStdout, colorStderr atomic.Bool
+
+// SetColor enables or disables colorized status messages on stdout and stderr
+func SetColor(stdout, stderr bool) {
+	colorStdout.Store(stdout)
+	colorStderr.Store(stderr)
+}
+
+// ColorSupported reports whether status messages written to f should be colorized: f is a terminal,
+// and neither noColor nor the NO_COLOR environment variable is set
+func ColorSupported(noColor bool, f *os.File) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return term.IsTerminal(int(f.Fd())) //nolint:unconvert //int is required for Windows compatibility
+}
+
+// Success colors text of a successful status written to stdout green when colors are enabled on stdout
+func Success(text string) string {
+	return colorize(&colorStdout, colorGreen, text)
+}
+
+// Failure colors text of a failed status written to stdout red when colors are enabled on stdout
+func Failure(text string) string {
+	return colorize(&colorStdout, colorRed, text)
+}
+
+// Skipped colors text of a skipped status written to stdout yellow when colors are enabled on stdout
+func Skipped(text string) string {
+	return colorize(&colorStdout, colorYellow, text)
+}
+
+// StderrFailure colors text of a failed status written to stderr red when colors are enabled on stderr
+func StderrFailure(text string) string {
+	return colorize(&colorStderr, colorRed, text)
+}
+
+// colorize wraps text in the given color, leaving it unchanged when colors are disabled on the stream
+func colorize(enabled *atomic.Bool, color, text string) string {
+	if !enabled.Load() {
+		return text
+	}
+	return color + text + colorReset
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestColorize(t *testing.T) {
+	defer SetColor(false, false)
+
+	SetColor(false, false)
+	assert.Equal(t, "done", Success("done"))
+	assert.Equal(t, "failed", Failure("failed"))
+	assert.Equal(t, "skipped", Skipped("skipped"))
+	assert.Equal(t, "error", StderrFailure("error"))
+
+	SetColor(true, false)
+	assert.Equal(t, "\x1b[32mdone\x1b[0m", Success("done"))
+	assert.Equal(t, "\x1b[31mfailed\x1b[0m", Failure("failed"))
+	assert.Equal(t, "\x1b[33mskipped\x1b[0m", Skipped("skipped"))
+	assert.Equal(t, "error", StderrFailure("error"), "colors on stdout should not apply to stderr")
+
+	SetColor(false, true)
+	assert.Equal(t, "done", Success("done"), "colors on stderr should not apply to stdout")
+	assert.Equal(t, "\x1b[31merror\x1b[0m", StderrFailure("error"))
+}
+
+func TestColorSupported(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	assert.False(t, ColorSupported(true, os.Stdout), "--no-color should disable colors")
+
+	t.Setenv("NO_COLOR", "1")
+	assert.False(t, ColorSupported(false, os.Stdout), "NO_COLOR should disable colors")
+
+	t.Setenv("NO_COLOR", "")
+	// Test output is not a terminal
+	file, err := os.CreateTemp(t.TempDir(), "output")
+	require.NoError(t, err)
+	defer file.Close()
+	assert.False(t, ColorSupported(false, file), "colors should be disabled when the stream is not a terminal")
+}
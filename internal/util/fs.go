@@ -3,9 +3,19 @@ package util
 
 import (
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
+// unsafeFileNameChars matches the characters replaced by SafeFileName
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// SafeFileName returns name with all characters other than letters, digits, '.', '_' and '-'
+// replaced by '_', so that it can be used as the name of a file on any system
+func SafeFileName(name string) string {
+	return unsafeFileNameChars.ReplaceAllString(name, "_")
+}
+
 // matchSimpleContains checks if a path contains a pattern without wildcards
 func matchSimpleContains(normalizedPath, normalizedPattern string) bool {
 	return strings.Contains(normalizedPath, normalizedPattern)
@@ -49,17 +59,66 @@ func matchPattern(normalizedPath, normalizedPattern, baseName string) bool {
 // Supports glob patterns:
 // - * matches any number of characters within a path segment
 // - ** matches any number of characters across path segments
+// - a leading ! negates a pattern, re-including paths excluded by earlier patterns
+// - a leading / anchors a pattern, which then only matches from the start of the path and the paths below it
+// Patterns apply in order and the last one matching the path decides, like in .gitignore.
 // Examples:
 // - "**/.idea/**" matches both "foo/.idea/bar/bla" and ".idea/bla/foo"
 // - "*.log" matches "file.log" but not "file.log.txt"
+// - "*.log", "!keep.log" excludes "debug.log" but not "keep.log"
 func IsExcluded(path string, exclude []string) bool {
 	// Normalize path separators to forward slash for consistency
 	normalizedPath := filepath.ToSlash(path)
 	baseName := filepath.Base(normalizedPath)
 
+	excluded := false
+	for _, pattern := range exclude {
+		negated := strings.HasPrefix(pattern, "!")
+		normalizedPattern := filepath.ToSlash(strings.TrimPrefix(pattern, "!"))
+		if excluded != negated || normalizedPattern == "" {
+			// The pattern can't change the outcome
+			continue
+		}
+		if matchExcludePattern(normalizedPath, normalizedPattern, baseName) {
+			excluded = !negated
+		}
+	}
+	return excluded
+}
+
+// matchExcludePattern checks if a single pattern matches a path, anchoring patterns starting with /
+func matchExcludePattern(normalizedPath, normalizedPattern, baseName string) bool {
+	if !strings.HasPrefix(normalizedPattern, "/") {
+		return matchPattern(normalizedPath, normalizedPattern, baseName)
+	}
+	return matchAnchoredPattern(anchoredPath(normalizedPath), normalizedPattern)
+}
+
+// matchAnchoredPattern checks if an anchored pattern matches a path or one of its parent directories
+func matchAnchoredPattern(path, pattern string) bool {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return path == pattern || strings.HasPrefix(path, pattern+"/")
+	}
+	if strings.Contains(pattern, "**") {
+		return matchGlobPattern(path, pattern)
+	}
+	return matchFullPathGlob(path, pattern)
+}
+
+// anchoredPath returns p as anchored patterns are matched against: cleaned, with forward slashes
+// and a single leading slash
+func anchoredPath(p string) string {
+	p = filepath.ToSlash(filepath.Clean(p))
+	if p == "." {
+		return "/"
+	}
+	return "/" + strings.TrimPrefix(p, "/")
+}
+
+// HasNegatedPattern reports whether any exclude pattern starts with !, making the order of the patterns significant
+func HasNegatedPattern(exclude []string) bool {
 	for _, pattern := range exclude {
-		normalizedPattern := filepath.ToSlash(pattern)
-		if matchPattern(normalizedPath, normalizedPattern, baseName) {
+		if strings.HasPrefix(pattern, "!") {
 			return true
 		}
 	}
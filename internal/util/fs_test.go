@@ -169,3 +169,89 @@ func TestIsExcluded_GlobPatterns(t *testing.T) {
 		})
 	}
 }
+
+func TestIsExcluded_Negation(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		patterns    []string
+		shouldMatch bool
+		description string
+	}{
+		{
+			name:        "negation re-includes a file",
+			path:        "logs/keep.log",
+			patterns:    []string{"*.log", "!keep.log"},
+			shouldMatch: false,
+			description: "A later negated pattern should re-include the file",
+		},
+		{
+			name:        "negation leaves other files excluded",
+			path:        "logs/debug.log",
+			patterns:    []string{"*.log", "!keep.log"},
+			shouldMatch: true,
+			description: "Files not matching the negated pattern should stay excluded",
+		},
+		{
+			name:        "last match wins",
+			path:        "logs/keep.log",
+			patterns:    []string{"!keep.log", "*.log"},
+			shouldMatch: true,
+			description: "A negated pattern should not re-include files excluded by later patterns",
+		},
+		{
+			name:        "exclude again after negation",
+			path:        "logs/keep.log",
+			patterns:    []string{"*.log", "!*.log", "keep.log"},
+			shouldMatch: true,
+			description: "Patterns should apply in order, each overriding the earlier ones",
+		},
+		{
+			name:        "negation without earlier match",
+			path:        "README.md",
+			patterns:    []string{"!README.md"},
+			shouldMatch: false,
+			description: "A negated pattern alone should exclude nothing",
+		},
+		{
+			name:        "negated double-star pattern",
+			path:        "node_modules/.bin/tool",
+			patterns:    []string{"node_modules/**", "!**/.bin/**"},
+			shouldMatch: false,
+			description: "Negated patterns should support ** like other patterns",
+		},
+		{
+			name:        "negated directory contents",
+			path:        "dist/logs/keep.log",
+			patterns:    []string{"**/logs/**", "!dist/logs/keep.log"},
+			shouldMatch: false,
+			description: "A file inside excluded directory contents should be re-included",
+		},
+		{
+			name:        "empty negation",
+			path:        "logs/debug.log",
+			patterns:    []string{"*.log", "!"},
+			shouldMatch: true,
+			description: "A lone ! should be ignored",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := IsExcluded(tt.path, tt.patterns)
+			assert.Equal(t, tt.shouldMatch, result, tt.description)
+		})
+	}
+}
+
+func TestHasNegatedPattern(t *testing.T) {
+	assert.False(t, HasNegatedPattern(nil))
+	assert.False(t, HasNegatedPattern([]string{"*.log", "node_modules"}))
+	assert.True(t, HasNegatedPattern([]string{"*.log", "!keep.log"}))
+}
+
+func TestSafeFileName(t *testing.T) {
+	assert.Equal(t, "web-1.example.com", SafeFileName("web-1.example.com"))
+	assert.Equal(t, "eu_web_1_", SafeFileName("eu/web 1*"))
+	assert.Equal(t, ".._x", SafeFileName("../x"))
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName is the name of the file in the root of a copied directory that lists
+// gitignore-style patterns of files excluded from the copy
+const IgnoreFileName = ".nshipignore"
+
+// ParseIgnorePatterns returns the exclude patterns listed in the content of an ignore file, one per line.
+// Blank lines and lines starting with # are skipped, and a trailing / is dropped since patterns match
+// directories and files alike.
+func ParseIgnorePatterns(data []byte) []string {
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if trimmed := strings.TrimRight(line, "/"); trimmed != "" {
+			patterns = append(patterns, trimmed)
+		}
+	}
+	return patterns
+}
+
+// MergeIgnoreFile returns exclude followed by the patterns of the ignore file in dir, read with readFile.
+// Patterns starting with / are anchored to dir, so that they only match paths directly below it.
+func MergeIgnoreFile(dir string, exclude []string, readFile func(string) ([]byte, error)) ([]string, error) {
+	path := filepath.Join(dir, IgnoreFileName)
+	data, err := readFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+
+	patterns := ParseIgnorePatterns(data)
+	merged := make([]string, 0, len(exclude)+len(patterns))
+	merged = append(merged, exclude...)
+	return anchorPatterns(dir, append(merged, patterns...)), nil
+}
+
+// anchorPatterns prefixes the patterns starting with / or !/ with dir as IsExcluded matches anchored patterns.
+// A trailing / is dropped like in ParseIgnorePatterns.
+func anchorPatterns(dir string, patterns []string) []string {
+	prefix := strings.TrimSuffix(anchoredPath(dir), "/")
+	for i, pattern := range patterns {
+		negation := ""
+		if strings.HasPrefix(pattern, "!") {
+			negation, pattern = "!", pattern[1:]
+		}
+		if pattern = filepath.ToSlash(pattern); strings.HasPrefix(pattern, "/") {
+			patterns[i] = negation + prefix + "/" + strings.Trim(pattern, "/")
+		}
+	}
+	return patterns
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIgnorePatterns(t *testing.T) {
+	data := []byte("# dependencies\nnode_modules/\n\n  *.log  \r\n**/.idea/**\n/\n")
+	assert.Equal(t, []string{"node_modules", "*.log", "**/.idea/**"}, ParseIgnorePatterns(data))
+	assert.Nil(t, ParseIgnorePatterns(nil))
+}
+
+func TestMergeIgnoreFile(t *testing.T) {
+	dir := t.TempDir()
+
+	exclude, err := MergeIgnoreFile(dir, []string{"*.tmp"}, os.ReadFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*.tmp"}, exclude, "a directory without ignore file should keep the step patterns")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, IgnoreFileName), []byte("*.log\ncache/\n"), 0644))
+	exclude, err = MergeIgnoreFile(dir, []string{"*.tmp"}, os.ReadFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*.tmp", "*.log", "cache"}, exclude)
+	assert.True(t, IsExcluded(filepath.Join(dir, "cache", "data.bin"), exclude))
+
+	_, err = MergeIgnoreFile(dir, nil, func(string) ([]byte, error) { return nil, errors.New("permission denied") })
+	assert.ErrorContains(t, err, "permission denied")
+}
+
+func TestMergeIgnoreFileAnchoredPatterns(t *testing.T) {
+	for _, dir := range []string{t.TempDir(), "app", "."} {
+		data := []byte("/build\n/*.log\n!/debug.log\n")
+		exclude, err := MergeIgnoreFile(dir, []string{"/tmp/"}, func(string) ([]byte, error) { return data, nil })
+		require.NoError(t, err)
+
+		assert.True(t, IsExcluded(filepath.Join(dir, "build"), exclude), "anchored patterns should match below %s", dir)
+		assert.True(t, IsExcluded(filepath.Join(dir, "build", "app.js"), exclude))
+		assert.True(t, IsExcluded(filepath.Join(dir, "tmp"), exclude), "step patterns should be anchored too")
+		assert.True(t, IsExcluded(filepath.Join(dir, "error.log"), exclude))
+		assert.False(t, IsExcluded(filepath.Join(dir, "debug.log"), exclude), "anchored negations should re-include")
+		assert.False(t, IsExcluded(filepath.Join(dir, "src", "build"), exclude), "anchored patterns should not match in subdirectories")
+		assert.False(t, IsExcluded(filepath.Join(dir, "src", "error.log"), exclude))
+		assert.False(t, IsExcluded(filepath.Join(dir, "buildx"), exclude))
+	}
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// stdinReader reads the answers to prompts from stdinFile. It is shared by all prompts, so input
+// buffered while reading one answer is not lost for the next.
+var (
+	stdinMu     sync.Mutex
+	stdinFile   *os.File
+	stdinReader *bufio.Reader
+)
+
+// stdin returns the reader of the answers to prompts, which is only replaced when os.Stdin is
+func stdin() *bufio.Reader {
+	stdinMu.Lock()
+	defer stdinMu.Unlock()
+	if stdinReader == nil || stdinFile != os.Stdin {
+		stdinFile, stdinReader = os.Stdin, bufio.NewReader(os.Stdin)
+	}
+	return stdinReader
+}
+
+// PromptSecret prints prompt and reads a line from stdin, hiding the input when stdin is a terminal.
+func PromptSecret(prompt string) (string, error) {
+	fmt.Print(prompt)
+
+	secret, err := term.ReadPassword(int(syscall.Stdin)) //nolint:unconvert //int is required for Windows compatibility
+	if err == nil {
+		fmt.Println() // Add newline after hidden input
+		return string(secret), nil
+	}
+
+	// Final fallback: Regular input (with warning)
+	fmt.Println("\nWarning: Unable to hide input. Input will be visible.")
+	return readLine(stdin())
+}
+
+// PromptLine prints prompt and reads a line from stdin.
+func PromptLine(prompt string) (string, error) {
+	fmt.Print(prompt)
+	return readLine(stdin())
+}
+
+// Confirm asks a yes/no question on stdin and reports whether the answer was yes.
+func Confirm(question string) (bool, error) {
+	answer, err := PromptLine(question + " [y/N]: ")
+	if err != nil {
+		return false, err
+	}
+	return IsYes(answer), nil
+}
+
+// IsYes reports whether answer is an affirmative reply such as "y" or "yes".
+func IsYes(answer string) bool {
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// readLine reads a single line from r without the trailing newline
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("failed to read input: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
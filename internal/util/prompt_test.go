@@ -0,0 +1,36 @@
+package util
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsYes(t *testing.T) {
+	for _, answer := range []string{"y", "Y", "yes", " YES \n"} {
+		assert.True(t, IsYes(answer), "expected %q to be yes", answer)
+	}
+	for _, answer := range []string{"", "n", "no", "yep"} {
+		assert.False(t, IsYes(answer), "expected %q to be no", answer)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("yes\nsecond\n"))
+	line, err := readLine(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "yes", line)
+
+	line, err = readLine(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", line, "lines buffered by an earlier read should not be lost")
+
+	line, err = readLine(bufio.NewReader(strings.NewReader("no newline")))
+	assert.NoError(t, err)
+	assert.Equal(t, "no newline", line)
+
+	_, err = readLine(bufio.NewReader(strings.NewReader("")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package util
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RedactedOutput replaces secret values in command output
+const RedactedOutput = "***"
+
+// minSecretLength is the length below which values are not redacted, as masking every
+// occurrence of a few characters would make the output unreadable
+const minSecretLength = 4
+
+// SecretRegistry holds secret values, such as passwords, that are masked in command output.
+// A nil registry holds no secrets and ignores those added to it.
+type SecretRegistry struct {
+	mu       sync.RWMutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}
+
+// NewSecretRegistry creates an empty SecretRegistry
+func NewSecretRegistry() *SecretRegistry {
+	return &SecretRegistry{values: make(map[string]bool)}
+}
+
+// Add registers secret values. Values shorter than four characters are ignored.
+func (r *SecretRegistry) Add(values ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, value := range values {
+		if len(value) >= minSecretLength {
+			r.values[value] = true
+		}
+	}
+	r.replacer = nil
+}
+
+// Contains reports whether value is a registered secret
+func (r *SecretRegistry) Contains(value string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.values[value]
+}
+
+// Redact returns text with every registered secret replaced by RedactedOutput
+func (r *SecretRegistry) Redact(text string) string {
+	if r == nil {
+		return text
+	}
+	r.mu.RLock()
+	replacer := r.replacer
+	r.mu.RUnlock()
+
+	if replacer == nil {
+		replacer = r.buildReplacer()
+	}
+	return replacer.Replace(text)
+}
+
+// buildReplacer creates the replacer for the registered secrets. Longer secrets come first,
+// so that a secret containing another one is masked as a whole.
+func (r *SecretRegistry) buildReplacer() *strings.Replacer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.replacer != nil {
+		return r.replacer
+	}
+
+	values := make([]string, 0, len(r.values))
+	for value := range r.values {
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+
+	pairs := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		pairs = append(pairs, value, RedactedOutput)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+	return r.replacer
+}
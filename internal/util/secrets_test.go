@@ -0,0 +1,30 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretRegistry(t *testing.T) {
+	registry := NewSecretRegistry()
+	assert.Equal(t, "login failed for s3cr3t", registry.Redact("login failed for s3cr3t"))
+
+	registry.Add("s3cr3t", "s3cr3t-token", "abc", "")
+	assert.Equal(t, "login failed for ***", registry.Redact("login failed for s3cr3t"))
+	assert.Equal(t, "token=*** password=***", registry.Redact("token=s3cr3t-token password=s3cr3t"))
+	assert.Equal(t, "abc", registry.Redact("abc"), "short values should not be redacted")
+
+	registry.Add("hunter2")
+	assert.Equal(t, "*** ***", registry.Redact("hunter2 s3cr3t"), "secrets added later should be redacted too")
+
+	assert.True(t, registry.Contains("hunter2"))
+	assert.False(t, registry.Contains("hunter"), "only whole secrets are contained")
+	assert.False(t, registry.Contains("abc"))
+}
+
+func TestNilSecretRegistry(t *testing.T) {
+	var registry *SecretRegistry
+	assert.Equal(t, "login failed for s3cr3t", registry.Redact("login failed for s3cr3t"))
+	assert.False(t, registry.Contains("s3cr3t"))
+}
@@ -0,0 +1,14 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellQuote(t *testing.T) {
+	assert.Equal(t, "'plain'", ShellQuote("plain"))
+	assert.Equal(t, "'with space'", ShellQuote("with space"))
+	assert.Equal(t, `'it'\''s'`, ShellQuote("it's"))
+	assert.Equal(t, "'$HOME `date`'", ShellQuote("$HOME `date`"))
+}
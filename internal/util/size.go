@@ -0,0 +1,37 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeMultipliers maps size suffixes to their multiplier in bytes
+var sizeMultipliers = map[string]int64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+}
+
+// ParseSize parses a byte count with an optional binary suffix, e.g. "512", "64K", "10M" or "1G".
+// Suffixes are case-insensitive and may be followed by "B", as in "10MB".
+func ParseSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(s, "B")
+
+	suffix := ""
+	if s != "" {
+		if _, ok := sizeMultipliers[s[len(s)-1:]]; ok {
+			suffix = s[len(s)-1:]
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", value)
+	}
+
+	return int64(n * float64(sizeMultipliers[suffix])), nil
+}
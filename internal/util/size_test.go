@@ -0,0 +1,37 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value     string
+		expected  int64
+		expectErr bool
+	}{
+		{value: "512", expected: 512},
+		{value: "64K", expected: 64 * 1024},
+		{value: "10M", expected: 10 * 1024 * 1024},
+		{value: "10mb", expected: 10 * 1024 * 1024},
+		{value: "1G", expected: 1024 * 1024 * 1024},
+		{value: "1.5K", expected: 1536},
+		{value: "", expectErr: true},
+		{value: "fast", expectErr: true},
+		{value: "-1M", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			size, err := ParseSize(tt.value)
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, size)
+		})
+	}
+}
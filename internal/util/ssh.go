@@ -0,0 +1,28 @@
+package util
+
+import (
+	"slices"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshHostKeyAlgorithms lists the host key algorithms implemented by crypto/ssh
+var sshHostKeyAlgorithms = []string{
+	ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01,
+	ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01, ssh.CertAlgoED25519v01,
+	ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSA, ssh.KeyAlgoDSA, ssh.KeyAlgoED25519,
+}
+
+// SupportedSSHAlgorithms returns cfg with the algorithms crypto/ssh does not implement removed
+func SupportedSSHAlgorithms(cfg ssh.Config) ssh.Config {
+	cfg.SetDefaults()
+	return cfg
+}
+
+// SupportedSSHHostKeyAlgorithms returns the host key algorithms of algorithms that crypto/ssh implements
+func SupportedSSHHostKeyAlgorithms(algorithms []string) []string {
+	return slices.DeleteFunc(slices.Clone(algorithms), func(algorithm string) bool {
+		return !slices.Contains(sshHostKeyAlgorithms, algorithm)
+	})
+}
@@ -0,0 +1,20 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSupportedSSHAlgorithms(t *testing.T) {
+	cfg := SupportedSSHAlgorithms(ssh.Config{Ciphers: []string{"aes128-cbc", "twofish-cbc"}, MACs: []string{"umac-64@openssh.com"}})
+	assert.Equal(t, []string{"aes128-cbc"}, cfg.Ciphers)
+	assert.Empty(t, cfg.MACs)
+}
+
+func TestSupportedSSHHostKeyAlgorithms(t *testing.T) {
+	offered := []string{"ssh-rsa", "x509v3-sign-rsa", "ssh-ed25519"}
+	assert.Equal(t, []string{"ssh-rsa", "ssh-ed25519"}, SupportedSSHHostKeyAlgorithms(offered))
+	assert.Len(t, offered, 3, "the algorithms should be left untouched")
+}
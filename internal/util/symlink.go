@@ -0,0 +1,34 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// IsCyclicLink reports whether following the symlink at path leads back into one of the directories
+// containing it, which would make a recursive walk never end. target is the information of the
+// directory the link resolves to and stat resolves the parent directories, following symlinks.
+func IsCyclicLink(path string, target os.FileInfo, stat func(string) (os.FileInfo, error)) (bool, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false, fmt.Errorf("resolve absolute path: %w", err)
+	}
+
+	dir := filepath.Dir(absPath)
+	for {
+		info, err := stat(dir)
+		if err != nil {
+			return false, fmt.Errorf("stat parent directory: %w", err)
+		}
+		if os.SameFile(info, target) {
+			return true, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false, nil
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,28 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsCyclicLink(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a", "b"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "shared"), 0755))
+	require.NoError(t, os.Symlink("..", filepath.Join(root, "a", "b", "up")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "shared"), filepath.Join(root, "a", "b", "shared")))
+
+	for name, want := range map[string]bool{"up": true, "shared": false} {
+		path := filepath.Join(root, "a", "b", name)
+		target, err := os.Stat(path)
+		require.NoError(t, err)
+
+		cyclic, err := IsCyclicLink(path, target, os.Stat)
+		assert.NoError(t, err)
+		assert.Equal(t, want, cyclic, name)
+	}
+}
@@ -6,6 +6,7 @@
 package nship
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/nickalie/nship/internal/config"
@@ -14,6 +15,7 @@ import (
 	"github.com/nickalie/nship/internal/infrastructure/fs"
 	"github.com/nickalie/nship/internal/infrastructure/ssh"
 	"github.com/nickalie/nship/internal/platform/cli"
+	"github.com/nickalie/nship/internal/util"
 )
 
 // Target represents a deployment target
@@ -28,9 +30,40 @@ type Step = job.Step
 // DockerStep represents a Docker container configuration
 type DockerStep = job.DockerStep
 
+// DockerPruneStep represents a removal of unused Docker images
+type DockerPruneStep = job.DockerPruneStep
+
+// DockerExecStep represents a command run in a running Docker container
+type DockerExecStep = job.DockerExecStep
+
+// ServiceStep represents an action on a system service
+type ServiceStep = job.ServiceStep
+
+// AssertStep represents a check of the exit code and output of a command
+type AssertStep = job.AssertStep
+
 // CopyStep represents a file copy operation
 type CopyStep = job.CopyStep
 
+// DownloadStep represents a file download from a target
+type DownloadStep = job.DownloadStep
+
+// ExecStep represents a local command execution
+type ExecStep = job.ExecStep
+
+// StepResult describes how a step of a job was handled on a target
+type StepResult = job.StepResult
+
+// StepStatus is the outcome of a step
+type StepStatus = job.StepStatus
+
+// Step statuses reported in step results
+const (
+	StepSucceeded = job.StepSucceeded
+	StepFailed    = job.StepFailed
+	StepSkipped   = job.StepSkipped
+)
+
 // Config represents a deployment configuration
 type Config = config.Config
 
@@ -40,6 +73,97 @@ type HashStorage = job.HashStorage
 // Builder represents a configuration builder
 type Builder = config.Builder
 
+// FileSystem provides read access to local files used by copy steps
+type FileSystem = job.FileSystem
+
+// Client executes deployment steps on a target
+type Client = job.Client
+
+// ClientFactory creates clients for deployment targets
+type ClientFactory = job.ClientFactory
+
+// RunOption configures the execution of RunConfigWith
+type RunOption func(*runOptions)
+
+// runOptions holds the dependencies used to execute a configuration
+type runOptions struct {
+	clientFactory  ClientFactory
+	serviceOptions []job.ServiceOption
+}
+
+// WithFileSystem sets the file system used to read copy step sources when hashing steps
+func WithFileSystem(fsys FileSystem) RunOption {
+	return func(o *runOptions) {
+		o.serviceOptions = append(o.serviceOptions, job.WithFileSystem(fsys))
+	}
+}
+
+// WithClientFactory sets the factory creating clients for targets, replacing SSH
+func WithClientFactory(factory ClientFactory) RunOption {
+	return func(o *runOptions) {
+		o.clientFactory = factory
+	}
+}
+
+// WithHashStorage sets the storage for step hashes
+func WithHashStorage(storage HashStorage) RunOption {
+	return func(o *runOptions) {
+		o.serviceOptions = append(o.serviceOptions, job.WithHashStorage(storage))
+	}
+}
+
+// WithSkipUnchanged sets whether unchanged steps should be skipped
+func WithSkipUnchanged(skip bool) RunOption {
+	return func(o *runOptions) {
+		o.serviceOptions = append(o.serviceOptions, job.WithSkipUnchanged(skip))
+	}
+}
+
+// StepOption configures a step added by the builder
+type StepOption = config.StepOption
+
+// WithStepShell sets the shell running the commands of the step
+func WithStepShell(shell string) StepOption {
+	return config.WithStepShell(shell)
+}
+
+// WithStepEnv sets the environment variables of the commands of the step. It only applies
+// to run, script_file and run_script steps, Build rejects it on others.
+func WithStepEnv(env map[string]string) StepOption {
+	return config.WithStepEnv(env)
+}
+
+// WithStepWorkdir sets the remote directory the commands of the step run in. It only applies
+// to run, script_file and run_script steps, Build rejects it on others.
+func WithStepWorkdir(dir string) StepOption {
+	return config.WithStepWorkdir(dir)
+}
+
+// WithStepSudo runs the commands of the step as root through sudo
+func WithStepSudo() StepOption {
+	return config.WithStepSudo()
+}
+
+// WithStepRunOnce runs the step on the first target of the job only
+func WithStepRunOnce() StepOption {
+	return config.WithStepRunOnce()
+}
+
+// WithStepFailFast sets whether the run command of the step stops at its first failing line
+func WithStepFailFast(enabled bool) StepOption {
+	return config.WithStepFailFast(enabled)
+}
+
+// WithStepWhen sets the condition the step only runs under, e.g. target.name == "prod"
+func WithStepWhen(condition string) StepOption {
+	return config.WithStepWhen(condition)
+}
+
+// WithExclude sets the patterns of files excluded from a copy step
+func WithExclude(patterns ...string) StepOption {
+	return config.WithExclude(patterns...)
+}
+
 // NewBuilder creates a new configuration builder
 func NewBuilder() *Builder {
 	return config.NewBuilder()
@@ -50,6 +174,12 @@ func NewFileHashStorage() HashStorage {
 	return fs.NewFileHashStorage()
 }
 
+// DockerCommands returns the shell commands a docker step runs on its target, without running them.
+// With the on-change recreate policy, these are the commands run when the container has to be recreated.
+func DockerCommands(step *DockerStep) []string {
+	return ssh.NewDockerCommandBuilder(step).BuildCommands()
+}
+
 // Run executes a deployment with the specified parameters (with default behavior)
 func Run(configPath, jobName string, envPaths []string, vaultPassword string) error {
 	return cli.Run(configPath, jobName, envPaths, vaultPassword)
@@ -69,51 +199,66 @@ func LoadConfig(configPath string) (*Config, error) {
 
 // RunConfigWithOptions executes the deployment with options for skipping unchanged steps
 func RunConfigWithOptions(cfg *Config, jobName string, skipUnchanged bool, hashStorage HashStorage) error {
-	return runConfigInternal(cfg, jobName, skipUnchanged, hashStorage)
+	opts := []RunOption{WithSkipUnchanged(skipUnchanged)}
+	if hashStorage != nil {
+		opts = append(opts, WithHashStorage(hashStorage))
+	}
+	return RunConfigWith(cfg, jobName, opts...)
 }
 
 // RunConfig executes the deployment (runs all steps regardless of change status)
 func RunConfig(cfg *Config, jobName string) error {
-	// Call internal implementation with skipUnchanged=false and no hash storage
-	return runConfigInternal(cfg, jobName, false, nil)
-}
-
-// runConfigInternal is the internal implementation of RunConfig and RunConfigWithOptions
-func runConfigInternal(cfg *Config, jobName string, skipUnchanged bool, hashStorage HashStorage) error {
-	var jobs []*job.Job
-	var err error
-
-	// Filter jobs by name if specified
-	if jobName != "" {
-		for _, j := range cfg.Jobs {
-			if j.Name == jobName {
-				jobs = []*job.Job{j}
-				break
-			}
-		}
-		if jobs == nil {
-			return fmt.Errorf("job '%s' not found", jobName)
-		}
-	} else {
-		jobs = cfg.Jobs
-	}
+	return RunConfigWith(cfg, jobName)
+}
 
-	clientFactory := ssh.NewClientFactory()
+// RunConfigWith executes the deployment using the given options. By default steps are
+// executed over SSH, copy sources are read from the local file system and no steps are skipped.
+func RunConfigWith(cfg *Config, jobName string, opts ...RunOption) error {
+	_, err := RunConfigWithResults(cfg, jobName, opts...)
+	return err
+}
 
-	// Create service with options including the filesystem for proper CopyStep hashing
-	serviceOptions := []job.ServiceOption{job.WithSkipUnchanged(skipUnchanged)}
+// RunConfigWithResults executes the deployment like RunConfigWith and also returns the results
+// of the job steps, including the steps handled before a failure.
+func RunConfigWithResults(cfg *Config, jobName string, opts ...RunOption) ([]StepResult, error) {
+	jobs, err := selectJobs(cfg, jobName)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ApplyDefaultPort(0)
 
-	// Add hash storage if provided
-	if hashStorage != nil {
-		serviceOptions = append(serviceOptions, job.WithHashStorage(hashStorage))
+	options := &runOptions{serviceOptions: []job.ServiceOption{job.WithNamespace(cfg.Project)}}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	if options.clientFactory == nil {
+		secrets := util.NewSecretRegistry()
+		secrets.Add(cfg.Secrets()...)
+		options.clientFactory = ssh.NewClientFactory(ssh.WithSecrets(secrets))
 	}
 
-	jobService := job.NewService(clientFactory, serviceOptions...)
+	jobService := job.NewService(options.clientFactory, options.serviceOptions...)
 
-	err = jobService.ExecuteJobs(cfg.Targets, jobs)
+	results, err := jobService.ExecuteJobsWithResults(context.Background(), cfg.Targets, jobs, cfg.BeforeAll, cfg.AfterAll)
 	if err != nil {
-		return fmt.Errorf("job execution failed: %w", err)
+		return results, fmt.Errorf("job execution failed: %w", err)
+	}
+
+	return results, nil
+}
+
+// selectJobs returns the job with the given name, or all jobs if jobName is empty
+func selectJobs(cfg *Config, jobName string) ([]*job.Job, error) {
+	if jobName == "" {
+		return cfg.Jobs, nil
+	}
+
+	for _, j := range cfg.Jobs {
+		if j.Name == jobName {
+			return []*job.Job{j}, nil
+		}
 	}
 
-	return nil
+	return nil, fmt.Errorf("job '%s' not found", jobName)
 }
@@ -1,7 +1,11 @@
 package nship
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/nickalie/nship/internal/core/job"
 	"github.com/nickalie/nship/internal/core/target"
@@ -105,6 +109,20 @@ func TestTypeAliases(t *testing.T) {
 	assert.NotNil(t, apiConfig, "Config should not be nil")
 }
 
+func TestDockerCommands(t *testing.T) {
+	commands := DockerCommands(&DockerStep{
+		Image: "nginx:latest",
+		Name:  "web",
+		Ports: []string{"80:80"},
+	})
+
+	assert.Equal(t, []string{
+		"docker rm -f web 2>/dev/null || true",
+		"docker create --name web -p 80:80 nginx:latest",
+		"docker start web",
+	}, commands)
+}
+
 func TestLoadConfig(t *testing.T) {
 	// This is a basic test to ensure LoadConfig doesn't panic
 	// A full test would require creating a test config file
@@ -117,6 +135,31 @@ func TestLoadConfig(t *testing.T) {
 	assert.Nil(t, cfg, "Expected nil config when loading fails")
 }
 
+func TestLoadConfigFormats(t *testing.T) {
+	configs := map[string]string{
+		"nship.yaml": "targets:\n  - host: example.com\n    user: deploy\n    password: secret\n" +
+			"jobs:\n  - name: deploy\n    steps:\n      - run: make install\n",
+		"nship.json": `{"targets": [{"host": "example.com", "user": "deploy", "password": "secret"}],` +
+			` "jobs": [{"name": "deploy", "steps": [{"run": "make install"}]}]}`,
+		"nship.toml": "[[targets]]\nhost = \"example.com\"\nuser = \"deploy\"\npassword = \"secret\"\n\n" +
+			"[[jobs]]\nname = \"deploy\"\n\n[[jobs.steps]]\nrun = \"make install\"\n",
+	}
+
+	for name, content := range configs {
+		t.Run(name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), name)
+			assert.NoError(t, os.WriteFile(configPath, []byte(content), 0600))
+
+			cfg, err := LoadConfig(configPath)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, "example.com", cfg.Targets[0].Host)
+			assert.Equal(t, "make install", cfg.Jobs[0].Steps[0].Run)
+		})
+	}
+}
+
 func TestRun(t *testing.T) {
 	// This test verifies that the Run function calls cli.Run without errors
 	// We can't fully test the behavior, but we can ensure it doesn't panic
@@ -163,3 +206,131 @@ func TestRunConfig(t *testing.T) {
 	assert.Error(t, err, "Expected 'job not found' error")
 	assert.Contains(t, err.Error(), "not found", "Error should indicate job not found")
 }
+
+// fakeClient records executed steps instead of connecting to a target
+type fakeClient struct {
+	executed *[]string
+}
+
+func (c *fakeClient) ExecuteStep(step *Step, stepNum, totalSteps int) error {
+	if step.Copy != nil {
+		*c.executed = append(*c.executed, "copy "+step.Copy.Local)
+	} else {
+		*c.executed = append(*c.executed, step.Run)
+	}
+	return nil
+}
+
+func (c *fakeClient) Close() {}
+
+type fakeClientFactory struct {
+	executed []string
+}
+
+func (f *fakeClientFactory) NewClient(tgt *Target) (Client, error) {
+	return &fakeClient{executed: &f.executed}, nil
+}
+
+// fakeFileSystem serves a single file for copy step hashing
+type fakeFileSystem struct {
+	size int64
+}
+
+func (f *fakeFileSystem) Stat(path string) (os.FileInfo, error) {
+	return fakeFileInfo{size: f.size}, nil
+}
+
+func (f *fakeFileSystem) ReadDir(path string) ([]os.DirEntry, error) {
+	return nil, nil
+}
+
+func (f *fakeFileSystem) ReadFile(path string) ([]byte, error) {
+	return make([]byte, f.size), nil
+}
+
+type fakeFileInfo struct {
+	size int64
+}
+
+func (i fakeFileInfo) Name() string       { return "app.tar" }
+func (i fakeFileInfo) Size() int64        { return i.size }
+func (i fakeFileInfo) Mode() os.FileMode  { return 0644 }
+func (i fakeFileInfo) ModTime() time.Time { return time.Time{} }
+func (i fakeFileInfo) IsDir() bool        { return false }
+func (i fakeFileInfo) Sys() interface{}   { return nil }
+
+// memoryHashStorage keeps step hashes in memory
+type memoryHashStorage map[string]string
+
+func (m memoryHashStorage) SaveHash(targetName, jobName string, stepIndex int, hash string) error {
+	m[fmt.Sprintf("%s/%s/%d", targetName, jobName, stepIndex)] = hash
+	return nil
+}
+
+func (m memoryHashStorage) GetHash(targetName, jobName string, stepIndex int) (string, error) {
+	return m[fmt.Sprintf("%s/%s/%d", targetName, jobName, stepIndex)], nil
+}
+
+func (m memoryHashStorage) Clear() error {
+	clear(m)
+	return nil
+}
+
+func TestRunConfigWith(t *testing.T) {
+	cfg := &Config{
+		Targets: []*Target{{Name: "test", Host: "localhost", User: "user", Password: "pass"}},
+		Jobs: []*Job{
+			{
+				Name: "deploy",
+				Steps: []*Step{
+					{Copy: &CopyStep{Local: "app.tar", Remote: "/opt/app.tar"}},
+					{Run: "tar xf /opt/app.tar"},
+				},
+			},
+		},
+	}
+
+	factory := &fakeClientFactory{}
+	fsys := &fakeFileSystem{size: 100}
+	opts := []RunOption{
+		WithClientFactory(factory),
+		WithFileSystem(fsys),
+		WithHashStorage(memoryHashStorage{}),
+		WithSkipUnchanged(true),
+	}
+
+	err := RunConfigWith(cfg, "deploy", opts...)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"copy app.tar", "tar xf /opt/app.tar"}, factory.executed)
+
+	factory.executed = nil
+	err = RunConfigWith(cfg, "deploy", opts...)
+	assert.NoError(t, err)
+	assert.Empty(t, factory.executed, "unchanged steps should be skipped")
+
+	fsys.size = 200
+	err = RunConfigWith(cfg, "deploy", opts...)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"copy app.tar", "tar xf /opt/app.tar"}, factory.executed, "changed copy step should run with the steps after it")
+
+	err = RunConfigWith(cfg, "missing", opts...)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+}
+
+func TestRunConfigWithResults(t *testing.T) {
+	cfg := &Config{
+		Targets: []*Target{{Name: "test", Host: "localhost", User: "user", Password: "pass"}},
+		Jobs:    []*Job{{Name: "deploy", Steps: []*Step{{Run: "make"}, {Run: "make install"}}}},
+	}
+
+	results, err := RunConfigWithResults(cfg, "deploy", WithClientFactory(&fakeClientFactory{}))
+	assert.NoError(t, err)
+	assert.Len(t, results, 2)
+	for i, result := range results {
+		assert.Equal(t, i, result.Index)
+		assert.Equal(t, StepSucceeded, result.Status)
+		assert.Equal(t, "test", result.Target)
+		assert.Equal(t, "deploy", result.Job)
+	}
+}